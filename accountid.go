@@ -0,0 +1,58 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+// ForAccount returns a client whose records are bound to the account ID, e.g. the user ID of the row the record is
+// stored in. The ID is hashed together with the password, so a record copied onto another account never verifies.
+// Records must be verified with the ID they were enrolled with, records enrolled without one need c itself.
+// The returned client shares keys and everything else with c, so wiping it wipes c. It's cheap enough to make per request
+func (c *Client) ForAccount(accountID []byte) *Client {
+	ac := *c
+	ac.accountID = nil
+	if len(accountID) > 0 {
+		ac.accountID = append([]byte{}, accountID...)
+	}
+	return &ac
+}
+
+// hc maps client nonce and password to hc0 or hc1, bound to the account ID if there's one
+func (c *Client) hc(domain, nc, password []byte) *Point {
+	if c.accountID == nil {
+		return c.curve.hashToPoint(domain, nc, password)
+	}
+	return c.curve.hashToPoint(domain, nc, password, c.accountID)
+}
@@ -0,0 +1,45 @@
+package phe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_ForAccount(t *testing.T) {
+	s := mustServer(t, mustKeypair(t))
+	c, err := NewClient(GenerateClientKey(), s.PublicKey())
+	assert.NoError(t, err)
+
+	login := func(c *Client, rec *EnrollmentRecord) []byte {
+		req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+		assert.NoError(t, err)
+		res, err := s.VerifyPassword(req)
+		assert.NoError(t, err)
+		key, err := c.CheckResponseAndDecrypt(pwd, rec, res)
+		assert.NoError(t, err)
+		return key
+	}
+
+	enrollment, err := s.GetEnrollment()
+	assert.NoError(t, err)
+	alice := c.ForAccount([]byte("alice"))
+	rec, key, err := alice.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	assert.Equal(t, key, login(alice, rec))
+	assert.Equal(t, key, login(c.ForAccount([]byte("alice")), rec))
+
+	// the record spliced onto another account or verified without the ID doesn't open
+	assert.Nil(t, login(c.ForAccount([]byte("mallory")), rec))
+	assert.Nil(t, login(c, rec))
+	assert.Nil(t, login(c.ForAccount(nil), rec))
+
+	// unbound records keep working
+	enrollment, err = s.GetEnrollment()
+	assert.NoError(t, err)
+	rec, key, err = c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+	assert.Equal(t, key, login(c.ForAccount(nil), rec))
+	assert.Nil(t, login(alice, rec))
+}
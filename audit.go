@@ -0,0 +1,221 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// RecordAudit summarizes what a set of enrollment records leaks beyond random nonces
+type RecordAudit struct {
+	Records int
+	// DuplicateNS and DuplicateNC count records sharing a server or client nonce with another record
+	DuplicateNS int
+	DuplicateNC int
+	// DuplicatePoints counts records sharing T0 or T1 with another record
+	DuplicatePoints int
+	// ShortNonces counts records with nonces shorter than 32 bytes which are more likely to collide.
+	// Expiring server nonces are shorter by design and not counted
+	ShortNonces int
+	// Malformed counts records which can't be parsed
+	Malformed int
+}
+
+// Clean reports whether records carry nothing that links them to each other
+func (a *RecordAudit) Clean() bool {
+	return a.DuplicateNS == 0 && a.DuplicateNC == 0 && a.DuplicatePoints == 0 && a.ShortNonces == 0 && a.Malformed == 0
+}
+
+// AuditRecords checks that no two records share a nonce or a point. Each record must be
+// an independent random-looking tuple, anything shared links accounts to each other
+func AuditRecords(records []*EnrollmentRecord) *RecordAudit {
+	a := &RecordAudit{Records: len(records)}
+	ns := make(map[string]int)
+	nc := make(map[string]int)
+	points := make(map[string]int)
+
+	for _, rec := range records {
+		if rec == nil {
+			continue
+		}
+		ns[string(rec.NS)]++
+		nc[string(rec.NC)]++
+		points[string(rec.T0)]++
+		if string(rec.T1) != string(rec.T0) {
+			points[string(rec.T1)]++
+		}
+	}
+
+	for _, rec := range records {
+		if rec == nil {
+			a.Malformed++
+			continue
+		}
+
+		curve, err := curveByPoint(rec.T0)
+		if err != nil {
+			a.Malformed++
+			continue
+		}
+		if _, _, err = rec.parse(curve); err != nil {
+			a.Malformed++
+			continue
+		}
+
+		if ns[string(rec.NS)] > 1 {
+			a.DuplicateNS++
+		}
+		if nc[string(rec.NC)] > 1 {
+			a.DuplicateNC++
+		}
+		if points[string(rec.T0)] > 1 || points[string(rec.T1)] > 1 || string(rec.T0) == string(rec.T1) {
+			a.DuplicatePoints++
+		}
+		_, expiring := nonceExpiry(rec.NS)
+		if (len(rec.NS) < 32 && !expiring) || len(rec.NC) < 32 {
+			a.ShortNonces++
+		}
+	}
+	return a
+}
+
+// LinkRecords returns how many records of a later database snapshot can be trivially joined with an earlier one
+// because they kept a nonce or a point. Server key rotation changes points only, see RerandomizeRecord
+func LinkRecords(before, after []*EnrollmentRecord) int {
+	seen := make(map[string]struct{})
+	for _, rec := range before {
+		if rec == nil {
+			continue
+		}
+		for _, v := range [][]byte{rec.NS, rec.NC, rec.T0, rec.T1} {
+			seen[string(v)] = struct{}{}
+		}
+	}
+
+	linked := 0
+	for _, rec := range after {
+		if rec == nil {
+			continue
+		}
+		for _, v := range [][]byte{rec.NS, rec.NC, rec.T0, rec.T1} {
+			if _, ok := seen[string(v)]; ok && len(v) > 0 {
+				linked++
+				break
+			}
+		}
+	}
+	return linked
+}
+
+// RerandomizeRecord replaces all values of a record with fresh ones keeping password and encryption key,
+// so that database snapshots taken before and after can't be joined.
+// It needs the password and server's response to it proving the password is correct.
+// Server nonce can only be changed with server's help, so enrollment is a fresh response from GetEnrollment;
+// if it's nil the server nonce is kept and the record stays linkable by it
+func (c *Client) RerandomizeRecord(password []byte, rec *EnrollmentRecord, resp *VerifyPasswordResponse, enrollment *EnrollmentResponse) (*EnrollmentRecord, error) {
+	newRec, key, err := c.reenroll(password, password, rec, resp, enrollment)
+	if err != nil {
+		return nil, err
+	}
+	Wipe(key)
+	return newRec, nil
+}
+
+// reenroll makes a fresh record for newPassword keeping the encryption key of rec, which password must open
+func (c *Client) reenroll(password, newPassword []byte, rec *EnrollmentRecord, resp *VerifyPasswordResponse, enrollment *EnrollmentResponse) (*EnrollmentRecord, []byte, error) {
+	m, err := c.checkResponse(context.Background(), password, rec, resp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if m == nil {
+		return nil, nil, errors.New("invalid password")
+	}
+	defer m.wipe()
+
+	if enrollment != nil {
+		c0, c1, err := c.parseEnrollment(context.Background(), enrollment)
+		if err != nil {
+			return nil, nil, err
+		}
+		newRec, err := c.newRecord(newPassword, enrollment.NS, c0, c1, m)
+		if err != nil {
+			return nil, nil, err
+		}
+		newRec.KeyVersion = rec.KeyVersion
+		if enrollment.KeyVersion != 0 {
+			newRec.KeyVersion = enrollment.KeyVersion
+		}
+		c.sealRecord(newRec)
+		return newRec, c.deriveKey(m), nil
+	}
+
+	// c0 = t0 * (hc0 ** (-y)), c1 is the one server has just returned
+	t0, err := c.curve.pointUnmarshal(rec.T0)
+	if err != nil {
+		return nil, nil, err
+	}
+	c1, err := c.curve.pointUnmarshal(resp.C1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashed, err := c.hashPassword(password, rec.NC)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer c.wipeHashed(hashed)
+	hc0 := c.hc(c.curve.dhc0, rec.NC, hashed)
+	y, release, err := c.privateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+	minusY := c.curve.sf.Neg(y)
+	defer Wipe(minusY)
+	c0 := t0.Add(hc0.ScalarMult(minusY))
+
+	newRec, err := c.newRecord(newPassword, rec.NS, c0, c1, m)
+	if err != nil {
+		return nil, nil, err
+	}
+	newRec.KeyVersion = rec.KeyVersion
+	c.sealRecord(newRec)
+	return newRec, c.deriveKey(m), nil
+}
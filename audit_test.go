@@ -0,0 +1,88 @@
+package phe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditRecords(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	s, err := NewServer(serverKeypair)
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), s.PublicKey())
+	assert.NoError(t, err)
+
+	records := make([]*EnrollmentRecord, 3)
+	for i := range records {
+		enrollment, err := s.GetEnrollment()
+		assert.NoError(t, err)
+		records[i], _, err = c.EnrollAccount(pwd, enrollment)
+		assert.NoError(t, err)
+	}
+
+	a := AuditRecords(records)
+	assert.True(t, a.Clean())
+	assert.Equal(t, 3, a.Records)
+
+	dup := *records[0]
+	dup.NC = records[1].NC
+	a = AuditRecords(append(records, &dup))
+	assert.False(t, a.Clean())
+	assert.Equal(t, 2, a.DuplicateNC)
+	assert.Equal(t, 2, a.DuplicateNS)
+	assert.Equal(t, 2, a.DuplicatePoints)
+
+	a = AuditRecords([]*EnrollmentRecord{{NS: []byte{1}, NC: []byte{2}, T0: []byte{3}, T1: []byte{4}}})
+	assert.Equal(t, 1, a.Malformed)
+}
+
+func TestClient_RerandomizeRecord(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	s, err := NewServer(serverKeypair)
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), s.PublicKey())
+	assert.NoError(t, err)
+
+	enrollment, err := s.GetEnrollment()
+	assert.NoError(t, err)
+	rec, key, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	verify := func(rec *EnrollmentRecord, password []byte) *VerifyPasswordResponse {
+		req, err := c.CreateVerifyPasswordRequest(password, rec)
+		assert.NoError(t, err)
+		resp, err := s.VerifyPassword(req)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	//keep server nonce
+	newRec, err := c.RerandomizeRecord(pwd, rec, verify(rec, pwd), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, rec.NS, newRec.NS)
+	assert.NotEqual(t, rec.NC, newRec.NC)
+	keyDec, err := c.CheckResponseAndDecrypt(pwd, newRec, verify(newRec, pwd))
+	assert.NoError(t, err)
+	assert.Equal(t, key, keyDec)
+
+	//replace everything
+	enrollment, err = s.GetEnrollment()
+	assert.NoError(t, err)
+	newRec, err = c.RerandomizeRecord(pwd, rec, verify(rec, pwd), enrollment)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, LinkRecords([]*EnrollmentRecord{rec}, []*EnrollmentRecord{newRec}))
+	keyDec, err = c.CheckResponseAndDecrypt(pwd, newRec, verify(newRec, pwd))
+	assert.NoError(t, err)
+	assert.Equal(t, key, keyDec)
+
+	//server rotation alone keeps nonces
+	token, _, err := Rotate(serverKeypair)
+	assert.NoError(t, err)
+	updRec, err := UpdateRecord(rec, token)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, LinkRecords([]*EnrollmentRecord{rec}, []*EnrollmentRecord{updRec}))
+
+	_, err = c.RerandomizeRecord([]byte("Password1"), rec, verify(rec, []byte("Password1")), nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,279 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	_ "crypto/sha256" // crypto.SHA256 for ECDSA signatures
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// AuditOp is the key operation an audit entry records
+type AuditOp string
+
+const (
+	// AuditKeyGenerated records a new server keypair
+	AuditKeyGenerated AuditOp = "keygen"
+	// AuditRotated records a server key rotation together with the update token it issued
+	AuditRotated AuditOp = "rotate"
+)
+
+var (
+	auditEntryDomain = []byte("AuditEntry")
+	auditTokenDomain = []byte("AuditToken")
+)
+
+// AuditEntry is a link of the audit log. Each entry commits to the previous one by its hash
+// and is signed, so entries can't be changed, removed or reordered without breaking the chain
+type AuditEntry struct {
+	Seq          uint64    `json:"seq"`
+	Time         time.Time `json:"time"`
+	Op           AuditOp   `json:"op"`
+	PublicKey    []byte    `json:"public_key"`
+	OldPublicKey []byte    `json:"old_public_key,omitempty"`
+	// TokenHash identifies the update token of a rotation without disclosing it
+	TokenHash []byte `json:"token_hash,omitempty"`
+	Prev      []byte `json:"prev,omitempty"`
+	Signature []byte `json:"signature"`
+}
+
+// Hash returns the hash of everything but the signature, the next entry refers to it
+func (e *AuditEntry) Hash() []byte {
+	return TupleHash([][]byte{
+		binary.BigEndian.AppendUint64(nil, e.Seq),
+		binary.BigEndian.AppendUint64(nil, uint64(e.Time.UnixNano())),
+		[]byte(e.Op),
+		e.PublicKey,
+		e.OldPublicKey,
+		e.TokenHash,
+		e.Prev,
+	}, auditEntryDomain)
+}
+
+// AuditTokenHash returns the hash an audit entry identifies an update token by
+func AuditTokenHash(token *UpdateToken) []byte {
+	return TupleHash([][]byte{token.A, token.B}, auditTokenDomain)
+}
+
+// AuditSink stores audit entries, e.g. in an append-only table or a WORM bucket
+type AuditSink interface {
+	Append(ctx context.Context, e *AuditEntry) error
+}
+
+// AuditLog signs key operations into a hash chain and hands entries over to a sink
+type AuditLog struct {
+	signer crypto.Signer
+	sink   AuditSink
+
+	mu   sync.Mutex
+	seq  uint64
+	prev []byte
+}
+
+// NewAuditLog creates a log signing entries with an ECDSA or Ed25519 key, which must not be a PHE key.
+// last is the last entry of the existing log to continue, nil starts a new one
+func NewAuditLog(signer crypto.Signer, sink AuditSink, last *AuditEntry) (*AuditLog, error) {
+	if _, err := auditHash(signer.Public()); err != nil {
+		return nil, err
+	}
+
+	l := &AuditLog{signer: signer, sink: sink}
+	if last != nil {
+		l.seq = last.Seq + 1
+		l.prev = last.Hash()
+	}
+	return l, nil
+}
+
+// RecordKeyGenerated appends an entry for a new server keypair
+func (l *AuditLog) RecordKeyGenerated(ctx context.Context, publicKey []byte) (*AuditEntry, error) {
+	return l.append(ctx, &AuditEntry{Op: AuditKeyGenerated, PublicKey: publicKey})
+}
+
+// RecordRotated appends an entry for a rotation from oldPublicKey to publicKey which issued token
+func (l *AuditLog) RecordRotated(ctx context.Context, oldPublicKey, publicKey []byte, token *UpdateToken) (*AuditEntry, error) {
+	if token == nil {
+		return nil, errors.New("invalid update token")
+	}
+	return l.append(ctx, &AuditEntry{Op: AuditRotated, PublicKey: publicKey, OldPublicKey: oldPublicKey, TokenHash: AuditTokenHash(token)})
+}
+
+// Subscribe records server key generation and rotation events of the bus. Events can't fail,
+// errors of the sink are passed to onError
+func (l *AuditLog) Subscribe(bus *EventBus, onError func(error)) (unsubscribe func()) {
+	return bus.Subscribe(func(e Event) {
+		if e.Header().Source != SourceServer {
+			return
+		}
+
+		var err error
+		switch ev := e.(type) {
+		case *KeyGeneratedEvent:
+			_, err = l.RecordKeyGenerated(context.Background(), ev.PublicKey)
+		case *RotatedEvent:
+			_, err = l.RecordRotated(context.Background(), ev.OldPublicKey, ev.PublicKey, ev.Token)
+		}
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}, EventKeyGenerated, EventRotated)
+}
+
+// append links, signs and stores the entry. The chain only advances if the sink took it
+func (l *AuditLog) append(ctx context.Context, e *AuditEntry) (*AuditEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.Seq = l.seq
+	e.Time = time.Now().UTC()
+	e.Prev = l.prev
+
+	hash, _ := auditHash(l.signer.Public())
+	digest := e.Hash()
+	if hash != 0 {
+		h := hash.New()
+		h.Write(digest)
+		digest = h.Sum(nil)
+	}
+
+	var err error
+	if e.Signature, err = l.signer.Sign(rand.Reader, digest, hash); err != nil {
+		return nil, errors.Wrap(err, "could not sign audit entry")
+	}
+	if err = l.sink.Append(ctx, e); err != nil {
+		return nil, errors.Wrap(err, "could not store audit entry")
+	}
+
+	l.seq++
+	l.prev = e.Hash()
+	return e, nil
+}
+
+// VerifyAuditLog checks signatures and links of a complete log starting with its first entry
+func VerifyAuditLog(pub crypto.PublicKey, entries []*AuditEntry) error {
+	hash, err := auditHash(pub)
+	if err != nil {
+		return err
+	}
+
+	var prev []byte
+	for i, e := range entries {
+		if e == nil || e.Seq != uint64(i) || string(e.Prev) != string(prev) {
+			return errors.Errorf("audit entry %d is out of chain", i)
+		}
+
+		digest := e.Hash()
+		valid := false
+		switch k := pub.(type) {
+		case *ecdsa.PublicKey:
+			h := hash.New()
+			h.Write(digest)
+			valid = ecdsa.VerifyASN1(k, h.Sum(nil), e.Signature)
+		case ed25519.PublicKey:
+			valid = ed25519.Verify(k, digest, e.Signature)
+		}
+		if !valid {
+			return errors.Errorf("audit entry %d has invalid signature", i)
+		}
+		prev = digest
+	}
+	return nil
+}
+
+// auditHash returns the hash ECDSA signs entry hashes with, Ed25519 signs them as is
+func auditHash(pub crypto.PublicKey) (crypto.Hash, error) {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		return crypto.SHA256, nil
+	case ed25519.PublicKey:
+		if len(k) == ed25519.PublicKeySize {
+			return 0, nil
+		}
+	}
+	return 0, errors.New("unsupported audit signing key")
+}
+
+// AuditWriter is an AuditSink writing entries as JSON lines
+type AuditWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+var _ AuditSink = (*AuditWriter)(nil)
+
+// NewAuditWriter returns a sink appending entries to w, e.g. a file opened with O_APPEND
+func NewAuditWriter(w io.Writer) *AuditWriter {
+	return &AuditWriter{w: w}
+}
+
+// Append implements AuditSink
+func (a *AuditWriter) Append(_ context.Context, e *AuditEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.w.Write(append(line, '\n'))
+	return err
+}
+
+// ReadAuditLog reads entries written by AuditWriter
+func ReadAuditLog(r io.Reader) ([]*AuditEntry, error) {
+	var entries []*AuditEntry
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		e := &AuditEntry{}
+		if err := json.Unmarshal(sc.Bytes(), e); err != nil {
+			return nil, errors.Wrapf(err, "invalid audit entry %d", len(entries))
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
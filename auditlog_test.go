@@ -0,0 +1,105 @@
+package phe
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLog(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	for _, signer := range []crypto.Signer{ecKey, edKey} {
+		var buf bytes.Buffer
+		log, err := NewAuditLog(signer, NewAuditWriter(&buf), nil)
+		assert.NoError(t, err)
+
+		bus := NewEventBus()
+		var sinkErr error
+		defer log.Subscribe(bus, func(err error) { sinkErr = err })()
+
+		serverKeypair := mustKeypair(t)
+		pub := mustPublicKey(t, serverKeypair)
+		bus.Publish(&KeyGeneratedEvent{EventHeader: EventHeader{Type: EventKeyGenerated, Source: SourceServer}, PublicKey: pub})
+		token, newKeypair, err := Rotate(serverKeypair)
+		assert.NoError(t, err)
+		bus.Publish(&RotatedEvent{
+			EventHeader:  EventHeader{Type: EventRotated, Source: SourceServer},
+			PublicKey:    mustPublicKey(t, newKeypair),
+			OldPublicKey: pub,
+			Token:        token,
+		})
+		//clients' rotations aren't key operations
+		bus.Publish(&RotatedEvent{EventHeader: EventHeader{Type: EventRotated, Source: SourceClient}, PublicKey: pub})
+		assert.NoError(t, sinkErr)
+
+		entries, err := ReadAuditLog(bytes.NewReader(buf.Bytes()))
+		assert.NoError(t, err)
+		assert.Len(t, entries, 2)
+		assert.Equal(t, AuditKeyGenerated, entries[0].Op)
+		assert.Equal(t, AuditRotated, entries[1].Op)
+		assert.Equal(t, AuditTokenHash(token), entries[1].TokenHash)
+		assert.NoError(t, VerifyAuditLog(signer.Public(), entries))
+
+		//continuing the log
+		log, err = NewAuditLog(signer, NewAuditWriter(&buf), entries[1])
+		assert.NoError(t, err)
+		_, err = log.RecordKeyGenerated(context.Background(), pub)
+		assert.NoError(t, err)
+		entries, err = ReadAuditLog(bytes.NewReader(buf.Bytes()))
+		assert.NoError(t, err)
+		assert.Len(t, entries, 3)
+		assert.NoError(t, VerifyAuditLog(signer.Public(), entries))
+
+		//tampering
+		entries[1].OldPublicKey = mustPublicKey(t, mustKeypair(t))
+		assert.Error(t, VerifyAuditLog(signer.Public(), entries))
+		entries, _ = ReadAuditLog(bytes.NewReader(buf.Bytes()))
+		assert.Error(t, VerifyAuditLog(signer.Public(), append(entries[:1], entries[2:]...)))
+		entries, _ = ReadAuditLog(bytes.NewReader(buf.Bytes()))
+		assert.Error(t, VerifyAuditLog(signer.Public(), entries[1:]))
+		assert.Error(t, VerifyAuditLog(edKey.Public().(ed25519.PublicKey)[:31], entries))
+	}
+}
+
+type failingSink struct{}
+
+func (failingSink) Append(context.Context, *AuditEntry) error {
+	return errors.New("sink is down")
+}
+
+func TestAuditLog_SinkFailure(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	log, err := NewAuditLog(edKey, failingSink{}, nil)
+	assert.NoError(t, err)
+
+	_, err = log.RecordKeyGenerated(context.Background(), mustPublicKey(t, mustKeypair(t)))
+	assert.Error(t, err)
+	assert.Equal(t, uint64(0), log.seq)
+}
+
+func TestKeyGeneratedEvent(t *testing.T) {
+	var log eventLog
+	defer DefaultEventBus.Subscribe(log.add, EventKeyGenerated)()
+
+	serverKeypair, err := GenerateServerKeypair()
+	assert.NoError(t, err)
+
+	found := false
+	for _, e := range log.events {
+		found = found || bytes.Equal(e.(*KeyGeneratedEvent).PublicKey, mustPublicKey(t, serverKeypair))
+	}
+	assert.True(t, found)
+}
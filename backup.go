@@ -0,0 +1,196 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+
+	"github.com/passw0rd/phe-go/internal/shamir"
+	"github.com/pkg/errors"
+)
+
+// A backup share of a server keypair is meant for offline storage by a custodian. Every share carries the
+// public key so recovery checks the result, and a checksum so a damaged share is named. Layout:
+// version | threshold | shares | index | public key length | public key | share value | checksum
+const (
+	keypairShareVersion  byte = 1
+	keypairShareCheckLen      = 8
+)
+
+var keypairShareInfo = []byte("KeypairShare")
+
+// SplitKeypair splits a server keypair into n backup shares any t of which recover it with RecoverKeypair.
+// Fewer than t shares reveal nothing about the private key
+func SplitKeypair(serverKeypair []byte, n, t int) ([][]byte, error) {
+	s, err := NewServer(serverKeypair)
+	if err != nil {
+		return nil, err
+	}
+
+	split, err := shamir.Split(new(big.Int).SetBytes(s.priv), n, t, s.curve.ec.Params().N, random)
+	if err != nil {
+		return nil, err
+	}
+
+	shares := make([][]byte, len(split))
+	for i, sh := range split {
+		b := []byte{keypairShareVersion, byte(t), byte(n), byte(sh.X), 0, 0}
+		binary.BigEndian.PutUint16(b[4:6], uint16(len(s.kp.PublicKey)))
+		b = append(b, s.kp.PublicKey...)
+		b = append(b, s.curve.scalarBytes(sh.Y)...)
+		shares[i] = append(b, keypairShareChecksum(b)...)
+	}
+	return shares, nil
+}
+
+// RecoverKeypair reassembles a server keypair from at least threshold backup shares made by SplitKeypair
+func RecoverKeypair(shares [][]byte) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares")
+	}
+
+	var first *keypairShare
+	points := make([]shamir.Share, len(shares))
+	for i, b := range shares {
+		sh, err := parseKeypairShare(b)
+		if err != nil {
+			return nil, errors.Wrapf(err, "share %d", i)
+		}
+		if first == nil {
+			first = sh
+		} else if sh.threshold != first.threshold || sh.count != first.count || !bytes.Equal(sh.publicKey, first.publicKey) {
+			return nil, errors.New("shares are from different splits")
+		}
+		points[i] = shamir.Share{X: sh.index, Y: sh.value}
+	}
+
+	return combineShares(first.pub, first.threshold, points)
+}
+
+// KeypairShareInfo is what a backup share tells about itself, e.g. for custodians to label it
+type KeypairShareInfo struct {
+	PublicKey []byte
+	Index     int
+	Threshold int
+	Shares    int
+}
+
+// ParseKeypairShare checks the checksum of a backup share made by SplitKeypair and returns its public part
+func ParseKeypairShare(share []byte) (*KeypairShareInfo, error) {
+	sh, err := parseKeypairShare(share)
+	if err != nil {
+		return nil, err
+	}
+	return &KeypairShareInfo{
+		PublicKey: append([]byte{}, sh.publicKey...),
+		Index:     sh.index,
+		Threshold: sh.threshold,
+		Shares:    sh.count,
+	}, nil
+}
+
+// combineShares interpolates the private key from at least threshold shares and checks it against the public key
+func combineShares(pub *Point, threshold int, points []shamir.Share) ([]byte, error) {
+	if len(points) < threshold {
+		return nil, errors.Errorf("%d shares given, %d needed", len(points), threshold)
+	}
+
+	c := pub.curve
+	secret, err := shamir.Combine(points, c.ec.Params().N)
+	if err != nil {
+		return nil, err
+	}
+
+	priv := c.scalarBytes(secret)
+	if !c.scalarBaseMultBytes(priv).Equal(pub) {
+		return nil, errors.New("recovered private key does not match the public key")
+	}
+	return marshalKeypair(pub.Marshal(), priv)
+}
+
+type keypairShare struct {
+	threshold, count, index int
+	publicKey               []byte
+	pub                     *Point
+	curve                   *Curve
+	value                   *big.Int
+}
+
+func parseKeypairShare(b []byte) (*keypairShare, error) {
+	if len(b) < 6+keypairShareCheckLen || b[0] != keypairShareVersion {
+		return nil, errors.New("invalid share")
+	}
+
+	body := b[:len(b)-keypairShareCheckLen]
+	if !bytes.Equal(keypairShareChecksum(body), b[len(body):]) {
+		return nil, errors.New("share is corrupted")
+	}
+
+	pubLen := int(binary.BigEndian.Uint16(body[4:6]))
+	if len(body) < 6+pubLen {
+		return nil, errors.New("invalid share")
+	}
+	pub, err := PointUnmarshal(body[6 : 6+pubLen])
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid share")
+	}
+
+	value := body[6+pubLen:]
+	if len(value) != pub.curve.scalarLen {
+		return nil, errors.New("invalid share")
+	}
+	y, err := pub.curve.parseScalar(value)
+	if err != nil {
+		return nil, errors.New("invalid share")
+	}
+
+	return &keypairShare{
+		threshold: int(body[1]),
+		count:     int(body[2]),
+		index:     int(body[3]),
+		publicKey: body[6 : 6+pubLen],
+		pub:       pub,
+		curve:     pub.curve,
+		value:     y,
+	}, nil
+}
+
+func keypairShareChecksum(body []byte) []byte {
+	return TupleHash([][]byte{body}, keypairShareInfo)[:keypairShareCheckLen]
+}
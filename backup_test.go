@@ -0,0 +1,63 @@
+package phe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitKeypair(t *testing.T) {
+	for _, curve := range curves {
+		serverKeypair, err := GenerateServerKeypairForCurve(curve)
+		assert.NoError(t, err)
+
+		shares, err := SplitKeypair(serverKeypair, 5, 3)
+		assert.NoError(t, err)
+		assert.Len(t, shares, 5)
+
+		for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+			picked := make([][]byte, len(subset))
+			for i, idx := range subset {
+				picked[i] = shares[idx]
+			}
+			kp, err := RecoverKeypair(picked)
+			assert.NoError(t, err)
+			assert.Equal(t, serverKeypair, kp)
+		}
+
+		_, err = RecoverKeypair(shares[:2])
+		assert.Error(t, err)
+
+		pub, err := GetPublicKey(serverKeypair)
+		assert.NoError(t, err)
+		info, err := ParseKeypairShare(shares[3])
+		assert.NoError(t, err)
+		assert.Equal(t, &KeypairShareInfo{PublicKey: pub, Index: 4, Threshold: 3, Shares: 5}, info)
+	}
+}
+
+func TestRecoverKeypair_Invalid(t *testing.T) {
+	shares, err := SplitKeypair(mustKeypair(t), 3, 2)
+	assert.NoError(t, err)
+	other, err := SplitKeypair(mustKeypair(t), 3, 2)
+	assert.NoError(t, err)
+
+	_, err = RecoverKeypair([][]byte{shares[0], other[1]})
+	assert.Error(t, err)
+
+	damaged := append([]byte{}, shares[1]...)
+	damaged[len(damaged)/2] ^= 1
+	_, err = RecoverKeypair([][]byte{shares[0], damaged})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "share 1")
+	}
+	_, err = ParseKeypairShare(damaged)
+	assert.Error(t, err)
+
+	_, err = RecoverKeypair([][]byte{shares[0], shares[0]})
+	assert.Error(t, err)
+	_, err = RecoverKeypair(nil)
+	assert.Error(t, err)
+	_, err = SplitKeypair(mustKeypair(t), 2, 3)
+	assert.Error(t, err)
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// batchWeightLen is the length of random weights in bytes, forging a batch has probability 2^-128
+const batchWeightLen = 16
+
+// SuccessStatement is a single proof of success together with the server values it proves,
+// e.g. taken from an EnrollmentResponse
+type SuccessStatement struct {
+	NS    []byte
+	C0    []byte
+	C1    []byte
+	Proof *ProofOfSuccess
+}
+
+// VerifyProofsOfSuccess checks many proofs of success at once by verifying a random linear combination of
+// all their equations with a single multi-scalar multiplication. If the batch doesn't verify, proofs are checked
+// one by one to report the first invalid one.
+// Every curve has a multi-scalar multiplication routine, on P-256 a batch of 32 proofs verifies about 1.6 times
+// as fast as one by one, see BenchmarkClient_VerifyProofsOfSuccess_P256
+func (c *Client) VerifyProofsOfSuccess(statements []*SuccessStatement) error {
+	return c.VerifyProofsOfSuccessContext(context.Background(), statements, WithWorkers(1))
+}
+
+// VerifyProofsOfSuccessContext is like VerifyProofsOfSuccess but splits statements into one batch per worker.
+// Verification stops at the first invalid proof or when ctx is cancelled
+func (c *Client) VerifyProofsOfSuccessContext(ctx context.Context, statements []*SuccessStatement, opts ...BulkOption) error {
+	cfg := newBulkConfig(opts)
+	size := (len(statements) + cfg.workers - 1) / cfg.workers
+	if size == 0 {
+		return ctx.Err()
+	}
+
+	return runBulk(ctx, (len(statements)+size-1)/size, cfg, func(ctx context.Context, k int) error {
+		from, to := k*size, (k+1)*size
+		if to > len(statements) {
+			to = len(statements)
+		}
+
+		if c.curve.msm != nil && to-from > 1 && c.verifyBatch(statements[from:to]) {
+			return nil
+		}
+
+		for i := from; i < to; i++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := c.verifyStatement(statements[i]); err != nil {
+				return errors.Errorf("invalid proof %d", i)
+			}
+		}
+		return nil
+	})
+}
+
+func (c *Client) verifyStatement(st *SuccessStatement) error {
+	if st == nil {
+		return errors.New("invalid proof")
+	}
+
+	c0, err := c.curve.pointUnmarshal(st.C0)
+	if err != nil {
+		return err
+	}
+
+	c1, err := c.curve.pointUnmarshal(st.C1)
+	if err != nil {
+		return err
+	}
+
+	return c.validateProofOfSuccess(nil, st.Proof, st.NS, c0, c1, st.C0, st.C1)
+}
+
+// verifyBatch checks that Σ ρ * (point ** blind_x - c ** challenge - term) is zero for all three equations of every proof.
+// Terms with the generator and server's public key are merged into one each
+func (c *Client) verifyBatch(statements []*SuccessStatement) bool {
+	gf := c.curve.gf
+	points := make([]*Point, 0, 7*len(statements)+2)
+	scalars := make([]*big.Int, 0, 7*len(statements)+2)
+	gScalar, xScalar := new(big.Int), new(big.Int)
+
+	for _, st := range statements {
+		if st == nil {
+			return false
+		}
+
+		c0, err := c.curve.pointUnmarshal(st.C0)
+		if err != nil {
+			return false
+		}
+		c1, err := c.curve.pointUnmarshal(st.C1)
+		if err != nil {
+			return false
+		}
+		term1, term2, term3, blindX, err := st.Proof.parse(c.curve)
+		if err != nil {
+			return false
+		}
+
+		hs0 := c.curve.hashToPoint(c.curve.dhs0, st.NS)
+		hs1 := c.curve.hashToPoint(c.curve.dhs1, st.NS)
+		challenge := c.curve.hashZ(c.curve.proofOk, c.serverPublicKeyBytes, c.curve.gBytes, st.C0, st.C1, st.Proof.Term1, st.Proof.Term2, st.Proof.Term3)
+
+		for _, eq := range [][3]*Point{{hs0, c0, term1}, {hs1, c1, term2}} {
+			rho := c.batchWeight()
+			points = append(points, eq[0], eq[1], eq[2])
+			scalars = append(scalars, gf.Mul(rho, blindX), gf.Neg(gf.Mul(rho, challenge)), gf.Neg(rho))
+		}
+
+		rho := c.batchWeight()
+		points = append(points, term3)
+		scalars = append(scalars, gf.Neg(rho))
+		gScalar = gf.Add(gScalar, gf.Mul(rho, blindX))
+		xScalar = gf.Sub(xScalar, gf.Mul(rho, challenge))
+	}
+
+	points = append(points, c.curve.g, c.serverPublicKey)
+	scalars = append(scalars, gScalar, xScalar)
+
+	sum := c.curve.multiScalarMult(points, scalars)
+	return isIdentity(sum)
+}
+
+func (c *Client) batchWeight() *big.Int {
+	buf := make([]byte, batchWeightLen)
+	random.MustRead(buf)
+	return new(big.Int).SetBytes(buf)
+}
@@ -0,0 +1,65 @@
+package phe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeStatements(t testing.TB, curve *Curve, n int) (*Client, []*SuccessStatement) {
+	kp, err := GenerateServerKeypairForCurve(curve)
+	assert.NoError(t, err)
+	s, err := NewServer(kp)
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKeyForCurve(curve), s.PublicKey())
+	assert.NoError(t, err)
+
+	statements := make([]*SuccessStatement, n)
+	for i := range statements {
+		resp, err := s.GetEnrollment()
+		assert.NoError(t, err)
+		statements[i] = &SuccessStatement{NS: resp.NS, C0: resp.C0, C1: resp.C1, Proof: resp.Proof}
+	}
+	return c, statements
+}
+
+func TestClient_VerifyProofsOfSuccess(t *testing.T) {
+	for _, curve := range curves {
+		c, statements := makeStatements(t, curve, 5)
+		assert.True(t, c.verifyBatch(statements), curve.Name())
+		assert.NoError(t, c.VerifyProofsOfSuccess(statements))
+
+		statements[3].Proof.BlindX, statements[4].Proof.BlindX = statements[4].Proof.BlindX, statements[3].Proof.BlindX
+		assert.False(t, c.verifyBatch(statements), curve.Name())
+		err := c.VerifyProofsOfSuccess(statements)
+		assert.EqualError(t, err, "invalid proof 3")
+
+		statements[3].C1 = statements[2].C1
+		assert.Error(t, c.VerifyProofsOfSuccess(statements))
+	}
+}
+
+func BenchmarkClient_VerifyProofsOfSuccess_P256(b *testing.B) {
+	benchmarkVerifyProofsOfSuccess(b, P256())
+}
+
+func BenchmarkClient_VerifyProofsOfSuccess_Secp256k1(b *testing.B) {
+	benchmarkVerifyProofsOfSuccess(b, Secp256k1())
+}
+
+func benchmarkVerifyProofsOfSuccess(b *testing.B, curve *Curve) {
+	c, statements := makeStatements(b, curve, 32)
+
+	b.Run("batch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			c.VerifyProofsOfSuccess(statements)
+		}
+	})
+	b.Run("one by one", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, st := range statements {
+				c.verifyStatement(st)
+			}
+		}
+	})
+}
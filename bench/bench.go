@@ -0,0 +1,335 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+// Package bench generates realistic PHE workloads against an in-process or remote server
+// and reports latency percentiles for capacity planning
+package bench
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/pkg/errors"
+)
+
+// Operation names used in reports
+const (
+	OpEnroll = "enroll"
+	OpVerify = "verify"
+	OpRotate = "rotate"
+	OpUpdate = "update"
+)
+
+// Backend is the server side of the protocol a workload runs against.
+// NewLocal runs it in-process, remote endpoints are benchmarked by implementing Backend on top of their client
+type Backend interface {
+	PublicKey(ctx context.Context) ([]byte, error)
+	GetEnrollment(ctx context.Context) (*phe.EnrollmentResponse, error)
+	VerifyPassword(ctx context.Context, req *phe.VerifyPasswordRequest) (*phe.VerifyPasswordResponse, error)
+	// Rotate rotates server keypair and returns the update token for clients and records
+	Rotate(ctx context.Context) (*phe.UpdateToken, error)
+}
+
+// Workload describes the mix of operations to run
+type Workload struct {
+	// Accounts are enrolled before measurement starts so that verifications have records to work with
+	Accounts int
+	// Operations is the number of measured enrollments and verifications
+	Operations int
+	// Concurrency is the number of operations in flight, 1 by default
+	Concurrency int
+	// EnrollRatio is the share of operations which are enrollments, the rest are verifications
+	EnrollRatio float64
+	// WrongPasswordRatio is the share of verifications made with a wrong password
+	WrongPasswordRatio float64
+	// RotateEvery simulates rotation storms: after every RotateEvery operations server keys are rotated
+	// and all records are updated. Zero disables rotation
+	RotateEvery int
+	// Seed makes the sequence of operations reproducible
+	Seed int64
+}
+
+type account struct {
+	password []byte
+	rec      *phe.EnrollmentRecord
+}
+
+type op struct {
+	enroll  bool
+	wrong   bool
+	account int
+}
+
+type runner struct {
+	b      Backend
+	client *phe.Client
+	rnd    *rand.Rand
+	rec    *recorder
+
+	mu       sync.RWMutex
+	accounts []*account
+}
+
+// Run executes the workload against the backend and reports latencies of every kind of operation.
+// Failed operations are counted in the report, Run itself fails only if the workload can't be set up
+// or ctx is cancelled
+func Run(ctx context.Context, b Backend, w Workload) (*Report, error) {
+	if w.Concurrency <= 0 {
+		w.Concurrency = 1
+	}
+
+	pub, err := b.PublicKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	point, err := phe.PointUnmarshal(pub)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid public key")
+	}
+
+	client, err := phe.NewClient(phe.GenerateClientKeyForCurve(point.Curve()), pub)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &runner{
+		b:      b,
+		client: client,
+		rnd:    rand.New(rand.NewSource(w.Seed)),
+		rec:    newRecorder(),
+	}
+
+	for i := 0; i < w.Accounts; i++ {
+		if err := r.enroll(ctx); err != nil {
+			return nil, errors.Wrap(err, "could not enroll accounts")
+		}
+	}
+	r.rec = newRecorder()
+
+	start := time.Now()
+	for done := 0; done < w.Operations; {
+		n := w.Operations - done
+		if w.RotateEvery > 0 && n > w.RotateEvery {
+			n = w.RotateEvery
+		}
+
+		if err := r.runOps(ctx, r.plan(n, w), w.Concurrency); err != nil {
+			return nil, err
+		}
+		done += n
+
+		if w.RotateEvery > 0 && n == w.RotateEvery {
+			if err := r.rotate(ctx, w.Concurrency); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return r.rec.report(time.Since(start)), nil
+}
+
+// plan draws the next n operations, accounts are picked at execution time as enrollments add new ones
+func (r *runner) plan(n int, w Workload) []op {
+	ops := make([]op, n)
+	for i := range ops {
+		ops[i] = op{
+			enroll:  r.rnd.Float64() < w.EnrollRatio,
+			wrong:   r.rnd.Float64() < w.WrongPasswordRatio,
+			account: r.rnd.Int(),
+		}
+	}
+	return ops
+}
+
+func (r *runner) runOps(ctx context.Context, ops []op, concurrency int) error {
+	jobs := make(chan op)
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for o := range jobs {
+				r.do(ctx, o)
+			}
+		}()
+	}
+
+	for _, o := range ops {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- o
+	}
+	close(jobs)
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+func (r *runner) do(ctx context.Context, o op) {
+	r.mu.RLock()
+	n := len(r.accounts)
+	r.mu.RUnlock()
+
+	if o.enroll || n == 0 {
+		r.enroll(ctx)
+		return
+	}
+
+	r.mu.RLock()
+	acc := r.accounts[o.account%n]
+	r.mu.RUnlock()
+
+	start := time.Now()
+	err := r.verify(ctx, acc, o.wrong)
+	r.rec.add(OpVerify, time.Since(start), err)
+}
+
+func (r *runner) enroll(ctx context.Context) error {
+	password := make([]byte, 16)
+	r.mu.Lock()
+	r.rnd.Read(password)
+	r.mu.Unlock()
+
+	start := time.Now()
+	resp, err := r.b.GetEnrollment(ctx)
+	var rec *phe.EnrollmentRecord
+	if err == nil {
+		rec, _, err = r.client.EnrollAccount(password, resp)
+	}
+	r.rec.add(OpEnroll, time.Since(start), err)
+
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.accounts = append(r.accounts, &account{password: password, rec: rec})
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *runner) verify(ctx context.Context, acc *account, wrong bool) error {
+	password := acc.password
+	if wrong {
+		password = append([]byte("wrong "), password...)
+	}
+
+	r.mu.RLock()
+	rec := acc.rec
+	r.mu.RUnlock()
+
+	req, err := r.client.CreateVerifyPasswordRequest(password, rec)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.b.VerifyPassword(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if _, err = r.client.CheckResponseAndDecrypt(password, rec, resp); err != nil {
+		return err
+	}
+
+	if resp.Res == wrong {
+		return errors.New("unexpected verification result")
+	}
+	return nil
+}
+
+// rotate rotates server keys, then the client's ones and updates every record
+func (r *runner) rotate(ctx context.Context, concurrency int) error {
+	start := time.Now()
+	token, err := r.b.Rotate(ctx)
+	var pub []byte
+	if err == nil {
+		pub, err = r.b.PublicKey(ctx)
+	}
+	var client *phe.Client
+	if err == nil {
+		client, err = r.client.RotateNew(token, pub)
+	}
+	r.rec.add(OpRotate, time.Since(start), err)
+	if err != nil {
+		return errors.Wrap(err, "could not rotate keys")
+	}
+	r.client = client
+
+	r.mu.RLock()
+	accounts := append([]*account{}, r.accounts...)
+	r.mu.RUnlock()
+
+	jobs := make(chan *account)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for acc := range jobs {
+				start := time.Now()
+				r.mu.RLock()
+				rec := acc.rec
+				r.mu.RUnlock()
+
+				updated, err := phe.UpdateRecord(rec, token)
+				r.rec.add(OpUpdate, time.Since(start), err)
+				if err == nil {
+					r.mu.Lock()
+					acc.rec = updated
+					r.mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, acc := range accounts {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- acc
+	}
+	close(jobs)
+	wg.Wait()
+
+	return ctx.Err()
+}
@@ -0,0 +1,50 @@
+package bench
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	kp, err := phe.GenerateServerKeypair()
+	assert.NoError(t, err)
+	b, err := NewLocal(kp)
+	assert.NoError(t, err)
+
+	report, err := Run(context.Background(), b, Workload{
+		Accounts:           2,
+		Operations:         12,
+		Concurrency:        3,
+		EnrollRatio:        0.3,
+		WrongPasswordRatio: 0.5,
+		RotateEvery:        5,
+		Seed:               1,
+	})
+	assert.NoError(t, err)
+
+	total := 0
+	for name, s := range report.Ops {
+		assert.Zero(t, s.Errors, name)
+		assert.True(t, s.Min <= s.P50 && s.P50 <= s.P90 && s.P90 <= s.P99 && s.P99 <= s.Max)
+		if name == OpEnroll || name == OpVerify {
+			total += s.Count
+		}
+	}
+	assert.Equal(t, 12, total)
+	assert.Equal(t, 2, report.Ops[OpRotate].Count)
+	assert.Contains(t, report.String(), OpUpdate)
+}
+
+func TestPercentile(t *testing.T) {
+	l := make([]time.Duration, 100)
+	for i := range l {
+		l[i] = time.Duration(i + 1)
+	}
+	assert.Equal(t, time.Duration(50), percentile(l, 50))
+	assert.Equal(t, time.Duration(99), percentile(l, 99))
+	assert.Equal(t, time.Duration(1), percentile(l[:1], 99))
+}
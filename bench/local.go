@@ -0,0 +1,96 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package bench
+
+import (
+	"context"
+	"sync"
+
+	"github.com/passw0rd/phe-go"
+)
+
+// local runs the server in-process, Rotate swaps the keypair under a lock
+type local struct {
+	mu sync.RWMutex
+	kp []byte
+	s  *phe.Server
+}
+
+// NewLocal returns a Backend running phe.Server in-process with the given keypair
+func NewLocal(serverKeypair []byte) (Backend, error) {
+	s, err := phe.NewServer(serverKeypair)
+	if err != nil {
+		return nil, err
+	}
+	return &local{kp: serverKeypair, s: s}, nil
+}
+
+func (l *local) server() *phe.Server {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.s
+}
+
+func (l *local) PublicKey(ctx context.Context) ([]byte, error) {
+	return l.server().PublicKey(), nil
+}
+
+func (l *local) GetEnrollment(ctx context.Context) (*phe.EnrollmentResponse, error) {
+	return l.server().GetEnrollment()
+}
+
+func (l *local) VerifyPassword(ctx context.Context, req *phe.VerifyPasswordRequest) (*phe.VerifyPasswordResponse, error) {
+	return l.server().VerifyPassword(req)
+}
+
+func (l *local) Rotate(ctx context.Context) (*phe.UpdateToken, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	token, kp, err := phe.Rotate(l.kp)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := phe.NewServer(kp)
+	if err != nil {
+		return nil, err
+	}
+
+	l.kp, l.s = kp, s
+	return token, nil
+}
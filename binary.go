@@ -0,0 +1,427 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// Binary encoding of message types:
+//
+//	version (1) | message type (1) | fields
+//
+// Byte fields are prefixed with their 2-byte big-endian length, booleans take one byte and nested proofs
+// are length-prefixed binary messages of their own. A non-zero key version is appended as a 4-byte field. Only well-formed messages are encoded and decoding
+// is as strict as for JSON. Update token metadata, if any, follows the scalars as six fields. A record MAC comes last, after an empty key version field if the version is 0
+const binaryVersion = 1
+
+const (
+	binaryRecord byte = iota + 1
+	binaryEnrollmentResponse
+	binaryVerifyRequest
+	binaryVerifyResponse
+	binaryProofOfSuccess
+	binaryProofOfFail
+	binaryUpdateToken
+	binaryClientState
+)
+
+// binaryWriter appends fields of a message
+type binaryWriter []byte
+
+func newBinaryWriter(msgType byte) binaryWriter {
+	return binaryWriter{binaryVersion, msgType}
+}
+
+func (w *binaryWriter) bytes(fields ...[]byte) {
+	for _, f := range fields {
+		*w = binary.BigEndian.AppendUint16(*w, uint16(len(f)))
+		*w = append(*w, f...)
+	}
+}
+
+// keyVersion appends a key version unless it's 0
+func (w *binaryWriter) keyVersion(v uint32) {
+	if v != 0 {
+		w.bytes(binary.BigEndian.AppendUint32(nil, v))
+	}
+}
+
+func (w *binaryWriter) bool(v bool) {
+	if v {
+		*w = append(*w, 1)
+	} else {
+		*w = append(*w, 0)
+	}
+}
+
+// binaryReader reads fields of a message, the first error sticks and zero values are returned after it
+type binaryReader struct {
+	data []byte
+	err  error
+}
+
+func newBinaryReader(data []byte, msgType byte) *binaryReader {
+	r := &binaryReader{data: data}
+	if len(data) < 2 || data[0] != binaryVersion || data[1] != msgType {
+		r.err = errors.New("unexpected message version or type")
+	} else {
+		r.data = data[2:]
+	}
+	return r
+}
+
+func (r *binaryReader) bytes() []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data) < 2 {
+		r.err = errors.New("truncated message")
+		return nil
+	}
+	n := int(binary.BigEndian.Uint16(r.data))
+	if len(r.data) < 2+n {
+		r.err = errors.New("truncated message")
+		return nil
+	}
+	f := append([]byte{}, r.data[2:2+n]...)
+	r.data = r.data[2+n:]
+	return f
+}
+
+func (r *binaryReader) bool() bool {
+	if r.err != nil {
+		return false
+	}
+	if len(r.data) < 1 || r.data[0] > 1 {
+		r.err = errors.New("invalid boolean")
+		return false
+	}
+	v := r.data[0] == 1
+	r.data = r.data[1:]
+	return v
+}
+
+// keyVersion reads an optional trailing key version
+func (r *binaryReader) keyVersion() uint32 {
+	if r.err != nil || len(r.data) == 0 {
+		return 0
+	}
+	f := r.bytes()
+	if r.err == nil && len(f) == 0 && len(r.data) != 0 {
+		// an empty version only keeps the place of the fields after it
+		return 0
+	}
+	if r.err == nil && (len(f) != 4 || binary.BigEndian.Uint32(f) == 0) {
+		r.err = errors.New("invalid key version")
+	}
+	if r.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(f)
+}
+
+// done returns the first error or an error if there's unread data
+func (r *binaryReader) done() error {
+	if r.err == nil && len(r.data) != 0 {
+		r.err = errors.New("unexpected data after message")
+	}
+	return r.err
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler
+func (c *EnrollmentRecord) MarshalBinary() ([]byte, error) {
+	if !c.wellFormed() {
+		return nil, errors.New("invalid record")
+	}
+
+	w := newBinaryWriter(binaryRecord)
+	w.bytes(c.NS, c.NC, c.T0, c.T1)
+	w.keyVersion(c.KeyVersion)
+	if c.MAC != nil {
+		if c.KeyVersion == 0 {
+			w.bytes(nil)
+		}
+		w.bytes(c.MAC)
+	}
+	return w, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler
+func (c *EnrollmentRecord) UnmarshalBinary(data []byte) error {
+	r := newBinaryReader(data, binaryRecord)
+	v := EnrollmentRecord{NS: r.bytes(), NC: r.bytes(), T0: r.bytes(), T1: r.bytes(), KeyVersion: r.keyVersion()}
+	if r.err == nil && len(r.data) != 0 {
+		v.MAC = r.bytes()
+	}
+	if err := r.done(); err != nil {
+		return errors.Wrap(err, "invalid record")
+	}
+	if !v.wellFormed() {
+		return errors.New("invalid record")
+	}
+	*c = v
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler
+func (p *ProofOfSuccess) MarshalBinary() ([]byte, error) {
+	if !p.wellFormed() {
+		return nil, errors.New("invalid proof")
+	}
+
+	w := newBinaryWriter(binaryProofOfSuccess)
+	w.bytes(p.Term1, p.Term2, p.Term3, p.BlindX)
+	return w, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler
+func (p *ProofOfSuccess) UnmarshalBinary(data []byte) error {
+	r := newBinaryReader(data, binaryProofOfSuccess)
+	v := ProofOfSuccess{Term1: r.bytes(), Term2: r.bytes(), Term3: r.bytes(), BlindX: r.bytes()}
+	if err := r.done(); err != nil {
+		return errors.Wrap(err, "invalid proof")
+	}
+	if !v.wellFormed() {
+		return errors.New("invalid proof")
+	}
+	*p = v
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler
+func (p *ProofOfFail) MarshalBinary() ([]byte, error) {
+	if !p.wellFormed() {
+		return nil, errors.New("invalid proof")
+	}
+
+	w := newBinaryWriter(binaryProofOfFail)
+	w.bytes(p.Term1, p.Term2, p.Term3, p.Term4, p.BlindA, p.BlindB)
+	return w, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler
+func (p *ProofOfFail) UnmarshalBinary(data []byte) error {
+	r := newBinaryReader(data, binaryProofOfFail)
+	v := ProofOfFail{Term1: r.bytes(), Term2: r.bytes(), Term3: r.bytes(), Term4: r.bytes(), BlindA: r.bytes(), BlindB: r.bytes()}
+	if err := r.done(); err != nil {
+		return errors.Wrap(err, "invalid proof")
+	}
+	if !v.wellFormed() {
+		return errors.New("invalid proof")
+	}
+	*p = v
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler
+func (t *UpdateToken) MarshalBinary() ([]byte, error) {
+	if !t.wellFormed() {
+		return nil, errors.New("invalid update token")
+	}
+
+	w := newBinaryWriter(binaryUpdateToken)
+	w.bytes(t.A, t.B)
+	if t.hasMetadata() {
+		w.bytes(binary.BigEndian.AppendUint32(nil, t.FromVersion), binary.BigEndian.AppendUint32(nil, t.ToVersion),
+			[]byte(t.FromKey), []byte(t.ToKey),
+			binary.BigEndian.AppendUint64(nil, uint64(t.IssuedAt)), binary.BigEndian.AppendUint64(nil, uint64(t.ExpiresAt)))
+	}
+	return w, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler
+func (t *UpdateToken) UnmarshalBinary(data []byte) error {
+	r := newBinaryReader(data, binaryUpdateToken)
+	v := UpdateToken{A: r.bytes(), B: r.bytes()}
+	if r.err == nil && len(r.data) != 0 {
+		fromVersion, toVersion, fromKey, toKey, issuedAt, expiresAt := r.bytes(), r.bytes(), r.bytes(), r.bytes(), r.bytes(), r.bytes()
+		if r.err == nil && (len(fromVersion) != 4 || len(toVersion) != 4 || len(issuedAt) != 8 || len(expiresAt) != 8) {
+			r.err = errors.New("invalid token metadata")
+		}
+		if r.err == nil {
+			v.FromVersion, v.ToVersion = binary.BigEndian.Uint32(fromVersion), binary.BigEndian.Uint32(toVersion)
+			v.FromKey, v.ToKey = string(fromKey), string(toKey)
+			v.IssuedAt, v.ExpiresAt = int64(binary.BigEndian.Uint64(issuedAt)), int64(binary.BigEndian.Uint64(expiresAt))
+			if !v.hasMetadata() {
+				r.err = errors.New("empty token metadata")
+			}
+		}
+	}
+	if err := r.done(); err != nil {
+		return errors.Wrap(err, "invalid update token")
+	}
+	if !v.wellFormed() {
+		return errors.New("invalid update token")
+	}
+	*t = v
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler
+func (r *EnrollmentResponse) MarshalBinary() ([]byte, error) {
+	if !r.wellFormed() {
+		return nil, errors.New("invalid enrollment response")
+	}
+	var proof []byte
+	if !r.NoProof {
+		var err error
+		if proof, err = r.Proof.MarshalBinary(); err != nil {
+			return nil, err
+		}
+	}
+
+	w := newBinaryWriter(binaryEnrollmentResponse)
+	w.bytes(r.NS, r.C0, r.C1, proof)
+	w.keyVersion(r.KeyVersion)
+	return w, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler
+func (r *EnrollmentResponse) UnmarshalBinary(data []byte) error {
+	br := newBinaryReader(data, binaryEnrollmentResponse)
+	v := EnrollmentResponse{NS: br.bytes(), C0: br.bytes(), C1: br.bytes()}
+	proof := br.bytes()
+	v.KeyVersion = br.keyVersion()
+	if err := br.done(); err != nil {
+		return errors.Wrap(err, "invalid enrollment response")
+	}
+	if v.NoProof = len(proof) == 0; !v.NoProof {
+		v.Proof = &ProofOfSuccess{}
+		if err := v.Proof.UnmarshalBinary(proof); err != nil {
+			return err
+		}
+	}
+	if !v.wellFormed() {
+		return errors.New("invalid enrollment response")
+	}
+	*r = v
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler
+func (r *VerifyPasswordRequest) MarshalBinary() ([]byte, error) {
+	if !r.wellFormed() {
+		return nil, errors.New("invalid password verify request")
+	}
+
+	w := newBinaryWriter(binaryVerifyRequest)
+	w.bytes(r.NS, r.C0)
+	w.keyVersion(r.KeyVersion)
+	if r.Nonce != nil {
+		if r.KeyVersion == 0 {
+			w.bytes(nil)
+		}
+		w.bytes(r.Nonce)
+	}
+	return w, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler
+func (r *VerifyPasswordRequest) UnmarshalBinary(data []byte) error {
+	br := newBinaryReader(data, binaryVerifyRequest)
+	v := VerifyPasswordRequest{NS: br.bytes(), C0: br.bytes(), KeyVersion: br.keyVersion()}
+	if br.err == nil && len(br.data) != 0 {
+		v.Nonce = br.bytes()
+	}
+	if err := br.done(); err != nil {
+		return errors.Wrap(err, "invalid password verify request")
+	}
+	if !v.wellFormed() {
+		return errors.New("invalid password verify request")
+	}
+	*r = v
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler. The proof matching the result is the last field,
+// it's empty if NoProof is set
+func (r *VerifyPasswordResponse) MarshalBinary() ([]byte, error) {
+	if !r.wellFormed() {
+		return nil, errors.New("invalid password verify response")
+	}
+
+	var proof []byte
+	var err error
+	switch {
+	case r.NoProof:
+	case r.Res:
+		proof, err = r.ProofSuccess.MarshalBinary()
+	default:
+		proof, err = r.ProofFail.MarshalBinary()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	w := newBinaryWriter(binaryVerifyResponse)
+	w.bool(r.Res)
+	w.bool(r.Expired)
+	w.bytes(r.C1, proof)
+	return w, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler
+func (r *VerifyPasswordResponse) UnmarshalBinary(data []byte) error {
+	br := newBinaryReader(data, binaryVerifyResponse)
+	v := VerifyPasswordResponse{Res: br.bool(), Expired: br.bool(), C1: br.bytes()}
+	proof := br.bytes()
+	if err := br.done(); err != nil {
+		return errors.Wrap(err, "invalid password verify response")
+	}
+
+	var err error
+	switch v.NoProof = len(proof) == 0; {
+	case v.NoProof:
+	case v.Res:
+		v.ProofSuccess = &ProofOfSuccess{}
+		err = v.ProofSuccess.UnmarshalBinary(proof)
+	default:
+		v.ProofFail = &ProofOfFail{}
+		err = v.ProofFail.UnmarshalBinary(proof)
+	}
+	if err != nil {
+		return err
+	}
+	if !v.wellFormed() {
+		return errors.New("invalid password verify response")
+	}
+	*r = v
+	return nil
+}
@@ -0,0 +1,86 @@
+package phe
+
+import (
+	"encoding"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBinary_RoundTrip(t *testing.T) {
+	s, err := NewServer(mustKeypair(t))
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), s.PublicKey())
+	assert.NoError(t, err)
+
+	enrollment, err := s.GetEnrollment()
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+	token, _, err := Rotate(mustKeypair(t))
+	assert.NoError(t, err)
+
+	pairs := [][2]interface{}{
+		{enrollment, &EnrollmentResponse{}},
+		{rec, &EnrollmentRecord{}},
+		{token, &UpdateToken{}},
+		{enrollment.Proof, &ProofOfSuccess{}},
+	}
+	for _, p := range [][]byte{pwd, []byte("wrong")} {
+		req, err := c.CreateVerifyPasswordRequest(p, rec)
+		assert.NoError(t, err)
+		resp, err := s.VerifyPassword(req)
+		assert.NoError(t, err)
+		pairs = append(pairs, [2]interface{}{&VerifyPasswordRequest{NS: req.NS, C0: req.C0}, &VerifyPasswordRequest{}}, [2]interface{}{resp, &VerifyPasswordResponse{}})
+		if resp.ProofFail != nil {
+			pairs = append(pairs, [2]interface{}{resp.ProofFail, &ProofOfFail{}})
+		}
+	}
+
+	for _, p := range pairs {
+		data, err := p[0].(encoding.BinaryMarshaler).MarshalBinary()
+		assert.NoError(t, err)
+		dec := p[1].(encoding.BinaryUnmarshaler)
+		assert.NoError(t, dec.UnmarshalBinary(data))
+		assert.Equal(t, p[0], dec)
+
+		assert.Error(t, dec.UnmarshalBinary(append(data, 0)))
+		assert.Error(t, dec.UnmarshalBinary(data[:len(data)-1]))
+		other := append([]byte{}, data...)
+		other[1]++
+		assert.Error(t, dec.UnmarshalBinary(other))
+	}
+
+	data, err := rec.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{binaryVersion, binaryRecord, 0, 32}, data[:4])
+	assert.Len(t, data, 2+4*2+32+32+65+65)
+}
+
+func TestBinary_Invalid(t *testing.T) {
+	_, err := (&EnrollmentRecord{NS: []byte("ns")}).MarshalBinary()
+	assert.Error(t, err)
+
+	_, err = (&VerifyPasswordResponse{Res: true, C1: make([]byte, 65)}).MarshalBinary()
+	assert.Error(t, err)
+
+	//result doesn't match the proof
+	s, err := NewServer(mustKeypair(t))
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), s.PublicKey())
+	assert.NoError(t, err)
+	enrollment, err := s.GetEnrollment()
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+	req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	resp, err := s.VerifyPassword(req)
+	assert.NoError(t, err)
+	data, err := resp.MarshalBinary()
+	assert.NoError(t, err)
+	data[2] = 0
+	assert.Error(t, (&VerifyPasswordResponse{}).UnmarshalBinary(data))
+	data[2] = 2
+	assert.Error(t, (&VerifyPasswordResponse{}).UnmarshalBinary(data))
+}
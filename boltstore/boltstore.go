@@ -0,0 +1,223 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+// Package boltstore keeps enrollment records in an embedded bbolt database so PHE can be used
+// without an external database. Every tenant gets its own bucket, records are stored in the compact encoding
+package boltstore
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// iteratePage is the number of records Iterate reads within a single transaction
+const iteratePage = 500
+
+// DB is an embedded records database
+type DB struct {
+	db *bolt.DB
+}
+
+// Open opens or creates the database file
+func Open(path string) (*DB, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{db: db}, nil
+}
+
+// Close closes the database file
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// Tenant returns the record store of a tenant, creating its bucket if needed
+func (d *DB) Tenant(name string) (*Store, error) {
+	if len(name) == 0 {
+		return nil, errors.New("invalid tenant name")
+	}
+
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(name))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: d.db, bucket: []byte(name)}, nil
+}
+
+// Tenants lists the names of all tenants
+func (d *DB) Tenants() ([]string, error) {
+	var names []string
+	err := d.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			names = append(names, string(name))
+			return nil
+		})
+	})
+	return names, err
+}
+
+// Store is a phe.RecordStore backed by a single tenant's bucket
+type Store struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+var _ phe.RecordStore = (*Store)(nil)
+
+// Get returns the record stored under key or phe.ErrRecordNotFound
+func (s *Store) Get(ctx context.Context, key string) (rec *phe.EnrollmentRecord, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		blob := tx.Bucket(s.bucket).Get([]byte(key))
+		if blob == nil {
+			return phe.ErrRecordNotFound
+		}
+		rec, err = phe.UnmarshalCompactRecord(blob)
+		return err
+	})
+	return
+}
+
+// Put stores the record under key replacing any existing one
+func (s *Store) Put(ctx context.Context, key string, rec *phe.EnrollmentRecord) error {
+	blob, err := phe.MarshalCompactRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).Put([]byte(key), blob)
+	})
+}
+
+// Create stores the record under key only if there's none yet, otherwise it returns phe.ErrRecordConflict
+func (s *Store) Create(ctx context.Context, key string, rec *phe.EnrollmentRecord) error {
+	blob, err := phe.MarshalCompactRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		if b.Get([]byte(key)) != nil {
+			return phe.ErrRecordConflict
+		}
+		return b.Put([]byte(key), blob)
+	})
+}
+
+// Iterate calls fn for records with keys greater than after in ascending key order.
+// Records are read page by page and fn is called outside of database transactions so it may write to the store
+func (s *Store) Iterate(ctx context.Context, after string, fn func(key string, rec *phe.EnrollmentRecord) error) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var keys []string
+		var blobs [][]byte
+		err := s.db.View(func(tx *bolt.Tx) error {
+			c := tx.Bucket(s.bucket).Cursor()
+			k, v := c.Seek([]byte(after))
+			if k != nil && string(k) == after {
+				k, v = c.Next()
+			}
+
+			for ; k != nil && len(keys) < iteratePage; k, v = c.Next() {
+				keys = append(keys, string(k))
+				blobs = append(blobs, append([]byte{}, v...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for i, key := range keys {
+			rec, err := phe.UnmarshalCompactRecord(blobs[i])
+			if err != nil {
+				return errors.Wrapf(err, "record %s", key)
+			}
+
+			if err = fn(key, rec); err == phe.ErrStopIteration {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+
+		if len(keys) < iteratePage {
+			return nil
+		}
+		after = keys[len(keys)-1]
+	}
+}
+
+// UpdateConditional replaces the record under key only if the stored one equals old.
+// It returns phe.ErrRecordConflict if it doesn't and phe.ErrRecordNotFound if there's no record.
+// The stored record may be packed with an earlier version, it's written with the current one
+func (s *Store) UpdateConditional(ctx context.Context, key string, old, rec *phe.EnrollmentRecord) error {
+	oldBlobs, err := phe.CompactRecordEncodings(old)
+	if err != nil {
+		return err
+	}
+
+	blob, err := phe.MarshalCompactRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		stored := b.Get([]byte(key))
+		if stored == nil {
+			return phe.ErrRecordNotFound
+		}
+		for _, oldBlob := range oldBlobs {
+			if bytes.Equal(stored, oldBlob) {
+				return b.Put([]byte(key), blob)
+			}
+		}
+		return phe.ErrRecordConflict
+	})
+}
@@ -0,0 +1,79 @@
+package boltstore
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "records.db"))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	acme, err := db.Tenant("acme")
+	assert.NoError(t, err)
+	globex, err := db.Tenant("globex")
+	assert.NoError(t, err)
+
+	tenants, err := db.Tenants()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"acme", "globex"}, tenants)
+
+	serverKeypair, err := phe.GenerateServerKeypair()
+	assert.NoError(t, err)
+	pub, err := phe.GetPublicKey(serverKeypair)
+	assert.NoError(t, err)
+	c, err := phe.NewClient(phe.GenerateClientKey(), pub)
+	assert.NoError(t, err)
+
+	records := make([]*phe.EnrollmentRecord, 2)
+	for i := range records {
+		enrollment, err := phe.GetEnrollment(serverKeypair)
+		assert.NoError(t, err)
+		records[i], _, err = c.EnrollAccount([]byte("password"), enrollment)
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, acme.Put(ctx, "alice", records[0]))
+	got, err := acme.Get(ctx, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, records[0], got)
+
+	//tenants don't share records
+	_, err = globex.Get(ctx, "alice")
+	assert.Equal(t, phe.ErrRecordNotFound, err)
+
+	assert.Equal(t, phe.ErrRecordConflict, acme.UpdateConditional(ctx, "alice", records[1], records[1]))
+	assert.NoError(t, acme.UpdateConditional(ctx, "alice", records[0], records[1]))
+	assert.Equal(t, phe.ErrRecordNotFound, acme.UpdateConditional(ctx, "bob", records[0], records[1]))
+
+	assert.Equal(t, phe.ErrRecordConflict, acme.Create(ctx, "alice", records[0]))
+	assert.NoError(t, acme.Create(ctx, "bob", records[0]))
+	got, err = acme.Get(ctx, "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, records[0], got)
+
+	for i := 0; i < iteratePage+3; i++ {
+		assert.NoError(t, globex.Put(ctx, fmt.Sprintf("user%04d", i), records[0]))
+	}
+
+	//callback may write to the store
+	n := 0
+	assert.NoError(t, globex.Iterate(ctx, "user0000", func(key string, rec *phe.EnrollmentRecord) error {
+		n++
+		return globex.Put(ctx, key, records[1])
+	}))
+	assert.Equal(t, iteratePage+2, n)
+
+	page, err := phe.StoreRecords{RecordStore: globex}.Records(ctx, "", 3)
+	assert.NoError(t, err)
+	assert.Len(t, page, 3)
+	assert.Equal(t, records[0], page[0].Record)
+	assert.Equal(t, records[1], page[1].Record)
+}
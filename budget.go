@@ -0,0 +1,94 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrBudgetExceeded is matched by BudgetExceededError
+var ErrBudgetExceeded = errors.New("proof verification budget exceeded")
+
+// BudgetExceededError is returned when verification of a server response takes longer than the budget set by
+// WithVerificationBudget
+type BudgetExceededError struct {
+	Budget  time.Duration
+	Elapsed time.Duration
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("%s: spent %s of %s", ErrBudgetExceeded, e.Elapsed, e.Budget)
+}
+
+// Is makes errors.Is(err, ErrBudgetExceeded) true for every BudgetExceededError
+func (e *BudgetExceededError) Is(target error) bool {
+	return target == ErrBudgetExceeded
+}
+
+// WithVerificationBudget bounds the time Client spends verifying a single server response.
+// Verification is aborted between expensive steps once the budget is spent
+func WithVerificationBudget(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.verifyBudget = d
+	}
+}
+
+// budget tracks the deadline of a single verification, nil budget is unlimited
+type budget struct {
+	start time.Time
+	limit time.Duration
+}
+
+func (c *Client) newBudget() *budget {
+	if c.verifyBudget <= 0 {
+		return nil
+	}
+	return &budget{start: time.Now(), limit: c.verifyBudget}
+}
+
+func (b *budget) check() error {
+	if b == nil {
+		return nil
+	}
+	if elapsed := time.Since(b.start); elapsed > b.limit {
+		return &BudgetExceededError{Budget: b.limit, Elapsed: elapsed}
+	}
+	return nil
+}
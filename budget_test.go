@@ -0,0 +1,48 @@
+package phe
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_VerificationBudget(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	pub, err := GetPublicKey(serverKeypair)
+	assert.NoError(t, err)
+
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+
+	tight, err := NewClient(GenerateClientKey(), pub, WithVerificationBudget(time.Nanosecond))
+	assert.NoError(t, err)
+	_, _, err = tight.EnrollAccount(pwd, enrollment)
+	assert.True(t, errors.Is(err, ErrBudgetExceeded))
+	var budgetErr *BudgetExceededError
+	assert.True(t, errors.As(err, &budgetErr))
+	assert.Equal(t, time.Nanosecond, budgetErr.Budget)
+	assert.Greater(t, budgetErr.Elapsed, budgetErr.Budget)
+
+	c, err := NewClient(tight.clientPrivateKeyBytes, pub, WithVerificationBudget(time.Minute))
+	assert.NoError(t, err)
+	rec, key, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	for _, attempt := range [][]byte{pwd, []byte("Password1")} {
+		req, err := c.CreateVerifyPasswordRequest(attempt, rec)
+		assert.NoError(t, err)
+		res, err := VerifyPassword(serverKeypair, req)
+		assert.NoError(t, err)
+
+		_, err = tight.CheckResponseAndDecrypt(attempt, rec, res)
+		assert.True(t, errors.Is(err, ErrBudgetExceeded))
+
+		keyDec, err := c.CheckResponseAndDecrypt(attempt, rec, res)
+		assert.NoError(t, err)
+		if res.Res {
+			assert.Equal(t, key, keyDec)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+// Limiter bounds how many bulk work items run at once. A single Limiter can be shared between bulk calls
+// and the host service's own work so that PHE never takes more than its share. *semaphore.Weighted satisfies it
+type Limiter interface {
+	Acquire(ctx context.Context, n int64) error
+	Release(n int64)
+}
+
+// NewLimiter returns a Limiter which lets at most n work items run concurrently
+func NewLimiter(n int) Limiter {
+	return semaphore.NewWeighted(int64(n))
+}
+
+// BulkOption configures bulk (batch) operations
+type BulkOption func(*bulkConfig)
+
+type bulkConfig struct {
+	workers int
+	limiter Limiter
+}
+
+// WithWorkers sets how many goroutines a single bulk call uses. The default is GOMAXPROCS
+func WithWorkers(n int) BulkOption {
+	return func(cfg *bulkConfig) {
+		if n > 0 {
+			cfg.workers = n
+		}
+	}
+}
+
+// WithLimiter makes every work item of a bulk call acquire the limiter before it runs
+func WithLimiter(l Limiter) BulkOption {
+	return func(cfg *bulkConfig) {
+		cfg.limiter = l
+	}
+}
+
+func newBulkConfig(opts []BulkOption) *bulkConfig {
+	cfg := &bulkConfig{workers: runtime.GOMAXPROCS(0)}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// runBulk calls fn for items 0..n-1 on the configured number of workers.
+// The first error cancels the context passed to the other items, items which haven't started yet are skipped
+// and the error is returned
+func runBulk(ctx context.Context, n int, cfg *bulkConfig, fn func(ctx context.Context, i int) error) error {
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(cfg.workers)
+
+	started := 0
+	for ; started < n && gctx.Err() == nil; started++ {
+		i := started
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
+
+			if cfg.limiter != nil {
+				if err := cfg.limiter.Acquire(gctx, 1); err != nil {
+					return err
+				}
+				defer cfg.limiter.Release(1)
+			}
+
+			return fn(gctx, i)
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	if started < n {
+		// cancelled by the caller before every item was started
+		return ctx.Err()
+	}
+	return nil
+}
+
+// BatchError is returned by bulk calls which process items independently.
+// Errors has an entry for every input item, nil for the ones which succeeded
+type BatchError struct {
+	Errors []error
+}
+
+func (e *BatchError) Error() string {
+	failed, first := 0, -1
+	for i, err := range e.Errors {
+		if err != nil {
+			failed++
+			if first < 0 {
+				first = i
+			}
+		}
+	}
+	return fmt.Sprintf("%d of %d items failed, item %d: %v", failed, len(e.Errors), first, e.Errors[first])
+}
+
+// runEach is like runBulk but a failed item doesn't stop the others, errors are collected into a *BatchError.
+// Only cancellation of ctx stops the run early
+func runEach(ctx context.Context, n int, cfg *bulkConfig, fn func(i int) error) error {
+	errs := make([]error, n)
+	failed := int32(0)
+
+	err := runBulk(ctx, n, cfg, func(ctx context.Context, i int) error {
+		if errs[i] = fn(i); errs[i] != nil {
+			atomic.AddInt32(&failed, 1)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return &BatchError{Errors: errs}
+	}
+	return nil
+}
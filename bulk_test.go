@@ -0,0 +1,160 @@
+package phe
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunBulk_FirstErrorCancels(t *testing.T) {
+	var calls int32
+	err := runBulk(context.Background(), 100, newBulkConfig([]BulkOption{WithWorkers(1)}), func(ctx context.Context, i int) error {
+		atomic.AddInt32(&calls, 1)
+		if i == 3 {
+			return errors.New("item 3 failed")
+		}
+		return nil
+	})
+	assert.EqualError(t, err, "item 3 failed")
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+}
+
+func TestRunBulk_Limiter(t *testing.T) {
+	var running, peak int32
+	cfg := newBulkConfig([]BulkOption{WithWorkers(8), WithLimiter(NewLimiter(2))})
+	err := runBulk(context.Background(), 50, cfg, func(ctx context.Context, i int) error {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		atomic.AddInt32(&running, -1)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, atomic.LoadInt32(&peak) <= 2)
+}
+
+func TestRunBulk_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := runBulk(ctx, 10, newBulkConfig(nil), func(ctx context.Context, i int) error {
+		return nil
+	})
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestClient_VerifyProofsOfSuccessContext(t *testing.T) {
+	c, statements := makeStatements(t, Secp256k1(), 9)
+	assert.NoError(t, c.VerifyProofsOfSuccessContext(context.Background(), statements, WithWorkers(3), WithLimiter(NewLimiter(2))))
+
+	statements[7].Proof.BlindX = statements[1].Proof.BlindX
+	assert.EqualError(t, c.VerifyProofsOfSuccessContext(context.Background(), statements, WithWorkers(3)), "invalid proof 7")
+}
+
+func TestVerifyPasswordBatch(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	pub, err := GetPublicKey(serverKeypair)
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), pub)
+	assert.NoError(t, err)
+
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	rec, key, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	attempts := [][]byte{pwd, []byte("wrong"), pwd}
+	reqs := make([]*VerifyPasswordRequest, len(attempts))
+	for i, attempt := range attempts {
+		reqs[i], err = c.CreateVerifyPasswordRequest(attempt, rec)
+		assert.NoError(t, err)
+	}
+	reqs = append(reqs, &VerifyPasswordRequest{NS: rec.NS, C0: []byte{4}})
+
+	responses, err := VerifyPasswordBatch(serverKeypair, reqs, WithWorkers(2))
+	assert.IsType(t, &BatchError{}, err)
+	errs := err.(*BatchError).Errors
+	assert.Len(t, responses, len(reqs))
+
+	for i, attempt := range attempts {
+		assert.NoError(t, errs[i])
+		assert.Equal(t, i != 1, responses[i].Res)
+
+		keyDec, err := c.CheckResponseAndDecrypt(attempt, rec, responses[i])
+		assert.NoError(t, err)
+		if responses[i].Res {
+			assert.Equal(t, key, keyDec)
+		}
+	}
+	assert.Error(t, errs[3])
+	assert.Nil(t, responses[3])
+
+	responses, err = VerifyPasswordBatch(serverKeypair, reqs[:3])
+	assert.NoError(t, err)
+	assert.Len(t, responses, 3)
+}
+
+func TestGetEnrollments(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	pub, err := GetPublicKey(serverKeypair)
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), pub)
+	assert.NoError(t, err)
+
+	responses, err := GetEnrollments(serverKeypair, 4, WithWorkers(2))
+	assert.NoError(t, err)
+	assert.Len(t, responses, 4)
+
+	statements := make([]*SuccessStatement, len(responses))
+	for i, resp := range responses {
+		statements[i] = &SuccessStatement{NS: resp.NS, C0: resp.C0, C1: resp.C1, Proof: resp.Proof}
+		if i > 0 {
+			assert.NotEqual(t, responses[i-1].NS, resp.NS)
+		}
+	}
+	assert.NoError(t, c.VerifyProofsOfSuccess(statements))
+
+	_, err = GetEnrollments(serverKeypair, -1)
+	assert.Error(t, err)
+}
+
+func TestUpdateRecords(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	pub, err := GetPublicKey(serverKeypair)
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), pub)
+	assert.NoError(t, err)
+
+	records := make([]*EnrollmentRecord, 4)
+	for i := range records {
+		enrollment, err := GetEnrollment(serverKeypair)
+		assert.NoError(t, err)
+		records[i], _, err = c.EnrollAccount(pwd, enrollment)
+		assert.NoError(t, err)
+	}
+	records[2] = &EnrollmentRecord{NS: records[2].NS}
+
+	token, newKeypair, err := Rotate(serverKeypair)
+	assert.NoError(t, err)
+	assert.NoError(t, c.RotateChecked(token, mustPublicKey(t, newKeypair)))
+
+	updated, err := UpdateRecords(records, token, WithWorkers(2))
+	assert.IsType(t, &BatchError{}, err)
+	assert.Contains(t, err.Error(), "1 of 4 items failed, item 2")
+	assert.Nil(t, updated[2])
+
+	for _, i := range []int{0, 1, 3} {
+		req, err := c.CreateVerifyPasswordRequest(pwd, updated[i])
+		assert.NoError(t, err)
+		resp, err := VerifyPassword(newKeypair, req)
+		assert.NoError(t, err)
+		assert.True(t, resp.Res)
+	}
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import "github.com/passw0rd/phe-go/internal/wire"
+
+// ProtocolVersion is the version of the PHE protocol messages produced by this package
+const ProtocolVersion = 1
+
+// SuiteRistretto255 is the parameter set of package ristretto
+const SuiteRistretto255 = "ristretto255"
+
+// Capabilities describes what one end of the protocol supports so that orchestration layers
+// can configure the other end automatically. It's meant to be serialized as JSON
+type Capabilities struct {
+	// Suite is the parameter set in use, Suites lists all the ones this build supports
+	Suite            string   `json:"suite"`
+	Suites           []string `json:"suites"`
+	ProtocolVersions []int    `json:"protocol_versions"`
+
+	// maximum sizes of protocol values in bytes
+	MaxNonceLen  int `json:"max_nonce_len"`
+	MaxPointLen  int `json:"max_point_len"`
+	MaxScalarLen int `json:"max_scalar_len"`
+
+	// optional features: Threshold is set by servers running a ThresholdKey, UOKMS by UOKMSServer and UOKMSClient,
+	// FastMode by servers made WithoutProofs and clients made WithTrustedServer
+	Threshold bool `json:"threshold"`
+	UOKMS     bool `json:"uokms"`
+	FastMode  bool `json:"fast_mode"`
+}
+
+// Capabilities reports what the server supports
+func (s *Server) Capabilities() *Capabilities {
+	caps := s.curve.capabilities()
+	caps.FastMode = s.noProofs
+	if dk, ok := s.key.(*delegatedKey); ok {
+		_, caps.Threshold = dk.ops.(*ThresholdKey)
+	}
+	return caps
+}
+
+// Capabilities reports what the client supports
+func (c *Client) Capabilities() *Capabilities {
+	caps := c.curve.capabilities()
+	caps.FastMode = c.trustServer
+	return caps
+}
+
+// Capabilities reports what the UOKMS server supports
+func (u *UOKMSServer) Capabilities() *Capabilities {
+	caps := u.s.Capabilities()
+	caps.UOKMS = true
+	return caps
+}
+
+// Capabilities reports what the UOKMS client supports
+func (u *UOKMSClient) Capabilities() *Capabilities {
+	caps := u.curve.capabilities()
+	caps.UOKMS = true
+	return caps
+}
+
+// SupportedSuites lists the parameter sets this build supports, the curves of this package and SuiteRistretto255
+func SupportedSuites() []string {
+	suites := make([]string, 0, len(curves)+1)
+	for _, curve := range curves {
+		suites = append(suites, curve.name)
+	}
+	return append(suites, SuiteRistretto255)
+}
+
+func (c *Curve) capabilities() *Capabilities {
+	return &Capabilities{
+		Suite:            c.name,
+		Suites:           SupportedSuites(),
+		ProtocolVersions: []int{ProtocolVersion},
+		MaxNonceLen:      wire.MaxNonceLen,
+		MaxPointLen:      c.pointLen,
+		MaxScalarLen:     c.scalarLen,
+	}
+}
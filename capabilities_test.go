@@ -0,0 +1,50 @@
+package phe
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapabilities(t *testing.T) {
+	kp, err := GenerateServerKeypairForCurve(P384())
+	assert.NoError(t, err)
+	s, err := NewServer(kp)
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKeyForCurve(P384()), s.PublicKey())
+	assert.NoError(t, err)
+
+	caps := s.Capabilities()
+	assert.Equal(t, c.Capabilities(), caps)
+	assert.Equal(t, "P-384", caps.Suite)
+	assert.Equal(t, []string{"P-256", "P-384", "P-521", "secp256k1", SuiteRistretto255}, caps.Suites)
+	assert.Equal(t, []int{ProtocolVersion}, caps.ProtocolVersions)
+	assert.Equal(t, len(s.PublicKey()), caps.MaxPointLen)
+	assert.Equal(t, 48, caps.MaxScalarLen)
+	assert.False(t, caps.Threshold)
+	assert.False(t, caps.UOKMS)
+
+	data, err := json.Marshal(caps)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"suite":"P-384"`)
+}
+
+func TestCapabilities_Features(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	pub := mustPublicKey(t, serverKeypair)
+
+	key, err := NewThresholdKey(pub, 2, mustThresholdHolders(t, serverKeypair, 3, 2))
+	assert.NoError(t, err)
+	s, err := NewServerWithKey(key)
+	assert.NoError(t, err)
+	assert.True(t, s.Capabilities().Threshold)
+
+	u, err := NewUOKMSServer(serverKeypair)
+	assert.NoError(t, err)
+	assert.True(t, u.Capabilities().UOKMS)
+	assert.False(t, u.Capabilities().Threshold)
+	uc, err := NewUOKMSClient(GenerateClientKey(), pub)
+	assert.NoError(t, err)
+	assert.True(t, uc.Capabilities().UOKMS)
+}
@@ -0,0 +1,416 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"bytes"
+	"io"
+	"math/big"
+	"sort"
+
+	"github.com/passw0rd/phe-go/internal/shamir"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+)
+
+// A key ceremony generates a server key no participant ever knows, using Pedersen's distributed key generation
+// with Feldman commitments. Every participant i picks a random polynomial fi of degree t-1, publishes commitments
+// to its coefficients and sends fi(j) to participant j. Participant j checks every share it receives against the
+// commitments and keeps xj = sum of fi(j), its threshold share of x = sum of fi(0). The public key and every
+// share public key follow from the commitments alone, so anyone can verify the transcript.
+//
+// Shares are used by threshold servers, see ThresholdKey, or t of them are combined into a keypair where
+// a single server has to hold the key, see CombineThresholdShares
+
+var (
+	ceremonyPoK     = []byte("CeremonyPoK")
+	ceremonyEntropy = []byte("CeremonyEntropy")
+)
+
+// CeremonyParams are the parameters all participants of a ceremony agree on beforehand
+type CeremonyParams struct {
+	Curve *Curve
+	// ID is unique per ceremony, so nothing published in one ceremony can be replayed in another
+	ID           []byte
+	Participants int
+	Threshold    int
+}
+
+func (p *CeremonyParams) check() error {
+	if p.Curve == nil || len(p.ID) == 0 {
+		return errors.New("invalid ceremony parameters")
+	}
+	if p.Threshold < 2 || p.Participants < p.Threshold || p.Participants > 255 {
+		return errors.New("invalid ceremony threshold")
+	}
+	return nil
+}
+
+// CeremonyContribution is published by a participant to everyone: commitments to its polynomial's coefficients
+// and a proof of knowledge of the constant term, which keeps a participant from cancelling out others' contributions
+type CeremonyContribution struct {
+	Index       int      `json:"index"`
+	Commitments [][]byte `json:"commitments"`
+	ProofTerm   []byte   `json:"proof_term"`
+	ProofBlind  []byte   `json:"proof_blind"`
+}
+
+// CeremonyParticipant is one participant's side of a ceremony
+type CeremonyParticipant struct {
+	params   CeremonyParams
+	index    int
+	coeffs   []*big.Int
+	own      *CeremonyContribution
+	received map[int]*big.Int
+}
+
+// NewCeremonyParticipant starts a participant with index from 1 to params.Participants.
+// entropy is mixed into the system random source, e.g. from dice rolled at the ceremony, and may be empty
+func NewCeremonyParticipant(params CeremonyParams, index int, entropy []byte) (*CeremonyParticipant, error) {
+	if err := params.check(); err != nil {
+		return nil, err
+	}
+	if index < 1 || index > params.Participants {
+		return nil, errors.New("invalid participant index")
+	}
+
+	c := params.Curve
+	seed := make([]byte, 64)
+	random.MustRead(seed)
+	ikm := append(seed, entropy...)
+	scalar := func(i int) *big.Int {
+		return nonZero(c, hkdf.New(c.hash, ikm, params.ID, append(ceremonyEntropy, byte(i>>8), byte(i))))
+	}
+
+	p := &CeremonyParticipant{
+		params:   params,
+		index:    index,
+		coeffs:   make([]*big.Int, params.Threshold),
+		received: make(map[int]*big.Int, params.Participants),
+		own:      &CeremonyContribution{Index: index, Commitments: make([][]byte, params.Threshold)},
+	}
+	for i := range p.coeffs {
+		p.coeffs[i] = scalar(i)
+		p.own.Commitments[i] = c.scalarBaseMult(p.coeffs[i]).Marshal()
+	}
+
+	// Schnorr proof of knowledge of the constant term
+	k := scalar(len(p.coeffs))
+	term := c.scalarBaseMult(k)
+	e := params.challenge(index, p.own.Commitments[0], term.Marshal())
+	s := new(big.Int).Mul(e, p.coeffs[0])
+	s.Add(s, k).Mod(s, c.ec.Params().N)
+	p.own.ProofTerm = term.Marshal()
+	p.own.ProofBlind = c.scalarBytes(s)
+
+	p.received[index] = p.eval(index)
+	return p, nil
+}
+
+// Contribution returns what the participant publishes to everyone
+func (p *CeremonyParticipant) Contribution() *CeremonyContribution {
+	return p.own
+}
+
+// Share returns the secret share for participant j. It must reach j over a confidential and authenticated channel
+func (p *CeremonyParticipant) Share(j int) ([]byte, error) {
+	if j < 1 || j > p.params.Participants || j == p.index {
+		return nil, errors.New("invalid participant index")
+	}
+	return p.params.Curve.scalarBytes(p.eval(j)), nil
+}
+
+// Receive checks the share sent by another participant against its published contribution.
+// A participant whose share doesn't match has to be excluded and the ceremony restarted
+func (p *CeremonyParticipant) Receive(from *CeremonyContribution, share []byte) error {
+	if from == nil || from.Index == p.index {
+		return errors.New("invalid contribution")
+	}
+	if _, ok := p.received[from.Index]; ok {
+		return errors.Errorf("share of participant %d already received", from.Index)
+	}
+
+	commitments, err := p.params.verify(from)
+	if err != nil {
+		return err
+	}
+
+	c := p.params.Curve
+	y, err := c.parseScalar(share)
+	if err != nil || len(share) != c.scalarLen {
+		return errors.Errorf("invalid share of participant %d", from.Index)
+	}
+	if !c.scalarBaseMult(y).Equal(evalCommitments(c, commitments, p.index)) {
+		return errors.Errorf("share of participant %d doesn't match its commitments", from.Index)
+	}
+
+	p.received[from.Index] = y
+	return nil
+}
+
+// Finish returns the participant's threshold share once shares of all participants are received.
+// It checks the share against the transcript built from everyone's contributions
+func (p *CeremonyParticipant) Finish(transcript *CeremonyTranscript) (*ThresholdShare, error) {
+	if len(p.received) != p.params.Participants {
+		return nil, errors.Errorf("%d of %d shares received", len(p.received), p.params.Participants)
+	}
+	if err := transcript.Verify(p.params); err != nil {
+		return nil, err
+	}
+	for _, cn := range transcript.Contributions {
+		if cn.Index == p.index && !contributionEqual(cn, p.own) {
+			return nil, errors.New("transcript has another contribution of this participant")
+		}
+	}
+
+	c := p.params.Curve
+	x := new(big.Int)
+	for _, y := range p.received {
+		x.Add(x, y)
+	}
+	priv := c.scalarBytes(x.Mod(x, c.ec.Params().N))
+
+	pub := c.scalarBaseMultBytes(priv).Marshal()
+	if !bytes.Equal(pub, transcript.SharePublicKeys[p.index-1]) {
+		return nil, errors.New("share doesn't match the transcript")
+	}
+
+	return &ThresholdShare{
+		Index:          p.index,
+		Threshold:      p.params.Threshold,
+		PublicKey:      append([]byte{}, transcript.PublicKey...),
+		SharePublicKey: pub,
+		PrivateKey:     priv,
+	}, nil
+}
+
+// eval computes the participant's polynomial at j
+func (p *CeremonyParticipant) eval(j int) *big.Int {
+	n := p.params.Curve.ec.Params().N
+	x := big.NewInt(int64(j))
+	y := new(big.Int)
+	for i := len(p.coeffs) - 1; i >= 0; i-- {
+		y.Mul(y, x)
+		y.Add(y, p.coeffs[i])
+		y.Mod(y, n)
+	}
+	return y
+}
+
+// CeremonyTranscript records a ceremony: contributions of all participants, the resulting server public key
+// and the public key of every participant's share. It contains no secrets and is to be archived
+type CeremonyTranscript struct {
+	Curve           string                  `json:"curve"`
+	ID              []byte                  `json:"id"`
+	Threshold       int                     `json:"threshold"`
+	Contributions   []*CeremonyContribution `json:"contributions"`
+	PublicKey       []byte                  `json:"public_key"`
+	SharePublicKeys [][]byte                `json:"share_public_keys"`
+}
+
+// NewCeremonyTranscript checks contributions of all participants and derives the public keys from them
+func NewCeremonyTranscript(params CeremonyParams, contributions []*CeremonyContribution) (*CeremonyTranscript, error) {
+	if err := params.check(); err != nil {
+		return nil, err
+	}
+	if len(contributions) != params.Participants {
+		return nil, errors.Errorf("%d of %d contributions given", len(contributions), params.Participants)
+	}
+
+	sorted := append([]*CeremonyContribution{}, contributions...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Index < sorted[j].Index })
+
+	c := params.Curve
+	all := make([][]*Point, len(sorted))
+	for i, cn := range sorted {
+		if cn == nil || cn.Index != i+1 {
+			return nil, errors.New("contributions must come from participants 1 to n once each")
+		}
+		var err error
+		if all[i], err = params.verify(cn); err != nil {
+			return nil, err
+		}
+	}
+
+	tr := &CeremonyTranscript{
+		Curve:           c.name,
+		ID:              append([]byte{}, params.ID...),
+		Threshold:       params.Threshold,
+		Contributions:   sorted,
+		SharePublicKeys: make([][]byte, params.Participants),
+	}
+
+	pub := all[0][0]
+	for _, cm := range all[1:] {
+		pub = pub.Add(cm[0])
+	}
+	tr.PublicKey = pub.Marshal()
+
+	for j := 1; j <= params.Participants; j++ {
+		share := evalCommitments(c, all[0], j)
+		for _, cm := range all[1:] {
+			share = share.Add(evalCommitments(c, cm, j))
+		}
+		tr.SharePublicKeys[j-1] = share.Marshal()
+	}
+	return tr, nil
+}
+
+// Verify recomputes the transcript from its contributions and checks it's a transcript of a ceremony with the given parameters
+func (tr *CeremonyTranscript) Verify(params CeremonyParams) error {
+	if tr == nil {
+		return errors.New("invalid transcript")
+	}
+	if err := params.check(); err != nil {
+		return err
+	}
+	if tr.Curve != params.Curve.name || !bytes.Equal(tr.ID, params.ID) || tr.Threshold != params.Threshold {
+		return errors.New("transcript is of another ceremony")
+	}
+
+	expected, err := NewCeremonyTranscript(params, tr.Contributions)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(expected.PublicKey, tr.PublicKey) || len(tr.SharePublicKeys) != len(expected.SharePublicKeys) {
+		return errors.New("transcript public keys don't match its contributions")
+	}
+	for i := range expected.SharePublicKeys {
+		if !bytes.Equal(expected.SharePublicKeys[i], tr.SharePublicKeys[i]) {
+			return errors.New("transcript public keys don't match its contributions")
+		}
+	}
+	return nil
+}
+
+// CombineThresholdShares assembles the server keypair from at least threshold shares,
+// for deployments where a single server has to hold the key generated in a ceremony
+func CombineThresholdShares(shares []*ThresholdShare) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares")
+	}
+
+	first := shares[0]
+	points := make([]shamir.Share, len(shares))
+	for i, sh := range shares {
+		if sh == nil || sh.Threshold != first.Threshold || !bytes.Equal(sh.PublicKey, first.PublicKey) {
+			return nil, errors.New("shares are from different keys")
+		}
+		k, err := newShareKey(sh.SharePublicKey, sh.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		points[i] = shamir.Share{X: sh.Index, Y: new(big.Int).SetBytes(k.key.x)}
+	}
+
+	pub, err := PointUnmarshal(first.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return combineShares(pub, first.Threshold, points)
+}
+
+// verify checks a contribution and returns its commitments
+func (p *CeremonyParams) verify(cn *CeremonyContribution) ([]*Point, error) {
+	c := p.Curve
+	if cn.Index < 1 || cn.Index > p.Participants || len(cn.Commitments) != p.Threshold {
+		return nil, errors.New("invalid contribution")
+	}
+
+	commitments := make([]*Point, len(cn.Commitments))
+	for i, b := range cn.Commitments {
+		var err error
+		if commitments[i], err = c.pointUnmarshal(b); err != nil {
+			return nil, errors.Wrapf(err, "invalid contribution of participant %d", cn.Index)
+		}
+	}
+
+	term, err := c.pointUnmarshal(cn.ProofTerm)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid contribution of participant %d", cn.Index)
+	}
+	s, err := c.parseScalar(cn.ProofBlind)
+	if err != nil {
+		return nil, errors.Errorf("invalid contribution of participant %d", cn.Index)
+	}
+
+	// s·G = R + e·C0
+	e := p.challenge(cn.Index, cn.Commitments[0], cn.ProofTerm)
+	if !c.scalarBaseMult(s).Equal(term.Add(commitments[0].ScalarMultInt(e))) {
+		return nil, errors.Errorf("invalid proof of participant %d", cn.Index)
+	}
+	return commitments, nil
+}
+
+func (p *CeremonyParams) challenge(index int, commitment, term []byte) *big.Int {
+	c := p.Curve
+	return c.hashZ(ceremonyPoK, p.ID, []byte{byte(p.Participants), byte(p.Threshold), byte(index)}, c.gBytes, commitment, term)
+}
+
+// evalCommitments computes the sum of Ck·j^k, the public key of the polynomial's value at j
+func evalCommitments(c *Curve, commitments []*Point, j int) *Point {
+	n := c.ec.Params().N
+	scalars := make([]*big.Int, len(commitments))
+	pow := big.NewInt(1)
+	for k := range commitments {
+		scalars[k] = new(big.Int).Set(pow)
+		pow.Mul(pow, big.NewInt(int64(j))).Mod(pow, n)
+	}
+	return c.multiScalarMult(commitments, scalars)
+}
+
+func contributionEqual(a, b *CeremonyContribution) bool {
+	if a.Index != b.Index || len(a.Commitments) != len(b.Commitments) ||
+		!bytes.Equal(a.ProofTerm, b.ProofTerm) || !bytes.Equal(a.ProofBlind, b.ProofBlind) {
+		return false
+	}
+	for i := range a.Commitments {
+		if !bytes.Equal(a.Commitments[i], b.Commitments[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// nonZero reads a non-zero scalar
+func nonZero(c *Curve, rnd io.Reader) *big.Int {
+	for {
+		if z := c.makeZ(rnd); z.Sign() != 0 {
+			return z
+		}
+	}
+}
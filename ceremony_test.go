@@ -0,0 +1,106 @@
+package phe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runCeremony runs all participants in process, everything is passed around in the clear
+func runCeremony(t *testing.T, params CeremonyParams) (*CeremonyTranscript, []*ThresholdShare) {
+	participants := make([]*CeremonyParticipant, params.Participants)
+	contributions := make([]*CeremonyContribution, params.Participants)
+	for i := range participants {
+		p, err := NewCeremonyParticipant(params, i+1, []byte("dice"))
+		assert.NoError(t, err)
+		participants[i], contributions[i] = p, p.Contribution()
+	}
+
+	for _, from := range participants {
+		for _, to := range participants {
+			if from == to {
+				continue
+			}
+			share, err := from.Share(to.index)
+			assert.NoError(t, err)
+			assert.NoError(t, to.Receive(from.Contribution(), share))
+		}
+	}
+
+	transcript, err := NewCeremonyTranscript(params, contributions)
+	assert.NoError(t, err)
+
+	shares := make([]*ThresholdShare, len(participants))
+	for i, p := range participants {
+		shares[i], err = p.Finish(transcript)
+		assert.NoError(t, err)
+	}
+	return transcript, shares
+}
+
+func TestCeremony(t *testing.T) {
+	params := CeremonyParams{Curve: P384(), ID: []byte("ceremony-1"), Participants: 4, Threshold: 3}
+	transcript, shares := runCeremony(t, params)
+	assert.NoError(t, transcript.Verify(params))
+
+	//shares run a threshold server
+	holders := make(map[int]PrivateKeyOps)
+	for _, sh := range shares {
+		var err error
+		holders[sh.Index], err = sh.Key()
+		assert.NoError(t, err)
+	}
+	key, err := NewThresholdKey(transcript.PublicKey, params.Threshold, holders)
+	assert.NoError(t, err)
+	server, err := NewServerWithKey(key)
+	assert.NoError(t, err)
+	assert.NoError(t, server.Warmup())
+
+	//or are combined into a keypair
+	kp, err := CombineThresholdShares(shares[1:])
+	assert.NoError(t, err)
+	assert.Equal(t, transcript.PublicKey, mustPublicKey(t, kp))
+	_, err = CombineThresholdShares(shares[2:])
+	assert.Error(t, err)
+
+	//transcripts are bound to the ceremony
+	other := params
+	other.ID = []byte("ceremony-2")
+	assert.Error(t, transcript.Verify(other))
+
+	transcript.PublicKey = mustPublicKey(t, mustKeypair(t))
+	assert.Error(t, transcript.Verify(params))
+}
+
+func TestCeremony_Cheating(t *testing.T) {
+	params := CeremonyParams{Curve: P256(), ID: []byte("ceremony"), Participants: 3, Threshold: 2}
+	p1, err := NewCeremonyParticipant(params, 1, nil)
+	assert.NoError(t, err)
+	p2, err := NewCeremonyParticipant(params, 2, nil)
+	assert.NoError(t, err)
+	p3, err := NewCeremonyParticipant(params, 3, nil)
+	assert.NoError(t, err)
+
+	//a share for someone else
+	share, err := p2.Share(3)
+	assert.NoError(t, err)
+	assert.Error(t, p1.Receive(p2.Contribution(), share))
+
+	//a contribution without a valid proof of knowledge, e.g. a key chosen to cancel others out
+	forged := *p3.Contribution()
+	forged.Commitments = append([][]byte{p1.Contribution().Commitments[0]}, forged.Commitments[1:]...)
+	_, err = NewCeremonyTranscript(params, []*CeremonyContribution{p1.Contribution(), p2.Contribution(), &forged})
+	assert.Error(t, err)
+
+	_, err = NewCeremonyTranscript(params, []*CeremonyContribution{p1.Contribution(), p2.Contribution()})
+	assert.Error(t, err)
+
+	//not all shares received
+	transcript, err := NewCeremonyTranscript(params, []*CeremonyContribution{p1.Contribution(), p2.Contribution(), p3.Contribution()})
+	assert.NoError(t, err)
+	_, err = p1.Finish(transcript)
+	assert.Error(t, err)
+
+	_, err = NewCeremonyParticipant(CeremonyParams{Curve: P256(), ID: []byte("x"), Participants: 3, Threshold: 1}, 1, nil)
+	assert.Error(t, err)
+}
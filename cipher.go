@@ -0,0 +1,137 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+)
+
+var (
+	aeadInfo      = []byte("AEAD")
+	subKeyInfoTag = []byte("DeriveSubKey")
+	kdfReaderTag  = []byte("KDFReader")
+)
+
+// maxSubKeyLen is the most HKDF-Expand with SHA-512/256 can output
+const maxSubKeyLen = 255 * 32
+
+// NewAEAD turns the account key returned by EnrollAccount or CheckResponseAndDecrypt into a standard AES-256-GCM cipher.AEAD
+// Caller is responsible for choosing unique nonces
+func NewAEAD(key []byte) (cipher.AEAD, error) {
+	return newAEAD(key, aeadInfo)
+}
+
+// newAEAD derives a dedicated AES-256-GCM key from the account key so that different helpers never share a key
+func newAEAD(key, info []byte) (cipher.AEAD, error) {
+	subKey, err := deriveSubKey(key, info)
+	if err != nil {
+		return nil, err
+	}
+	return newGCM(subKey)
+}
+
+// DeriveSubKey derives a key of length bytes for the purpose named by label from the account key returned by
+// EnrollAccount or CheckResponseAndDecrypt, e.g. "db-encryption" or "search-index". The same inputs always give the same key,
+// keys for different labels or lengths are independent of each other and of the keys helpers of this package derive
+func DeriveSubKey(key []byte, label string, length int) ([]byte, error) {
+	if len(key) < 32 {
+		return nil, errors.New("invalid key")
+	}
+	if len(label) == 0 {
+		return nil, errors.New("invalid label")
+	}
+	if length <= 0 || length > maxSubKeyLen {
+		return nil, errors.New("invalid subkey length")
+	}
+
+	// the tag keeps labels apart from internal infos, the length keeps shorter keys from being prefixes of longer ones
+	var lengthBuf [2]byte
+	binary.BigEndian.PutUint16(lengthBuf[:], uint16(length))
+	info := make([]byte, 0, len(subKeyInfoTag)+2+len(label))
+	info = append(append(append(info, subKeyInfoTag...), lengthBuf[:]...), label...)
+
+	subKey := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.Expand(sha512.New512_256, key, info), subKey); err != nil {
+		return nil, err
+	}
+	return subKey, nil
+}
+
+// NewKDFReader returns HKDF output keyed by the account key returned by EnrollAccount or CheckResponseAndDecrypt,
+// which is itself derived from the account's secret point, so callers can read as much key material for the purpose
+// as they need, e.g. an encryption key followed by a MAC key. Different purposes give independent streams,
+// which are also independent of DeriveSubKey. Reading past 8160 bytes fails
+func NewKDFReader(key []byte, purpose string) (io.Reader, error) {
+	if len(key) < 32 {
+		return nil, errors.New("invalid key")
+	}
+	if len(purpose) == 0 {
+		return nil, errors.New("invalid purpose")
+	}
+
+	info := append(append([]byte{}, kdfReaderTag...), purpose...)
+	return hkdf.New(sha512.New512_256, key, nil, info), nil
+}
+
+// deriveSubKey derives a 32 byte key for a single purpose from the account key
+func deriveSubKey(key, info []byte) ([]byte, error) {
+	if len(key) < 32 {
+		return nil, errors.New("invalid key")
+	}
+
+	subKey := make([]byte, 32)
+	kdf := hkdf.New(sha512.New512_256, key, nil, info)
+	if _, err := kdf.Read(subKey); err != nil {
+		return nil, err
+	}
+	return subKey, nil
+}
+
+func newGCM(subKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(subKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,95 @@
+package phe
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeriveSubKey(t *testing.T) {
+	key := make([]byte, 32)
+	random.MustRead(key)
+
+	k1, err := DeriveSubKey(key, "db-encryption", 32)
+	assert.NoError(t, err)
+	assert.Len(t, k1, 32)
+
+	again, err := DeriveSubKey(key, "db-encryption", 32)
+	assert.NoError(t, err)
+	assert.Equal(t, k1, again)
+
+	k2, err := DeriveSubKey(key, "token-signing", 32)
+	assert.NoError(t, err)
+	assert.NotEqual(t, k1, k2)
+
+	long, err := DeriveSubKey(key, "db-encryption", 64)
+	assert.NoError(t, err)
+	assert.False(t, bytes.HasPrefix(long, k1))
+
+	// a label can't reproduce a key used internally
+	internal, err := deriveSubKey(key, pheCipherInfo[PheCipherAES256GCM])
+	assert.NoError(t, err)
+	k3, err := DeriveSubKey(key, string(pheCipherInfo[PheCipherAES256GCM]), 32)
+	assert.NoError(t, err)
+	assert.NotEqual(t, internal, k3)
+
+	longest, err := DeriveSubKey(key, "x", maxSubKeyLen)
+	assert.NoError(t, err)
+	assert.Len(t, longest, maxSubKeyLen)
+
+	_, err = DeriveSubKey(key, "x", maxSubKeyLen+1)
+	assert.Error(t, err)
+	_, err = DeriveSubKey(key, "x", 0)
+	assert.Error(t, err)
+	_, err = DeriveSubKey(key, "", 32)
+	assert.Error(t, err)
+	_, err = DeriveSubKey(key[:31], "x", 32)
+	assert.Error(t, err)
+}
+
+func TestNewKDFReader(t *testing.T) {
+	key := make([]byte, 32)
+	random.MustRead(key)
+
+	r, err := NewKDFReader(key, "session")
+	assert.NoError(t, err)
+	encKey := make([]byte, 32)
+	macKey := make([]byte, 64)
+	_, err = io.ReadFull(r, encKey)
+	assert.NoError(t, err)
+	_, err = io.ReadFull(r, macKey)
+	assert.NoError(t, err)
+
+	// reading in other portions gives the same stream
+	r, err = NewKDFReader(key, "session")
+	assert.NoError(t, err)
+	all := make([]byte, 96)
+	_, err = io.ReadFull(r, all)
+	assert.NoError(t, err)
+	assert.Equal(t, append(encKey, macKey...), all)
+
+	r, err = NewKDFReader(key, "other")
+	assert.NoError(t, err)
+	other := make([]byte, 96)
+	_, err = io.ReadFull(r, other)
+	assert.NoError(t, err)
+	assert.NotEqual(t, all, other)
+
+	sub, err := DeriveSubKey(key, "session", 32)
+	assert.NoError(t, err)
+	assert.NotEqual(t, encKey, sub)
+
+	r, err = NewKDFReader(key, "session")
+	assert.NoError(t, err)
+	_, err = io.ReadFull(r, make([]byte, maxSubKeyLen))
+	assert.NoError(t, err)
+	_, err = r.Read(make([]byte, 1))
+	assert.Error(t, err)
+
+	_, err = NewKDFReader(key, "")
+	assert.Error(t, err)
+	_, err = NewKDFReader(key[:16], "session")
+	assert.Error(t, err)
+}
@@ -37,323 +37,877 @@
 package phe
 
 import (
-	"crypto/rand"
-	"crypto/sha512"
+	"context"
+	"log/slog"
 	"math/big"
+	"time"
 
 	"github.com/pkg/errors"
-	"golang.org/x/crypto/hkdf"
 )
 
 // Client is responsible for protecting & checking passwords at the client (website) side
 type Client struct {
-	clientPrivateKey      *big.Int
+	clientPrivateKey      []byte //fixed-width for constant-time scalar operations
 	clientPrivateKeyBytes []byte
 	serverPublicKey       *Point
 	serverPublicKeyBytes  []byte
+	curve                 *Curve
+	verifyBudget          time.Duration
+	events                *EventBus
+	keyVersion            uint32
+	trustServer           bool
+	tracer                Tracer
+	metrics               Metrics
+	logger                Logger
+	keySalt               []byte
+	keyInfo               []byte
+	hasher                PasswordHasher
+	normalize             bool
+	policy                PasswordPolicy
+	protectKey            bool
+	enclave               *keyEnclave
+	accountID             []byte
+	macKey                []byte
+	requestNonce          bool
+	precompute            bool
+	keyTable              *fixedBase
 }
 
+// ClientOption configures optional Client behavior
+type ClientOption func(*Client)
+
 // GenerateClientKey creates a new random key used on the Client side
 func GenerateClientKey() []byte {
-	return randomZ().Bytes()
+	return p256.scalarBytes(randomZ())
 }
 
-//NewClient creates new client instance using client's private key and server's public key used for verification
-func NewClient(privateKey []byte, serverPublicKey []byte) (*Client, error) {
-	if len(privateKey) == 0 {
-		return nil, errors.New("invalid private key")
-	}
+// GenerateClientKeyForCurve creates a new random key used on the Client side for the given curve
+func GenerateClientKeyForCurve(curve *Curve) []byte {
+	return curve.randomScalar()
+}
 
+//NewClient creates new client instance using client's private key and server's public key used for verification
+//The curve is the one server's public key belongs to
+func NewClient(privateKey []byte, serverPublicKey []byte, opts ...ClientOption) (*Client, error) {
 	pub, err := PointUnmarshal(serverPublicKey)
 
 	if err != nil {
 		return nil, errors.Wrap(err, "invalid public key")
 	}
 
-	return &Client{
-		clientPrivateKey:      new(big.Int).SetBytes(privateKey),
+	y, err := pub.curve.parseScalar(privateKey)
+	if err != nil {
+		return nil, errors.New("invalid private key")
+	}
+
+	c := &Client{
+		clientPrivateKey:      pub.curve.scalarBytes(y),
 		serverPublicKey:       pub,
-		clientPrivateKeyBytes: privateKey,
+		clientPrivateKeyBytes: append([]byte{}, privateKey...),
 		serverPublicKeyBytes:  serverPublicKey,
-	}, nil
+		curve:                 pub.curve,
+		events:                DefaultEventBus,
+		metrics:               nopMetrics{},
+		logger:                nopLogger{},
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.precompute {
+		c.keyTable = newFixedBase(c.curve, pub)
+	}
+
+	if c.protectKey {
+		if c.enclave, err = newKeyEnclave(c.clientPrivateKey); err != nil {
+			return nil, err
+		}
+		Wipe(c.clientPrivateKey)
+		Wipe(c.clientPrivateKeyBytes)
+		c.clientPrivateKey, c.clientPrivateKeyBytes = nil, nil
+	}
+
+	return c, nil
 
 }
 
 // EnrollAccount uses fresh Enrollment Response and user's password (or its hash) to create a new Enrollment Record which
 // is then supposed to be stored in a database
-// it also generates a random encryption key which can be used to protect user's data
+// it also generates a random encryption key which can be used to protect user's data, see NewPheCipher
 func (c *Client) EnrollAccount(password []byte, resp *EnrollmentResponse) (rec *EnrollmentRecord, key []byte, err error) {
+	return c.EnrollAccountContext(context.Background(), password, resp)
+}
 
-	if resp == nil {
-		err = errors.New("invalid proof")
+// EnrollAccountContext is like EnrollAccount, ctx carries the trace the enrollment belongs to
+func (c *Client) EnrollAccountContext(ctx context.Context, password []byte, resp *EnrollmentResponse) (rec *EnrollmentRecord, key []byte, err error) {
+	ctx, span := startSpan(ctx, c.tracer, "phe.Client.EnrollAccount")
+	defer func() { span.End(err) }()
+	span.SetAttribute("phe.curve", c.curve.name)
+
+	if err = c.checkPolicy(password); err != nil {
 		return
 	}
 
-	c0, err := PointUnmarshal(resp.C0)
-	if err != nil {
+	if resp != nil {
+		if err = CheckKeyVersion(c.keyVersion, resp.KeyVersion); err != nil {
+			return
+		}
+	}
+
+	// encryption key in a form of a random point
+	m := c.randomM()
+	defer m.wipe()
+	return c.enroll(ctx, password, resp, m)
+}
+
+// EnrollAccountWithKey is like EnrollAccount but keeps the encryption key of an existing account, so re-enrolling it
+// doesn't orphan data encrypted before. accountSecret comes from RecoverAccountSecret for the existing record
+func (c *Client) EnrollAccountWithKey(password []byte, resp *EnrollmentResponse, accountSecret []byte) (rec *EnrollmentRecord, key []byte, err error) {
+	ctx, span := startSpan(context.Background(), c.tracer, "phe.Client.EnrollAccount")
+	defer func() { span.End(err) }()
+	span.SetAttribute("phe.curve", c.curve.name)
+
+	if err = c.checkPolicy(password); err != nil {
 		return
 	}
 
-	c1, err := PointUnmarshal(resp.C1)
+	if resp != nil {
+		if err = CheckKeyVersion(c.keyVersion, resp.KeyVersion); err != nil {
+			return
+		}
+	}
+
+	m, err := c.curve.pointUnmarshal(accountSecret)
+	if err != nil {
+		return nil, nil, errors.New("invalid account secret")
+	}
+	defer m.wipe()
+	return c.enroll(ctx, password, resp, m)
+}
+
+// RecoverAccountSecret verifies server's answer like CheckResponseAndDecrypt and returns the secret account's encryption key
+// is derived from, for EnrollAccountWithKey. It's nil if the password is wrong. The secret is as sensitive as the key
+func (c *Client) RecoverAccountSecret(password []byte, rec *EnrollmentRecord, resp *VerifyPasswordResponse) ([]byte, error) {
+	m, err := c.checkResponse(context.Background(), password, rec, resp)
+	if err != nil || m == nil {
+		return nil, err
+	}
+	defer m.wipe()
+	return m.Marshal(), nil
+}
+
+// enroll creates a record for the encryption key m with server's enrollment response
+func (c *Client) enroll(ctx context.Context, password []byte, resp *EnrollmentResponse, m *Point) (rec *EnrollmentRecord, key []byte, err error) {
+	c0, c1, err := c.parseEnrollment(ctx, resp)
 	if err != nil {
 		return
 	}
 
-	proofValid := c.validateProofOfSuccess(resp.Proof, resp.NS, c0, c1, resp.C0, resp.C1)
-	if !proofValid {
-		err = errors.New("invalid proof")
+	key = c.deriveKey(m)
+
+	if rec, err = c.newRecord(password, resp.NS, c0, c1, m); err != nil {
+		Wipe(key)
+		return nil, nil, err
+	}
+	rec.KeyVersion = resp.KeyVersion
+	if rec.KeyVersion == 0 {
+		rec.KeyVersion = c.keyVersion
+	}
+	c.sealRecord(rec)
+
+	c.metrics.Enrollment(SourceClient)
+	c.events.publish(EventEnrolled, SourceClient, func(h EventHeader) Event {
+		return &EnrolledEvent{EventHeader: h, NS: rec.NS}
+	})
+	return
+}
+
+// parseEnrollment validates server's enrollment response and returns its points
+func (c *Client) parseEnrollment(ctx context.Context, resp *EnrollmentResponse) (c0, c1 *Point, err error) {
+
+	if resp == nil {
+		err = &ProofError{Reason: ProofMalformed}
 		return
 	}
 
+	// points which fail to parse are left nil and rejected together with the proof
+	c0, _ = c.curve.pointUnmarshal(resp.C0)
+	c1, _ = c.curve.pointUnmarshal(resp.C1)
+
+	done := c.proofCheck(ctx)
+	err = c.validateProofOfSuccess(c.newBudget(), resp.Proof, resp.NS, c0, c1, resp.C0, resp.C1)
+	done(err)
+	c.publishProofError(err)
+	return
+}
+
+// newRecord creates a record for a fresh client nonce binding password and encryption key m to server's points
+func (c *Client) newRecord(password, ns []byte, c0, c1, m *Point) (*EnrollmentRecord, error) {
+
 	// client nonce and 2 points
 	nc := make([]byte, 32)
-	_, err = rand.Read(nc)
+	random.MustRead(nc)
+	password, err := c.hashPassword(password, nc)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	hc0 := hashToPoint(dhc0, nc, password)
-	hc1 := hashToPoint(dhc1, nc, password)
+	defer c.wipeHashed(password)
+	hc0 := c.hc(c.curve.dhc0, nc, password)
+	hc1 := c.hc(c.curve.dhc1, nc, password)
 
-	// encryption key in a form of a random point
-	mBuf := make([]byte, 32)
-	_, err = rand.Read(mBuf)
+	y, release, err := c.privateKey()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	m := hashToPoint(dm, mBuf)
-
-	kdf := hkdf.New(sha512.New512_256, m.Marshal(), nil, []byte("Secret"))
-	key = make([]byte, 32)
-	_, err = kdf.Read(key)
+	defer release()
 
 	// calculate two enrollment points
-	t0 := c0.Add(hc0.ScalarMultInt(c.clientPrivateKey))
-	t1 := c1.Add(hc1.ScalarMultInt(c.clientPrivateKey)).Add(m.ScalarMultInt(c.clientPrivateKey))
+	t0 := c0.Add(hc0.ScalarMult(y))
+	t1 := c1.Add(hc1.ScalarMult(y)).Add(m.ScalarMult(y))
 
-	rec = &EnrollmentRecord{
-		NS: resp.NS,
+	return &EnrollmentRecord{
+		NS: ns,
 		NC: nc,
 		T0: t0.Marshal(),
 		T1: t1.Marshal(),
-	}
-
-	return
+	}, nil
 }
 
-func (c *Client) validateProofOfSuccess(proof *ProofOfSuccess, nonce []byte, c0 *Point, c1 *Point, c0b, c1b []byte) bool {
+// validateProofOfSuccess checks server's proof that c0 and c1 were computed with its private key.
+// Nil points and unparsable proofs are replaced with placeholders and go through all the equations anyway
+// so that a malformed proof isn't rejected faster than a wrong one
+func (c *Client) validateProofOfSuccess(b *budget, proof *ProofOfSuccess, nonce []byte, c0 *Point, c1 *Point, c0b, c1b []byte) error {
+
+	if c.trustServer {
+		return c.checkTrusted(c0, c1)
+	}
 
-	term1, term2, term3, blindX, err := proof.parse()
+	var reason ProofFailure
+	term1, term2, term3, blindX, err := proof.parse(c.curve)
 
-	if err != nil {
-		return false
+	if err != nil || c0 == nil || c1 == nil {
+		reason = ProofMalformed
+		term1, term2, term3, blindX = c.curve.g, c.curve.g, c.curve.g, new(big.Int)
+		c0, c1 = c.curve.g, c.curve.g
+		if proof == nil {
+			proof = &ProofOfSuccess{}
+		}
 	}
 
-	hs0 := hashToPoint(dhs0, nonce)
-	hs1 := hashToPoint(dhs1, nonce)
+	hs0 := c.curve.hashToPoint(c.curve.dhs0, nonce)
+	hs1 := c.curve.hashToPoint(c.curve.dhs1, nonce)
+
+	challenge := c.curve.hashZ(c.curve.proofOk, c.serverPublicKeyBytes, c.curve.gBytes, c0b, c1b, proof.Term1, proof.Term2, proof.Term3)
 
-	challenge := hashZ(proofOk, c.serverPublicKeyBytes, curveG.Marshal(), c0b, c1b, proof.Term1, proof.Term2, proof.Term3)
+	if err = b.check(); err != nil {
+		return err
+	}
+
+	// verification equations are rearranged as term == Σ point ** scalar so that each one is a single multi-scalar multiplication
+	minusChallenge := c.curve.gf.Neg(challenge)
 
 	//if term1 * (c0 ** challenge) != hs0 ** blind_x:
 	// return False
 
-	t1 := term1.Add(c0.ScalarMultInt(challenge))
-	t2 := hs0.ScalarMultInt(blindX)
+	ok1 := c.curve.multiScalarMult([]*Point{hs0, c0}, []*big.Int{blindX, minusChallenge}).Equal(term1)
 
-	if !t1.Equal(t2) {
-		return false
+	if err = b.check(); err != nil {
+		return err
 	}
 
 	// if term2 * (c1 ** challenge) != hs1 ** blind_x:
 	// return False
 
-	t1 = term2.Add(c1.ScalarMultInt(challenge))
-	t2 = hs1.ScalarMultInt(blindX)
+	ok2 := c.curve.multiScalarMult([]*Point{hs1, c1}, []*big.Int{blindX, minusChallenge}).Equal(term2)
 
-	if !t1.Equal(t2) {
-		return false
+	if err = b.check(); err != nil {
+		return err
 	}
 
 	//if term3 * (self.X ** challenge) != self.G ** blind_x:
 	// return False
 
-	t1 = term3.Add(c.serverPublicKey.ScalarMultInt(challenge))
-	t2 = new(Point).ScalarBaseMultInt(blindX)
+	ok3 := c.keyMult(blindX, minusChallenge).Equal(term3)
 
-	if !t1.Equal(t2) {
-		return false
+	if reason == 0 && !(ok1 && ok2 && ok3) {
+		reason = ProofMismatch
 	}
 
-	return true
+	if reason != 0 {
+		return &ProofError{Reason: reason}
+	}
+	return nil
 }
 
 //CreateVerifyPasswordRequest creates a request in a form of elliptic curve point which is then need to be validated at the server side
 func (c *Client) CreateVerifyPasswordRequest(password []byte, rec *EnrollmentRecord) (req *VerifyPasswordRequest, err error) {
 
 	if rec == nil || len(rec.NC) == 0 || len(rec.NS) == 0 || len(rec.T0) == 0 {
-		return nil, errors.New("invalid client record")
+		err = errors.New("invalid client record")
+		c.logInvalidRecord(context.Background(), rec, err)
+		return nil, err
+	}
+
+	if err = c.checkRecordMAC(rec); err != nil {
+		c.logInvalidRecord(context.Background(), rec, err)
+		return nil, err
+	}
+
+	if err = CheckKeyVersion(c.keyVersion, rec.KeyVersion); err != nil {
+		return nil, err
+	}
+
+	if password, err = c.hashPassword(password, rec.NC); err != nil {
+		return nil, err
+	}
+	defer c.wipeHashed(password)
+
+	y, release, err := c.privateKey()
+	if err != nil {
+		return nil, err
 	}
+	defer release()
 
-	hc0 := hashToPoint(dhc0, rec.NC, password)
-	minusY := gf.Neg(c.clientPrivateKey)
+	hc0 := c.hc(c.curve.dhc0, rec.NC, password)
+	minusY := c.curve.sf.Neg(y)
+	defer Wipe(minusY)
 
-	t0, err := PointUnmarshal(rec.T0)
+	t0, err := c.curve.pointUnmarshal(rec.T0)
 	if err != nil {
+		c.logInvalidRecord(context.Background(), rec, err)
 		return nil, errors.New("invalid proof")
 	}
 
-	c0 := t0.Add(hc0.ScalarMultInt(minusY))
+	c0 := t0.Add(hc0.ScalarMult(minusY))
 	req = &VerifyPasswordRequest{
-		C0: c0.Marshal(),
-		NS: rec.NS,
+		C0:         c0.Marshal(),
+		NS:         rec.NS,
+		KeyVersion: rec.KeyVersion,
+	}
+	if c.requestNonce {
+		req.Nonce = newRequestNonce()
 	}
 	return
 }
 
 // CheckResponseAndDecrypt verifies server's answer and extracts data encryption key on success
 func (c *Client) CheckResponseAndDecrypt(password []byte, rec *EnrollmentRecord, resp *VerifyPasswordResponse) (key []byte, err error) {
+	return c.CheckResponseAndDecryptContext(context.Background(), password, rec, resp)
+}
+
+// CheckResponseAndDecryptContext is like CheckResponseAndDecrypt, ctx carries the trace the login belongs to
+func (c *Client) CheckResponseAndDecryptContext(ctx context.Context, password []byte, rec *EnrollmentRecord, resp *VerifyPasswordResponse) (key []byte, err error) {
+	ctx, span := startSpan(ctx, c.tracer, "phe.Client.CheckResponseAndDecrypt")
+	defer func() {
+		if err == nil {
+			span.SetAttribute("phe.result", key != nil)
+		}
+		span.End(err)
+	}()
+	span.SetAttribute("phe.curve", c.curve.name)
+
+	m, err := c.checkResponse(ctx, password, rec, resp)
+	if err != nil || m == nil {
+		return nil, err
+	}
+	defer m.wipe()
+	return c.deriveKey(m), nil
+}
+
+// RotateAccountKey verifies server's answer like CheckResponseAndDecrypt and replaces account's data encryption key
+// with a fresh one. Password, nonces and T0 stay the same, only T1 changes.
+// oldKey must be used to decrypt existing ciphertexts which are then encrypted with newKey before newRec is stored
+func (c *Client) RotateAccountKey(password []byte, rec *EnrollmentRecord, resp *VerifyPasswordResponse) (newRec *EnrollmentRecord, oldKey, newKey []byte, err error) {
+	m, err := c.checkResponse(context.Background(), password, rec, resp)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if m == nil {
+		return nil, nil, nil, errors.New("invalid password")
+	}
+
+	t1, err := c.curve.pointUnmarshal(rec.T1)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	newM := c.randomM()
+	defer m.wipe()
+	defer newM.wipe()
+
+	y, release, err := c.privateKey()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer release()
+
+	// t1' = t1 * (m' ** y) * (m ** (-y))
+	minusY := c.curve.sf.Neg(y)
+	defer Wipe(minusY)
+	t1 = t1.Add(newM.ScalarMult(y)).Add(m.ScalarMult(minusY))
+
+	newRec = &EnrollmentRecord{
+		NS:         rec.NS,
+		NC:         rec.NC,
+		T0:         rec.T0,
+		T1:         t1.Marshal(),
+		KeyVersion: rec.KeyVersion,
+	}
+	c.sealRecord(newRec)
+
+	return newRec, c.deriveKey(m), c.deriveKey(newM), nil
+}
+
+// ChangePassword verifies oldPassword like RerandomizeRecord does and returns a record for newPassword bound to
+// the same encryption key, which is returned too. Data encrypted with the key stays readable.
+// enrollment is a fresh response from GetEnrollment, if it's nil the server nonce is kept
+func (c *Client) ChangePassword(oldPassword []byte, rec *EnrollmentRecord, resp *VerifyPasswordResponse, newPassword []byte, enrollment *EnrollmentResponse) (newRec *EnrollmentRecord, key []byte, err error) {
+	if err = c.checkPolicy(newPassword); err != nil {
+		return nil, nil, err
+	}
+	return c.reenroll(oldPassword, newPassword, rec, resp, enrollment)
+}
+
+// deriveKey derives account's encryption key with client's HKDF salt and info
+func (c *Client) deriveKey(m *Point) []byte {
+	return c.curve.deriveKey(m, c.keySalt, c.keyInfo)
+}
+
+// randomM generates account's encryption key in a form of a random point
+func (c *Client) randomM() *Point {
+	mBuf := make([]byte, 32)
+	defer Wipe(mBuf)
+	random.MustRead(mBuf)
+	return c.curve.hashToPoint(c.curve.dm, mBuf)
+}
+
+// checkResponse verifies server's answer and returns the point account's encryption key is derived from
+// m is nil if server proved the password is wrong
+func (c *Client) checkResponse(ctx context.Context, password []byte, rec *EnrollmentRecord, resp *VerifyPasswordResponse) (m *Point, err error) {
 
 	if resp == nil {
 		return nil, errors.New("invalid response")
 	}
 
-	t0, t1, err := rec.parse()
+	if err = c.checkRecordMAC(rec); err != nil {
+		c.logInvalidRecord(ctx, rec, err)
+		return nil, err
+	}
+
+	b := c.newBudget()
+
+	t0, t1, err := rec.parse(c.curve)
 	if err != nil {
+		c.logInvalidRecord(ctx, rec, err)
 		return nil, errors.New("invalid record")
 	}
 
-	c1, err := PointUnmarshal(resp.C1)
-	if err != nil {
+	if err = CheckKeyVersion(c.keyVersion, rec.KeyVersion); err != nil {
 		return nil, err
 	}
 
-	hc0 := hashToPoint(dhc0, rec.NC, password)
-	hc1 := hashToPoint(dhc1, rec.NC, password)
+	if password, err = c.hashPassword(password, rec.NC); err != nil {
+		return nil, err
+	}
+	defer c.wipeHashed(password)
+
+	start := time.Now()
+	defer func() {
+		c.publishVerification(rec.NS, m, err, start)
+	}()
+
+	// c1 which fails to parse is left nil and rejected together with the proof
+	c1, _ := c.curve.pointUnmarshal(resp.C1)
+
+	hc0 := c.hc(c.curve.dhc0, rec.NC, password)
+	hc1 := c.hc(c.curve.dhc1, rec.NC, password)
 
 	//c0 = t0 * (hc0 ** (-self.y))
 
-	minusY := gf.Neg(c.clientPrivateKey)
+	y, release, err := c.privateKey()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	minusY := c.curve.sf.Neg(y)
+	defer Wipe(minusY)
 
-	c0 := t0.Add(hc0.ScalarMultInt(minusY))
+	c0 := t0.Add(hc0.ScalarMult(minusY))
+
+	done := c.proofCheck(ctx)
 
 	if resp.Res {
 
-		if !c.validateProofOfSuccess(resp.ProofSuccess, rec.NS, c0, c1, c0.Marshal(), resp.C1) {
-			return nil, errors.New("result is ok but proof is invalid")
+		err = c.validateProofOfSuccess(b, resp.ProofSuccess, rec.NS, c0, c1, c0.Marshal(), resp.C1)
+		done(err)
+		if err != nil {
+			return nil, err
 		}
 
 		//return ((t1 * (c1 ** (-1))) * (hc1 ** (-self.y))) ** (self.y ** (-1))
 
-		m := (t1.Add(c1.Neg()).Add(hc1.ScalarMultInt(minusY))).ScalarMultInt(gf.Inv(c.clientPrivateKey))
-
-		kdf := hkdf.New(sha512.New512_256, m.Marshal(), nil, []byte("Secret"))
-		key = make([]byte, 32)
-		_, err = kdf.Read(key)
+		invY := c.curve.sf.Inv(y)
+		defer Wipe(invY)
+		m = (t1.Add(c1.Neg()).Add(hc1.ScalarMult(minusY))).ScalarMult(invY)
 
 		return
 
 	}
 
-	hs0 := hashToPoint(dhs0, rec.NS)
-	err = c.validateProofOfFail(resp, c0, c1, hs0, hc0, hc1)
+	hs0 := c.curve.hashToPoint(c.curve.dhs0, rec.NS)
+	err = c.validateProofOfFail(b, resp, c0, c1, hs0, hc0, hc1)
+	done(err)
 
 	return nil, err
 }
 
-func (c *Client) validateProofOfFail(resp *VerifyPasswordResponse, c0, c1, hs0, hc0, hc1 *Point) error {
-	term1, term2, term3, term4, blindA, blindB, err := resp.ProofFail.parse()
-	if err != nil {
-		return errors.New("invalid public key")
+// publishVerification publishes the outcome of checkResponse which started at start
+func (c *Client) publishVerification(ns []byte, m *Point, err error, start time.Time) {
+	if err == nil {
+		c.metrics.Verification(SourceClient, m != nil, time.Since(start))
+	}
+
+	switch {
+	case err != nil:
+		c.publishProofError(err)
+	case m != nil:
+		c.events.publish(EventVerified, SourceClient, func(h EventHeader) Event {
+			return &VerifiedEvent{EventHeader: h, NS: ns}
+		})
+	default:
+		c.events.publish(EventVerificationFailed, SourceClient, func(h EventHeader) Event {
+			return &VerificationFailedEvent{EventHeader: h, NS: ns}
+		})
+	}
+}
+
+// proofCheck traces, measures and logs a proof verification, the returned func ends it with its outcome
+func (c *Client) proofCheck(ctx context.Context) func(err error) {
+	start := time.Now()
+	_, span := startSpan(ctx, c.tracer, "phe.Client.VerifyProof")
+	return func(err error) {
+		c.metrics.ProofVerification(time.Since(start), err)
+		span.End(err)
+		if err != nil {
+			c.logger.LogAttrs(ctx, slog.LevelWarn, "phe: server proof rejected", errAttr(err),
+				slog.String("server_public_key", Fingerprint(c.serverPublicKeyBytes)))
+		}
+	}
+}
+
+// logInvalidRecord logs a record which couldn't be parsed
+func (c *Client) logInvalidRecord(ctx context.Context, rec *EnrollmentRecord, err error) {
+	attrs := []slog.Attr{errAttr(err)}
+	if rec != nil {
+		attrs = append(attrs, slog.String("ns", Fingerprint(rec.NS)))
+	}
+	c.logger.LogAttrs(ctx, slog.LevelWarn, "phe: invalid enrollment record", attrs...)
+}
+
+// publishProofError publishes EventProofInvalid if err is a rejected proof
+func (c *Client) publishProofError(err error) {
+	var pe *ProofError
+	if errors.As(err, &pe) {
+		c.events.publish(EventProofInvalid, SourceClient, func(h EventHeader) Event {
+			return &ProofInvalidEvent{EventHeader: h, Reason: pe.Reason}
+		})
+	}
+}
+
+// validateProofOfFail checks server's proof that the password is wrong, malformed proofs are handled
+// like in validateProofOfSuccess
+func (c *Client) validateProofOfFail(b *budget, resp *VerifyPasswordResponse, c0, c1, hs0, hc0, hc1 *Point) error {
+
+	if c.trustServer {
+		return c.checkTrusted(c0, c1)
 	}
 
-	challenge := hashZ(proofError, c.serverPublicKeyBytes, curveG.Marshal(), c0.Marshal(), resp.C1, resp.ProofFail.Term1, resp.ProofFail.Term2, resp.ProofFail.Term3, resp.ProofFail.Term4)
+	var reason ProofFailure
+	proof := resp.ProofFail
+	term1, term2, term3, term4, blindA, blindB, err := proof.parse(c.curve)
+
+	if err != nil || c1 == nil {
+		reason = ProofMalformed
+		term1, term2, term3, term4 = c.curve.g, c.curve.g, c.curve.g, c.curve.g
+		blindA, blindB = new(big.Int), new(big.Int)
+		c1 = c.curve.g
+		if proof == nil {
+			proof = &ProofOfFail{}
+		}
+	}
+
+	challenge := c.curve.hashZ(c.curve.proofError, c.serverPublicKeyBytes, c.curve.gBytes, c0.Marshal(), resp.C1, proof.Term1, proof.Term2, proof.Term3, proof.Term4)
 	//if term1 * term2 * (c1 ** challenge) != (c0 ** blind_a) * (hs0 ** blind_b):
 	//return False
 	//
 	//if term3 * term4 * (I ** challenge) != (self.X ** blind_a) * (self.G ** blind_b):
 	//return False
 
-	t1 := term1.Add(term2).Add(c1.ScalarMultInt(challenge))
-	t2 := c0.ScalarMultInt(blindA).Add(hs0.ScalarMultInt(blindB))
+	if err = b.check(); err != nil {
+		return err
+	}
+
+	ok1 := term1.Add(term2).Equal(c.curve.multiScalarMult([]*Point{c0, hs0, c1}, []*big.Int{blindA, blindB, c.curve.gf.Neg(challenge)}))
 
-	if !t1.Equal(t2) {
-		return errors.New("proof verification failed")
+	if err = b.check(); err != nil {
+		return err
 	}
 
-	t1 = term3.Add(term4)
-	t2 = c.serverPublicKey.ScalarMultInt(blindA).Add(new(Point).ScalarBaseMultInt(blindB))
+	ok2 := term3.Add(term4).Equal(c.keyMult(blindB, blindA))
+
+	if reason == 0 && !(ok1 && ok2) {
+		reason = ProofMismatch
+	}
 
-	if !t1.Equal(t2) {
-		return errors.New("verification failed")
+	if reason != 0 {
+		return &ProofError{Reason: reason}
 	}
 	return nil
 }
 
-// Rotate updates client's secret key and server's public key with server's update token
+// Rotate updates client's secret key and server's public key with server's update token.
+// Expired tokens and tokens issued for other keys are refused. The key the token leads to isn't checked,
+// see RotateChecked
+//
+// Deprecated: Rotate changes the client in place and races with requests using it concurrently, use RotateNew
 func (c *Client) Rotate(token *UpdateToken) error {
+	return c.rotateInPlace(token, nil, false)
+}
+
+// RotateChecked is like Rotate but newServerPublicKey is the public key the server has rotated to,
+// nothing changes and *RotationError is returned if the token doesn't lead to it. It changes the client in place
+// like Rotate does, RotateNew is the checked variant which doesn't
+func (c *Client) RotateChecked(token *UpdateToken, newServerPublicKey []byte) error {
+	return c.rotateInPlace(token, newServerPublicKey, true)
+}
 
-	a, b, err := token.parse()
+func (c *Client) rotateInPlace(token *UpdateToken, newServerPublicKey []byte, check bool) error {
+	pub, newY, err := c.rotate(token, newServerPublicKey, check)
 	if err != nil {
 		return err
 	}
 
-	c.clientPrivateKey = gf.Mul(c.clientPrivateKey, a)
-	c.clientPrivateKeyBytes = c.clientPrivateKey.Bytes()
-
-	pub := c.serverPublicKey.ScalarMultInt(a).Add(new(Point).ScalarBaseMultInt(b))
+	if c.enclave != nil {
+		err = c.enclave.seal(newY)
+		Wipe(newY)
+		if err != nil {
+			return err
+		}
+	} else {
+		c.clientPrivateKey = newY
+		c.clientPrivateKeyBytes = append([]byte{}, newY...)
+	}
 
 	c.serverPublicKey = pub
 	c.serverPublicKeyBytes = pub.Marshal()
+	c.keyVersion = token.NextVersion(c.keyVersion)
+	if c.precompute {
+		c.keyTable = newFixedBase(c.curve, pub)
+	}
+
+	c.rotated()
 	return nil
 }
 
-// UpdateRecord needs to be applied to every database record to correspond to new private and public keys
+// RotateNew returns a client for the rotated keys, see Rotate. c itself never changes,
+// so a single client can be shared by request handlers and swapped atomically once rotated
+func (c *Client) RotateNew(token *UpdateToken, newServerPublicKey []byte) (*Client, error) {
+	pub, newY, err := c.rotate(token, newServerPublicKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	rc := *c
+	if c.macKey != nil {
+		rc.macKey = append([]byte{}, c.macKey...)
+	}
+	if c.enclave != nil {
+		rc.enclave, err = newKeyEnclave(newY)
+		Wipe(newY)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		rc.clientPrivateKey = newY
+		rc.clientPrivateKeyBytes = append([]byte{}, newY...)
+	}
+
+	rc.serverPublicKey = pub
+	rc.serverPublicKeyBytes = pub.Marshal()
+	rc.keyVersion = token.NextVersion(rc.keyVersion)
+	if c.precompute {
+		rc.keyTable = newFixedBase(c.curve, pub)
+	}
+
+	rc.rotated()
+	return &rc, nil
+}
+
+// rotate checks the update token and computes the new server public key and client private key.
+// If check is set the new key must be newServerPublicKey
+func (c *Client) rotate(token *UpdateToken, newServerPublicKey []byte, check bool) (pub *Point, newY []byte, err error) {
+	a, b, err := token.parse(c.curve)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = token.CheckUse(c.serverPublicKeyBytes, c.keyVersion); err != nil {
+		return nil, nil, err
+	}
+
+	pub = c.serverPublicKey.ScalarMultInt(a).Add(c.curve.scalarBaseMult(b))
+	if check {
+		if err = checkRotation(c.serverPublicKeyBytes, pub, newServerPublicKey); err != nil {
+			c.logger.LogAttrs(context.Background(), slog.LevelWarn, "phe: rotation rejected", errAttr(err),
+				slog.String("server_public_key", Fingerprint(c.serverPublicKeyBytes)))
+			return nil, nil, err
+		}
+	}
+
+	y, release, err := c.privateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+	return pub, c.curve.sf.Mul(y, c.curve.scalarBytes(a)), nil
+}
+
+// rotated reports the rotation c has just been through
+func (c *Client) rotated() {
+	c.logger.LogAttrs(context.Background(), slog.LevelInfo, "phe: rotation applied",
+		slog.String("server_public_key", Fingerprint(c.serverPublicKeyBytes)), slog.Uint64("key_version", uint64(c.keyVersion)))
+	c.events.publish(EventRotated, SourceClient, func(h EventHeader) Event {
+		return &RotatedEvent{EventHeader: h, PublicKey: c.serverPublicKeyBytes}
+	})
+}
+
+// VerifyRotation checks that the update token turns client's current server public key into newServerPublicKey
+func (c *Client) VerifyRotation(newServerPublicKey []byte, token *UpdateToken) error {
+	return VerifyRotation(c.serverPublicKeyBytes, newServerPublicKey, token)
+}
+
+// VerifyRotation checks that the update token turns oldServerPublicKey into newServerPublicKey,
+// so that the key clients rotate to is the one derived from the key they trusted. It returns *RotationError if it doesn't
+func VerifyRotation(oldServerPublicKey, newServerPublicKey []byte, token *UpdateToken) error {
+	pub, err := PointUnmarshal(oldServerPublicKey)
+	if err != nil {
+		return err
+	}
+
+	a, b, err := token.parse(pub.curve)
+	if err != nil {
+		return err
+	}
+
+	return checkRotation(oldServerPublicKey, pub.ScalarMultInt(a).Add(pub.curve.scalarBaseMult(b)), newServerPublicKey)
+}
+
+// checkRotation compares the key an update token leads to with the expected one
+func checkRotation(oldPub []byte, rotated *Point, newPub []byte) error {
+	expected, err := rotated.curve.pointUnmarshal(newPub)
+	if err != nil || !expected.Equal(rotated) {
+		return &RotationError{
+			OldPublicKey: append([]byte{}, oldPub...),
+			NewPublicKey: append([]byte{}, newPub...),
+		}
+	}
+	return nil
+}
+
+// UpdateRecord needs to be applied to every database record to correspond to new private and public keys.
+// The record's MAC can't survive the update and is dropped, clients made with WithRecordMAC
+// reject such records, so they have to update them with Client.UpdateRecord instead.
+// Expired tokens and tokens issued for another key version than the record's are refused
+// so that a record is never updated twice or out of order
 func UpdateRecord(rec *EnrollmentRecord, token *UpdateToken) (updRec *EnrollmentRecord, err error) {
 
-	a, b, err := token.parse()
+	if rec == nil {
+		return nil, errors.New("invalid record")
+	}
+
+	curve, err := curveByPoint(rec.T0)
 	if err != nil {
 		return nil, err
 	}
 
-	t0, t1, err := rec.parse()
+	a, b, err := token.parse(curve)
 	if err != nil {
 		return nil, err
 	}
+	if err = token.CheckUse(nil, rec.KeyVersion); err != nil {
+		return nil, err
+	}
 
-	hs0 := hashToPoint(dhs0, rec.NS)
-	hs1 := hashToPoint(dhs1, rec.NS)
+	t0, t1, err := rec.parse(curve)
+	if err != nil {
+		return nil, err
+	}
+
+	hs0 := curve.hashToPoint(curve.dhs0, rec.NS)
+	hs1 := curve.hashToPoint(curve.dhs1, rec.NS)
 
 	t00 := t0.ScalarMultInt(a).Add(hs0.ScalarMultInt(b))
 	t11 := t1.ScalarMultInt(a).Add(hs1.ScalarMultInt(b))
 
 	updRec = &EnrollmentRecord{
-		T0: t00.Marshal(),
-		T1: t11.Marshal(),
-		NS: rec.NS,
-		NC: rec.NC,
+		T0:         t00.Marshal(),
+		T1:         t11.Marshal(),
+		NS:         rec.NS,
+		NC:         rec.NC,
+		// every rotation moves keys to the next version
+		KeyVersion: token.NextVersion(rec.KeyVersion),
 	}
+
+	DefaultEventBus.publish(EventRecordUpdated, SourceClient, func(h EventHeader) Event {
+		return &RecordUpdatedEvent{EventHeader: h, NS: rec.NS}
+	})
+	return
+}
+
+// UpdateRecords applies UpdateRecord to many records in parallel, see UpdateRecordsContext
+func UpdateRecords(records []*EnrollmentRecord, token *UpdateToken, opts ...BulkOption) ([]*EnrollmentRecord, error) {
+	return UpdateRecordsContext(context.Background(), records, token, opts...)
+}
+
+// UpdateRecordsContext updates records on the configured number of workers. A record which fails to update
+// doesn't stop the others: updated[i] is nil for it and a *BatchError with per-record errors is returned.
+// Processing stops early only if ctx is cancelled
+func UpdateRecordsContext(ctx context.Context, records []*EnrollmentRecord, token *UpdateToken, opts ...BulkOption) (updated []*EnrollmentRecord, err error) {
+	updated = make([]*EnrollmentRecord, len(records))
+
+	err = runEach(ctx, len(records), newBulkConfig(opts), func(i int) (err error) {
+		updated[i], err = UpdateRecord(records[i], token)
+		return
+	})
 	return
 }
 
 // RotateClientKeys returns a new pair of keys given old keys and an update token
 func RotateClientKeys(clientPrivate, serverPublic []byte, token *UpdateToken) (newClientPrivate, newServerPublic []byte, err error) {
-	a, b, err := token.parse()
+	pub, err := PointUnmarshal(serverPublic)
+
 	if err != nil {
 		return
 	}
 
-	pub, err := PointUnmarshal(serverPublic)
-
+	a, b, err := token.parse(pub.curve)
 	if err != nil {
 		return
 	}
+	if err = token.CheckUse(serverPublic, 0); err != nil {
+		return
+	}
 
-	if len(clientPrivate) == 0 {
+	y, err := pub.curve.parseScalar(clientPrivate)
+	if err != nil {
 		err = errors.New("invalid private key")
 		return
 	}
 
-	newClientPrivate = gf.MulBytes(clientPrivate, a).Bytes()
-	pub = pub.ScalarMultInt(a).Add(new(Point).ScalarBaseMultInt(b))
+	newClientPrivate = pub.curve.sf.Mul(pub.curve.scalarBytes(y), pub.curve.scalarBytes(a))
+	pub = pub.ScalarMultInt(a).Add(pub.curve.scalarBaseMult(b))
 	newServerPublic = pub.Marshal()
 	return
 }
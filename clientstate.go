@@ -0,0 +1,92 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+// Export serializes client's private key, server's public key and key version, e.g. to persist them after a rotation
+// with a single write. The blob contains the private key and must be stored as such.
+// Options are not exported and are given to ImportClient again
+func (c *Client) Export() ([]byte, error) {
+	y, release, err := c.privateKey()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	w := newBinaryWriter(binaryClientState)
+	w.bytes(y, c.serverPublicKeyBytes)
+	w.keyVersion(c.keyVersion)
+	return w, nil
+}
+
+// ImportClient restores a client exported with Export. Options given here are applied on top of the imported state
+func ImportClient(blob []byte, opts ...ClientOption) (*Client, error) {
+	r := newBinaryReader(blob, binaryClientState)
+	privateKey, serverPublicKey := r.bytes(), r.bytes()
+	defer Wipe(privateKey)
+	keyVersion := r.keyVersion()
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+
+	return NewClient(privateKey, serverPublicKey, append([]ClientOption{WithClientKeyVersion(keyVersion)}, opts...)...)
+}
+
+// Clone returns an independent copy of the client with the same keys and options,
+// e.g. one per worker so that rotating or wiping one doesn't affect the others
+func (c *Client) Clone() (*Client, error) {
+	cc := *c
+	cc.serverPublicKeyBytes = append([]byte{}, c.serverPublicKeyBytes...)
+	if c.macKey != nil {
+		cc.macKey = append([]byte{}, c.macKey...)
+	}
+	if c.enclave != nil {
+		y, release, err := c.privateKey()
+		if err != nil {
+			return nil, err
+		}
+		cc.enclave, err = newKeyEnclave(y)
+		release()
+		if err != nil {
+			return nil, err
+		}
+		return &cc, nil
+	}
+
+	cc.clientPrivateKey = append([]byte{}, c.clientPrivateKey...)
+	cc.clientPrivateKeyBytes = append([]byte{}, c.clientPrivateKeyBytes...)
+	return &cc, nil
+}
@@ -0,0 +1,51 @@
+package phe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_ExportImport(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	pub := mustPublicKey(t, serverKeypair)
+
+	for _, opts := range [][]ClientOption{{WithClientKeyVersion(3)}, {WithProtectedKey()}} {
+		c, err := NewClient(GenerateClientKey(), pub, opts...)
+		assert.NoError(t, err)
+		enrollment, err := GetEnrollment(serverKeypair)
+		assert.NoError(t, err)
+		enrollment.KeyVersion = c.KeyVersion()
+		rec, key, err := c.EnrollAccount(pwd, enrollment)
+		assert.NoError(t, err)
+
+		blob, err := c.Export()
+		assert.NoError(t, err)
+		imported, err := ImportClient(blob)
+		assert.NoError(t, err)
+		assert.Equal(t, c.KeyVersion(), imported.KeyVersion())
+		clone, err := c.Clone()
+		assert.NoError(t, err)
+
+		for _, cl := range []*Client{imported, clone} {
+			req, err := cl.CreateVerifyPasswordRequest(pwd, rec)
+			assert.NoError(t, err)
+			res, err := VerifyPassword(serverKeypair, req)
+			assert.NoError(t, err)
+			keyDec, err := cl.CheckResponseAndDecrypt(pwd, rec, res)
+			assert.NoError(t, err)
+			assert.Equal(t, key, keyDec)
+		}
+
+		// wiping the clone leaves the original intact
+		clone.Wipe()
+		again, err := c.Export()
+		assert.NoError(t, err)
+		assert.Equal(t, blob, again)
+	}
+
+	_, err := ImportClient([]byte{binaryVersion, binaryClientState, 0, 1})
+	assert.Error(t, err)
+	_, err = ImportClient(nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,306 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/pkg/errors"
+)
+
+// ceremony generates a server keypair, splits it with phe.SplitKeypair into shares written to separate files,
+// checks that every share takes part in a successful reassembly and optionally seals the keypair.
+// Everything custodians need to record is printed as a transcript
+func (e *env) ceremony(args []string) error {
+	fs := flag.NewFlagSet("ceremony", flag.ContinueOnError)
+	fs.SetOutput(e.stdout)
+	curveName := fs.String("curve", phe.P256().Name(), "curve of the server keypair")
+	n := fs.Int("shares", 5, "number of shares")
+	t := fs.Int("threshold", 3, "number of shares needed to reassemble the keypair")
+	out := fs.String("out", ".", "directory to write shares and the sealed keypair to")
+	passFile := fs.String("passphrase-file", "", "file with the passphrase to seal the keypair with, - for stdin. The keypair isn't sealed if empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("unexpected arguments")
+	}
+
+	curve, err := curveByName(*curveName)
+	if err != nil {
+		return err
+	}
+
+	var passphrase []byte
+	if *passFile != "" {
+		if passphrase, err = e.passphrase(*passFile); err != nil {
+			return err
+		}
+	}
+
+	kp, err := phe.GenerateServerKeypairForCurve(curve)
+	if err != nil {
+		return err
+	}
+	pub, err := phe.GetPublicKey(kp)
+	if err != nil {
+		return err
+	}
+
+	shares, err := phe.SplitKeypair(kp, *n, *t)
+	if err != nil {
+		return err
+	}
+
+	// every share must take part in at least one reassembly: t consecutive shares starting at each of them
+	checks := 0
+	for i := range shares {
+		subset := make([][]byte, *t)
+		for j := range subset {
+			subset[j] = shares[(i+j)%len(shares)]
+		}
+		got, err := phe.RecoverKeypair(subset)
+		if err != nil {
+			return errors.Wrap(err, "reassembly check failed")
+		}
+		if !bytes.Equal(got, kp) {
+			return errors.New("reassembly check failed")
+		}
+		checks++
+		if *t == *n {
+			break
+		}
+	}
+
+	fmt.Fprintf(e.stdout, "curve: %s\n", curve.Name())
+	fmt.Fprintf(e.stdout, "public key: %s\n", base64.StdEncoding.EncodeToString(pub))
+	fmt.Fprintf(e.stdout, "public key fingerprint: %s\n", fingerprint(pub))
+	fmt.Fprintf(e.stdout, "threshold: %d of %d\n", *t, *n)
+
+	for i, s := range shares {
+		name := filepath.Join(*out, fmt.Sprintf("share-%d-of-%d", i+1, *n))
+		if err = createFile(name, s); err != nil {
+			return err
+		}
+		fmt.Fprintf(e.stdout, "share %d: %s fingerprint %s\n", i+1, name, fingerprint(s))
+	}
+	fmt.Fprintf(e.stdout, "reassembly verified: %d combinations of %d shares\n", checks, *t)
+
+	if passphrase == nil {
+		return nil
+	}
+
+	sealed, err := phe.EncryptKeypair(kp, passphrase)
+	if err != nil {
+		return err
+	}
+	if opened, err := phe.DecryptKeypair(sealed, passphrase); err != nil || !bytes.Equal(opened, kp) {
+		return errors.New("sealed keypair check failed")
+	}
+
+	name := filepath.Join(*out, "keypair.sealed")
+	if err = createFile(name, sealed); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "sealed keypair: %s\n", name)
+	return nil
+}
+
+// combine reassembles shares with phe.RecoverKeypair, which checks the keypair against the public key they carry,
+// and seals it with phe.EncryptKeypair
+func (e *env) combine(args []string) error {
+	fs := flag.NewFlagSet("combine", flag.ContinueOnError)
+	fs.SetOutput(e.stdout)
+	passFile := fs.String("passphrase-file", "", "file with the passphrase to seal the keypair with, - for stdin")
+	out := fs.String("out", "keypair.sealed", "sealed keypair file to write, - for stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("no share files, use - to read them from stdin")
+	}
+
+	var shares [][]byte
+	for _, name := range fs.Args() {
+		s, err := e.readShares(name)
+		if err != nil {
+			return errors.Wrap(err, name)
+		}
+		shares = append(shares, s...)
+	}
+
+	passphrase, err := e.passphrase(*passFile)
+	if err != nil {
+		return err
+	}
+
+	kp, err := phe.RecoverKeypair(shares)
+	if err != nil {
+		return err
+	}
+	pub, err := phe.GetPublicKey(kp)
+	if err != nil {
+		return err
+	}
+
+	sealed, err := phe.EncryptKeypair(kp, passphrase)
+	if err != nil {
+		return err
+	}
+
+	if *out == "-" {
+		_, err = e.stdout.Write(sealed)
+		return err
+	}
+	if err = createFile(*out, sealed); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "public key fingerprint: %s\n", fingerprint(pub))
+	fmt.Fprintf(e.stdout, "sealed keypair: %s\n", *out)
+	return nil
+}
+
+// unseal prints the base64 keypair of a sealed keypair file, the form servers are configured with.
+// Any keypair encrypted by phe.EncryptKeypair can be unsealed
+func (e *env) unseal(args []string) error {
+	fs := flag.NewFlagSet("unseal", flag.ContinueOnError)
+	fs.SetOutput(e.stdout)
+	passFile := fs.String("passphrase-file", "", "file with the passphrase, - for stdin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("exactly one sealed keypair file expected")
+	}
+
+	data, err := e.readFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	passphrase, err := e.passphrase(*passFile)
+	if err != nil {
+		return err
+	}
+
+	kp, err := phe.DecryptKeypair(data, passphrase)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(e.stdout, base64.StdEncoding.EncodeToString(kp))
+	return err
+}
+
+// fingerprint prints public key fingerprints of share files, files with a base64 public key are fingerprinted as is.
+// Sealed keypairs don't carry the public key in the clear, unseal them first
+func (e *env) fingerprint(args []string) error {
+	if len(args) == 0 {
+		return errors.New("no files")
+	}
+
+	for _, name := range args {
+		data, err := e.readFile(name)
+		if err != nil {
+			return err
+		}
+
+		if shares := decodeShares(data); shares != nil {
+			if info, err := phe.ParseKeypairShare(shares[0]); err == nil {
+				fmt.Fprintf(e.stdout, "%s: public key %s share %d %s\n", name, fingerprint(info.PublicKey), info.Index, fingerprint(shares[0]))
+				continue
+			}
+		}
+
+		pub, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+		if err != nil {
+			return errors.Errorf("%s: not a share or base64 public key", name)
+		}
+		if _, err = phe.PointUnmarshal(pub); err != nil {
+			return errors.Wrap(err, name)
+		}
+		fmt.Fprintf(e.stdout, "%s: public key %s\n", name, fingerprint(pub))
+	}
+	return nil
+}
+
+// readShares reads shares made by phe.SplitKeypair from the named file or stdin, see decodeShares
+func (e *env) readShares(name string) ([][]byte, error) {
+	data, err := e.readFile(name)
+	if err != nil {
+		return nil, err
+	}
+	shares := decodeShares(data)
+	if shares == nil {
+		return nil, errors.New("no shares")
+	}
+	return shares, nil
+}
+
+// decodeShares returns the shares in data: a single share as written by ceremony, or base64 shares one per line
+// so that several of them can be pasted into stdin
+func decodeShares(data []byte) [][]byte {
+	var shares [][]byte
+	for _, line := range bytes.Fields(data) {
+		s, err := base64.StdEncoding.DecodeString(string(line))
+		if err != nil {
+			return [][]byte{data}
+		}
+		shares = append(shares, s)
+	}
+	if len(shares) == 0 && len(data) > 0 {
+		return [][]byte{data}
+	}
+	return shares
+}
+
+// createFile writes data to a new file readable by the owner only and refuses to overwrite existing ones
+func createFile(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
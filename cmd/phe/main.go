@@ -0,0 +1,174 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+// Command phe runs offline server key operations: a guided key ceremony splitting a fresh server keypair
+// into custodian shares in the format of phe.SplitKeypair, reassembly of shares into a keypair file sealed with a passphrase by phe.EncryptKeypair
+// and fingerprinting.
+// Secrets are read from files or stdin ("-") and never taken from arguments
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/pkg/errors"
+)
+
+// minPassphraseLen is the shortest passphrase keypairs are sealed with
+const minPassphraseLen = 12
+
+const usage = `usage: phe <command> [flags]
+
+commands:
+  ceremony     generate a server keypair, split it into shares and seal it
+  combine      reassemble shares into a sealed keypair
+  unseal       print the base64 keypair of a sealed keypair file
+  fingerprint  print the fingerprint of a share or public key file
+`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "phe:", err)
+		os.Exit(1)
+	}
+}
+
+// run dispatches a command, stdin and stdout are passed in so ceremonies can be scripted and tested
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(stdout, usage)
+		return errors.New("no command")
+	}
+
+	env := &env{stdin: stdin, stdout: stdout}
+	switch args[0] {
+	case "ceremony":
+		return env.ceremony(args[1:])
+	case "combine":
+		return env.combine(args[1:])
+	case "unseal":
+		return env.unseal(args[1:])
+	case "fingerprint":
+		return env.fingerprint(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return nil
+	}
+	return errors.Errorf("unknown command %q", args[0])
+}
+
+// env is the I/O of a single command run. stdin can be consumed only once,
+// so it's an error to read both the passphrase and a share from it
+type env struct {
+	stdin     io.Reader
+	stdout    io.Writer
+	stdinUsed bool
+}
+
+// open returns the named file or stdin for "-"
+func (e *env) open(name string) (io.ReadCloser, error) {
+	if name != "-" {
+		return os.Open(name)
+	}
+	if e.stdinUsed {
+		return nil, errors.New("stdin can only be read once")
+	}
+	e.stdinUsed = true
+	return io.NopCloser(e.stdin), nil
+}
+
+// readFile reads the named file or stdin for "-"
+func (e *env) readFile(name string) ([]byte, error) {
+	f, err := e.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// passphrase reads the first line of the named file or stdin
+func (e *env) passphrase(name string) ([]byte, error) {
+	if name == "" {
+		return nil, errors.New("passphrase file is required")
+	}
+	f, err := e.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	line = bytes.TrimRight(line, "\r\n")
+	if len(line) < minPassphraseLen {
+		return nil, errors.Errorf("passphrase must be at least %d characters", minPassphraseLen)
+	}
+	return line, nil
+}
+
+// fingerprint formats the first 16 bytes of SHA-256 of data as colon-separated groups,
+// short enough to be read aloud and compared during a ceremony
+func fingerprint(data []byte) string {
+	sum := sha256.Sum256(data)
+	h := hex.EncodeToString(sum[:16])
+	groups := make([]string, 0, len(h)/4)
+	for i := 0; i < len(h); i += 4 {
+		groups = append(groups, h[i:i+4])
+	}
+	return strings.Join(groups, ":")
+}
+
+var curves = []*phe.Curve{phe.P256(), phe.P384(), phe.P521(), phe.Secp256k1()}
+
+// curveByName returns a curve by the name Curve.Name reports
+func curveByName(name string) (*phe.Curve, error) {
+	for _, c := range curves {
+		if strings.EqualFold(c.Name(), name) {
+			return c, nil
+		}
+	}
+	return nil, errors.Errorf("unknown curve %q", name)
+}
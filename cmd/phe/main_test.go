@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPassphrase = "correct horse battery staple\n"
+
+func TestCeremony(t *testing.T) {
+	dir := t.TempDir()
+	passFile := filepath.Join(dir, "pass")
+	assert.NoError(t, os.WriteFile(passFile, []byte(testPassphrase), 0600))
+
+	out := &bytes.Buffer{}
+	err := run([]string{"ceremony", "-shares", "4", "-threshold", "2", "-out", dir, "-passphrase-file", passFile}, nil, out)
+	assert.NoError(t, err)
+	transcript := out.String()
+	assert.Contains(t, transcript, "reassembly verified: 4 combinations of 2 shares")
+
+	// unseal the keypair written by the ceremony
+	out.Reset()
+	err = run([]string{"unseal", "-passphrase-file", "-", filepath.Join(dir, "keypair.sealed")}, strings.NewReader(testPassphrase), out)
+	assert.NoError(t, err)
+	kp, err := base64.StdEncoding.DecodeString(strings.TrimSpace(out.String()))
+	assert.NoError(t, err)
+	s, err := phe.NewServer(kp)
+	assert.NoError(t, err)
+	assert.Contains(t, transcript, fingerprint(s.PublicKey()))
+
+	// any two shares reassemble the same keypair, shares are read from files and from stdin in base64
+	var stdin bytes.Buffer
+	for _, i := range []int{2, 4} {
+		data, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("share-%d-of-4", i)))
+		assert.NoError(t, err)
+		fmt.Fprintln(&stdin, base64.StdEncoding.EncodeToString(data))
+	}
+	sealed := filepath.Join(dir, "combined.sealed")
+	out.Reset()
+	err = run([]string{"combine", "-passphrase-file", passFile, "-out", sealed, "-"}, &stdin, out)
+	assert.NoError(t, err)
+
+	out.Reset()
+	err = run([]string{"unseal", "-passphrase-file", passFile, sealed}, nil, out)
+	assert.NoError(t, err)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(kp), strings.TrimSpace(out.String()))
+
+	out.Reset()
+	err = run([]string{"combine", "-passphrase-file", passFile, "-out", filepath.Join(dir, "files.sealed"),
+		filepath.Join(dir, "share-1-of-4"), filepath.Join(dir, "share-3-of-4")}, nil, out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), fingerprint(s.PublicKey()))
+
+	// shares are the ones of the library both ways
+	var files [][]byte
+	for _, i := range []int{1, 4} {
+		data, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("share-%d-of-4", i)))
+		assert.NoError(t, err)
+		files = append(files, data)
+	}
+	recovered, err := phe.RecoverKeypair(files)
+	assert.NoError(t, err)
+	assert.Equal(t, kp, recovered)
+
+	libShares, err := phe.SplitKeypair(kp, 3, 2)
+	assert.NoError(t, err)
+	for i, share := range libShares[1:] {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, fmt.Sprintf("lib-%d", i)), share, 0600))
+	}
+	out.Reset()
+	err = run([]string{"combine", "-passphrase-file", passFile, "-out", filepath.Join(dir, "lib-shares.sealed"),
+		filepath.Join(dir, "lib-0"), filepath.Join(dir, "lib-1")}, nil, out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), fingerprint(s.PublicKey()))
+
+	// sealed keypairs are the ones of the library
+	data, err := os.ReadFile(sealed)
+	assert.NoError(t, err)
+	opened, err := phe.DecryptKeypair(data, []byte(strings.TrimSpace(testPassphrase)))
+	assert.NoError(t, err)
+	assert.Equal(t, kp, opened)
+
+	encrypted, err := phe.EncryptKeypair(kp, []byte(strings.TrimSpace(testPassphrase)))
+	assert.NoError(t, err)
+	libSealed := filepath.Join(dir, "lib.sealed")
+	assert.NoError(t, os.WriteFile(libSealed, encrypted, 0600))
+	out.Reset()
+	err = run([]string{"unseal", "-passphrase-file", passFile, libSealed}, nil, out)
+	assert.NoError(t, err)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(kp), strings.TrimSpace(out.String()))
+
+	pubFile := filepath.Join(dir, "pub")
+	assert.NoError(t, os.WriteFile(pubFile, []byte(base64.StdEncoding.EncodeToString(s.PublicKey())), 0600))
+	out.Reset()
+	err = run([]string{"fingerprint", pubFile, filepath.Join(dir, "share-1-of-4")}, nil, out)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(out.String(), fingerprint(s.PublicKey())))
+	assert.Error(t, run([]string{"fingerprint", sealed}, nil, out))
+
+	// one share is not enough, existing files aren't overwritten
+	err = run([]string{"combine", "-passphrase-file", passFile, "-out", filepath.Join(dir, "x"), filepath.Join(dir, "share-1-of-4")}, nil, out)
+	assert.Error(t, err)
+	err = run([]string{"ceremony", "-shares", "4", "-threshold", "2", "-out", dir}, nil, out)
+	assert.Error(t, err)
+
+	// wrong passphrase
+	err = run([]string{"unseal", "-passphrase-file", "-", sealed}, strings.NewReader("not the passphrase"), out)
+	assert.Error(t, err)
+}
+
+func TestRun_Invalid(t *testing.T) {
+	out := &bytes.Buffer{}
+	assert.Error(t, run(nil, nil, out))
+	assert.Error(t, run([]string{"nope"}, nil, out))
+	assert.Error(t, run([]string{"ceremony", "-curve", "P-224", "-out", t.TempDir()}, nil, out))
+	assert.Error(t, run([]string{"ceremony", "-shares", "2", "-threshold", "3", "-out", t.TempDir()}, nil, out))
+	// passphrase and shares can't both come from stdin
+	assert.Error(t, run([]string{"combine", "-passphrase-file", "-", "-"}, strings.NewReader(testPassphrase), out))
+}
@@ -0,0 +1,294 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+
+	"github.com/passw0rd/phe-go/internal/wire"
+	"github.com/pkg/errors"
+)
+
+// CompactRecordVersion is the version byte records are packed with. Records packed by earlier versions
+// are still read and are upgraded when they're written again, see UpgradeCompactRecord
+const CompactRecordVersion = 2
+
+// compactRecordV1 is the first packed layout, it's the current one without extension fields
+const compactRecordV1 = 1
+
+// compactCurves assigns packed record curve IDs, the ID is the index + 1. Only append to it
+var compactCurves = []*Curve{p256, p384, p521, secp256K1}
+
+var errCompactRecord = errors.New("invalid compact record")
+
+// MarshalCompactRecord packs a record into a single blob:
+// version | curve ID | len(NS) | len(NC) | NS | NC | compressed T0 | compressed T1 | extensions.
+// Extensions are optional fields added after the first version, each one is tag | len | value in ascending tag order.
+// With 32-byte nonces every field is at a fixed offset and a P-256 record without extensions takes 134 bytes
+func MarshalCompactRecord(rec *EnrollmentRecord) ([]byte, error) {
+	return marshalCompactRecord(rec, CompactRecordVersion)
+}
+
+// marshalCompactRecord packs a record with the layout of the given version
+func marshalCompactRecord(rec *EnrollmentRecord, version byte) ([]byte, error) {
+	if rec == nil {
+		return nil, errors.New("invalid record")
+	}
+
+	curve, err := curveByPoint(rec.T0)
+	if err != nil {
+		return nil, err
+	}
+
+	t0, t1, err := rec.parse(curve)
+	if err != nil {
+		return nil, err
+	}
+
+	id := 0
+	for i, c := range compactCurves {
+		if c == curve {
+			id = i + 1
+		}
+	}
+
+	// two compressed points take pointLen + 1 bytes
+	blob := make([]byte, 0, 4+len(rec.NS)+len(rec.NC)+curve.pointLen+1)
+	blob = append(blob, version, byte(id), byte(len(rec.NS)), byte(len(rec.NC)))
+	blob = append(blob, rec.NS...)
+	blob = append(blob, rec.NC...)
+	blob = append(blob, t0.MarshalCompressed()...)
+	blob = append(blob, t1.MarshalCompressed()...)
+
+	ext := rec.compactExtensions()
+	if version == compactRecordV1 && len(ext) > 0 {
+		return nil, errors.New("record can't be packed with version 1")
+	}
+	for _, e := range ext {
+		blob = append(blob, e.tag, byte(len(e.value)))
+		blob = append(blob, e.value...)
+	}
+	return blob, nil
+}
+
+// compactExtension is an optional field of a packed record
+type compactExtension struct {
+	tag   byte
+	value []byte
+}
+
+const (
+	// compactKeyVersion holds a non-zero key version as a 4-byte big-endian integer
+	compactKeyVersion byte = 1
+	// compactMAC holds the record MAC
+	compactMAC byte = 2
+)
+
+// compactExtensions returns the record's optional fields in ascending tag order
+func (c *EnrollmentRecord) compactExtensions() []compactExtension {
+	var ext []compactExtension
+	if c.KeyVersion != 0 {
+		ext = append(ext, compactExtension{tag: compactKeyVersion, value: binary.BigEndian.AppendUint32(nil, c.KeyVersion)})
+	}
+	if c.MAC != nil {
+		ext = append(ext, compactExtension{tag: compactMAC, value: c.MAC})
+	}
+	return ext
+}
+
+// setCompactExtension sets an optional field of a record being unpacked
+func (c *EnrollmentRecord) setCompactExtension(e compactExtension) error {
+	switch e.tag {
+	case compactKeyVersion:
+		if len(e.value) != 4 || binary.BigEndian.Uint32(e.value) == 0 {
+			return errors.New("invalid key version")
+		}
+		c.KeyVersion = binary.BigEndian.Uint32(e.value)
+		return nil
+	case compactMAC:
+		if len(e.value) != RecordMACSize {
+			return errors.New("invalid record mac")
+		}
+		c.MAC = e.value
+		return nil
+	}
+	return errors.Errorf("unknown record field %d", e.tag)
+}
+
+// UnmarshalCompactRecord unpacks a blob produced by MarshalCompactRecord of this or an earlier version
+func UnmarshalCompactRecord(blob []byte) (*EnrollmentRecord, error) {
+	if len(blob) < 4 || blob[0] < compactRecordV1 || blob[0] > CompactRecordVersion {
+		return nil, errCompactRecord
+	}
+
+	id := int(blob[1])
+	if id < 1 || id > len(compactCurves) {
+		return nil, errCompactRecord
+	}
+	curve := compactCurves[id-1]
+
+	nsLen, ncLen := int(blob[2]), int(blob[3])
+	pointLen := (curve.pointLen + 1) / 2
+	size := 4 + nsLen + ncLen + 2*pointLen
+	if wire.MaxNonceLen < nsLen || wire.MaxNonceLen < ncLen || len(blob) < size ||
+		(blob[0] == compactRecordV1 && len(blob) != size) {
+		return nil, errCompactRecord
+	}
+
+	data := blob[4:]
+	ns, data := data[:nsLen], data[nsLen:]
+	nc, data := data[:ncLen], data[ncLen:]
+
+	t0, err := curve.pointUnmarshalCompressed(data[:pointLen])
+	if err != nil {
+		return nil, errCompactRecord
+	}
+
+	t1, err := curve.pointUnmarshalCompressed(data[pointLen : 2*pointLen])
+	if err != nil {
+		return nil, errCompactRecord
+	}
+
+	rec := &EnrollmentRecord{
+		NS: append([]byte{}, ns...),
+		NC: append([]byte{}, nc...),
+		T0: t0.Marshal(),
+		T1: t1.Marshal(),
+	}
+
+	if _, _, err = rec.parse(curve); err != nil {
+		return nil, errCompactRecord
+	}
+
+	ext := data[2*pointLen:]
+	for last := -1; len(ext) > 0; {
+		if len(ext) < 2 || len(ext) < 2+int(ext[1]) || int(ext[0]) <= last {
+			return nil, errCompactRecord
+		}
+		e := compactExtension{tag: ext[0], value: append([]byte{}, ext[2:2+int(ext[1])]...)}
+		if err = rec.setCompactExtension(e); err != nil {
+			return nil, errors.Wrap(err, errCompactRecord.Error())
+		}
+		last, ext = int(e.tag), ext[2+int(ext[1]):]
+	}
+	return rec, nil
+}
+
+// CompactRecordVersionOf returns the version a blob was packed with
+func CompactRecordVersionOf(blob []byte) (int, error) {
+	if len(blob) == 0 || blob[0] < compactRecordV1 || blob[0] > CompactRecordVersion {
+		return 0, errCompactRecord
+	}
+	return int(blob[0]), nil
+}
+
+// UpgradeCompactRecord re-packs a blob of an earlier version with the current one.
+// Blobs which already are of the current version are returned as they are with upgraded set to false
+func UpgradeCompactRecord(blob []byte) (upgradedBlob []byte, upgraded bool, err error) {
+	rec, err := UnmarshalCompactRecord(blob)
+	if err != nil {
+		return nil, false, err
+	}
+	if blob[0] == CompactRecordVersion {
+		return blob, false, nil
+	}
+
+	upgradedBlob, err = CompactRecord(rec)
+	if err != nil {
+		return nil, false, err
+	}
+	return upgradedBlob, true, nil
+}
+
+// CompactRecordEncodings returns every blob the record may be stored as, the current version first.
+// Stores use them to compare a stored blob with a record when the blob may not have been upgraded yet
+func CompactRecordEncodings(rec *EnrollmentRecord) ([][]byte, error) {
+	blob, err := MarshalCompactRecord(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	blobs := [][]byte{blob}
+	if v1, err := marshalCompactRecord(rec, compactRecordV1); err == nil {
+		blobs = append(blobs, v1)
+	}
+	return blobs, nil
+}
+
+// CompactRecord re-encodes a stored record into the newest, smallest encoding. The blob is accepted only if it
+// decodes back to the same record and decoding and encoding it again yields the same blob
+func CompactRecord(rec *EnrollmentRecord) ([]byte, error) {
+	blob, err := MarshalCompactRecord(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := UnmarshalCompactRecord(blob)
+	if err != nil {
+		return nil, errors.Wrap(err, "round trip failed")
+	}
+
+	if !decoded.Equal(rec) {
+		return nil, errors.New("round trip failed: record changed")
+	}
+
+	again, err := MarshalCompactRecord(decoded)
+	if err != nil || !bytes.Equal(again, blob) {
+		return nil, errors.New("round trip failed: encoding is not stable")
+	}
+
+	return blob, nil
+}
+
+// CompactRecords compacts many records in parallel, see CompactRecordsContext
+func CompactRecords(records []*EnrollmentRecord, opts ...BulkOption) ([][]byte, error) {
+	return CompactRecordsContext(context.Background(), records, opts...)
+}
+
+// CompactRecordsContext applies CompactRecord to every record. A record which fails to compact doesn't stop
+// the others: blobs[i] is nil for it and a *BatchError with per-record errors is returned
+func CompactRecordsContext(ctx context.Context, records []*EnrollmentRecord, opts ...BulkOption) (blobs [][]byte, err error) {
+	blobs = make([][]byte, len(records))
+
+	err = runEach(ctx, len(records), newBulkConfig(opts), func(i int) (err error) {
+		blobs[i], err = CompactRecord(records[i])
+		return
+	})
+	return
+}
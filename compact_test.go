@@ -0,0 +1,101 @@
+package phe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompactRecord(t *testing.T) {
+	for _, curve := range curves {
+		serverKeypair, err := GenerateServerKeypairForCurve(curve)
+		assert.NoError(t, err)
+		pub, err := GetPublicKey(serverKeypair)
+		assert.NoError(t, err)
+		c, err := NewClient(GenerateClientKeyForCurve(curve), pub)
+		assert.NoError(t, err)
+
+		enrollment, err := GetEnrollment(serverKeypair)
+		assert.NoError(t, err)
+		rec, _, err := c.EnrollAccount(pwd, enrollment)
+		assert.NoError(t, err)
+
+		blob, err := CompactRecord(rec)
+		assert.NoError(t, err)
+		assert.Len(t, blob, 4+64+curve.pointLen+1)
+
+		decoded, err := UnmarshalCompactRecord(blob)
+		assert.NoError(t, err)
+		assert.Equal(t, rec, decoded)
+
+		//the record still works with server's key
+		req, err := c.CreateVerifyPasswordRequest(pwd, decoded)
+		assert.NoError(t, err)
+		resp, err := VerifyPassword(serverKeypair, req)
+		assert.NoError(t, err)
+		assert.True(t, resp.Res)
+
+		_, err = UnmarshalCompactRecord(blob[:len(blob)-1])
+		assert.Error(t, err)
+
+		blob[1] = byte(len(compactCurves) + 1)
+		_, err = UnmarshalCompactRecord(blob)
+		assert.Error(t, err)
+	}
+}
+
+func TestCompactRecords(t *testing.T) {
+	c, statements := makeStatements(t, P256(), 1)
+	rec, _, err := c.EnrollAccount(pwd, &EnrollmentResponse{NS: statements[0].NS, C0: statements[0].C0, C1: statements[0].C1, Proof: statements[0].Proof})
+	assert.NoError(t, err)
+
+	blobs, err := CompactRecords([]*EnrollmentRecord{rec, {NS: rec.NS}})
+	assert.IsType(t, &BatchError{}, err)
+	assert.Len(t, blobs[0], 134)
+	assert.Nil(t, blobs[1])
+}
+
+func TestCompactRecord_Versions(t *testing.T) {
+	c, statements := makeStatements(t, P256(), 1)
+	rec, _, err := c.EnrollAccount(pwd, &EnrollmentResponse{NS: statements[0].NS, C0: statements[0].C0, C1: statements[0].C1, Proof: statements[0].Proof})
+	assert.NoError(t, err)
+
+	encodings, err := CompactRecordEncodings(rec)
+	assert.NoError(t, err)
+	assert.Len(t, encodings, 2)
+	blob, v1 := encodings[0], encodings[1]
+
+	version, err := CompactRecordVersionOf(v1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, version)
+	decoded, err := UnmarshalCompactRecord(v1)
+	assert.NoError(t, err)
+	assert.Equal(t, rec, decoded)
+
+	upgraded, ok, err := UpgradeCompactRecord(v1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, blob, upgraded)
+	version, err = CompactRecordVersionOf(upgraded)
+	assert.NoError(t, err)
+	assert.Equal(t, CompactRecordVersion, version)
+
+	same, ok, err := UpgradeCompactRecord(blob)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, blob, same)
+
+	//version 1 has no extensions, unknown extensions and future versions are rejected
+	_, err = UnmarshalCompactRecord(append(v1, 1, 0))
+	assert.Error(t, err)
+	_, err = UnmarshalCompactRecord(append(append([]byte{}, blob...), 200, 1, 0))
+	assert.Error(t, err)
+	_, err = UnmarshalCompactRecord(append(append([]byte{}, blob...), 200))
+	assert.Error(t, err)
+	future := append([]byte{}, blob...)
+	future[0] = CompactRecordVersion + 1
+	_, err = UnmarshalCompactRecord(future)
+	assert.Error(t, err)
+	_, err = CompactRecordVersionOf(future)
+	assert.Error(t, err)
+}
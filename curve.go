@@ -0,0 +1,337 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"crypto/elliptic"
+	"crypto/sha512"
+	"hash"
+	"io"
+	"math/big"
+	"sync"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/passw0rd/phe-go/internal/wire"
+	"github.com/passw0rd/phe-go/swu"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Curve is a set of parameters PHE protocol is instantiated with
+type Curve struct {
+	name       string
+	ec         elliptic.Curve
+	a          *big.Int //coefficient of x in curve's equation, needed to decompress points
+	swu        mapper
+	gf         *swu.GF
+	sf         *scalarField
+	msm        func(points []*Point, scalars []*big.Int) (x, y *big.Int)
+	msmMin     int // shorter sums are multiplied term by term
+	field      *nistField
+	gTable     *fixedBase
+	gTableOnce sync.Once
+	hash       func() hash.Hash
+	scalarLen  int
+	pointLen   int
+	keyLen     int
+	g          *Point
+	gBytes     []byte
+
+	//domains
+	dhc0       []byte
+	dhc1       []byte
+	dhs0       []byte
+	dhs1       []byte
+	dm         []byte
+	proofOk    []byte
+	proofError []byte
+	secret     []byte
+}
+
+// mapper deterministically maps hashes of Size() bytes to curve points
+type mapper interface {
+	Size() int
+	HashToPoint(hash []byte) (x, y *big.Int)
+}
+
+var (
+	p256 = newCurve("P-256", elliptic.P256(), nil, sha512.New512_256, nil)
+	p384 = newCurve("P-384", elliptic.P384(), nil, sha512.New384, []byte("P-384"))
+	p521 = newCurve("P-521", elliptic.P521(), nil, sha512.New, []byte("P-521"))
+
+	k256Curve = k256{secp256k1.S256()}
+	secp256K1 = newCurve("secp256k1", k256Curve, swu.NewSvdW(k256Curve, big.NewInt(0)), sha512.New512_256, []byte("secp256k1"))
+
+	//curves sharing point length are tried in this order
+	curves = []*Curve{p256, p384, p521, secp256K1}
+)
+
+// P256 returns the default NIST P-256 parameter set
+func P256() *Curve {
+	return p256
+}
+
+// P384 returns NIST P-384 parameter set for deployments which require higher security level
+func P384() *Curve {
+	return p384
+}
+
+// P521 returns NIST P-521 parameter set
+func P521() *Curve {
+	return p521
+}
+
+// Secp256k1 returns secp256k1 parameter set for infrastructure built around that curve
+func Secp256k1() *Curve {
+	return secp256K1
+}
+
+// newCurve builds a parameter set. P-256 uses no domain prefix to stay compatible with existing records
+// Simplified SWU is used for hashing to curve unless another mapper is given
+func newCurve(name string, ec elliptic.Curve, m mapper, h func() hash.Hash, prefix []byte) *Curve {
+	if m == nil {
+		m = swu.New(ec)
+	}
+
+	c := &Curve{
+		name:      name,
+		ec:        ec,
+		swu:       m,
+		gf:        &swu.GF{P: ec.Params().N},
+		sf:        newScalarField(ec.Params().N),
+		hash:      h,
+		scalarLen: (ec.Params().N.BitLen() + 7) / 8,
+		pointLen:  1 + 2*((ec.Params().BitSize+7)/8),
+		keyLen:    h().Size(),
+	}
+
+	tag := func(d string) []byte {
+		return append(append([]byte{}, prefix...), d...)
+	}
+
+	c.dhc0 = tag("hc0")
+	c.dhc1 = tag("hc1")
+	c.dhs0 = tag("hs0")
+	c.dhs1 = tag("hs1")
+	c.dm = tag("m")
+	c.proofOk = tag("ProofOk")
+	c.proofError = tag("ProofError")
+	c.secret = tag("Secret")
+
+	if _, ok := ec.(k256); !ok {
+		// crypto/elliptic multiplies on P-256 in assembly, so Strauss' method in Go only wins on longer sums there
+		c.msm, c.msmMin = nistMultiScalarMult(ec), 2
+		if ec == elliptic.P256() {
+			c.msmMin = 8
+		}
+		// the field's point formulas assume a = -3, secp256k1 has a = 0
+		c.field = newNISTField(ec.Params().P)
+	}
+
+	c.a = curveA(ec.Params())
+	c.g = &Point{X: ec.Params().Gx, Y: ec.Params().Gy, curve: c}
+	c.gBytes = elliptic.Marshal(ec, c.g.X, c.g.Y)
+	return c
+}
+
+// curveA finds a in y² = x³ + a·x + b from the generator: a = (Gy² - Gx³ - b) / Gx
+func curveA(params *elliptic.CurveParams) *big.Int {
+	p := params.P
+	a := new(big.Int).Mul(params.Gy, params.Gy)
+	a.Sub(a, new(big.Int).Exp(params.Gx, big.NewInt(3), p))
+	a.Sub(a, params.B)
+	a.Mul(a, new(big.Int).ModInverse(params.Gx, p))
+	return a.Mod(a, p)
+}
+
+// Name returns curve name
+func (c *Curve) Name() string {
+	return c.name
+}
+
+// curveByPoint picks the first parameter set the marshaled point is valid on
+func curveByPoint(data []byte) (*Curve, error) {
+	for _, c := range curves {
+		if _, err := c.pointUnmarshal(data); err == nil {
+			return c, nil
+		}
+	}
+	return nil, errors.New("Invalid curve point")
+}
+
+// randomZ generates big random integer which must be less than curve's N parameter
+func (c *Curve) randomZ() (z *big.Int) {
+	return c.makeZ(random)
+}
+
+// randomScalar generates a random scalar of scalarLen bytes for use with sf
+func (c *Curve) randomScalar() []byte {
+	return c.scalarBytes(c.randomZ())
+}
+
+// hashZ maps arrays of bytes to an integer less than curve's N parameter
+func (c *Curve) hashZ(domain []byte, data ...[]byte) (z *big.Int) {
+	return c.makeZ(tupleKDF(c.hash, data, domain))
+}
+
+// makeZ reads integers from reader until one is less than curve's N parameter
+func (c *Curve) makeZ(reader io.Reader) *big.Int {
+	n := c.ec.Params().N
+	buf := make([]byte, c.scalarLen)
+	excess := uint(c.scalarLen*8 - n.BitLen())
+	for {
+		_, err := io.ReadFull(reader, buf)
+		if err != nil {
+			panic(err)
+		}
+		buf[0] &= 0xFF >> excess
+
+		z := new(big.Int).SetBytes(buf)
+		// If the scalar is out of range, sample another number.
+		if z.Cmp(n) < 0 {
+			return z
+		}
+	}
+}
+
+// hashToPoint maps arrays of bytes to a valid curve point
+func (c *Curve) hashToPoint(domain []byte, data ...[]byte) *Point {
+	hash := tupleHash(c.hash, data, domain)
+	if len(hash) != c.swu.Size() {
+		prk := hash
+		hash = make([]byte, c.swu.Size())
+		if _, err := io.ReadFull(hkdf.Expand(c.hash, prk, domain), hash); err != nil {
+			panic(err)
+		}
+	}
+	x, y := c.swu.HashToPoint(hash)
+	return &Point{X: x, Y: y, curve: c}
+}
+
+// deriveKey derives account encryption key from the secret point m. info is appended to the curve's own info
+func (c *Curve) deriveKey(m *Point, salt, info []byte) []byte {
+	secret := m.Marshal()
+	defer Wipe(secret)
+	kdf := hkdf.New(c.hash, secret, salt, append(append([]byte{}, c.secret...), info...))
+	key := make([]byte, c.keyLen)
+	if _, err := io.ReadFull(kdf, key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// scalarBaseMult multiplies base point to a number
+func (c *Curve) scalarBaseMult(k *big.Int) *Point {
+	x, y := c.ec.ScalarBaseMult(c.fixedScalar(k))
+	return &Point{X: x, Y: y, curve: c}
+}
+
+// scalarBaseMultBytes multiplies base point to a fixed-width scalar
+func (c *Curve) scalarBaseMultBytes(k []byte) *Point {
+	x, y := c.ec.ScalarBaseMult(k)
+	return &Point{X: x, Y: y, curve: c}
+}
+
+// multiScalarMult computes the sum of points multiplied to scalars. It may run in variable time and
+// must only be used for public values such as proof verification.
+// Sums shorter than the curve's msmMin are multiplied term by term, using base point table for c.g.
+// Clients made with WithPrecomputedKey use tables for server's public key instead, see Client.keyMult
+func (c *Curve) multiScalarMult(points []*Point, scalars []*big.Int) *Point {
+	if c.msm != nil && len(points) >= c.msmMin {
+		x, y := c.msm(points, scalars)
+		return &Point{X: x, Y: y, curve: c}
+	}
+
+	var sum *Point
+	for i, p := range points {
+		var t *Point
+		if p == c.g {
+			t = c.scalarBaseMult(scalars[i])
+		} else {
+			t = p.ScalarMultInt(scalars[i])
+		}
+
+		if sum == nil {
+			sum = t
+		} else {
+			sum = sum.Add(t)
+		}
+	}
+	return sum
+}
+
+// pointUnmarshal validates & converts byte array to a point on this curve
+func (c *Curve) pointUnmarshal(data []byte) (*Point, error) {
+	x, y, err := wire.Point(c.ec, data)
+	if err != nil {
+		return nil, err
+	}
+	return &Point{X: x, Y: y, curve: c}, nil
+}
+
+// PointUnmarshalCompressed decodes a SEC 1 compressed point on this curve
+func (c *Curve) PointUnmarshalCompressed(data []byte) (*Point, error) {
+	return c.pointUnmarshalCompressed(data)
+}
+
+// pointUnmarshalCompressed decodes a compressed point on this curve
+func (c *Curve) pointUnmarshalCompressed(data []byte) (*Point, error) {
+	x, y, err := wire.CompressedPoint(c.ec, c.a, data)
+	if err != nil {
+		return nil, err
+	}
+	return &Point{X: x, Y: y, curve: c}, nil
+}
+
+// scalarBytes serializes an integer into exactly scalarLen big-endian bytes
+func (c *Curve) scalarBytes(z *big.Int) []byte {
+	return z.FillBytes(make([]byte, c.scalarLen))
+}
+
+// fixedScalar encodes a multiplier into scalarLen bytes so its length doesn't depend on its value.
+// NIST curves multiply in constant time in crypto/elliptic, secp256k1 is protected by k256
+func (c *Curve) fixedScalar(k *big.Int) []byte {
+	if k.Sign() < 0 || k.Cmp(c.ec.Params().N) >= 0 {
+		k = new(big.Int).Mod(k, c.ec.Params().N)
+	}
+	return c.scalarBytes(k)
+}
+
+// parseScalar converts non-empty byte array of at most scalarLen bytes to an integer less than curve's N parameter
+func (c *Curve) parseScalar(b []byte) (*big.Int, error) {
+	return wire.Scalar(b, c.scalarLen, c.ec.Params().N)
+}
@@ -0,0 +1,229 @@
+package phe
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var curveVectors = []struct {
+	curve *Curve
+	point string
+	z     string
+}{
+	{
+		curve: P256(),
+		point: "040dbd42867303eb44f8a9d8ea01bfeaef0a2c5b43db44850dc2bdefc8c9adee745cae9226d18ecf52761056254e53d0b49954ed0923eb011da57424a3858ae6b6",
+		z:     "06758d7e910fbb25b594deb8d6a6e0d8356a53c9c6180879209af73ede5ae465",
+	},
+	{
+		curve: P384(),
+		point: "041c0e71eeab5a8b076ac7e348809274e1deb2c79afb3265904abf42749feafcb70192364a2f83e32d21dc64041c914f88a1e0a8c5914f56f4207231fcaa731fef727eb9782c0db46e3f1688a4f2f11af27debf1021576b8a02961305d7b4ece16",
+		z:     "009b63cc4b71972a7e2c477a13e0104be0cb98319e4fe4f27e4c9be83b655745134b97827e838bf5f5e373b7fea62854",
+	},
+	{
+		curve: P521(),
+		point: "0400d0bb24e638f8f7ddc348cf9c2b909c2f1b0e5c3c6257ec2134d302d114606bc9c328e03b80aa173e527718931203d5740359fe95d67162c94ac1d7e440a55f0ee10120170f2a6d6ca88b623ba3ce3a7128139e3c4668a28ebd568700c73b1af9c86beafaa40f8a670606d54f0d277c07d3f8f49b1d928d1c2f3b112cfe4ba0de17797c",
+		z:     "011215808239184ec6996e8ba442c3d56746ea4fca8e468473010f30d094fcf1bf8896f31e0bc51b67e6a3c38c2a2cd8dd58625fec3bf2695a39d95e3ad3b79aea83",
+	},
+}
+
+func TestCurve_Vectors(t *testing.T) {
+	for _, v := range curveVectors {
+		c := v.curve
+		p := c.hashToPoint(c.dhc0, []byte("a"), []byte("b"))
+		assert.Equal(t, v.point, hex.EncodeToString(p.Marshal()), c.Name())
+
+		z := c.hashZ(c.proofOk, []byte("x"), []byte("y"))
+		assert.Equal(t, v.z, hex.EncodeToString(c.scalarBytes(z)), c.Name())
+	}
+}
+
+func TestCurve_ScalarBytes(t *testing.T) {
+	for _, c := range curves {
+		b := c.scalarBytes(big.NewInt(1))
+		assert.Len(t, b, c.scalarLen)
+		assert.Equal(t, byte(1), b[len(b)-1])
+
+		z, err := c.parseScalar(b)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), z.Int64())
+
+		_, err = c.parseScalar(append([]byte{0}, b...))
+		assert.Error(t, err)
+	}
+}
+
+func Test_PHE_P521(t *testing.T) {
+	testCurveFlow(t, P521())
+}
+
+func Test_PHE_Secp256k1(t *testing.T) {
+	testCurveFlow(t, Secp256k1())
+}
+
+func TestCurveByPoint_Secp256k1(t *testing.T) {
+	c := Secp256k1()
+	p := c.scalarBaseMult(c.randomZ())
+
+	pub, err := PointUnmarshal(p.Marshal())
+	assert.NoError(t, err)
+	assert.Equal(t, c, pub.c())
+	assert.True(t, p.Equal(pub))
+
+	q := P256().scalarBaseMult(randomZ())
+	pub, err = PointUnmarshal(q.Marshal())
+	assert.NoError(t, err)
+	assert.Equal(t, P256(), pub.c())
+}
+
+func TestK256_ScalarMult(t *testing.T) {
+	ref := k256Curve.KoblitzCurve
+	n := ref.Params().N
+	p := Secp256k1().scalarBaseMult(randomZ())
+
+	scalars := [][]byte{nil, {0}, {1}, {2}, {15}, {16}, new(big.Int).Sub(n, big.NewInt(1)).Bytes(), n.Bytes(), make([]byte, 40)}
+	for i := 0; i < 16; i++ {
+		scalars = append(scalars, Secp256k1().fixedScalar(Secp256k1().randomZ()))
+	}
+	random.MustRead(scalars[8])
+
+	for _, k := range scalars {
+		x, y := k256Curve.ScalarMult(p.X, p.Y, k)
+		wx, wy := ref.ScalarMult(p.X, p.Y, new(big.Int).Mod(new(big.Int).SetBytes(k), n).Bytes())
+		assert.Equal(t, 0, wx.Cmp(x), "%x", k)
+		assert.Equal(t, 0, wy.Cmp(y), "%x", k)
+
+		x, y = k256Curve.ScalarBaseMult(k)
+		wx, wy = ref.ScalarBaseMult(new(big.Int).Mod(new(big.Int).SetBytes(k), n).Bytes())
+		assert.Equal(t, 0, wx.Cmp(x), "%x", k)
+		assert.Equal(t, 0, wy.Cmp(y), "%x", k)
+	}
+
+	// the point at infinity stays there
+	x, y := k256Curve.ScalarMult(new(big.Int), new(big.Int), []byte{5})
+	assert.Zero(t, x.Sign())
+	assert.Zero(t, y.Sign())
+}
+
+func TestCurve_FixedScalar(t *testing.T) {
+	for _, c := range curves {
+		n := c.ec.Params().N
+		for _, k := range []*big.Int{big.NewInt(1), big.NewInt(-1), new(big.Int).Add(n, big.NewInt(5)), c.randomZ()} {
+			b := c.fixedScalar(k)
+			assert.Len(t, b, c.scalarLen)
+
+			want := new(big.Int).Mod(k, n)
+			assert.Equal(t, 0, want.Cmp(new(big.Int).SetBytes(b)))
+			assert.True(t, c.scalarBaseMult(k).Equal(c.scalarBaseMult(want)))
+		}
+	}
+}
+
+func TestCurve_MultiScalarMult(t *testing.T) {
+	for _, c := range curves {
+		for _, n := range []int{1, 2, 3, 9} {
+			points := make([]*Point, n)
+			scalars := make([]*big.Int, n)
+			var want *Point
+			for i := range points {
+				points[i] = c.scalarBaseMult(c.randomZ())
+				scalars[i] = c.randomZ()
+				term := points[i].ScalarMultInt(scalars[i])
+				if want == nil {
+					want = term
+				} else {
+					want = want.Add(term)
+				}
+			}
+			assert.True(t, want.Equal(c.multiScalarMult(points, scalars)), c.Name())
+			x, y := c.msm(points, scalars)
+			assert.True(t, want.Equal(&Point{X: x, Y: y, curve: c}), c.Name())
+		}
+
+		k := c.randomZ()
+		got := c.multiScalarMult([]*Point{c.g, c.g}, []*big.Int{k, big.NewInt(0)})
+		assert.True(t, c.scalarBaseMult(k).Equal(got), c.Name())
+
+		//terms which meet the same or the opposite point
+		got = c.multiScalarMult([]*Point{c.g, c.g}, []*big.Int{k, k})
+		assert.True(t, c.scalarBaseMult(new(big.Int).Lsh(k, 1)).Equal(got), c.Name())
+		x, y := c.msm([]*Point{c.g, c.g}, []*big.Int{k, k})
+		assert.True(t, got.Equal(&Point{X: x, Y: y, curve: c}), c.Name())
+		x, y = c.msm([]*Point{c.g, c.g}, []*big.Int{k, new(big.Int).Sub(c.ec.Params().N, k)})
+		assert.Zero(t, x.Sign(), c.Name())
+		assert.Zero(t, y.Sign(), c.Name())
+	}
+}
+
+// BenchmarkCurve_MultiScalarMult compares the msm routines with multiplying term by term, msmMin of every curve
+// is where the former starts to win
+func BenchmarkCurve_MultiScalarMult(b *testing.B) {
+	for _, c := range curves {
+		for _, n := range []int{2, 8, 32} {
+			points := make([]*Point, n)
+			scalars := make([]*big.Int, n)
+			for i := range points {
+				points[i] = c.scalarBaseMult(c.randomZ())
+				scalars[i] = c.randomZ()
+			}
+
+			b.Run(fmt.Sprintf("%s/%d", c.Name(), n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					c.msm(points, scalars)
+				}
+			})
+			b.Run(fmt.Sprintf("%s/%d/TermByTerm", c.Name(), n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					sum := points[0].ScalarMultInt(scalars[0])
+					for j := 1; j < len(points); j++ {
+						sum = sum.Add(points[j].ScalarMultInt(scalars[j]))
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkClient_CheckResponse_Secp256k1(b *testing.B) {
+	benchmarkCheckResponse(b, Secp256k1())
+}
+
+func BenchmarkClient_CheckResponse_P256(b *testing.B) {
+	benchmarkCheckResponse(b, P256())
+}
+
+func BenchmarkClient_CheckResponse_P384(b *testing.B) {
+	benchmarkCheckResponse(b, P384())
+}
+
+func BenchmarkClient_CheckResponse_P521(b *testing.B) {
+	benchmarkCheckResponse(b, P521())
+}
+
+func benchmarkCheckResponse(b *testing.B, curve *Curve) {
+	kp, _ := GenerateServerKeypairForCurve(curve)
+	s, _ := NewServer(kp)
+	clientKey := GenerateClientKeyForCurve(curve)
+	c, _ := NewClient(clientKey, s.PublicKey())
+	enrollment, _ := s.GetEnrollment()
+	rec, _, _ := c.EnrollAccount(pwd, enrollment)
+	req, _ := c.CreateVerifyPasswordRequest(pwd, rec)
+	resp, _ := s.VerifyPassword(req)
+
+	b.Run("plain", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			c.CheckResponseAndDecrypt(pwd, rec, resp)
+		}
+	})
+
+	pc, _ := NewClient(clientKey, s.PublicKey(), WithPrecomputedKey())
+	b.Run("precomputed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			pc.CheckResponseAndDecrypt(pwd, rec, resp)
+		}
+	})
+}
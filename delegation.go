@@ -0,0 +1,250 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"encoding/asn1"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	delegationVersion   byte = 2
+	delegationIDLen          = 16
+	delegationHeaderLen      = 1 + fieldNonceLen
+)
+
+var delegationInfo = []byte("Delegation")
+
+var (
+	// ErrDelegationExpired is returned when a delegation token is used after its expiration time
+	ErrDelegationExpired = errors.New("delegation expired")
+	// ErrDelegationRevoked is returned when a delegation token was revoked
+	ErrDelegationRevoked = errors.New("delegation revoked")
+)
+
+// delegation is the sealed content of a delegation token, Keys[i] is the key of Fields[i]
+type delegation struct {
+	ID      []byte
+	UserID  []byte
+	Fields  []string
+	Keys    [][]byte
+	Expires int64
+}
+
+// Revoker reports whether a delegation token with the given ID must no longer be honored
+type Revoker interface {
+	IsRevoked(id []byte) bool
+}
+
+// RevocationList is an in-memory Revoker
+type RevocationList struct {
+	mu  sync.RWMutex
+	ids map[string]struct{}
+}
+
+// NewRevocationList creates an empty revocation list
+func NewRevocationList() *RevocationList {
+	return &RevocationList{ids: make(map[string]struct{})}
+}
+
+// Revoke marks delegation ID as revoked
+func (l *RevocationList) Revoke(id []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ids[string(id)] = struct{}{}
+}
+
+// IsRevoked implements Revoker
+func (l *RevocationList) IsRevoked(id []byte) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.ids[string(id)]
+	return ok
+}
+
+// MintDelegation lets a client which has just verified the password hand decryption of the listed fields
+// of the account over to a backend worker for ttl. The token is sealed with workerKey shared with the workers and
+// carries the keys of the listed fields only, so neither the account key nor the other fields' keys leave the client.
+// Blobs written as FieldAlgAES256GCM before fields had keys of their own can't be delegated.
+// It returns the token and its ID to be used for revocation
+func MintDelegation(workerKey, accountKey, userID []byte, fields []string, ttl time.Duration) (token, id []byte, err error) {
+	if len(fields) == 0 {
+		return nil, nil, errors.New("invalid delegation scope")
+	}
+	for _, f := range fields {
+		if len(f) == 0 {
+			return nil, nil, errors.New("invalid field name")
+		}
+	}
+
+	if ttl <= 0 {
+		return nil, nil, errors.New("invalid delegation ttl")
+	}
+
+	if len(userID) == 0 {
+		return nil, nil, errors.New("invalid user id")
+	}
+
+	aead, err := newAEAD(workerKey, delegationInfo)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid worker key")
+	}
+
+	subKey, err := deriveSubKey(accountKey, fieldInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer Wipe(subKey)
+
+	keys := make([][]byte, len(fields))
+	for i, f := range fields {
+		if keys[i], err = fieldKey(subKey, f); err != nil {
+			return nil, nil, err
+		}
+		defer Wipe(keys[i])
+	}
+
+	id = make([]byte, delegationIDLen)
+	random.MustRead(id)
+
+	payload, err := asn1.Marshal(delegation{
+		ID:      id,
+		UserID:  userID,
+		Fields:  fields,
+		Keys:    keys,
+		Expires: time.Now().Add(ttl).UnixNano(),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	token = make([]byte, delegationHeaderLen, delegationHeaderLen+len(payload)+aead.Overhead())
+	token[0] = delegationVersion
+	random.MustRead(token[1:delegationHeaderLen])
+
+	return aead.Seal(token, token[1:delegationHeaderLen], payload, token[:1]), id, nil
+}
+
+// DelegatedCipher decrypts the fields a delegation token grants access to
+type DelegatedCipher struct {
+	id      []byte
+	expires time.Time
+	revoker Revoker
+	fc      *FieldCipher
+}
+
+// OpenDelegation unseals a token produced by MintDelegation. Revoker may be nil if tokens are never revoked
+func OpenDelegation(workerKey, token []byte, revoker Revoker) (*DelegatedCipher, error) {
+	aead, err := newAEAD(workerKey, delegationInfo)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid worker key")
+	}
+
+	if len(token) < delegationHeaderLen+aead.Overhead() || token[0] != delegationVersion {
+		return nil, errors.New("invalid delegation")
+	}
+
+	payload, err := aead.Open(nil, token[1:delegationHeaderLen], token[delegationHeaderLen:], token[:1])
+	if err != nil {
+		return nil, errors.New("invalid delegation")
+	}
+
+	var del delegation
+	if rest, err := asn1.Unmarshal(payload, &del); err != nil || len(rest) != 0 {
+		return nil, errors.New("invalid delegation")
+	}
+
+	if len(del.Keys) != len(del.Fields) {
+		return nil, errors.New("invalid delegation")
+	}
+	keys := make(map[string][]byte, len(del.Fields))
+	for i, f := range del.Fields {
+		keys[f] = del.Keys[i]
+	}
+	fc, err := newScopedFieldCipher(keys, del.UserID)
+	if err != nil {
+		return nil, errors.New("invalid delegation")
+	}
+
+	d := &DelegatedCipher{
+		id:      del.ID,
+		expires: time.Unix(0, del.Expires),
+		revoker: revoker,
+		fc:      fc,
+	}
+
+	if err = d.check(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// ID returns delegation ID
+func (d *DelegatedCipher) ID() []byte {
+	return append([]byte{}, d.id...)
+}
+
+// Expires returns the time delegation stops working
+func (d *DelegatedCipher) Expires() time.Time {
+	return d.expires
+}
+
+// DecryptField decrypts a blob produced by FieldCipher.EncryptField if the field is in delegation's scope
+// and the delegation is neither expired nor revoked
+func (d *DelegatedCipher) DecryptField(name string, blob []byte) ([]byte, error) {
+	if err := d.check(); err != nil {
+		return nil, err
+	}
+
+	if _, ok := d.fc.keys[name]; !ok {
+		return nil, errors.New("field is out of delegation scope")
+	}
+	return d.fc.DecryptField(name, blob)
+}
+
+func (d *DelegatedCipher) check() error {
+	if !time.Now().Before(d.expires) {
+		return ErrDelegationExpired
+	}
+	if d.revoker != nil && d.revoker.IsRevoked(d.id) {
+		return ErrDelegationRevoked
+	}
+	return nil
+}
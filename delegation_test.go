@@ -0,0 +1,75 @@
+package phe
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelegation(t *testing.T) {
+	accountKey := make([]byte, 32)
+	workerKey := make([]byte, 32)
+	rand.Read(accountKey)
+	rand.Read(workerKey)
+	userID := []byte("user1")
+
+	fc, err := NewFieldCipher(accountKey, userID)
+	assert.NoError(t, err)
+	email, err := fc.EncryptField("email", []byte("user@example.com"))
+	assert.NoError(t, err)
+	phone, err := fc.EncryptField("phone", []byte("555-0100"))
+	assert.NoError(t, err)
+
+	token, id, err := MintDelegation(workerKey, accountKey, userID, []string{"email"}, time.Minute)
+	assert.NoError(t, err)
+
+	revoked := NewRevocationList()
+	d, err := OpenDelegation(workerKey, token, revoked)
+	assert.NoError(t, err)
+	assert.Equal(t, id, d.ID())
+
+	value, err := d.DecryptField("email", email)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("user@example.com"), value)
+
+	//out of scope
+	_, err = d.DecryptField("phone", phone)
+	assert.Error(t, err)
+
+	//the token holds no key for fields out of scope, not even the one all fields derive from
+	subKey, err := deriveSubKey(accountKey, fieldInfo)
+	assert.NoError(t, err)
+	assert.Nil(t, d.fc.subKey)
+	assert.Len(t, d.fc.keys, 1)
+	assert.NotEqual(t, subKey, d.fc.keys["email"])
+	_, err = d.fc.DecryptField("phone", phone)
+	assert.Error(t, err)
+
+	//wrong worker key
+	otherKey := make([]byte, 32)
+	rand.Read(otherKey)
+	_, err = OpenDelegation(otherKey, token, nil)
+	assert.Error(t, err)
+
+	revoked.Revoke(id)
+	_, err = d.DecryptField("email", email)
+	assert.Equal(t, ErrDelegationRevoked, err)
+	_, err = OpenDelegation(workerKey, token, revoked)
+	assert.Equal(t, ErrDelegationRevoked, err)
+}
+
+func TestDelegation_Expired(t *testing.T) {
+	accountKey := make([]byte, 32)
+	workerKey := make([]byte, 32)
+	rand.Read(accountKey)
+	rand.Read(workerKey)
+
+	token, _, err := MintDelegation(workerKey, accountKey, []byte("user1"), []string{"email"}, time.Millisecond)
+	assert.NoError(t, err)
+
+	time.Sleep(5 * time.Millisecond)
+	_, err = OpenDelegation(workerKey, token, nil)
+	assert.Equal(t, ErrDelegationExpired, err)
+}
@@ -0,0 +1,314 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"encoding/asn1"
+	"math"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// DER encodings of PHE artifacts for PKI tooling and HSM import and export. Every structure starts with
+// a version and the curve's SEC 2 object identifier, points are uncompressed:
+//
+//	PHEEnrollmentRecord ::= SEQUENCE {
+//	    version INTEGER (1),
+//	    curve   OBJECT IDENTIFIER,
+//	    ns      OCTET STRING,
+//	    nc      OCTET STRING,
+//	    t0      OCTET STRING,
+//	    t1      OCTET STRING,
+//	    keyVersion [0] EXPLICIT INTEGER OPTIONAL,
+//	    mac        [1] EXPLICIT OCTET STRING OPTIONAL }
+//
+//	PHEUpdateToken ::= SEQUENCE {
+//	    version INTEGER (1),
+//	    curve   OBJECT IDENTIFIER,
+//	    a       INTEGER,
+//	    b       INTEGER,
+//	    fromVersion [0] EXPLICIT INTEGER OPTIONAL,
+//	    toVersion   [1] EXPLICIT INTEGER OPTIONAL,
+//	    fromKey     [2] EXPLICIT UTF8String OPTIONAL,
+//	    toKey       [3] EXPLICIT UTF8String OPTIONAL,
+//	    issuedAt    [4] EXPLICIT INTEGER OPTIONAL,
+//	    expiresAt   [5] EXPLICIT INTEGER OPTIONAL }
+//
+//	PHEServerKeypair ::= SEQUENCE {
+//	    version    INTEGER (1),
+//	    curve      OBJECT IDENTIFIER,
+//	    publicKey  OCTET STRING,
+//	    privateKey OCTET STRING }
+//
+// The private key is a big-endian integer of the curve's scalar length, as in RFC 5915
+const derVersion = 1
+
+var curveOIDs = map[*Curve]asn1.ObjectIdentifier{
+	p256:      {1, 2, 840, 10045, 3, 1, 7},
+	p384:      {1, 3, 132, 0, 34},
+	p521:      {1, 3, 132, 0, 35},
+	secp256K1: {1, 3, 132, 0, 10},
+}
+
+type derRecord struct {
+	Version int
+	Curve   asn1.ObjectIdentifier
+	NS      []byte
+	NC      []byte
+	T0      []byte
+	T1      []byte
+	// KeyVersion is omitted when it's 0
+	KeyVersion int64 `asn1:"optional,explicit,tag:0"`
+	// MAC is omitted when the record has none
+	MAC []byte `asn1:"optional,explicit,tag:1"`
+}
+
+type derUpdateToken struct {
+	Version int
+	Curve   asn1.ObjectIdentifier
+	A       *big.Int
+	B       *big.Int
+	// metadata fields are omitted when they're unset
+	FromVersion int64  `asn1:"optional,explicit,tag:0"`
+	ToVersion   int64  `asn1:"optional,explicit,tag:1"`
+	FromKey     string `asn1:"optional,explicit,tag:2,utf8"`
+	ToKey       string `asn1:"optional,explicit,tag:3,utf8"`
+	IssuedAt    int64  `asn1:"optional,explicit,tag:4"`
+	ExpiresAt   int64  `asn1:"optional,explicit,tag:5"`
+}
+
+type derKeypair struct {
+	Version    int
+	Curve      asn1.ObjectIdentifier
+	PublicKey  []byte
+	PrivateKey []byte
+}
+
+// MarshalRecordDER encodes a record into PHEEnrollmentRecord
+func MarshalRecordDER(rec *EnrollmentRecord) ([]byte, error) {
+	if rec == nil {
+		return nil, errors.New("invalid record")
+	}
+
+	curve, err := curveByPoint(rec.T0)
+	if err != nil {
+		return nil, errors.New("invalid record")
+	}
+	if _, _, err = rec.parse(curve); err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(derRecord{
+		Version:    derVersion,
+		Curve:      curveOIDs[curve],
+		NS:         rec.NS,
+		NC:         rec.NC,
+		T0:         rec.T0,
+		T1:         rec.T1,
+		KeyVersion: int64(rec.KeyVersion),
+		MAC:        rec.MAC,
+	})
+}
+
+// UnmarshalRecordDER decodes a PHEEnrollmentRecord
+func UnmarshalRecordDER(data []byte) (*EnrollmentRecord, error) {
+	var v derRecord
+	if err := unmarshalDER(data, &v); err != nil {
+		return nil, errors.Wrap(err, "invalid record")
+	}
+	if v.Version != derVersion {
+		return nil, errors.New("unsupported record version")
+	}
+
+	curve, err := curveByOID(v.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.KeyVersion < 0 || v.KeyVersion > math.MaxUint32 {
+		return nil, errors.New("invalid key version")
+	}
+
+	if v.MAC != nil && len(v.MAC) != RecordMACSize {
+		return nil, errors.New("invalid record mac")
+	}
+
+	rec := &EnrollmentRecord{NS: v.NS, NC: v.NC, T0: v.T0, T1: v.T1, KeyVersion: uint32(v.KeyVersion), MAC: v.MAC}
+	if _, _, err = rec.parse(curve); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// MarshalUpdateTokenDER encodes a token for the curve of server keys it rotates into PHEUpdateToken
+func MarshalUpdateTokenDER(token *UpdateToken, curve *Curve) ([]byte, error) {
+	oid, ok := curveOIDs[curve]
+	if !ok {
+		return nil, errors.New("unsupported curve")
+	}
+
+	a, b, err := token.parse(curve)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(derUpdateToken{
+		Version:     derVersion,
+		Curve:       oid,
+		A:           a,
+		B:           b,
+		FromVersion: int64(token.FromVersion),
+		ToVersion:   int64(token.ToVersion),
+		FromKey:     token.FromKey,
+		ToKey:       token.ToKey,
+		IssuedAt:    token.IssuedAt,
+		ExpiresAt:   token.ExpiresAt,
+	})
+}
+
+// UnmarshalUpdateTokenDER decodes a PHEUpdateToken and returns the curve it's for
+func UnmarshalUpdateTokenDER(data []byte) (*UpdateToken, *Curve, error) {
+	var v derUpdateToken
+	if err := unmarshalDER(data, &v); err != nil {
+		return nil, nil, errors.Wrap(err, "invalid update token")
+	}
+	if v.Version != derVersion {
+		return nil, nil, errors.New("unsupported update token version")
+	}
+
+	curve, err := curveByOID(v.Curve)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	n := curve.ec.Params().N
+	if v.A.Sign() <= 0 || v.A.Cmp(n) >= 0 || v.B.Sign() < 0 || v.B.Cmp(n) >= 0 {
+		return nil, nil, errors.New("invalid update token")
+	}
+
+	if v.FromVersion < 0 || v.FromVersion > math.MaxUint32 || v.ToVersion < 0 || v.ToVersion > math.MaxUint32 {
+		return nil, nil, errors.New("invalid key version")
+	}
+
+	token := &UpdateToken{
+		A:           curve.scalarBytes(v.A),
+		B:           curve.scalarBytes(v.B),
+		FromVersion: uint32(v.FromVersion),
+		ToVersion:   uint32(v.ToVersion),
+		FromKey:     v.FromKey,
+		ToKey:       v.ToKey,
+		IssuedAt:    v.IssuedAt,
+		ExpiresAt:   v.ExpiresAt,
+	}
+	if !token.wellFormed() {
+		return nil, nil, errors.New("invalid update token")
+	}
+	return token, curve, nil
+}
+
+// MarshalKeypairDER converts a server keypair into PHEServerKeypair
+func MarshalKeypairDER(serverKeypair []byte) ([]byte, error) {
+	s, err := NewServer(serverKeypair)
+	if err != nil {
+		return nil, err
+	}
+
+	oid, ok := curveOIDs[s.curve]
+	if !ok {
+		return nil, errors.New("unsupported curve")
+	}
+
+	return asn1.Marshal(derKeypair{
+		Version:    derVersion,
+		Curve:      oid,
+		PublicKey:  s.kp.PublicKey,
+		PrivateKey: s.priv,
+	})
+}
+
+// UnmarshalKeypairDER converts a PHEServerKeypair into a server keypair. The public key must match the private one
+func UnmarshalKeypairDER(data []byte) ([]byte, error) {
+	var v derKeypair
+	if err := unmarshalDER(data, &v); err != nil {
+		return nil, errors.Wrap(err, "invalid keypair")
+	}
+	if v.Version != derVersion {
+		return nil, errors.New("unsupported keypair version")
+	}
+
+	curve, err := curveByOID(v.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := curve.pointUnmarshal(v.PublicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid keypair")
+	}
+	if len(v.PrivateKey) != curve.scalarLen {
+		return nil, errors.New("invalid keypair")
+	}
+	x, err := curve.parseScalar(v.PrivateKey)
+	if err != nil || x.Sign() == 0 || !curve.scalarBaseMult(x).Equal(pub) {
+		return nil, errors.New("invalid keypair")
+	}
+
+	return marshalKeypair(v.PublicKey, v.PrivateKey)
+}
+
+// unmarshalDER decodes a structure rejecting trailing data
+func unmarshalDER(data []byte, v interface{}) error {
+	rest, err := asn1.Unmarshal(data, v)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return errors.New("trailing data")
+	}
+	return nil
+}
+
+// curveByOID finds a curve by its object identifier
+func curveByOID(oid asn1.ObjectIdentifier) (*Curve, error) {
+	for c, id := range curveOIDs {
+		if id.Equal(oid) {
+			return c, nil
+		}
+	}
+	return nil, errors.New("unsupported curve")
+}
@@ -0,0 +1,85 @@
+package phe
+
+import (
+	"encoding/asn1"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDER_RoundTrip(t *testing.T) {
+	for _, curve := range curves {
+		serverKeypair, err := GenerateServerKeypairForCurve(curve)
+		assert.NoError(t, err)
+		c, err := NewClient(GenerateClientKeyForCurve(curve), mustPublicKey(t, serverKeypair))
+		assert.NoError(t, err)
+		enrollment, err := GetEnrollment(serverKeypair)
+		assert.NoError(t, err)
+		rec, _, err := c.EnrollAccount(pwd, enrollment)
+		assert.NoError(t, err)
+
+		data, err := MarshalRecordDER(rec)
+		assert.NoError(t, err)
+		decoded, err := UnmarshalRecordDER(data)
+		assert.NoError(t, err)
+		assert.Equal(t, rec, decoded)
+
+		token, _, err := Rotate(serverKeypair)
+		assert.NoError(t, err)
+		data, err = MarshalUpdateTokenDER(token, curve)
+		assert.NoError(t, err)
+		decodedToken, tokenCurve, err := UnmarshalUpdateTokenDER(data)
+		assert.NoError(t, err)
+		assert.Equal(t, token, decodedToken)
+		assert.Equal(t, curve, tokenCurve)
+
+		data, err = MarshalKeypairDER(serverKeypair)
+		assert.NoError(t, err)
+		kp, err := UnmarshalKeypairDER(data)
+		assert.NoError(t, err)
+		assert.Equal(t, serverKeypair, kp)
+	}
+}
+
+func TestDER_Invalid(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	data, err := MarshalKeypairDER(serverKeypair)
+	assert.NoError(t, err)
+
+	_, err = UnmarshalKeypairDER(append(data, 0))
+	assert.Error(t, err)
+
+	var v derKeypair
+	_, err = asn1.Unmarshal(data, &v)
+	assert.NoError(t, err)
+
+	other := v
+	other.PublicKey = mustPublicKey(t, mustKeypair(t))
+	mismatched, err := asn1.Marshal(other)
+	assert.NoError(t, err)
+	_, err = UnmarshalKeypairDER(mismatched)
+	assert.Error(t, err)
+
+	other = v
+	other.Curve = asn1.ObjectIdentifier{1, 2, 3}
+	unknown, err := asn1.Marshal(other)
+	assert.NoError(t, err)
+	_, err = UnmarshalKeypairDER(unknown)
+	assert.Error(t, err)
+
+	other = v
+	other.Version = 2
+	future, err := asn1.Marshal(other)
+	assert.NoError(t, err)
+	_, err = UnmarshalKeypairDER(future)
+	assert.Error(t, err)
+
+	n := p256.ec.Params().N
+	token, err := asn1.Marshal(derUpdateToken{Version: derVersion, Curve: curveOIDs[p256], A: n, B: n})
+	assert.NoError(t, err)
+	_, _, err = UnmarshalUpdateTokenDER(token)
+	assert.Error(t, err)
+
+	_, err = MarshalRecordDER(&EnrollmentRecord{NS: []byte("ns")})
+	assert.Error(t, err)
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// WithProtectedKey keeps client's private key encrypted in memory. The key encrypting it lives in memory locked
+// against swapping outside the Go heap, and the private key is decrypted into another locked buffer only for the duration
+// of each operation and wiped right after. Memory is locked where the platform supports mlock, elsewhere the key is
+// still kept encrypted. Operations become slightly slower. Call Wipe to destroy the enclave
+func WithProtectedKey() ClientOption {
+	return func(c *Client) {
+		c.protectKey = true
+	}
+}
+
+// keyEnclave holds a secret sealed with AES-256-GCM under a random key in locked memory. The cipher is built from
+// the locked key for each operation and dropped right after, so its expanded key schedule isn't kept on the Go heap
+// between operations. It's safe for concurrent use, so rotation can reseal while handlers open
+type keyEnclave struct {
+	mu     sync.RWMutex
+	kek    []byte
+	sealed []byte
+	size   int
+}
+
+// newKeyEnclave seals key, the caller wipes its copy
+func newKeyEnclave(key []byte) (*keyEnclave, error) {
+	kek, err := lockedAlloc(32)
+	if err != nil {
+		return nil, err
+	}
+	random.MustRead(kek)
+
+	e := &keyEnclave{kek: kek}
+	if err = e.seal(key); err != nil {
+		lockedFree(kek)
+		return nil, err
+	}
+	return e, nil
+}
+
+// aead builds the cipher from the locked key
+func (e *keyEnclave) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(e.kek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal replaces the secret
+func (e *keyEnclave) seal(key []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.kek == nil {
+		return errors.New("key enclave is destroyed")
+	}
+	aead, err := e.aead()
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	random.MustRead(nonce)
+	e.sealed = aead.Seal(nonce, nonce, key, nil)
+	e.size = len(key)
+	return nil
+}
+
+// open decrypts the secret into locked memory, release wipes and frees it
+func (e *keyEnclave) open() (key []byte, release func(), err error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.kek == nil {
+		return nil, nil, errors.New("key enclave is destroyed")
+	}
+	aead, err := e.aead()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	buf, err := lockedAlloc(e.size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	n := aead.NonceSize()
+	if len(e.sealed) < n {
+		lockedFree(buf)
+		return nil, nil, errors.New("key enclave is corrupted")
+	}
+	if _, err = aead.Open(buf[:0], e.sealed[:n], e.sealed[n:], nil); err != nil {
+		lockedFree(buf)
+		return nil, nil, errors.New("key enclave is corrupted")
+	}
+	return buf, func() { lockedFree(buf) }, nil
+}
+
+// destroy wipes the key encryption key, the enclave can't be opened afterwards
+func (e *keyEnclave) destroy() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.kek != nil {
+		lockedFree(e.kek)
+		e.kek, e.sealed = nil, nil
+	}
+}
+
+// privateKey returns client's private key. release must be called when the operation is done,
+// it wipes the key if it was decrypted from the enclave
+func (c *Client) privateKey() (y []byte, release func(), err error) {
+	if c.enclave == nil {
+		return c.clientPrivateKey, func() {}, nil
+	}
+	return c.enclave.open()
+}
@@ -0,0 +1,103 @@
+package phe
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithProtectedKey(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	clientKey := GenerateClientKey()
+
+	c, err := NewClient(clientKey, mustPublicKey(t, serverKeypair), WithProtectedKey())
+	assert.NoError(t, err)
+	assert.Nil(t, c.clientPrivateKey)
+	assert.NotNil(t, c.enclave)
+	assert.NotContains(t, string(c.enclave.sealed), string(clientKey))
+
+	// records are interchangeable with an unprotected client
+	plain, err := NewClient(clientKey, mustPublicKey(t, serverKeypair))
+	assert.NoError(t, err)
+
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	rec, key, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	for _, cl := range []*Client{c, plain} {
+		req, err := cl.CreateVerifyPasswordRequest(pwd, rec)
+		assert.NoError(t, err)
+		resp, err := VerifyPassword(serverKeypair, req)
+		assert.NoError(t, err)
+		decrypted, err := cl.CheckResponseAndDecrypt(pwd, rec, resp)
+		assert.NoError(t, err)
+		assert.Equal(t, key, decrypted)
+	}
+
+	policy := FallbackPolicy{Lifetime: time.Hour, Time: 1, Memory: 64, Threads: 1}
+	v, err := c.NewFallbackVerifier(pwd, rec, key, policy)
+	assert.NoError(t, err)
+	decrypted, err := plain.CheckFallbackVerifier(pwd, rec, v, policy)
+	assert.NoError(t, err)
+	assert.Equal(t, key, decrypted)
+
+	// rotation reseals the new key
+	token, newKeypair, err := Rotate(serverKeypair)
+	assert.NoError(t, err)
+	newPub := mustPublicKey(t, newKeypair)
+	assert.NoError(t, c.RotateChecked(token, newPub))
+	assert.NoError(t, plain.RotateChecked(token, newPub))
+	rec, err = UpdateRecord(rec, token)
+	assert.NoError(t, err)
+
+	y, release, err := c.privateKey()
+	assert.NoError(t, err)
+	assert.Equal(t, plain.clientPrivateKey, append([]byte{}, y...))
+	release()
+
+	req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	resp, err := VerifyPassword(newKeypair, req)
+	assert.NoError(t, err)
+	decrypted, err = c.CheckResponseAndDecrypt(pwd, rec, resp)
+	assert.NoError(t, err)
+	assert.Equal(t, key, decrypted)
+
+	c.Wipe()
+	_, err = c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.Error(t, err)
+}
+
+func TestKeyEnclave_Concurrent(t *testing.T) {
+	keys := [][]byte{GenerateClientKey(), GenerateClientKey()}
+	e, err := newKeyEnclave(keys[0])
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key, release, err := e.open()
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Contains(t, keys, append([]byte{}, key...))
+				release()
+			}
+		}()
+	}
+	for j := 0; j < 100; j++ {
+		assert.NoError(t, e.seal(keys[j%2]))
+	}
+	wg.Wait()
+
+	e.destroy()
+	_, _, err = e.open()
+	assert.Error(t, err)
+	assert.Error(t, e.seal(keys[0]))
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"bytes"
+	"crypto/subtle"
+)
+
+// Equal reports whether both records have the same fields. Records are derived from passwords,
+// so the comparison takes the same time wherever they differ
+func (c *EnrollmentRecord) Equal(other *EnrollmentRecord) bool {
+	if c == nil || other == nil {
+		return c == other
+	}
+	eq := ctEqual(c.NS, other.NS) & ctEqual(c.NC, other.NC) & ctEqual(c.T0, other.T0) & ctEqual(c.T1, other.T1) &
+		ctEqual(c.MAC, other.MAC) & subtle.ConstantTimeEq(int32(c.KeyVersion), int32(other.KeyVersion))
+	return eq == 1
+}
+
+// Clone returns a copy of the record sharing no memory with it
+func (c *EnrollmentRecord) Clone() *EnrollmentRecord {
+	if c == nil {
+		return nil
+	}
+	return &EnrollmentRecord{
+		NS:         cloneBytes(c.NS),
+		NC:         cloneBytes(c.NC),
+		T0:         cloneBytes(c.T0),
+		T1:         cloneBytes(c.T1),
+		KeyVersion: c.KeyVersion,
+		MAC:        cloneBytes(c.MAC),
+	}
+}
+
+// Equal reports whether both proofs have the same fields
+func (p *ProofOfSuccess) Equal(other *ProofOfSuccess) bool {
+	if p == nil || other == nil {
+		return p == other
+	}
+	return bytes.Equal(p.Term1, other.Term1) && bytes.Equal(p.Term2, other.Term2) && bytes.Equal(p.Term3, other.Term3) &&
+		bytes.Equal(p.BlindX, other.BlindX)
+}
+
+// Clone returns a copy of the proof sharing no memory with it
+func (p *ProofOfSuccess) Clone() *ProofOfSuccess {
+	if p == nil {
+		return nil
+	}
+	return &ProofOfSuccess{
+		Term1:  cloneBytes(p.Term1),
+		Term2:  cloneBytes(p.Term2),
+		Term3:  cloneBytes(p.Term3),
+		BlindX: cloneBytes(p.BlindX),
+	}
+}
+
+// Equal reports whether both proofs have the same fields
+func (p *ProofOfFail) Equal(other *ProofOfFail) bool {
+	if p == nil || other == nil {
+		return p == other
+	}
+	return bytes.Equal(p.Term1, other.Term1) && bytes.Equal(p.Term2, other.Term2) && bytes.Equal(p.Term3, other.Term3) &&
+		bytes.Equal(p.Term4, other.Term4) && bytes.Equal(p.BlindA, other.BlindA) && bytes.Equal(p.BlindB, other.BlindB)
+}
+
+// Clone returns a copy of the proof sharing no memory with it
+func (p *ProofOfFail) Clone() *ProofOfFail {
+	if p == nil {
+		return nil
+	}
+	return &ProofOfFail{
+		Term1:  cloneBytes(p.Term1),
+		Term2:  cloneBytes(p.Term2),
+		Term3:  cloneBytes(p.Term3),
+		Term4:  cloneBytes(p.Term4),
+		BlindA: cloneBytes(p.BlindA),
+		BlindB: cloneBytes(p.BlindB),
+	}
+}
+
+// Equal reports whether both tokens are the same. Tokens relate old and new server private keys,
+// so the comparison takes the same time wherever they differ
+func (t *UpdateToken) Equal(other *UpdateToken) bool {
+	if t == nil || other == nil {
+		return t == other
+	}
+	return ctEqual(t.A, other.A)&ctEqual(t.B, other.B) == 1 && t.FromVersion == other.FromVersion &&
+		t.ToVersion == other.ToVersion && t.FromKey == other.FromKey && t.ToKey == other.ToKey &&
+		t.IssuedAt == other.IssuedAt && t.ExpiresAt == other.ExpiresAt
+}
+
+// Clone returns a copy of the token sharing no memory with it
+func (t *UpdateToken) Clone() *UpdateToken {
+	if t == nil {
+		return nil
+	}
+	cp := *t
+	cp.A, cp.B = cloneBytes(t.A), cloneBytes(t.B)
+	return &cp
+}
+
+// ctEqual is subtle.ConstantTimeCompare, only lengths leak
+func ctEqual(a, b []byte) int {
+	return subtle.ConstantTimeCompare(a, b)
+}
+
+// cloneBytes copies b keeping nil as nil
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
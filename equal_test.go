@@ -0,0 +1,70 @@
+package phe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnrollmentRecord_EqualClone(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	c, err := NewClient(GenerateClientKey(), mustPublicKey(t, serverKeypair), WithRecordMAC([]byte("key")))
+	assert.NoError(t, err)
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+	rec.KeyVersion = 2
+
+	cp := rec.Clone()
+	assert.Equal(t, rec, cp)
+	assert.True(t, rec.Equal(cp))
+	cp.T1[1] ^= 1
+	assert.False(t, rec.Equal(cp))
+	assert.NotEqual(t, rec.T1, cp.T1)
+
+	cp = rec.Clone()
+	cp.KeyVersion++
+	assert.False(t, rec.Equal(cp))
+	cp = rec.Clone()
+	cp.MAC = nil
+	assert.False(t, rec.Equal(cp))
+
+	var nilRec *EnrollmentRecord
+	assert.True(t, nilRec.Equal(nil))
+	assert.False(t, rec.Equal(nil))
+	assert.Nil(t, nilRec.Clone())
+
+	proof := enrollment.Proof.Clone()
+	assert.True(t, proof.Equal(enrollment.Proof))
+	proof.BlindX[0] ^= 1
+	assert.False(t, proof.Equal(enrollment.Proof))
+}
+
+func TestProofOfFail_UpdateToken_EqualClone(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	c, err := NewClient(GenerateClientKey(), mustPublicKey(t, serverKeypair))
+	assert.NoError(t, err)
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+	req, err := c.CreateVerifyPasswordRequest([]byte("wrong"), rec)
+	assert.NoError(t, err)
+	res, err := VerifyPassword(serverKeypair, req)
+	assert.NoError(t, err)
+
+	proof := res.ProofFail.Clone()
+	assert.True(t, proof.Equal(res.ProofFail))
+	proof.Term4[1] ^= 1
+	assert.False(t, proof.Equal(res.ProofFail))
+	assert.False(t, proof.Equal(nil))
+
+	token, _, err := Rotate(serverKeypair)
+	assert.NoError(t, err)
+	cp := token.Clone()
+	assert.True(t, token.Equal(cp))
+	cp.B = append(cp.B, 0)
+	assert.False(t, token.Equal(cp))
+	assert.Nil(t, (*UpdateToken)(nil).Clone())
+}
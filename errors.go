@@ -0,0 +1,162 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidProof is what every rejected server proof looks like from the outside.
+// Use errors.As with *ProofError to find out locally why the proof was rejected
+var ErrInvalidProof = errors.New("invalid proof")
+
+// ProofFailure is the precise reason a proof was rejected
+type ProofFailure int
+
+const (
+	// ProofMalformed means the proof or the server values it covers couldn't be parsed
+	ProofMalformed ProofFailure = iota + 1
+	// ProofMismatch means the proof was well-formed but one of its equations didn't hold
+	ProofMismatch
+)
+
+func (f ProofFailure) String() string {
+	switch f {
+	case ProofMalformed:
+		return "malformed proof"
+	case ProofMismatch:
+		return "proof equation mismatch"
+	default:
+		return "unknown proof failure"
+	}
+}
+
+// ProofError is returned when a server proof is rejected. Its message is the same whatever the reason,
+// and malformed proofs go through the same verification steps as wrong ones, so neither the error text
+// nor the time it took to produce it tells the peer which check failed
+type ProofError struct {
+	Reason ProofFailure
+}
+
+func (e *ProofError) Error() string {
+	return ErrInvalidProof.Error()
+}
+
+// Is makes errors.Is(err, ErrInvalidProof) true for every ProofError
+func (e *ProofError) Is(target error) bool {
+	return target == ErrInvalidProof
+}
+
+// ErrRotationMismatch is matched by RotationError
+var ErrRotationMismatch = errors.New("update token does not map the old server public key to the new one")
+
+// RotationError is returned when an update token doesn't turn the old server public key into the new one.
+// Applying such a token would silently move clients to a key nobody has proven to own
+type RotationError struct {
+	OldPublicKey []byte
+	NewPublicKey []byte
+}
+
+func (e *RotationError) Error() string {
+	return ErrRotationMismatch.Error()
+}
+
+// Is makes errors.Is(err, ErrRotationMismatch) true for every RotationError
+func (e *RotationError) Is(target error) bool {
+	return target == ErrRotationMismatch
+}
+
+// ErrKeyVersionMismatch is matched by KeyVersionError
+var ErrKeyVersionMismatch = errors.New("key version mismatch")
+
+// KeyVersionError is returned when a record or a request was made with other keys than the ones in use,
+// e.g. a record which hasn't been updated after rotation yet
+type KeyVersionError struct {
+	Expected uint32
+	Got      uint32
+}
+
+func (e *KeyVersionError) Error() string {
+	return fmt.Sprintf("%s: expected key version %d, got %d", ErrKeyVersionMismatch, e.Expected, e.Got)
+}
+
+// Is makes errors.Is(err, ErrKeyVersionMismatch) true for every KeyVersionError
+func (e *KeyVersionError) Is(target error) bool {
+	return target == ErrKeyVersionMismatch
+}
+
+// ErrRecordMAC is returned when a record's MAC doesn't match its fields, i.e. the stored record was corrupted
+var ErrRecordMAC = errors.New("record integrity check failed")
+
+var (
+	// ErrTokenExpired is returned when an update token is used after its ExpiresAt
+	ErrTokenExpired = errors.New("update token has expired")
+	// ErrTokenKeyMismatch is returned when an update token was issued for another server key than the one it's applied to
+	ErrTokenKeyMismatch = errors.New("update token was issued for another server key")
+)
+
+// ErrPasswordPolicy is matched by PolicyError
+var ErrPasswordPolicy = errors.New("password rejected by policy")
+
+// PolicyError is returned by EnrollAccount when the password policy rejects the password, Err is policy's reason
+type PolicyError struct {
+	Err error
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrPasswordPolicy, e.Err)
+}
+
+// Is makes errors.Is(err, ErrPasswordPolicy) true for every PolicyError
+func (e *PolicyError) Is(target error) bool {
+	return target == ErrPasswordPolicy
+}
+
+// Unwrap returns policy's reason
+func (e *PolicyError) Unwrap() error {
+	return e.Err
+}
+
+// CheckKeyVersion compares key versions, 0 on either side means unversioned and matches anything
+func CheckKeyVersion(expected, got uint32) error {
+	if expected != 0 && got != 0 && expected != got {
+		return &KeyVersionError{Expected: expected, Got: got}
+	}
+	return nil
+}
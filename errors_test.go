@@ -0,0 +1,71 @@
+package phe
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProofError(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	pub, err := GetPublicKey(serverKeypair)
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), pub)
+	assert.NoError(t, err)
+
+	reason := func(err error) ProofFailure {
+		var pe *ProofError
+		assert.True(t, errors.As(err, &pe))
+		assert.True(t, errors.Is(err, ErrInvalidProof))
+		assert.Equal(t, "invalid proof", err.Error())
+		return pe.Reason
+	}
+
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+
+	malformed := *enrollment
+	malformed.Proof = &ProofOfSuccess{Term1: []byte{4}}
+	_, _, err = c.EnrollAccount(pwd, &malformed)
+	assert.Equal(t, ProofMalformed, reason(err))
+
+	malformed = *enrollment
+	malformed.C1 = []byte{4}
+	_, _, err = c.EnrollAccount(pwd, &malformed)
+	assert.Equal(t, ProofMalformed, reason(err))
+
+	mismatch := *enrollment
+	mismatch.C0, mismatch.C1 = enrollment.C1, enrollment.C0
+	_, _, err = c.EnrollAccount(pwd, &mismatch)
+	assert.Equal(t, ProofMismatch, reason(err))
+
+	rec, _, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	for _, password := range [][]byte{pwd, []byte("wrong")} {
+		req, err := c.CreateVerifyPasswordRequest(password, rec)
+		assert.NoError(t, err)
+		resp, err := VerifyPassword(serverKeypair, req)
+		assert.NoError(t, err)
+
+		if resp.Res {
+			resp.ProofSuccess.BlindX = bytes.Repeat([]byte{0xff}, 32)
+		} else {
+			resp.ProofFail.BlindA = nil
+		}
+		_, err = c.CheckResponseAndDecrypt(password, rec, resp)
+		assert.Equal(t, ProofMalformed, reason(err))
+
+		resp, err = VerifyPassword(serverKeypair, req)
+		assert.NoError(t, err)
+		if resp.Res {
+			resp.ProofSuccess.Term1, resp.ProofSuccess.Term2 = resp.ProofSuccess.Term2, resp.ProofSuccess.Term1
+		} else {
+			resp.ProofFail.Term1, resp.ProofFail.Term3 = resp.ProofFail.Term3, resp.ProofFail.Term1
+		}
+		_, err = c.CheckResponseAndDecrypt(password, rec, resp)
+		assert.Equal(t, ProofMismatch, reason(err))
+	}
+}
@@ -0,0 +1,267 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType identifies what happened
+type EventType int
+
+const (
+	// EventEnrolled is published when the server issues an enrollment or the client enrolls an account
+	EventEnrolled EventType = iota + 1
+	// EventVerified is published when a password is verified as correct
+	EventVerified
+	// EventVerificationFailed is published when a password is verified as wrong
+	EventVerificationFailed
+	// EventProofInvalid is published when the client rejects a server proof
+	EventProofInvalid
+	// EventRotated is published when server keys are rotated or a client applies an update token
+	EventRotated
+	// EventRecordUpdated is published for every record updated with an update token
+	EventRecordUpdated
+	// EventKeyGenerated is published when a server keypair is generated or derived from a seed
+	EventKeyGenerated
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventEnrolled:
+		return "enrolled"
+	case EventVerified:
+		return "verified"
+	case EventVerificationFailed:
+		return "verification-failed"
+	case EventProofInvalid:
+		return "proof-invalid"
+	case EventRotated:
+		return "rotated"
+	case EventRecordUpdated:
+		return "record-updated"
+	case EventKeyGenerated:
+		return "key-generated"
+	default:
+		return "unknown"
+	}
+}
+
+// EventSource tells which side of the protocol published an event
+type EventSource int
+
+const (
+	// SourceServer events come from Server and server-side functions such as Rotate
+	SourceServer EventSource = iota + 1
+	// SourceClient events come from Client and client-side functions such as UpdateRecord
+	SourceClient
+)
+
+func (s EventSource) String() string {
+	switch s {
+	case SourceServer:
+		return "server"
+	case SourceClient:
+		return "client"
+	default:
+		return "unknown"
+	}
+}
+
+// EventHeader is common to all events
+type EventHeader struct {
+	Type   EventType
+	Source EventSource
+	Time   time.Time
+}
+
+// Header returns the header, it makes every event type an Event
+func (h EventHeader) Header() EventHeader {
+	return h
+}
+
+// Event is one of the *...Event types of this package. Byte slices in events are shared with the caller
+// of the operation and must not be modified
+type Event interface {
+	Header() EventHeader
+}
+
+// EnrolledEvent carries the server nonce of the new enrollment
+type EnrolledEvent struct {
+	EventHeader
+	NS []byte
+}
+
+// VerifiedEvent carries the server nonce of the verified record
+type VerifiedEvent struct {
+	EventHeader
+	NS []byte
+	// Expired is set by the server if the record has expired but was verified anyway, see WithExpiredRecordsFlagged
+	Expired bool
+}
+
+// VerificationFailedEvent carries the server nonce of the record the wrong password was tried for
+type VerificationFailedEvent struct {
+	EventHeader
+	NS []byte
+}
+
+// ProofInvalidEvent carries the reason a server proof was rejected
+type ProofInvalidEvent struct {
+	EventHeader
+	Reason ProofFailure
+}
+
+// RotatedEvent carries the new server public key. Server side rotation also carries
+// the old public key and the update token it issued
+type RotatedEvent struct {
+	EventHeader
+	PublicKey    []byte
+	OldPublicKey []byte
+	Token        *UpdateToken
+}
+
+// KeyGeneratedEvent carries the public key of the new server keypair
+type KeyGeneratedEvent struct {
+	EventHeader
+	PublicKey []byte
+}
+
+// RecordUpdatedEvent carries the server nonce of the updated record, which rotation doesn't change
+type RecordUpdatedEvent struct {
+	EventHeader
+	NS []byte
+}
+
+// EventBus delivers events to subscribers synchronously, in the goroutine performing the operation.
+// Subscribers must be fast and hand slow work such as webhooks over to their own goroutines.
+// Publishing to a bus without subscribers costs an atomic load
+type EventBus struct {
+	mu     sync.RWMutex
+	subs   map[int]*subscription
+	nextID int
+	active atomic.Bool
+}
+
+type subscription struct {
+	fn    func(Event)
+	types map[EventType]bool
+}
+
+// DefaultEventBus receives events of Servers and Clients created without their own bus
+// and of package-level functions such as Rotate and UpdateRecord
+var DefaultEventBus = NewEventBus()
+
+// NewEventBus creates a bus without subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[int]*subscription)}
+}
+
+// WithServerEvents makes the server publish to bus instead of DefaultEventBus
+func WithServerEvents(bus *EventBus) ServerOption {
+	return func(s *Server) {
+		s.events = bus
+	}
+}
+
+// WithClientEvents makes the client publish to bus instead of DefaultEventBus
+func WithClientEvents(bus *EventBus) ClientOption {
+	return func(c *Client) {
+		c.events = bus
+	}
+}
+
+// Subscribe calls fn for every published event of the given types, or of all types if none are given.
+// It returns a function which cancels the subscription
+func (b *EventBus) Subscribe(fn func(Event), types ...EventType) (unsubscribe func()) {
+	sub := &subscription{fn: fn}
+	if len(types) > 0 {
+		sub.types = make(map[EventType]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+
+	b.mu.Lock()
+	id := b.nextID
+	b.nextID++
+	b.subs[id] = sub
+	b.active.Store(true)
+	b.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, id)
+			b.active.Store(len(b.subs) > 0)
+			b.mu.Unlock()
+		})
+	}
+}
+
+// Publish delivers an event to subscribers
+func (b *EventBus) Publish(e Event) {
+	if !b.active.Load() {
+		return
+	}
+
+	t := e.Header().Type
+	b.mu.RLock()
+	fns := make([]func(Event), 0, len(b.subs))
+	for _, sub := range b.subs {
+		if sub.types == nil || sub.types[t] {
+			fns = append(fns, sub.fn)
+		}
+	}
+	b.mu.RUnlock()
+
+	// called without the lock so subscribers may subscribe and unsubscribe
+	for _, fn := range fns {
+		fn(e)
+	}
+}
+
+// publish builds an event only if anyone is subscribed
+func (b *EventBus) publish(t EventType, source EventSource, build func(h EventHeader) Event) {
+	if b == nil || !b.active.Load() {
+		return
+	}
+	b.Publish(build(EventHeader{Type: t, Source: source, Time: time.Now()}))
+}
@@ -0,0 +1,104 @@
+package phe
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type eventLog struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (l *eventLog) add(e Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, e)
+}
+
+func (l *eventLog) types() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var res []string
+	for _, e := range l.events {
+		res = append(res, e.Header().Source.String()+" "+e.Header().Type.String())
+	}
+	return res
+}
+
+func TestEventBus(t *testing.T) {
+	bus := NewEventBus()
+	var all, failures eventLog
+	unsubscribe := bus.Subscribe(all.add)
+	bus.Subscribe(failures.add, EventVerificationFailed, EventProofInvalid)
+
+	serverKeypair, err := GenerateServerKeypair()
+	assert.NoError(t, err)
+	s, err := NewServer(serverKeypair, WithServerEvents(bus))
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), s.PublicKey(), WithClientEvents(bus))
+	assert.NoError(t, err)
+
+	enrollment, err := s.GetEnrollment()
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccount([]byte("password"), enrollment)
+	assert.NoError(t, err)
+
+	for _, pwd := range []string{"password", "wrong"} {
+		req, err := c.CreateVerifyPasswordRequest([]byte(pwd), rec)
+		assert.NoError(t, err)
+		resp, err := s.VerifyPassword(req)
+		assert.NoError(t, err)
+		_, err = c.CheckResponseAndDecrypt([]byte(pwd), rec, resp)
+		assert.NoError(t, err)
+	}
+
+	enrollment.Proof.BlindX = enrollment.Proof.Term1
+	_, _, err = c.EnrollAccount([]byte("password"), enrollment)
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{
+		"server enrolled", "client enrolled",
+		"server verified", "client verified",
+		"server verification-failed", "client verification-failed",
+		"client proof-invalid",
+	}, all.types())
+	assert.Equal(t, []string{"server verification-failed", "client verification-failed", "client proof-invalid"}, failures.types())
+	assert.Equal(t, enrollment.NS, all.events[0].(*EnrolledEvent).NS)
+	assert.Equal(t, ProofMalformed, all.events[6].(*ProofInvalidEvent).Reason)
+
+	unsubscribe()
+	unsubscribe()
+	_, err = s.GetEnrollment()
+	assert.NoError(t, err)
+	assert.Len(t, all.types(), 7)
+}
+
+func TestDefaultEventBus(t *testing.T) {
+	var log eventLog
+	defer DefaultEventBus.Subscribe(log.add, EventRotated, EventRecordUpdated)()
+
+	serverKeypair, err := GenerateServerKeypair()
+	assert.NoError(t, err)
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	pub, err := GetPublicKey(serverKeypair)
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), pub)
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccount([]byte("password"), enrollment)
+	assert.NoError(t, err)
+
+	token, newKeypair, err := Rotate(serverKeypair)
+	assert.NoError(t, err)
+	_, err = UpdateRecord(rec, token)
+	assert.NoError(t, err)
+	assert.NoError(t, c.RotateChecked(token, mustPublicKey(t, newKeypair)))
+
+	assert.Equal(t, []string{"server rotated", "client record-updated", "client rotated"}, log.types())
+	newPub, err := GetPublicKey(newKeypair)
+	assert.NoError(t, err)
+	assert.Equal(t, newPub, log.events[0].(*RotatedEvent).PublicKey)
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Records which expire carry their expiry in the server nonce: 'E' | expiry in Unix seconds | 22 random bytes.
+// The nonce is hashed into the record's points so the expiry can't be changed without breaking verification,
+// and it survives UpdateRecord. Regular nonces are 32 bytes long so the two kinds can't be confused
+const (
+	expiringNonceLen    = 31
+	expiringNoncePrefix = 'E'
+)
+
+// ErrRecordExpired is returned by Server.VerifyPassword for records past their expiry
+var ErrRecordExpired = errors.New("record expired")
+
+// WithRecordLifetime makes records enrolled through the server expire after d.
+// Expired records are refused at verification unless WithExpiredRecordsFlagged is set
+func WithRecordLifetime(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.recordLifetime = d
+	}
+}
+
+// WithExpiredRecordsFlagged makes the server verify expired records as usual and set VerifyPasswordResponse.Expired
+// instead of refusing them, e.g. to force a password change after a successful login
+func WithExpiredRecordsFlagged() ServerOption {
+	return func(s *Server) {
+		s.flagExpired = true
+	}
+}
+
+// RecordExpiry returns when the record expires, ok is false for records which never do.
+// It can be used to clean up dormant accounts without asking the server
+func RecordExpiry(rec *EnrollmentRecord) (expires time.Time, ok bool) {
+	if rec == nil {
+		return
+	}
+	return nonceExpiry(rec.NS)
+}
+
+// stampNonce turns a fresh 32-byte random nonce into an expiring one if the server has a record lifetime
+func (s *Server) stampNonce(ns []byte) []byte {
+	if s.recordLifetime <= 0 {
+		return ns
+	}
+
+	ns[0] = expiringNoncePrefix
+	binary.BigEndian.PutUint64(ns[1:9], uint64(time.Now().Add(s.recordLifetime).Unix()))
+	return ns[:expiringNonceLen]
+}
+
+// checkExpiry refuses or flags an expired nonce
+func (s *Server) checkExpiry(ns []byte) (expired bool, err error) {
+	expires, ok := nonceExpiry(ns)
+	if !ok || time.Now().Before(expires) {
+		return false, nil
+	}
+
+	if !s.flagExpired {
+		return true, ErrRecordExpired
+	}
+	return true, nil
+}
+
+func nonceExpiry(ns []byte) (time.Time, bool) {
+	if len(ns) != expiringNonceLen || ns[0] != expiringNoncePrefix {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(binary.BigEndian.Uint64(ns[1:9])), 0), true
+}
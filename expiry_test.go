@@ -0,0 +1,91 @@
+package phe
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordExpiry(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	s, err := NewServer(serverKeypair, WithRecordLifetime(time.Nanosecond))
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), s.PublicKey())
+	assert.NoError(t, err)
+
+	enrollment, err := s.GetEnrollment()
+	assert.NoError(t, err)
+	rec, key, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	expires, ok := RecordExpiry(rec)
+	assert.True(t, ok)
+	assert.True(t, expires.Before(time.Now()))
+
+	req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	_, err = s.VerifyPassword(req)
+	assert.Equal(t, ErrRecordExpired, err)
+
+	//expiry survives rotation
+	token, newKeypair, err := Rotate(serverKeypair)
+	assert.NoError(t, err)
+	assert.NoError(t, c.RotateChecked(token, mustPublicKey(t, newKeypair)))
+	rec, err = UpdateRecord(rec, token)
+	assert.NoError(t, err)
+	_, ok = RecordExpiry(rec)
+	assert.True(t, ok)
+
+	s, err = NewServer(newKeypair, WithExpiredRecordsFlagged())
+	assert.NoError(t, err)
+	req, err = c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	resp, err := s.VerifyPassword(req)
+	assert.NoError(t, err)
+	assert.True(t, resp.Expired)
+	keyDec, err := c.CheckResponseAndDecrypt(pwd, rec, resp)
+	assert.NoError(t, err)
+	assert.Equal(t, key, keyDec)
+
+	//extending expiry in the record breaks it
+	rec.NS[8]++
+	req, err = c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	resp, err = s.VerifyPassword(req)
+	assert.NoError(t, err)
+	assert.False(t, resp.Res)
+
+	s, err = NewServer(newKeypair, WithRecordLifetime(time.Hour))
+	assert.NoError(t, err)
+	responses, err := s.GetEnrollments(context.Background(), 2)
+	assert.NoError(t, err)
+	for _, resp := range responses {
+		rec, _, err := c.EnrollAccount(pwd, resp)
+		assert.NoError(t, err)
+		expires, ok := RecordExpiry(rec)
+		assert.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), expires, time.Minute)
+	}
+
+	enrollment, err = GetEnrollment(newKeypair)
+	assert.NoError(t, err)
+	rec, _, err = c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+	_, ok = RecordExpiry(rec)
+	assert.False(t, ok)
+}
+
+func TestAuditRecords_ExpiringNonce(t *testing.T) {
+	s, err := NewServer(mustKeypair(t), WithRecordLifetime(time.Hour))
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), s.PublicKey())
+	assert.NoError(t, err)
+
+	enrollment, err := s.GetEnrollment()
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+	assert.True(t, AuditRecords([]*EnrollmentRecord{rec}).Clean())
+}
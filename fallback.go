@@ -0,0 +1,210 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+)
+
+// A fallback verifier lets a client log a user in while the PHE server is unreachable.
+// It is created at a successful login and holds the account key encrypted with a key derived from the password
+// by Argon2id and from the client private key. Layout:
+// version | time | memory | threads | created | expires | salt | record digest | sealed account key
+const (
+	fallbackVersion   byte = 1
+	fallbackSaltLen        = 16
+	fallbackHeaderLen      = 1 + 4 + 4 + 1 + 8 + 8 + fallbackSaltLen + 32
+
+	// a verifier can't make a login take more than this, the memory is in KiB like Argon2's, i.e. 256 MiB
+	fallbackMaxTime   = 64
+	fallbackMaxMemory = 256 << 10
+)
+
+var fallbackInfo = []byte("Fallback")
+
+var (
+	// ErrFallbackDisabled is returned when the policy doesn't allow fallback verifiers
+	ErrFallbackDisabled = errors.New("fallback is disabled")
+	// ErrFallbackExpired is returned for verifiers older than the policy allows
+	ErrFallbackExpired = errors.New("fallback verifier expired")
+	// ErrFallbackStale is returned if the record has changed since the verifier was created, e.g. by UpdateRecord
+	ErrFallbackStale = errors.New("fallback verifier does not match record")
+)
+
+// FallbackPolicy decides whether and for how long logins may bypass the PHE server. The zero value disables fallback.
+//
+// A fallback verifier gives up what PHE is for: anyone holding the verifiers and the client private key can
+// guess passwords offline at the cost of one Argon2id evaluation per guess, and the server neither rate limits
+// nor sees such logins. Keep Lifetime short, store verifiers apart from records and only try them
+// when the server is actually unreachable
+type FallbackPolicy struct {
+	// Lifetime is how long after creation a verifier is accepted. It is checked against the current policy
+	// on every use, so shortening it or setting it to zero takes effect for existing verifiers too
+	Lifetime time.Duration
+	// Time, Memory in KiB and Threads are Argon2id parameters. Verifiers made with less than the current
+	// Time or Memory are refused. Zero values mean 3 passes over 64 MiB with 4 threads, Memory can't exceed 256 MiB
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+func (p FallbackPolicy) params() (t, m uint32, threads uint8) {
+	t, m, threads = p.Time, p.Memory, p.Threads
+	if t == 0 {
+		t = 3
+	}
+	if m == 0 {
+		m = 64 * 1024
+	}
+	if threads == 0 {
+		threads = 4
+	}
+	return
+}
+
+// NewFallbackVerifier creates a verifier for the account after the password was confirmed by the server,
+// key is the account key returned by EnrollAccount or CheckResponseAndDecrypt
+func (c *Client) NewFallbackVerifier(password []byte, rec *EnrollmentRecord, key []byte, policy FallbackPolicy) ([]byte, error) {
+	if policy.Lifetime <= 0 {
+		return nil, ErrFallbackDisabled
+	}
+	if rec == nil {
+		return nil, errors.New("invalid client record")
+	}
+	if len(key) == 0 {
+		return nil, errors.New("invalid key")
+	}
+
+	t, m, threads := policy.params()
+	now := time.Now()
+
+	v := make([]byte, fallbackHeaderLen, fallbackHeaderLen+len(key)+16)
+	v[0] = fallbackVersion
+	binary.BigEndian.PutUint32(v[1:5], t)
+	binary.BigEndian.PutUint32(v[5:9], m)
+	v[9] = threads
+	binary.BigEndian.PutUint64(v[10:18], uint64(now.Unix()))
+	binary.BigEndian.PutUint64(v[18:26], uint64(now.Add(policy.Lifetime).Unix()))
+	random.MustRead(v[26 : 26+fallbackSaltLen])
+	copy(v[26+fallbackSaltLen:], fallbackRecordDigest(rec))
+
+	aead, err := c.fallbackAEAD(password, v)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	return aead.Seal(v, nonce, key, v[:fallbackHeaderLen]), nil
+}
+
+// CheckFallbackVerifier recovers the account key from a verifier without the server.
+// Like CheckResponseAndDecrypt it returns a nil key and no error if the password is wrong
+func (c *Client) CheckFallbackVerifier(password []byte, rec *EnrollmentRecord, verifier []byte, policy FallbackPolicy) (key []byte, err error) {
+	if policy.Lifetime <= 0 {
+		return nil, ErrFallbackDisabled
+	}
+	if rec == nil {
+		return nil, errors.New("invalid client record")
+	}
+	if len(verifier) <= fallbackHeaderLen || verifier[0] != fallbackVersion {
+		return nil, errors.New("invalid fallback verifier")
+	}
+
+	t, m, _ := policy.params()
+	if binary.BigEndian.Uint32(verifier[1:5]) < t || binary.BigEndian.Uint32(verifier[5:9]) < m {
+		return nil, errors.New("fallback verifier is weaker than policy")
+	}
+
+	now := time.Now()
+	created := time.Unix(int64(binary.BigEndian.Uint64(verifier[10:18])), 0)
+	expires := time.Unix(int64(binary.BigEndian.Uint64(verifier[18:26])), 0)
+	if !now.Before(expires) || !now.Before(created.Add(policy.Lifetime)) {
+		return nil, ErrFallbackExpired
+	}
+
+	if !bytes.Equal(verifier[26+fallbackSaltLen:fallbackHeaderLen], fallbackRecordDigest(rec)) {
+		return nil, ErrFallbackStale
+	}
+
+	aead, err := c.fallbackAEAD(password, verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	key, err = aead.Open(nil, nonce, verifier[fallbackHeaderLen:], verifier[:fallbackHeaderLen])
+	if err != nil {
+		return nil, nil
+	}
+	return key, nil
+}
+
+// fallbackAEAD derives the verifier key from the password with parameters and salt from the header.
+// The salt is unique per verifier so a fixed nonce is fine
+func (c *Client) fallbackAEAD(password, header []byte) (cipher.AEAD, error) {
+	t, m, threads := binary.BigEndian.Uint32(header[1:5]), binary.BigEndian.Uint32(header[5:9]), header[9]
+	if t == 0 || t > fallbackMaxTime || m > fallbackMaxMemory || threads == 0 {
+		return nil, errors.New("invalid fallback verifier")
+	}
+
+	password, err := c.normalizePassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	pwKey := argon2.IDKey(password, header[26:26+fallbackSaltLen], t, m, threads, 32)
+	y, release, err := c.privateKey()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ikm := append(pwKey, y...)
+	defer Wipe(pwKey)
+	defer Wipe(ikm)
+	return newAEAD(ikm, fallbackInfo)
+}
+
+func fallbackRecordDigest(rec *EnrollmentRecord) []byte {
+	return TupleHash([][]byte{rec.NS, rec.NC, rec.T0, rec.T1}, fallbackInfo)
+}
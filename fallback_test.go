@@ -0,0 +1,74 @@
+package phe
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testFallbackPolicy = FallbackPolicy{Lifetime: time.Hour, Time: 1, Memory: 64, Threads: 1}
+
+func TestFallbackVerifier(t *testing.T) {
+	serverKeypair, err := GenerateServerKeypair()
+	assert.NoError(t, err)
+	pub, err := GetPublicKey(serverKeypair)
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), pub)
+	assert.NoError(t, err)
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+
+	pwd := []byte("password")
+	rec, key, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	v, err := c.NewFallbackVerifier(pwd, rec, key, testFallbackPolicy)
+	assert.NoError(t, err)
+
+	got, err := c.CheckFallbackVerifier(pwd, rec, v, testFallbackPolicy)
+	assert.NoError(t, err)
+	assert.Equal(t, key, got)
+
+	got, err = c.CheckFallbackVerifier([]byte("wrong"), rec, v, testFallbackPolicy)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+
+	// another client key can't open it
+	other, err := NewClient(GenerateClientKey(), pub)
+	assert.NoError(t, err)
+	got, err = other.CheckFallbackVerifier(pwd, rec, v, testFallbackPolicy)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+
+	// tightening the policy applies to existing verifiers
+	_, err = c.CheckFallbackVerifier(pwd, rec, v, FallbackPolicy{})
+	assert.Equal(t, ErrFallbackDisabled, err)
+	shorter := testFallbackPolicy
+	shorter.Lifetime = time.Nanosecond
+	_, err = c.CheckFallbackVerifier(pwd, rec, v, shorter)
+	assert.Equal(t, ErrFallbackExpired, err)
+	costlier := testFallbackPolicy
+	costlier.Memory = 128
+	_, err = c.CheckFallbackVerifier(pwd, rec, v, costlier)
+	assert.Error(t, err)
+
+	// a tampered verifier can't ask for more memory than the cap
+	greedy := append([]byte(nil), v...)
+	binary.BigEndian.PutUint32(greedy[5:9], fallbackMaxMemory+1)
+	_, err = c.CheckFallbackVerifier(pwd, rec, greedy, testFallbackPolicy)
+	assert.Error(t, err)
+
+	token, _, err := Rotate(serverKeypair)
+	assert.NoError(t, err)
+	updated, err := UpdateRecord(rec, token)
+	assert.NoError(t, err)
+	_, err = c.CheckFallbackVerifier(pwd, updated, v, testFallbackPolicy)
+	assert.Equal(t, ErrFallbackStale, err)
+
+	_, err = c.NewFallbackVerifier(pwd, rec, key, FallbackPolicy{})
+	assert.Equal(t, ErrFallbackDisabled, err)
+	_, err = c.CheckFallbackVerifier(pwd, rec, v[:fallbackHeaderLen], testFallbackPolicy)
+	assert.Error(t, err)
+}
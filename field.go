@@ -0,0 +1,181 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// FieldAlgAES256GCM identifies field blobs encrypted with AES-256-GCM under one key shared by all fields
+	// of the account. FieldCipher still opens them but no longer writes them
+	FieldAlgAES256GCM byte = 1
+	// FieldAlgAES256GCMPerField identifies field blobs encrypted with AES-256-GCM under a key of their own field
+	FieldAlgAES256GCMPerField byte = 2
+
+	fieldNonceLen  = 12
+	fieldHeaderLen = 1 + fieldNonceLen
+	fieldTagLen    = 16
+)
+
+var (
+	fieldInfo    = []byte("FieldCipher")
+	fieldKeyInfo = []byte("FieldCipher/")
+)
+
+// FieldCipher protects individual database columns of a single account with the key
+// returned by EnrollAccount or CheckResponseAndDecrypt. Every field is encrypted with a key of its own,
+// so one field's key can be handed out without the others, see MintDelegation
+type FieldCipher struct {
+	subKey []byte            // nil if the cipher only holds the keys of some fields
+	keys   map[string][]byte // keys of the fields the cipher is limited to
+	userID []byte
+}
+
+// NewFieldCipher creates a field cipher bound to the account's encryption key and user ID.
+// The user ID is mixed into every field's associated data so blobs can't be moved between accounts
+func NewFieldCipher(key, userID []byte) (*FieldCipher, error) {
+	if len(userID) == 0 {
+		return nil, errors.New("invalid user id")
+	}
+
+	subKey, err := deriveSubKey(key, fieldInfo)
+	if err != nil {
+		return nil, err
+	}
+	return &FieldCipher{subKey: subKey, userID: append([]byte{}, userID...)}, nil
+}
+
+// newScopedFieldCipher creates a field cipher which holds the keys of the named fields only
+func newScopedFieldCipher(keys map[string][]byte, userID []byte) (*FieldCipher, error) {
+	if len(userID) == 0 {
+		return nil, errors.New("invalid user id")
+	}
+	for _, k := range keys {
+		if len(k) != 32 {
+			return nil, errors.New("invalid field key")
+		}
+	}
+	return &FieldCipher{keys: keys, userID: append([]byte{}, userID...)}, nil
+}
+
+// fieldKey derives the key of the named field from the field subkey
+func fieldKey(subKey []byte, name string) ([]byte, error) {
+	return deriveSubKey(subKey, append(append([]byte{}, fieldKeyInfo...), name...))
+}
+
+// aead returns the cipher for blobs of alg in the named field
+func (f *FieldCipher) aead(alg byte, name string) (cipher.AEAD, error) {
+	switch {
+	case alg == FieldAlgAES256GCMPerField && f.keys != nil:
+		key, ok := f.keys[name]
+		if !ok {
+			return nil, errors.New("field is out of cipher scope")
+		}
+		return newGCM(key)
+	case alg == FieldAlgAES256GCMPerField:
+		key, err := fieldKey(f.subKey, name)
+		if err != nil {
+			return nil, err
+		}
+		defer Wipe(key)
+		return newGCM(key)
+	case alg == FieldAlgAES256GCM && f.subKey != nil:
+		return newGCM(f.subKey)
+	case alg == FieldAlgAES256GCM:
+		return nil, errors.New("field is out of cipher scope")
+	}
+	return nil, errors.New("unsupported field algorithm")
+}
+
+// EncryptField encrypts the value of a named field and returns a self-describing blob
+// containing the algorithm identifier, the nonce and the sealed value
+func (f *FieldCipher) EncryptField(name string, value []byte) ([]byte, error) {
+	if len(name) == 0 {
+		return nil, errors.New("invalid field name")
+	}
+
+	aead, err := f.aead(FieldAlgAES256GCMPerField, name)
+	if err != nil {
+		return nil, err
+	}
+
+	blob := make([]byte, fieldHeaderLen, fieldHeaderLen+len(value)+aead.Overhead())
+	blob[0] = FieldAlgAES256GCMPerField
+	random.MustRead(blob[1:fieldHeaderLen])
+
+	return aead.Seal(blob, blob[1:fieldHeaderLen], value, f.additionalData(blob[0], name)), nil
+}
+
+// DecryptField opens a blob produced by EncryptField for the same field name and user ID
+func (f *FieldCipher) DecryptField(name string, blob []byte) ([]byte, error) {
+	if len(name) == 0 {
+		return nil, errors.New("invalid field name")
+	}
+
+	if len(blob) < fieldHeaderLen+fieldTagLen {
+		return nil, errors.New("invalid field blob")
+	}
+
+	aead, err := f.aead(blob[0], name)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := aead.Open(nil, blob[1:fieldHeaderLen], blob[fieldHeaderLen:], f.additionalData(blob[0], name))
+	if err != nil {
+		return nil, errors.New("field decryption failed")
+	}
+	return value, nil
+}
+
+// additionalData binds algorithm, field name and user ID into a length-prefixed tuple
+func (f *FieldCipher) additionalData(alg byte, name string) []byte {
+	ad := make([]byte, 0, 1+8+len(name)+8+len(f.userID))
+	ad = append(ad, alg)
+
+	var sizeBuf [8]byte
+	binary.BigEndian.PutUint64(sizeBuf[:], uint64(len(name)))
+	ad = append(ad, sizeBuf[:]...)
+	ad = append(ad, name...)
+	binary.BigEndian.PutUint64(sizeBuf[:], uint64(len(f.userID)))
+	ad = append(ad, sizeBuf[:]...)
+	return append(ad, f.userID...)
+}
@@ -0,0 +1,50 @@
+package phe
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFieldCipher(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+
+	fc, err := NewFieldCipher(key, []byte("user1"))
+	assert.NoError(t, err)
+
+	blob, err := fc.EncryptField("email", []byte("user@example.com"))
+	assert.NoError(t, err)
+	assert.Equal(t, FieldAlgAES256GCMPerField, blob[0])
+
+	value, err := fc.DecryptField("email", blob)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("user@example.com"), value)
+
+	//blob must not open under another field name
+	_, err = fc.DecryptField("phone", blob)
+	assert.Error(t, err)
+
+	//or for another user
+	other, err := NewFieldCipher(key, []byte("user2"))
+	assert.NoError(t, err)
+	_, err = other.DecryptField("email", blob)
+	assert.Error(t, err)
+
+	blob[len(blob)-1] ^= 1
+	_, err = fc.DecryptField("email", blob)
+	assert.Error(t, err)
+
+	//blobs from before fields had keys of their own still open
+	subKey, err := deriveSubKey(key, fieldInfo)
+	assert.NoError(t, err)
+	aead, err := newGCM(subKey)
+	assert.NoError(t, err)
+	legacy := make([]byte, fieldHeaderLen)
+	legacy[0] = FieldAlgAES256GCM
+	legacy = aead.Seal(legacy, legacy[1:], []byte("old"), fc.additionalData(FieldAlgAES256GCM, "email"))
+	value, err = fc.DecryptField("email", legacy)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("old"), value)
+}
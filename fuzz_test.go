@@ -0,0 +1,58 @@
+package phe
+
+import (
+	"testing"
+)
+
+func FuzzPointUnmarshal(f *testing.F) {
+	for _, c := range curves {
+		f.Add(c.gBytes)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p, err := PointUnmarshal(data)
+		if err != nil {
+			return
+		}
+		if string(p.Marshal()) != string(data) {
+			t.Fatal("point encoding is not canonical")
+		}
+	})
+}
+
+func FuzzVerifyPassword(f *testing.F) {
+	kp, err := GenerateServerKeypair()
+	if err != nil {
+		f.Fatal(err)
+	}
+	server, err := NewServer(kp)
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add(make([]byte, 32), p256.gBytes)
+	f.Add([]byte{}, []byte{4})
+
+	f.Fuzz(func(t *testing.T, ns, c0 []byte) {
+		resp, err := server.VerifyPassword(&VerifyPasswordRequest{NS: ns, C0: c0})
+		if err == nil && resp == nil {
+			t.Fatal("nil response without error")
+		}
+	})
+}
+
+func FuzzUpdateRecord(f *testing.F) {
+	f.Add(make([]byte, 32), make([]byte, 32), p256.gBytes, p256.gBytes, []byte{1}, []byte{1})
+	f.Add([]byte{}, []byte{}, []byte{}, []byte{}, []byte{}, []byte{})
+
+	f.Fuzz(func(t *testing.T, ns, nc, t0, t1, a, b []byte) {
+		rec := &EnrollmentRecord{NS: ns, NC: nc, T0: t0, T1: t1}
+		upd, err := UpdateRecord(rec, &UpdateToken{A: a, B: b})
+		if err != nil {
+			return
+		}
+		if _, err = PointUnmarshal(upd.T0); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,260 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+// Package gcmsiv implements AEAD_AES_256_GCM_SIV of RFC 8452. Repeating a nonce only reveals
+// whether the same message was sealed twice under it with the same additional data
+package gcmsiv
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// KeySize is the length of AES-256-GCM-SIV keys
+	KeySize = 32
+	// NonceSize is the length of nonces
+	NonceSize = 12
+	// TagSize is the length of the tag appended to ciphertexts
+	TagSize = 16
+
+	maxPlaintextLen = 1 << 36
+)
+
+type gcmSIV struct {
+	block cipher.Block
+}
+
+// New returns AES-256-GCM-SIV with the key
+func New(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, errors.New("invalid key size")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &gcmSIV{block: block}, nil
+}
+
+func (g *gcmSIV) NonceSize() int {
+	return NonceSize
+}
+
+func (g *gcmSIV) Overhead() int {
+	return TagSize
+}
+
+func (g *gcmSIV) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != NonceSize {
+		panic("gcmsiv: incorrect nonce length")
+	}
+	if uint64(len(plaintext)) > maxPlaintextLen || uint64(len(additionalData)) > maxPlaintextLen {
+		panic("gcmsiv: message too large")
+	}
+
+	authKey, enc := g.deriveKeys(nonce)
+	var tag [TagSize]byte
+	enc.Encrypt(tag[:], polyvalTag(authKey, nonce, plaintext, additionalData))
+
+	ret, out := sliceForAppend(dst, len(plaintext)+TagSize)
+	ctr(enc, out[:len(plaintext)], plaintext, tag)
+	copy(out[len(plaintext):], tag[:])
+	return ret
+}
+
+func (g *gcmSIV) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != NonceSize {
+		panic("gcmsiv: incorrect nonce length")
+	}
+	if len(ciphertext) < TagSize || uint64(len(ciphertext)) > maxPlaintextLen+TagSize || uint64(len(additionalData)) > maxPlaintextLen {
+		return nil, errors.New("gcmsiv: message authentication failed")
+	}
+
+	var tag [TagSize]byte
+	copy(tag[:], ciphertext[len(ciphertext)-TagSize:])
+	ciphertext = ciphertext[:len(ciphertext)-TagSize]
+
+	authKey, enc := g.deriveKeys(nonce)
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	ctr(enc, out, ciphertext, tag)
+
+	var expected [TagSize]byte
+	enc.Encrypt(expected[:], polyvalTag(authKey, nonce, out, additionalData))
+	if subtle.ConstantTimeCompare(expected[:], tag[:]) != 1 {
+		for i := range out {
+			out[i] = 0
+		}
+		return nil, errors.New("gcmsiv: message authentication failed")
+	}
+	return ret, nil
+}
+
+// deriveKeys derives per-nonce message authentication and encryption keys, section 4 of RFC 8452
+func (g *gcmSIV) deriveKeys(nonce []byte) (authKey [16]byte, enc cipher.Block) {
+	var in, out [16]byte
+	var encKey [32]byte
+	copy(in[4:], nonce)
+
+	for i := uint32(0); i < 6; i++ {
+		binary.LittleEndian.PutUint32(in[:4], i)
+		g.block.Encrypt(out[:], in[:])
+		if i < 2 {
+			copy(authKey[8*i:], out[:8])
+		} else {
+			copy(encKey[8*(i-2):], out[:8])
+		}
+	}
+
+	enc, err := aes.NewCipher(encKey[:])
+	if err != nil {
+		panic(err)
+	}
+	return authKey, enc
+}
+
+// polyvalTag returns the block the tag is encrypted from
+func polyvalTag(authKey [16]byte, nonce, plaintext, additionalData []byte) []byte {
+	p := newPolyval(authKey)
+	p.update(additionalData)
+	p.update(plaintext)
+
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[:8], uint64(len(additionalData))*8)
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(plaintext))*8)
+	p.update(lengths[:])
+
+	s := p.sum()
+	for i := range nonce {
+		s[i] ^= nonce[i]
+	}
+	s[15] &= 0x7f
+	return s[:]
+}
+
+// ctr is AES-CTR with a 32 bit little-endian counter in the first 4 bytes of the block, starting at tag with the top bit set
+func ctr(enc cipher.Block, dst, src []byte, tag [TagSize]byte) {
+	counter := tag
+	counter[15] |= 0x80
+	var ks [16]byte
+
+	for len(src) > 0 {
+		enc.Encrypt(ks[:], counter[:])
+		binary.LittleEndian.PutUint32(counter[:4], binary.LittleEndian.Uint32(counter[:4])+1)
+
+		n := subtle.XORBytes(dst, src, ks[:])
+		dst, src = dst[n:], src[n:]
+	}
+}
+
+// polyval is POLYVAL of RFC 8452 over zero-padded input
+type polyval struct {
+	h, s fieldElement
+}
+
+// fieldElement is an element of GF(2^128) defined by x^128 + x^127 + x^126 + x^121 + 1, bit i of lo|hi<<64 being the coefficient of x^i
+type fieldElement struct {
+	lo, hi uint64
+}
+
+func newPolyval(key [16]byte) *polyval {
+	return &polyval{h: loadElement(key[:])}
+}
+
+func loadElement(b []byte) fieldElement {
+	return fieldElement{lo: binary.LittleEndian.Uint64(b[:8]), hi: binary.LittleEndian.Uint64(b[8:16])}
+}
+
+// update absorbs data, the last incomplete block is padded with zeroes
+func (p *polyval) update(data []byte) {
+	var block [16]byte
+	for len(data) > 0 {
+		n := copy(block[:], data)
+		for i := n; i < 16; i++ {
+			block[i] = 0
+		}
+		data = data[n:]
+
+		x := loadElement(block[:])
+		p.s = dot(fieldElement{lo: p.s.lo ^ x.lo, hi: p.s.hi ^ x.hi}, p.h)
+	}
+}
+
+func (p *polyval) sum() [16]byte {
+	var out [16]byte
+	binary.LittleEndian.PutUint64(out[:8], p.s.lo)
+	binary.LittleEndian.PutUint64(out[8:], p.s.hi)
+	return out
+}
+
+// dot returns a·b·x^-128 in constant time with bitwise Montgomery multiplication
+func dot(a, b fieldElement) fieldElement {
+	const polyHi = 0xc200000000000000 // x^127 + x^126 + x^121, plus x^128 and 1 handled below
+
+	var acc fieldElement
+	for i := uint(0); i < 128; i++ {
+		bit := b.lo >> i
+		if i >= 64 {
+			bit = b.hi >> (i - 64)
+		}
+		m := -(bit & 1)
+		acc.lo ^= a.lo & m
+		acc.hi ^= a.hi & m
+
+		// acc is divisible by x after adding P if its constant term is set, x^128 of P becomes the top bit after the shift
+		r := -(acc.lo & 1)
+		acc.lo ^= 1 & r
+		acc.hi ^= polyHi & r
+		acc.lo = acc.lo>>1 | acc.hi<<63
+		acc.hi = acc.hi>>1 | (r & (1 << 63))
+	}
+	return acc
+}
+
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
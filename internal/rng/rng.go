@@ -0,0 +1,118 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+// Package rng is the random source shared by package phe and its group instantiations. It serializes reads
+// and runs a continuous health test on the output
+package rng
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// BlockSize is the size of output blocks compared by the continuous health test
+const BlockSize = 16
+
+// Reader rejects stuck-at-zero output of the underlying source and blocks repeating the previous one
+type Reader struct {
+	mu     sync.Mutex
+	reader io.Reader
+	last   [BlockSize]byte
+	primed bool
+
+	reads, bytes, failures uint64
+}
+
+// Default reads from crypto/rand
+var Default = New(rand.Reader)
+
+// New returns a Reader on top of r
+func New(r io.Reader) *Reader {
+	return &Reader{reader: r}
+}
+
+// Stats returns the number of reads, bytes read and failed reads
+func (r *Reader) Stats() (reads, n, failures uint64) {
+	return atomic.LoadUint64(&r.reads), atomic.LoadUint64(&r.bytes), atomic.LoadUint64(&r.failures)
+}
+
+// Read fills b entirely with random bytes
+func (r *Reader) Read(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	atomic.AddUint64(&r.reads, 1)
+
+	if _, err := io.ReadFull(r.reader, b); err != nil {
+		atomic.AddUint64(&r.failures, 1)
+		return 0, errors.Wrap(err, "random source failed")
+	}
+
+	if err := r.check(b); err != nil {
+		atomic.AddUint64(&r.failures, 1)
+		return 0, err
+	}
+
+	atomic.AddUint64(&r.bytes, uint64(len(b)))
+	return len(b), nil
+}
+
+// check compares every full block of output with the preceding one
+func (r *Reader) check(b []byte) error {
+	var zero [BlockSize]byte
+	for len(b) >= BlockSize {
+		block := b[:BlockSize]
+		if bytes.Equal(block, zero[:]) || (r.primed && bytes.Equal(block, r.last[:])) {
+			return errors.New("random source health check failed")
+		}
+		copy(r.last[:], block)
+		r.primed = true
+		b = b[BlockSize:]
+	}
+	return nil
+}
+
+// MustRead fills b with random bytes and panics if randomness is unavailable
+func (r *Reader) MustRead(b []byte) {
+	if _, err := r.Read(b); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,40 @@
+package rng
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReader_HealthCheck(t *testing.T) {
+	r := New(rand.Reader)
+	buf := make([]byte, 64)
+	_, err := r.Read(buf)
+	assert.NoError(t, err)
+	_, _, failures := r.Stats()
+	assert.Equal(t, uint64(0), failures)
+
+	//stuck at zero
+	r = New(bytes.NewReader(make([]byte, 64)))
+	_, err = r.Read(buf)
+	assert.Error(t, err)
+	_, _, failures = r.Stats()
+	assert.Equal(t, uint64(1), failures)
+
+	//repeating output
+	block := make([]byte, BlockSize)
+	rand.Read(block)
+	r = New(bytes.NewReader(bytes.Repeat(block, 4)))
+	_, err = r.Read(buf)
+	assert.Error(t, err)
+
+	//short source
+	r = New(bytes.NewReader(block))
+	_, err = r.Read(buf)
+	assert.Error(t, err)
+	_, _, failures = r.Stats()
+	assert.Equal(t, uint64(1), failures)
+	assert.Panics(t, func() { r.MustRead(buf) })
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+// Package serverkey holds the server keypair of a network service and rotates it
+package serverkey
+
+import (
+	"context"
+	"sync"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/pkg/errors"
+)
+
+// ErrRotationDisabled is returned by Rotate if no persist function was given
+var ErrRotationDisabled = errors.New("rotation is not enabled")
+
+// PersistError is returned by Rotate if the new keypair could not be stored, the old one stays in use
+type PersistError struct {
+	Err error
+}
+
+func (e *PersistError) Error() string {
+	return "could not persist rotated keypair: " + e.Err.Error()
+}
+
+func (e *PersistError) Unwrap() error {
+	return e.Err
+}
+
+// Holder is a server keypair shared by concurrent requests
+type Holder struct {
+	mu      sync.RWMutex
+	kp      []byte
+	s       *phe.Server
+	opts    []phe.ServerOption
+	persist func(ctx context.Context, newServerKeypair []byte) error
+}
+
+// New parses the keypair. persist stores rotated keypairs durably, rotation is disabled if it's nil
+func New(serverKeypair []byte, persist func(ctx context.Context, newServerKeypair []byte) error, opts ...phe.ServerOption) (*Holder, error) {
+	s, err := phe.NewServer(serverKeypair, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Holder{kp: serverKeypair, s: s, opts: opts, persist: persist}, nil
+}
+
+// Server returns the current server
+func (h *Holder) Server() *phe.Server {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.s
+}
+
+// Rotate replaces the keypair once the new one is persisted and returns the update token.
+// A versioned server moves to the next key version, which has to be configured again after a restart
+func (h *Holder) Rotate(ctx context.Context) (*phe.UpdateToken, error) {
+	if h.persist == nil {
+		return nil, ErrRotationDisabled
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	token, kp, err := phe.Rotate(h.kp)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := h.opts
+	if v := h.s.KeyVersion(); v != 0 {
+		opts = append(opts[:len(opts):len(opts)], phe.WithKeyVersion(v+1))
+	}
+	s, err := phe.NewServer(kp, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = h.persist(ctx, kp); err != nil {
+		return nil, &PersistError{Err: err}
+	}
+
+	h.kp, h.s = kp, s
+	return token, nil
+}
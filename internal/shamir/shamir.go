@@ -0,0 +1,151 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+// Package shamir splits secrets modulo a prime into shares any threshold of which recover the secret
+package shamir
+
+import (
+	"crypto/rand"
+	"io"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// MaxShares is the largest number of shares a secret can be split into
+const MaxShares = 255
+
+// Share is the value of the sharing polynomial at X
+type Share struct {
+	X int
+	Y *big.Int
+}
+
+// Split returns n shares of secret any t of which recover it. Coefficients are read from rnd,
+// crypto/rand if it's nil
+func Split(secret *big.Int, n, t int, p *big.Int, rnd io.Reader) ([]Share, error) {
+	if t < 1 || n < t || n > MaxShares {
+		return nil, errors.New("invalid share parameters")
+	}
+	if secret.Sign() < 0 || secret.Cmp(p) >= 0 {
+		return nil, errors.New("secret out of range")
+	}
+	if rnd == nil {
+		rnd = rand.Reader
+	}
+
+	coeffs := make([]*big.Int, t)
+	coeffs[0] = secret
+	for i := 1; i < t; i++ {
+		c, err := rand.Int(rnd, p)
+		if err != nil {
+			return nil, err
+		}
+		coeffs[i] = c
+	}
+
+	shares := make([]Share, n)
+	for i := range shares {
+		x := big.NewInt(int64(i + 1))
+		// Horner's rule
+		y := new(big.Int)
+		for j := t - 1; j >= 0; j-- {
+			y.Mul(y, x)
+			y.Add(y, coeffs[j])
+			y.Mod(y, p)
+		}
+		shares[i] = Share{X: i + 1, Y: y}
+	}
+	return shares, nil
+}
+
+// Combine interpolates the secret from shares. It can't tell if fewer than threshold shares are given,
+// the result is then a random value and has to be checked by the caller
+func Combine(shares []Share, p *big.Int) (*big.Int, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares")
+	}
+
+	xs := make([]int, len(shares))
+	for i, s := range shares {
+		if s.Y == nil || s.Y.Sign() < 0 || s.Y.Cmp(p) >= 0 {
+			return nil, errors.New("invalid share")
+		}
+		xs[i] = s.X
+	}
+	coeffs, err := Coefficients(xs, 0, p)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := new(big.Int)
+	for i, s := range shares {
+		secret.Add(secret, coeffs[i].Mul(coeffs[i], s.Y))
+	}
+	return secret.Mod(secret, p), nil
+}
+
+// Coefficients returns Lagrange coefficients l such that f(at) = sum of l[i]·f(xs[i]) mod p for every
+// polynomial f of degree below len(xs). They also interpolate shares multiplied to a group element
+func Coefficients(xs []int, at int, p *big.Int) ([]*big.Int, error) {
+	seen := make(map[int]bool, len(xs))
+	for _, x := range xs {
+		if x < 1 || x > MaxShares {
+			return nil, errors.New("invalid share")
+		}
+		if seen[x] {
+			return nil, errors.New("duplicate share")
+		}
+		seen[x] = true
+	}
+
+	coeffs := make([]*big.Int, len(xs))
+	for i, xi := range xs {
+		num, den := big.NewInt(1), big.NewInt(1)
+		for j, xj := range xs {
+			if i == j {
+				continue
+			}
+			num.Mul(num, big.NewInt(int64(at-xj)))
+			num.Mod(num, p)
+			den.Mul(den, big.NewInt(int64(xi-xj)))
+			den.Mod(den, p)
+		}
+		coeffs[i] = num.Mul(num, den.ModInverse(den, p))
+		coeffs[i].Mod(coeffs[i], p)
+	}
+	return coeffs, nil
+}
@@ -0,0 +1,77 @@
+package shamir
+
+import (
+	"crypto/elliptic"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitCombine(t *testing.T) {
+	p := elliptic.P256().Params().N
+	secret := big.NewInt(0xc0ffee)
+
+	shares, err := Split(secret, 5, 3, p, nil)
+	assert.NoError(t, err)
+	assert.Len(t, shares, 5)
+
+	for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		var s []Share
+		for _, i := range subset {
+			s = append(s, shares[i])
+		}
+		got, err := Combine(s, p)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, secret.Cmp(got), "%v", subset)
+	}
+
+	got, err := Combine(shares[:2], p)
+	assert.NoError(t, err)
+	assert.NotEqual(t, 0, secret.Cmp(got))
+}
+
+func TestSplit_Invalid(t *testing.T) {
+	p := elliptic.P256().Params().N
+
+	for _, nt := range [][2]int{{0, 0}, {2, 3}, {3, 0}, {MaxShares + 1, 2}} {
+		_, err := Split(big.NewInt(1), nt[0], nt[1], p, nil)
+		assert.Error(t, err, "%v", nt)
+	}
+
+	_, err := Split(p, 3, 2, p, nil)
+	assert.Error(t, err)
+}
+
+func TestCombine_Invalid(t *testing.T) {
+	p := elliptic.P256().Params().N
+	shares, err := Split(big.NewInt(1), 3, 2, p, nil)
+	assert.NoError(t, err)
+
+	_, err = Combine(nil, p)
+	assert.Error(t, err)
+
+	_, err = Combine([]Share{shares[0], shares[0]}, p)
+	assert.Error(t, err)
+
+	_, err = Combine([]Share{{X: 0, Y: shares[0].Y}, shares[1]}, p)
+	assert.Error(t, err)
+}
+
+func TestCoefficients(t *testing.T) {
+	p := big.NewInt(7919)
+	shares, err := Split(big.NewInt(1234), 5, 3, p, nil)
+	assert.NoError(t, err)
+
+	//any 3 shares predict the other two
+	coeffs, err := Coefficients([]int{1, 3, 5}, 4, p)
+	assert.NoError(t, err)
+	y := new(big.Int)
+	for i, idx := range []int{0, 2, 4} {
+		y.Add(y, new(big.Int).Mul(coeffs[i], shares[idx].Y))
+	}
+	assert.Equal(t, shares[3].Y, y.Mod(y, p))
+
+	_, err = Coefficients([]int{1, 1}, 0, p)
+	assert.Error(t, err)
+}
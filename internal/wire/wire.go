@@ -0,0 +1,170 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+// Package wire contains bounds-checked decoders for every field PHE messages and keys carry.
+// All parsing of untrusted input goes through here so it can be fuzzed in one place
+package wire
+
+import (
+	"crypto/elliptic"
+	"encoding/asn1"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// MaxNonceLen is the longest server or client nonce accepted
+const MaxNonceLen = 32
+
+var (
+	errPoint   = errors.New("Invalid curve point")
+	errScalar  = errors.New("invalid scalar")
+	errNonce   = errors.New("invalid nonce")
+	errKeypair = errors.New("invalid keypair")
+)
+
+// Keypair is the ASN.1 structure server keypairs are serialized to
+type Keypair struct {
+	PublicKey  []byte
+	PrivateKey []byte
+}
+
+// Point decodes an uncompressed point and checks it lies on curve and isn't the point at infinity
+func Point(curve elliptic.Curve, data []byte) (x, y *big.Int, err error) {
+	params := curve.Params()
+	byteLen := (params.BitSize + 7) / 8
+	if len(data) != 1+2*byteLen || data[0] != 4 {
+		return nil, nil, errPoint
+	}
+
+	x = new(big.Int).SetBytes(data[1 : 1+byteLen])
+	y = new(big.Int).SetBytes(data[1+byteLen:])
+	if x.Cmp(params.P) >= 0 || y.Cmp(params.P) >= 0 {
+		return nil, nil, errPoint
+	}
+
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return nil, nil, errPoint
+	}
+
+	if !curve.IsOnCurve(x, y) {
+		return nil, nil, errPoint
+	}
+	return
+}
+
+// Scalar decodes a big-endian integer of 1 to maxLen bytes which must be less than n
+func Scalar(data []byte, maxLen int, n *big.Int) (*big.Int, error) {
+	if len(data) == 0 || len(data) > maxLen {
+		return nil, errScalar
+	}
+
+	z := new(big.Int).SetBytes(data)
+	if z.Cmp(n) >= 0 {
+		return nil, errScalar
+	}
+	return z, nil
+}
+
+// Nonce checks a nonce is present and not longer than MaxNonceLen
+func Nonce(data []byte) error {
+	if len(data) == 0 || len(data) > MaxNonceLen {
+		return errNonce
+	}
+	return nil
+}
+
+// UnmarshalKeypair decodes a DER keypair rejecting trailing data and empty fields
+func UnmarshalKeypair(data []byte) (*Keypair, error) {
+	kp := &Keypair{}
+	rest, err := asn1.Unmarshal(data, kp)
+	if err != nil || len(rest) != 0 {
+		return nil, errKeypair
+	}
+
+	if len(kp.PublicKey) == 0 || len(kp.PrivateKey) == 0 {
+		return nil, errKeypair
+	}
+	return kp, nil
+}
+
+// MarshalKeypair encodes a keypair into DER
+func MarshalKeypair(publicKey, privateKey []byte) ([]byte, error) {
+	return asn1.Marshal(Keypair{
+		PublicKey:  publicKey,
+		PrivateKey: privateKey,
+	})
+}
+
+// CompressedPoint decodes a SEC 1 compressed point on the curve y² = x³ + a·x + b.
+// Curve's field prime must be 3 mod 4 which holds for all curves PHE is instantiated with
+func CompressedPoint(curve elliptic.Curve, a *big.Int, data []byte) (x, y *big.Int, err error) {
+	params := curve.Params()
+	byteLen := (params.BitSize + 7) / 8
+	if len(data) != 1+byteLen || (data[0] != 2 && data[0] != 3) {
+		return nil, nil, errPoint
+	}
+
+	p := params.P
+	x = new(big.Int).SetBytes(data[1:])
+	if x.Cmp(p) >= 0 {
+		return nil, nil, errPoint
+	}
+
+	// y² = x³ + a·x + b
+	rhs := new(big.Int).Mul(x, x)
+	rhs.Add(rhs, a)
+	rhs.Mul(rhs, x)
+	rhs.Add(rhs, params.B)
+	rhs.Mod(rhs, p)
+
+	exp := new(big.Int).Add(p, big.NewInt(1))
+	exp.Rsh(exp, 2)
+	y = new(big.Int).Exp(rhs, exp, p)
+
+	if new(big.Int).Exp(y, big.NewInt(2), p).Cmp(rhs) != 0 || y.Sign() == 0 {
+		return nil, nil, errPoint
+	}
+
+	if y.Bit(0) != uint(data[0]&1) {
+		y.Sub(p, y)
+	}
+
+	if !curve.IsOnCurve(x, y) {
+		return nil, nil, errPoint
+	}
+	return
+}
@@ -0,0 +1,174 @@
+package wire
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoint(t *testing.T) {
+	c := elliptic.P256()
+	_, x, y, err := elliptic.GenerateKey(c, rand.Reader)
+	assert.NoError(t, err)
+	data := elliptic.Marshal(c, x, y)
+
+	px, py, err := Point(c, data)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, x.Cmp(px))
+	assert.Equal(t, 0, y.Cmp(py))
+
+	_, _, err = Point(c, data[:len(data)-1])
+	assert.Error(t, err)
+
+	compressed := append([]byte{}, data...)
+	compressed[0] = 2
+	_, _, err = Point(c, compressed)
+	assert.Error(t, err)
+
+	offCurve := append([]byte{}, data...)
+	offCurve[len(offCurve)-1] ^= 1
+	_, _, err = Point(c, offCurve)
+	assert.Error(t, err)
+
+	_, _, err = Point(c, make([]byte, 65))
+	assert.Error(t, err)
+}
+
+func TestCompressedPoint(t *testing.T) {
+	for _, c := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		a := new(big.Int).Sub(c.Params().P, big.NewInt(3))
+		for i := 0; i < 4; i++ {
+			_, x, y, err := elliptic.GenerateKey(c, rand.Reader)
+			assert.NoError(t, err)
+
+			px, py, err := CompressedPoint(c, a, elliptic.MarshalCompressed(c, x, y))
+			assert.NoError(t, err)
+			assert.Equal(t, 0, x.Cmp(px))
+			assert.Equal(t, 0, y.Cmp(py))
+		}
+
+		data := elliptic.MarshalCompressed(c, c.Params().Gx, c.Params().Gy)
+		_, _, err := CompressedPoint(c, a, data[1:])
+		assert.Error(t, err)
+
+		data[0] = 4
+		_, _, err = CompressedPoint(c, a, data)
+		assert.Error(t, err)
+	}
+}
+
+func TestScalar(t *testing.T) {
+	n := elliptic.P256().Params().N
+
+	z, err := Scalar([]byte{1}, 32, n)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), z.Int64())
+
+	_, err = Scalar(nil, 32, n)
+	assert.Error(t, err)
+
+	_, err = Scalar(make([]byte, 33), 32, n)
+	assert.Error(t, err)
+
+	_, err = Scalar(n.Bytes(), 32, n)
+	assert.Error(t, err)
+
+	_, err = Scalar(new(big.Int).Sub(n, big.NewInt(1)).Bytes(), 32, n)
+	assert.NoError(t, err)
+}
+
+func TestKeypair(t *testing.T) {
+	data, err := MarshalKeypair([]byte{1, 2}, []byte{3})
+	assert.NoError(t, err)
+
+	kp, err := UnmarshalKeypair(data)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1, 2}, kp.PublicKey)
+	assert.Equal(t, []byte{3}, kp.PrivateKey)
+
+	_, err = UnmarshalKeypair(append(data, 0))
+	assert.Error(t, err)
+
+	data, err = MarshalKeypair([]byte{1}, nil)
+	assert.NoError(t, err)
+	_, err = UnmarshalKeypair(data)
+	assert.Error(t, err)
+}
+
+func FuzzPoint(f *testing.F) {
+	c := elliptic.P256()
+	f.Add(elliptic.Marshal(c, c.Params().Gx, c.Params().Gy))
+	f.Add([]byte{4})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		x, y, err := Point(c, data)
+		if err != nil {
+			return
+		}
+		if !c.IsOnCurve(x, y) {
+			t.Fatal("accepted point is not on curve")
+		}
+		if string(elliptic.Marshal(c, x, y)) != string(data) {
+			t.Fatal("point encoding is not canonical")
+		}
+	})
+}
+
+func FuzzCompressedPoint(f *testing.F) {
+	c := elliptic.P256()
+	a := new(big.Int).Sub(c.Params().P, big.NewInt(3))
+	f.Add(elliptic.MarshalCompressed(c, c.Params().Gx, c.Params().Gy))
+	f.Add([]byte{3})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		x, y, err := CompressedPoint(c, a, data)
+		if err != nil {
+			return
+		}
+		if !c.IsOnCurve(x, y) {
+			t.Fatal("accepted point is not on curve")
+		}
+		if string(elliptic.MarshalCompressed(c, x, y)) != string(data) {
+			t.Fatal("point encoding is not canonical")
+		}
+	})
+}
+
+func FuzzScalar(f *testing.F) {
+	n := elliptic.P256().Params().N
+	f.Add([]byte{1})
+	f.Add(n.Bytes())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		z, err := Scalar(data, 32, n)
+		if err != nil {
+			return
+		}
+		if z.Sign() < 0 || z.Cmp(n) >= 0 {
+			t.Fatal("accepted scalar is out of range")
+		}
+	})
+}
+
+func FuzzUnmarshalKeypair(f *testing.F) {
+	seed, _ := MarshalKeypair([]byte{4, 1, 2}, []byte{1})
+	f.Add(seed)
+	f.Add([]byte{0x30, 0x00})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		kp, err := UnmarshalKeypair(data)
+		if err != nil {
+			return
+		}
+		again, err := MarshalKeypair(kp.PublicKey, kp.PrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = UnmarshalKeypair(again); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
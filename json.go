@@ -0,0 +1,202 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// Message types encode byte fields as base64 strings under their json tags. Decoding is strict:
+// unknown fields, missing fields and values which can't be a nonce, point or scalar of any supported curve
+// are rejected. Whether points lie on the curve is checked when the message is used
+
+// MarshalJSON implements json.Marshaler
+func (c *EnrollmentRecord) MarshalJSON() ([]byte, error) {
+	type plain EnrollmentRecord
+	return json.Marshal((*plain)(c))
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (c *EnrollmentRecord) UnmarshalJSON(data []byte) error {
+	type plain EnrollmentRecord
+	var v plain
+	if err := decodeStrict(data, &v); err != nil {
+		return errors.Wrap(err, "invalid record")
+	}
+	if rec := EnrollmentRecord(v); !rec.wellFormed() {
+		return errors.New("invalid record")
+	}
+	*c = EnrollmentRecord(v)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (p *ProofOfSuccess) MarshalJSON() ([]byte, error) {
+	type plain ProofOfSuccess
+	return json.Marshal((*plain)(p))
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (p *ProofOfSuccess) UnmarshalJSON(data []byte) error {
+	type plain ProofOfSuccess
+	var v plain
+	if err := decodeStrict(data, &v); err != nil {
+		return errors.Wrap(err, "invalid proof")
+	}
+	if proof := ProofOfSuccess(v); !proof.wellFormed() {
+		return errors.New("invalid proof")
+	}
+	*p = ProofOfSuccess(v)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (p *ProofOfFail) MarshalJSON() ([]byte, error) {
+	type plain ProofOfFail
+	return json.Marshal((*plain)(p))
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (p *ProofOfFail) UnmarshalJSON(data []byte) error {
+	type plain ProofOfFail
+	var v plain
+	if err := decodeStrict(data, &v); err != nil {
+		return errors.Wrap(err, "invalid proof")
+	}
+	if proof := ProofOfFail(v); !proof.wellFormed() {
+		return errors.New("invalid proof")
+	}
+	*p = ProofOfFail(v)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (t *UpdateToken) MarshalJSON() ([]byte, error) {
+	type plain UpdateToken
+	return json.Marshal((*plain)(t))
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (t *UpdateToken) UnmarshalJSON(data []byte) error {
+	type plain UpdateToken
+	var v plain
+	if err := decodeStrict(data, &v); err != nil {
+		return errors.Wrap(err, "invalid update token")
+	}
+	if token := UpdateToken(v); !token.wellFormed() {
+		return errors.New("invalid update token")
+	}
+	*t = UpdateToken(v)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (r *EnrollmentResponse) MarshalJSON() ([]byte, error) {
+	type plain EnrollmentResponse
+	return json.Marshal((*plain)(r))
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (r *EnrollmentResponse) UnmarshalJSON(data []byte) error {
+	type plain EnrollmentResponse
+	var v plain
+	if err := decodeStrict(data, &v); err != nil {
+		return errors.Wrap(err, "invalid enrollment response")
+	}
+	if resp := EnrollmentResponse(v); !resp.wellFormed() {
+		return errors.New("invalid enrollment response")
+	}
+	*r = EnrollmentResponse(v)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (r *VerifyPasswordRequest) MarshalJSON() ([]byte, error) {
+	type plain VerifyPasswordRequest
+	return json.Marshal((*plain)(r))
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (r *VerifyPasswordRequest) UnmarshalJSON(data []byte) error {
+	type plain VerifyPasswordRequest
+	var v plain
+	if err := decodeStrict(data, &v); err != nil {
+		return errors.Wrap(err, "invalid password verify request")
+	}
+	req := VerifyPasswordRequest{NS: v.NS, C0: v.C0, KeyVersion: v.KeyVersion, Nonce: v.Nonce}
+	if !req.wellFormed() {
+		return errors.New("invalid password verify request")
+	}
+	*r = req
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (r *VerifyPasswordResponse) MarshalJSON() ([]byte, error) {
+	type plain VerifyPasswordResponse
+	return json.Marshal((*plain)(r))
+}
+
+// UnmarshalJSON implements json.Unmarshaler. The response must carry exactly the proof its result calls for
+func (r *VerifyPasswordResponse) UnmarshalJSON(data []byte) error {
+	type plain VerifyPasswordResponse
+	var v plain
+	if err := decodeStrict(data, &v); err != nil {
+		return errors.Wrap(err, "invalid password verify response")
+	}
+	if resp := VerifyPasswordResponse(v); !resp.wellFormed() {
+		return errors.New("invalid password verify response")
+	}
+	*r = VerifyPasswordResponse(v)
+	return nil
+}
+
+// decodeStrict decodes a single JSON object rejecting unknown fields and trailing data
+func decodeStrict(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("unexpected data after object")
+	}
+	return nil
+}
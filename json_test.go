@@ -0,0 +1,116 @@
+package phe
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSON_RoundTrip(t *testing.T) {
+	serverKeypair, err := GenerateServerKeypair()
+	assert.NoError(t, err)
+	s, err := NewServer(serverKeypair)
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), s.PublicKey())
+	assert.NoError(t, err)
+
+	enrollment, err := s.GetEnrollment()
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccount([]byte("password"), enrollment)
+	assert.NoError(t, err)
+	token, _, err := Rotate(serverKeypair)
+	assert.NoError(t, err)
+
+	messages := []interface{}{enrollment, rec, token}
+	for _, pwd := range []string{"password", "wrong"} {
+		req, err := c.CreateVerifyPasswordRequest([]byte(pwd), rec)
+		assert.NoError(t, err)
+		resp, err := s.VerifyPassword(req)
+		assert.NoError(t, err)
+		messages = append(messages, req, resp)
+	}
+
+	for _, m := range messages {
+		data, err := json.Marshal(m)
+		assert.NoError(t, err)
+		switch v := m.(type) {
+		case *EnrollmentResponse:
+			var res EnrollmentResponse
+			assert.NoError(t, json.Unmarshal(data, &res))
+			assert.Equal(t, v, &res)
+		case *EnrollmentRecord:
+			var res EnrollmentRecord
+			assert.NoError(t, json.Unmarshal(data, &res))
+			assert.Equal(t, v, &res)
+		case *UpdateToken:
+			var res UpdateToken
+			assert.NoError(t, json.Unmarshal(data, &res))
+			assert.Equal(t, v, &res)
+		case *VerifyPasswordRequest:
+			var res VerifyPasswordRequest
+			assert.NoError(t, json.Unmarshal(data, &res))
+			assert.Equal(t, v.NS, res.NS)
+			assert.Equal(t, v.C0, res.C0)
+		case *VerifyPasswordResponse:
+			var res VerifyPasswordResponse
+			assert.NoError(t, json.Unmarshal(data, &res))
+			assert.Equal(t, v, &res)
+		}
+	}
+
+	data, err := json.Marshal(rec)
+	assert.NoError(t, err)
+	var fields map[string]string
+	assert.NoError(t, json.Unmarshal(data, &fields))
+	assert.Len(t, fields, 4)
+}
+
+func TestJSON_Strict(t *testing.T) {
+	serverKeypair, err := GenerateServerKeypair()
+	assert.NoError(t, err)
+	s, err := NewServer(serverKeypair)
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), s.PublicKey())
+	assert.NoError(t, err)
+	enrollment, err := s.GetEnrollment()
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccount([]byte("password"), enrollment)
+	assert.NoError(t, err)
+	req, err := c.CreateVerifyPasswordRequest([]byte("wrong"), rec)
+	assert.NoError(t, err)
+	resp, err := s.VerifyPassword(req)
+	assert.NoError(t, err)
+
+	tamper := func(v interface{}, f func(m map[string]interface{})) []byte {
+		data, err := json.Marshal(v)
+		assert.NoError(t, err)
+		var m map[string]interface{}
+		assert.NoError(t, json.Unmarshal(data, &m))
+		f(m)
+		data, err = json.Marshal(m)
+		assert.NoError(t, err)
+		return data
+	}
+
+	var r EnrollmentRecord
+	assert.Error(t, json.Unmarshal(tamper(rec, func(m map[string]interface{}) { m["extra"] = "AA==" }), &r))
+	assert.Error(t, json.Unmarshal(tamper(rec, func(m map[string]interface{}) { delete(m, "t_1") }), &r))
+	assert.Error(t, json.Unmarshal(tamper(rec, func(m map[string]interface{}) { m["t_0"] = "AAAA" }), &r))
+	assert.Error(t, json.Unmarshal(tamper(rec, func(m map[string]interface{}) { m["ns"] = "not base64!" }), &r))
+	assert.Error(t, json.Unmarshal([]byte(`{"ns":"AA=="} {}`), &r))
+
+	var e EnrollmentResponse
+	assert.Error(t, json.Unmarshal(tamper(enrollment, func(m map[string]interface{}) { delete(m, "proof") }), &e))
+	assert.Error(t, json.Unmarshal(tamper(enrollment, func(m map[string]interface{}) {
+		m["proof"].(map[string]interface{})["blind_x"] = ""
+	}), &e))
+
+	var vr VerifyPasswordResponse
+	assert.NoError(t, json.Unmarshal(tamper(resp, func(map[string]interface{}) {}), &vr))
+	assert.Error(t, json.Unmarshal(tamper(resp, func(m map[string]interface{}) { m["res"] = true }), &vr))
+	assert.Error(t, json.Unmarshal(tamper(resp, func(m map[string]interface{}) { m["proof_success"] = m["proof_fail"] }), &vr))
+
+	var tok UpdateToken
+	assert.Error(t, json.Unmarshal([]byte(`{"a":"AQ=="}`), &tok))
+}
@@ -0,0 +1,247 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// JWKAlg is the "alg" value of PHE server keys. It's not registered with IANA, keys carrying
+	// any other algorithm are refused on import so PHE keys can't be mistaken for signing keys
+	JWKAlg = "PHE"
+	// JWKUse is the "use" value of PHE server keys
+	JWKUse = "enc"
+)
+
+// JWK is an elliptic curve JSON Web Key (RFC 7517, RFC 7518) holding a server public key and optionally
+// its private key. Curve names are the ones of RFC 7518 and RFC 8812
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	D   string `json:"d,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set
+type JWKSet struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// PublicKeyJWK exports a server public key. Empty kid is replaced with the key's RFC 7638 thumbprint
+func PublicKeyJWK(serverPublicKey []byte, kid string) (*JWK, error) {
+	pub, err := PointUnmarshal(serverPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return newJWK(pub, nil, kid)
+}
+
+// KeypairJWK exports a server keypair including its private key. Empty kid is replaced with the key's RFC 7638 thumbprint
+func KeypairJWK(serverKeypair []byte, kid string) (*JWK, error) {
+	s, err := NewServer(serverKeypair)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := s.curve.pointUnmarshal(s.kp.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return newJWK(pub, s.priv, kid)
+}
+
+func newJWK(pub *Point, priv []byte, kid string) (*JWK, error) {
+	c := pub.curve
+	coordLen := (c.pointLen - 1) / 2
+	k := &JWK{
+		Kty: "EC",
+		Crv: c.name,
+		X:   b64(pub.X.FillBytes(make([]byte, coordLen))),
+		Y:   b64(pub.Y.FillBytes(make([]byte, coordLen))),
+		Use: JWKUse,
+		Alg: JWKAlg,
+		Kid: kid,
+	}
+	if priv != nil {
+		k.D = b64(priv)
+	}
+
+	if k.Kid == "" {
+		thumbprint, err := k.Thumbprint()
+		if err != nil {
+			return nil, err
+		}
+		k.Kid = thumbprint
+	}
+	return k, nil
+}
+
+// Thumbprint returns base64url encoded RFC 7638 SHA-256 thumbprint of the public key
+func (k *JWK) Thumbprint() (string, error) {
+	if _, err := k.PublicKey(); err != nil {
+		return "", err
+	}
+
+	//members in lexicographic order, no whitespace
+	canonical, err := json.Marshal(struct {
+		Crv string `json:"crv"`
+		Kty string `json:"kty"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}{k.Crv, k.Kty, k.X, k.Y})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(canonical)
+	return b64(sum[:]), nil
+}
+
+// PublicKey validates the key and returns the server public key it holds
+func (k *JWK) PublicKey() ([]byte, error) {
+	pub, err := k.point()
+	if err != nil {
+		return nil, err
+	}
+	return pub.Marshal(), nil
+}
+
+// Keypair validates the key and returns the server keypair it holds. The key must include its private part
+func (k *JWK) Keypair() ([]byte, error) {
+	pub, err := k.point()
+	if err != nil {
+		return nil, err
+	}
+	if k.D == "" {
+		return nil, errors.New("jwk has no private key")
+	}
+
+	c := pub.curve
+	priv, err := unb64(k.D)
+	if err != nil || len(priv) != c.scalarLen {
+		return nil, errors.New("invalid jwk private key")
+	}
+	x, err := c.parseScalar(priv)
+	if err != nil || x.Sign() == 0 {
+		return nil, errors.New("invalid jwk private key")
+	}
+	if !c.scalarBaseMult(x).Equal(pub) {
+		return nil, errors.New("jwk public key doesn't match private key")
+	}
+
+	return marshalKeypair(pub.Marshal(), priv)
+}
+
+// point checks key parameters and decodes the public point
+func (k *JWK) point() (*Point, error) {
+	if k.Kty != "EC" {
+		return nil, errors.Errorf("unsupported jwk key type %q", k.Kty)
+	}
+	if k.Alg != "" && k.Alg != JWKAlg {
+		return nil, errors.Errorf("unsupported jwk algorithm %q", k.Alg)
+	}
+	if k.Use != "" && k.Use != JWKUse {
+		return nil, errors.Errorf("unsupported jwk use %q", k.Use)
+	}
+
+	var c *Curve
+	for _, cc := range curves {
+		if cc.name == k.Crv {
+			c = cc
+		}
+	}
+	if c == nil {
+		return nil, errors.Errorf("unsupported jwk curve %q", k.Crv)
+	}
+
+	coordLen := (c.pointLen - 1) / 2
+	x, errX := unb64(k.X)
+	y, errY := unb64(k.Y)
+	if errX != nil || errY != nil || len(x) != coordLen || len(y) != coordLen {
+		return nil, errors.New("invalid jwk coordinates")
+	}
+
+	pub, err := c.pointUnmarshal(append(append([]byte{4}, x...), y...))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid jwk public key")
+	}
+	return pub, nil
+}
+
+// Public returns a copy of the key without its private part
+func (k *JWK) Public() *JWK {
+	pub := *k
+	pub.D = ""
+	return &pub
+}
+
+// Key returns the key with the given ID or nil
+func (s *JWKSet) Key(kid string) *JWK {
+	for _, k := range s.Keys {
+		if k != nil && k.Kid == kid {
+			return k
+		}
+	}
+	return nil
+}
+
+// Public returns a copy of the set without private keys, suitable for publishing
+func (s *JWKSet) Public() *JWKSet {
+	pub := &JWKSet{Keys: make([]*JWK, 0, len(s.Keys))}
+	for _, k := range s.Keys {
+		if k != nil {
+			pub.Keys = append(pub.Keys, k.Public())
+		}
+	}
+	return pub
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func unb64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
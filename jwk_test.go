@@ -0,0 +1,88 @@
+package phe
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJWK_RoundTrip(t *testing.T) {
+	for _, curve := range curves {
+		serverKeypair, err := GenerateServerKeypairForCurve(curve)
+		assert.NoError(t, err)
+		pub := mustPublicKey(t, serverKeypair)
+
+		k, err := KeypairJWK(serverKeypair, "")
+		assert.NoError(t, err)
+		assert.Equal(t, curve.Name(), k.Crv)
+		assert.NotEmpty(t, k.Kid)
+
+		data, err := json.Marshal(&JWKSet{Keys: []*JWK{k}})
+		assert.NoError(t, err)
+
+		var set JWKSet
+		assert.NoError(t, json.Unmarshal(data, &set))
+		imported := set.Key(k.Kid)
+		if !assert.NotNil(t, imported) {
+			continue
+		}
+		kp, err := imported.Keypair()
+		assert.NoError(t, err)
+		assert.Equal(t, serverKeypair, kp)
+
+		published := set.Public().Key(k.Kid)
+		assert.Empty(t, published.D)
+		_, err = published.Keypair()
+		assert.Error(t, err)
+		p, err := published.PublicKey()
+		assert.NoError(t, err)
+		assert.Equal(t, pub, p)
+
+		pk, err := PublicKeyJWK(pub, "")
+		assert.NoError(t, err)
+		assert.Equal(t, published, pk)
+	}
+}
+
+func TestJWK_Thumbprint(t *testing.T) {
+	//RFC 7638 doesn't have an EC example, this is the key of RFC 7517 appendix A.1
+	k := &JWK{
+		Kty: "EC",
+		Crv: "P-256",
+		X:   "MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4",
+		Y:   "4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyM",
+		D:   "870MB6gfuTJ4HtUnUvYMyJpr5eUZNP4Bk43bVdj3eAE",
+	}
+	thumbprint, err := k.Thumbprint()
+	assert.NoError(t, err)
+	assert.Equal(t, "cn-I_WNMClehiVp51i_0VpOENW1upEerA8sEam5hn-s", thumbprint)
+
+	kp, err := k.Keypair()
+	assert.NoError(t, err)
+	s, err := NewServer(kp)
+	assert.NoError(t, err)
+	assert.IsType(t, &ecdsa.PublicKey{}, s.Public())
+}
+
+func TestJWK_Invalid(t *testing.T) {
+	k, err := KeypairJWK(mustKeypair(t), "key-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "key-1", k.Kid)
+
+	for _, mutate := range []func(k *JWK){
+		func(k *JWK) { k.Kty = "RSA" },
+		func(k *JWK) { k.Crv = "P-192" },
+		func(k *JWK) { k.Alg = "ES256" },
+		func(k *JWK) { k.Use = "sig" },
+		func(k *JWK) { k.X = k.Y },
+		func(k *JWK) { k.X = k.X[1:] },
+		func(k *JWK) { k.D = k.X },
+	} {
+		bad := *k
+		mutate(&bad)
+		_, err := bad.Keypair()
+		assert.Error(t, err)
+	}
+}
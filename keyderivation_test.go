@@ -0,0 +1,67 @@
+package phe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyDerivationOptions(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	pub := mustPublicKey(t, serverKeypair)
+	clientKey := GenerateClientKey()
+
+	c, err := NewClient(clientKey, pub)
+	assert.NoError(t, err)
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	rec, key, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	resp, err := VerifyPassword(serverKeypair, req)
+	assert.NoError(t, err)
+
+	keys := map[string][]byte{}
+	for name, opts := range map[string][]ClientOption{
+		"default":       nil,
+		"empty":         {WithKeySalt(nil), WithKeyInfo(nil)},
+		"salt":          {WithKeySalt([]byte("deployment-1"))},
+		"other salt":    {WithKeySalt([]byte("deployment-2"))},
+		"info":          {WithKeyInfo([]byte("app"))},
+		"salt and info": {WithKeySalt([]byte("deployment-1")), WithKeyInfo([]byte("app"))},
+	} {
+		c, err := NewClient(clientKey, pub, opts...)
+		assert.NoError(t, err)
+		keys[name], err = c.CheckResponseAndDecrypt(pwd, rec, resp)
+		assert.NoError(t, err)
+		assert.Len(t, keys[name], 32)
+	}
+
+	// defaults keep keys of existing records
+	assert.Equal(t, key, keys["default"])
+	assert.Equal(t, key, keys["empty"])
+
+	seen := map[string]bool{}
+	for name, k := range keys {
+		if name == "empty" {
+			continue
+		}
+		assert.False(t, seen[string(k)], name)
+		seen[string(k)] = true
+	}
+
+	// enrollment and verification agree on the key
+	c, err = NewClient(clientKey, pub, WithKeySalt([]byte("deployment-1")), WithKeyInfo([]byte("app")))
+	assert.NoError(t, err)
+	rec, key, err = c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+	req, err = c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	resp, err = VerifyPassword(serverKeypair, req)
+	assert.NoError(t, err)
+	decrypted, err := c.CheckResponseAndDecrypt(pwd, rec, resp)
+	assert.NoError(t, err)
+	assert.Equal(t, key, decrypted)
+}
@@ -0,0 +1,243 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"crypto/ecdh"
+	"crypto/sha512"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+)
+
+var (
+	memberKeyInfo = []byte("KeyGroupMember")
+	groupWrapInfo = []byte("KeyGroupWrap")
+)
+
+// KeyGroup shares a data key between several accounts, e.g. a team folder.
+// The data key is wrapped to every member's public key derived from the member's PHE account key,
+// so members can be added and the key rotated without knowing other members' account keys
+type KeyGroup struct {
+	ID      []byte                     `json:"id"`
+	Version uint32                     `json:"version"`
+	Members map[string]*KeyGroupMember `json:"members"`
+}
+
+// KeyGroupMember holds data key of the current version wrapped to a single member
+type KeyGroupMember struct {
+	PublicKey  []byte `json:"public_key"`
+	Ephemeral  []byte `json:"ephemeral"`
+	WrappedKey []byte `json:"wrapped_key"`
+}
+
+// MemberPublicKey derives the public key data keys are wrapped to from the account key returned by
+// EnrollAccount or CheckResponseAndDecrypt. It is to be published once so others can add the account to groups
+func MemberPublicKey(accountKey []byte) ([]byte, error) {
+	priv, err := memberPrivateKey(accountKey)
+	if err != nil {
+		return nil, err
+	}
+	return priv.PublicKey().Bytes(), nil
+}
+
+// NewKeyGroup creates a group with a fresh data key and no members
+func NewKeyGroup(id []byte) (group *KeyGroup, dataKey []byte, err error) {
+	if len(id) == 0 {
+		return nil, nil, errors.New("invalid group id")
+	}
+
+	dataKey = make([]byte, 32)
+	random.MustRead(dataKey)
+
+	return &KeyGroup{
+		ID:      append([]byte{}, id...),
+		Version: 1,
+		Members: make(map[string]*KeyGroupMember),
+	}, dataKey, nil
+}
+
+// AddMember wraps current data key to a member's public key returned by MemberPublicKey
+func (g *KeyGroup) AddMember(dataKey []byte, memberID string, memberPublicKey []byte) error {
+	if len(memberID) == 0 {
+		return errors.New("invalid member id")
+	}
+
+	pub, err := ecdh.P256().NewPublicKey(memberPublicKey)
+	if err != nil {
+		return errors.New("invalid member public key")
+	}
+
+	m, err := g.wrap(dataKey, memberID, pub)
+	if err != nil {
+		return err
+	}
+
+	if g.Members == nil {
+		g.Members = make(map[string]*KeyGroupMember)
+	}
+	g.Members[memberID] = m
+	return nil
+}
+
+// RemoveMember removes member's copy of the data key.
+// The member may still remember the key, so Rotate has to be called before encrypting anything new
+func (g *KeyGroup) RemoveMember(memberID string) {
+	delete(g.Members, memberID)
+}
+
+// Rotate generates a new data key, wraps it to all remaining members and increments group version.
+// Data encrypted with the previous key must be re-encrypted by the caller
+func (g *KeyGroup) Rotate() (dataKey []byte, err error) {
+	dataKey = make([]byte, 32)
+	random.MustRead(dataKey)
+
+	members := make(map[string]*KeyGroupMember, len(g.Members))
+	prevVersion := g.Version
+	g.Version++
+	for id, m := range g.Members {
+		pub, err := ecdh.P256().NewPublicKey(m.PublicKey)
+		if err != nil {
+			g.Version = prevVersion
+			return nil, errors.New("invalid member public key")
+		}
+
+		if members[id], err = g.wrap(dataKey, id, pub); err != nil {
+			g.Version = prevVersion
+			return nil, err
+		}
+	}
+
+	g.Members = members
+	return dataKey, nil
+}
+
+// Open unwraps the data key with member's account key
+func (g *KeyGroup) Open(memberID string, accountKey []byte) (dataKey []byte, err error) {
+	m, ok := g.Members[memberID]
+	if !ok {
+		return nil, errors.New("not a group member")
+	}
+
+	priv, err := memberPrivateKey(accountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	eph, err := ecdh.P256().NewPublicKey(m.Ephemeral)
+	if err != nil {
+		return nil, errors.New("invalid wrapped key")
+	}
+
+	secret, err := priv.ECDH(eph)
+	if err != nil {
+		return nil, errors.New("invalid wrapped key")
+	}
+
+	aead, err := newAEAD(secret, groupWrapInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	dataKey, err = aead.Open(nil, nonce, m.WrappedKey, g.additionalData(memberID, m.Ephemeral))
+	if err != nil {
+		return nil, errors.New("unwrapping failed")
+	}
+	return dataKey, nil
+}
+
+// wrap encrypts data key to the member's public key using a one-time ephemeral key
+func (g *KeyGroup) wrap(dataKey []byte, memberID string, pub *ecdh.PublicKey) (*KeyGroupMember, error) {
+	if len(dataKey) != 32 {
+		return nil, errors.New("invalid data key")
+	}
+
+	eph, err := ecdh.P256().GenerateKey(random)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := eph.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(secret, groupWrapInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	// every wrapping key is used exactly once, so zero nonce is fine
+	nonce := make([]byte, aead.NonceSize())
+	ephBytes := eph.PublicKey().Bytes()
+
+	return &KeyGroupMember{
+		PublicKey:  pub.Bytes(),
+		Ephemeral:  ephBytes,
+		WrappedKey: aead.Seal(nil, nonce, dataKey, g.additionalData(memberID, ephBytes)),
+	}, nil
+}
+
+// additionalData binds wrapped key to group ID, version and member ID
+func (g *KeyGroup) additionalData(memberID string, ephemeral []byte) []byte {
+	var buf [8]byte
+	ad := make([]byte, 0, 8+len(g.ID)+4+8+len(memberID)+len(ephemeral))
+	binary.BigEndian.PutUint64(buf[:], uint64(len(g.ID)))
+	ad = append(ad, buf[:]...)
+	ad = append(ad, g.ID...)
+	binary.BigEndian.PutUint32(buf[:4], g.Version)
+	ad = append(ad, buf[:4]...)
+	binary.BigEndian.PutUint64(buf[:], uint64(len(memberID)))
+	ad = append(ad, buf[:]...)
+	ad = append(ad, memberID...)
+	return append(ad, ephemeral...)
+}
+
+// memberPrivateKey deterministically derives member's wrapping key from the account key
+func memberPrivateKey(accountKey []byte) (*ecdh.PrivateKey, error) {
+	if len(accountKey) < 32 {
+		return nil, errors.New("invalid key")
+	}
+
+	z := p256.makeZ(hkdf.New(sha512.New512_256, accountKey, nil, memberKeyInfo))
+	if z.Sign() == 0 {
+		return nil, errors.New("invalid key")
+	}
+	return ecdh.P256().NewPrivateKey(p256.scalarBytes(z))
+}
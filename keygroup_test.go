@@ -0,0 +1,48 @@
+package phe
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyGroup(t *testing.T) {
+	alice, bob := make([]byte, 32), make([]byte, 32)
+	rand.Read(alice)
+	rand.Read(bob)
+
+	alicePub, err := MemberPublicKey(alice)
+	assert.NoError(t, err)
+	bobPub, err := MemberPublicKey(bob)
+	assert.NoError(t, err)
+
+	g, dataKey, err := NewKeyGroup([]byte("team"))
+	assert.NoError(t, err)
+	assert.NoError(t, g.AddMember(dataKey, "alice", alicePub))
+	assert.NoError(t, g.AddMember(dataKey, "bob", bobPub))
+
+	key, err := g.Open("alice", alice)
+	assert.NoError(t, err)
+	assert.Equal(t, dataKey, key)
+	key, err = g.Open("bob", bob)
+	assert.NoError(t, err)
+	assert.Equal(t, dataKey, key)
+
+	//bob can't open alice's copy
+	_, err = g.Open("alice", bob)
+	assert.Error(t, err)
+
+	g.RemoveMember("bob")
+	newKey, err := g.Rotate()
+	assert.NoError(t, err)
+	assert.NotEqual(t, dataKey, newKey)
+	assert.Equal(t, uint32(2), g.Version)
+
+	key, err = g.Open("alice", alice)
+	assert.NoError(t, err)
+	assert.Equal(t, newKey, key)
+
+	_, err = g.Open("bob", bob)
+	assert.Error(t, err)
+}
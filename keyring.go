@@ -0,0 +1,327 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Keyring holds the current server keypair together with earlier ones whose records haven't all been updated yet.
+// Verification requests are routed to the keypair of their key version so that records keep working while
+// a migration is under way, clients route records the same way with ClientKeyring. Enrollments always use the current keypair
+type Keyring struct {
+	mu      sync.RWMutex
+	current uint32
+	servers map[uint32]*Server
+	opts    []ServerOption
+}
+
+// NewKeyring creates a keyring whose current keypair has the given non-zero version.
+// Options apply to servers of every version
+func NewKeyring(version uint32, serverKeypair []byte, opts ...ServerOption) (*Keyring, error) {
+	k := &Keyring{
+		current: version,
+		servers: make(map[uint32]*Server),
+		opts:    opts,
+	}
+	if err := k.add(version, serverKeypair); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Add adds an earlier keypair, e.g. when the keyring is restored after a restart during a migration
+func (k *Keyring) Add(version uint32, serverKeypair []byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.servers[version]; ok {
+		return errors.Errorf("key version %d is already in the keyring", version)
+	}
+	return k.add(version, serverKeypair)
+}
+
+func (k *Keyring) add(version uint32, serverKeypair []byte) error {
+	if version == 0 {
+		return errors.New("invalid key version")
+	}
+
+	opts := append(k.opts[:len(k.opts):len(k.opts)], WithKeyVersion(version))
+	s, err := NewServer(serverKeypair, opts...)
+	if err != nil {
+		return err
+	}
+	k.servers[version] = s
+	return nil
+}
+
+// Rotate makes a rotated keypair of the next version current. The previous keypair stays in the keyring
+// until it's retired. The new keypair is returned so it can be persisted
+func (k *Keyring) Rotate() (token *UpdateToken, newServerKeypair []byte, err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.servers[k.current+1]; ok || k.current+1 == 0 {
+		return nil, nil, errors.Errorf("key version %d is already in the keyring", k.current+1)
+	}
+
+	cur := k.servers[k.current]
+	kp, err := marshalKeypair(cur.kp.PublicKey, cur.kp.PrivateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	token, newServerKeypair, err = Rotate(kp)
+	if err != nil {
+		return nil, nil, err
+	}
+	token.FromVersion, token.ToVersion = k.current, k.current+1
+
+	if err = k.add(k.current+1, newServerKeypair); err != nil {
+		return nil, nil, err
+	}
+	k.current++
+	return token, newServerKeypair, nil
+}
+
+// Retire removes a keypair once every record has been updated past its version. The current keypair can't be retired
+func (k *Keyring) Retire(version uint32) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if version == k.current {
+		return errors.New("current key version can't be retired")
+	}
+	if _, ok := k.servers[version]; !ok {
+		return errors.Errorf("key version %d is not in the keyring", version)
+	}
+	delete(k.servers, version)
+	return nil
+}
+
+// Current returns the server of the current keypair
+func (k *Keyring) Current() *Server {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.servers[k.current]
+}
+
+// Server returns the server of a keypair version, 0 stands for the current one.
+// It returns *KeyVersionError if the version isn't in the keyring
+func (k *Keyring) Server(version uint32) (*Server, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if version == 0 {
+		version = k.current
+	}
+	s, ok := k.servers[version]
+	if !ok {
+		return nil, &KeyVersionError{Expected: k.current, Got: version}
+	}
+	return s, nil
+}
+
+// Versions returns versions of the keypairs in the keyring in ascending order
+func (k *Keyring) Versions() []uint32 {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	versions := make([]uint32, 0, len(k.servers))
+	for v := range k.servers {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions
+}
+
+// GetEnrollment enrolls with the current keypair
+func (k *Keyring) GetEnrollment() (*EnrollmentResponse, error) {
+	return k.Current().GetEnrollment()
+}
+
+// VerifyPassword verifies with the keypair of the request's key version.
+// Unversioned requests are verified with the current keypair
+func (k *Keyring) VerifyPassword(req *VerifyPasswordRequest) (*VerifyPasswordResponse, error) {
+	if req == nil {
+		return nil, errors.New("Invalid password verify request")
+	}
+
+	s, err := k.Server(req.KeyVersion)
+	if err != nil {
+		return nil, err
+	}
+	return s.VerifyPassword(req)
+}
+
+// ClientKeyring is the client side counterpart of Keyring. It holds the current client together with clients
+// of earlier key versions, so that records which haven't been updated yet keep working while a migration is under way:
+// requests for a record are made and checked by the client of the record's key version. Enrollments use the current client
+type ClientKeyring struct {
+	mu      sync.RWMutex
+	current uint32
+	clients map[uint32]*Client
+}
+
+// NewClientKeyring creates a keyring whose current client is c, which must have a non-zero key version
+func NewClientKeyring(c *Client) (*ClientKeyring, error) {
+	if c == nil || c.keyVersion == 0 {
+		return nil, errors.New("invalid key version")
+	}
+	return &ClientKeyring{
+		current: c.keyVersion,
+		clients: map[uint32]*Client{c.keyVersion: c},
+	}, nil
+}
+
+// Add adds a client of an earlier key version, e.g. when the keyring is restored after a restart during a migration
+func (k *ClientKeyring) Add(c *Client) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if c == nil || c.keyVersion == 0 {
+		return errors.New("invalid key version")
+	}
+	if _, ok := k.clients[c.keyVersion]; ok {
+		return errors.Errorf("key version %d is already in the keyring", c.keyVersion)
+	}
+	k.clients[c.keyVersion] = c
+	return nil
+}
+
+// Rotate makes the current client rotated with the token current, see Client.RotateNew.
+// The previous client stays in the keyring until it's retired
+func (k *ClientKeyring) Rotate(token *UpdateToken, newServerPublicKey []byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	c, err := k.clients[k.current].RotateNew(token, newServerPublicKey)
+	if err != nil {
+		return err
+	}
+	if _, ok := k.clients[c.keyVersion]; ok {
+		return errors.Errorf("key version %d is already in the keyring", c.keyVersion)
+	}
+	k.clients[c.keyVersion] = c
+	k.current = c.keyVersion
+	return nil
+}
+
+// Retire removes a client once every record has been updated past its version. The current client can't be retired
+func (k *ClientKeyring) Retire(version uint32) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if version == k.current {
+		return errors.New("current key version can't be retired")
+	}
+	if _, ok := k.clients[version]; !ok {
+		return errors.Errorf("key version %d is not in the keyring", version)
+	}
+	delete(k.clients, version)
+	return nil
+}
+
+// Current returns the client of the current key version
+func (k *ClientKeyring) Current() *Client {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.clients[k.current]
+}
+
+// Client returns the client of a key version, 0 stands for the current one.
+// It returns *KeyVersionError if the version isn't in the keyring
+func (k *ClientKeyring) Client(version uint32) (*Client, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if version == 0 {
+		version = k.current
+	}
+	c, ok := k.clients[version]
+	if !ok {
+		return nil, &KeyVersionError{Expected: k.current, Got: version}
+	}
+	return c, nil
+}
+
+// Versions returns key versions of the clients in the keyring in ascending order
+func (k *ClientKeyring) Versions() []uint32 {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	versions := make([]uint32, 0, len(k.clients))
+	for v := range k.clients {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions
+}
+
+// EnrollAccount enrolls with the current client
+func (k *ClientKeyring) EnrollAccount(password []byte, resp *EnrollmentResponse) (rec *EnrollmentRecord, key []byte, err error) {
+	return k.Current().EnrollAccount(password, resp)
+}
+
+// CreateVerifyPasswordRequest makes the request with the client of the record's key version.
+// Unversioned records are handled by the current client
+func (k *ClientKeyring) CreateVerifyPasswordRequest(password []byte, rec *EnrollmentRecord) (*VerifyPasswordRequest, error) {
+	c, err := k.forRecord(rec)
+	if err != nil {
+		return nil, err
+	}
+	return c.CreateVerifyPasswordRequest(password, rec)
+}
+
+// CheckResponseAndDecrypt checks the response with the client of the record's key version
+func (k *ClientKeyring) CheckResponseAndDecrypt(password []byte, rec *EnrollmentRecord, resp *VerifyPasswordResponse) ([]byte, error) {
+	c, err := k.forRecord(rec)
+	if err != nil {
+		return nil, err
+	}
+	return c.CheckResponseAndDecrypt(password, rec, resp)
+}
+
+func (k *ClientKeyring) forRecord(rec *EnrollmentRecord) (*Client, error) {
+	if rec == nil {
+		return nil, errors.New("invalid record")
+	}
+	return k.Client(rec.KeyVersion)
+}
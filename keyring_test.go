@@ -0,0 +1,133 @@
+package phe
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyring(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	k, err := NewKeyring(1, serverKeypair)
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), k.Current().PublicKey(), WithClientKeyVersion(1))
+	assert.NoError(t, err)
+
+	enrollment, err := k.GetEnrollment()
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+	oldReq, err := c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+
+	token, newKeypair, err := k.Rotate()
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{1, 2}, k.Versions())
+	assert.Equal(t, uint32(2), k.Current().KeyVersion())
+	assert.Equal(t, mustPublicKey(t, newKeypair), k.Current().PublicKey())
+
+	//records which haven't been updated yet are still verified with the old keypair
+	resp, err := k.VerifyPassword(oldReq)
+	assert.NoError(t, err)
+	assert.True(t, resp.Res)
+
+	newClient, err := NewClient(c.clientPrivateKeyBytes, c.serverPublicKeyBytes, WithClientKeyVersion(1))
+	assert.NoError(t, err)
+	assert.NoError(t, newClient.RotateChecked(token, k.Current().PublicKey()))
+	updated, err := UpdateRecord(rec, token)
+	assert.NoError(t, err)
+	req, err := newClient.CreateVerifyPasswordRequest(pwd, updated)
+	assert.NoError(t, err)
+	resp, err = k.VerifyPassword(req)
+	assert.NoError(t, err)
+	assert.True(t, resp.Res)
+	_, err = newClient.CheckResponseAndDecrypt(pwd, updated, resp)
+	assert.NoError(t, err)
+
+	assert.Error(t, k.Retire(2))
+	assert.NoError(t, k.Retire(1))
+	assert.Error(t, k.Retire(1))
+	_, err = k.VerifyPassword(oldReq)
+	assert.True(t, errors.Is(err, ErrKeyVersionMismatch))
+
+	//restored after a restart
+	assert.NoError(t, k.Add(1, serverKeypair))
+	assert.Error(t, k.Add(1, serverKeypair))
+	resp, err = k.VerifyPassword(oldReq)
+	assert.NoError(t, err)
+	assert.True(t, resp.Res)
+
+	_, err = NewKeyring(0, serverKeypair)
+	assert.Error(t, err)
+}
+
+func TestClientKeyring(t *testing.T) {
+	k, err := NewKeyring(1, mustKeypair(t))
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), k.Current().PublicKey(), WithClientKeyVersion(1))
+	assert.NoError(t, err)
+	ck, err := NewClientKeyring(c)
+	assert.NoError(t, err)
+
+	login := func(rec *EnrollmentRecord) ([]byte, error) {
+		req, err := ck.CreateVerifyPasswordRequest(pwd, rec)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := k.VerifyPassword(req)
+		if err != nil {
+			return nil, err
+		}
+		return ck.CheckResponseAndDecrypt(pwd, rec, resp)
+	}
+
+	enrollment, err := k.GetEnrollment()
+	assert.NoError(t, err)
+	rec, key, err := ck.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	token, _, err := k.Rotate()
+	assert.NoError(t, err)
+	assert.NoError(t, ck.Rotate(token, k.Current().PublicKey()))
+	assert.Error(t, ck.Rotate(token, k.Current().PublicKey()))
+	assert.Equal(t, []uint32{1, 2}, ck.Versions())
+	assert.Equal(t, uint32(2), ck.Current().KeyVersion())
+	assert.Equal(t, uint32(1), c.KeyVersion())
+
+	//records which haven't been updated yet keep working next to new ones
+	keyDec, err := login(rec)
+	assert.NoError(t, err)
+	assert.Equal(t, key, keyDec)
+
+	enrollment, err = k.GetEnrollment()
+	assert.NoError(t, err)
+	newRec, newKey, err := ck.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), newRec.KeyVersion)
+	keyDec, err = login(newRec)
+	assert.NoError(t, err)
+	assert.Equal(t, newKey, keyDec)
+
+	updated, err := UpdateRecord(rec, token)
+	assert.NoError(t, err)
+	keyDec, err = login(updated)
+	assert.NoError(t, err)
+	assert.Equal(t, key, keyDec)
+
+	assert.Error(t, ck.Retire(2))
+	assert.NoError(t, ck.Retire(1))
+	_, err = login(rec)
+	assert.True(t, errors.Is(err, ErrKeyVersionMismatch))
+
+	//restored after a restart
+	assert.NoError(t, ck.Add(c))
+	assert.Error(t, ck.Add(c))
+	_, err = login(rec)
+	assert.NoError(t, err)
+
+	unversioned, err := NewClient(GenerateClientKey(), k.Current().PublicKey())
+	assert.NoError(t, err)
+	_, err = NewClientKeyring(unversioned)
+	assert.Error(t, err)
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+)
+
+// An encrypted keypair is a server keypair sealed with AES-256-GCM under a key derived from a passphrase by Argon2id.
+// The salt is unique per encryption so the nonce is fixed. Layout:
+// version | time | memory | threads | salt | sealed keypair
+const (
+	sealedKeypairVersion   byte = 1
+	sealedKeypairSaltLen        = 16
+	sealedKeypairHeaderLen      = 1 + 4 + 4 + 1 + sealedKeypairSaltLen
+
+	// Argon2id parameters: 3 passes over 64 MiB with 4 threads
+	sealedKeypairTime    = 3
+	sealedKeypairMemory  = 64 * 1024
+	sealedKeypairThreads = 4
+)
+
+var sealedKeypairInfo = []byte("SealedKeypair")
+
+// ErrWrongPassphrase is returned by DecryptKeypair if the passphrase is wrong or the data has been modified
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted keypair")
+
+// EncryptKeypair encrypts a serialized server keypair with a passphrase so it can be written to disk or config stores
+func EncryptKeypair(serverKeypair, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("invalid passphrase")
+	}
+	if _, err := unmarshalKeypair(serverKeypair); err != nil {
+		return nil, err
+	}
+
+	sealed := make([]byte, sealedKeypairHeaderLen, sealedKeypairHeaderLen+len(serverKeypair)+16)
+	sealed[0] = sealedKeypairVersion
+	binary.BigEndian.PutUint32(sealed[1:5], sealedKeypairTime)
+	binary.BigEndian.PutUint32(sealed[5:9], sealedKeypairMemory)
+	sealed[9] = sealedKeypairThreads
+	random.MustRead(sealed[10:sealedKeypairHeaderLen])
+
+	aead, err := sealedKeypairAEAD(sealed, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	return aead.Seal(sealed, nonce, serverKeypair, sealed), nil
+}
+
+// DecryptKeypair decrypts a keypair encrypted by EncryptKeypair
+func DecryptKeypair(sealed, passphrase []byte) ([]byte, error) {
+	if len(sealed) <= sealedKeypairHeaderLen || sealed[0] != sealedKeypairVersion {
+		return nil, errors.New("invalid encrypted keypair")
+	}
+
+	aead, err := sealedKeypairAEAD(sealed, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	serverKeypair, err := aead.Open(nil, nonce, sealed[sealedKeypairHeaderLen:], sealed[:sealedKeypairHeaderLen])
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	if _, err = unmarshalKeypair(serverKeypair); err != nil {
+		return nil, err
+	}
+	return serverKeypair, nil
+}
+
+// sealedKeypairAEAD derives the key from the passphrase with parameters and salt from the header.
+// Parameters have the same bounds as fallback verifiers so a crafted blob can't ask for unbounded work
+func sealedKeypairAEAD(header, passphrase []byte) (cipher.AEAD, error) {
+	t, m, threads := binary.BigEndian.Uint32(header[1:5]), binary.BigEndian.Uint32(header[5:9]), header[9]
+	if t == 0 || t > fallbackMaxTime || m > fallbackMaxMemory || threads == 0 {
+		return nil, errors.New("invalid encrypted keypair")
+	}
+
+	return newAEAD(argon2.IDKey(passphrase, header[10:sealedKeypairHeaderLen], t, m, threads, 32), sealedKeypairInfo)
+}
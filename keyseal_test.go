@@ -0,0 +1,48 @@
+package phe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptKeypair(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	passphrase := []byte("correct horse battery staple")
+
+	sealed, err := EncryptKeypair(serverKeypair, passphrase)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(sealed), string(serverKeypair))
+
+	kp, err := DecryptKeypair(sealed, passphrase)
+	assert.NoError(t, err)
+	assert.Equal(t, serverKeypair, kp)
+
+	//salt is random
+	sealed2, err := EncryptKeypair(serverKeypair, passphrase)
+	assert.NoError(t, err)
+	assert.NotEqual(t, sealed, sealed2)
+
+	_, err = DecryptKeypair(sealed, []byte("wrong passphrase"))
+	assert.Equal(t, ErrWrongPassphrase, err)
+
+	//parameters are authenticated
+	tampered := append([]byte{}, sealed...)
+	tampered[4]++
+	_, err = DecryptKeypair(tampered, passphrase)
+	assert.Equal(t, ErrWrongPassphrase, err)
+
+	//and bounded
+	tampered = append([]byte{}, sealed...)
+	tampered[1] = 0xFF
+	_, err = DecryptKeypair(tampered, passphrase)
+	assert.Error(t, err)
+
+	_, err = DecryptKeypair(sealed[:sealedKeypairHeaderLen], passphrase)
+	assert.Error(t, err)
+
+	_, err = EncryptKeypair(serverKeypair, nil)
+	assert.Error(t, err)
+	_, err = EncryptKeypair([]byte("not a keypair"), passphrase)
+	assert.Error(t, err)
+}
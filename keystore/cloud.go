@@ -0,0 +1,117 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package keystore
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// The adapters below take minimal client interfaces instead of the cloud SDKs so this module doesn't depend on them.
+// Each interface is a few lines of glue around the SDK's own client
+
+// AWSClient is the part of the AWS KMS API the AWS adapter uses, e.g. Encrypt and Decrypt of aws-sdk-go-v2 kms.Client
+// called with KeyId, Plaintext or CiphertextBlob and EncryptionContext
+type AWSClient interface {
+	Encrypt(ctx context.Context, keyID string, plaintext []byte, encryptionContext map[string]string) ([]byte, error)
+	Decrypt(ctx context.Context, keyID string, ciphertext []byte, encryptionContext map[string]string) ([]byte, error)
+}
+
+// AWSKMS wraps data keys with an AWS KMS symmetric key
+type AWSKMS struct {
+	client AWSClient
+	keyID  string
+	encCtx map[string]string
+}
+
+// NewAWSKMS creates an adapter for the key with the given ID or ARN. The encryption context is bound to every
+// wrapped data key and has to be the same when the keypair is loaded, it also shows up in CloudTrail
+func NewAWSKMS(client AWSClient, keyID string, encryptionContext map[string]string) (*AWSKMS, error) {
+	if client == nil || keyID == "" {
+		return nil, errors.New("aws kms client and key id are required")
+	}
+
+	encCtx := make(map[string]string, len(encryptionContext))
+	for k, v := range encryptionContext {
+		encCtx[k] = v
+	}
+	return &AWSKMS{client: client, keyID: keyID, encCtx: encCtx}, nil
+}
+
+// WrapKey encrypts the data key with the KMS key
+func (a *AWSKMS) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	return a.client.Encrypt(ctx, a.keyID, dataKey, a.encCtx)
+}
+
+// UnwrapKey decrypts the data key with the KMS key
+func (a *AWSKMS) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	return a.client.Decrypt(ctx, a.keyID, wrapped, a.encCtx)
+}
+
+// GCPClient is the part of the Cloud KMS API the GCP adapter uses, e.g. Encrypt and Decrypt of
+// cloud.google.com/go/kms KeyManagementClient called with Name, Plaintext or Ciphertext and AdditionalAuthenticatedData
+type GCPClient interface {
+	Encrypt(ctx context.Context, name string, plaintext, aad []byte) ([]byte, error)
+	Decrypt(ctx context.Context, name string, ciphertext, aad []byte) ([]byte, error)
+}
+
+// GCPKMS wraps data keys with a Cloud KMS symmetric key
+type GCPKMS struct {
+	client GCPClient
+	name   string
+	aad    []byte
+}
+
+// NewGCPKMS creates an adapter for the key with the given resource name,
+// projects/*/locations/*/keyRings/*/cryptoKeys/*. aad is bound to every wrapped data key
+func NewGCPKMS(client GCPClient, name string, aad []byte) (*GCPKMS, error) {
+	if client == nil || name == "" {
+		return nil, errors.New("gcp kms client and key name are required")
+	}
+	return &GCPKMS{client: client, name: name, aad: append([]byte{}, aad...)}, nil
+}
+
+// WrapKey encrypts the data key with the KMS key
+func (g *GCPKMS) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	return g.client.Encrypt(ctx, g.name, dataKey, g.aad)
+}
+
+// UnwrapKey decrypts the data key with the KMS key
+func (g *GCPKMS) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	return g.client.Decrypt(ctx, g.name, wrapped, g.aad)
+}
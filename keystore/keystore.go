@@ -0,0 +1,238 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+// Package keystore keeps the server keypair wrapped by a key management service so it's only
+// unwrapped in memory. The keypair is envelope encrypted: a fresh data key seals it with AES-256-GCM
+// and the KMS wraps the data key, so the KMS never sees the keypair and a single call loads it
+package keystore
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/pkg/errors"
+)
+
+// Envelope layout: version | wrapped data key length | wrapped data key | nonce | sealed keypair
+const (
+	envelopeVersion byte = 1
+	dataKeyLen           = 32
+)
+
+// ErrNotFound is returned by Load if no keypair has been stored yet
+var ErrNotFound = errors.New("keypair not found")
+
+// KeyStorage loads and stores a server keypair wrapped by a KMS
+type KeyStorage interface {
+	// Load reads the stored keypair and unwraps it
+	Load(ctx context.Context) (serverKeypair []byte, err error)
+	// Store wraps the keypair and writes it. It can be passed to serverkey holders as their persist function
+	Store(ctx context.Context, serverKeypair []byte) error
+	// Wrap encrypts a serialized keypair into an envelope
+	Wrap(ctx context.Context, serverKeypair []byte) ([]byte, error)
+	// Unwrap decrypts an envelope made by Wrap
+	Unwrap(ctx context.Context, envelope []byte) ([]byte, error)
+}
+
+// KMS wraps and unwraps data keys with a key that never leaves the service
+type KMS interface {
+	WrapKey(ctx context.Context, dataKey []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// Blob is where the wrapped keypair is kept
+type Blob interface {
+	// Read returns ErrNotFound if nothing has been written
+	Read(ctx context.Context) ([]byte, error)
+	Write(ctx context.Context, data []byte) error
+}
+
+// Envelope is a KeyStorage that wraps keypairs with a KMS and keeps them in a Blob
+type Envelope struct {
+	kms  KMS
+	blob Blob
+}
+
+// New creates a key storage
+func New(kms KMS, blob Blob) (*Envelope, error) {
+	if kms == nil || blob == nil {
+		return nil, errors.New("kms and blob are required")
+	}
+	return &Envelope{kms: kms, blob: blob}, nil
+}
+
+// Load reads the stored keypair and unwraps it
+func (e *Envelope) Load(ctx context.Context) ([]byte, error) {
+	envelope, err := e.blob.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return e.Unwrap(ctx, envelope)
+}
+
+// Store wraps the keypair and writes it
+func (e *Envelope) Store(ctx context.Context, serverKeypair []byte) error {
+	envelope, err := e.Wrap(ctx, serverKeypair)
+	if err != nil {
+		return err
+	}
+	return e.blob.Write(ctx, envelope)
+}
+
+// Wrap seals the keypair with a fresh data key and wraps the data key with the KMS
+func (e *Envelope) Wrap(ctx context.Context, serverKeypair []byte) ([]byte, error) {
+	if _, err := phe.NewServer(serverKeypair); err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, dataKeyLen)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	defer wipe(dataKey)
+
+	wrapped, err := e.kms.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not wrap data key")
+	}
+	if len(wrapped) == 0 || len(wrapped) > 0xFFFF {
+		return nil, errors.New("invalid wrapped data key")
+	}
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	headerLen := 1 + 2 + len(wrapped)
+	envelope := make([]byte, headerLen+aead.NonceSize(), headerLen+aead.NonceSize()+len(serverKeypair)+aead.Overhead())
+	envelope[0] = envelopeVersion
+	binary.BigEndian.PutUint16(envelope[1:3], uint16(len(wrapped)))
+	copy(envelope[3:], wrapped)
+	nonce := envelope[headerLen:]
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(envelope, nonce, serverKeypair, envelope[:headerLen]), nil
+}
+
+// Unwrap unwraps the data key with the KMS and opens the keypair
+func (e *Envelope) Unwrap(ctx context.Context, envelope []byte) ([]byte, error) {
+	if len(envelope) < 3 || envelope[0] != envelopeVersion {
+		return nil, errors.New("invalid keypair envelope")
+	}
+	headerLen := 3 + int(binary.BigEndian.Uint16(envelope[1:3]))
+	if len(envelope) < headerLen {
+		return nil, errors.New("invalid keypair envelope")
+	}
+
+	dataKey, err := e.kms.UnwrapKey(ctx, envelope[3:headerLen])
+	if err != nil {
+		return nil, errors.Wrap(err, "could not unwrap data key")
+	}
+	defer wipe(dataKey)
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(envelope) < headerLen+aead.NonceSize()+aead.Overhead() {
+		return nil, errors.New("invalid keypair envelope")
+	}
+
+	nonce := envelope[headerLen : headerLen+aead.NonceSize()]
+	serverKeypair, err := aead.Open(nil, nonce, envelope[headerLen+aead.NonceSize():], envelope[:headerLen])
+	if err != nil {
+		return nil, errors.New("keypair envelope is corrupted")
+	}
+	return serverKeypair, nil
+}
+
+// File is a Blob kept in a local file, writes replace it atomically
+type File string
+
+// Read reads the file
+func (f File) Read(context.Context) ([]byte, error) {
+	data, err := os.ReadFile(string(f))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// Write replaces the file with data through a temporary file in the same directory
+func (f File) Write(_ context.Context, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(string(f)), filepath.Base(string(f))+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), string(f))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != dataKeyLen {
+		return nil, errors.New("invalid data key")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,125 @@
+package keystore
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeKMS seals data keys with a local key the way a KMS would, checking the key ID and context
+type fakeKMS struct {
+	calls int
+}
+
+func (f *fakeKMS) seal(name string, plaintext []byte, aad string) ([]byte, error) {
+	f.calls++
+	aead, err := newGCM(bytes.Repeat([]byte{byte(len(name))}, dataKeyLen))
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, _ = rand.Read(nonce)
+	return aead.Seal(nonce, nonce, plaintext, []byte(name+aad)), nil
+}
+
+func (f *fakeKMS) open(name string, ciphertext []byte, aad string) ([]byte, error) {
+	f.calls++
+	aead, err := newGCM(bytes.Repeat([]byte{byte(len(name))}, dataKeyLen))
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, fmt.Errorf("invalid ciphertext")
+	}
+	return aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], []byte(name+aad))
+}
+
+func (f *fakeKMS) Encrypt(_ context.Context, keyID string, plaintext []byte, encCtx map[string]string) ([]byte, error) {
+	return f.seal(keyID, plaintext, fmt.Sprint(encCtx))
+}
+
+func (f *fakeKMS) Decrypt(_ context.Context, keyID string, ciphertext []byte, encCtx map[string]string) ([]byte, error) {
+	return f.open(keyID, ciphertext, fmt.Sprint(encCtx))
+}
+
+type fakeGCP struct{ fakeKMS }
+
+func (f *fakeGCP) Encrypt(_ context.Context, name string, plaintext, aad []byte) ([]byte, error) {
+	return f.seal(name, plaintext, string(aad))
+}
+
+func (f *fakeGCP) Decrypt(_ context.Context, name string, ciphertext, aad []byte) ([]byte, error) {
+	return f.open(name, ciphertext, string(aad))
+}
+
+func TestEnvelope_AWS(t *testing.T) {
+	ctx := context.Background()
+	client := &fakeKMS{}
+	kms, err := NewAWSKMS(client, "alias/phe", map[string]string{"service": "phe"})
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "keypair")
+	storage, err := New(kms, File(path))
+	assert.NoError(t, err)
+
+	_, err = storage.Load(ctx)
+	assert.Equal(t, ErrNotFound, err)
+
+	serverKeypair, err := phe.GenerateServerKeypair()
+	assert.NoError(t, err)
+	assert.NoError(t, storage.Store(ctx, serverKeypair))
+
+	kp, err := storage.Load(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, serverKeypair, kp)
+	assert.Equal(t, 2, client.calls)
+
+	data, err := File(path).Read(ctx)
+	assert.NoError(t, err)
+	assert.False(t, bytes.Contains(data, serverKeypair))
+
+	//another encryption context can't unwrap
+	other, err := NewAWSKMS(client, "alias/phe", map[string]string{"service": "other"})
+	assert.NoError(t, err)
+	otherStorage, err := New(other, File(path))
+	assert.NoError(t, err)
+	_, err = otherStorage.Load(ctx)
+	assert.Error(t, err)
+
+	//envelope header is authenticated
+	data[len(data)-1] ^= 1
+	_, err = storage.Unwrap(ctx, data)
+	assert.Error(t, err)
+
+	_, err = storage.Wrap(ctx, []byte("not a keypair"))
+	assert.Error(t, err)
+}
+
+func TestEnvelope_GCP(t *testing.T) {
+	ctx := context.Background()
+	kms, err := NewGCPKMS(&fakeGCP{}, "projects/p/locations/global/keyRings/r/cryptoKeys/phe", []byte("phe"))
+	assert.NoError(t, err)
+
+	storage, err := New(kms, File(filepath.Join(t.TempDir(), "keypair")))
+	assert.NoError(t, err)
+
+	serverKeypair, err := phe.GenerateServerKeypair()
+	assert.NoError(t, err)
+
+	envelope, err := storage.Wrap(ctx, serverKeypair)
+	assert.NoError(t, err)
+	kp, err := storage.Unwrap(ctx, envelope)
+	assert.NoError(t, err)
+	assert.Equal(t, serverKeypair, kp)
+
+	for _, bad := range [][]byte{nil, {envelopeVersion}, envelope[:10], append([]byte{2}, envelope[1:]...)} {
+		_, err = storage.Unwrap(ctx, bad)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+// Key versions number server keypairs and the client keys which go with them. A versioned server stamps
+// enrollment responses with its version, records carry it and verification requests repeat it, so a record
+// which wasn't updated after a rotation is refused with *KeyVersionError instead of failing to verify.
+// Every rotation moves to the next version: Client.Rotate and UpdateRecord increment non-zero versions.
+// Version 0 means unversioned and is never checked
+
+// WithKeyVersion sets the version of the server keypair
+func WithKeyVersion(v uint32) ServerOption {
+	return func(s *Server) {
+		s.keyVersion = v
+	}
+}
+
+// KeyVersion returns the version of the server keypair, 0 if it isn't versioned
+func (s *Server) KeyVersion() uint32 {
+	return s.keyVersion
+}
+
+// WithClientKeyVersion sets the version of client's keys. A versioned client refuses records and enrollment
+// responses of other versions
+func WithClientKeyVersion(v uint32) ClientOption {
+	return func(c *Client) {
+		c.keyVersion = v
+	}
+}
+
+// KeyVersion returns the version of client's keys, 0 if they aren't versioned
+func (c *Client) KeyVersion() uint32 {
+	return c.keyVersion
+}
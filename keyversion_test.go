@@ -0,0 +1,95 @@
+package phe
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyVersion(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	s, err := NewServer(serverKeypair, WithKeyVersion(3))
+	assert.NoError(t, err)
+	clientKey := GenerateClientKey()
+	c, err := NewClient(clientKey, s.PublicKey(), WithClientKeyVersion(3))
+	assert.NoError(t, err)
+
+	enrollment, err := s.GetEnrollment()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(3), enrollment.KeyVersion)
+	rec, _, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(3), rec.KeyVersion)
+
+	req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(3), req.KeyVersion)
+	resp, err := s.VerifyPassword(req)
+	assert.NoError(t, err)
+	assert.True(t, resp.Res)
+
+	//the record survives every encoding
+	blob, err := MarshalCompactRecord(rec)
+	assert.NoError(t, err)
+	decoded, err := UnmarshalCompactRecord(blob)
+	assert.NoError(t, err)
+	assert.Equal(t, rec, decoded)
+	encodings, err := CompactRecordEncodings(rec)
+	assert.NoError(t, err)
+	assert.Len(t, encodings, 1)
+
+	blob, err = rec.MarshalBinary()
+	assert.NoError(t, err)
+	decoded = &EnrollmentRecord{}
+	assert.NoError(t, decoded.UnmarshalBinary(blob))
+	assert.Equal(t, rec, decoded)
+
+	blob, err = json.Marshal(rec)
+	assert.NoError(t, err)
+	decoded = &EnrollmentRecord{}
+	assert.NoError(t, json.Unmarshal(blob, decoded))
+	assert.Equal(t, rec, decoded)
+
+	blob, err = MarshalRecordDER(rec)
+	assert.NoError(t, err)
+	decoded, err = UnmarshalRecordDER(blob)
+	assert.NoError(t, err)
+	assert.Equal(t, rec, decoded)
+
+	//after rotation records which weren't updated are refused by the server and the client
+	token, newKeypair, err := Rotate(serverKeypair)
+	assert.NoError(t, err)
+	newServer, err := NewServer(newKeypair, WithKeyVersion(4))
+	assert.NoError(t, err)
+
+	_, err = newServer.VerifyPassword(req)
+	var versionErr *KeyVersionError
+	assert.True(t, errors.As(err, &versionErr))
+	assert.Equal(t, KeyVersionError{Expected: 4, Got: 3}, *versionErr)
+
+	assert.NoError(t, c.RotateChecked(token, newServer.PublicKey()))
+	assert.Equal(t, uint32(4), c.KeyVersion())
+	_, err = c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.True(t, errors.Is(err, ErrKeyVersionMismatch))
+
+	updated, err := UpdateRecord(rec, token)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(4), updated.KeyVersion)
+	req, err = c.CreateVerifyPasswordRequest(pwd, updated)
+	assert.NoError(t, err)
+	resp, err = newServer.VerifyPassword(req)
+	assert.NoError(t, err)
+	assert.True(t, resp.Res)
+
+	//enrollments of another version are refused
+	_, _, err = c.EnrollAccount(pwd, enrollment)
+	assert.True(t, errors.Is(err, ErrKeyVersionMismatch))
+
+	//unversioned parties don't check
+	plain, err := NewClient(clientKey, s.PublicKey())
+	assert.NoError(t, err)
+	_, err = plain.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+}
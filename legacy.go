@@ -0,0 +1,124 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/pkg/errors"
+)
+
+// LegacyLogin moves accounts from classic password hashes such as bcrypt, scrypt or argon2 to PHE as users log in.
+// Users with a record are verified with PHE, the others with their legacy hash. The first successful legacy login
+// enrolls the user, stores the record and flags the legacy hash for deletion, so nobody has to reset the password.
+// Records are created with RecordStore.Create, so of concurrent first logins of one user only one enrolls
+// and the others are verified against its record and get the same key
+type LegacyLogin struct {
+	Client  *Client
+	Records RecordStore
+	// GetEnrollment returns a fresh enrollment response, e.g. Server.GetEnrollmentContext or RemoteServer.GetEnrollment
+	GetEnrollment func(ctx context.Context) (*EnrollmentResponse, error)
+	// VerifyPassword asks the server to verify a password, e.g. Server.VerifyPasswordContext or RemoteServer.VerifyPassword
+	VerifyPassword func(ctx context.Context, req *VerifyPasswordRequest) (*VerifyPasswordResponse, error)
+	// Legacy checks the password against the user's legacy hash, ok is false if it's wrong or there's no hash
+	Legacy func(ctx context.Context, key string, password []byte) (ok bool, err error)
+	// Retire, if set, flags the user's legacy hash for deletion once the record is stored. Its failure is logged
+	// and doesn't fail the login, the legacy hash is never checked again after enrollment
+	Retire func(ctx context.Context, key string) error
+}
+
+// Login checks the password of the user stored under key and returns the account's encryption key,
+// which is nil if the password is wrong
+func (l *LegacyLogin) Login(ctx context.Context, key string, password []byte) ([]byte, error) {
+	rec, err := l.Records.Get(ctx, key)
+	if err == nil {
+		return l.verify(ctx, password, rec)
+	}
+	if !errors.Is(err, ErrRecordNotFound) {
+		return nil, err
+	}
+
+	ok, err := l.Legacy(ctx, key, password)
+	if err != nil {
+		return nil, errors.Wrap(err, "legacy verification failed")
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	enrollment, err := l.GetEnrollment(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rec, accountKey, err := l.Client.EnrollAccountContext(ctx, password, enrollment)
+	if err != nil {
+		return nil, err
+	}
+	if err = l.Records.Create(ctx, key, rec); err != nil {
+		Wipe(accountKey)
+		if !errors.Is(err, ErrRecordConflict) {
+			return nil, errors.Wrapf(err, "could not create record %s", key)
+		}
+
+		// another login enrolled the user first
+		if rec, err = l.Records.Get(ctx, key); err != nil {
+			return nil, err
+		}
+		return l.verify(ctx, password, rec)
+	}
+
+	if l.Retire != nil {
+		if err = l.Retire(ctx, key); err != nil {
+			l.Client.logger.LogAttrs(ctx, slog.LevelWarn, "phe: legacy hash not retired", errAttr(err), slog.String("key", key))
+		}
+	}
+	return accountKey, nil
+}
+
+// verify checks the password against the user's record
+func (l *LegacyLogin) verify(ctx context.Context, password []byte, rec *EnrollmentRecord) ([]byte, error) {
+	req, err := l.Client.CreateVerifyPasswordRequest(password, rec)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := l.VerifyPassword(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return l.Client.CheckResponseAndDecryptContext(ctx, password, rec, resp)
+}
@@ -0,0 +1,137 @@
+package phe
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type memStore map[string]*EnrollmentRecord
+
+func (m memStore) Get(ctx context.Context, key string) (*EnrollmentRecord, error) {
+	rec, ok := m[key]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	return rec, nil
+}
+
+func (m memStore) Put(ctx context.Context, key string, rec *EnrollmentRecord) error {
+	m[key] = rec
+	return nil
+}
+
+func (m memStore) Create(ctx context.Context, key string, rec *EnrollmentRecord) error {
+	if _, ok := m[key]; ok {
+		return ErrRecordConflict
+	}
+	m[key] = rec
+	return nil
+}
+
+func (m memStore) Iterate(ctx context.Context, after string, fn func(key string, rec *EnrollmentRecord) error) error {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		if k > after {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if err := fn(k, m[k]); err != nil {
+			if err == ErrStopIteration {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (m memStore) UpdateConditional(ctx context.Context, key string, old, rec *EnrollmentRecord) error {
+	stored, ok := m[key]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	if !stored.Equal(old) {
+		return ErrRecordConflict
+	}
+	m[key] = rec
+	return nil
+}
+
+func TestLegacyLogin(t *testing.T) {
+	s := mustServer(t, mustKeypair(t))
+	c, err := NewClient(GenerateClientKey(), s.PublicKey())
+	assert.NoError(t, err)
+
+	legacy := map[string][]byte{"alice": pwd}
+	retired := map[string]bool{}
+	records := memStore{}
+	l := &LegacyLogin{
+		Client:         c,
+		Records:        records,
+		GetEnrollment:  s.GetEnrollmentContext,
+		VerifyPassword: s.VerifyPasswordContext,
+		Legacy: func(ctx context.Context, key string, password []byte) (bool, error) {
+			if key == "broken" {
+				return false, errors.New("connection lost")
+			}
+			hash, ok := legacy[key]
+			return ok && bytes.Equal(hash, password), nil
+		},
+		Retire: func(ctx context.Context, key string) error {
+			retired[key] = true
+			return nil
+		},
+	}
+	ctx := context.Background()
+
+	key, err := l.Login(ctx, "alice", []byte("wrong"))
+	assert.NoError(t, err)
+	assert.Nil(t, key)
+	assert.Empty(t, records)
+
+	key, err = l.Login(ctx, "alice", pwd)
+	assert.NoError(t, err)
+	assert.NotNil(t, key)
+	assert.Contains(t, records, "alice")
+	assert.True(t, retired["alice"])
+
+	// the legacy hash is never checked again
+	delete(legacy, "alice")
+	keyDec, err := l.Login(ctx, "alice", pwd)
+	assert.NoError(t, err)
+	assert.Equal(t, key, keyDec)
+	keyDec, err = l.Login(ctx, "alice", []byte("wrong"))
+	assert.NoError(t, err)
+	assert.Nil(t, keyDec)
+
+	key, err = l.Login(ctx, "bob", pwd)
+	assert.NoError(t, err)
+	assert.Nil(t, key)
+	_, err = l.Login(ctx, "broken", pwd)
+	assert.Error(t, err)
+
+	// a concurrent first login of carol enrolls her after this one checked the legacy hash
+	legacy["carol"] = pwd
+	var first *EnrollmentRecord
+	var firstKey []byte
+	l.Legacy = func(ctx context.Context, key string, password []byte) (bool, error) {
+		enrollment, err := s.GetEnrollment()
+		assert.NoError(t, err)
+		first, firstKey, err = c.EnrollAccount(password, enrollment)
+		assert.NoError(t, err)
+		records[key] = first
+		return true, nil
+	}
+	key, err = l.Login(ctx, "carol", pwd)
+	assert.NoError(t, err)
+	assert.Equal(t, firstKey, key)
+	assert.Same(t, first, records["carol"])
+}
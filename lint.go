@@ -0,0 +1,184 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultMaxTokenAge is how long an update token may stay unapplied before Lint flags it
+const defaultMaxTokenAge = 24 * time.Hour
+
+// LintSeverity tells how urgently a finding should be addressed
+type LintSeverity int
+
+const (
+	// LintWarning is a risky but working setup
+	LintWarning LintSeverity = iota + 1
+	// LintError is a setup which is broken or insecure
+	LintError
+)
+
+func (s LintSeverity) String() string {
+	if s == LintError {
+		return "error"
+	}
+	return "warning"
+}
+
+// LintFinding is a single problem found by Lint
+type LintFinding struct {
+	Severity LintSeverity
+	Code     string
+	Message  string
+}
+
+func (f LintFinding) String() string {
+	return fmt.Sprintf("%s [%s]: %s", f.Severity, f.Code, f.Message)
+}
+
+// PendingToken is an update token which hasn't yet been applied to all records
+type PendingToken struct {
+	Token    *UpdateToken
+	IssuedAt time.Time
+}
+
+// LintConfig describes how an integrator uses the package
+type LintConfig struct {
+	// SkipProofVerification is set if server responses are used without checking their proofs
+	SkipProofVerification bool
+	// AllowedSuites lists parameter sets the deployment is supposed to use, empty allows any
+	AllowedSuites []string
+	// PendingTokens are update tokens still being applied to records
+	PendingTokens []PendingToken
+	// MaxTokenAge is how long applying a token may take, 24 hours by default
+	MaxTokenAge time.Duration
+}
+
+// Lint checks a deployment's configuration, server keypair and a sample of its records for risky setups.
+// It's meant to be run by integrators in CI or staging, every finding carries an actionable message
+func Lint(config *LintConfig, serverKeypair []byte, sampleRecords []*EnrollmentRecord) []LintFinding {
+	if config == nil {
+		config = &LintConfig{}
+	}
+
+	var findings []LintFinding
+	add := func(severity LintSeverity, code, format string, args ...interface{}) {
+		findings = append(findings, LintFinding{Severity: severity, Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if config.SkipProofVerification {
+		add(LintError, "proofs-disabled", "server proofs are not verified, a malicious server could learn passwords offline; check errors of EnrollAccount and CheckResponseAndDecrypt")
+	}
+
+	var curve *Curve
+	s, err := NewServer(serverKeypair)
+	if err != nil {
+		add(LintError, "invalid-keypair", "server keypair can't be parsed: %v", err)
+	} else if err = s.Warmup(); err != nil {
+		add(LintError, "invalid-keypair", "server keypair fails self-test: %v", err)
+	} else {
+		curve = s.curve
+	}
+
+	if curve != nil && len(config.AllowedSuites) > 0 && !containsString(config.AllowedSuites, curve.name) {
+		add(LintError, "suite-not-allowed", "server keypair uses %s which is not among allowed suites %v; generate a keypair with an allowed suite", curve.name, config.AllowedSuites)
+	}
+
+	maxAge := config.MaxTokenAge
+	if maxAge <= 0 {
+		maxAge = defaultMaxTokenAge
+	}
+	for i, pt := range config.PendingTokens {
+		if curve != nil {
+			if _, _, err = pt.Token.parse(curve); err != nil {
+				add(LintError, "invalid-token", "pending update token %d is malformed or belongs to another suite", i)
+				continue
+			}
+		}
+		if age := time.Since(pt.IssuedAt); age > maxAge {
+			add(LintWarning, "stale-token", "update token %d was issued %v ago and is still pending; finish UpdateRecord for all records so the old key can be discarded", i, age.Round(time.Second))
+		}
+	}
+
+	lintRecords(curve, sampleRecords, add)
+	return findings
+}
+
+func lintRecords(curve *Curve, records []*EnrollmentRecord, add func(LintSeverity, string, string, ...interface{})) {
+	if len(records) == 0 {
+		return
+	}
+
+	audit := AuditRecords(records)
+	if audit.ShortNonces > 0 {
+		add(LintWarning, "short-nonces", "%d of %d sample records have nonces shorter than 32 bytes; re-enroll them with RerandomizeRecord", audit.ShortNonces, audit.Records)
+	}
+	if audit.DuplicateNS > 0 || audit.DuplicateNC > 0 || audit.DuplicatePoints > 0 {
+		add(LintError, "linked-records", "sample records share nonces or points which links accounts to each other; never reuse enrollment responses")
+	}
+	if audit.Malformed > 0 {
+		add(LintError, "malformed-records", "%d of %d sample records can't be parsed", audit.Malformed, audit.Records)
+	}
+
+	if curve == nil {
+		return
+	}
+
+	stale := 0
+	for _, rec := range records {
+		if rec == nil {
+			continue
+		}
+		if c, err := curveByPoint(rec.T0); err == nil && c != curve {
+			stale++
+		}
+	}
+	if stale > 0 {
+		add(LintError, "stale-suite", "%d of %d sample records use another suite than the server keypair; migrate them before retiring the old keypair", stale, len(records))
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package phe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func lintCodes(findings []LintFinding) []string {
+	codes := make([]string, len(findings))
+	for i, f := range findings {
+		codes[i] = f.Code
+	}
+	return codes
+}
+
+func TestLint(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	pub, err := GetPublicKey(serverKeypair)
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), pub)
+	assert.NoError(t, err)
+
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	assert.Empty(t, Lint(nil, serverKeypair, []*EnrollmentRecord{rec}))
+
+	token, _, err := Rotate(serverKeypair)
+	assert.NoError(t, err)
+	short := *rec
+	short.NC = short.NC[:8]
+
+	findings := Lint(&LintConfig{
+		SkipProofVerification: true,
+		AllowedSuites:         []string{"P-384"},
+		PendingTokens: []PendingToken{
+			{Token: token, IssuedAt: time.Now().Add(-48 * time.Hour)},
+			{Token: token, IssuedAt: time.Now()},
+			{Token: &UpdateToken{A: []byte{0}}, IssuedAt: time.Now()},
+		},
+	}, serverKeypair, []*EnrollmentRecord{rec, &short})
+
+	assert.Equal(t, []string{"proofs-disabled", "suite-not-allowed", "stale-token", "invalid-token", "short-nonces", "linked-records"}, lintCodes(findings))
+	assert.Contains(t, findings[0].String(), "error [proofs-disabled]")
+
+	findings = Lint(nil, []byte("garbage"), []*EnrollmentRecord{nil})
+	assert.Equal(t, []string{"invalid-keypair", "malformed-records"}, lintCodes(findings))
+}
@@ -0,0 +1,122 @@
+package phe
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// logLines decodes JSON lines written by slog.JSONHandler
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if l == "" {
+			continue
+		}
+		var line map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(l), &line))
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, nil))
+
+	serverKeypair := mustKeypair(t)
+	s, err := NewServer(serverKeypair, WithServerLogger(logger))
+	assert.NoError(t, err)
+	clientKey := GenerateClientKey()
+	c, err := NewClient(clientKey, s.PublicKey(), WithClientLogger(logger))
+	assert.NoError(t, err)
+
+	enrollment, err := s.GetEnrollment()
+	assert.NoError(t, err)
+	rec, key, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	resp, err := s.VerifyPassword(req)
+	assert.NoError(t, err)
+	_, err = c.CheckResponseAndDecrypt(pwd, rec, resp)
+	assert.NoError(t, err)
+	assert.Empty(t, buf.String())
+
+	resp.ProofSuccess.BlindX = resp.ProofSuccess.Term1
+	_, err = c.CheckResponseAndDecrypt(pwd, rec, resp)
+	assert.Error(t, err)
+
+	_, err = c.CheckResponseAndDecrypt(pwd, &EnrollmentRecord{NS: rec.NS, NC: rec.NC, T0: rec.T0}, resp)
+	assert.Error(t, err)
+
+	_, err = s.VerifyPassword(&VerifyPasswordRequest{NS: req.NS, C0: []byte{4}})
+	assert.Error(t, err)
+
+	token, newKeypair, err := Rotate(serverKeypair)
+	assert.NoError(t, err)
+	assert.Error(t, c.RotateChecked(token, s.PublicKey()))
+	assert.NoError(t, c.RotateChecked(token, mustPublicKey(t, newKeypair)))
+
+	lines := logLines(t, buf)
+	var msgs []string
+	for _, l := range lines {
+		msgs = append(msgs, l["level"].(string)+" "+l["msg"].(string))
+	}
+	assert.Equal(t, []string{
+		"WARN phe: server proof rejected",
+		"WARN phe: invalid enrollment record",
+		"WARN phe: verify password request rejected",
+		"WARN phe: rotation rejected",
+		"INFO phe: rotation applied",
+	}, msgs)
+	assert.Equal(t, Fingerprint(rec.NS), lines[1]["ns"])
+	assert.Equal(t, Fingerprint(req.NS), lines[2]["ns"])
+
+	for _, secret := range [][]byte{pwd, key, clientKey, rec.NS, rec.T0, rec.T1, req.C0} {
+		assert.NotContains(t, buf.String(), hex.EncodeToString(secret))
+		assert.NotContains(t, buf.String(), string(secret))
+	}
+}
+
+func TestLogger_Migration(t *testing.T) {
+	buf := &bytes.Buffer{}
+	serverKeypair := mustKeypair(t)
+	c, err := NewClient(GenerateClientKey(), mustPublicKey(t, serverKeypair))
+	assert.NoError(t, err)
+
+	store := &memRecords{records: map[string]*EnrollmentRecord{"b": {NS: []byte("broken")}}}
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	store.records["a"], _, err = c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+	token, _, err := Rotate(serverKeypair)
+	assert.NoError(t, err)
+
+	m := &Migration{
+		Source:      store,
+		Sink:        store,
+		Checkpoints: NewFileCheckpoint(filepath.Join(t.TempDir(), "checkpoint.json")),
+		Token:       token,
+		Logger:      slog.New(slog.NewJSONHandler(buf, nil)),
+	}
+	_, err = m.Run(context.Background())
+	assert.NoError(t, err)
+
+	lines := logLines(t, buf)
+	assert.Len(t, lines, 3)
+	assert.Equal(t, "phe: record update failed", lines[0]["msg"])
+	assert.Equal(t, "b", lines[0]["key"])
+	assert.Equal(t, "phe: rotation applied to batch", lines[1]["msg"])
+	assert.Equal(t, 2.0, lines[1]["processed"])
+	assert.Equal(t, 1.0, lines[1]["failed"])
+	assert.Equal(t, true, lines[2]["done"])
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"time"
+)
+
+// Metrics receives measurements of protocol operations, e.g. to alert on spikes of proof failures.
+// Package pheprom adapts Prometheus. Methods are called synchronously and must be fast
+type Metrics interface {
+	// Enrollment counts an enrollment issued by the server or completed by the client
+	Enrollment(source EventSource)
+	// Verification counts a password check which completed, ok is whether the password was right
+	Verification(source EventSource, ok bool, d time.Duration)
+	// ProofVerification measures how long the client took to check a server proof, err is nil if it was accepted
+	ProofVerification(d time.Duration, err error)
+	// MigrationProgress reports the checkpoint of a migration after each batch
+	MigrationProgress(cp *Checkpoint)
+}
+
+// WithServerMetrics makes the server report enrollments and verifications
+func WithServerMetrics(m Metrics) ServerOption {
+	return func(s *Server) {
+		s.metrics = m
+	}
+}
+
+// WithClientMetrics makes the client report enrollments, verifications and proof checks
+func WithClientMetrics(m Metrics) ClientOption {
+	return func(c *Client) {
+		c.metrics = m
+	}
+}
+
+// nopMetrics is used when no metrics are set so call sites don't check for nil
+type nopMetrics struct{}
+
+func (nopMetrics) Enrollment(EventSource) {}
+
+func (nopMetrics) Verification(EventSource, bool, time.Duration) {}
+
+func (nopMetrics) ProofVerification(time.Duration, error) {}
+
+func (nopMetrics) MigrationProgress(*Checkpoint) {}
@@ -0,0 +1,72 @@
+package phe
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingMetrics struct {
+	mu            sync.Mutex
+	enrollments   []EventSource
+	verifications []bool
+	proofErrors   []error
+	checkpoints   []Checkpoint
+}
+
+func (m *recordingMetrics) Enrollment(source EventSource) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.enrollments = append(m.enrollments, source)
+}
+
+func (m *recordingMetrics) Verification(source EventSource, ok bool, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.verifications = append(m.verifications, ok)
+}
+
+func (m *recordingMetrics) ProofVerification(d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.proofErrors = append(m.proofErrors, err)
+}
+
+func (m *recordingMetrics) MigrationProgress(cp *Checkpoint) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checkpoints = append(m.checkpoints, *cp)
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := &recordingMetrics{}
+	s, err := NewServer(mustKeypair(t), WithServerMetrics(metrics))
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), s.PublicKey(), WithClientMetrics(metrics))
+	assert.NoError(t, err)
+
+	enrollment, err := s.GetEnrollment()
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+	assert.Equal(t, []EventSource{SourceServer, SourceClient}, metrics.enrollments)
+
+	for _, p := range [][]byte{pwd, []byte("wrong")} {
+		req, err := c.CreateVerifyPasswordRequest(p, rec)
+		assert.NoError(t, err)
+		resp, err := s.VerifyPassword(req)
+		assert.NoError(t, err)
+		_, err = c.CheckResponseAndDecrypt(p, rec, resp)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []bool{true, true, false, false}, metrics.verifications)
+
+	enrollment.Proof.BlindX = enrollment.Proof.Term1
+	_, _, err = c.EnrollAccount(pwd, enrollment)
+	assert.Error(t, err)
+	assert.Len(t, metrics.proofErrors, 4)
+	assert.Nil(t, metrics.proofErrors[2])
+	assert.Equal(t, err, metrics.proofErrors[3])
+}
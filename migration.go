@@ -0,0 +1,369 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+const defaultMigrationBatch = 1000
+
+var migrationDomain = []byte("MigrationPending")
+
+// KeyedRecord is an enrollment record together with the key it's stored under
+type KeyedRecord struct {
+	Key    string
+	Record *EnrollmentRecord
+	// Previous is the record Record replaces, Migration sets it so that sinks can write conditionally
+	Previous *EnrollmentRecord
+}
+
+// RecordSource lists stored records in ascending key order
+type RecordSource interface {
+	// Records returns up to limit records with keys greater than after, no records means the end was reached
+	Records(ctx context.Context, after string, limit int) ([]KeyedRecord, error)
+}
+
+// RecordSink stores updated records, replacing the ones under the same keys. Records which changed or were deleted
+// since they were read should be skipped and reported with ErrRecordConflict or ErrRecordNotFound in a *BatchError,
+// Migration records them as failures
+type RecordSink interface {
+	PutRecords(ctx context.Context, records []KeyedRecord) error
+}
+
+// CheckpointStore persists migration progress. Load returns nil if no migration has been started
+type CheckpointStore interface {
+	LoadCheckpoint(ctx context.Context) (*Checkpoint, error)
+	SaveCheckpoint(ctx context.Context, cp *Checkpoint) error
+}
+
+// Checkpoint is the progress of a record migration
+type Checkpoint struct {
+	// LastKey is the key of the last record of the last completed batch
+	LastKey   string `json:"last_key"`
+	Processed int64  `json:"processed"`
+	Updated   int64  `json:"updated"`
+	Failed    int64  `json:"failed"`
+	// Failures lists records which couldn't be updated, they are left as they were
+	Failures []MigrationFailure `json:"failures,omitempty"`
+	// Pending holds digests of updated records of the batch being written, so that records which
+	// were already written before an interruption aren't updated twice
+	Pending map[string][]byte `json:"pending,omitempty"`
+	Done    bool              `json:"done"`
+	// Token identifies the update token the migration runs with, a checkpoint can't be resumed with another one
+	Token []byte `json:"token,omitempty"`
+	// Shard is the part of the table the migration covers, nil for the whole table
+	Shard *Shard `json:"shard,omitempty"`
+}
+
+// MigrationFailure describes a record which couldn't be updated
+type MigrationFailure struct {
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+// Migration applies an update token to every record of a source and writes the results to a sink.
+// Progress is checkpointed after every batch so an interrupted migration resumes where it stopped
+// when Run is called again with the same checkpoint store and token
+type Migration struct {
+	Source      RecordSource
+	Sink        RecordSink
+	Checkpoints CheckpointStore
+	Token       *UpdateToken
+	// BatchSize is the number of records read, updated and written at once, 1000 by default
+	BatchSize int
+	// Options configure how each batch is updated, see UpdateRecordsContext
+	Options []BulkOption
+	// OnWrite, if set, is called with the keys of every written batch, e.g. to invalidate caches
+	OnWrite func(ctx context.Context, keys []string) error
+	// Shard, if set, restricts the migration to records of that shard, see NewShardedMigrations
+	Shard *Shard
+	// Tracer, if set, traces the migration and each of its batches
+	Tracer Tracer
+	// Metrics, if set, receives the checkpoint after every batch
+	Metrics Metrics
+	// Logger, if set, logs records which couldn't be updated and progress after every batch
+	Logger Logger
+}
+
+// Run migrates all records which haven't been migrated yet and returns the final checkpoint.
+// Records which fail to update are recorded in the checkpoint and don't stop the migration
+func (m *Migration) Run(ctx context.Context) (cp *Checkpoint, err error) {
+	ctx, span := startSpan(ctx, m.Tracer, "phe.Migration.Run")
+	defer func() {
+		if cp != nil {
+			span.SetAttribute("phe.processed", cp.Processed)
+			span.SetAttribute("phe.failed", cp.Failed)
+		}
+		span.End(err)
+	}()
+	return m.run(ctx)
+}
+
+func (m *Migration) run(ctx context.Context) (*Checkpoint, error) {
+	if m.Source == nil || m.Sink == nil || m.Checkpoints == nil || m.Token == nil {
+		return nil, errors.New("incomplete migration")
+	}
+
+	size := m.BatchSize
+	if size <= 0 {
+		size = defaultMigrationBatch
+	}
+
+	cp, err := m.Checkpoints.LoadCheckpoint(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load checkpoint")
+	}
+	if cp == nil {
+		cp = &Checkpoint{}
+	}
+
+	digest := tokenDigest(m.Token)
+	if cp.Token == nil {
+		cp.Token = digest
+	} else if !bytes.Equal(cp.Token, digest) {
+		return cp, errors.New("checkpoint belongs to another update token")
+	}
+
+	source := m.Source
+	if m.Shard != nil {
+		if err = m.Shard.validate(); err != nil {
+			return nil, err
+		}
+		if cp.Shard == nil && cp.Processed == 0 {
+			shard := *m.Shard
+			cp.Shard = &shard
+		}
+		if cp.Shard == nil || *cp.Shard != *m.Shard {
+			return cp, errors.New("checkpoint belongs to another shard")
+		}
+		source = &shardedSource{src: m.Source, shard: *m.Shard}
+	} else if cp.Shard != nil {
+		return cp, errors.New("checkpoint belongs to another shard")
+	}
+
+	for !cp.Done {
+		batch, err := source.Records(ctx, cp.LastKey, size)
+		if err != nil {
+			return cp, errors.Wrap(err, "could not read records")
+		}
+
+		if len(batch) == 0 {
+			cp.Done = true
+		} else if err = m.migrateBatch(ctx, cp, batch); err != nil {
+			return cp, err
+		}
+
+		if err = m.Checkpoints.SaveCheckpoint(ctx, cp); err != nil {
+			return cp, errors.Wrap(err, "could not save checkpoint")
+		}
+		if m.Metrics != nil {
+			m.Metrics.MigrationProgress(cp)
+		}
+		m.log(ctx, slog.LevelInfo, "phe: rotation applied to batch", slog.String("last_key", cp.LastKey),
+			slog.Int64("processed", cp.Processed), slog.Int64("updated", cp.Updated), slog.Int64("failed", cp.Failed), slog.Bool("done", cp.Done))
+	}
+
+	return cp, nil
+}
+
+// migrateBatch updates and writes a batch. Before anything is written the digests of updated records
+// are checkpointed, so after a crash records that made it to the sink are recognized and skipped
+func (m *Migration) migrateBatch(ctx context.Context, cp *Checkpoint, batch []KeyedRecord) (err error) {
+	ctx, span := startSpan(ctx, m.Tracer, "phe.Migration.Batch")
+	defer func() { span.End(err) }()
+	span.SetAttribute("phe.records", len(batch))
+
+	todo := make([]*EnrollmentRecord, 0, len(batch))
+	keys := make([]string, 0, len(batch))
+	var written []string
+
+	for _, kr := range batch {
+		if d, ok := cp.Pending[kr.Key]; ok && kr.Record != nil && bytes.Equal(d, recordDigest(kr.Record)) {
+			// written before the interruption
+			cp.Updated++
+			written = append(written, kr.Key)
+			continue
+		}
+		todo = append(todo, kr.Record)
+		keys = append(keys, kr.Key)
+	}
+
+	updated, err := UpdateRecordsContext(ctx, todo, m.Token, m.Options...)
+	var failed []error
+	if batchErr, ok := err.(*BatchError); ok {
+		failed = batchErr.Errors
+	} else if err != nil {
+		return err
+	}
+
+	if cp.Pending == nil {
+		cp.Pending = make(map[string][]byte)
+	}
+
+	out := make([]KeyedRecord, 0, len(updated))
+	for i, rec := range updated {
+		if rec == nil {
+			cp.Failed++
+			cp.Failures = append(cp.Failures, MigrationFailure{Key: keys[i], Error: failed[i].Error()})
+			m.log(ctx, slog.LevelWarn, "phe: record update failed", slog.String("key", keys[i]), errAttr(failed[i]))
+			continue
+		}
+		cp.Pending[keys[i]] = recordDigest(rec)
+		out = append(out, KeyedRecord{Key: keys[i], Record: rec, Previous: todo[i]})
+	}
+
+	if err = m.Checkpoints.SaveCheckpoint(ctx, cp); err != nil {
+		return errors.Wrap(err, "could not save checkpoint")
+	}
+
+	if out, err = m.putRecords(ctx, cp, out); err != nil {
+		return errors.Wrap(err, "could not write records")
+	}
+
+	if m.OnWrite != nil {
+		// records written before an interruption are reported again as the hook may not have run for them
+		for _, kr := range out {
+			written = append(written, kr.Key)
+		}
+		if err = m.OnWrite(ctx, written); err != nil {
+			return err
+		}
+	}
+
+	for _, kr := range batch {
+		delete(cp.Pending, kr.Key)
+	}
+	cp.Updated += int64(len(out))
+	cp.Processed += int64(len(batch))
+	cp.LastKey = batch[len(batch)-1].Key
+	return nil
+}
+
+// putRecords writes records to the sink and returns the ones which were written. Records which changed
+// or were deleted concurrently are left as they are and recorded as failures
+func (m *Migration) putRecords(ctx context.Context, cp *Checkpoint, out []KeyedRecord) ([]KeyedRecord, error) {
+	err := m.Sink.PutRecords(ctx, out)
+	batchErr, ok := err.(*BatchError)
+	if !ok {
+		return out, err
+	}
+
+	written := out[:0:0]
+	for i, kr := range out {
+		switch recErr := batchErr.Errors[i]; {
+		case recErr == nil:
+			written = append(written, kr)
+		case errors.Is(recErr, ErrRecordConflict), errors.Is(recErr, ErrRecordNotFound):
+			cp.Failed++
+			cp.Failures = append(cp.Failures, MigrationFailure{Key: kr.Key, Error: recErr.Error()})
+			m.log(ctx, slog.LevelWarn, "phe: record changed during migration", slog.String("key", kr.Key), errAttr(recErr))
+		default:
+			return nil, recErr
+		}
+	}
+	return written, nil
+}
+
+// log logs to Logger if it's set
+func (m *Migration) log(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr) {
+	if m.Logger != nil {
+		m.Logger.LogAttrs(ctx, level, msg, attrs...)
+	}
+}
+
+func recordDigest(rec *EnrollmentRecord) []byte {
+	return TupleHash([][]byte{rec.NS, rec.NC, rec.T0, rec.T1}, migrationDomain)
+}
+
+// fileCheckpoint keeps the checkpoint in a JSON file which is replaced atomically
+type fileCheckpoint struct {
+	path string
+}
+
+// NewFileCheckpoint returns a CheckpointStore keeping migration progress in a JSON file
+func NewFileCheckpoint(path string) CheckpointStore {
+	return &fileCheckpoint{path: path}
+}
+
+func (f *fileCheckpoint) LoadCheckpoint(ctx context.Context) (*Checkpoint, error) {
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cp := &Checkpoint{}
+	if err = json.Unmarshal(data, cp); err != nil {
+		return nil, errors.Wrap(err, "invalid checkpoint")
+	}
+	return cp, nil
+}
+
+func (f *fileCheckpoint) SaveCheckpoint(ctx context.Context, cp *Checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.path)
+}
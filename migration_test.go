@@ -0,0 +1,179 @@
+package phe
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// memRecords is a RecordSource and RecordSink, failAfter makes PutRecords fail after writing that many batches
+type memRecords struct {
+	records   map[string]*EnrollmentRecord
+	failAfter int
+	puts      int
+}
+
+func (m *memRecords) Records(ctx context.Context, after string, limit int) ([]KeyedRecord, error) {
+	keys := make([]string, 0, len(m.records))
+	for k := range m.records {
+		if k > after {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	res := make([]KeyedRecord, len(keys))
+	for i, k := range keys {
+		res[i] = KeyedRecord{Key: k, Record: m.records[k]}
+	}
+	return res, nil
+}
+
+func (m *memRecords) PutRecords(ctx context.Context, records []KeyedRecord) error {
+	for _, kr := range records {
+		m.records[kr.Key] = kr.Record
+	}
+	m.puts++
+	if m.failAfter > 0 && m.puts == m.failAfter {
+		return errors.New("connection lost")
+	}
+	return nil
+}
+
+func TestMigration_Resume(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	pub, err := GetPublicKey(serverKeypair)
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), pub)
+	assert.NoError(t, err)
+
+	store := &memRecords{records: map[string]*EnrollmentRecord{}, failAfter: 2}
+	for _, k := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		enrollment, err := GetEnrollment(serverKeypair)
+		assert.NoError(t, err)
+		store.records[k], _, err = c.EnrollAccount(pwd, enrollment)
+		assert.NoError(t, err)
+	}
+	store.records["x"] = &EnrollmentRecord{NS: []byte("broken")}
+
+	token, newKeypair, err := Rotate(serverKeypair)
+	assert.NoError(t, err)
+	assert.NoError(t, c.RotateChecked(token, mustPublicKey(t, newKeypair)))
+
+	written := map[string]bool{}
+	m := &Migration{
+		OnWrite: func(ctx context.Context, keys []string) error {
+			for _, k := range keys {
+				written[k] = true
+			}
+			return nil
+		},
+		Source:      store,
+		Sink:        store,
+		Checkpoints: NewFileCheckpoint(filepath.Join(t.TempDir(), "checkpoint.json")),
+		Token:       token,
+		BatchSize:   3,
+	}
+
+	//second batch is written but the sink reports a failure
+	cp, err := m.Run(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, "c", cp.LastKey)
+	assert.Len(t, cp.Pending, 3)
+
+	cp, err = m.Run(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, cp.Done)
+	assert.Equal(t, int64(8), cp.Processed)
+	assert.Equal(t, int64(7), cp.Updated)
+	assert.Equal(t, int64(1), cp.Failed)
+	assert.Equal(t, "x", cp.Failures[0].Key)
+	assert.Empty(t, cp.Pending)
+	assert.Len(t, written, 7)
+
+	for k, rec := range store.records {
+		if k == "x" {
+			continue
+		}
+		req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+		assert.NoError(t, err)
+		resp, err := VerifyPassword(newKeypair, req)
+		assert.NoError(t, err)
+		assert.True(t, resp.Res, k)
+	}
+
+	//finished migration is not run again
+	cp, err = m.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8), cp.Processed)
+}
+
+// racingSink changes records between the migration reading and writing them
+type racingSink struct {
+	StoreRecords
+	race func()
+}
+
+func (r racingSink) PutRecords(ctx context.Context, records []KeyedRecord) error {
+	r.race()
+	return r.StoreRecords.PutRecords(ctx, records)
+}
+
+func TestMigration_ConcurrentChange(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	c, err := NewClient(GenerateClientKey(), mustPublicKey(t, serverKeypair))
+	assert.NoError(t, err)
+
+	store := memStore{}
+	for _, k := range []string{"a", "b", "c", "d"} {
+		enrollment, err := GetEnrollment(serverKeypair)
+		assert.NoError(t, err)
+		store[k], _, err = c.EnrollAccount(pwd, enrollment)
+		assert.NoError(t, err)
+	}
+
+	// b changes password and d is deleted after the migration has read them
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	changed, _, err := c.EnrollAccount([]byte("new password"), enrollment)
+	assert.NoError(t, err)
+
+	token, newKeypair, err := Rotate(serverKeypair)
+	assert.NoError(t, err)
+	m := &Migration{
+		Source: StoreRecords{store},
+		Sink: racingSink{StoreRecords{store}, func() {
+			if _, ok := store["d"]; ok {
+				store["b"] = changed
+				delete(store, "d")
+			}
+		}},
+		Checkpoints: NewFileCheckpoint(filepath.Join(t.TempDir(), "checkpoint.json")),
+		Token:       token,
+	}
+
+	cp, err := m.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), cp.Processed)
+	assert.Equal(t, int64(2), cp.Updated)
+	assert.Equal(t, int64(2), cp.Failed)
+	assert.Equal(t, "b", cp.Failures[0].Key)
+	assert.Contains(t, cp.Failures[0].Error, ErrRecordConflict.Error())
+	assert.Equal(t, "d", cp.Failures[1].Key)
+	assert.Same(t, changed, store["b"])
+	assert.NotContains(t, store, "d")
+
+	assert.NoError(t, c.RotateChecked(token, mustPublicKey(t, newKeypair)))
+	req, err := c.CreateVerifyPasswordRequest(pwd, store["a"])
+	assert.NoError(t, err)
+	resp, err := VerifyPassword(newKeypair, req)
+	assert.NoError(t, err)
+	assert.True(t, resp.Res)
+}
@@ -39,6 +39,7 @@ package phe
 import (
 	"math/big"
 
+	"github.com/passw0rd/phe-go/internal/wire"
 	"github.com/pkg/errors"
 )
 
@@ -48,22 +49,24 @@ type EnrollmentRecord struct {
 	NC []byte `json:"nc"`
 	T0 []byte `json:"t_0"`
 	T1 []byte `json:"t_1"`
+	// KeyVersion is the version of server and client keys the record was made or last updated with, 0 if unknown
+	KeyVersion uint32 `json:"key_version,omitempty"`
+	// MAC, if set, authenticates the fields above with a client-held key, see WithRecordMAC
+	MAC []byte `json:"mac,omitempty"`
 }
 
-func (c *EnrollmentRecord) parse() (t0, t1 *Point, err error) {
+func (c *EnrollmentRecord) parse(curve *Curve) (t0, t1 *Point, err error) {
 
-	if c == nil ||
-		len(c.NC) == 0 || len(c.NS) == 0 ||
-		len(c.NC) > 32 || len(c.NS) > 32 {
+	if c == nil || wire.Nonce(c.NC) != nil || wire.Nonce(c.NS) != nil {
 		err = errors.New("invalid record")
 		return
 	}
 
-	if t0, err = PointUnmarshal(c.T0); err != nil {
+	if t0, err = curve.pointUnmarshal(c.T0); err != nil {
 		return
 	}
 
-	t1, err = PointUnmarshal(c.T1)
+	t1, err = curve.pointUnmarshal(c.T1)
 	return
 }
 
@@ -75,29 +78,28 @@ type ProofOfSuccess struct {
 	BlindX []byte `json:"blind_x"`
 }
 
-func (p *ProofOfSuccess) parse() (term1, term2, term3 *Point, blindX *big.Int, err error) {
+func (p *ProofOfSuccess) parse(c *Curve) (term1, term2, term3 *Point, blindX *big.Int, err error) {
 	if p == nil {
 		err = errors.New("invalid proof")
 		return
 	}
 
-	if term1, err = PointUnmarshal(p.Term1); err != nil {
+	if term1, err = c.pointUnmarshal(p.Term1); err != nil {
 		return
 	}
 
-	if term2, err = PointUnmarshal(p.Term2); err != nil {
+	if term2, err = c.pointUnmarshal(p.Term2); err != nil {
 		return
 	}
 
-	if term3, err = PointUnmarshal(p.Term3); err != nil {
+	if term3, err = c.pointUnmarshal(p.Term3); err != nil {
 		return
 	}
 
-	if len(p.BlindX) == 0 || len(p.BlindX) > 32 {
+	if blindX, err = c.parseScalar(p.BlindX); err != nil {
 		err = errors.New("invalid proof")
 		return
 	}
-	blindX = new(big.Int).SetBytes(p.BlindX)
 
 	return
 }
@@ -112,41 +114,38 @@ type ProofOfFail struct {
 	BlindB []byte `json:"blind_b"`
 }
 
-func (p *ProofOfFail) parse() (term1, term2, term3, term4 *Point, blindA, blindB *big.Int, err error) {
+func (p *ProofOfFail) parse(c *Curve) (term1, term2, term3, term4 *Point, blindA, blindB *big.Int, err error) {
 	if p == nil {
 		err = errors.New("invalid proof")
 		return
 	}
 
-	if term1, err = PointUnmarshal(p.Term1); err != nil {
+	if term1, err = c.pointUnmarshal(p.Term1); err != nil {
 		return
 	}
 
-	if term2, err = PointUnmarshal(p.Term2); err != nil {
+	if term2, err = c.pointUnmarshal(p.Term2); err != nil {
 		return
 	}
 
-	if term3, err = PointUnmarshal(p.Term3); err != nil {
+	if term3, err = c.pointUnmarshal(p.Term3); err != nil {
 		return
 	}
 
-	if term4, err = PointUnmarshal(p.Term4); err != nil {
+	if term4, err = c.pointUnmarshal(p.Term4); err != nil {
 		return
 	}
 
-	if len(p.BlindA) == 0 || len(p.BlindA) > 32 {
+	if blindA, err = c.parseScalar(p.BlindA); err != nil {
 		err = errors.New("invalid proof")
 		return
 	}
 
-	if len(p.BlindB) == 0 || len(p.BlindB) > 32 {
+	if blindB, err = c.parseScalar(p.BlindB); err != nil {
 		err = errors.New("invalid proof")
 		return
 	}
 
-	blindA = new(big.Int).SetBytes(p.BlindA)
-	blindB = new(big.Int).SetBytes(p.BlindB)
-
 	return
 }
 
@@ -154,21 +153,28 @@ func (p *ProofOfFail) parse() (term1, term2, term3, term4 *Point, blindA, blindB
 type UpdateToken struct {
 	A []byte `json:"a"`
 	B []byte `json:"b"`
+	// FromVersion and ToVersion are the key versions the token rotates from and to, 0 if unset
+	FromVersion uint32 `json:"from_version,omitempty"`
+	ToVersion   uint32 `json:"to_version,omitempty"`
+	// FromKey and ToKey are Fingerprint of server public keys the token rotates from and to, empty if unset
+	FromKey string `json:"from_key,omitempty"`
+	ToKey   string `json:"to_key,omitempty"`
+	// IssuedAt and ExpiresAt are Unix times, 0 if unset. Expired tokens are refused
+	IssuedAt  int64 `json:"issued_at,omitempty"`
+	ExpiresAt int64 `json:"expires_at,omitempty"`
 }
 
-func (t *UpdateToken) parse() (a, b *big.Int, err error) {
+func (t *UpdateToken) parse(c *Curve) (a, b *big.Int, err error) {
 	if t == nil {
 		return nil, nil, errors.New("invalid token")
 	}
-	if len(t.A) == 0 || len(t.A) > 32 {
+	// a = 0 would turn every key and record into a function of b alone
+	if a, err = c.parseScalar(t.A); err != nil || a.Sign() == 0 {
 		return nil, nil, errors.New("invalid update token")
 	}
-	if len(t.B) == 0 || len(t.B) > 32 {
+	if b, err = c.parseScalar(t.B); err != nil {
 		return nil, nil, errors.New("invalid update token")
 	}
-
-	a = new(big.Int).SetBytes(t.A)
-	b = new(big.Int).SetBytes(t.B)
 	return
 }
 
@@ -178,12 +184,20 @@ type EnrollmentResponse struct {
 	C0    []byte          `json:"c_0"`
 	C1    []byte          `json:"c_1"`
 	Proof *ProofOfSuccess `json:"proof"`
+	// KeyVersion is the version of the server key, 0 if the server isn't versioned
+	KeyVersion uint32 `json:"key_version,omitempty"`
+	// NoProof is set instead of Proof by servers made with WithoutProofs
+	NoProof bool `json:"no_proof,omitempty"`
 }
 
 // VerifyPasswordRequest contains server's nonce and an attempt to verify a password in form of an elliptic curve point
 type VerifyPasswordRequest struct {
-	NS       []byte `json:"ns"`
-	C0       []byte `json:"c_0"`
+	NS []byte `json:"ns"`
+	C0 []byte `json:"c_0"`
+	// KeyVersion is copied from the record, the server refuses requests for a version other than its own
+	KeyVersion uint32 `json:"key_version,omitempty"`
+	// Nonce dates the request for servers with replay protection, see WithRequestNonce
+	Nonce    []byte `json:"nonce,omitempty"`
 	hc0, hc1 *Point
 }
 
@@ -193,9 +207,87 @@ type VerifyPasswordResponse struct {
 	C1           []byte          `json:"c_1"`
 	ProofSuccess *ProofOfSuccess `json:"proof_success,omitempty"`
 	ProofFail    *ProofOfFail    `json:"proof_fail,omitempty"`
+	// Expired is set if the record has expired and the server is configured to flag rather than refuse such records
+	Expired bool `json:"expired,omitempty"`
+	// NoProof is set instead of a proof by servers made with WithoutProofs
+	NoProof bool `json:"no_proof,omitempty"`
 }
 
 type keypair struct {
 	PublicKey  []byte
 	PrivateKey []byte
 }
+
+// Shape checks below are independent of encoding: nonces must be present and not too long, points must have
+// an uncompressed length of a supported curve and scalars must fit the longest supported one.
+// Whether values are valid on a particular curve is checked when the message is used
+
+func (c *EnrollmentRecord) wellFormed() bool {
+	return wire.Nonce(c.NS) == nil && wire.Nonce(c.NC) == nil && plausiblePoint(c.T0) && plausiblePoint(c.T1) &&
+		(c.MAC == nil || len(c.MAC) == RecordMACSize)
+}
+
+func (p *ProofOfSuccess) wellFormed() bool {
+	return plausiblePoint(p.Term1) && plausiblePoint(p.Term2) && plausiblePoint(p.Term3) && plausibleScalar(p.BlindX)
+}
+
+func (p *ProofOfFail) wellFormed() bool {
+	return plausiblePoint(p.Term1) && plausiblePoint(p.Term2) && plausiblePoint(p.Term3) && plausiblePoint(p.Term4) &&
+		plausibleScalar(p.BlindA) && plausibleScalar(p.BlindB)
+}
+
+func (t *UpdateToken) wellFormed() bool {
+	return plausibleScalar(t.A) && plausibleScalar(t.B) && len(t.FromKey) <= 64 && len(t.ToKey) <= 64 &&
+		(t.ExpiresAt == 0 || t.ExpiresAt >= t.IssuedAt)
+}
+
+func (r *EnrollmentResponse) wellFormed() bool {
+	if r.NoProof != (r.Proof == nil) {
+		return false
+	}
+	return wire.Nonce(r.NS) == nil && plausiblePoint(r.C0) && plausiblePoint(r.C1) && (r.NoProof || r.Proof.wellFormed())
+}
+
+func (r *VerifyPasswordRequest) wellFormed() bool {
+	return wire.Nonce(r.NS) == nil && plausiblePoint(r.C0) && (r.Nonce == nil || len(r.Nonce) == RequestNonceSize)
+}
+
+// wellFormed also requires the response to carry exactly the proof its result calls for, or none if NoProof is set
+func (r *VerifyPasswordResponse) wellFormed() bool {
+	if !plausiblePoint(r.C1) {
+		return false
+	}
+	if r.NoProof {
+		return r.ProofSuccess == nil && r.ProofFail == nil
+	}
+	if r.Res {
+		return r.ProofSuccess != nil && r.ProofFail == nil && r.ProofSuccess.wellFormed()
+	}
+	return r.ProofFail != nil && r.ProofSuccess == nil && r.ProofFail.wellFormed()
+}
+
+// plausiblePoint checks the length and prefix of an uncompressed point against supported curves
+func plausiblePoint(b []byte) bool {
+	if len(b) == 0 || b[0] != 4 {
+		return false
+	}
+	for _, c := range curves {
+		if len(b) == c.pointLen {
+			return true
+		}
+	}
+	return false
+}
+
+// plausibleScalar checks a scalar isn't empty or longer than the longest supported one
+func plausibleScalar(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range curves {
+		if len(b) <= c.scalarLen {
+			return true
+		}
+	}
+	return false
+}
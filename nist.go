@@ -0,0 +1,661 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+package phe
+
+import (
+	"crypto/elliptic"
+	"math/big"
+	"math/bits"
+)
+
+// nistMaxLimbs is enough 64-bit limbs for the P-521 field
+const nistMaxLimbs = 9
+
+// nistElement is a field element in the Montgomery domain, limbs are little-endian
+type nistElement [nistMaxLimbs]uint64
+
+// nistField is arithmetic modulo the prime of a NIST curve for nistMultiScalarMult.
+// It works in variable time and must only be used for public values
+type nistField struct {
+	n    int // limbs in use
+	p    nistElement
+	pInv uint64 // -p⁻¹ mod 2⁶⁴
+	r2   nistElement
+	one  nistElement // R mod p, 1 in the Montgomery domain
+	pBig *big.Int
+}
+
+// newNISTField precomputes Montgomery constants for prime p
+func newNISTField(p *big.Int) *nistField {
+	f := &nistField{n: (p.BitLen() + 63) / 64, pBig: p}
+	f.p = f.limbs(p)
+
+	// Newton's iteration doubles correct low bits of p⁻¹ mod 2⁶⁴ each step, p is its own inverse mod 8
+	inv := f.p[0]
+	for i := 0; i < 5; i++ {
+		inv *= 2 - f.p[0]*inv
+	}
+	f.pInv = -inv
+
+	r2 := new(big.Int).Lsh(big.NewInt(1), uint(128*f.n))
+	f.r2 = f.limbs(r2.Mod(r2, p))
+	f.one = f.fromBig(big.NewInt(1))
+	return f
+}
+
+// limbs splits a non-negative integer less than 2^(64n) into limbs
+func (f *nistField) limbs(z *big.Int) (e nistElement) {
+	buf := z.FillBytes(make([]byte, 8*f.n))
+	for i := 0; i < f.n; i++ {
+		for _, b := range buf[8*(f.n-1-i) : 8*(f.n-i)] {
+			e[i] = e[i]<<8 | uint64(b)
+		}
+	}
+	return
+}
+
+// fromBig converts an integer less than p into the Montgomery domain
+func (f *nistField) fromBig(z *big.Int) (e nistElement) {
+	e = f.limbs(z)
+	f.mul(&e, &e, &f.r2)
+	return
+}
+
+// toBig converts an element out of the Montgomery domain
+func (f *nistField) toBig(e *nistElement) *big.Int {
+	one := nistElement{1}
+	var v nistElement
+	f.mul(&v, e, &one)
+	buf := make([]byte, 8*f.n)
+	for i := 0; i < f.n; i++ {
+		for j := 0; j < 8; j++ {
+			buf[8*(f.n-i)-1-j] = byte(v[i] >> (8 * j))
+		}
+	}
+	return new(big.Int).SetBytes(buf)
+}
+
+func (f *nistField) isZero(e *nistElement) bool {
+	for i := 0; i < f.n; i++ {
+		if e[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// reduce subtracts p from a value below 2p whose top carry is hi
+func (f *nistField) reduce(z *nistElement, hi uint64) {
+	var d nistElement
+	var borrow uint64
+	for i := 0; i < f.n; i++ {
+		d[i], borrow = bits.Sub64(z[i], f.p[i], borrow)
+	}
+	if hi != 0 || borrow == 0 {
+		*z = d
+	}
+}
+
+// mul sets z = x·y·R⁻¹ with coarsely integrated operand scanning
+func (f *nistField) mul(z, x, y *nistElement) {
+	if f.n == 4 {
+		f.mul4(z, x, y)
+		return
+	}
+
+	var t [nistMaxLimbs + 2]uint64
+	n := f.n
+	for i := 0; i < n; i++ {
+		var c, carry uint64
+		for j := 0; j < n; j++ {
+			hi, lo := bits.Mul64(x[j], y[i])
+			lo, carry = bits.Add64(lo, t[j], 0)
+			hi += carry
+			t[j], carry = bits.Add64(lo, c, 0)
+			c = hi + carry
+		}
+		t[n], carry = bits.Add64(t[n], c, 0)
+		t[n+1] = carry
+
+		m := t[0] * f.pInv
+		hi, lo := bits.Mul64(m, f.p[0])
+		_, carry = bits.Add64(lo, t[0], 0)
+		c = hi + carry
+		for j := 1; j < n; j++ {
+			hi, lo = bits.Mul64(m, f.p[j])
+			lo, carry = bits.Add64(lo, t[j], 0)
+			hi += carry
+			t[j-1], carry = bits.Add64(lo, c, 0)
+			c = hi + carry
+		}
+		t[n-1], carry = bits.Add64(t[n], c, 0)
+		t[n] = t[n+1] + carry
+	}
+	copy(z[:n], t[:n])
+	f.reduce(z, t[n])
+}
+
+// mul4 is mul unrolled for the 4-limb P-256 field
+func (f *nistField) mul4(z, x, y *nistElement) {
+	x0, x1, x2, x3 := x[0], x[1], x[2], x[3]
+	p0, p1, p2, p3 := f.p[0], f.p[1], f.p[2], f.p[3]
+	pInv := f.pInv
+	var t0, t1, t2, t3, t4, t5, c, hi, lo, carry, m uint64
+	yi := y[0]
+	c = 0
+	hi, lo = bits.Mul64(x0, yi)
+	lo, carry = bits.Add64(lo, t0, 0)
+	hi += carry
+	t0, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	hi, lo = bits.Mul64(x1, yi)
+	lo, carry = bits.Add64(lo, t1, 0)
+	hi += carry
+	t1, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	hi, lo = bits.Mul64(x2, yi)
+	lo, carry = bits.Add64(lo, t2, 0)
+	hi += carry
+	t2, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	hi, lo = bits.Mul64(x3, yi)
+	lo, carry = bits.Add64(lo, t3, 0)
+	hi += carry
+	t3, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	t4, carry = bits.Add64(t4, c, 0)
+	t5 = carry
+	m = t0 * pInv
+	hi, lo = bits.Mul64(m, p0)
+	_, carry = bits.Add64(lo, t0, 0)
+	c = hi + carry
+	hi, lo = bits.Mul64(m, p1)
+	lo, carry = bits.Add64(lo, t1, 0)
+	hi += carry
+	t0, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	hi, lo = bits.Mul64(m, p2)
+	lo, carry = bits.Add64(lo, t2, 0)
+	hi += carry
+	t1, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	hi, lo = bits.Mul64(m, p3)
+	lo, carry = bits.Add64(lo, t3, 0)
+	hi += carry
+	t2, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	t3, carry = bits.Add64(t4, c, 0)
+	t4 = t5 + carry
+	yi = y[1]
+	c = 0
+	hi, lo = bits.Mul64(x0, yi)
+	lo, carry = bits.Add64(lo, t0, 0)
+	hi += carry
+	t0, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	hi, lo = bits.Mul64(x1, yi)
+	lo, carry = bits.Add64(lo, t1, 0)
+	hi += carry
+	t1, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	hi, lo = bits.Mul64(x2, yi)
+	lo, carry = bits.Add64(lo, t2, 0)
+	hi += carry
+	t2, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	hi, lo = bits.Mul64(x3, yi)
+	lo, carry = bits.Add64(lo, t3, 0)
+	hi += carry
+	t3, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	t4, carry = bits.Add64(t4, c, 0)
+	t5 = carry
+	m = t0 * pInv
+	hi, lo = bits.Mul64(m, p0)
+	_, carry = bits.Add64(lo, t0, 0)
+	c = hi + carry
+	hi, lo = bits.Mul64(m, p1)
+	lo, carry = bits.Add64(lo, t1, 0)
+	hi += carry
+	t0, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	hi, lo = bits.Mul64(m, p2)
+	lo, carry = bits.Add64(lo, t2, 0)
+	hi += carry
+	t1, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	hi, lo = bits.Mul64(m, p3)
+	lo, carry = bits.Add64(lo, t3, 0)
+	hi += carry
+	t2, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	t3, carry = bits.Add64(t4, c, 0)
+	t4 = t5 + carry
+	yi = y[2]
+	c = 0
+	hi, lo = bits.Mul64(x0, yi)
+	lo, carry = bits.Add64(lo, t0, 0)
+	hi += carry
+	t0, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	hi, lo = bits.Mul64(x1, yi)
+	lo, carry = bits.Add64(lo, t1, 0)
+	hi += carry
+	t1, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	hi, lo = bits.Mul64(x2, yi)
+	lo, carry = bits.Add64(lo, t2, 0)
+	hi += carry
+	t2, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	hi, lo = bits.Mul64(x3, yi)
+	lo, carry = bits.Add64(lo, t3, 0)
+	hi += carry
+	t3, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	t4, carry = bits.Add64(t4, c, 0)
+	t5 = carry
+	m = t0 * pInv
+	hi, lo = bits.Mul64(m, p0)
+	_, carry = bits.Add64(lo, t0, 0)
+	c = hi + carry
+	hi, lo = bits.Mul64(m, p1)
+	lo, carry = bits.Add64(lo, t1, 0)
+	hi += carry
+	t0, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	hi, lo = bits.Mul64(m, p2)
+	lo, carry = bits.Add64(lo, t2, 0)
+	hi += carry
+	t1, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	hi, lo = bits.Mul64(m, p3)
+	lo, carry = bits.Add64(lo, t3, 0)
+	hi += carry
+	t2, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	t3, carry = bits.Add64(t4, c, 0)
+	t4 = t5 + carry
+	yi = y[3]
+	c = 0
+	hi, lo = bits.Mul64(x0, yi)
+	lo, carry = bits.Add64(lo, t0, 0)
+	hi += carry
+	t0, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	hi, lo = bits.Mul64(x1, yi)
+	lo, carry = bits.Add64(lo, t1, 0)
+	hi += carry
+	t1, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	hi, lo = bits.Mul64(x2, yi)
+	lo, carry = bits.Add64(lo, t2, 0)
+	hi += carry
+	t2, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	hi, lo = bits.Mul64(x3, yi)
+	lo, carry = bits.Add64(lo, t3, 0)
+	hi += carry
+	t3, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	t4, carry = bits.Add64(t4, c, 0)
+	t5 = carry
+	m = t0 * pInv
+	hi, lo = bits.Mul64(m, p0)
+	_, carry = bits.Add64(lo, t0, 0)
+	c = hi + carry
+	hi, lo = bits.Mul64(m, p1)
+	lo, carry = bits.Add64(lo, t1, 0)
+	hi += carry
+	t0, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	hi, lo = bits.Mul64(m, p2)
+	lo, carry = bits.Add64(lo, t2, 0)
+	hi += carry
+	t1, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	hi, lo = bits.Mul64(m, p3)
+	lo, carry = bits.Add64(lo, t3, 0)
+	hi += carry
+	t2, carry = bits.Add64(lo, c, 0)
+	c = hi + carry
+	t3, carry = bits.Add64(t4, c, 0)
+	t4 = t5 + carry
+	d0, b := bits.Sub64(t0, p0, 0)
+	d1, b := bits.Sub64(t1, p1, b)
+	d2, b := bits.Sub64(t2, p2, b)
+	d3, b := bits.Sub64(t3, p3, b)
+	if t4 != 0 || b == 0 {
+		t0, t1, t2, t3 = d0, d1, d2, d3
+	}
+	z[0], z[1], z[2], z[3] = t0, t1, t2, t3
+}
+
+func (f *nistField) add(z, x, y *nistElement) {
+	var carry uint64
+	for i := 0; i < f.n; i++ {
+		z[i], carry = bits.Add64(x[i], y[i], carry)
+	}
+	f.reduce(z, carry)
+}
+
+func (f *nistField) sub(z, x, y *nistElement) {
+	var borrow uint64
+	for i := 0; i < f.n; i++ {
+		z[i], borrow = bits.Sub64(x[i], y[i], borrow)
+	}
+	if borrow != 0 {
+		var carry uint64
+		for i := 0; i < f.n; i++ {
+			z[i], carry = bits.Add64(z[i], f.p[i], carry)
+		}
+	}
+}
+
+// nistPoint is a point in Jacobian coordinates, Z is zero for the point at infinity
+type nistPoint struct {
+	x, y, z nistElement
+}
+
+// pointDouble sets q = 2p on a curve with a = -3, dbl-2001-b from the Explicit-Formulas Database
+func (f *nistField) pointDouble(q, p *nistPoint) {
+	if f.isZero(&p.z) {
+		*q = *p
+		return
+	}
+
+	var delta, gamma, beta, alpha, t0, t1 nistElement
+	f.mul(&delta, &p.z, &p.z)
+	f.mul(&gamma, &p.y, &p.y)
+	f.mul(&beta, &p.x, &gamma)
+
+	f.sub(&t0, &p.x, &delta)
+	f.add(&t1, &p.x, &delta)
+	f.mul(&alpha, &t0, &t1)
+	f.add(&t0, &alpha, &alpha)
+	f.add(&alpha, &alpha, &t0)
+
+	// Z3 = (Y1+Z1)² - gamma - delta
+	f.add(&t0, &p.y, &p.z)
+	f.mul(&q.z, &t0, &t0)
+	f.sub(&q.z, &q.z, &gamma)
+	f.sub(&q.z, &q.z, &delta)
+
+	// X3 = alpha² - 8·beta
+	f.add(&beta, &beta, &beta)
+	f.add(&beta, &beta, &beta)
+	f.add(&t0, &beta, &beta)
+	f.mul(&q.x, &alpha, &alpha)
+	f.sub(&q.x, &q.x, &t0)
+
+	// Y3 = alpha·(4·beta - X3) - 8·gamma²
+	f.sub(&t0, &beta, &q.x)
+	f.mul(&t1, &gamma, &gamma)
+	f.add(&t1, &t1, &t1)
+	f.add(&t1, &t1, &t1)
+	f.add(&t1, &t1, &t1)
+	f.mul(&q.y, &alpha, &t0)
+	f.sub(&q.y, &q.y, &t1)
+}
+
+// pointAdd sets q = p1 + p2, add-2007-bl from the Explicit-Formulas Database
+func (f *nistField) pointAdd(q, p1, p2 *nistPoint) {
+	if f.isZero(&p1.z) {
+		*q = *p2
+		return
+	}
+	if f.isZero(&p2.z) {
+		*q = *p1
+		return
+	}
+
+	var z1z1, z2z2, u1, u2, s1, s2, h, i, j, r, v, t nistElement
+	f.mul(&z1z1, &p1.z, &p1.z)
+	f.mul(&z2z2, &p2.z, &p2.z)
+	f.mul(&u1, &p1.x, &z2z2)
+	f.mul(&u2, &p2.x, &z1z1)
+	f.mul(&s1, &p1.y, &p2.z)
+	f.mul(&s1, &s1, &z2z2)
+	f.mul(&s2, &p2.y, &p1.z)
+	f.mul(&s2, &s2, &z1z1)
+
+	f.sub(&h, &u2, &u1)
+	f.sub(&r, &s2, &s1)
+	if f.isZero(&h) {
+		if f.isZero(&r) {
+			f.pointDouble(q, p1)
+		} else {
+			*q = nistPoint{}
+		}
+		return
+	}
+	f.add(&r, &r, &r)
+
+	f.add(&i, &h, &h)
+	f.mul(&i, &i, &i)
+	f.mul(&j, &h, &i)
+	f.mul(&v, &u1, &i)
+
+	// Z3 = ((Z1+Z2)² - Z1Z1 - Z2Z2)·H, computed before X3 and Y3 in case q aliases an input
+	f.add(&t, &p1.z, &p2.z)
+	f.mul(&t, &t, &t)
+	f.sub(&t, &t, &z1z1)
+	f.sub(&t, &t, &z2z2)
+	f.mul(&q.z, &t, &h)
+
+	// X3 = r² - J - 2·V
+	f.mul(&q.x, &r, &r)
+	f.sub(&q.x, &q.x, &j)
+	f.sub(&q.x, &q.x, &v)
+	f.sub(&q.x, &q.x, &v)
+
+	// Y3 = r·(V - X3) - 2·S1·J
+	f.sub(&t, &v, &q.x)
+	f.mul(&t, &r, &t)
+	f.mul(&s1, &s1, &j)
+	f.add(&s1, &s1, &s1)
+	f.sub(&q.y, &t, &s1)
+}
+
+// nistAffine is a point in affine coordinates
+type nistAffine struct {
+	x, y nistElement
+}
+
+// pointAddAffine sets q = p1 + p2 where p2 isn't the point at infinity, madd-2007-bl from the Explicit-Formulas Database
+func (f *nistField) pointAddAffine(q, p1 *nistPoint, p2 *nistAffine) {
+	if f.isZero(&p1.z) {
+		q.x, q.y, q.z = p2.x, p2.y, f.one
+		return
+	}
+
+	var z1z1, u2, s2, h, hh, i, j, r, v, t nistElement
+	f.mul(&z1z1, &p1.z, &p1.z)
+	f.mul(&u2, &p2.x, &z1z1)
+	f.mul(&s2, &p2.y, &p1.z)
+	f.mul(&s2, &s2, &z1z1)
+
+	f.sub(&h, &u2, &p1.x)
+	f.sub(&r, &s2, &p1.y)
+	if f.isZero(&h) {
+		if f.isZero(&r) {
+			f.pointDouble(q, p1)
+		} else {
+			*q = nistPoint{}
+		}
+		return
+	}
+	f.add(&r, &r, &r)
+
+	f.mul(&hh, &h, &h)
+	f.add(&i, &hh, &hh)
+	f.add(&i, &i, &i)
+	f.mul(&j, &h, &i)
+	f.mul(&v, &p1.x, &i)
+
+	// Y1·J is needed after Y3 overwrites Y1 if q aliases p1
+	var y1j nistElement
+	f.mul(&y1j, &p1.y, &j)
+	f.add(&y1j, &y1j, &y1j)
+
+	// Z3 = (Z1+H)² - Z1Z1 - HH
+	f.add(&t, &p1.z, &h)
+	f.mul(&q.z, &t, &t)
+	f.sub(&q.z, &q.z, &z1z1)
+	f.sub(&q.z, &q.z, &hh)
+
+	// X3 = r² - J - 2·V
+	f.mul(&q.x, &r, &r)
+	f.sub(&q.x, &q.x, &j)
+	f.sub(&q.x, &q.x, &v)
+	f.sub(&q.x, &q.x, &v)
+
+	// Y3 = r·(V - X3) - 2·Y1·J
+	f.sub(&t, &v, &q.x)
+	f.mul(&t, &r, &t)
+	f.sub(&q.y, &t, &y1j)
+}
+
+// toAffine converts points which aren't at infinity to affine coordinates with a single inversion
+func (f *nistField) toAffine(points []nistPoint) []nistAffine {
+	// prefix[i] is the product of z of points before i
+	prefix := make([]nistElement, len(points)+1)
+	prefix[0] = f.one
+	for i := range points {
+		f.mul(&prefix[i+1], &prefix[i], &points[i].z)
+	}
+
+	inv := f.fromBig(new(big.Int).ModInverse(f.toBig(&prefix[len(points)]), f.pBig))
+
+	out := make([]nistAffine, len(points))
+	for i := len(points) - 1; i >= 0; i-- {
+		var zInv, zInv2 nistElement
+		f.mul(&zInv, &inv, &prefix[i])
+		f.mul(&inv, &inv, &points[i].z)
+
+		f.mul(&zInv2, &zInv, &zInv)
+		f.mul(&out[i].x, &points[i].x, &zInv2)
+		f.mul(&zInv2, &zInv2, &zInv)
+		f.mul(&out[i].y, &points[i].y, &zInv2)
+	}
+	return out
+}
+
+// wNAF returns the width-w non-adjacent form of k, least significant digit first. Non-zero digits are odd
+// and less than 2^(w-1) in absolute value
+func wNAF(k *big.Int, w uint) []int8 {
+	k = new(big.Int).Set(k)
+	naf := make([]int8, 0, k.BitLen()+1)
+	mod := int64(1) << w
+	for k.Sign() > 0 {
+		var d int64
+		if k.Bit(0) == 1 {
+			d = int64(k.Uint64() & uint64(mod-1))
+			if d >= mod/2 {
+				d -= mod
+			}
+			k.Sub(k, big.NewInt(d))
+		}
+		naf = append(naf, int8(d))
+		k.Rsh(k, 1)
+	}
+	return naf
+}
+
+// nistMultiScalarMult returns the msm routine of a NIST curve: Strauss' method with width-5 NAF of every scalar
+// and tables of odd multiples in affine coordinates, so doublings are shared and additions are cheaper.
+// It works in variable time and must only be used for public values
+func nistMultiScalarMult(ec elliptic.Curve) func(points []*Point, scalars []*big.Int) (x, y *big.Int) {
+	const window = 5
+
+	params := ec.Params()
+	f := newNISTField(params.P)
+
+	return func(points []*Point, scalars []*big.Int) (x, y *big.Int) {
+		const tableSize = 1 << (window - 2)
+
+		nafs := make([][]int8, len(points))
+		multiples := make([]nistPoint, 0, tableSize*len(points))
+		length := 0
+		for i, p := range points {
+			k := scalars[i]
+			if k.Sign() < 0 || k.Cmp(params.N) >= 0 {
+				k = new(big.Int).Mod(k, params.N)
+			}
+			nafs[i] = wNAF(k, window)
+			if len(nafs[i]) > length {
+				length = len(nafs[i])
+			}
+
+			// P, 3P, 5P, ... none of them is at infinity as the order is prime and large
+			var p1, p2 nistPoint
+			p1.x, p1.y, p1.z = f.fromBig(p.X), f.fromBig(p.Y), f.one
+			f.pointDouble(&p2, &p1)
+			multiples = append(multiples, p1)
+			for j := 1; j < tableSize; j++ {
+				var next nistPoint
+				f.pointAdd(&next, &multiples[len(multiples)-1], &p2)
+				multiples = append(multiples, next)
+			}
+		}
+		tables := f.toAffine(multiples)
+
+		var acc nistPoint
+		var neg nistAffine
+		for n := length - 1; n >= 0; n-- {
+			f.pointDouble(&acc, &acc)
+			for i, naf := range nafs {
+				if n >= len(naf) || naf[n] == 0 {
+					continue
+				}
+				if d := naf[n]; d > 0 {
+					f.pointAddAffine(&acc, &acc, &tables[i*tableSize+int(d)/2])
+				} else {
+					neg.x = tables[i*tableSize+int(-d)/2].x
+					f.sub(&neg.y, &nistElement{}, &tables[i*tableSize+int(-d)/2].y)
+					f.pointAddAffine(&acc, &acc, &neg)
+				}
+			}
+		}
+
+		if f.isZero(&acc.z) {
+			return new(big.Int), new(big.Int)
+		}
+
+		affine := f.toAffine([]nistPoint{acc})[0]
+		return f.toBig(&affine.x), f.toBig(&affine.y)
+	}
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"bytes"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizePassword brings a UTF-8 password to the form used for hashing when WithPasswordNormalization is set:
+// NFKC, which among others turns full-width letters and no-break or ideographic spaces into their ASCII forms,
+// and removal of leading and trailing whitespace. Spaces inside the password are kept
+func NormalizePassword(password []byte) ([]byte, error) {
+	if !utf8.Valid(password) {
+		return nil, errors.New("password is not valid UTF-8")
+	}
+
+	normalized := bytes.TrimFunc(norm.NFKC.Bytes(password), unicode.IsSpace)
+	if len(normalized) == 0 && len(password) > 0 {
+		return nil, errors.New("password is empty after normalization")
+	}
+	return normalized, nil
+}
+
+// WithPasswordNormalization makes the client normalize passwords with NormalizePassword before they are hashed,
+// so the same password typed with a different keyboard or input method is accepted. Passwords which aren't UTF-8
+// are rejected. It applies to enrollment, verification and fallback verifiers, records enrolled without it
+// keep working only for passwords which don't change under normalization
+func WithPasswordNormalization() ClientOption {
+	return func(c *Client) {
+		c.normalize = true
+	}
+}
+
+// normalizePassword normalizes the password if the client is configured to
+func (c *Client) normalizePassword(password []byte) ([]byte, error) {
+	if !c.normalize {
+		return password, nil
+	}
+	return NormalizePassword(password)
+}
@@ -0,0 +1,74 @@
+package phe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizePassword(t *testing.T) {
+	for in, out := range map[string]string{
+		"password":                 "password",
+		"\uff50\uff41\uff53\uff53": "pass",
+		"  pass word\t\n":          "pass word",
+		"pass\u00a0word":           "pass word",
+		"\u3000pass\u3000":         "pass",
+		"e\u0301te\u0301":          "\u00e9t\u00e9",
+		"\ufb01le":                 "file",
+		"\u216b":                   "XII",
+		"":                         "",
+	} {
+		normalized, err := NormalizePassword([]byte(in))
+		assert.NoError(t, err, in)
+		assert.Equal(t, out, string(normalized), in)
+	}
+
+	_, err := NormalizePassword([]byte{0xff, 0xfe})
+	assert.Error(t, err)
+	_, err = NormalizePassword([]byte(" \t "))
+	assert.Error(t, err)
+}
+
+func TestWithPasswordNormalization(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	c, err := NewClient(GenerateClientKey(), mustPublicKey(t, serverKeypair), WithPasswordNormalization())
+	assert.NoError(t, err)
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+
+	// composed é typed on one keyboard, decomposed on another
+	rec, key, err := c.EnrollAccount([]byte("caf\u00e9 au lait"), enrollment)
+	assert.NoError(t, err)
+
+	for _, password := range []string{"caf\u00e9 au lait", "cafe\u0301 au lait", " caf\u00e9\u00a0au lait "} {
+		req, err := c.CreateVerifyPasswordRequest([]byte(password), rec)
+		assert.NoError(t, err)
+		resp, err := VerifyPassword(serverKeypair, req)
+		assert.NoError(t, err)
+		decrypted, err := c.CheckResponseAndDecrypt([]byte(password), rec, resp)
+		assert.NoError(t, err)
+		assert.Equal(t, key, decrypted, password)
+	}
+
+	_, err = c.CreateVerifyPasswordRequest([]byte{0xff}, rec)
+	assert.Error(t, err)
+
+	policy := FallbackPolicy{Lifetime: time.Hour, Time: 1, Memory: 64, Threads: 1}
+	v, err := c.NewFallbackVerifier([]byte("caf\u00e9 au lait"), rec, key, policy)
+	assert.NoError(t, err)
+	decrypted, err := c.CheckFallbackVerifier([]byte("cafe\u0301 au lait "), rec, v, policy)
+	assert.NoError(t, err)
+	assert.Equal(t, key, decrypted)
+
+	// without normalization the bytes must match exactly
+	plain, err := NewClient(GenerateClientKey(), mustPublicKey(t, serverKeypair))
+	assert.NoError(t, err)
+	rec, _, err = plain.EnrollAccount([]byte("caf\u00e9"), enrollment)
+	assert.NoError(t, err)
+	req, err := plain.CreateVerifyPasswordRequest([]byte("cafe\u0301"), rec)
+	assert.NoError(t, err)
+	resp, err := VerifyPassword(serverKeypair, req)
+	assert.NoError(t, err)
+	assert.False(t, resp.Res)
+}
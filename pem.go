@@ -0,0 +1,285 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"encoding/asn1"
+	"encoding/pem"
+
+	"github.com/pkg/errors"
+)
+
+// Standard encodings of server keys: the private key as PKCS #8 (RFC 5208) wrapping SEC 1 ECPrivateKey (RFC 5915)
+// and the public key as SubjectPublicKeyInfo (RFC 5480). NIST curve keys are interchangeable with crypto/x509,
+// secp256k1 keys use the same structures with its SEC 2 identifier
+
+const (
+	pemPrivateKey   = "PRIVATE KEY"
+	pemECPrivateKey = "EC PRIVATE KEY"
+	pemPublicKey    = "PUBLIC KEY"
+)
+
+var oidECPublicKey = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+
+type ecPrivateKey struct {
+	Version       int
+	PrivateKey    []byte
+	NamedCurveOID asn1.ObjectIdentifier `asn1:"optional,explicit,tag:0"`
+	PublicKey     asn1.BitString        `asn1:"optional,explicit,tag:1"`
+}
+
+type pkixAlgorithm struct {
+	Algorithm  asn1.ObjectIdentifier
+	NamedCurve asn1.ObjectIdentifier
+}
+
+type pkcs8 struct {
+	Version    int
+	Algorithm  pkixAlgorithm
+	PrivateKey []byte
+}
+
+type subjectPublicKeyInfo struct {
+	Algorithm pkixAlgorithm
+	PublicKey asn1.BitString
+}
+
+// MarshalKeypairSEC1 encodes the private key of a server keypair as SEC 1 ECPrivateKey
+func MarshalKeypairSEC1(serverKeypair []byte) ([]byte, error) {
+	s, err := NewServer(serverKeypair)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSEC1(s, true)
+}
+
+func marshalSEC1(s *Server, withCurve bool) ([]byte, error) {
+	oid, ok := curveOIDs[s.curve]
+	if !ok {
+		return nil, errors.New("unsupported curve")
+	}
+
+	key := ecPrivateKey{
+		Version:    1,
+		PrivateKey: s.priv,
+		PublicKey:  asn1.BitString{Bytes: s.kp.PublicKey, BitLength: 8 * len(s.kp.PublicKey)},
+	}
+	if withCurve {
+		key.NamedCurveOID = oid
+	}
+	return asn1.Marshal(key)
+}
+
+// UnmarshalKeypairSEC1 decodes a SEC 1 ECPrivateKey into a server keypair
+func UnmarshalKeypairSEC1(der []byte) ([]byte, error) {
+	return unmarshalSEC1(der, nil)
+}
+
+// unmarshalSEC1 decodes ECPrivateKey, curve is taken from the key unless it's given by the enclosing structure
+func unmarshalSEC1(der []byte, curve *Curve) ([]byte, error) {
+	var key ecPrivateKey
+	if err := unmarshalDER(der, &key); err != nil {
+		return nil, errors.Wrap(err, "invalid private key")
+	}
+	if key.Version != 1 {
+		return nil, errors.New("unsupported private key version")
+	}
+
+	if len(key.NamedCurveOID) > 0 {
+		keyCurve, err := curveByOID(key.NamedCurveOID)
+		if err != nil {
+			return nil, err
+		}
+		if curve != nil && curve != keyCurve {
+			return nil, errors.New("private key curve mismatch")
+		}
+		curve = keyCurve
+	}
+	if curve == nil {
+		return nil, errors.New("private key curve is missing")
+	}
+
+	if len(key.PrivateKey) != curve.scalarLen {
+		return nil, errors.New("invalid private key")
+	}
+	x, err := curve.parseScalar(key.PrivateKey)
+	if err != nil || x.Sign() == 0 {
+		return nil, errors.New("invalid private key")
+	}
+
+	pub := curve.scalarBaseMult(x)
+	if len(key.PublicKey.Bytes) > 0 {
+		given, err := curve.pointUnmarshal(key.PublicKey.RightAlign())
+		if err != nil || !given.Equal(pub) {
+			return nil, errors.New("public key doesn't match private key")
+		}
+	}
+
+	return marshalKeypair(pub.Marshal(), key.PrivateKey)
+}
+
+// MarshalKeypairPKCS8 encodes the private key of a server keypair as PKCS #8 PrivateKeyInfo
+func MarshalKeypairPKCS8(serverKeypair []byte) ([]byte, error) {
+	s, err := NewServer(serverKeypair)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := marshalSEC1(s, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(pkcs8{
+		Algorithm:  pkixAlgorithm{Algorithm: oidECPublicKey, NamedCurve: curveOIDs[s.curve]},
+		PrivateKey: key,
+	})
+}
+
+// UnmarshalKeypairPKCS8 decodes a PKCS #8 PrivateKeyInfo holding an EC key into a server keypair
+func UnmarshalKeypairPKCS8(der []byte) ([]byte, error) {
+	var key pkcs8
+	if err := unmarshalDER(der, &key); err != nil {
+		return nil, errors.Wrap(err, "invalid private key")
+	}
+	if key.Version != 0 || !key.Algorithm.Algorithm.Equal(oidECPublicKey) {
+		return nil, errors.New("unsupported private key algorithm")
+	}
+
+	curve, err := curveByOID(key.Algorithm.NamedCurve)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalSEC1(key.PrivateKey, curve)
+}
+
+// MarshalPublicKeyPKIX encodes a server public key as SubjectPublicKeyInfo
+func MarshalPublicKeyPKIX(serverPublicKey []byte) ([]byte, error) {
+	pub, err := PointUnmarshal(serverPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(subjectPublicKeyInfo{
+		Algorithm: pkixAlgorithm{Algorithm: oidECPublicKey, NamedCurve: curveOIDs[pub.curve]},
+		PublicKey: asn1.BitString{Bytes: serverPublicKey, BitLength: 8 * len(serverPublicKey)},
+	})
+}
+
+// UnmarshalPublicKeyPKIX decodes a SubjectPublicKeyInfo holding an EC key into a server public key
+func UnmarshalPublicKeyPKIX(der []byte) ([]byte, error) {
+	var info subjectPublicKeyInfo
+	if err := unmarshalDER(der, &info); err != nil {
+		return nil, errors.Wrap(err, "invalid public key")
+	}
+	if !info.Algorithm.Algorithm.Equal(oidECPublicKey) {
+		return nil, errors.New("unsupported public key algorithm")
+	}
+
+	curve, err := curveByOID(info.Algorithm.NamedCurve)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := curve.pointUnmarshal(info.PublicKey.RightAlign())
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid public key")
+	}
+	return pub.Marshal(), nil
+}
+
+// MarshalKeypairPEM encodes the private key of a server keypair as a PKCS #8 "PRIVATE KEY" PEM block
+func MarshalKeypairPEM(serverKeypair []byte) ([]byte, error) {
+	der, err := MarshalKeypairPKCS8(serverKeypair)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemPrivateKey, Bytes: der}), nil
+}
+
+// UnmarshalKeypairPEM decodes a "PRIVATE KEY" or an "EC PRIVATE KEY" PEM block into a server keypair.
+// The data must hold a single block
+func UnmarshalKeypairPEM(data []byte) ([]byte, error) {
+	block, err := decodePEM(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch block.Type {
+	case pemPrivateKey:
+		return UnmarshalKeypairPKCS8(block.Bytes)
+	case pemECPrivateKey:
+		return UnmarshalKeypairSEC1(block.Bytes)
+	}
+	return nil, errors.Errorf("unexpected PEM block %q", block.Type)
+}
+
+// MarshalPublicKeyPEM encodes a server public key as a "PUBLIC KEY" PEM block
+func MarshalPublicKeyPEM(serverPublicKey []byte) ([]byte, error) {
+	der, err := MarshalPublicKeyPKIX(serverPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemPublicKey, Bytes: der}), nil
+}
+
+// UnmarshalPublicKeyPEM decodes a "PUBLIC KEY" PEM block into a server public key
+func UnmarshalPublicKeyPEM(data []byte) ([]byte, error) {
+	block, err := decodePEM(data)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type != pemPublicKey {
+		return nil, errors.Errorf("unexpected PEM block %q", block.Type)
+	}
+	return UnmarshalPublicKeyPKIX(block.Bytes)
+}
+
+// decodePEM decodes exactly one unencrypted PEM block
+func decodePEM(data []byte) (*pem.Block, error) {
+	block, rest := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if next, _ := pem.Decode(rest); next != nil {
+		return nil, errors.New("more than one PEM block")
+	}
+	if len(block.Headers) != 0 {
+		return nil, errors.New("encrypted or annotated PEM blocks are not supported")
+	}
+	return block, nil
+}
@@ -0,0 +1,87 @@
+package phe
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPEM_RoundTrip(t *testing.T) {
+	for _, curve := range curves {
+		serverKeypair, err := GenerateServerKeypairForCurve(curve)
+		assert.NoError(t, err)
+		pub := mustPublicKey(t, serverKeypair)
+
+		data, err := MarshalKeypairPEM(serverKeypair)
+		assert.NoError(t, err)
+		kp, err := UnmarshalKeypairPEM(data)
+		assert.NoError(t, err)
+		assert.Equal(t, serverKeypair, kp)
+
+		der, err := MarshalKeypairSEC1(serverKeypair)
+		assert.NoError(t, err)
+		kp, err = UnmarshalKeypairSEC1(der)
+		assert.NoError(t, err)
+		assert.Equal(t, serverKeypair, kp)
+
+		data, err = MarshalPublicKeyPEM(pub)
+		assert.NoError(t, err)
+		decoded, err := UnmarshalPublicKeyPEM(data)
+		assert.NoError(t, err)
+		assert.Equal(t, pub, decoded)
+	}
+}
+
+func TestPEM_X509(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	s, err := NewServer(serverKeypair)
+	assert.NoError(t, err)
+
+	der, err := MarshalKeypairPKCS8(serverKeypair)
+	assert.NoError(t, err)
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	assert.NoError(t, err)
+	assert.Equal(t, s.Public(), &key.(*ecdsa.PrivateKey).PublicKey)
+
+	der, err = MarshalKeypairSEC1(serverKeypair)
+	assert.NoError(t, err)
+	_, err = x509.ParseECPrivateKey(der)
+	assert.NoError(t, err)
+
+	der, err = MarshalPublicKeyPKIX(s.PublicKey())
+	assert.NoError(t, err)
+	pub, err := x509.ParsePKIXPublicKey(der)
+	assert.NoError(t, err)
+	assert.Equal(t, s.Public(), pub)
+
+	//keys made by standard tooling are imported
+	priv, err := ecdsa.GenerateKey(elliptic.P384(), random)
+	assert.NoError(t, err)
+	der, err = x509.MarshalPKCS8PrivateKey(priv)
+	assert.NoError(t, err)
+	kp, err := UnmarshalKeypairPKCS8(der)
+	assert.NoError(t, err)
+	imported, err := NewServer(kp)
+	assert.NoError(t, err)
+	assert.Equal(t, &priv.PublicKey, imported.Public())
+
+	der, err = x509.MarshalECPrivateKey(priv)
+	assert.NoError(t, err)
+	kp2, err := UnmarshalKeypairSEC1(der)
+	assert.NoError(t, err)
+	assert.Equal(t, kp, kp2)
+
+	_, err = UnmarshalKeypairPEM(append(mustPEM(t, serverKeypair), mustPEM(t, serverKeypair)...))
+	assert.Error(t, err)
+	_, err = UnmarshalPublicKeyPEM(mustPEM(t, serverKeypair))
+	assert.Error(t, err)
+}
+
+func mustPEM(t *testing.T, serverKeypair []byte) []byte {
+	data, err := MarshalKeypairPEM(serverKeypair)
+	assert.NoError(t, err)
+	return data
+}
@@ -1,8 +1,11 @@
 package phe
 
 import (
+	"bytes"
+	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -199,3 +202,318 @@ func BenchmarkLoginFlow(b *testing.B) {
 		assert.Equal(b, key, keyDec)
 	}
 }
+
+func TestServer_Public(t *testing.T) {
+	serverKeypair, err := GenerateServerKeypair()
+	assert.NoError(t, err)
+	s, err := NewServer(serverKeypair)
+	assert.NoError(t, err)
+
+	pub, ok := s.Public().(*ecdsa.PublicKey)
+	assert.True(t, ok)
+	assert.Equal(t, s.PublicKey(), elliptic.Marshal(pub.Curve, pub.X, pub.Y))
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	aead, err := NewAEAD(key)
+	assert.NoError(t, err)
+	nonce := make([]byte, aead.NonceSize())
+	ct := aead.Seal(nil, nonce, pwd, nil)
+	pt, err := aead.Open(nil, nonce, ct, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, pwd, pt)
+}
+
+func Test_PHE_P384(t *testing.T) {
+	testCurveFlow(t, P384())
+}
+
+func testCurveFlow(t *testing.T, curve *Curve) {
+	serverKeypair, err := GenerateServerKeypairForCurve(curve)
+	assert.NoError(t, err)
+	pub, err := GetPublicKey(serverKeypair)
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKeyForCurve(curve), pub)
+	assert.NoError(t, err)
+	assert.Equal(t, curve, c.curve)
+
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	rec, key, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+	assert.Len(t, key, curve.keyLen)
+
+	req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	res, err := VerifyPassword(serverKeypair, req)
+	assert.NoError(t, err)
+	keyDec, err := c.CheckResponseAndDecrypt(pwd, rec, res)
+	assert.NoError(t, err)
+	assert.Equal(t, key, keyDec)
+
+	req, err = c.CreateVerifyPasswordRequest([]byte("Password1"), rec)
+	assert.NoError(t, err)
+	res, err = VerifyPassword(serverKeypair, req)
+	assert.NoError(t, err)
+	keyDec, err = c.CheckResponseAndDecrypt([]byte("Password1"), rec, res)
+	assert.NoError(t, err)
+	assert.Nil(t, keyDec)
+
+	token, newKeypair, err := Rotate(serverKeypair)
+	assert.NoError(t, err)
+	assert.NoError(t, c.Rotate(token))
+	rec, err = UpdateRecord(rec, token)
+	assert.NoError(t, err)
+
+	req, err = c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	res, err = VerifyPassword(newKeypair, req)
+	assert.NoError(t, err)
+	keyDec, err = c.CheckResponseAndDecrypt(pwd, rec, res)
+	assert.NoError(t, err)
+	assert.Equal(t, key, keyDec)
+
+	//records of one curve must never be accepted by a client of another curve
+	p256Pub, err := GetPublicKey(mustKeypair(t))
+	assert.NoError(t, err)
+	other, err := NewClient(GenerateClientKey(), p256Pub)
+	assert.NoError(t, err)
+	_, err = other.CreateVerifyPasswordRequest(pwd, rec)
+	assert.Error(t, err)
+}
+
+func mustKeypair(t *testing.T) []byte {
+	kp, err := GenerateServerKeypair()
+	assert.NoError(t, err)
+	return kp
+}
+
+func mustPublicKey(t *testing.T, kp []byte) []byte {
+	pub, err := GetPublicKey(kp)
+	assert.NoError(t, err)
+	return pub
+}
+
+func TestServer_Warmup(t *testing.T) {
+	for _, c := range curves {
+		serverKeypair, err := GenerateServerKeypairForCurve(c)
+		assert.NoError(t, err)
+		s, err := NewServer(serverKeypair)
+		assert.NoError(t, err)
+		assert.NoError(t, s.Warmup())
+	}
+
+	//keypair with a foreign public key must fail self-test
+	kp, err := unmarshalKeypair(mustKeypair(t))
+	assert.NoError(t, err)
+	other, err := unmarshalKeypair(mustKeypair(t))
+	assert.NoError(t, err)
+	broken, err := marshalKeypair(other.PublicKey, kp.PrivateKey)
+	assert.NoError(t, err)
+	s, err := NewServer(broken)
+	assert.NoError(t, err)
+	assert.Error(t, s.Warmup())
+}
+
+func TestClient_RotateAccountKey(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	pub, err := GetPublicKey(serverKeypair)
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), pub)
+	assert.NoError(t, err)
+
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	rec, key, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	res, err := VerifyPassword(serverKeypair, req)
+	assert.NoError(t, err)
+
+	newRec, oldKey, newKey, err := c.RotateAccountKey(pwd, rec, res)
+	assert.NoError(t, err)
+	assert.Equal(t, key, oldKey)
+	assert.NotEqual(t, key, newKey)
+	assert.Equal(t, rec.T0, newRec.T0)
+	assert.NotEqual(t, rec.T1, newRec.T1)
+
+	//the same password now unlocks the new key
+	req, err = c.CreateVerifyPasswordRequest(pwd, newRec)
+	assert.NoError(t, err)
+	res, err = VerifyPassword(serverKeypair, req)
+	assert.NoError(t, err)
+	keyDec, err := c.CheckResponseAndDecrypt(pwd, newRec, res)
+	assert.NoError(t, err)
+	assert.Equal(t, newKey, keyDec)
+
+	//wrong password can't rotate the key
+	req, err = c.CreateVerifyPasswordRequest([]byte("Password1"), rec)
+	assert.NoError(t, err)
+	res, err = VerifyPassword(serverKeypair, req)
+	assert.NoError(t, err)
+	_, _, _, err = c.RotateAccountKey([]byte("Password1"), rec, res)
+	assert.Error(t, err)
+}
+
+func TestClient_RotateNew(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	pub := mustPublicKey(t, serverKeypair)
+
+	for _, opts := range [][]ClientOption{nil, {WithProtectedKey()}} {
+		c, err := NewClient(GenerateClientKey(), pub, opts...)
+		assert.NoError(t, err)
+		enrollment, err := GetEnrollment(serverKeypair)
+		assert.NoError(t, err)
+		rec, key, err := c.EnrollAccount(pwd, enrollment)
+		assert.NoError(t, err)
+
+		token, newKeypair, err := Rotate(serverKeypair)
+		assert.NoError(t, err)
+		newPub := mustPublicKey(t, newKeypair)
+
+		_, err = c.RotateNew(token, pub)
+		assert.IsType(t, &RotationError{}, err)
+
+		rotated, err := c.RotateNew(token, newPub)
+		assert.NoError(t, err)
+		assert.Equal(t, newPub, rotated.serverPublicKeyBytes)
+		assert.Equal(t, pub, c.serverPublicKeyBytes)
+
+		// the old client keeps working with the old keys
+		req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+		assert.NoError(t, err)
+		res, err := VerifyPassword(serverKeypair, req)
+		assert.NoError(t, err)
+		keyDec, err := c.CheckResponseAndDecrypt(pwd, rec, res)
+		assert.NoError(t, err)
+		assert.Equal(t, key, keyDec)
+
+		rec, err = UpdateRecord(rec, token)
+		assert.NoError(t, err)
+		req, err = rotated.CreateVerifyPasswordRequest(pwd, rec)
+		assert.NoError(t, err)
+		res, err = VerifyPassword(newKeypair, req)
+		assert.NoError(t, err)
+		keyDec, err = rotated.CheckResponseAndDecrypt(pwd, rec, res)
+		assert.NoError(t, err)
+		assert.Equal(t, key, keyDec)
+	}
+}
+
+func TestClient_EnrollAccountWithKey(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	c, err := NewClient(GenerateClientKey(), mustPublicKey(t, serverKeypair))
+	assert.NoError(t, err)
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	rec, key, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	req, err := c.CreateVerifyPasswordRequest([]byte("Password1"), rec)
+	assert.NoError(t, err)
+	res, err := VerifyPassword(serverKeypair, req)
+	assert.NoError(t, err)
+	secret, err := c.RecoverAccountSecret([]byte("Password1"), rec, res)
+	assert.NoError(t, err)
+	assert.Nil(t, secret)
+
+	req, err = c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	res, err = VerifyPassword(serverKeypair, req)
+	assert.NoError(t, err)
+	secret, err = c.RecoverAccountSecret(pwd, rec, res)
+	assert.NoError(t, err)
+
+	newPwd := []byte("new password")
+	enrollment, err = GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	newRec, newKey, err := c.EnrollAccountWithKey(newPwd, enrollment, secret)
+	assert.NoError(t, err)
+	assert.Equal(t, key, newKey)
+	assert.NotEqual(t, rec.NC, newRec.NC)
+
+	req, err = c.CreateVerifyPasswordRequest(newPwd, newRec)
+	assert.NoError(t, err)
+	res, err = VerifyPassword(serverKeypair, req)
+	assert.NoError(t, err)
+	keyDec, err := c.CheckResponseAndDecrypt(newPwd, newRec, res)
+	assert.NoError(t, err)
+	assert.Equal(t, key, keyDec)
+
+	_, _, err = c.EnrollAccountWithKey(newPwd, enrollment, secret[1:])
+	assert.Error(t, err)
+}
+
+func TestClient_ChangePassword(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	s, err := NewServer(serverKeypair)
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), s.PublicKey(), WithPasswordPolicy(MinLength(8)))
+	assert.NoError(t, err)
+
+	enrollment, err := s.GetEnrollment()
+	assert.NoError(t, err)
+	rec, key, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	verify := func(rec *EnrollmentRecord, password []byte) *VerifyPasswordResponse {
+		req, err := c.CreateVerifyPasswordRequest(password, rec)
+		assert.NoError(t, err)
+		resp, err := s.VerifyPassword(req)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	newPwd := []byte("correct horse battery staple")
+	for _, enroll := range []bool{false, true} {
+		var enrollment *EnrollmentResponse
+		if enroll {
+			enrollment, err = s.GetEnrollment()
+			assert.NoError(t, err)
+		}
+		newRec, newKey, err := c.ChangePassword(pwd, rec, verify(rec, pwd), newPwd, enrollment)
+		assert.NoError(t, err)
+		assert.Equal(t, key, newKey)
+		assert.Equal(t, !enroll, bytes.Equal(rec.NS, newRec.NS))
+
+		keyDec, err := c.CheckResponseAndDecrypt(newPwd, newRec, verify(newRec, newPwd))
+		assert.NoError(t, err)
+		assert.Equal(t, key, keyDec)
+		keyDec, err = c.CheckResponseAndDecrypt(pwd, newRec, verify(newRec, pwd))
+		assert.NoError(t, err)
+		assert.Nil(t, keyDec)
+	}
+
+	_, _, err = c.ChangePassword(newPwd, rec, verify(rec, newPwd), newPwd, nil)
+	assert.Error(t, err)
+	_, _, err = c.ChangePassword(pwd, rec, verify(rec, pwd), []byte("short"), nil)
+	assert.ErrorIs(t, err, ErrPasswordPolicy)
+}
+
+func TestVerifyRotation(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	pub := mustPublicKey(t, serverKeypair)
+	c, err := NewClient(GenerateClientKey(), pub)
+	assert.NoError(t, err)
+
+	token, newKeypair, err := Rotate(serverKeypair)
+	assert.NoError(t, err)
+	newPub := mustPublicKey(t, newKeypair)
+	assert.NoError(t, VerifyRotation(pub, newPub, token))
+	assert.NoError(t, c.VerifyRotation(newPub, token))
+
+	//a key the token doesn't lead to is rejected and the client keeps its keys
+	otherPub := mustPublicKey(t, mustKeypair(t))
+	err = VerifyRotation(pub, otherPub, token)
+	assert.True(t, errors.Is(err, ErrRotationMismatch))
+	var rotationErr *RotationError
+	assert.True(t, errors.As(c.RotateChecked(token, otherPub), &rotationErr))
+	assert.Equal(t, otherPub, rotationErr.NewPublicKey)
+	assert.Equal(t, pub, c.serverPublicKeyBytes)
+	assert.Error(t, c.RotateChecked(token, nil))
+
+	assert.NoError(t, c.RotateChecked(token, newPub))
+	assert.Equal(t, newPub, c.serverPublicKeyBytes)
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"crypto/cipher"
+
+	"github.com/passw0rd/phe-go/internal/gcmsiv"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// PheCipherSuite is the first byte of a PheCipher ciphertext and identifies how it was sealed
+type PheCipherSuite byte
+
+const (
+	// PheCipherAES256GCM seals with AES-256-GCM under a random 96 bit nonce. It's the default
+	PheCipherAES256GCM PheCipherSuite = 1
+	// PheCipherXChaCha20Poly1305 seals with XChaCha20-Poly1305 under a random 192 bit nonce.
+	// It's faster without AES hardware and random nonces don't collide however many messages a key seals
+	PheCipherXChaCha20Poly1305 PheCipherSuite = 2
+	// PheCipherAES256GCMSIV seals with AES-256-GCM-SIV of RFC 8452 under a random 96 bit nonce.
+	// A repeated nonce only reveals that the same message was sealed twice, so it suits many writers
+	// sharing a key which can't coordinate nonces. Sealing reads the message twice and is slower than GCM
+	PheCipherAES256GCMSIV PheCipherSuite = 3
+)
+
+var pheCipherInfo = map[PheCipherSuite][]byte{
+	PheCipherAES256GCM:         []byte("PheCipher"),
+	PheCipherXChaCha20Poly1305: []byte("PheCipherXChaCha20Poly1305"),
+	PheCipherAES256GCMSIV:      []byte("PheCipherAES256GCMSIV"),
+}
+
+// PheCipher encrypts user's data with the account key returned by EnrollAccount or CheckResponseAndDecrypt.
+// Ciphertexts start with the suite byte and a random nonce, so callers don't choose nonces themselves.
+// An AES-256-GCM key should seal no more than 2^32 messages
+type PheCipher struct {
+	suite PheCipherSuite
+	keys  map[PheCipherSuite][]byte
+	aeads map[PheCipherSuite]cipher.AEAD
+}
+
+// PheCipherOption configures optional PheCipher behavior
+type PheCipherOption func(*PheCipher)
+
+// WithCipherSuite makes Encrypt use the suite. Decrypt opens ciphertexts of any suite
+func WithCipherSuite(suite PheCipherSuite) PheCipherOption {
+	return func(c *PheCipher) {
+		c.suite = suite
+	}
+}
+
+// NewPheCipher creates a cipher with subkeys derived from the account key, one per suite
+func NewPheCipher(key []byte, opts ...PheCipherOption) (*PheCipher, error) {
+	c := &PheCipher{
+		suite: PheCipherAES256GCM,
+		keys:  make(map[PheCipherSuite][]byte, len(pheCipherInfo)),
+		aeads: make(map[PheCipherSuite]cipher.AEAD, len(pheCipherInfo)),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if _, ok := pheCipherInfo[c.suite]; !ok {
+		return nil, errors.New("unsupported cipher suite")
+	}
+
+	for suite, info := range pheCipherInfo {
+		subKey, err := deriveSubKey(key, info)
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err := newSuiteAEAD(suite, subKey)
+		if err != nil {
+			return nil, err
+		}
+		c.keys[suite] = subKey
+		c.aeads[suite] = aead
+	}
+	return c, nil
+}
+
+// newSuiteAEAD creates the AEAD of a suite with a 32 byte key
+func newSuiteAEAD(suite PheCipherSuite, key []byte) (cipher.AEAD, error) {
+	switch suite {
+	case PheCipherXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	case PheCipherAES256GCMSIV:
+		return gcmsiv.New(key)
+	default:
+		return newGCM(key)
+	}
+}
+
+// Encrypt seals plaintext. additionalData may be nil, if it isn't the same value must be passed to Decrypt
+func (c *PheCipher) Encrypt(plaintext, additionalData []byte) ([]byte, error) {
+	aead := c.aeads[c.suite]
+	headerLen := 1 + aead.NonceSize()
+
+	out := make([]byte, headerLen, headerLen+len(plaintext)+aead.Overhead())
+	out[0] = byte(c.suite)
+	random.MustRead(out[1:headerLen])
+
+	return aead.Seal(out, out[1:headerLen], plaintext, pheCipherAD(out[0], additionalData)), nil
+}
+
+// Decrypt opens a ciphertext produced by Encrypt with the same additional data
+func (c *PheCipher) Decrypt(ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) == 0 {
+		return nil, errors.New("invalid ciphertext")
+	}
+
+	aead, ok := c.aeads[PheCipherSuite(ciphertext[0])]
+	if !ok {
+		return nil, errors.New("unsupported cipher suite")
+	}
+
+	headerLen := 1 + aead.NonceSize()
+	if len(ciphertext) < headerLen+aead.Overhead() {
+		return nil, errors.New("invalid ciphertext")
+	}
+
+	plaintext, err := aead.Open(nil, ciphertext[1:headerLen], ciphertext[headerLen:], pheCipherAD(ciphertext[0], additionalData))
+	if err != nil {
+		return nil, errors.New("decryption failed")
+	}
+	return plaintext, nil
+}
+
+// pheCipherAD authenticates the suite byte together with caller's additional data
+func pheCipherAD(suite byte, additionalData []byte) []byte {
+	return append([]byte{suite}, additionalData...)
+}
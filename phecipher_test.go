@@ -0,0 +1,151 @@
+package phe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPheCipher(t *testing.T) {
+	key := make([]byte, 32)
+	random.MustRead(key)
+
+	c, err := NewPheCipher(key)
+	assert.NoError(t, err)
+
+	msg := []byte("user's data")
+	ad := []byte("user-42")
+
+	ct, err := c.Encrypt(msg, ad)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(PheCipherAES256GCM), ct[0])
+	assert.Len(t, ct, 13+len(msg)+16)
+
+	ct2, err := c.Encrypt(msg, ad)
+	assert.NoError(t, err)
+	assert.NotEqual(t, ct, ct2)
+
+	pt, err := c.Decrypt(ct, ad)
+	assert.NoError(t, err)
+	assert.Equal(t, msg, pt)
+
+	_, err = c.Decrypt(ct, nil)
+	assert.Error(t, err)
+
+	tampered := append([]byte{}, ct...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = c.Decrypt(tampered, ad)
+	assert.Error(t, err)
+
+	tampered = append([]byte{}, ct...)
+	tampered[0] = 4
+	_, err = c.Decrypt(tampered, ad)
+	assert.Error(t, err)
+
+	_, err = c.Decrypt(ct[:13+15], ad)
+	assert.Error(t, err)
+
+	ct, err = c.Encrypt(nil, nil)
+	assert.NoError(t, err)
+	pt, err = c.Decrypt(ct, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, pt)
+
+	// a ciphertext can't be opened with a NewAEAD key even under the same nonce
+	aead, err := NewAEAD(key)
+	assert.NoError(t, err)
+	_, err = aead.Open(nil, ct[1:13], ct[13:], []byte{byte(PheCipherAES256GCM)})
+	assert.Error(t, err)
+
+	_, err = NewPheCipher(key[:16])
+	assert.Error(t, err)
+}
+
+func TestPheCipher_XChaCha20Poly1305(t *testing.T) {
+	key := make([]byte, 32)
+	random.MustRead(key)
+
+	_, err := NewPheCipher(key, WithCipherSuite(4))
+	assert.Error(t, err)
+
+	x, err := NewPheCipher(key, WithCipherSuite(PheCipherXChaCha20Poly1305))
+	assert.NoError(t, err)
+	c, err := NewPheCipher(key)
+	assert.NoError(t, err)
+
+	msg := []byte("user's data")
+	ct, err := x.Encrypt(msg, []byte("ad"))
+	assert.NoError(t, err)
+	assert.Equal(t, byte(PheCipherXChaCha20Poly1305), ct[0])
+	assert.Len(t, ct, 1+24+len(msg)+16)
+
+	// any cipher created from the key opens both suites
+	for _, d := range []*PheCipher{x, c} {
+		pt, err := d.Decrypt(ct, []byte("ad"))
+		assert.NoError(t, err)
+		assert.Equal(t, msg, pt)
+	}
+
+	// the suite byte is authenticated
+	ct[0] = byte(PheCipherAES256GCM)
+	_, err = c.Decrypt(ct, []byte("ad"))
+	assert.Error(t, err)
+
+	_, err = x.Decrypt(ct[:1+24+15], []byte("ad"))
+	assert.Error(t, err)
+	_, err = x.Decrypt(nil, nil)
+	assert.Error(t, err)
+}
+
+func TestPheCipher_AES256GCMSIV(t *testing.T) {
+	key := make([]byte, 32)
+	random.MustRead(key)
+
+	s, err := NewPheCipher(key, WithCipherSuite(PheCipherAES256GCMSIV))
+	assert.NoError(t, err)
+	c, err := NewPheCipher(key)
+	assert.NoError(t, err)
+
+	msg := []byte("user's data")
+	ct, err := s.Encrypt(msg, []byte("ad"))
+	assert.NoError(t, err)
+	assert.Equal(t, byte(PheCipherAES256GCMSIV), ct[0])
+	assert.Len(t, ct, 1+12+len(msg)+16)
+
+	pt, err := c.Decrypt(ct, []byte("ad"))
+	assert.NoError(t, err)
+	assert.Equal(t, msg, pt)
+
+	// GCM-SIV and GCM keys are separate although both take a 96 bit nonce
+	ct[0] = byte(PheCipherAES256GCM)
+	_, err = c.Decrypt(ct, []byte("ad"))
+	assert.Error(t, err)
+}
+
+func TestPheCipher_AccountKey(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	c, err := NewClient(GenerateClientKey(), mustPublicKey(t, serverKeypair))
+	assert.NoError(t, err)
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	rec, key, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	enc, err := NewPheCipher(key)
+	assert.NoError(t, err)
+	ct, err := enc.Encrypt([]byte("secret"), nil)
+	assert.NoError(t, err)
+
+	req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	resp, err := VerifyPassword(serverKeypair, req)
+	assert.NoError(t, err)
+	key, err = c.CheckResponseAndDecrypt(pwd, rec, resp)
+	assert.NoError(t, err)
+
+	dec, err := NewPheCipher(key)
+	assert.NoError(t, err)
+	pt, err := dec.Decrypt(ct, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("secret"), pt)
+}
@@ -0,0 +1,164 @@
+package phecose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/passw0rd/phe-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublicKey(t *testing.T) {
+	for _, curve := range []*phe.Curve{phe.P256(), phe.P384(), phe.P521(), phe.Secp256k1()} {
+		kp, err := phe.GenerateServerKeypairForCurve(curve)
+		assert.NoError(t, err)
+		pub, err := phe.GetPublicKey(kp)
+		assert.NoError(t, err)
+
+		data, err := MarshalPublicKey(pub, []byte("key-1"))
+		assert.NoError(t, err)
+
+		var m map[int]interface{}
+		assert.NoError(t, cbor.Unmarshal(data, &m))
+		assert.EqualValues(t, ktyEC2, m[1])
+		assert.EqualValues(t, curveIDs[curve.Name()], m[-1])
+
+		got, kid, err := UnmarshalPublicKey(data)
+		assert.NoError(t, err)
+		assert.Equal(t, pub, got)
+		assert.Equal(t, []byte("key-1"), kid)
+	}
+
+	pub, err := phe.GetPublicKey(mustKeypair(t))
+	assert.NoError(t, err)
+	data, err := encMode.Marshal(&coseKey{Kty: ktyEC2, Crv: 2, X: pub[1:33], Y: pub[33:]})
+	assert.NoError(t, err)
+	_, _, err = UnmarshalPublicKey(data)
+	assert.Error(t, err)
+}
+
+func TestSign1(t *testing.T) {
+	token, _, err := phe.Rotate(mustKeypair(t))
+	assert.NoError(t, err)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	for _, signer := range []crypto.Signer{ecKey, edKey} {
+		data, err := Sign1(signer, []byte("signer"), token)
+		assert.NoError(t, err)
+
+		kid, err := KeyID(data)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("signer"), kid)
+
+		var got phe.UpdateToken
+		assert.NoError(t, Verify1(signer.Public(), data, &got))
+		assert.Equal(t, token, &got)
+
+		// wrong type
+		assert.Error(t, Verify1(signer.Public(), data, &phe.EnrollmentRecord{}))
+
+		// tampered payload
+		var m sign1
+		assert.NoError(t, unmarshalTagged(data, tagSign1, &m))
+		m.Payload[len(m.Payload)-1] ^= 1
+		tampered, err := encMode.Marshal(cbor.Tag{Number: tagSign1, Content: &m})
+		assert.NoError(t, err)
+		assert.Error(t, Verify1(signer.Public(), tampered, &got))
+	}
+
+	other, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(t, err)
+	data, err := Sign1(ecKey, nil, token)
+	assert.NoError(t, err)
+	assert.Error(t, Verify1(other.Public(), data, &phe.UpdateToken{}))
+}
+
+func TestEncrypt0(t *testing.T) {
+	enrollment, err := phe.GetEnrollment(mustKeypair(t))
+	assert.NoError(t, err)
+
+	key := make([]byte, 32)
+	_, err = rand.Read(key)
+	assert.NoError(t, err)
+
+	data, err := Encrypt0(key, []byte("k"), enrollment)
+	assert.NoError(t, err)
+
+	var got phe.EnrollmentResponse
+	assert.NoError(t, Decrypt0(key, data, &got))
+	assert.Equal(t, enrollment, &got)
+
+	key[0] ^= 1
+	assert.Error(t, Decrypt0(key, data, &got))
+	assert.Error(t, Decrypt0(key[:16], data, &got))
+	_, err = Encrypt0(key, nil, "not a message")
+	assert.Error(t, err)
+}
+
+func mustKeypair(t *testing.T) []byte {
+	kp, err := phe.GenerateServerKeypair()
+	assert.NoError(t, err)
+	return kp
+}
+
+func TestMac0(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	pub, err := phe.GetPublicKey(serverKeypair)
+	assert.NoError(t, err)
+	c, err := phe.NewClient(phe.GenerateClientKey(), pub)
+	assert.NoError(t, err)
+	enrollment, err := phe.GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccount([]byte("password"), enrollment)
+	assert.NoError(t, err)
+	req, err := c.CreateVerifyPasswordRequest([]byte("password"), rec)
+	assert.NoError(t, err)
+	resp, err := phe.VerifyPassword(serverKeypair, req)
+	assert.NoError(t, err)
+
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	assert.NoError(t, err)
+
+	for _, msg := range []interface{}{enrollment, req, resp} {
+		data, err := Mac0(secret, []byte("transport"), msg)
+		assert.NoError(t, err)
+		kid, err := KeyID(data)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("transport"), kid)
+	}
+
+	data, err := Mac0(secret, nil, req)
+	assert.NoError(t, err)
+	var got phe.VerifyPasswordRequest
+	assert.NoError(t, VerifyMac0(secret, data, &got))
+	assert.Equal(t, req.C0, got.C0)
+	assert.Equal(t, req.NS, got.NS)
+
+	// wrong type
+	assert.Error(t, VerifyMac0(secret, data, &phe.VerifyPasswordResponse{}))
+
+	// tampered payload
+	var m mac0
+	assert.NoError(t, unmarshalTagged(data, tagMac0, &m))
+	m.Payload[len(m.Payload)-1] ^= 1
+	tampered, err := encMode.Marshal(cbor.Tag{Number: tagMac0, Content: &m})
+	assert.NoError(t, err)
+	assert.Error(t, VerifyMac0(secret, tampered, &got))
+
+	other := append([]byte{}, secret...)
+	other[0] ^= 1
+	assert.Error(t, VerifyMac0(other, data, &got))
+	assert.Error(t, VerifyMac0(secret[:16], data, &got))
+	_, err = Mac0(secret[:16], nil, req)
+	assert.Error(t, err)
+}
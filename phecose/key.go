@@ -0,0 +1,128 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+// Package phecose encodes server public keys as COSE_Key and wraps protocol messages into
+// COSE_Sign1, COSE_Mac0 and COSE_Encrypt0 structures (RFC 9052) for CBOR based stacks
+package phecose
+
+import (
+	"bytes"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/passw0rd/phe-go"
+	"github.com/pkg/errors"
+)
+
+// ktyEC2 is the COSE key type of elliptic curve keys with x and y coordinates
+const ktyEC2 = 2
+
+// COSE curve identifiers of the PHE parameter sets
+var curveIDs = map[string]int{
+	phe.P256().Name():      1,
+	phe.P384().Name():      2,
+	phe.P521().Name():      3,
+	phe.Secp256k1().Name(): 8,
+}
+
+var (
+	encMode cbor.EncMode
+	decMode cbor.DecMode
+)
+
+func init() {
+	var err error
+	if encMode, err = cbor.CoreDetEncOptions().EncMode(); err != nil {
+		panic(err)
+	}
+	if decMode, err = (cbor.DecOptions{DupMapKey: cbor.DupMapKeyEnforcedAPF}).DecMode(); err != nil {
+		panic(err)
+	}
+}
+
+// coseKey is an EC2 COSE_Key. The server key is not a signing key so it carries no alg
+type coseKey struct {
+	Kty int    `cbor:"1,keyasint"`
+	Kid []byte `cbor:"2,keyasint,omitempty"`
+	Crv int    `cbor:"-1,keyasint"`
+	X   []byte `cbor:"-2,keyasint"`
+	Y   []byte `cbor:"-3,keyasint"`
+}
+
+// MarshalPublicKey encodes a marshaled server public key as a COSE_Key with an optional key ID
+func MarshalPublicKey(serverPublicKey, kid []byte) ([]byte, error) {
+	p, err := phe.PointUnmarshal(serverPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	crv, ok := curveIDs[p.Curve().Name()]
+	if !ok {
+		return nil, errors.New("curve has no COSE identifier")
+	}
+
+	coordLen := (len(serverPublicKey) - 1) / 2
+	return encMode.Marshal(&coseKey{
+		Kty: ktyEC2,
+		Kid: kid,
+		Crv: crv,
+		X:   serverPublicKey[1 : 1+coordLen],
+		Y:   serverPublicKey[1+coordLen:],
+	})
+}
+
+// UnmarshalPublicKey decodes a COSE_Key into a marshaled server public key and its key ID
+func UnmarshalPublicKey(data []byte) (serverPublicKey, kid []byte, err error) {
+	var k coseKey
+	if err = decMode.Unmarshal(data, &k); err != nil {
+		return nil, nil, errors.Wrap(err, "invalid COSE key")
+	}
+	if k.Kty != ktyEC2 || len(k.X) == 0 || len(k.X) != len(k.Y) {
+		return nil, nil, errors.New("invalid COSE key")
+	}
+
+	serverPublicKey = append(append([]byte{4}, k.X...), k.Y...)
+	p, err := phe.PointUnmarshal(serverPublicKey)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid COSE key")
+	}
+	if curveIDs[p.Curve().Name()] != k.Crv {
+		return nil, nil, errors.New("COSE key curve mismatch")
+	}
+	if !bytes.Equal(p.Marshal(), serverPublicKey) {
+		return nil, nil, errors.New("invalid COSE key")
+	}
+	return serverPublicKey, k.Kid, nil
+}
@@ -0,0 +1,491 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phecose
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"math/big"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/passw0rd/phe-go"
+	"github.com/pkg/errors"
+)
+
+// COSE tags and algorithm identifiers, RFC 9052 and RFC 9053
+const (
+	tagSign1    = 18
+	tagEncrypt0 = 16
+	tagMac0     = 17
+
+	algES256   = -7
+	algES384   = -35
+	algES512   = -36
+	algEdDSA   = -8
+	algA256GCM = 3
+	algHS256   = 5
+
+	ivLen = 12
+)
+
+// Content types put into the protected header so a message can't be accepted as another type
+const (
+	ContentTypeEnrollmentRecord       = "application/phe-enrollment-record"
+	ContentTypeEnrollmentResponse     = "application/phe-enrollment-response"
+	ContentTypeVerifyPasswordRequest  = "application/phe-verify-password-request"
+	ContentTypeVerifyPasswordResponse = "application/phe-verify-password-response"
+	ContentTypeUpdateToken            = "application/phe-update-token"
+)
+
+type protectedHeader struct {
+	Alg         int    `cbor:"1,keyasint"`
+	ContentType string `cbor:"3,keyasint"`
+}
+
+type unprotectedHeader struct {
+	Kid []byte `cbor:"4,keyasint,omitempty"`
+	IV  []byte `cbor:"5,keyasint,omitempty"`
+}
+
+type sign1 struct {
+	_           struct{} `cbor:",toarray"`
+	Protected   []byte
+	Unprotected unprotectedHeader
+	Payload     []byte
+	Signature   []byte
+}
+
+type mac0 struct {
+	_           struct{} `cbor:",toarray"`
+	Protected   []byte
+	Unprotected unprotectedHeader
+	Payload     []byte
+	Tag         []byte
+}
+
+type encrypt0 struct {
+	_           struct{} `cbor:",toarray"`
+	Protected   []byte
+	Unprotected unprotectedHeader
+	Ciphertext  []byte
+}
+
+// contentType returns the content type of a supported message
+func contentType(v interface{}) (string, error) {
+	switch v.(type) {
+	case *phe.EnrollmentRecord:
+		return ContentTypeEnrollmentRecord, nil
+	case *phe.EnrollmentResponse:
+		return ContentTypeEnrollmentResponse, nil
+	case *phe.VerifyPasswordRequest:
+		return ContentTypeVerifyPasswordRequest, nil
+	case *phe.VerifyPasswordResponse:
+		return ContentTypeVerifyPasswordResponse, nil
+	case *phe.UpdateToken:
+		return ContentTypeUpdateToken, nil
+	}
+	return "", errors.New("unsupported message type")
+}
+
+// Sign1 wraps a message into COSE_Sign1 signed with an ECDSA or Ed25519 key. The message is one of
+// *phe.EnrollmentRecord, *phe.EnrollmentResponse, *phe.VerifyPasswordRequest, *phe.VerifyPasswordResponse
+// and *phe.UpdateToken. The signing key must not be the PHE server key
+func Sign1(signer crypto.Signer, kid []byte, msg interface{}) ([]byte, error) {
+	ct, err := contentType(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	alg, hash, err := signatureAlg(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	protected, err := encMode.Marshal(&protectedHeader{Alg: alg, ContentType: ct})
+	if err != nil {
+		return nil, err
+	}
+	payload, err := encMode.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	tbs, err := sigStructure(protected, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := tbs
+	if hash != 0 {
+		h := hash.New()
+		h.Write(tbs)
+		digest = h.Sum(nil)
+	}
+
+	sig, err := signer.Sign(rand.Reader, digest, hash)
+	if err != nil {
+		return nil, err
+	}
+	if pub, ok := signer.Public().(*ecdsa.PublicKey); ok {
+		if sig, err = rawECDSASignature(pub.Curve, sig); err != nil {
+			return nil, err
+		}
+	}
+
+	return encMode.Marshal(cbor.Tag{Number: tagSign1, Content: &sign1{
+		Protected:   protected,
+		Unprotected: unprotectedHeader{Kid: kid},
+		Payload:     payload,
+		Signature:   sig,
+	}})
+}
+
+// Verify1 checks a COSE_Sign1 produced by Sign1 with the signer's public key and decodes its payload into msg,
+// which must be of the same type as the signed one
+func Verify1(pub crypto.PublicKey, data []byte, msg interface{}) error {
+	ct, err := contentType(msg)
+	if err != nil {
+		return err
+	}
+
+	alg, hash, err := signatureAlg(pub)
+	if err != nil {
+		return err
+	}
+
+	var m sign1
+	if err = unmarshalTagged(data, tagSign1, &m); err != nil {
+		return err
+	}
+	if err = checkProtected(m.Protected, alg, ct); err != nil {
+		return err
+	}
+
+	tbs, err := sigStructure(m.Protected, m.Payload)
+	if err != nil {
+		return err
+	}
+
+	valid := false
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(m.Signature) == 2*size {
+			h := hash.New()
+			h.Write(tbs)
+			r := new(big.Int).SetBytes(m.Signature[:size])
+			s := new(big.Int).SetBytes(m.Signature[size:])
+			valid = ecdsa.Verify(k, h.Sum(nil), r, s)
+		}
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(k, tbs, m.Signature)
+	}
+	if !valid {
+		return errors.New("invalid COSE signature")
+	}
+
+	return decodePayload(m.Payload, msg)
+}
+
+// Encrypt0 wraps a message supported by Sign1 into COSE_Encrypt0 encrypted with a 32-byte AES-256-GCM key
+func Encrypt0(key, kid []byte, msg interface{}) ([]byte, error) {
+	ct, err := contentType(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	protected, err := encMode.Marshal(&protectedHeader{Alg: algA256GCM, ContentType: ct})
+	if err != nil {
+		return nil, err
+	}
+	payload, err := encMode.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	aad, err := encStructure(protected)
+	if err != nil {
+		return nil, err
+	}
+
+	iv := make([]byte, ivLen)
+	if _, err = rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	return encMode.Marshal(cbor.Tag{Number: tagEncrypt0, Content: &encrypt0{
+		Protected:   protected,
+		Unprotected: unprotectedHeader{Kid: kid, IV: iv},
+		Ciphertext:  aead.Seal(nil, iv, payload, aad),
+	}})
+}
+
+// Decrypt0 opens a COSE_Encrypt0 produced by Encrypt0 and decodes its payload into msg
+func Decrypt0(key, data []byte, msg interface{}) error {
+	ct, err := contentType(msg)
+	if err != nil {
+		return err
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	var m encrypt0
+	if err = unmarshalTagged(data, tagEncrypt0, &m); err != nil {
+		return err
+	}
+	if err = checkProtected(m.Protected, algA256GCM, ct); err != nil {
+		return err
+	}
+	if len(m.Unprotected.IV) != ivLen {
+		return errors.New("invalid COSE message")
+	}
+
+	aad, err := encStructure(m.Protected)
+	if err != nil {
+		return err
+	}
+	payload, err := aead.Open(nil, m.Unprotected.IV, m.Ciphertext, aad)
+	if err != nil {
+		return errors.New("COSE decryption failed")
+	}
+	return decodePayload(payload, msg)
+}
+
+// Mac0 wraps a message supported by Sign1 into COSE_Mac0 authenticated with HMAC-SHA256 under a transport
+// secret of at least 32 bytes shared by client and server. It gives deployments without mutual TLS origin
+// authentication and tamper detection of protocol messages, not confidentiality
+func Mac0(secret, kid []byte, msg interface{}) ([]byte, error) {
+	ct, err := contentType(msg)
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) < 32 {
+		return nil, errors.New("invalid secret")
+	}
+
+	protected, err := encMode.Marshal(&protectedHeader{Alg: algHS256, ContentType: ct})
+	if err != nil {
+		return nil, err
+	}
+	payload, err := encMode.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := macTag(secret, protected, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return encMode.Marshal(cbor.Tag{Number: tagMac0, Content: &mac0{
+		Protected:   protected,
+		Unprotected: unprotectedHeader{Kid: kid},
+		Payload:     payload,
+		Tag:         tag,
+	}})
+}
+
+// VerifyMac0 checks a COSE_Mac0 produced by Mac0 with the shared secret and decodes its payload into msg,
+// which must be of the same type as the authenticated one
+func VerifyMac0(secret, data []byte, msg interface{}) error {
+	ct, err := contentType(msg)
+	if err != nil {
+		return err
+	}
+	if len(secret) < 32 {
+		return errors.New("invalid secret")
+	}
+
+	var m mac0
+	if err = unmarshalTagged(data, tagMac0, &m); err != nil {
+		return err
+	}
+	if err = checkProtected(m.Protected, algHS256, ct); err != nil {
+		return err
+	}
+
+	tag, err := macTag(secret, m.Protected, m.Payload)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(tag, m.Tag) {
+		return errors.New("invalid COSE tag")
+	}
+	return decodePayload(m.Payload, msg)
+}
+
+// KeyID returns the key ID of a COSE_Sign1, COSE_Mac0 or COSE_Encrypt0 message so the key can be looked up before verification
+func KeyID(data []byte) ([]byte, error) {
+	var raw cbor.RawTag
+	if err := decMode.Unmarshal(data, &raw); err != nil {
+		return nil, errors.Wrap(err, "invalid COSE message")
+	}
+
+	switch raw.Number {
+	case tagSign1:
+		var m sign1
+		if err := decMode.Unmarshal(raw.Content, &m); err != nil {
+			return nil, errors.Wrap(err, "invalid COSE message")
+		}
+		return m.Unprotected.Kid, nil
+	case tagMac0:
+		var m mac0
+		if err := decMode.Unmarshal(raw.Content, &m); err != nil {
+			return nil, errors.Wrap(err, "invalid COSE message")
+		}
+		return m.Unprotected.Kid, nil
+	case tagEncrypt0:
+		var m encrypt0
+		if err := decMode.Unmarshal(raw.Content, &m); err != nil {
+			return nil, errors.Wrap(err, "invalid COSE message")
+		}
+		return m.Unprotected.Kid, nil
+	}
+	return nil, errors.New("unsupported COSE message")
+}
+
+func signatureAlg(pub crypto.PublicKey) (alg int, hash crypto.Hash, err error) {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return algES256, crypto.SHA256, nil
+		case elliptic.P384():
+			return algES384, crypto.SHA384, nil
+		case elliptic.P521():
+			return algES512, crypto.SHA512, nil
+		}
+	case ed25519.PublicKey:
+		return algEdDSA, 0, nil
+	}
+	return 0, 0, errors.New("unsupported signing key")
+}
+
+// rawECDSASignature converts an ASN.1 signature returned by crypto.Signer into the fixed-size r | s COSE uses
+func rawECDSASignature(curve elliptic.Curve, der []byte) ([]byte, error) {
+	var sig struct {
+		R, S *big.Int
+	}
+	if rest, err := asn1.Unmarshal(der, &sig); err != nil || len(rest) != 0 {
+		return nil, errors.New("invalid ECDSA signature")
+	}
+
+	size := (curve.Params().BitSize + 7) / 8
+	raw := make([]byte, 2*size)
+	sig.R.FillBytes(raw[:size])
+	sig.S.FillBytes(raw[size:])
+	return raw, nil
+}
+
+func sigStructure(protected, payload []byte) ([]byte, error) {
+	return encMode.Marshal([]interface{}{"Signature1", protected, []byte{}, payload})
+}
+
+// macTag computes untruncated HMAC-SHA256 over MAC_structure, as alg 5 requires
+func macTag(secret, protected, payload []byte) ([]byte, error) {
+	tbm, err := encMode.Marshal([]interface{}{"MAC0", protected, []byte{}, payload})
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(tbm)
+	return mac.Sum(nil), nil
+}
+
+func encStructure(protected []byte) ([]byte, error) {
+	return encMode.Marshal([]interface{}{"Encrypt0", protected, []byte{}})
+}
+
+func unmarshalTagged(data []byte, tag uint64, v interface{}) error {
+	var raw cbor.RawTag
+	if err := decMode.Unmarshal(data, &raw); err != nil {
+		return errors.Wrap(err, "invalid COSE message")
+	}
+	if raw.Number != tag {
+		return errors.New("unexpected COSE message type")
+	}
+	if err := decMode.Unmarshal(raw.Content, v); err != nil {
+		return errors.Wrap(err, "invalid COSE message")
+	}
+	return nil
+}
+
+func checkProtected(protected []byte, alg int, ct string) error {
+	var h protectedHeader
+	if err := decMode.Unmarshal(protected, &h); err != nil {
+		return errors.Wrap(err, "invalid COSE header")
+	}
+	if h.Alg != alg {
+		return errors.New("unexpected COSE algorithm")
+	}
+	if h.ContentType != ct {
+		return errors.New("unexpected COSE content type")
+	}
+	return nil
+}
+
+func decodePayload(payload []byte, msg interface{}) error {
+	if err := decMode.Unmarshal(payload, msg); err != nil {
+		return errors.Wrap(err, "invalid COSE payload")
+	}
+	return nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, errors.New("invalid key")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phegrpc
+
+import (
+	"context"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/passw0rd/phe-go/phegrpc/phepb"
+	"google.golang.org/grpc"
+)
+
+// Client calls a remote PHE service and converts its messages to the types of package phe.
+// Responses still have to be checked by phe.Client, the service is not trusted
+type Client struct {
+	c phepb.PHEClient
+}
+
+// NewClient creates a client on top of a gRPC connection
+func NewClient(conn grpc.ClientConnInterface) *Client {
+	return &Client{c: phepb.NewPHEClient(conn)}
+}
+
+// PublicKey returns the marshaled server public key
+func (c *Client) PublicKey(ctx context.Context) ([]byte, error) {
+	resp, err := c.c.GetPublicKey(ctx, &phepb.GetPublicKeyRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetPublicKey(), nil
+}
+
+// GetEnrollment requests a fresh enrollment response
+func (c *Client) GetEnrollment(ctx context.Context) (*phe.EnrollmentResponse, error) {
+	resp, err := c.c.Enroll(ctx, &phepb.EnrollRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return &phe.EnrollmentResponse{
+		NS:    resp.GetNs(),
+		C0:    resp.GetC0(),
+		C1:    resp.GetC1(),
+		Proof: proofOfSuccessFromProto(resp.GetProof()),
+	}, nil
+}
+
+// VerifyPassword sends a password attempt created by phe.Client
+func (c *Client) VerifyPassword(ctx context.Context, req *phe.VerifyPasswordRequest) (*phe.VerifyPasswordResponse, error) {
+	resp, err := c.c.VerifyPassword(ctx, &phepb.VerifyPasswordRequest{
+		Ns: req.NS,
+		C0: req.C0,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &phe.VerifyPasswordResponse{
+		Res:          resp.GetRes(),
+		C1:           resp.GetC1(),
+		ProofSuccess: proofOfSuccessFromProto(resp.GetProofSuccess()),
+		ProofFail:    proofOfFailFromProto(resp.GetProofFail()),
+		Expired:      resp.GetExpired(),
+	}, nil
+}
+
+// Rotate rotates the server keypair and returns the update token
+func (c *Client) Rotate(ctx context.Context) (*phe.UpdateToken, error) {
+	resp, err := c.c.Rotate(ctx, &phepb.RotateRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return &phe.UpdateToken{
+		A: resp.GetToken().GetA(),
+		B: resp.GetToken().GetB(),
+	}, nil
+}
+
+func proofOfSuccessFromProto(p *phepb.ProofOfSuccess) *phe.ProofOfSuccess {
+	if p == nil {
+		return nil
+	}
+	return &phe.ProofOfSuccess{
+		Term1:  p.GetTerm1(),
+		Term2:  p.GetTerm2(),
+		Term3:  p.GetTerm3(),
+		BlindX: p.GetBlindX(),
+	}
+}
+
+func proofOfFailFromProto(p *phepb.ProofOfFail) *phe.ProofOfFail {
+	if p == nil {
+		return nil
+	}
+	return &phe.ProofOfFail{
+		Term1:  p.GetTerm1(),
+		Term2:  p.GetTerm2(),
+		Term3:  p.GetTerm3(),
+		Term4:  p.GetTerm4(),
+		BlindA: p.GetBlindA(),
+		BlindB: p.GetBlindB(),
+	}
+}
@@ -0,0 +1,967 @@
+//
+// Copyright (C) 2015-2018 Virgil Security Inc.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+//     (1) Redistributions of source code must retain the above copyright
+//     notice, this list of conditions and the following disclaimer.
+//
+//     (2) Redistributions in binary form must reproduce the above copyright
+//     notice, this list of conditions and the following disclaimer in
+//     the documentation and/or other materials provided with the
+//     distribution.
+//
+//     (3) Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+//
+// Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.34.2
+// 	protoc        (unknown)
+// source: phe.proto
+
+package phepb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ProofOfSuccess struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Term1  []byte `protobuf:"bytes,1,opt,name=term1,proto3" json:"term1,omitempty"`
+	Term2  []byte `protobuf:"bytes,2,opt,name=term2,proto3" json:"term2,omitempty"`
+	Term3  []byte `protobuf:"bytes,3,opt,name=term3,proto3" json:"term3,omitempty"`
+	BlindX []byte `protobuf:"bytes,4,opt,name=blind_x,json=blindX,proto3" json:"blind_x,omitempty"`
+}
+
+func (x *ProofOfSuccess) Reset() {
+	*x = ProofOfSuccess{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_phe_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ProofOfSuccess) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProofOfSuccess) ProtoMessage() {}
+
+func (x *ProofOfSuccess) ProtoReflect() protoreflect.Message {
+	mi := &file_phe_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProofOfSuccess.ProtoReflect.Descriptor instead.
+func (*ProofOfSuccess) Descriptor() ([]byte, []int) {
+	return file_phe_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ProofOfSuccess) GetTerm1() []byte {
+	if x != nil {
+		return x.Term1
+	}
+	return nil
+}
+
+func (x *ProofOfSuccess) GetTerm2() []byte {
+	if x != nil {
+		return x.Term2
+	}
+	return nil
+}
+
+func (x *ProofOfSuccess) GetTerm3() []byte {
+	if x != nil {
+		return x.Term3
+	}
+	return nil
+}
+
+func (x *ProofOfSuccess) GetBlindX() []byte {
+	if x != nil {
+		return x.BlindX
+	}
+	return nil
+}
+
+type ProofOfFail struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Term1  []byte `protobuf:"bytes,1,opt,name=term1,proto3" json:"term1,omitempty"`
+	Term2  []byte `protobuf:"bytes,2,opt,name=term2,proto3" json:"term2,omitempty"`
+	Term3  []byte `protobuf:"bytes,3,opt,name=term3,proto3" json:"term3,omitempty"`
+	Term4  []byte `protobuf:"bytes,4,opt,name=term4,proto3" json:"term4,omitempty"`
+	BlindA []byte `protobuf:"bytes,5,opt,name=blind_a,json=blindA,proto3" json:"blind_a,omitempty"`
+	BlindB []byte `protobuf:"bytes,6,opt,name=blind_b,json=blindB,proto3" json:"blind_b,omitempty"`
+}
+
+func (x *ProofOfFail) Reset() {
+	*x = ProofOfFail{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_phe_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ProofOfFail) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProofOfFail) ProtoMessage() {}
+
+func (x *ProofOfFail) ProtoReflect() protoreflect.Message {
+	mi := &file_phe_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProofOfFail.ProtoReflect.Descriptor instead.
+func (*ProofOfFail) Descriptor() ([]byte, []int) {
+	return file_phe_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ProofOfFail) GetTerm1() []byte {
+	if x != nil {
+		return x.Term1
+	}
+	return nil
+}
+
+func (x *ProofOfFail) GetTerm2() []byte {
+	if x != nil {
+		return x.Term2
+	}
+	return nil
+}
+
+func (x *ProofOfFail) GetTerm3() []byte {
+	if x != nil {
+		return x.Term3
+	}
+	return nil
+}
+
+func (x *ProofOfFail) GetTerm4() []byte {
+	if x != nil {
+		return x.Term4
+	}
+	return nil
+}
+
+func (x *ProofOfFail) GetBlindA() []byte {
+	if x != nil {
+		return x.BlindA
+	}
+	return nil
+}
+
+func (x *ProofOfFail) GetBlindB() []byte {
+	if x != nil {
+		return x.BlindB
+	}
+	return nil
+}
+
+type UpdateToken struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	A []byte `protobuf:"bytes,1,opt,name=a,proto3" json:"a,omitempty"`
+	B []byte `protobuf:"bytes,2,opt,name=b,proto3" json:"b,omitempty"`
+}
+
+func (x *UpdateToken) Reset() {
+	*x = UpdateToken{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_phe_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *UpdateToken) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateToken) ProtoMessage() {}
+
+func (x *UpdateToken) ProtoReflect() protoreflect.Message {
+	mi := &file_phe_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateToken.ProtoReflect.Descriptor instead.
+func (*UpdateToken) Descriptor() ([]byte, []int) {
+	return file_phe_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *UpdateToken) GetA() []byte {
+	if x != nil {
+		return x.A
+	}
+	return nil
+}
+
+func (x *UpdateToken) GetB() []byte {
+	if x != nil {
+		return x.B
+	}
+	return nil
+}
+
+type EnrollRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *EnrollRequest) Reset() {
+	*x = EnrollRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_phe_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *EnrollRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EnrollRequest) ProtoMessage() {}
+
+func (x *EnrollRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_phe_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EnrollRequest.ProtoReflect.Descriptor instead.
+func (*EnrollRequest) Descriptor() ([]byte, []int) {
+	return file_phe_proto_rawDescGZIP(), []int{3}
+}
+
+type EnrollResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Ns    []byte          `protobuf:"bytes,1,opt,name=ns,proto3" json:"ns,omitempty"`
+	C0    []byte          `protobuf:"bytes,2,opt,name=c0,proto3" json:"c0,omitempty"`
+	C1    []byte          `protobuf:"bytes,3,opt,name=c1,proto3" json:"c1,omitempty"`
+	Proof *ProofOfSuccess `protobuf:"bytes,4,opt,name=proof,proto3" json:"proof,omitempty"`
+}
+
+func (x *EnrollResponse) Reset() {
+	*x = EnrollResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_phe_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *EnrollResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EnrollResponse) ProtoMessage() {}
+
+func (x *EnrollResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_phe_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EnrollResponse.ProtoReflect.Descriptor instead.
+func (*EnrollResponse) Descriptor() ([]byte, []int) {
+	return file_phe_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *EnrollResponse) GetNs() []byte {
+	if x != nil {
+		return x.Ns
+	}
+	return nil
+}
+
+func (x *EnrollResponse) GetC0() []byte {
+	if x != nil {
+		return x.C0
+	}
+	return nil
+}
+
+func (x *EnrollResponse) GetC1() []byte {
+	if x != nil {
+		return x.C1
+	}
+	return nil
+}
+
+func (x *EnrollResponse) GetProof() *ProofOfSuccess {
+	if x != nil {
+		return x.Proof
+	}
+	return nil
+}
+
+type VerifyPasswordRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Ns []byte `protobuf:"bytes,1,opt,name=ns,proto3" json:"ns,omitempty"`
+	C0 []byte `protobuf:"bytes,2,opt,name=c0,proto3" json:"c0,omitempty"`
+}
+
+func (x *VerifyPasswordRequest) Reset() {
+	*x = VerifyPasswordRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_phe_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *VerifyPasswordRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VerifyPasswordRequest) ProtoMessage() {}
+
+func (x *VerifyPasswordRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_phe_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VerifyPasswordRequest.ProtoReflect.Descriptor instead.
+func (*VerifyPasswordRequest) Descriptor() ([]byte, []int) {
+	return file_phe_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *VerifyPasswordRequest) GetNs() []byte {
+	if x != nil {
+		return x.Ns
+	}
+	return nil
+}
+
+func (x *VerifyPasswordRequest) GetC0() []byte {
+	if x != nil {
+		return x.C0
+	}
+	return nil
+}
+
+type VerifyPasswordResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Res          bool            `protobuf:"varint,1,opt,name=res,proto3" json:"res,omitempty"`
+	C1           []byte          `protobuf:"bytes,2,opt,name=c1,proto3" json:"c1,omitempty"`
+	ProofSuccess *ProofOfSuccess `protobuf:"bytes,3,opt,name=proof_success,json=proofSuccess,proto3" json:"proof_success,omitempty"`
+	ProofFail    *ProofOfFail    `protobuf:"bytes,4,opt,name=proof_fail,json=proofFail,proto3" json:"proof_fail,omitempty"`
+	Expired      bool            `protobuf:"varint,5,opt,name=expired,proto3" json:"expired,omitempty"`
+}
+
+func (x *VerifyPasswordResponse) Reset() {
+	*x = VerifyPasswordResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_phe_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *VerifyPasswordResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VerifyPasswordResponse) ProtoMessage() {}
+
+func (x *VerifyPasswordResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_phe_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VerifyPasswordResponse.ProtoReflect.Descriptor instead.
+func (*VerifyPasswordResponse) Descriptor() ([]byte, []int) {
+	return file_phe_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *VerifyPasswordResponse) GetRes() bool {
+	if x != nil {
+		return x.Res
+	}
+	return false
+}
+
+func (x *VerifyPasswordResponse) GetC1() []byte {
+	if x != nil {
+		return x.C1
+	}
+	return nil
+}
+
+func (x *VerifyPasswordResponse) GetProofSuccess() *ProofOfSuccess {
+	if x != nil {
+		return x.ProofSuccess
+	}
+	return nil
+}
+
+func (x *VerifyPasswordResponse) GetProofFail() *ProofOfFail {
+	if x != nil {
+		return x.ProofFail
+	}
+	return nil
+}
+
+func (x *VerifyPasswordResponse) GetExpired() bool {
+	if x != nil {
+		return x.Expired
+	}
+	return false
+}
+
+type RotateRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *RotateRequest) Reset() {
+	*x = RotateRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_phe_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RotateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RotateRequest) ProtoMessage() {}
+
+func (x *RotateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_phe_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RotateRequest.ProtoReflect.Descriptor instead.
+func (*RotateRequest) Descriptor() ([]byte, []int) {
+	return file_phe_proto_rawDescGZIP(), []int{7}
+}
+
+type RotateResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Token *UpdateToken `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
+}
+
+func (x *RotateResponse) Reset() {
+	*x = RotateResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_phe_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RotateResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RotateResponse) ProtoMessage() {}
+
+func (x *RotateResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_phe_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RotateResponse.ProtoReflect.Descriptor instead.
+func (*RotateResponse) Descriptor() ([]byte, []int) {
+	return file_phe_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *RotateResponse) GetToken() *UpdateToken {
+	if x != nil {
+		return x.Token
+	}
+	return nil
+}
+
+type GetPublicKeyRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *GetPublicKeyRequest) Reset() {
+	*x = GetPublicKeyRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_phe_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetPublicKeyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPublicKeyRequest) ProtoMessage() {}
+
+func (x *GetPublicKeyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_phe_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPublicKeyRequest.ProtoReflect.Descriptor instead.
+func (*GetPublicKeyRequest) Descriptor() ([]byte, []int) {
+	return file_phe_proto_rawDescGZIP(), []int{9}
+}
+
+type GetPublicKeyResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	PublicKey []byte `protobuf:"bytes,1,opt,name=public_key,json=publicKey,proto3" json:"public_key,omitempty"`
+}
+
+func (x *GetPublicKeyResponse) Reset() {
+	*x = GetPublicKeyResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_phe_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetPublicKeyResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPublicKeyResponse) ProtoMessage() {}
+
+func (x *GetPublicKeyResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_phe_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPublicKeyResponse.ProtoReflect.Descriptor instead.
+func (*GetPublicKeyResponse) Descriptor() ([]byte, []int) {
+	return file_phe_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *GetPublicKeyResponse) GetPublicKey() []byte {
+	if x != nil {
+		return x.PublicKey
+	}
+	return nil
+}
+
+var File_phe_proto protoreflect.FileDescriptor
+
+var file_phe_proto_rawDesc = []byte{
+	0x0a, 0x09, 0x70, 0x68, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x06, 0x70, 0x68, 0x65,
+	0x2e, 0x76, 0x31, 0x22, 0x6b, 0x0a, 0x0e, 0x50, 0x72, 0x6f, 0x6f, 0x66, 0x4f, 0x66, 0x53, 0x75,
+	0x63, 0x63, 0x65, 0x73, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x74, 0x65, 0x72, 0x6d, 0x31, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x74, 0x65, 0x72, 0x6d, 0x31, 0x12, 0x14, 0x0a, 0x05, 0x74,
+	0x65, 0x72, 0x6d, 0x32, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x74, 0x65, 0x72, 0x6d,
+	0x32, 0x12, 0x14, 0x0a, 0x05, 0x74, 0x65, 0x72, 0x6d, 0x33, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0c,
+	0x52, 0x05, 0x74, 0x65, 0x72, 0x6d, 0x33, 0x12, 0x17, 0x0a, 0x07, 0x62, 0x6c, 0x69, 0x6e, 0x64,
+	0x5f, 0x78, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x62, 0x6c, 0x69, 0x6e, 0x64, 0x58,
+	0x22, 0x97, 0x01, 0x0a, 0x0b, 0x50, 0x72, 0x6f, 0x6f, 0x66, 0x4f, 0x66, 0x46, 0x61, 0x69, 0x6c,
+	0x12, 0x14, 0x0a, 0x05, 0x74, 0x65, 0x72, 0x6d, 0x31, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52,
+	0x05, 0x74, 0x65, 0x72, 0x6d, 0x31, 0x12, 0x14, 0x0a, 0x05, 0x74, 0x65, 0x72, 0x6d, 0x32, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x74, 0x65, 0x72, 0x6d, 0x32, 0x12, 0x14, 0x0a, 0x05,
+	0x74, 0x65, 0x72, 0x6d, 0x33, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x74, 0x65, 0x72,
+	0x6d, 0x33, 0x12, 0x14, 0x0a, 0x05, 0x74, 0x65, 0x72, 0x6d, 0x34, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x05, 0x74, 0x65, 0x72, 0x6d, 0x34, 0x12, 0x17, 0x0a, 0x07, 0x62, 0x6c, 0x69, 0x6e,
+	0x64, 0x5f, 0x61, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x62, 0x6c, 0x69, 0x6e, 0x64,
+	0x41, 0x12, 0x17, 0x0a, 0x07, 0x62, 0x6c, 0x69, 0x6e, 0x64, 0x5f, 0x62, 0x18, 0x06, 0x20, 0x01,
+	0x28, 0x0c, 0x52, 0x06, 0x62, 0x6c, 0x69, 0x6e, 0x64, 0x42, 0x22, 0x29, 0x0a, 0x0b, 0x55, 0x70,
+	0x64, 0x61, 0x74, 0x65, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x12, 0x0c, 0x0a, 0x01, 0x61, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0c, 0x52, 0x01, 0x61, 0x12, 0x0c, 0x0a, 0x01, 0x62, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x0c, 0x52, 0x01, 0x62, 0x22, 0x0f, 0x0a, 0x0d, 0x45, 0x6e, 0x72, 0x6f, 0x6c, 0x6c, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0x6e, 0x0a, 0x0e, 0x45, 0x6e, 0x72, 0x6f, 0x6c, 0x6c,
+	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x6e, 0x73, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0c, 0x52, 0x02, 0x6e, 0x73, 0x12, 0x0e, 0x0a, 0x02, 0x63, 0x30, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0c, 0x52, 0x02, 0x63, 0x30, 0x12, 0x0e, 0x0a, 0x02, 0x63, 0x31, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x0c, 0x52, 0x02, 0x63, 0x31, 0x12, 0x2c, 0x0a, 0x05, 0x70, 0x72, 0x6f, 0x6f,
+	0x66, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x16, 0x2e, 0x70, 0x68, 0x65, 0x2e, 0x76, 0x31,
+	0x2e, 0x50, 0x72, 0x6f, 0x6f, 0x66, 0x4f, 0x66, 0x53, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x52,
+	0x05, 0x70, 0x72, 0x6f, 0x6f, 0x66, 0x22, 0x37, 0x0a, 0x15, 0x56, 0x65, 0x72, 0x69, 0x66, 0x79,
+	0x50, 0x61, 0x73, 0x73, 0x77, 0x6f, 0x72, 0x64, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12,
+	0x0e, 0x0a, 0x02, 0x6e, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x02, 0x6e, 0x73, 0x12,
+	0x0e, 0x0a, 0x02, 0x63, 0x30, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x02, 0x63, 0x30, 0x22,
+	0xc5, 0x01, 0x0a, 0x16, 0x56, 0x65, 0x72, 0x69, 0x66, 0x79, 0x50, 0x61, 0x73, 0x73, 0x77, 0x6f,
+	0x72, 0x64, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x72, 0x65,
+	0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x03, 0x72, 0x65, 0x73, 0x12, 0x0e, 0x0a, 0x02,
+	0x63, 0x31, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x02, 0x63, 0x31, 0x12, 0x3b, 0x0a, 0x0d,
+	0x70, 0x72, 0x6f, 0x6f, 0x66, 0x5f, 0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x18, 0x03, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x16, 0x2e, 0x70, 0x68, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x50, 0x72, 0x6f,
+	0x6f, 0x66, 0x4f, 0x66, 0x53, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x52, 0x0c, 0x70, 0x72, 0x6f,
+	0x6f, 0x66, 0x53, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x12, 0x32, 0x0a, 0x0a, 0x70, 0x72, 0x6f,
+	0x6f, 0x66, 0x5f, 0x66, 0x61, 0x69, 0x6c, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x13, 0x2e,
+	0x70, 0x68, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x50, 0x72, 0x6f, 0x6f, 0x66, 0x4f, 0x66, 0x46, 0x61,
+	0x69, 0x6c, 0x52, 0x09, 0x70, 0x72, 0x6f, 0x6f, 0x66, 0x46, 0x61, 0x69, 0x6c, 0x12, 0x18, 0x0a,
+	0x07, 0x65, 0x78, 0x70, 0x69, 0x72, 0x65, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07,
+	0x65, 0x78, 0x70, 0x69, 0x72, 0x65, 0x64, 0x22, 0x0f, 0x0a, 0x0d, 0x52, 0x6f, 0x74, 0x61, 0x74,
+	0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0x3b, 0x0a, 0x0e, 0x52, 0x6f, 0x74, 0x61,
+	0x74, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x29, 0x0a, 0x05, 0x74, 0x6f,
+	0x6b, 0x65, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x70, 0x68, 0x65, 0x2e,
+	0x76, 0x31, 0x2e, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x52, 0x05,
+	0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x22, 0x15, 0x0a, 0x13, 0x47, 0x65, 0x74, 0x50, 0x75, 0x62, 0x6c,
+	0x69, 0x63, 0x4b, 0x65, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0x35, 0x0a, 0x14,
+	0x47, 0x65, 0x74, 0x50, 0x75, 0x62, 0x6c, 0x69, 0x63, 0x4b, 0x65, 0x79, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x63, 0x5f, 0x6b,
+	0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x63,
+	0x4b, 0x65, 0x79, 0x32, 0x93, 0x02, 0x0a, 0x03, 0x50, 0x48, 0x45, 0x12, 0x37, 0x0a, 0x06, 0x45,
+	0x6e, 0x72, 0x6f, 0x6c, 0x6c, 0x12, 0x15, 0x2e, 0x70, 0x68, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x45,
+	0x6e, 0x72, 0x6f, 0x6c, 0x6c, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x16, 0x2e, 0x70,
+	0x68, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x45, 0x6e, 0x72, 0x6f, 0x6c, 0x6c, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x12, 0x4f, 0x0a, 0x0e, 0x56, 0x65, 0x72, 0x69, 0x66, 0x79, 0x50, 0x61,
+	0x73, 0x73, 0x77, 0x6f, 0x72, 0x64, 0x12, 0x1d, 0x2e, 0x70, 0x68, 0x65, 0x2e, 0x76, 0x31, 0x2e,
+	0x56, 0x65, 0x72, 0x69, 0x66, 0x79, 0x50, 0x61, 0x73, 0x73, 0x77, 0x6f, 0x72, 0x64, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1e, 0x2e, 0x70, 0x68, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x56,
+	0x65, 0x72, 0x69, 0x66, 0x79, 0x50, 0x61, 0x73, 0x73, 0x77, 0x6f, 0x72, 0x64, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x37, 0x0a, 0x06, 0x52, 0x6f, 0x74, 0x61, 0x74, 0x65, 0x12,
+	0x15, 0x2e, 0x70, 0x68, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x52, 0x6f, 0x74, 0x61, 0x74, 0x65, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x16, 0x2e, 0x70, 0x68, 0x65, 0x2e, 0x76, 0x31, 0x2e,
+	0x52, 0x6f, 0x74, 0x61, 0x74, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x49,
+	0x0a, 0x0c, 0x47, 0x65, 0x74, 0x50, 0x75, 0x62, 0x6c, 0x69, 0x63, 0x4b, 0x65, 0x79, 0x12, 0x1b,
+	0x2e, 0x70, 0x68, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x47, 0x65, 0x74, 0x50, 0x75, 0x62, 0x6c, 0x69,
+	0x63, 0x4b, 0x65, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1c, 0x2e, 0x70, 0x68,
+	0x65, 0x2e, 0x76, 0x31, 0x2e, 0x47, 0x65, 0x74, 0x50, 0x75, 0x62, 0x6c, 0x69, 0x63, 0x4b, 0x65,
+	0x79, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x2a, 0x5a, 0x28, 0x67, 0x69, 0x74,
+	0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x70, 0x61, 0x73, 0x73, 0x77, 0x30, 0x72, 0x64,
+	0x2f, 0x70, 0x68, 0x65, 0x2d, 0x67, 0x6f, 0x2f, 0x70, 0x68, 0x65, 0x67, 0x72, 0x70, 0x63, 0x2f,
+	0x70, 0x68, 0x65, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_phe_proto_rawDescOnce sync.Once
+	file_phe_proto_rawDescData = file_phe_proto_rawDesc
+)
+
+func file_phe_proto_rawDescGZIP() []byte {
+	file_phe_proto_rawDescOnce.Do(func() {
+		file_phe_proto_rawDescData = protoimpl.X.CompressGZIP(file_phe_proto_rawDescData)
+	})
+	return file_phe_proto_rawDescData
+}
+
+var file_phe_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_phe_proto_goTypes = []any{
+	(*ProofOfSuccess)(nil),         // 0: phe.v1.ProofOfSuccess
+	(*ProofOfFail)(nil),            // 1: phe.v1.ProofOfFail
+	(*UpdateToken)(nil),            // 2: phe.v1.UpdateToken
+	(*EnrollRequest)(nil),          // 3: phe.v1.EnrollRequest
+	(*EnrollResponse)(nil),         // 4: phe.v1.EnrollResponse
+	(*VerifyPasswordRequest)(nil),  // 5: phe.v1.VerifyPasswordRequest
+	(*VerifyPasswordResponse)(nil), // 6: phe.v1.VerifyPasswordResponse
+	(*RotateRequest)(nil),          // 7: phe.v1.RotateRequest
+	(*RotateResponse)(nil),         // 8: phe.v1.RotateResponse
+	(*GetPublicKeyRequest)(nil),    // 9: phe.v1.GetPublicKeyRequest
+	(*GetPublicKeyResponse)(nil),   // 10: phe.v1.GetPublicKeyResponse
+}
+var file_phe_proto_depIdxs = []int32{
+	0,  // 0: phe.v1.EnrollResponse.proof:type_name -> phe.v1.ProofOfSuccess
+	0,  // 1: phe.v1.VerifyPasswordResponse.proof_success:type_name -> phe.v1.ProofOfSuccess
+	1,  // 2: phe.v1.VerifyPasswordResponse.proof_fail:type_name -> phe.v1.ProofOfFail
+	2,  // 3: phe.v1.RotateResponse.token:type_name -> phe.v1.UpdateToken
+	3,  // 4: phe.v1.PHE.Enroll:input_type -> phe.v1.EnrollRequest
+	5,  // 5: phe.v1.PHE.VerifyPassword:input_type -> phe.v1.VerifyPasswordRequest
+	7,  // 6: phe.v1.PHE.Rotate:input_type -> phe.v1.RotateRequest
+	9,  // 7: phe.v1.PHE.GetPublicKey:input_type -> phe.v1.GetPublicKeyRequest
+	4,  // 8: phe.v1.PHE.Enroll:output_type -> phe.v1.EnrollResponse
+	6,  // 9: phe.v1.PHE.VerifyPassword:output_type -> phe.v1.VerifyPasswordResponse
+	8,  // 10: phe.v1.PHE.Rotate:output_type -> phe.v1.RotateResponse
+	10, // 11: phe.v1.PHE.GetPublicKey:output_type -> phe.v1.GetPublicKeyResponse
+	8,  // [8:12] is the sub-list for method output_type
+	4,  // [4:8] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_phe_proto_init() }
+func file_phe_proto_init() {
+	if File_phe_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_phe_proto_msgTypes[0].Exporter = func(v any, i int) any {
+			switch v := v.(*ProofOfSuccess); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_phe_proto_msgTypes[1].Exporter = func(v any, i int) any {
+			switch v := v.(*ProofOfFail); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_phe_proto_msgTypes[2].Exporter = func(v any, i int) any {
+			switch v := v.(*UpdateToken); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_phe_proto_msgTypes[3].Exporter = func(v any, i int) any {
+			switch v := v.(*EnrollRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_phe_proto_msgTypes[4].Exporter = func(v any, i int) any {
+			switch v := v.(*EnrollResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_phe_proto_msgTypes[5].Exporter = func(v any, i int) any {
+			switch v := v.(*VerifyPasswordRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_phe_proto_msgTypes[6].Exporter = func(v any, i int) any {
+			switch v := v.(*VerifyPasswordResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_phe_proto_msgTypes[7].Exporter = func(v any, i int) any {
+			switch v := v.(*RotateRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_phe_proto_msgTypes[8].Exporter = func(v any, i int) any {
+			switch v := v.(*RotateResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_phe_proto_msgTypes[9].Exporter = func(v any, i int) any {
+			switch v := v.(*GetPublicKeyRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_phe_proto_msgTypes[10].Exporter = func(v any, i int) any {
+			switch v := v.(*GetPublicKeyResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_phe_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   11,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_phe_proto_goTypes,
+		DependencyIndexes: file_phe_proto_depIdxs,
+		MessageInfos:      file_phe_proto_msgTypes,
+	}.Build()
+	File_phe_proto = out.File
+	file_phe_proto_rawDesc = nil
+	file_phe_proto_goTypes = nil
+	file_phe_proto_depIdxs = nil
+}
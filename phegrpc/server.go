@@ -0,0 +1,189 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+// Package phegrpc serves the PHE protocol over gRPC using the service defined in phepb/phe.proto
+// and provides a client for it
+package phegrpc
+
+import (
+	"context"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/passw0rd/phe-go/internal/serverkey"
+	"github.com/passw0rd/phe-go/phegrpc/phepb"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements phepb.PHEServer backed by a server keypair
+type Server struct {
+	phepb.UnimplementedPHEServer
+
+	h        *serverkey.Holder
+	opts     []phe.ServerOption
+	onRotate func(ctx context.Context, newServerKeypair []byte) error
+}
+
+// Option configures optional Server behavior
+type Option func(*Server)
+
+// WithServerOptions passes options to every phe.Server the keypair is loaded into
+func WithServerOptions(opts ...phe.ServerOption) Option {
+	return func(s *Server) {
+		s.opts = append(s.opts, opts...)
+	}
+}
+
+// WithRotation enables the Rotate method. persist is called with the new keypair before it is used
+// and must store it durably, if it fails the old keypair stays in use. Rotate is refused without it
+// since a rotated keypair which is lost on restart makes every updated record unusable
+func WithRotation(persist func(ctx context.Context, newServerKeypair []byte) error) Option {
+	return func(s *Server) {
+		s.onRotate = persist
+	}
+}
+
+// NewServer parses the server keypair and returns a service ready to be registered
+func NewServer(serverKeypair []byte, opts ...Option) (*Server, error) {
+	s := &Server{}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	h, err := serverkey.New(serverKeypair, s.onRotate, s.opts...)
+	if err != nil {
+		return nil, err
+	}
+	s.h = h
+	return s, nil
+}
+
+// Register mounts the service on a gRPC server
+func (s *Server) Register(r grpc.ServiceRegistrar) {
+	phepb.RegisterPHEServer(r, s)
+}
+
+func (s *Server) server() *phe.Server {
+	return s.h.Server()
+}
+
+// Enroll returns a fresh enrollment response
+func (s *Server) Enroll(ctx context.Context, req *phepb.EnrollRequest) (*phepb.EnrollResponse, error) {
+	resp, err := s.server().GetEnrollment()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &phepb.EnrollResponse{
+		Ns:    resp.NS,
+		C0:    resp.C0,
+		C1:    resp.C1,
+		Proof: proofOfSuccessToProto(resp.Proof),
+	}, nil
+}
+
+// VerifyPassword checks a password attempt. Expired records are reported with FailedPrecondition,
+// any other failure is caused by the request and reported with InvalidArgument
+func (s *Server) VerifyPassword(ctx context.Context, req *phepb.VerifyPasswordRequest) (*phepb.VerifyPasswordResponse, error) {
+	resp, err := s.server().VerifyPassword(&phe.VerifyPasswordRequest{
+		NS: req.GetNs(),
+		C0: req.GetC0(),
+	})
+	if errors.Is(err, phe.ErrRecordExpired) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &phepb.VerifyPasswordResponse{
+		Res:          resp.Res,
+		C1:           resp.C1,
+		ProofSuccess: proofOfSuccessToProto(resp.ProofSuccess),
+		ProofFail:    proofOfFailToProto(resp.ProofFail),
+		Expired:      resp.Expired,
+	}, nil
+}
+
+// Rotate replaces the keypair and returns the update token, see WithRotation
+func (s *Server) Rotate(ctx context.Context, req *phepb.RotateRequest) (*phepb.RotateResponse, error) {
+	token, err := s.h.Rotate(ctx)
+	var pe *serverkey.PersistError
+	switch {
+	case errors.Is(err, serverkey.ErrRotationDisabled):
+		return nil, status.Error(codes.Unimplemented, err.Error())
+	case errors.As(err, &pe):
+		return nil, status.Error(codes.Unavailable, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &phepb.RotateResponse{
+		Token: &phepb.UpdateToken{A: token.A, B: token.B},
+	}, nil
+}
+
+// GetPublicKey returns the marshaled server public key
+func (s *Server) GetPublicKey(ctx context.Context, req *phepb.GetPublicKeyRequest) (*phepb.GetPublicKeyResponse, error) {
+	return &phepb.GetPublicKeyResponse{PublicKey: s.server().PublicKey()}, nil
+}
+
+func proofOfSuccessToProto(p *phe.ProofOfSuccess) *phepb.ProofOfSuccess {
+	if p == nil {
+		return nil
+	}
+	return &phepb.ProofOfSuccess{
+		Term1:  p.Term1,
+		Term2:  p.Term2,
+		Term3:  p.Term3,
+		BlindX: p.BlindX,
+	}
+}
+
+func proofOfFailToProto(p *phe.ProofOfFail) *phepb.ProofOfFail {
+	if p == nil {
+		return nil
+	}
+	return &phepb.ProofOfFail{
+		Term1:  p.Term1,
+		Term2:  p.Term2,
+		Term3:  p.Term3,
+		Term4:  p.Term4,
+		BlindA: p.BlindA,
+		BlindB: p.BlindB,
+	}
+}
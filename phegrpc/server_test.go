@@ -0,0 +1,111 @@
+package phegrpc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/passw0rd/phe-go/bench"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var _ bench.Backend = (*Client)(nil)
+
+func serve(t *testing.T, s *Server) *Client {
+	lis := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	s.Register(gs)
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return NewClient(conn)
+}
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	kp, err := phe.GenerateServerKeypair()
+	assert.NoError(t, err)
+
+	var persisted []byte
+	s, err := NewServer(kp, WithRotation(func(ctx context.Context, newServerKeypair []byte) error {
+		persisted = newServerKeypair
+		return nil
+	}))
+	assert.NoError(t, err)
+	remote := serve(t, s)
+
+	pub, err := remote.PublicKey(ctx)
+	assert.NoError(t, err)
+	c, err := phe.NewClient(phe.GenerateClientKey(), pub)
+	assert.NoError(t, err)
+
+	enrollment, err := remote.GetEnrollment(ctx)
+	assert.NoError(t, err)
+	rec, key, err := c.EnrollAccount([]byte("password"), enrollment)
+	assert.NoError(t, err)
+
+	for _, pwd := range []string{"password", "wrong"} {
+		req, err := c.CreateVerifyPasswordRequest([]byte(pwd), rec)
+		assert.NoError(t, err)
+		resp, err := remote.VerifyPassword(ctx, req)
+		assert.NoError(t, err)
+		got, err := c.CheckResponseAndDecrypt([]byte(pwd), rec, resp)
+		assert.NoError(t, err)
+		if pwd == "password" {
+			assert.Equal(t, key, got)
+		} else {
+			assert.Nil(t, got)
+		}
+	}
+
+	token, err := remote.Rotate(ctx)
+	assert.NoError(t, err)
+	assert.NotNil(t, persisted)
+	newPub, err := phe.GetPublicKey(persisted)
+	assert.NoError(t, err)
+	pub, err = remote.PublicKey(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, newPub, pub)
+
+	rec, err = phe.UpdateRecord(rec, token)
+	assert.NoError(t, err)
+	assert.NoError(t, c.RotateChecked(token, newPub))
+
+	req, err := c.CreateVerifyPasswordRequest([]byte("password"), rec)
+	assert.NoError(t, err)
+	resp, err := remote.VerifyPassword(ctx, req)
+	assert.NoError(t, err)
+	got, err := c.CheckResponseAndDecrypt([]byte("password"), rec, resp)
+	assert.NoError(t, err)
+	assert.Equal(t, key, got)
+}
+
+func TestServer_Errors(t *testing.T) {
+	ctx := context.Background()
+	kp, err := phe.GenerateServerKeypair()
+	assert.NoError(t, err)
+
+	_, err = NewServer([]byte("garbage"))
+	assert.Error(t, err)
+
+	s, err := NewServer(kp)
+	assert.NoError(t, err)
+	remote := serve(t, s)
+
+	_, err = remote.Rotate(ctx)
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	_, err = remote.VerifyPassword(ctx, &phe.VerifyPasswordRequest{NS: []byte("ns"), C0: []byte("not a point")})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,216 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+// Package phehttp serves the PHE protocol over HTTP with JSON encodings of the phe message structs.
+// phe.RemoteServer is the matching client
+package phehttp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/passw0rd/phe-go/internal/serverkey"
+	"github.com/pkg/errors"
+)
+
+// Paths the handler serves, relative to where it's mounted
+const (
+	PathEnroll    = "/enroll"
+	PathVerify    = "/verify"
+	PathPublicKey = "/public-key"
+	PathRotate    = "/rotate"
+)
+
+// Error codes of ErrorResponse
+const (
+	CodeInvalidRequest   = "invalid_request"
+	CodeRecordExpired    = "record_expired"
+	CodeRotationDisabled = "rotation_disabled"
+	CodeUnavailable      = "unavailable"
+	CodeInternal         = "internal"
+)
+
+// maxBodySize bounds request bodies, the largest valid request is a few hundred bytes
+const maxBodySize = 16 << 10
+
+// ErrorResponse is the body of every non-200 response
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PublicKeyResponse is the body returned from PathPublicKey
+type PublicKeyResponse struct {
+	PublicKey []byte `json:"public_key"`
+}
+
+// RotateResponse is the body returned from PathRotate
+type RotateResponse struct {
+	Token *phe.UpdateToken `json:"token"`
+}
+
+// Handler serves the protocol for a server keypair. It is an http.Handler routing by path,
+// its methods can also be mounted separately. Rotation isn't routed, see RotateHandler
+type Handler struct {
+	h        *serverkey.Holder
+	mux      *http.ServeMux
+	opts     []phe.ServerOption
+	onRotate func(ctx context.Context, newServerKeypair []byte) error
+}
+
+// Option configures optional Handler behavior
+type Option func(*Handler)
+
+// WithServerOptions passes options to every phe.Server the keypair is loaded into
+func WithServerOptions(opts ...phe.ServerOption) Option {
+	return func(h *Handler) {
+		h.opts = append(h.opts, opts...)
+	}
+}
+
+// WithRotation enables rotation. persist is called with the new keypair before it is used
+// and must store it durably, if it fails the old keypair stays in use.
+// Rotation is refused without it since a rotated keypair which is lost on restart makes every updated record unusable
+func WithRotation(persist func(ctx context.Context, newServerKeypair []byte) error) Option {
+	return func(h *Handler) {
+		h.onRotate = persist
+	}
+}
+
+// NewHandler parses the server keypair and returns a handler ready to be mounted
+func NewHandler(serverKeypair []byte, opts ...Option) (*Handler, error) {
+	h := &Handler{}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	holder, err := serverkey.New(serverKeypair, h.onRotate, h.opts...)
+	if err != nil {
+		return nil, err
+	}
+	h.h = holder
+
+	h.mux = http.NewServeMux()
+	h.mux.HandleFunc("POST "+PathEnroll, h.Enroll)
+	h.mux.HandleFunc("POST "+PathVerify, h.VerifyPassword)
+	h.mux.HandleFunc("GET "+PathPublicKey, h.PublicKey)
+	return h, nil
+}
+
+// ServeHTTP routes requests to the handler methods by the Path constants except PathRotate
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// Enroll responds with a fresh phe.EnrollmentResponse
+func (h *Handler) Enroll(w http.ResponseWriter, r *http.Request) {
+	resp, err := h.h.Server().GetEnrollment()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, err)
+		return
+	}
+	writeJSON(w, resp)
+}
+
+// VerifyPassword reads a phe.VerifyPasswordRequest and responds with a phe.VerifyPasswordResponse
+func (h *Handler) VerifyPassword(w http.ResponseWriter, r *http.Request) {
+	req := &phe.VerifyPasswordRequest{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, errors.Wrap(err, "invalid request body"))
+		return
+	}
+
+	resp, err := h.h.Server().VerifyPassword(req)
+	if errors.Is(err, phe.ErrRecordExpired) {
+		writeError(w, http.StatusForbidden, CodeRecordExpired, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err)
+		return
+	}
+	writeJSON(w, resp)
+}
+
+// PublicKey responds with PublicKeyResponse
+func (h *Handler) PublicKey(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, &PublicKeyResponse{PublicKey: h.h.Server().PublicKey()})
+}
+
+// RotateHandler returns a handler for POST requests which rotate the keypair, see Rotate. Rotation changes the keypair
+// for every route and hands out the update token, so it must not be exposed to the callers of the rest: mount it
+// separately behind admin authentication, e.g. at PathRotate of an internal listener
+func (h *Handler) RotateHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, errors.New("method not allowed"))
+			return
+		}
+		h.Rotate(w, r)
+	})
+}
+
+// Rotate rotates the keypair and responds with RotateResponse, see WithRotation and RotateHandler
+func (h *Handler) Rotate(w http.ResponseWriter, r *http.Request) {
+	token, err := h.h.Rotate(r.Context())
+	var pe *serverkey.PersistError
+	switch {
+	case errors.Is(err, serverkey.ErrRotationDisabled):
+		writeError(w, http.StatusNotFound, CodeRotationDisabled, err)
+	case errors.As(err, &pe):
+		writeError(w, http.StatusServiceUnavailable, CodeUnavailable, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, CodeInternal, err)
+	default:
+		writeJSON(w, &RotateResponse{Token: token})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code string, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&ErrorResponse{Code: code, Message: err.Error()})
+}
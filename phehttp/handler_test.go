@@ -0,0 +1,166 @@
+package phehttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func call(t *testing.T, srv *httptest.Server, method, path string, body, out interface{}) int {
+	var buf bytes.Buffer
+	if body != nil {
+		assert.NoError(t, json.NewEncoder(&buf).Encode(body))
+	}
+	req, err := http.NewRequest(method, srv.URL+path, &buf)
+	assert.NoError(t, err)
+	resp, err := srv.Client().Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+	return resp.StatusCode
+}
+
+func TestHandler(t *testing.T) {
+	kp, err := phe.GenerateServerKeypair()
+	assert.NoError(t, err)
+
+	var persisted []byte
+	h, err := NewHandler(kp, WithRotation(func(ctx context.Context, newServerKeypair []byte) error {
+		persisted = newServerKeypair
+		return nil
+	}))
+	assert.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	admin := httptest.NewServer(h.RotateHandler())
+	defer admin.Close()
+
+	// rotation isn't served next to the protocol
+	resp, err := srv.Client().Post(srv.URL+PathRotate, "application/json", nil)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Nil(t, persisted)
+
+	var pub PublicKeyResponse
+	assert.Equal(t, http.StatusOK, call(t, srv, "GET", PathPublicKey, nil, &pub))
+	c, err := phe.NewClient(phe.GenerateClientKey(), pub.PublicKey)
+	assert.NoError(t, err)
+
+	var enrollment phe.EnrollmentResponse
+	assert.Equal(t, http.StatusOK, call(t, srv, "POST", PathEnroll, nil, &enrollment))
+	rec, key, err := c.EnrollAccount([]byte("password"), &enrollment)
+	assert.NoError(t, err)
+
+	var e ErrorResponse
+	assert.Equal(t, http.StatusMethodNotAllowed, call(t, admin, "GET", PathRotate, nil, &e))
+	var rotated RotateResponse
+	assert.Equal(t, http.StatusOK, call(t, admin, "POST", PathRotate, nil, &rotated))
+	assert.NotNil(t, persisted)
+	rec, err = phe.UpdateRecord(rec, rotated.Token)
+	assert.NoError(t, err)
+	newPub, err := phe.GetPublicKey(persisted)
+	assert.NoError(t, err)
+	assert.NoError(t, c.RotateChecked(rotated.Token, newPub))
+
+	for _, pwd := range []string{"password", "wrong"} {
+		req, err := c.CreateVerifyPasswordRequest([]byte(pwd), rec)
+		assert.NoError(t, err)
+		var resp phe.VerifyPasswordResponse
+		assert.Equal(t, http.StatusOK, call(t, srv, "POST", PathVerify, req, &resp))
+		got, err := c.CheckResponseAndDecrypt([]byte(pwd), rec, &resp)
+		assert.NoError(t, err)
+		if pwd == "password" {
+			assert.Equal(t, key, got)
+		} else {
+			assert.Nil(t, got)
+		}
+	}
+}
+
+func TestHandler_Errors(t *testing.T) {
+	kp, err := phe.GenerateServerKeypair()
+	assert.NoError(t, err)
+
+	_, err = NewHandler([]byte("garbage"))
+	assert.Error(t, err)
+
+	h, err := NewHandler(kp)
+	assert.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	admin := httptest.NewServer(h.RotateHandler())
+	defer admin.Close()
+
+	var e ErrorResponse
+	assert.Equal(t, http.StatusNotFound, call(t, admin, "POST", PathRotate, nil, &e))
+	assert.Equal(t, CodeRotationDisabled, e.Code)
+
+	assert.Equal(t, http.StatusBadRequest, call(t, srv, "POST", PathVerify, map[string]string{"ns": "not base64"}, &e))
+	assert.Equal(t, CodeInvalidRequest, e.Code)
+
+	assert.Equal(t, http.StatusBadRequest, call(t, srv, "POST", PathVerify, &phe.VerifyPasswordRequest{NS: []byte("ns"), C0: []byte("c0")}, &e))
+	assert.Equal(t, CodeInvalidRequest, e.Code)
+
+	h, err = NewHandler(kp, WithRotation(func(ctx context.Context, newServerKeypair []byte) error {
+		return errors.New("disk full")
+	}))
+	assert.NoError(t, err)
+	srv2 := httptest.NewServer(h.RotateHandler())
+	defer srv2.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, call(t, srv2, "POST", PathRotate, nil, &e))
+	assert.Equal(t, CodeUnavailable, e.Code)
+}
+
+func TestHandler_RemoteServer(t *testing.T) {
+	ctx := context.Background()
+	kp, err := phe.GenerateServerKeypair()
+	assert.NoError(t, err)
+	h, err := NewHandler(kp)
+	assert.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	remote, err := phe.NewRemoteServer(srv.URL, phe.WithHTTPClient(srv.Client()))
+	assert.NoError(t, err)
+
+	pub, err := remote.PublicKey(ctx)
+	assert.NoError(t, err)
+	c, err := phe.NewClient(phe.GenerateClientKey(), pub)
+	assert.NoError(t, err)
+
+	enrollment, err := remote.GetEnrollment(ctx)
+	assert.NoError(t, err)
+	rec, key, err := c.EnrollAccount([]byte("password"), enrollment)
+	assert.NoError(t, err)
+
+	req, err := c.CreateVerifyPasswordRequest([]byte("password"), rec)
+	assert.NoError(t, err)
+	resp, err := remote.VerifyPassword(ctx, req)
+	assert.NoError(t, err)
+	got, err := c.CheckResponseAndDecrypt([]byte("password"), rec, resp)
+	assert.NoError(t, err)
+	assert.Equal(t, key, got)
+
+	_, err = remote.Rotate(ctx)
+	var re *phe.RemoteError
+	assert.True(t, errors.As(err, &re))
+	assert.Equal(t, http.StatusNotFound, re.StatusCode)
+
+	admin := httptest.NewServer(h.RotateHandler())
+	defer admin.Close()
+	remoteAdmin, err := phe.NewRemoteServer(admin.URL, phe.WithHTTPClient(admin.Client()))
+	assert.NoError(t, err)
+	_, err = remoteAdmin.Rotate(ctx)
+	assert.True(t, errors.As(err, &re))
+	assert.Equal(t, CodeRotationDisabled, re.Code)
+}
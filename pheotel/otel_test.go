@@ -0,0 +1,62 @@
+package pheotel
+
+import (
+	"context"
+	"testing"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := New(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	serverKeypair, err := phe.GenerateServerKeypair()
+	assert.NoError(t, err)
+	s, err := phe.NewServer(serverKeypair, phe.WithServerTracer(tracer))
+	assert.NoError(t, err)
+	c, err := phe.NewClient(phe.GenerateClientKey(), s.PublicKey(), phe.WithClientTracer(tracer))
+	assert.NoError(t, err)
+
+	ctx, root := tracer.Start(context.Background(), "login")
+	enrollment, err := s.GetEnrollmentContext(ctx)
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccountContext(ctx, []byte("password"), enrollment)
+	assert.NoError(t, err)
+	req, err := c.CreateVerifyPasswordRequest([]byte("wrong"), rec)
+	assert.NoError(t, err)
+	resp, err := s.VerifyPasswordContext(ctx, req)
+	assert.NoError(t, err)
+	_, err = c.CheckResponseAndDecryptContext(ctx, []byte("wrong"), rec, resp)
+	assert.NoError(t, err)
+	_, err = s.VerifyPasswordContext(ctx, nil)
+	assert.Error(t, err)
+	root.End(nil)
+
+	spans := recorder.Ended()
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name())
+	}
+	assert.Equal(t, []string{
+		"phe.Server.Prove", "phe.Server.GetEnrollment",
+		"phe.Client.VerifyProof", "phe.Client.EnrollAccount",
+		"phe.Server.Prove", "phe.Server.VerifyPassword",
+		"phe.Client.VerifyProof", "phe.Client.CheckResponseAndDecrypt",
+		"phe.Server.VerifyPassword",
+		"login",
+	}, names)
+
+	traceID := spans[len(spans)-1].SpanContext().TraceID()
+	for _, span := range spans {
+		assert.Equal(t, traceID, span.SpanContext().TraceID())
+	}
+	assert.Contains(t, spans[5].Attributes(), attribute.Bool("phe.result", false))
+	assert.Contains(t, spans[5].Attributes(), attribute.String("phe.curve", "P-256"))
+	assert.Equal(t, codes.Error, spans[8].Status().Code)
+}
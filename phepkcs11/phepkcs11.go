@@ -0,0 +1,176 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+// Package phepkcs11 keeps the PHE server private key in an HSM and performs server operations through PKCS #11.
+//
+// Multiplying points by the key is standard ECDH (CKM_ECDH1_DERIVE with CKD_NULL). Proofs also need a Schnorr
+// response k + c·x computed next to the key with a nonce k that never leaves the HSM. No standard PKCS #11 mechanism
+// does that, so the session has to provide it, typically as a vendor-defined mechanism running inside the HSM
+// (nShield CodeSafe, Luna FM, Utimaco CXI and similar). Never implement it by exporting the key
+package phepkcs11
+
+import (
+	"sync"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/pkg/errors"
+)
+
+// Session is the part of a logged in PKCS #11 session the key needs. Points are SEC 1 uncompressed,
+// scalars are big-endian
+type Session interface {
+	// DeriveECDH runs C_DeriveKey with CKM_ECDH1_DERIVE and CKD_NULL on the private key and returns CKA_VALUE
+	// of the derived CKK_GENERIC_SECRET, the x coordinate of the shared point
+	DeriveECDH(point []byte) ([]byte, error)
+	// SchnorrCommit generates a nonce k inside the HSM, returns a handle to it and k·B for each base
+	SchnorrCommit(bases [][]byte) (handle []byte, terms [][]byte, err error)
+	// SchnorrRespond returns k + c·x mod N for the nonce behind handle and destroys the nonce
+	SchnorrRespond(handle, challenge []byte) ([]byte, error)
+}
+
+// Key is a server private key kept in an HSM. It implements phe.PrivateKeyOps
+type Key struct {
+	session Session
+	pub     *phe.Point
+	g       *phe.Point
+	coord   int
+	// Session calls of a single PKCS #11 session must not overlap
+	mu sync.Mutex
+}
+
+// New creates a key for the HSM object behind the session. publicKey is its CKA_EC_POINT without the DER
+// OCTET STRING wrapper. Pass the key to phe.NewServerWithKey
+func New(session Session, publicKey []byte) (*Key, error) {
+	if session == nil {
+		return nil, errors.New("invalid session")
+	}
+
+	pub, err := phe.PointUnmarshal(publicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid public key")
+	}
+
+	return &Key{
+		session: session,
+		pub:     pub,
+		g:       pub.ScalarBaseMult([]byte{1}),
+		coord:   (len(publicKey) - 1) / 2,
+	}, nil
+}
+
+// PublicKey returns marshaled server public key
+func (k *Key) PublicKey() []byte {
+	return k.pub.Marshal()
+}
+
+// ScalarMult returns x·P. ECDH only yields the x coordinate of x·P, the sign of y is found by
+// a second derivation: x·(P + G) = x·P + X
+func (k *Key) ScalarMult(point []byte) ([]byte, error) {
+	in, err := k.unmarshal(point)
+	if err != nil {
+		return nil, err
+	}
+	shifted := in.Add(k.g)
+	if shifted.X.Sign() == 0 && shifted.Y.Sign() == 0 {
+		return nil, errors.New("invalid point")
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	x1, err := k.session.DeriveECDH(point)
+	if err != nil {
+		return nil, err
+	}
+	x2, err := k.session.DeriveECDH(shifted.Marshal())
+	if err != nil {
+		return nil, err
+	}
+	if len(x1) != k.coord || len(x2) != k.coord {
+		return nil, errors.New("hsm returned invalid shared secret")
+	}
+
+	candidate, err := k.pub.Curve().PointUnmarshalCompressed(append([]byte{2}, x1...))
+	if err != nil {
+		return nil, errors.Wrap(err, "hsm returned invalid shared secret")
+	}
+	for _, q := range []*phe.Point{candidate, candidate.Neg()} {
+		sum := q.Add(k.pub)
+		if sum.X.Sign() != 0 && string(sum.X.FillBytes(make([]byte, k.coord))) == string(x2) {
+			return q.Marshal(), nil
+		}
+	}
+	return nil, errors.New("hsm returned inconsistent shared secrets")
+}
+
+// Commit runs SchnorrCommit, the returned respond function can be used once
+func (k *Key) Commit(bases [][]byte) ([][]byte, func(challenge []byte) ([]byte, error), error) {
+	for _, b := range bases {
+		if _, err := k.unmarshal(b); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	k.mu.Lock()
+	handle, terms, err := k.session.SchnorrCommit(bases)
+	k.mu.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	return terms, func(challenge []byte) (resp []byte, err error) {
+		err = errors.New("commitment already used")
+		once.Do(func() {
+			k.mu.Lock()
+			defer k.mu.Unlock()
+			resp, err = k.session.SchnorrRespond(handle, challenge)
+		})
+		return
+	}, nil
+}
+
+// unmarshal decodes a point on the key's curve
+func (k *Key) unmarshal(point []byte) (*phe.Point, error) {
+	p, err := phe.PointUnmarshal(point)
+	if err != nil {
+		return nil, err
+	}
+	if p.Curve() != k.pub.Curve() {
+		return nil, errors.New("point is on another curve")
+	}
+	return p, nil
+}
@@ -0,0 +1,118 @@
+package phepkcs11
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/base64"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// softHSM does what the HSM would with the key in memory
+type softHSM struct {
+	x      *big.Int
+	n      *big.Int
+	mu     sync.Mutex
+	nonces map[string]*big.Int
+	seq    int
+}
+
+func newSoftHSM(t *testing.T, curve *phe.Curve) (*softHSM, []byte) {
+	kp, err := phe.GenerateServerKeypairForCurve(curve)
+	assert.NoError(t, err)
+	jwk, err := phe.KeypairJWK(kp, "")
+	assert.NoError(t, err)
+	d, err := base64.RawURLEncoding.DecodeString(jwk.D)
+	assert.NoError(t, err)
+	s, err := phe.NewServer(kp)
+	assert.NoError(t, err)
+
+	return &softHSM{x: new(big.Int).SetBytes(d), n: s.Public().(*ecdsa.PublicKey).Params().N, nonces: map[string]*big.Int{}}, s.PublicKey()
+}
+
+func (h *softHSM) DeriveECDH(point []byte) ([]byte, error) {
+	p, err := phe.PointUnmarshal(point)
+	if err != nil {
+		return nil, err
+	}
+	return p.ScalarMultInt(h.x).X.FillBytes(make([]byte, (len(point)-1)/2)), nil
+}
+
+func (h *softHSM) SchnorrCommit(bases [][]byte) ([]byte, [][]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	k := mustRandInt(h.n)
+	h.seq++
+	handle := []byte{byte(h.seq)}
+	h.nonces[string(handle)] = k
+
+	terms := make([][]byte, len(bases))
+	for i, b := range bases {
+		p, err := phe.PointUnmarshal(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		terms[i] = p.ScalarMultInt(k).Marshal()
+	}
+	return handle, terms, nil
+}
+
+func (h *softHSM) SchnorrRespond(handle, challenge []byte) ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	k := h.nonces[string(handle)]
+	delete(h.nonces, string(handle))
+
+	res := new(big.Int).Mul(new(big.Int).SetBytes(challenge), h.x)
+	res.Add(res, k).Mod(res, h.n)
+	return res.FillBytes(make([]byte, len(challenge))), nil
+}
+
+func TestKey(t *testing.T) {
+	for _, curve := range []*phe.Curve{phe.P256(), phe.P384(), phe.Secp256k1()} {
+		hsm, pub := newSoftHSM(t, curve)
+		key, err := New(hsm, pub)
+		assert.NoError(t, err)
+
+		server, err := phe.NewServerWithKey(key)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		assert.NoError(t, server.Warmup())
+		assert.Empty(t, hsm.nonces)
+
+		//a key for another public key is refused
+		_, otherPub := newSoftHSM(t, curve)
+		other, err := New(hsm, otherPub)
+		assert.NoError(t, err)
+		_, err = phe.NewServerWithKey(other)
+		assert.Error(t, err)
+	}
+}
+
+func TestKey_RespondOnce(t *testing.T) {
+	hsm, pub := newSoftHSM(t, phe.P256())
+	key, err := New(hsm, pub)
+	assert.NoError(t, err)
+
+	_, respond, err := key.Commit([][]byte{pub})
+	assert.NoError(t, err)
+	_, err = respond(make([]byte, 32))
+	assert.NoError(t, err)
+	_, err = respond(make([]byte, 32))
+	assert.Error(t, err)
+}
+
+func mustRandInt(n *big.Int) *big.Int {
+	k, err := rand.Int(rand.Reader, n)
+	if err != nil {
+		panic(err)
+	}
+	return k
+}
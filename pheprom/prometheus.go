@@ -0,0 +1,140 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+// Package pheprom exports PHE protocol metrics to Prometheus
+package pheprom
+
+import (
+	"errors"
+	"time"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics is a phe.Metrics keeping Prometheus collectors
+type Metrics struct {
+	enrollments    *prometheus.CounterVec
+	verifications  *prometheus.CounterVec
+	verifyDuration *prometheus.HistogramVec
+	proofDuration  prometheus.Histogram
+	proofFailures  *prometheus.CounterVec
+	migration      *prometheus.GaugeVec
+}
+
+var _ phe.Metrics = (*Metrics)(nil)
+
+// New creates collectors and registers them with reg, e.g. prometheus.DefaultRegisterer
+func New(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		enrollments: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "phe_enrollments_total",
+			Help: "Enrollments issued by the server or completed by the client.",
+		}, []string{"source"}),
+		verifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "phe_verifications_total",
+			Help: "Completed password verifications by result.",
+		}, []string{"source", "result"}),
+		verifyDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "phe_verification_duration_seconds",
+			Help:    "Time spent verifying a password.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 12),
+		}, []string{"source"}),
+		proofDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "phe_proof_verification_duration_seconds",
+			Help:    "Time the client spent checking a server proof.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 12),
+		}),
+		proofFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "phe_proof_failures_total",
+			Help: "Server proofs the client rejected or couldn't check, by reason.",
+		}, []string{"reason"}),
+		migration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "phe_migration_records",
+			Help: "Records of the running update token migration by state.",
+		}, []string{"state"}),
+	}
+
+	for _, c := range []prometheus.Collector{m.enrollments, m.verifications, m.verifyDuration, m.proofDuration, m.proofFailures, m.migration} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Enrollment implements phe.Metrics
+func (m *Metrics) Enrollment(source phe.EventSource) {
+	m.enrollments.WithLabelValues(source.String()).Inc()
+}
+
+// Verification implements phe.Metrics
+func (m *Metrics) Verification(source phe.EventSource, ok bool, d time.Duration) {
+	result := "wrong"
+	if ok {
+		result = "ok"
+	}
+	m.verifications.WithLabelValues(source.String(), result).Inc()
+	m.verifyDuration.WithLabelValues(source.String()).Observe(d.Seconds())
+}
+
+// ProofVerification implements phe.Metrics. Rejected proofs are counted as "malformed" or "mismatch",
+// other errors such as an exceeded budget as "error"
+func (m *Metrics) ProofVerification(d time.Duration, err error) {
+	m.proofDuration.Observe(d.Seconds())
+	if err == nil {
+		return
+	}
+
+	reason := "error"
+	var pe *phe.ProofError
+	if errors.As(err, &pe) {
+		switch pe.Reason {
+		case phe.ProofMalformed:
+			reason = "malformed"
+		case phe.ProofMismatch:
+			reason = "mismatch"
+		}
+	}
+	m.proofFailures.WithLabelValues(reason).Inc()
+}
+
+// MigrationProgress implements phe.Metrics
+func (m *Metrics) MigrationProgress(cp *phe.Checkpoint) {
+	m.migration.WithLabelValues("processed").Set(float64(cp.Processed))
+	m.migration.WithLabelValues("updated").Set(float64(cp.Updated))
+	m.migration.WithLabelValues("failed").Set(float64(cp.Failed))
+}
@@ -0,0 +1,119 @@
+package pheprom
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := New(reg)
+	assert.NoError(t, err)
+	_, err = New(reg)
+	assert.Error(t, err)
+
+	serverKeypair, err := phe.GenerateServerKeypair()
+	assert.NoError(t, err)
+	s, err := phe.NewServer(serverKeypair, phe.WithServerMetrics(m))
+	assert.NoError(t, err)
+	c, err := phe.NewClient(phe.GenerateClientKey(), s.PublicKey(), phe.WithClientMetrics(m))
+	assert.NoError(t, err)
+
+	enrollment, err := s.GetEnrollment()
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccount([]byte("password"), enrollment)
+	assert.NoError(t, err)
+
+	for _, pwd := range []string{"password", "wrong", "wrong"} {
+		req, err := c.CreateVerifyPasswordRequest([]byte(pwd), rec)
+		assert.NoError(t, err)
+		resp, err := s.VerifyPassword(req)
+		assert.NoError(t, err)
+		_, err = c.CheckResponseAndDecrypt([]byte(pwd), rec, resp)
+		assert.NoError(t, err)
+	}
+
+	enrollment.Proof.BlindX = enrollment.Proof.Term1
+	_, _, err = c.EnrollAccount([]byte("password"), enrollment)
+	assert.Error(t, err)
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP phe_enrollments_total Enrollments issued by the server or completed by the client.
+# TYPE phe_enrollments_total counter
+phe_enrollments_total{source="client"} 1
+phe_enrollments_total{source="server"} 1
+# HELP phe_verifications_total Completed password verifications by result.
+# TYPE phe_verifications_total counter
+phe_verifications_total{result="ok",source="client"} 1
+phe_verifications_total{result="ok",source="server"} 1
+phe_verifications_total{result="wrong",source="client"} 2
+phe_verifications_total{result="wrong",source="server"} 2
+# HELP phe_proof_failures_total Server proofs the client rejected or couldn't check, by reason.
+# TYPE phe_proof_failures_total counter
+phe_proof_failures_total{reason="malformed"} 1
+`), "phe_enrollments_total", "phe_verifications_total", "phe_proof_failures_total"))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.verifyDuration))
+}
+
+type memRecords map[string]*phe.EnrollmentRecord
+
+func (m memRecords) Records(ctx context.Context, after string, limit int) ([]phe.KeyedRecord, error) {
+	var res []phe.KeyedRecord
+	for _, k := range []string{"a", "b", "c"} {
+		if k > after && len(res) < limit {
+			res = append(res, phe.KeyedRecord{Key: k, Record: m[k]})
+		}
+	}
+	return res, nil
+}
+
+func (m memRecords) PutRecords(ctx context.Context, records []phe.KeyedRecord) error {
+	for _, kr := range records {
+		m[kr.Key] = kr.Record
+	}
+	return nil
+}
+
+func TestMetrics_Migration(t *testing.T) {
+	m, err := New(prometheus.NewRegistry())
+	assert.NoError(t, err)
+
+	serverKeypair, err := phe.GenerateServerKeypair()
+	assert.NoError(t, err)
+	pub, err := phe.GetPublicKey(serverKeypair)
+	assert.NoError(t, err)
+	c, err := phe.NewClient(phe.GenerateClientKey(), pub)
+	assert.NoError(t, err)
+
+	records := memRecords{"c": &phe.EnrollmentRecord{NS: []byte("broken")}}
+	for _, k := range []string{"a", "b"} {
+		enrollment, err := phe.GetEnrollment(serverKeypair)
+		assert.NoError(t, err)
+		records[k], _, err = c.EnrollAccount([]byte("password"), enrollment)
+		assert.NoError(t, err)
+	}
+	token, _, err := phe.Rotate(serverKeypair)
+	assert.NoError(t, err)
+
+	migration := &phe.Migration{
+		Source:      records,
+		Sink:        records,
+		Checkpoints: phe.NewFileCheckpoint(filepath.Join(t.TempDir(), "checkpoint.json")),
+		Token:       token,
+		BatchSize:   2,
+		Metrics:     m,
+	}
+	_, err = migration.Run(context.Background())
+	assert.NoError(t, err)
+
+	assert.Equal(t, 3.0, testutil.ToFloat64(m.migration.WithLabelValues("processed")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.migration.WithLabelValues("updated")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.migration.WithLabelValues("failed")))
+}
@@ -39,75 +39,82 @@ package phe
 import (
 	"crypto/elliptic"
 	"math/big"
-
-	"github.com/pkg/errors"
 )
 
 // Point represents an elliptic curve point
 type Point struct {
-	X, Y *big.Int
+	X, Y  *big.Int
+	curve *Curve
 }
 
 var (
-	pn   = curve.Params().P
 	zero = big.NewInt(0)
 )
 
 // PointUnmarshal validates & converts byte array to an elliptic curve point object
+// Curve is the first known one the point is valid on
 func PointUnmarshal(data []byte) (*Point, error) {
-	if len(data) > 65 || len(data) == 0 {
-		return nil, errors.New("Invalid curve point")
+	c, err := curveByPoint(data)
+	if err != nil {
+		return nil, err
 	}
-	x, y := elliptic.Unmarshal(curve, data)
-	if x == nil || y == nil {
-		return nil, errors.New("Invalid curve point")
+	return c.pointUnmarshal(data)
+}
+
+// c returns point's curve, P-256 if not set
+func (p *Point) c() *Curve {
+	if p.curve == nil {
+		return p256
 	}
-	return &Point{
-		X: x,
-		Y: y,
-	}, nil
+	return p.curve
+}
+
+// Curve returns the parameter set the point belongs to
+func (p *Point) Curve() *Curve {
+	return p.c()
 }
 
 // Add adds two points
 func (p *Point) Add(a *Point) *Point {
-	x, y := curve.Add(p.X, p.Y, a.X, a.Y)
-	return &Point{x, y}
+	x, y := p.c().ec.Add(p.X, p.Y, a.X, a.Y)
+	return &Point{x, y, p.curve}
 }
 
 // Neg inverts point's Y coordinate
 func (p *Point) Neg() *Point {
 	t := new(Point)
 	t.X = p.X
-	t.Y = new(big.Int).Sub(pn, p.Y)
+	t.Y = new(big.Int).Sub(p.c().ec.Params().P, p.Y)
+	t.curve = p.curve
 	return t
 }
 
 // ScalarMult multiplies point to a number
 func (p *Point) ScalarMult(b []byte) *Point {
-	x, y := curve.ScalarMult(p.X, p.Y, b)
+	x, y := p.c().ec.ScalarMult(p.X, p.Y, b)
 
-	return &Point{x, y}
+	return &Point{x, y, p.curve}
 }
 
 // ScalarMultInt multiplies point to a number
 func (p *Point) ScalarMultInt(b *big.Int) *Point {
-	x, y := curve.ScalarMult(p.X, p.Y, b.Bytes())
+	x, y := p.c().ec.ScalarMult(p.X, p.Y, p.c().fixedScalar(b))
 
-	return &Point{x, y}
+	return &Point{x, y, p.curve}
 }
 
-// ScalarBaseMult multiplies base point to a number
+// ScalarBaseMult multiplies base point of p's curve to a number
 func (p *Point) ScalarBaseMult(b []byte) *Point {
-	x, y := curve.ScalarBaseMult(b)
+	x, y := p.c().ec.ScalarBaseMult(b)
 
-	return &Point{x, y}
+	return &Point{x, y, p.curve}
 }
 
-// ScalarBaseMultInt multiplies base point to a number
+// ScalarBaseMultInt multiplies base point of p's curve to a number
 func (p *Point) ScalarBaseMultInt(b *big.Int) *Point {
-	x, y := curve.ScalarBaseMult(b.Bytes())
+	x, y := p.c().ec.ScalarBaseMult(p.c().fixedScalar(b))
 
-	return &Point{x, y}
+	return &Point{x, y, p.curve}
 }
 
 // Marshal converts point to an array of bytes
@@ -115,13 +122,22 @@ func (p *Point) Marshal() []byte {
 
 	if p.X.Cmp(zero) != 0 &&
 		p.Y.Cmp(zero) != 0 {
-		return elliptic.Marshal(curve, p.X, p.Y)
+		return elliptic.Marshal(p.c().ec, p.X, p.Y)
 	}
 	panic("zero point")
 }
 
+// MarshalCompressed converts point to the SEC 1 compressed form: parity of Y and X
+func (p *Point) MarshalCompressed() []byte {
+	if p.X.Sign() == 0 && p.Y.Sign() == 0 {
+		panic("zero point")
+	}
+	return elliptic.MarshalCompressed(p.c().ec, p.X, p.Y)
+}
+
 // Equal checks two points for equality
 func (p *Point) Equal(other *Point) bool {
-	return p.X.Cmp(other.X) == 0 &&
+	return p.c() == other.c() &&
+		p.X.Cmp(other.X) == 0 &&
 		p.Y.Cmp(other.Y) == 0
 }
@@ -30,7 +30,7 @@ func MakePoint() *Point {
 	b := make([]byte, 32)
 	rand.Read(b)
 	x, y := swu.HashToPoint(b)
-	return &Point{x, y}
+	return &Point{X: x, Y: y}
 }
 
 func TestPointUnmarshal(t *testing.T) {
@@ -0,0 +1,110 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// PasswordPolicy decides whether a password may be enrolled, returning the reason if it may not.
+// It gets the password after normalization, if it's enabled, and before any hashing
+type PasswordPolicy func(password []byte) error
+
+// WithPasswordPolicy makes EnrollAccount check passwords with p first, rejected passwords fail with *PolicyError
+func WithPasswordPolicy(p PasswordPolicy) ClientOption {
+	return func(c *Client) {
+		c.policy = p
+	}
+}
+
+// MinLength rejects passwords shorter than n characters
+func MinLength(n int) PasswordPolicy {
+	return func(password []byte) error {
+		if utf8.RuneCount(password) < n {
+			return fmt.Errorf("password must be at least %d characters long", n)
+		}
+		return nil
+	}
+}
+
+// BannedPasswords rejects the listed passwords, e.g. the most common ones, ignoring case
+func BannedPasswords(banned ...string) PasswordPolicy {
+	set := make(map[string]struct{}, len(banned))
+	for _, b := range banned {
+		set[string(bytes.ToLower([]byte(b)))] = struct{}{}
+	}
+	return func(password []byte) error {
+		if _, ok := set[string(bytes.ToLower(password))]; ok {
+			return errors.New("password is too common")
+		}
+		return nil
+	}
+}
+
+// AllPolicies combines policies, the first rejection is returned. Strength estimators such as zxcvbn
+// can be added as a PasswordPolicy of their own
+func AllPolicies(policies ...PasswordPolicy) PasswordPolicy {
+	return func(password []byte) error {
+		for _, p := range policies {
+			if err := p(password); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// checkPolicy runs the password policy if there's one
+func (c *Client) checkPolicy(password []byte) error {
+	if c.policy == nil {
+		return nil
+	}
+
+	password, err := c.normalizePassword(password)
+	if err != nil {
+		return err
+	}
+
+	if err = c.policy(password); err != nil {
+		return &PolicyError{Err: err}
+	}
+	return nil
+}
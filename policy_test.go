@@ -0,0 +1,46 @@
+package phe
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	policy := AllPolicies(MinLength(8), BannedPasswords("Password1", "qwertyuiop"))
+	c, err := NewClient(GenerateClientKey(), mustPublicKey(t, serverKeypair), WithPasswordPolicy(policy), WithPasswordNormalization())
+	assert.NoError(t, err)
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+
+	for _, password := range []string{"short", "password1", "QWERTYUIOP", "  seven  ", "ééééééé"} {
+		_, _, err = c.EnrollAccount([]byte(password), enrollment)
+		assert.True(t, errors.Is(err, ErrPasswordPolicy), password)
+		var pe *PolicyError
+		assert.True(t, errors.As(err, &pe))
+		assert.NotNil(t, pe.Err)
+	}
+
+	// the policy runs before the enrollment response is looked at
+	_, _, err = c.EnrollAccount([]byte("short"), nil)
+	assert.True(t, errors.Is(err, ErrPasswordPolicy))
+
+	// lengths are in characters
+	rec, _, err := c.EnrollAccount([]byte("éééééééé"), enrollment)
+	assert.NoError(t, err)
+	assert.NotNil(t, rec)
+
+	// verification isn't subject to the policy
+	req, err := c.CreateVerifyPasswordRequest([]byte("short"), rec)
+	assert.NoError(t, err)
+	assert.NotNil(t, req)
+
+	custom := errors.New("custom")
+	c, err = NewClient(GenerateClientKey(), mustPublicKey(t, serverKeypair), WithPasswordPolicy(func([]byte) error { return custom }))
+	assert.NoError(t, err)
+	_, _, err = c.EnrollAccount(pwd, enrollment)
+	assert.True(t, errors.Is(err, custom))
+	assert.Equal(t, "password rejected by policy: custom", err.Error())
+}
@@ -0,0 +1,235 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultPoolSize   = 64
+	defaultPoolMaxAge = 10 * time.Minute
+)
+
+// EnrollmentPool pre-computes enrollment responses in the background so that registration requests
+// are served from the pool without waiting for proof generation.
+// Responses older than the pool's max age are discarded so that unused server nonces don't sit around
+type EnrollmentPool struct {
+	s        *Server
+	size     int
+	interval time.Duration
+	maxAge   time.Duration
+	now      func() time.Time
+
+	mu    sync.Mutex
+	items []pooledEnrollment
+
+	wake    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+type pooledEnrollment struct {
+	resp    *EnrollmentResponse
+	created time.Time
+}
+
+// PoolOption configures an EnrollmentPool
+type PoolOption func(*EnrollmentPool)
+
+// WithPoolSize sets how many responses the pool keeps ready, 64 by default
+func WithPoolSize(n int) PoolOption {
+	return func(p *EnrollmentPool) {
+		if n > 0 {
+			p.size = n
+		}
+	}
+}
+
+// WithRefillRate limits how many responses per second the pool generates so that refilling
+// doesn't compete with request processing. By default the pool is refilled as fast as possible
+func WithRefillRate(perSecond int) PoolOption {
+	return func(p *EnrollmentPool) {
+		if perSecond > 0 {
+			p.interval = time.Second / time.Duration(perSecond)
+		}
+	}
+}
+
+// WithMaxAge sets how long a pre-generated response may be served, 10 minutes by default
+func WithMaxAge(d time.Duration) PoolOption {
+	return func(p *EnrollmentPool) {
+		if d > 0 {
+			p.maxAge = d
+		}
+	}
+}
+
+// NewEnrollmentPool starts filling a pool of enrollment responses for the server. Close must be called to stop it
+func NewEnrollmentPool(s *Server, opts ...PoolOption) *EnrollmentPool {
+	p := &EnrollmentPool{
+		s:       s,
+		size:    defaultPoolSize,
+		maxAge:  defaultPoolMaxAge,
+		now:     time.Now,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	go p.run()
+	return p
+}
+
+// GetEnrollment returns a pre-generated enrollment response or generates a new one if the pool is empty.
+// Every response is handed out only once
+func (p *EnrollmentPool) GetEnrollment() (*EnrollmentResponse, error) {
+	p.mu.Lock()
+	p.expire()
+	var resp *EnrollmentResponse
+	if len(p.items) > 0 {
+		resp = p.items[0].resp
+		p.items[0] = pooledEnrollment{}
+		p.items = p.items[1:]
+	}
+	p.mu.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+
+	if resp != nil {
+		return resp, nil
+	}
+	return p.s.GetEnrollment()
+}
+
+// Len returns the number of responses ready to be served
+func (p *EnrollmentPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expire()
+	return len(p.items)
+}
+
+// Close stops background generation and drops pre-generated responses
+func (p *EnrollmentPool) Close() {
+	p.once.Do(func() {
+		close(p.done)
+		<-p.stopped
+
+		p.mu.Lock()
+		p.items = nil
+		p.mu.Unlock()
+	})
+}
+
+func (p *EnrollmentPool) run() {
+	defer close(p.stopped)
+
+	for {
+		if p.Len() < p.size {
+			resp, err := p.s.GetEnrollment()
+			if err == nil {
+				p.mu.Lock()
+				p.items = append(p.items, pooledEnrollment{resp: resp, created: p.now()})
+				p.mu.Unlock()
+			}
+
+			wait := p.interval
+			if err != nil {
+				// don't spin on a failing random source
+				wait = time.Second
+			}
+
+			if wait > 0 {
+				if !p.sleep(wait) {
+					return
+				}
+			} else if p.closed() {
+				return
+			}
+			continue
+		}
+
+		// pool is full, wait until a response is taken or the oldest one expires
+		select {
+		case <-p.done:
+			return
+		case <-p.wake:
+		case <-time.After(p.maxAge / 4):
+		}
+	}
+}
+
+// expire drops responses older than max age, p.mu must be held
+func (p *EnrollmentPool) expire() {
+	deadline := p.now().Add(-p.maxAge)
+	i := 0
+	for i < len(p.items) && p.items[i].created.Before(deadline) {
+		p.items[i] = pooledEnrollment{}
+		i++
+	}
+	p.items = p.items[i:]
+}
+
+func (p *EnrollmentPool) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-p.done:
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+func (p *EnrollmentPool) closed() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,68 @@
+package phe
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func waitPool(t *testing.T, p *EnrollmentPool, n int) {
+	for i := 0; i < 500 && p.Len() < n; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, n, p.Len())
+}
+
+func TestEnrollmentPool(t *testing.T) {
+	s, err := NewServer(mustKeypair(t))
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), s.PublicKey())
+	assert.NoError(t, err)
+
+	p := NewEnrollmentPool(s, WithPoolSize(3))
+	defer p.Close()
+	waitPool(t, p, 3)
+
+	seen := map[string]bool{}
+	for i := 0; i < 5; i++ {
+		resp, err := p.GetEnrollment()
+		assert.NoError(t, err)
+		assert.False(t, seen[string(resp.NS)])
+		seen[string(resp.NS)] = true
+
+		_, _, err = c.EnrollAccount(pwd, resp)
+		assert.NoError(t, err)
+	}
+
+	waitPool(t, p, 3)
+}
+
+func TestEnrollmentPool_MaxAge(t *testing.T) {
+	s, err := NewServer(mustKeypair(t))
+	assert.NoError(t, err)
+
+	var mu sync.Mutex
+	now := time.Now()
+	p := NewEnrollmentPool(s, WithPoolSize(2), WithMaxAge(time.Minute), WithRefillRate(1000))
+	defer p.Close()
+
+	p.mu.Lock()
+	p.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	p.mu.Unlock()
+	waitPool(t, p, 2)
+
+	mu.Lock()
+	now = now.Add(2 * time.Minute)
+	mu.Unlock()
+
+	p.mu.Lock()
+	p.expire()
+	assert.Len(t, p.items, 0)
+	p.mu.Unlock()
+}
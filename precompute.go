@@ -0,0 +1,143 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+package phe
+
+import (
+	"math/big"
+)
+
+// fixedBaseWindow is the width of scalar digits looked up in a fixedBase table
+const fixedBaseWindow = 4
+
+// fixedBase holds multiples of one public point so it's multiplied with a mixed addition per scalar digit
+// and no doublings: rows[i][j] is (j+1)·16^i·P
+type fixedBase struct {
+	rows [][1<<fixedBaseWindow - 1]nistAffine
+}
+
+// WithPrecomputedKey makes NewClient build a table of multiples of the server public key, about 140 KB on P-256.
+// The proof equation involving the key and the generator is then computed with additions only. The other equations
+// involve per-record points and gain nothing, so CheckResponseAndDecrypt gets about 10% faster on P-384 and 20%
+// on P-521 but not on P-256, where crypto/elliptic multiplies in assembly, see BenchmarkClient_CheckResponse_P256.
+// Rotation rebuilds the table. It has no effect on secp256k1, whose a coefficient the table arithmetic doesn't support
+func WithPrecomputedKey() ClientOption {
+	return func(c *Client) {
+		c.precompute = true
+	}
+}
+
+// newFixedBase builds the table of p, nil if the curve has no field arithmetic. Additions of equal points,
+// e.g. when a sum of generator multiples meets a multiple of X = x·G, fall back to doubling for a = -3,
+// so only the NIST curves have it
+func newFixedBase(c *Curve, p *Point) *fixedBase {
+	f := c.field
+	if f == nil {
+		return nil
+	}
+	rows := 2 * c.scalarLen
+	t := &fixedBase{rows: make([][1<<fixedBaseWindow - 1]nistAffine, rows)}
+
+	multiples := make([]nistPoint, 0, rows*len(t.rows[0]))
+	x, y := p.X, p.Y
+	for i := 0; i < rows; i++ {
+		x2, y2 := c.ec.Double(x, y)
+		base := nistAffine{x: f.fromBig(x), y: f.fromBig(y)}
+		multiples = append(multiples,
+			nistPoint{x: base.x, y: base.y, z: f.one},
+			nistPoint{x: f.fromBig(x2), y: f.fromBig(y2), z: f.one})
+		for j := 3; j < 1<<fixedBaseWindow; j++ {
+			var next nistPoint
+			f.pointAddAffine(&next, &multiples[len(multiples)-1], &base)
+			multiples = append(multiples, next)
+		}
+
+		x, y = c.ec.Double(x2, y2)
+		x, y = c.ec.Double(x, y)
+		x, y = c.ec.Double(x, y)
+	}
+
+	affine := f.toAffine(multiples)
+	for i := range t.rows {
+		copy(t.rows[i][:], affine[i*len(t.rows[i]):])
+	}
+	return t
+}
+
+// generatorTable returns the table of the curve's generator, it's built on first use
+func (c *Curve) generatorTable() *fixedBase {
+	c.gTableOnce.Do(func() {
+		c.gTable = newFixedBase(c, c.g)
+	})
+	return c.gTable
+}
+
+// fixedBaseMult computes the sum of the tables' points multiplied to scalars. It works in variable time
+// and must only be used for public values
+func (c *Curve) fixedBaseMult(tables []*fixedBase, scalars []*big.Int) *Point {
+	f := c.field
+	n := c.ec.Params().N
+
+	var acc nistPoint
+	for i, t := range tables {
+		k := scalars[i]
+		if k.Sign() < 0 || k.Cmp(n) >= 0 {
+			k = new(big.Int).Mod(k, n)
+		}
+		kb := c.scalarBytes(k)
+
+		// digits go from the least significant one, two per byte
+		for row := range t.rows {
+			digit := kb[len(kb)-1-row/2] >> (fixedBaseWindow * (row % 2)) & (1<<fixedBaseWindow - 1)
+			if digit != 0 {
+				f.pointAddAffine(&acc, &acc, &t.rows[row][digit-1])
+			}
+		}
+	}
+
+	if f.isZero(&acc.z) {
+		return &Point{X: new(big.Int), Y: new(big.Int), curve: c}
+	}
+	affine := f.toAffine([]nistPoint{acc})[0]
+	return &Point{X: f.toBig(&affine.x), Y: f.toBig(&affine.y), curve: c}
+}
+
+// keyMult computes g ** a * X ** b, with the precomputed tables if the client has them
+func (c *Client) keyMult(a, b *big.Int) *Point {
+	if c.keyTable == nil {
+		return c.curve.multiScalarMult([]*Point{c.curve.g, c.serverPublicKey}, []*big.Int{a, b})
+	}
+	return c.curve.fixedBaseMult([]*fixedBase{c.curve.generatorTable(), c.keyTable}, []*big.Int{a, b})
+}
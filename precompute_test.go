@@ -0,0 +1,117 @@
+package phe
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCurve_FixedBaseMult(t *testing.T) {
+	for _, c := range curves {
+		p := c.scalarBaseMult(c.randomZ())
+		table := newFixedBase(c, p)
+		if c == Secp256k1() {
+			assert.Nil(t, table)
+			continue
+		}
+
+		a, b := c.randomZ(), c.randomZ()
+		want := c.scalarBaseMult(a).Add(p.ScalarMultInt(b))
+		got := c.fixedBaseMult([]*fixedBase{c.generatorTable(), table}, []*big.Int{a, b})
+		assert.True(t, want.Equal(got), c.Name())
+
+		got = c.fixedBaseMult([]*fixedBase{table}, []*big.Int{new(big.Int).Neg(b)})
+		assert.True(t, p.ScalarMultInt(c.gf.Neg(b)).Equal(got), c.Name())
+
+		got = c.fixedBaseMult([]*fixedBase{table, table}, []*big.Int{b, c.gf.Neg(b)})
+		assert.Zero(t, got.X.Sign(), c.Name())
+	}
+}
+
+func TestWithPrecomputedKey(t *testing.T) {
+	for _, curve := range curves {
+		kp, err := GenerateServerKeypairForCurve(curve)
+		assert.NoError(t, err)
+		s, err := NewServer(kp)
+		assert.NoError(t, err)
+		c, err := NewClient(GenerateClientKeyForCurve(curve), s.PublicKey(), WithPrecomputedKey())
+		assert.NoError(t, err)
+		assert.Equal(t, curve != Secp256k1(), c.keyTable != nil, curve.Name())
+
+		enrollment, err := s.GetEnrollment()
+		assert.NoError(t, err)
+		rec, key, err := c.EnrollAccount(pwd, enrollment)
+		assert.NoError(t, err)
+
+		token, newKp, err := Rotate(kp)
+		assert.NoError(t, err)
+		s, err = NewServer(newKp)
+		assert.NoError(t, err)
+		c, err = c.RotateNew(token, s.PublicKey())
+		assert.NoError(t, err)
+		rec, err = UpdateRecord(rec, token)
+		assert.NoError(t, err)
+
+		for _, password := range [][]byte{pwd, []byte("wrong")} {
+			req, err := c.CreateVerifyPasswordRequest(password, rec)
+			assert.NoError(t, err)
+			resp, err := s.VerifyPassword(req)
+			assert.NoError(t, err)
+			got, err := c.CheckResponseAndDecrypt(password, rec, resp)
+			assert.NoError(t, err, curve.Name())
+			if resp.Res {
+				assert.Equal(t, key, got)
+			} else {
+				assert.Nil(t, got)
+			}
+
+			// tampered responses are still caught
+			resp.C1 = c.curve.g.Marshal()
+			_, err = c.CheckResponseAndDecrypt(password, rec, resp)
+			assert.Error(t, err, curve.Name())
+		}
+	}
+}
+
+func TestWithPrecomputedKey_EqualPoints(t *testing.T) {
+	for _, curve := range curves {
+		//with X = G the sums of both tables meet and have to be doubled
+		c, err := NewClient(GenerateClientKeyForCurve(curve), curve.gBytes, WithPrecomputedKey())
+		assert.NoError(t, err)
+
+		k := curve.randomZ()
+		for _, ab := range [][2]*big.Int{{big.NewInt(1), big.NewInt(1)}, {big.NewInt(5), big.NewInt(3)}, {k, k}} {
+			want := curve.scalarBaseMult(new(big.Int).Add(ab[0], ab[1]))
+			assert.True(t, want.Equal(c.keyMult(ab[0], ab[1])), curve.Name())
+		}
+	}
+}
+
+func TestWithPrecomputedKey_Secp256k1(t *testing.T) {
+	curve := Secp256k1()
+	kp, err := GenerateServerKeypairForCurve(curve)
+	assert.NoError(t, err)
+	s, err := NewServer(kp)
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKeyForCurve(curve), s.PublicKey(), WithPrecomputedKey())
+	assert.NoError(t, err)
+
+	enrollment, err := s.GetEnrollment()
+	assert.NoError(t, err)
+	rec, key, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+	for _, password := range [][]byte{pwd, []byte("wrong")} {
+		req, err := c.CreateVerifyPasswordRequest(password, rec)
+		assert.NoError(t, err)
+		resp, err := s.VerifyPassword(req)
+		assert.NoError(t, err)
+		got, err := c.CheckResponseAndDecrypt(password, rec, resp)
+		assert.NoError(t, err)
+		if resp.Res {
+			assert.Equal(t, key, got)
+		} else {
+			assert.Nil(t, got)
+		}
+	}
+}
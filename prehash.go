@@ -0,0 +1,102 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PasswordHasher stretches a password before the client hashes it to curve points, so that an attacker
+// who got both the records and server's private key still pays for every guess.
+// salt is the record's client nonce. Records enrolled with a hasher can only be verified with the same hasher and parameters.
+// EnrollAccount, CreateVerifyPasswordRequest and CheckResponseAndDecrypt return hasher's error.
+// The result is wiped after use, so it must not share memory with the password
+type PasswordHasher interface {
+	HashPassword(password, salt []byte) ([]byte, error)
+}
+
+// WithPasswordHasher makes the client stretch passwords with h, e.g. Argon2id{} or Scrypt{}. By default passwords are used as they are
+func WithPasswordHasher(h PasswordHasher) ClientOption {
+	return func(c *Client) {
+		c.hasher = h
+	}
+}
+
+// Argon2id is a PasswordHasher computing 32 bytes of Argon2id. Time, Memory in KiB and Threads
+// are its parameters, zero values mean 3 passes over 64 MiB with 4 threads
+type Argon2id struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// HashPassword implements PasswordHasher
+func (a Argon2id) HashPassword(password, salt []byte) ([]byte, error) {
+	t, m, threads := FallbackPolicy{Time: a.Time, Memory: a.Memory, Threads: a.Threads}.params()
+	return argon2.IDKey(password, salt, t, m, threads, 32), nil
+}
+
+// Scrypt is a PasswordHasher computing 32 bytes of scrypt. N is the CPU/memory cost, a power of two, R the block size
+// and P the parallelism. Zero values mean N = 2^15, R = 8 and P = 1, which takes 32 MiB
+type Scrypt struct {
+	N, R, P int
+}
+
+// HashPassword implements PasswordHasher
+func (s Scrypt) HashPassword(password, salt []byte) ([]byte, error) {
+	n, r, p := s.N, s.R, s.P
+	if n == 0 {
+		n = 1 << 15
+	}
+	if r == 0 {
+		r = 8
+	}
+	if p == 0 {
+		p = 1
+	}
+	return scrypt.Key(password, salt, n, r, p, 32)
+}
+
+// hashPassword returns what the client hashes to points in place of the password
+func (c *Client) hashPassword(password, nc []byte) ([]byte, error) {
+	password, err := c.normalizePassword(password)
+	if err != nil || c.hasher == nil {
+		return password, err
+	}
+	return c.hasher.HashPassword(password, nc)
+}
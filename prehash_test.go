@@ -0,0 +1,105 @@
+package phe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordHasher(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	pub := mustPublicKey(t, serverKeypair)
+	clientKey := GenerateClientKey()
+	hasher := Argon2id{Time: 1, Memory: 64, Threads: 1}
+
+	c, err := NewClient(clientKey, pub, WithPasswordHasher(hasher))
+	assert.NoError(t, err)
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	rec, key, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	check := func(c *Client, password []byte) []byte {
+		req, err := c.CreateVerifyPasswordRequest(password, rec)
+		assert.NoError(t, err)
+		resp, err := VerifyPassword(serverKeypair, req)
+		assert.NoError(t, err)
+		key, err := c.CheckResponseAndDecrypt(password, rec, resp)
+		assert.NoError(t, err)
+		return key
+	}
+
+	assert.Equal(t, key, check(c, pwd))
+	assert.Nil(t, check(c, []byte("wrong")))
+
+	// the record is bound to the stretched password
+	plain, err := NewClient(clientKey, pub)
+	assert.NoError(t, err)
+	assert.Nil(t, check(plain, pwd))
+	stretched, err := hasher.HashPassword(pwd, rec.NC)
+	assert.NoError(t, err)
+	assert.Equal(t, key, check(plain, stretched))
+
+	other, err := NewClient(clientKey, pub, WithPasswordHasher(Argon2id{Time: 2, Memory: 64, Threads: 1}))
+	assert.NoError(t, err)
+	assert.Nil(t, check(other, pwd))
+
+	// rerandomized records keep working with the hasher
+	req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	resp, err := VerifyPassword(serverKeypair, req)
+	assert.NoError(t, err)
+	enrollment, err = GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	rec, err = c.RerandomizeRecord(pwd, rec, resp, enrollment)
+	assert.NoError(t, err)
+	assert.Equal(t, key, check(c, pwd))
+}
+
+func TestPasswordHasher_Defaults(t *testing.T) {
+	salt := make([]byte, 32)
+	for _, pair := range [][2]PasswordHasher{
+		{Argon2id{}, Argon2id{Time: 3, Memory: 64 * 1024, Threads: 4}},
+		{Scrypt{}, Scrypt{N: 1 << 15, R: 8, P: 1}},
+	} {
+		h, err := pair[0].HashPassword(pwd, salt)
+		assert.NoError(t, err)
+		assert.Len(t, h, 32)
+
+		explicit, err := pair[1].HashPassword(pwd, salt)
+		assert.NoError(t, err)
+		assert.Equal(t, h, explicit)
+
+		other, err := pair[0].HashPassword(pwd, salt[:31])
+		assert.NoError(t, err)
+		assert.NotEqual(t, h, other)
+	}
+}
+
+func TestPasswordHasher_Scrypt(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	c, err := NewClient(GenerateClientKey(), mustPublicKey(t, serverKeypair), WithPasswordHasher(Scrypt{N: 1024}))
+	assert.NoError(t, err)
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	rec, key, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	resp, err := VerifyPassword(serverKeypair, req)
+	assert.NoError(t, err)
+	decrypted, err := c.CheckResponseAndDecrypt(pwd, rec, resp)
+	assert.NoError(t, err)
+	assert.Equal(t, key, decrypted)
+
+	// hasher errors are returned
+	bad, err := NewClient(GenerateClientKey(), mustPublicKey(t, serverKeypair), WithPasswordHasher(Scrypt{N: 1000}))
+	assert.NoError(t, err)
+	_, _, err = bad.EnrollAccount(pwd, enrollment)
+	assert.Error(t, err)
+	_, err = bad.CreateVerifyPasswordRequest(pwd, rec)
+	assert.Error(t, err)
+	_, err = bad.CheckResponseAndDecrypt(pwd, rec, resp)
+	assert.Error(t, err)
+}
@@ -0,0 +1,182 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PrivateKeyOps performs the only operations that need the server private key x, so the key can stay in an HSM
+// or another process. Points are marshaled uncompressed, scalars are big-endian of the curve's scalar length
+type PrivateKeyOps interface {
+	// PublicKey returns marshaled x·G
+	PublicKey() []byte
+	// ScalarMult returns x·P. Server only calls it with points hashed from nonces and with the generator
+	ScalarMult(point []byte) ([]byte, error)
+	// Commit starts a proof of knowledge of x: it picks a fresh secret nonce k and returns k·B for each base.
+	// respond returns k + c·x mod N for a challenge c. It must refuse to answer twice, two responses with
+	// the same nonce reveal x
+	Commit(bases [][]byte) (terms [][]byte, respond func(challenge []byte) ([]byte, error), err error)
+}
+
+// privateKey is PrivateKeyOps on parsed points, implemented directly for keys in memory
+type privateKey interface {
+	scalarMult(p *Point) (*Point, error)
+	commit(bases ...*Point) (terms []*Point, respond func(challenge []byte) ([]byte, error), err error)
+}
+
+// softwareKey is a private key held in process memory
+type softwareKey struct {
+	curve *Curve
+	x     []byte
+}
+
+func (k *softwareKey) scalarMult(p *Point) (*Point, error) {
+	if p == k.curve.g {
+		return k.curve.scalarBaseMultBytes(k.x), nil
+	}
+	return p.ScalarMult(k.x), nil
+}
+
+func (k *softwareKey) commit(bases ...*Point) ([]*Point, func(challenge []byte) ([]byte, error), error) {
+	nonce := k.curve.randomScalar()
+	terms := make([]*Point, len(bases))
+	for i, b := range bases {
+		if b == k.curve.g {
+			terms[i] = k.curve.scalarBaseMultBytes(nonce)
+		} else {
+			terms[i] = b.ScalarMult(nonce)
+		}
+	}
+
+	return terms, func(challenge []byte) ([]byte, error) {
+		sf := k.curve.sf
+		return sf.Add(nonce, sf.Mul(k.x, challenge)), nil
+	}, nil
+}
+
+// delegatedKey adapts PrivateKeyOps and validates everything it returns
+type delegatedKey struct {
+	curve *Curve
+	ops   PrivateKeyOps
+}
+
+func (k *delegatedKey) scalarMult(p *Point) (*Point, error) {
+	res, err := k.ops.ScalarMult(p.Marshal())
+	if err != nil {
+		return nil, errors.Wrap(err, "private key operation failed")
+	}
+	return k.curve.pointUnmarshal(res)
+}
+
+func (k *delegatedKey) commit(bases ...*Point) ([]*Point, func(challenge []byte) ([]byte, error), error) {
+	marshaled := make([][]byte, len(bases))
+	for i, b := range bases {
+		marshaled[i] = b.Marshal()
+	}
+
+	res, respond, err := k.ops.Commit(marshaled)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "private key operation failed")
+	}
+	if len(res) != len(bases) {
+		return nil, nil, errors.New("private key operation returned wrong number of terms")
+	}
+
+	terms := make([]*Point, len(res))
+	for i, t := range res {
+		if terms[i], err = k.curve.pointUnmarshal(t); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return terms, func(challenge []byte) ([]byte, error) {
+		resp, err := respond(challenge)
+		if err != nil {
+			return nil, errors.Wrap(err, "private key operation failed")
+		}
+		if len(resp) != k.curve.scalarLen {
+			return nil, errors.New("private key operation returned invalid scalar")
+		}
+		if _, err = k.curve.parseScalar(resp); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}, nil
+}
+
+// NewServerWithKey creates a server whose private key operations are delegated, e.g. to an HSM.
+// Such a server can't be exported or rotated by this package, rotation has to happen where the key is kept
+func NewServerWithKey(key PrivateKeyOps, opts ...ServerOption) (*Server, error) {
+	if key == nil {
+		return nil, errors.New("invalid private key")
+	}
+
+	pub, err := PointUnmarshal(key.PublicKey())
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid public key")
+	}
+
+	dk := &delegatedKey{curve: pub.curve, ops: key}
+	x, err := dk.scalarMult(pub.curve.g)
+	if err != nil {
+		return nil, err
+	}
+	if !x.Equal(pub) {
+		return nil, errors.New("public key does not match private key")
+	}
+
+	s := &Server{
+		kp:      &keypair{PublicKey: pub.Marshal()},
+		pub:     pub,
+		curve:   pub.curve,
+		key:     dk,
+		usage:   &usageCounter{since: time.Now()},
+		stats:   newServerStats(),
+		events:  DefaultEventBus,
+		metrics: nopMetrics{},
+		logger:  nopLogger{},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s, nil
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// RecordMACSize is the length of a record MAC
+const RecordMACSize = sha256.Size
+
+var recordMACDomain = []byte("RecordMAC")
+
+// WithRecordMAC makes the client authenticate the records it creates with an HMAC keyed with macKey, and check
+// the MAC of records before using them so that corrupted or truncated rows fail with ErrRecordMAC instead of
+// an invalid proof. Records without a MAC are rejected too, update records with Client.UpdateRecord to keep it
+// and add it to older ones with Client.MACRecord
+func WithRecordMAC(macKey []byte) ClientOption {
+	return func(c *Client) {
+		c.macKey = append([]byte{}, macKey...)
+	}
+}
+
+// MACRecord returns a copy of the record with a MAC, e.g. after UpdateRecord dropped it
+func (c *Client) MACRecord(rec *EnrollmentRecord) (*EnrollmentRecord, error) {
+	if c.macKey == nil {
+		return nil, errors.New("client has no record MAC key")
+	}
+	if rec == nil || !rec.wellFormed() {
+		return nil, errors.New("invalid record")
+	}
+
+	res := *rec
+	res.MAC = c.recordMAC(rec)
+	return &res, nil
+}
+
+// UpdateRecord checks the record's MAC, applies the update token to it like UpdateRecord does and authenticates
+// the result again. The MAC key doesn't change on rotation, so c may be either the old or the rotated client
+func (c *Client) UpdateRecord(rec *EnrollmentRecord, token *UpdateToken) (*EnrollmentRecord, error) {
+	if err := c.checkRecordMAC(rec); err != nil {
+		return nil, err
+	}
+
+	updRec, err := UpdateRecord(rec, token)
+	if err != nil {
+		return nil, err
+	}
+	c.sealRecord(updRec)
+	return updRec, nil
+}
+
+// sealRecord sets the MAC of a record the client has just made
+func (c *Client) sealRecord(rec *EnrollmentRecord) {
+	if c.macKey != nil {
+		rec.MAC = c.recordMAC(rec)
+	}
+}
+
+// checkRecordMAC verifies the record's MAC if the client has the key. A missing MAC fails as well
+// so that a row cut off before it or stripped of it isn't used unchecked
+func (c *Client) checkRecordMAC(rec *EnrollmentRecord) error {
+	if c.macKey == nil || rec == nil {
+		return nil
+	}
+	if rec.MAC == nil || !hmac.Equal(rec.MAC, c.recordMAC(rec)) {
+		return ErrRecordMAC
+	}
+	return nil
+}
+
+// recordMAC authenticates the record for the account ID the client is bound to
+func (c *Client) recordMAC(rec *EnrollmentRecord) []byte {
+	return RecordMAC(c.macKey, c.accountID, rec)
+}
+
+// RecordMAC authenticates length-prefixed record fields, key version and accountID with an HMAC keyed with macKey.
+// It's the MAC WithRecordMAC clients set, exported for the other group instantiations of the protocol
+func RecordMAC(macKey, accountID []byte, rec *EnrollmentRecord) []byte {
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(recordMACDomain)
+	for _, f := range [][]byte{rec.NS, rec.NC, rec.T0, rec.T1, accountID} {
+		_ = binary.Write(mac, binary.BigEndian, uint64(len(f)))
+		mac.Write(f)
+	}
+	_ = binary.Write(mac, binary.BigEndian, rec.KeyVersion)
+	return mac.Sum(nil)
+}
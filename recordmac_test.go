@@ -0,0 +1,152 @@
+package phe
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_RecordMAC(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	s := mustServer(t, serverKeypair)
+	macKey := []byte("record mac key")
+	c, err := NewClient(GenerateClientKey(), s.PublicKey(), WithRecordMAC(macKey))
+	assert.NoError(t, err)
+
+	enrollment, err := s.GetEnrollment()
+	assert.NoError(t, err)
+	rec, key, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+	assert.Len(t, rec.MAC, RecordMACSize)
+
+	req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	res, err := s.VerifyPassword(req)
+	assert.NoError(t, err)
+	keyDec, err := c.CheckResponseAndDecrypt(pwd, rec, res)
+	assert.NoError(t, err)
+	assert.Equal(t, key, keyDec)
+
+	corrupted := *rec
+	corrupted.NC = append([]byte{}, rec.NC...)
+	corrupted.NC[0] ^= 1
+	_, err = c.CreateVerifyPasswordRequest(pwd, &corrupted)
+	assert.ErrorIs(t, err, ErrRecordMAC)
+	_, err = c.CheckResponseAndDecrypt(pwd, &corrupted, res)
+	assert.ErrorIs(t, err, ErrRecordMAC)
+
+	corrupted = *rec
+	corrupted.KeyVersion = 7
+	_, err = c.CreateVerifyPasswordRequest(pwd, &corrupted)
+	assert.ErrorIs(t, err, ErrRecordMAC)
+
+	// a client with another key or bound to another account rejects the record
+	other, err := NewClient(GenerateClientKey(), s.PublicKey(), WithRecordMAC([]byte("other")))
+	assert.NoError(t, err)
+	_, err = other.CreateVerifyPasswordRequest(pwd, rec)
+	assert.ErrorIs(t, err, ErrRecordMAC)
+	_, err = c.ForAccount([]byte("alice")).CreateVerifyPasswordRequest(pwd, rec)
+	assert.ErrorIs(t, err, ErrRecordMAC)
+
+	// a record stripped of its MAC or cut off before it is rejected
+	stripped := *rec
+	stripped.MAC = nil
+	_, err = c.CreateVerifyPasswordRequest(pwd, &stripped)
+	assert.ErrorIs(t, err, ErrRecordMAC)
+	_, err = c.CheckResponseAndDecrypt(pwd, &stripped, res)
+	assert.ErrorIs(t, err, ErrRecordMAC)
+
+	bin, err := stripped.MarshalBinary()
+	assert.NoError(t, err)
+	var truncated EnrollmentRecord
+	assert.NoError(t, truncated.UnmarshalBinary(bin))
+	_, err = c.CreateVerifyPasswordRequest(pwd, &truncated)
+	assert.ErrorIs(t, err, ErrRecordMAC)
+
+	// UpdateRecord drops the MAC, Client.UpdateRecord keeps it
+	token, newKeypair, err := Rotate(serverKeypair)
+	assert.NoError(t, err)
+	old := c
+	c, err = c.RotateNew(token, mustPublicKey(t, newKeypair))
+	assert.NoError(t, err)
+	updRec, err := UpdateRecord(rec, token)
+	assert.NoError(t, err)
+	assert.Nil(t, updRec.MAC)
+	_, err = c.CreateVerifyPasswordRequest(pwd, updRec)
+	assert.ErrorIs(t, err, ErrRecordMAC)
+	updRec, err = c.MACRecord(updRec)
+	assert.NoError(t, err)
+	assert.Len(t, updRec.MAC, RecordMACSize)
+
+	sealed, err := old.UpdateRecord(rec, token)
+	assert.NoError(t, err)
+	assert.Equal(t, updRec, sealed)
+	_, err = c.UpdateRecord(&stripped, token)
+	assert.ErrorIs(t, err, ErrRecordMAC)
+
+	// the rotated client keeps working once the old one is wiped
+	old.Wipe()
+	req, err = c.CreateVerifyPasswordRequest(pwd, updRec)
+	assert.NoError(t, err)
+	res, err = VerifyPassword(newKeypair, req)
+	assert.NoError(t, err)
+	keyDec, err = c.CheckResponseAndDecrypt(pwd, updRec, res)
+	assert.NoError(t, err)
+	assert.Equal(t, key, keyDec)
+}
+
+func TestRecordMAC_Encodings(t *testing.T) {
+	s := mustServer(t, mustKeypair(t))
+	c, err := NewClient(GenerateClientKey(), s.PublicKey(), WithRecordMAC([]byte("key")))
+	assert.NoError(t, err)
+
+	for _, version := range []uint32{0, 3} {
+		enrollment, err := s.GetEnrollment()
+		assert.NoError(t, err)
+		rec, _, err := c.EnrollAccount(pwd, enrollment)
+		assert.NoError(t, err)
+		rec.KeyVersion = version
+		rec, err = c.MACRecord(rec)
+		assert.NoError(t, err)
+
+		bin, err := rec.MarshalBinary()
+		assert.NoError(t, err)
+		var fromBin EnrollmentRecord
+		assert.NoError(t, fromBin.UnmarshalBinary(bin))
+		assert.Equal(t, rec, &fromBin)
+
+		js, err := json.Marshal(rec)
+		assert.NoError(t, err)
+		var fromJSON EnrollmentRecord
+		assert.NoError(t, json.Unmarshal(js, &fromJSON))
+		assert.Equal(t, rec, &fromJSON)
+
+		compact, err := CompactRecord(rec)
+		assert.NoError(t, err)
+		fromCompact, err := UnmarshalCompactRecord(compact)
+		assert.NoError(t, err)
+		assert.Equal(t, rec, fromCompact)
+
+		der, err := MarshalRecordDER(rec)
+		assert.NoError(t, err)
+		fromDER, err := UnmarshalRecordDER(der)
+		assert.NoError(t, err)
+		assert.Equal(t, rec, fromDER)
+
+		rec.MAC = rec.MAC[1:]
+		_, err = rec.MarshalBinary()
+		assert.Error(t, err)
+	}
+
+	// an empty key version field must be followed by something
+	enrollment, err := s.GetEnrollment()
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+	rec.MAC = nil
+	bin, err := rec.MarshalBinary()
+	assert.NoError(t, err)
+	var v EnrollmentRecord
+	assert.Error(t, v.UnmarshalBinary(append(bin, 0, 0)))
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package redisstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/redis/go-redis/v9"
+)
+
+// Cache is a read-through Redis cache in front of a slower phe.RecordStore.
+// Writes go to the backing store first and then invalidate the cached record
+type Cache struct {
+	rdb     redis.UniversalClient
+	prefix  string
+	backing phe.RecordStore
+	ttl     time.Duration
+}
+
+var _ phe.RecordStore = (*Cache)(nil)
+
+// NewCache returns a cache keeping records under prefix for ttl, zero ttl keeps them until invalidated
+func NewCache(rdb redis.UniversalClient, prefix string, backing phe.RecordStore, ttl time.Duration) *Cache {
+	return &Cache{rdb: rdb, prefix: prefix, backing: backing, ttl: ttl}
+}
+
+func (c *Cache) cacheKey(key string) string {
+	return c.prefix + "cache:" + key
+}
+
+// Get returns the cached record or reads it from the backing store and caches it.
+// Cache errors aren't fatal, the backing store is used instead
+func (c *Cache) Get(ctx context.Context, key string) (*phe.EnrollmentRecord, error) {
+	if blob, err := c.rdb.Get(ctx, c.cacheKey(key)).Bytes(); err == nil {
+		if rec, err := phe.UnmarshalCompactRecord(blob); err == nil {
+			return rec, nil
+		}
+	}
+
+	rec, err := c.backing.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if blob, err := phe.MarshalCompactRecord(rec); err == nil {
+		c.rdb.Set(ctx, c.cacheKey(key), blob, c.ttl)
+	}
+	return rec, nil
+}
+
+// Put writes the record to the backing store and invalidates the cached one
+func (c *Cache) Put(ctx context.Context, key string, rec *phe.EnrollmentRecord) error {
+	if err := c.backing.Put(ctx, key, rec); err != nil {
+		return err
+	}
+	return c.Invalidate(ctx, key)
+}
+
+// Create creates the record in the backing store and invalidates the cached one, which may only be
+// a leftover of a deleted record
+func (c *Cache) Create(ctx context.Context, key string, rec *phe.EnrollmentRecord) error {
+	if err := c.backing.Create(ctx, key, rec); err != nil {
+		return err
+	}
+	return c.Invalidate(ctx, key)
+}
+
+// Iterate reads records from the backing store bypassing the cache
+func (c *Cache) Iterate(ctx context.Context, after string, fn func(key string, rec *phe.EnrollmentRecord) error) error {
+	return c.backing.Iterate(ctx, after, fn)
+}
+
+// UpdateConditional updates the record in the backing store and invalidates the cached one
+func (c *Cache) UpdateConditional(ctx context.Context, key string, old, rec *phe.EnrollmentRecord) error {
+	if err := c.backing.UpdateConditional(ctx, key, old, rec); err != nil {
+		return err
+	}
+	return c.Invalidate(ctx, key)
+}
+
+// Invalidate drops cached records. It must be called for records written to the backing store
+// directly, e.g. by UpdateRecord based tooling which doesn't go through the cache
+func (c *Cache) Invalidate(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	cacheKeys := make([]string, len(keys))
+	for i, key := range keys {
+		cacheKeys[i] = c.cacheKey(key)
+	}
+	return c.rdb.Del(ctx, cacheKeys...).Err()
+}
+
+// Flush drops all cached records. Every record changes when server keys are rotated,
+// so the cache should be flushed once UpdateRecord was applied to the backing store
+func (c *Cache) Flush(ctx context.Context) error {
+	iter := c.rdb.Scan(ctx, 0, c.cacheKey("*"), iteratePage).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == iteratePage {
+			if err := c.rdb.Del(ctx, keys...).Err(); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	if len(keys) > 0 {
+		return c.rdb.Del(ctx, keys...).Err()
+	}
+	return nil
+}
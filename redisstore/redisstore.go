@@ -0,0 +1,229 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+// Package redisstore keeps enrollment records in Redis and provides a read-through Redis cache
+// in front of another phe.RecordStore and a replay cache shared by server instances
+package redisstore
+
+import (
+	"context"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/redis/go-redis/v9"
+)
+
+// iteratePage is the number of keys Iterate reads with a single command
+const iteratePage = 500
+
+// updateScript replaces the record with ARGV[1] only if it equals one of the expected encodings in the rest of ARGV:
+// 1 on success, 0 on conflict, -1 if missing
+var updateScript = redis.NewScript(`
+local stored = redis.call("GET", KEYS[1])
+if not stored then
+	return -1
+end
+for i = 2, #ARGV do
+	if stored == ARGV[i] then
+		redis.call("SET", KEYS[1], ARGV[1])
+		return 1
+	end
+end
+return 0
+`)
+
+// createScript stores ARGV[1] and indexes ARGV[2] only if there's no record yet: 1 on success, 0 on conflict
+var createScript = redis.NewScript(`
+if not redis.call("SET", KEYS[1], ARGV[1], "NX") then
+	return 0
+end
+redis.call("ZADD", KEYS[2], 0, ARGV[2])
+return 1
+`)
+
+// Store is a phe.RecordStore in Redis. Every record is a string key under the prefix,
+// a sorted set of record keys keeps them ordered for iteration
+type Store struct {
+	rdb    redis.UniversalClient
+	prefix string
+}
+
+var _ phe.RecordStore = (*Store)(nil)
+
+// New returns a store keeping its keys under prefix, e.g. "phe:"
+func New(rdb redis.UniversalClient, prefix string) *Store {
+	return &Store{rdb: rdb, prefix: prefix}
+}
+
+func (s *Store) recordKey(key string) string {
+	return s.prefix + "record:" + key
+}
+
+func (s *Store) indexKey() string {
+	return s.prefix + "index"
+}
+
+// Get returns the record stored under key or phe.ErrRecordNotFound
+func (s *Store) Get(ctx context.Context, key string) (*phe.EnrollmentRecord, error) {
+	blob, err := s.rdb.Get(ctx, s.recordKey(key)).Bytes()
+	if err == redis.Nil {
+		return nil, phe.ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return phe.UnmarshalCompactRecord(blob)
+}
+
+// Put stores the record under key replacing any existing one
+func (s *Store) Put(ctx context.Context, key string, rec *phe.EnrollmentRecord) error {
+	blob, err := phe.MarshalCompactRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, s.recordKey(key), blob, 0)
+		p.ZAdd(ctx, s.indexKey(), redis.Z{Member: key})
+		return nil
+	})
+	return err
+}
+
+// Create atomically stores the record under key only if there's none yet, otherwise it returns phe.ErrRecordConflict
+func (s *Store) Create(ctx context.Context, key string, rec *phe.EnrollmentRecord) error {
+	blob, err := phe.MarshalCompactRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	res, err := createScript.Run(ctx, s.rdb, []string{s.recordKey(key), s.indexKey()}, blob, key).Int()
+	if err != nil {
+		return err
+	}
+	if res == 0 {
+		return phe.ErrRecordConflict
+	}
+	return nil
+}
+
+// Iterate calls fn for records with keys greater than after in ascending key order
+func (s *Store) Iterate(ctx context.Context, after string, fn func(key string, rec *phe.EnrollmentRecord) error) error {
+	min := "-"
+	if after != "" {
+		min = "(" + after
+	}
+
+	for {
+		keys, err := s.rdb.ZRangeByLex(ctx, s.indexKey(), &redis.ZRangeBy{
+			Min:   min,
+			Max:   "+",
+			Count: iteratePage,
+		}).Result()
+		if err != nil {
+			return err
+		}
+
+		if len(keys) == 0 {
+			return nil
+		}
+
+		recordKeys := make([]string, len(keys))
+		for i, key := range keys {
+			recordKeys[i] = s.recordKey(key)
+		}
+
+		blobs, err := s.rdb.MGet(ctx, recordKeys...).Result()
+		if err != nil {
+			return err
+		}
+
+		for i, key := range keys {
+			blob, ok := blobs[i].(string)
+			if !ok {
+				// deleted since the index was read
+				continue
+			}
+
+			rec, err := phe.UnmarshalCompactRecord([]byte(blob))
+			if err != nil {
+				return err
+			}
+
+			if err = fn(key, rec); err == phe.ErrStopIteration {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+
+		if len(keys) < iteratePage {
+			return nil
+		}
+		min = "(" + keys[len(keys)-1]
+	}
+}
+
+// UpdateConditional atomically replaces the record under key only if the stored one equals old.
+// It returns phe.ErrRecordConflict if it doesn't and phe.ErrRecordNotFound if there's no record.
+// The stored record may be packed with an earlier version, it's written with the current one
+func (s *Store) UpdateConditional(ctx context.Context, key string, old, rec *phe.EnrollmentRecord) error {
+	oldBlobs, err := phe.CompactRecordEncodings(old)
+	if err != nil {
+		return err
+	}
+
+	blob, err := phe.MarshalCompactRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	args := append([]interface{}{blob}, make([]interface{}, len(oldBlobs))...)
+	for i, b := range oldBlobs {
+		args[i+1] = b
+	}
+	res, err := updateScript.Run(ctx, s.rdb, []string{s.recordKey(key)}, args...).Int()
+	if err != nil {
+		return err
+	}
+
+	switch res {
+	case 0:
+		return phe.ErrRecordConflict
+	case -1:
+		return phe.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package redisstore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/passw0rd/phe-go"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRecords(t *testing.T, n int) []*phe.EnrollmentRecord {
+	serverKeypair, err := phe.GenerateServerKeypair()
+	assert.NoError(t, err)
+	pub, err := phe.GetPublicKey(serverKeypair)
+	assert.NoError(t, err)
+	c, err := phe.NewClient(phe.GenerateClientKey(), pub)
+	assert.NoError(t, err)
+
+	records := make([]*phe.EnrollmentRecord, n)
+	for i := range records {
+		enrollment, err := phe.GetEnrollment(serverKeypair)
+		assert.NoError(t, err)
+		records[i], _, err = c.EnrollAccount([]byte("password"), enrollment)
+		assert.NoError(t, err)
+	}
+	return records
+}
+
+func newClient(t *testing.T) redis.UniversalClient {
+	mr := miniredis.RunT(t)
+	return redis.NewClient(&redis.Options{Addr: mr.Addr()})
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	s := New(newClient(t), "phe:")
+	records := newRecords(t, 2)
+
+	_, err := s.Get(ctx, "alice")
+	assert.Equal(t, phe.ErrRecordNotFound, err)
+
+	assert.NoError(t, s.Put(ctx, "alice", records[0]))
+	got, err := s.Get(ctx, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, records[0], got)
+
+	assert.Equal(t, phe.ErrRecordConflict, s.UpdateConditional(ctx, "alice", records[1], records[1]))
+	assert.NoError(t, s.UpdateConditional(ctx, "alice", records[0], records[1]))
+	assert.Equal(t, phe.ErrRecordNotFound, s.UpdateConditional(ctx, "bob", records[0], records[1]))
+
+	assert.Equal(t, phe.ErrRecordConflict, s.Create(ctx, "alice", records[0]))
+	assert.NoError(t, s.Create(ctx, "bob", records[0]))
+	got, err = s.Get(ctx, "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, records[0], got)
+	assert.Equal(t, int64(1), s.rdb.ZRem(ctx, s.indexKey(), "bob").Val())
+	assert.NoError(t, s.rdb.Del(ctx, s.recordKey("bob")).Err())
+
+	//records packed with an earlier version are matched and upgraded
+	encodings, err := phe.CompactRecordEncodings(records[0])
+	assert.NoError(t, err)
+	assert.NoError(t, s.rdb.Set(ctx, s.recordKey("carol"), encodings[1], 0).Err())
+	got, err = s.Get(ctx, "carol")
+	assert.NoError(t, err)
+	assert.Equal(t, records[0], got)
+	assert.NoError(t, s.UpdateConditional(ctx, "carol", records[0], records[1]))
+	stored, err := s.rdb.Get(ctx, s.recordKey("carol")).Bytes()
+	assert.NoError(t, err)
+	assert.Equal(t, phe.CompactRecordVersion, int(stored[0]))
+	assert.NoError(t, s.rdb.Del(ctx, s.recordKey("carol")).Err())
+
+	for i := 0; i < iteratePage+3; i++ {
+		assert.NoError(t, s.Put(ctx, fmt.Sprintf("user%04d", i), records[0]))
+	}
+
+	var keys []string
+	assert.NoError(t, s.Iterate(ctx, "", func(key string, rec *phe.EnrollmentRecord) error {
+		keys = append(keys, key)
+		return nil
+	}))
+	assert.Len(t, keys, iteratePage+4)
+	assert.Equal(t, "alice", keys[0])
+	assert.Equal(t, "user0000", keys[1])
+
+	page, err := phe.StoreRecords{RecordStore: s}.Records(ctx, "alice", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "user0001", page[1].Key)
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	rdb := newClient(t)
+	backing := New(rdb, "slow:")
+	c := NewCache(rdb, "fast:", backing, 0)
+	records := newRecords(t, 2)
+
+	assert.NoError(t, c.Put(ctx, "alice", records[0]))
+	got, err := c.Get(ctx, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, records[0], got)
+
+	//updates bypassing the cache are served stale until invalidated
+	assert.NoError(t, backing.Put(ctx, "alice", records[1]))
+	got, err = c.Get(ctx, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, records[0], got)
+
+	assert.NoError(t, c.Invalidate(ctx, "alice"))
+	got, err = c.Get(ctx, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, records[1], got)
+
+	assert.NoError(t, c.UpdateConditional(ctx, "alice", records[1], records[0]))
+	got, err = c.Get(ctx, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, records[0], got)
+
+	assert.NoError(t, backing.Put(ctx, "alice", records[1]))
+	assert.NoError(t, c.Flush(ctx))
+	got, err = c.Get(ctx, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, records[1], got)
+
+	_, err = c.Get(ctx, "bob")
+	assert.Equal(t, phe.ErrRecordNotFound, err)
+}
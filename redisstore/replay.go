@@ -0,0 +1,69 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package redisstore
+
+import (
+	"context"
+	"encoding/hex"
+	"time"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/redis/go-redis/v9"
+)
+
+// ReplayCache is a phe.ReplayCache in Redis shared by all server instances
+type ReplayCache struct {
+	rdb    redis.UniversalClient
+	prefix string
+	ttl    time.Duration
+}
+
+var _ phe.ReplayCache = (*ReplayCache)(nil)
+
+// NewReplayCache returns a replay cache keeping requests under prefix for ttl
+func NewReplayCache(rdb redis.UniversalClient, prefix string, ttl time.Duration) *ReplayCache {
+	return &ReplayCache{rdb: rdb, prefix: prefix, ttl: ttl}
+}
+
+// Seen implements phe.ReplayCache with SET NX, the request is seen if the key already exists
+func (c *ReplayCache) Seen(ctx context.Context, key []byte) (bool, error) {
+	added, err := c.rdb.SetNX(ctx, c.prefix+"replay:"+hex.EncodeToString(key), 1, c.ttl).Result()
+	if err != nil {
+		return false, err
+	}
+	return !added, nil
+}
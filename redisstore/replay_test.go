@@ -0,0 +1,30 @@
+package redisstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayCache(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	c := NewReplayCache(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "phe:", time.Minute)
+
+	seen, err := c.Seen(ctx, []byte{1, 2})
+	assert.NoError(t, err)
+	assert.False(t, seen)
+	seen, err = c.Seen(ctx, []byte{1, 2})
+	assert.NoError(t, err)
+	assert.True(t, seen)
+	assert.True(t, mr.Exists("phe:replay:0102"))
+
+	mr.FastForward(time.Minute)
+	seen, err = c.Seen(ctx, []byte{1, 2})
+	assert.NoError(t, err)
+	assert.False(t, seen)
+}
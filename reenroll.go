@@ -0,0 +1,88 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/pkg/errors"
+)
+
+// IsRecordMismatch reports whether err is a verification failure caused by a record made with other keys than
+// the ones in use rather than by a wrong password, see KeyVersionError. Only versioned keys tell the two apart
+func IsRecordMismatch(err error) bool {
+	return errors.Is(err, ErrKeyVersionMismatch)
+}
+
+// RecoverRecord re-enrolls an account whose record failed verification with verifyErr because it was made with other
+// keys than c's, so that the account gets a record consistent with the keys in use. The password is verified against
+// the record first with old, a client of the keys the record was made with, and verify, which asks the server holding
+// the matching keypair, e.g. a Keyring. The account keeps its encryption key, so data encrypted before stays readable.
+// If the password is wrong key is nil and no record is made. verifyErr is returned as is if it's not a record mismatch
+func (c *Client) RecoverRecord(ctx context.Context, password []byte, rec *EnrollmentRecord, verifyErr error, old *Client,
+	verify func(ctx context.Context, req *VerifyPasswordRequest) (*VerifyPasswordResponse, error),
+	enrollment *EnrollmentResponse) (newRec *EnrollmentRecord, key []byte, err error) {
+	if !IsRecordMismatch(verifyErr) {
+		return nil, nil, verifyErr
+	}
+	if old == nil || verify == nil {
+		return nil, nil, errors.New("keys the record was made with are required to recover it")
+	}
+
+	req, err := old.CreateVerifyPasswordRequest(password, rec)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := verify(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	secret, err := old.RecoverAccountSecret(password, rec, resp)
+	if err != nil || secret == nil {
+		return nil, nil, err
+	}
+	defer Wipe(secret)
+
+	if newRec, key, err = c.EnrollAccountWithKey(password, enrollment, secret); err != nil {
+		return nil, nil, err
+	}
+
+	c.logger.LogAttrs(ctx, slog.LevelWarn, "phe: record re-enrolled after key mismatch", slog.Uint64("key_version", uint64(newRec.KeyVersion)),
+		slog.Uint64("record_key_version", uint64(rec.KeyVersion)), slog.String("ns", Fingerprint(rec.NS)))
+	return newRec, key, nil
+}
@@ -0,0 +1,68 @@
+package phe
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_RecoverRecord(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	k, err := NewKeyring(1, serverKeypair)
+	assert.NoError(t, err)
+	old, err := NewClient(GenerateClientKey(), k.Current().PublicKey(), WithClientKeyVersion(1))
+	assert.NoError(t, err)
+
+	enrollment, err := k.GetEnrollment()
+	assert.NoError(t, err)
+	rec, key, err := old.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	// the keys are rotated but the record is left behind
+	token, _, err := k.Rotate()
+	assert.NoError(t, err)
+	c, err := old.RotateNew(token, k.Current().PublicKey())
+	assert.NoError(t, err)
+
+	_, verifyErr := c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.True(t, IsRecordMismatch(verifyErr))
+	assert.False(t, IsRecordMismatch(ErrInvalidProof))
+
+	ctx := context.Background()
+	verify := func(ctx context.Context, req *VerifyPasswordRequest) (*VerifyPasswordResponse, error) {
+		return k.VerifyPassword(req)
+	}
+	enrollment, err = k.GetEnrollment()
+	assert.NoError(t, err)
+	_, _, err = c.RecoverRecord(ctx, pwd, rec, ErrInvalidProof, old, verify, enrollment)
+	assert.True(t, errors.Is(err, ErrInvalidProof))
+	_, _, err = c.RecoverRecord(ctx, pwd, rec, verifyErr, nil, verify, enrollment)
+	assert.Error(t, err)
+
+	// a wrong password isn't enrolled
+	newRec, newKey, err := c.RecoverRecord(ctx, []byte("wrong"), rec, verifyErr, old, verify, enrollment)
+	assert.NoError(t, err)
+	assert.Nil(t, newRec)
+	assert.Nil(t, newKey)
+
+	// the old keypair is gone, the password can't be checked
+	assert.NoError(t, k.Retire(1))
+	_, _, err = c.RecoverRecord(ctx, pwd, rec, verifyErr, old, verify, enrollment)
+	assert.True(t, IsRecordMismatch(err))
+	assert.NoError(t, k.Add(1, serverKeypair))
+
+	newRec, newKey, err = c.RecoverRecord(ctx, pwd, rec, verifyErr, old, verify, enrollment)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), newRec.KeyVersion)
+	assert.Equal(t, key, newKey)
+
+	req, err := c.CreateVerifyPasswordRequest(pwd, newRec)
+	assert.NoError(t, err)
+	res, err := k.VerifyPassword(req)
+	assert.NoError(t, err)
+	keyDec, err := c.CheckResponseAndDecrypt(pwd, newRec, res)
+	assert.NoError(t, err)
+	assert.Equal(t, key, keyDec)
+}
@@ -0,0 +1,212 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxRemoteResponse bounds response bodies read from a remote server
+const maxRemoteResponse = 64 << 10
+
+// RemoteServer calls a PHE server served by package phehttp. Its responses are checked by Client
+// like local ones, so the transport only has to be trusted for availability
+type RemoteServer struct {
+	base   *url.URL
+	client *http.Client
+	header http.Header
+}
+
+// RemoteOption configures optional RemoteServer behavior
+type RemoteOption func(*RemoteServer)
+
+// WithHTTPClient sets the client requests are made with, http.DefaultClient by default
+func WithHTTPClient(c *http.Client) RemoteOption {
+	return func(r *RemoteServer) {
+		r.client = c
+	}
+}
+
+// WithHeader adds a header to every request, e.g. for authentication
+func WithHeader(key, value string) RemoteOption {
+	return func(r *RemoteServer) {
+		r.header.Add(key, value)
+	}
+}
+
+// RemoteError is an error response of the remote server
+type RemoteError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *RemoteError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("remote server: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return "remote server: " + e.Message
+}
+
+// Is makes errors.Is(err, ErrRecordExpired) true for expired records reported by the server
+func (e *RemoteError) Is(target error) bool {
+	return target == ErrRecordExpired && e.Code == "record_expired"
+}
+
+// NewRemoteServer creates a client for the server mounted at baseURL
+func NewRemoteServer(baseURL string, opts ...RemoteOption) (*RemoteServer, error) {
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, errors.New("invalid server url")
+	}
+
+	r := &RemoteServer{
+		base:   base,
+		client: http.DefaultClient,
+		header: make(http.Header),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r, nil
+}
+
+// PublicKey returns marshaled server public key
+func (r *RemoteServer) PublicKey(ctx context.Context) ([]byte, error) {
+	var resp struct {
+		PublicKey []byte `json:"public_key"`
+	}
+	if err := r.call(ctx, http.MethodGet, "/public-key", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.PublicKey, nil
+}
+
+// GetEnrollment requests a new enrollment response, see GetEnrollment
+func (r *RemoteServer) GetEnrollment(ctx context.Context) (*EnrollmentResponse, error) {
+	resp := &EnrollmentResponse{}
+	if err := r.call(ctx, http.MethodPost, "/enroll", nil, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// VerifyPassword sends a password attempt, see VerifyPassword
+func (r *RemoteServer) VerifyPassword(ctx context.Context, req *VerifyPasswordRequest) (*VerifyPasswordResponse, error) {
+	if req == nil {
+		return nil, errors.New("Invalid password verify request")
+	}
+	resp := &VerifyPasswordResponse{}
+	if err := r.call(ctx, http.MethodPost, "/verify", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Rotate rotates server keypair if the server allows it and returns the update token. phehttp serves rotation
+// apart from the protocol, so this is usually called on a RemoteServer for the admin endpoint
+func (r *RemoteServer) Rotate(ctx context.Context) (*UpdateToken, error) {
+	var resp struct {
+		Token *UpdateToken `json:"token"`
+	}
+	if err := r.call(ctx, http.MethodPost, "/rotate", nil, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Token == nil {
+		return nil, errors.New("invalid update token")
+	}
+	return resp.Token, nil
+}
+
+func (r *RemoteServer) call(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, r.base.String()+path, body)
+	if err != nil {
+		return err
+	}
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteResponse))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		e := &RemoteError{StatusCode: resp.StatusCode}
+		var body struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &body) == nil {
+			e.Code, e.Message = body.Code, body.Message
+		}
+		return e
+	}
+
+	if err = json.Unmarshal(data, out); err != nil {
+		return errors.Wrap(err, "invalid server response")
+	}
+	return nil
+}
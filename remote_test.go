@@ -0,0 +1,79 @@
+package phe
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// remoteStub serves a Server the way package phehttp does
+func remoteStub(t *testing.T, s *Server) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /public-key", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secret", r.Header.Get("Authorization"))
+		json.NewEncoder(w).Encode(map[string][]byte{"public_key": s.PublicKey()})
+	})
+	mux.HandleFunc("POST /enroll", func(w http.ResponseWriter, r *http.Request) {
+		resp, err := s.GetEnrollment()
+		assert.NoError(t, err)
+		json.NewEncoder(w).Encode(resp)
+	})
+	mux.HandleFunc("POST /verify", func(w http.ResponseWriter, r *http.Request) {
+		req := &VerifyPasswordRequest{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(req))
+		resp, err := s.VerifyPassword(req)
+		if errors.Is(err, ErrRecordExpired) {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"code": "record_expired", "message": err.Error()})
+			return
+		}
+		assert.NoError(t, err)
+		json.NewEncoder(w).Encode(resp)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRemoteServer(t *testing.T) {
+	ctx := context.Background()
+	kp, err := GenerateServerKeypair()
+	assert.NoError(t, err)
+	s, err := NewServer(kp, WithRecordLifetime(time.Nanosecond))
+	assert.NoError(t, err)
+	srv := remoteStub(t, s)
+
+	remote, err := NewRemoteServer(srv.URL+"/", WithHTTPClient(srv.Client()), WithHeader("Authorization", "secret"))
+	assert.NoError(t, err)
+
+	pub, err := remote.PublicKey(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, s.PublicKey(), pub)
+
+	c, err := NewClient(GenerateClientKey(), pub)
+	assert.NoError(t, err)
+	enrollment, err := remote.GetEnrollment(ctx)
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccount([]byte("password"), enrollment)
+	assert.NoError(t, err)
+
+	req, err := c.CreateVerifyPasswordRequest([]byte("password"), rec)
+	assert.NoError(t, err)
+	time.Sleep(time.Second)
+	_, err = remote.VerifyPassword(ctx, req)
+	assert.True(t, errors.Is(err, ErrRecordExpired))
+
+	var re *RemoteError
+	_, err = remote.Rotate(ctx)
+	assert.True(t, errors.As(err, &re))
+	assert.Equal(t, http.StatusNotFound, re.StatusCode)
+
+	_, err = NewRemoteServer("ftp://example.com")
+	assert.Error(t, err)
+}
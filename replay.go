@@ -0,0 +1,191 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"container/list"
+	"context"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrReplayedRequest is returned by Server.VerifyPassword for a request it has already seen within the replay window,
+// or whose nonce is older than the window
+var ErrReplayedRequest = errors.New("replayed password verify request")
+
+// ErrMissingNonce is returned by a server with replay protection for a request without a nonce, see WithRequestNonce
+var ErrMissingNonce = errors.New("password verify request has no nonce")
+
+// RequestNonceSize is the length of VerifyPasswordRequest.Nonce: the 8-byte Unix time it was made followed by 16 random bytes
+const RequestNonceSize = 24
+
+var replayDomain = []byte("ReplayCache")
+
+// ReplayCache remembers password verify requests for a while. Seen records key and reports whether it
+// was already there and hasn't expired. It must be safe for concurrent use
+type ReplayCache interface {
+	Seen(ctx context.Context, key []byte) (bool, error)
+}
+
+// WithRequestNonce makes the client put a fresh nonce into every verify request. Servers with replay protection
+// only accept requests whose nonce was made within their window, the random part merely tells retries apart
+func WithRequestNonce() ClientOption {
+	return func(c *Client) {
+		c.requestNonce = true
+	}
+}
+
+// WithReplayProtection makes the server refuse replays of verify requests with ErrReplayedRequest. Requests must carry
+// a nonce made by a client with WithRequestNonce less than window ago, the cache must remember them for at least twice
+// the window so clock skew doesn't let a replay through. Requests are remembered by NS, C0 and key version only,
+// so changing the nonce doesn't get a replay through and a login repeated within the window is refused as well
+func WithReplayProtection(cache ReplayCache, window time.Duration) ServerOption {
+	return func(s *Server) {
+		s.replay = cache
+		s.replayWindow = window
+	}
+}
+
+// ReplayKey returns the key a verify request is remembered under in a ReplayCache. The nonce isn't part of it
+func ReplayKey(req *VerifyPasswordRequest) []byte {
+	return TupleHash([][]byte{req.NS, req.C0, binary.BigEndian.AppendUint32(nil, req.KeyVersion)}, replayDomain)
+}
+
+// newRequestNonce returns a nonce made now
+func newRequestNonce() []byte {
+	nonce := binary.BigEndian.AppendUint64(make([]byte, 0, RequestNonceSize), uint64(time.Now().Unix()))
+	nonce = nonce[:RequestNonceSize]
+	random.MustRead(nonce[8:])
+	return nonce
+}
+
+// checkReplay checks the request's nonce is fresh and records the request in the replay cache if there's one
+func (s *Server) checkReplay(req *VerifyPasswordRequest) error {
+	if s.replay == nil {
+		return nil
+	}
+
+	if len(req.Nonce) != RequestNonceSize {
+		return ErrMissingNonce
+	}
+	made := time.Unix(int64(binary.BigEndian.Uint64(req.Nonce)), 0)
+	if age := time.Since(made); age > s.replayWindow || age < -s.replayWindow {
+		return ErrReplayedRequest
+	}
+
+	seen, err := s.replay.Seen(context.Background(), ReplayKey(req))
+	if err != nil {
+		return errors.Wrap(err, "replay cache failed")
+	}
+	if seen {
+		return ErrReplayedRequest
+	}
+	return nil
+}
+
+// MemoryReplayCache is an in-process ReplayCache for a single server instance
+type MemoryReplayCache struct {
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // oldest first
+}
+
+type replayEntry struct {
+	key     string
+	expires time.Time
+}
+
+var _ ReplayCache = (*MemoryReplayCache)(nil)
+
+// NewMemoryReplayCache returns a cache remembering requests for ttl. When it holds maxEntries requests
+// the oldest one is forgotten early, so maxEntries must cover the peak request rate times ttl.
+// Zero maxEntries means no limit
+func NewMemoryReplayCache(ttl time.Duration, maxEntries int) *MemoryReplayCache {
+	return &MemoryReplayCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Seen implements ReplayCache
+func (c *MemoryReplayCache) Seen(_ context.Context, key []byte) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.expire(now)
+
+	if _, ok := c.entries[string(key)]; ok {
+		return true, nil
+	}
+
+	if c.maxEntries > 0 && c.order.Len() >= c.maxEntries {
+		c.remove(c.order.Front())
+	}
+	c.entries[string(key)] = c.order.PushBack(&replayEntry{key: string(key), expires: now.Add(c.ttl)})
+	return false, nil
+}
+
+// Len returns the number of remembered requests
+func (c *MemoryReplayCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire(c.now())
+	return c.order.Len()
+}
+
+// expire drops entries from the front, all of them were added with the same ttl so the front expires first
+func (c *MemoryReplayCache) expire(now time.Time) {
+	for e := c.order.Front(); e != nil && !now.Before(e.Value.(*replayEntry).expires); e = c.order.Front() {
+		c.remove(e)
+	}
+}
+
+func (c *MemoryReplayCache) remove(e *list.Element) {
+	delete(c.entries, e.Value.(*replayEntry).key)
+	c.order.Remove(e)
+}
@@ -0,0 +1,131 @@
+package phe
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayProtection(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	server, err := NewServer(serverKeypair, WithReplayProtection(NewMemoryReplayCache(2*time.Minute, 0), time.Minute))
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), server.PublicKey(), WithRequestNonce())
+	assert.NoError(t, err)
+
+	enrollment, err := server.GetEnrollment()
+	assert.NoError(t, err)
+	rec, key, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	assert.Len(t, req.Nonce, RequestNonceSize)
+	resp, err := server.VerifyPassword(req)
+	assert.NoError(t, err)
+	decrypted, err := c.CheckResponseAndDecrypt(pwd, rec, resp)
+	assert.NoError(t, err)
+	assert.Equal(t, key, decrypted)
+
+	_, err = server.VerifyPassword(req)
+	assert.Equal(t, ErrReplayedRequest, err)
+
+	//a fresh nonce doesn't get the same request through within the window
+	retry, err := c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	assert.NotEqual(t, req.Nonce, retry.Nonce)
+	assert.Equal(t, ReplayKey(req), ReplayKey(retry))
+	_, err = server.VerifyPassword(retry)
+	assert.Equal(t, ErrReplayedRequest, err)
+
+	//once the cache forgets the request the login goes through again
+	cache := NewMemoryReplayCache(2*time.Minute, 0)
+	server, err = NewServer(serverKeypair, WithReplayProtection(cache, time.Minute))
+	assert.NoError(t, err)
+	_, err = server.VerifyPassword(req)
+	assert.NoError(t, err)
+	cache.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	resp, err = server.VerifyPassword(retry)
+	assert.NoError(t, err)
+	decrypted, err = c.CheckResponseAndDecrypt(pwd, rec, resp)
+	assert.NoError(t, err)
+	assert.Equal(t, key, decrypted)
+
+	//wrong passwords are other requests
+	req, err = c.CreateVerifyPasswordRequest([]byte("wrong"), rec)
+	assert.NoError(t, err)
+	_, err = server.VerifyPassword(req)
+	assert.NoError(t, err)
+	_, err = server.VerifyPassword(req)
+	assert.Equal(t, ErrReplayedRequest, err)
+
+	//requests made outside the window can't be told from replays
+	stale, err := c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	binary.BigEndian.PutUint64(stale.Nonce, uint64(time.Now().Add(-2*time.Minute).Unix()))
+	_, err = server.VerifyPassword(stale)
+	assert.Equal(t, ErrReplayedRequest, err)
+	binary.BigEndian.PutUint64(stale.Nonce, uint64(time.Now().Add(2*time.Minute).Unix()))
+	_, err = server.VerifyPassword(stale)
+	assert.Equal(t, ErrReplayedRequest, err)
+
+	//the nonce survives the encodings
+	data, err := retry.MarshalBinary()
+	assert.NoError(t, err)
+	var decoded VerifyPasswordRequest
+	assert.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, retry.Nonce, decoded.Nonce)
+	data, err = retry.MarshalJSON()
+	assert.NoError(t, err)
+	decoded = VerifyPasswordRequest{}
+	assert.NoError(t, decoded.UnmarshalJSON(data))
+	assert.Equal(t, retry.Nonce, decoded.Nonce)
+
+	//requests without a nonce are refused
+	plain, err := NewClient(GenerateClientKey(), server.PublicKey())
+	assert.NoError(t, err)
+	rec, _, err = plain.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+	req, err = plain.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	assert.Nil(t, req.Nonce)
+	_, err = server.VerifyPassword(req)
+	assert.Equal(t, ErrMissingNonce, err)
+
+	//malformed requests aren't remembered
+	_, err = server.VerifyPassword(&VerifyPasswordRequest{NS: rec.NS, C0: []byte{4}, Nonce: retry.Nonce})
+	assert.Error(t, err)
+	_, err = server.VerifyPassword(&VerifyPasswordRequest{NS: rec.NS, C0: []byte{4}, Nonce: retry.Nonce})
+	assert.NotEqual(t, ErrReplayedRequest, err)
+}
+
+func TestMemoryReplayCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+	cache := NewMemoryReplayCache(time.Minute, 2)
+	cache.now = func() time.Time { return now }
+
+	seen := func(key string) bool {
+		ok, err := cache.Seen(ctx, []byte(key))
+		assert.NoError(t, err)
+		return ok
+	}
+
+	assert.False(t, seen("a"))
+	assert.True(t, seen("a"))
+	now = now.Add(30 * time.Second)
+	assert.False(t, seen("b"))
+	assert.Equal(t, 2, cache.Len())
+
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, 1, cache.Len())
+	assert.False(t, seen("a"))
+
+	//the oldest entry is evicted when full
+	assert.False(t, seen("c"))
+	assert.Equal(t, 2, cache.Len())
+	assert.False(t, seen("b"))
+}
@@ -0,0 +1,401 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package ristretto
+
+import (
+	"crypto/hmac"
+
+	"github.com/gtank/ristretto255"
+	"github.com/passw0rd/phe-go"
+	"github.com/passw0rd/phe-go/internal/rng"
+	"github.com/passw0rd/phe-go/internal/wire"
+	"github.com/pkg/errors"
+)
+
+// Client is responsible for protecting & checking passwords at the client (website) side
+type Client struct {
+	clientPrivateKey     *ristretto255.Scalar
+	serverPublicKey      *ristretto255.Element
+	serverPublicKeyBytes []byte
+	keyVersion           uint32
+	macKey               []byte
+}
+
+// ClientOption configures a Client
+type ClientOption func(*Client)
+
+// WithClientKeyVersion sets the version of client's keys, see phe.WithClientKeyVersion
+func WithClientKeyVersion(v uint32) ClientOption {
+	return func(c *Client) {
+		c.keyVersion = v
+	}
+}
+
+// WithRecordMAC makes the client authenticate its records with phe.RecordMAC, see phe.WithRecordMAC
+func WithRecordMAC(macKey []byte) ClientOption {
+	return func(c *Client) {
+		c.macKey = append([]byte{}, macKey...)
+	}
+}
+
+// GenerateClientKey creates a new random key used on the Client side
+func GenerateClientKey() []byte {
+	return randomZ().Encode(nil)
+}
+
+// NewClient creates new client instance using client's private key and server's public key used for verification
+func NewClient(privateKey []byte, serverPublicKey []byte, opts ...ClientOption) (*Client, error) {
+	y, err := scalarUnmarshal(privateKey)
+	if err != nil {
+		return nil, errors.New("invalid private key")
+	}
+
+	pub, err := pointUnmarshal(serverPublicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid public key")
+	}
+
+	c := &Client{
+		clientPrivateKey:     y,
+		serverPublicKey:      pub,
+		serverPublicKeyBytes: pub.Encode(nil),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// KeyVersion returns the version of client's keys, 0 if they aren't versioned
+func (c *Client) KeyVersion() uint32 {
+	return c.keyVersion
+}
+
+// EnrollAccount uses fresh Enrollment Response and user's password (or its hash) to create a new Enrollment Record which
+// is then supposed to be stored in a database
+// it also generates a random encryption key which can be used to protect user's data
+func (c *Client) EnrollAccount(password []byte, resp *phe.EnrollmentResponse) (rec *phe.EnrollmentRecord, key []byte, err error) {
+	if resp == nil {
+		return nil, nil, errors.New("invalid proof")
+	}
+
+	if err = phe.CheckKeyVersion(c.keyVersion, resp.KeyVersion); err != nil {
+		return
+	}
+
+	c0, err := pointUnmarshal(resp.C0)
+	if err != nil {
+		return
+	}
+
+	c1, err := pointUnmarshal(resp.C1)
+	if err != nil {
+		return
+	}
+
+	if !c.validateProofOfSuccess(resp.Proof, resp.NS, c0, c1) {
+		return nil, nil, errors.New("invalid proof")
+	}
+
+	// client nonce and 2 points
+	nc := make([]byte, 32)
+	rng.Default.MustRead(nc)
+	hc0 := hashToPoint(dhc0, nc, password)
+	hc1 := hashToPoint(dhc1, nc, password)
+
+	// encryption key in a form of a random point
+	mBuf := make([]byte, 32)
+	rng.Default.MustRead(mBuf)
+	m := hashToPoint(dm, mBuf)
+
+	t0 := add(c0, mul(hc0, c.clientPrivateKey))
+	t1 := add(add(c1, mul(hc1, c.clientPrivateKey)), mul(m, c.clientPrivateKey))
+
+	rec = &phe.EnrollmentRecord{
+		NS:         resp.NS,
+		NC:         nc,
+		T0:         t0.Encode(nil),
+		T1:         t1.Encode(nil),
+		KeyVersion: resp.KeyVersion,
+	}
+	if rec.KeyVersion == 0 {
+		rec.KeyVersion = c.keyVersion
+	}
+	c.sealRecord(rec)
+	return rec, deriveKey(m), nil
+}
+
+func (c *Client) validateProofOfSuccess(proof *phe.ProofOfSuccess, nonce []byte, c0, c1 *ristretto255.Element) bool {
+	if proof == nil {
+		return false
+	}
+
+	term1, err1 := pointUnmarshal(proof.Term1)
+	term2, err2 := pointUnmarshal(proof.Term2)
+	term3, err3 := pointUnmarshal(proof.Term3)
+	blindX, err4 := scalarUnmarshal(proof.BlindX)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return false
+	}
+
+	hs0 := hashToPoint(dhs0, nonce)
+	hs1 := hashToPoint(dhs1, nonce)
+
+	challenge := hashZ(proofOk, c.serverPublicKeyBytes, gBytes, c0.Encode(nil), c1.Encode(nil), proof.Term1, proof.Term2, proof.Term3)
+
+	return equal(add(term1, mul(c0, challenge)), mul(hs0, blindX)) &&
+		equal(add(term2, mul(c1, challenge)), mul(hs1, blindX)) &&
+		equal(add(term3, mul(c.serverPublicKey, challenge)), baseMul(blindX))
+}
+
+func (c *Client) validateProofOfFail(proof *phe.ProofOfFail, c0, c1, hs0 *ristretto255.Element) error {
+	if proof == nil {
+		return errors.New("invalid proof")
+	}
+
+	term1, err1 := pointUnmarshal(proof.Term1)
+	term2, err2 := pointUnmarshal(proof.Term2)
+	term3, err3 := pointUnmarshal(proof.Term3)
+	term4, err4 := pointUnmarshal(proof.Term4)
+	blindA, err5 := scalarUnmarshal(proof.BlindA)
+	blindB, err6 := scalarUnmarshal(proof.BlindB)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || err5 != nil || err6 != nil {
+		return errors.New("invalid proof")
+	}
+
+	challenge := hashZ(proofError, c.serverPublicKeyBytes, gBytes, c0.Encode(nil), c1.Encode(nil), proof.Term1, proof.Term2, proof.Term3, proof.Term4)
+
+	if !equal(add(add(term1, term2), mul(c1, challenge)), add(mul(c0, blindA), mul(hs0, blindB))) {
+		return errors.New("proof verification failed")
+	}
+
+	if !equal(add(term3, term4), add(mul(c.serverPublicKey, blindA), baseMul(blindB))) {
+		return errors.New("verification failed")
+	}
+	return nil
+}
+
+// CreateVerifyPasswordRequest creates a request in a form of a group element which is then need to be validated at the server side
+func (c *Client) CreateVerifyPasswordRequest(password []byte, rec *phe.EnrollmentRecord) (*phe.VerifyPasswordRequest, error) {
+	t0, _, err := c.checkRecord(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	c0 := sub(t0, mul(hashToPoint(dhc0, rec.NC, password), c.clientPrivateKey))
+	return &phe.VerifyPasswordRequest{
+		C0:         c0.Encode(nil),
+		NS:         rec.NS,
+		KeyVersion: rec.KeyVersion,
+	}, nil
+}
+
+// CheckResponseAndDecrypt verifies server's answer and extracts data encryption key on success
+func (c *Client) CheckResponseAndDecrypt(password []byte, rec *phe.EnrollmentRecord, resp *phe.VerifyPasswordResponse) ([]byte, error) {
+	if resp == nil {
+		return nil, errors.New("invalid response")
+	}
+
+	t0, t1, err := c.checkRecord(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	c1, err := pointUnmarshal(resp.C1)
+	if err != nil {
+		return nil, err
+	}
+
+	hc0 := hashToPoint(dhc0, rec.NC, password)
+	hc1 := hashToPoint(dhc1, rec.NC, password)
+
+	c0 := sub(t0, mul(hc0, c.clientPrivateKey))
+
+	if resp.Res {
+		if !c.validateProofOfSuccess(resp.ProofSuccess, rec.NS, c0, c1) {
+			return nil, errors.New("result is ok but proof is invalid")
+		}
+
+		// m = (t1 - c1 - hc1 * y) * y^-1
+		m := sub(sub(t1, c1), mul(hc1, c.clientPrivateKey))
+		m = mul(m, ristretto255.NewScalar().Invert(c.clientPrivateKey))
+		return deriveKey(m), nil
+	}
+
+	return nil, c.validateProofOfFail(resp.ProofFail, c0, c1, hashToPoint(dhs0, rec.NS))
+}
+
+// Rotate updates client's secret key and server's public key with server's update token
+func (c *Client) Rotate(token *phe.UpdateToken) error {
+	return c.rotate(token, nil, false)
+}
+
+// RotateChecked is like Rotate but newServerPublicKey is the public key the server has rotated to,
+// nothing changes and *phe.RotationError is returned if the token doesn't lead to it
+func (c *Client) RotateChecked(token *phe.UpdateToken, newServerPublicKey []byte) error {
+	return c.rotate(token, newServerPublicKey, true)
+}
+
+func (c *Client) rotate(token *phe.UpdateToken, newServerPublicKey []byte, check bool) error {
+	a, b, err := parseToken(token)
+	if err != nil {
+		return err
+	}
+	if err = token.CheckUse(c.serverPublicKeyBytes, c.keyVersion); err != nil {
+		return err
+	}
+
+	pub := add(mul(c.serverPublicKey, a), baseMul(b))
+	if check {
+		if expected, err := pointUnmarshal(newServerPublicKey); err != nil || !equal(expected, pub) {
+			return &phe.RotationError{
+				OldPublicKey: append([]byte{}, c.serverPublicKeyBytes...),
+				NewPublicKey: append([]byte{}, newServerPublicKey...),
+			}
+		}
+	}
+
+	c.clientPrivateKey = ristretto255.NewScalar().Multiply(c.clientPrivateKey, a)
+	c.serverPublicKey = pub
+	c.serverPublicKeyBytes = pub.Encode(nil)
+	c.keyVersion = token.NextVersion(c.keyVersion)
+	return nil
+}
+
+// UpdateRecord checks the record's MAC, applies the update token to it like UpdateRecord does and authenticates
+// the result again, see phe.Client.UpdateRecord
+func (c *Client) UpdateRecord(rec *phe.EnrollmentRecord, token *phe.UpdateToken) (*phe.EnrollmentRecord, error) {
+	if err := c.checkRecordMAC(rec); err != nil {
+		return nil, err
+	}
+
+	updRec, err := UpdateRecord(rec, token)
+	if err != nil {
+		return nil, err
+	}
+	c.sealRecord(updRec)
+	return updRec, nil
+}
+
+// UpdateRecord needs to be applied to every database record to correspond to new private and public keys.
+// Like phe.UpdateRecord it drops the record's MAC and refuses expired tokens and tokens for another key version
+func UpdateRecord(rec *phe.EnrollmentRecord, token *phe.UpdateToken) (*phe.EnrollmentRecord, error) {
+	t0, t1, err := parseRecord(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	a, b, err := parseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if err = token.CheckUse(nil, rec.KeyVersion); err != nil {
+		return nil, err
+	}
+
+	hs0 := hashToPoint(dhs0, rec.NS)
+	hs1 := hashToPoint(dhs1, rec.NS)
+
+	return &phe.EnrollmentRecord{
+		T0:         add(mul(t0, a), mul(hs0, b)).Encode(nil),
+		T1:         add(mul(t1, a), mul(hs1, b)).Encode(nil),
+		NS:         rec.NS,
+		NC:         rec.NC,
+		KeyVersion: token.NextVersion(rec.KeyVersion),
+	}, nil
+}
+
+// RotateClientKeys returns a new pair of keys given old keys and an update token
+func RotateClientKeys(clientPrivate, serverPublic []byte, token *phe.UpdateToken) (newClientPrivate, newServerPublic []byte, err error) {
+	c, err := NewClient(clientPrivate, serverPublic)
+	if err != nil {
+		return
+	}
+
+	if err = c.Rotate(token); err != nil {
+		return
+	}
+
+	return c.clientPrivateKey.Encode(nil), c.serverPublicKeyBytes, nil
+}
+
+// checkRecord checks the record's MAC and key version and parses it
+func (c *Client) checkRecord(rec *phe.EnrollmentRecord) (t0, t1 *ristretto255.Element, err error) {
+	if err = c.checkRecordMAC(rec); err != nil {
+		return nil, nil, err
+	}
+	if t0, t1, err = parseRecord(rec); err != nil {
+		return nil, nil, err
+	}
+	if err = phe.CheckKeyVersion(c.keyVersion, rec.KeyVersion); err != nil {
+		return nil, nil, err
+	}
+	return
+}
+
+// sealRecord sets the MAC of a record the client has just made
+func (c *Client) sealRecord(rec *phe.EnrollmentRecord) {
+	if c.macKey != nil {
+		rec.MAC = phe.RecordMAC(c.macKey, nil, rec)
+	}
+}
+
+// checkRecordMAC verifies the record's MAC if the client has the key, a missing MAC fails as well
+func (c *Client) checkRecordMAC(rec *phe.EnrollmentRecord) error {
+	if c.macKey == nil || rec == nil {
+		return nil
+	}
+	if rec.MAC == nil || !hmac.Equal(rec.MAC, phe.RecordMAC(c.macKey, nil, rec)) {
+		return phe.ErrRecordMAC
+	}
+	return nil
+}
+
+func parseRecord(rec *phe.EnrollmentRecord) (t0, t1 *ristretto255.Element, err error) {
+	if rec == nil || wire.Nonce(rec.NC) != nil || wire.Nonce(rec.NS) != nil {
+		return nil, nil, errors.New("invalid record")
+	}
+
+	if t0, err = pointUnmarshal(rec.T0); err != nil {
+		return nil, nil, errors.New("invalid record")
+	}
+
+	if t1, err = pointUnmarshal(rec.T1); err != nil {
+		return nil, nil, errors.New("invalid record")
+	}
+	return
+}
@@ -0,0 +1,193 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+// Package ristretto instantiates the PHE protocol over the ristretto255 prime-order group.
+// It mirrors the API of package phe and reuses its message types, but uses 32-byte element and
+// scalar encodings and its own domain separation, so its keys and records can't be mixed with the NIST curve ones.
+// Randomness comes from the health-checked generator of package phe, see phe.ReadRNGStats, and key versions,
+// record MACs and update token metadata are checked by the same code as in package phe.
+package ristretto
+
+import (
+	"crypto/sha512"
+	"io"
+
+	"github.com/gtank/ristretto255"
+	"github.com/passw0rd/phe-go"
+	"github.com/passw0rd/phe-go/internal/rng"
+	"github.com/passw0rd/phe-go/internal/wire"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	elementLen = 32
+	scalarLen  = 32
+	uniformLen = 64
+)
+
+var (
+	g      = ristretto255.NewElement().Base()
+	gBytes = g.Encode(nil)
+
+	//domains
+	dhc0       = []byte("ristretto255/hc0")
+	dhc1       = []byte("ristretto255/hc1")
+	dhs0       = []byte("ristretto255/hs0")
+	dhs1       = []byte("ristretto255/hs1")
+	dm         = []byte("ristretto255/m")
+	proofOk    = []byte("ristretto255/ProofOk")
+	proofError = []byte("ristretto255/ProofError")
+	secret     = []byte("ristretto255/Secret")
+)
+
+// randomZ generates a uniformly distributed random scalar
+func randomZ() *ristretto255.Scalar {
+	buf := make([]byte, uniformLen)
+	rng.Default.MustRead(buf)
+	return ristretto255.NewScalar().FromUniformBytes(buf)
+}
+
+// hashZ maps arrays of bytes to a scalar
+func hashZ(domain []byte, data ...[]byte) *ristretto255.Scalar {
+	return ristretto255.NewScalar().FromUniformBytes(uniform(domain, data))
+}
+
+// hashToPoint maps arrays of bytes to a group element
+func hashToPoint(domain []byte, data ...[]byte) *ristretto255.Element {
+	return ristretto255.NewElement().FromUniformBytes(uniform(domain, data))
+}
+
+func uniform(domain []byte, data [][]byte) []byte {
+	buf := make([]byte, uniformLen)
+	if _, err := io.ReadFull(phe.TupleKDF(data, domain), buf); err != nil {
+		panic(err)
+	}
+	return buf
+}
+
+// deriveKey derives account encryption key from the secret element m
+func deriveKey(m *ristretto255.Element) []byte {
+	kdf := hkdf.New(sha512.New512_256, m.Encode(nil), nil, secret)
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(kdf, key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// pointUnmarshal decodes canonical non-identity element
+func pointUnmarshal(data []byte) (*ristretto255.Element, error) {
+	e := ristretto255.NewElement()
+	if len(data) != elementLen || e.Decode(data) != nil || e.Equal(ristretto255.NewElement()) == 1 {
+		return nil, errors.New("invalid group element")
+	}
+	return e, nil
+}
+
+// scalarUnmarshal decodes canonical scalar
+func scalarUnmarshal(data []byte) (*ristretto255.Scalar, error) {
+	s := ristretto255.NewScalar()
+	if len(data) != scalarLen || s.Decode(data) != nil {
+		return nil, errors.New("invalid scalar")
+	}
+	return s, nil
+}
+
+func mul(p *ristretto255.Element, s *ristretto255.Scalar) *ristretto255.Element {
+	return ristretto255.NewElement().ScalarMult(s, p)
+}
+
+func baseMul(s *ristretto255.Scalar) *ristretto255.Element {
+	return ristretto255.NewElement().ScalarBaseMult(s)
+}
+
+func add(p, q *ristretto255.Element) *ristretto255.Element {
+	return ristretto255.NewElement().Add(p, q)
+}
+
+func sub(p, q *ristretto255.Element) *ristretto255.Element {
+	return ristretto255.NewElement().Subtract(p, q)
+}
+
+func equal(p, q *ristretto255.Element) bool {
+	return p.Equal(q) == 1
+}
+
+func marshalKeypair(publicKey, privateKey []byte) ([]byte, error) {
+	return wire.MarshalKeypair(publicKey, privateKey)
+}
+
+func unmarshalKeypair(serverKeypair []byte) (x *ristretto255.Scalar, pub *ristretto255.Element, err error) {
+	kp, err := wire.UnmarshalKeypair(serverKeypair)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if x, err = scalarUnmarshal(kp.PrivateKey); err != nil {
+		return nil, nil, errors.New("invalid keypair")
+	}
+
+	if pub, err = pointUnmarshal(kp.PublicKey); err != nil {
+		return nil, nil, errors.New("invalid keypair")
+	}
+	return
+}
+
+func parseToken(token *phe.UpdateToken) (a, b *ristretto255.Scalar, err error) {
+	if token == nil {
+		return nil, nil, errors.New("invalid token")
+	}
+	if a, err = scalarUnmarshal(token.A); err != nil || a.Equal(ristretto255.NewScalar()) == 1 {
+		return nil, nil, errors.New("invalid update token")
+	}
+	if b, err = scalarUnmarshal(token.B); err != nil {
+		return nil, nil, errors.New("invalid update token")
+	}
+	return
+}
+
+// Capabilities reports what clients and servers of this package support
+func Capabilities() *phe.Capabilities {
+	return &phe.Capabilities{
+		Suite:            phe.SuiteRistretto255,
+		Suites:           phe.SupportedSuites(),
+		ProtocolVersions: []int{phe.ProtocolVersion},
+		MaxNonceLen:      wire.MaxNonceLen,
+		MaxPointLen:      elementLen,
+		MaxScalarLen:     scalarLen,
+	}
+}
@@ -0,0 +1,212 @@
+package ristretto
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/stretchr/testify/assert"
+)
+
+var pwd = []byte("Password")
+
+func Test_PHE(t *testing.T) {
+	serverKeypair, err := GenerateServerKeypair()
+	assert.NoError(t, err)
+	pub, err := GetPublicKey(serverKeypair)
+	assert.NoError(t, err)
+	assert.Len(t, pub, 32)
+	c, err := NewClient(GenerateClientKey(), pub)
+	assert.NoError(t, err)
+
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	rec, key, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	res, err := VerifyPassword(serverKeypair, req)
+	assert.NoError(t, err)
+	keyDec, err := c.CheckResponseAndDecrypt(pwd, rec, res)
+	assert.NoError(t, err)
+	assert.Equal(t, key, keyDec)
+
+	token, newKeypair, err := Rotate(serverKeypair)
+	assert.NoError(t, err)
+	newPriv, newPub, err := RotateClientKeys(c.clientPrivateKey.Encode(nil), pub, token)
+	assert.NoError(t, err)
+	assert.NoError(t, c.Rotate(token))
+	expectedPub, err := GetPublicKey(newKeypair)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedPub, c.serverPublicKeyBytes)
+	assert.Equal(t, expectedPub, newPub)
+	assert.Equal(t, c.clientPrivateKey.Encode(nil), newPriv)
+
+	rec, err = UpdateRecord(rec, token)
+	assert.NoError(t, err)
+	req, err = c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	res, err = VerifyPassword(newKeypair, req)
+	assert.NoError(t, err)
+	keyDec, err = c.CheckResponseAndDecrypt(pwd, rec, res)
+	assert.NoError(t, err)
+	assert.Equal(t, key, keyDec)
+}
+
+func TestClient_RotateChecked(t *testing.T) {
+	serverKeypair, err := GenerateServerKeypair()
+	assert.NoError(t, err)
+	pub, err := GetPublicKey(serverKeypair)
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), pub)
+	assert.NoError(t, err)
+
+	token, newKeypair, err := Rotate(serverKeypair)
+	assert.NoError(t, err)
+	newPub, err := GetPublicKey(newKeypair)
+	assert.NoError(t, err)
+	otherKeypair, err := GenerateServerKeypair()
+	assert.NoError(t, err)
+	otherPub, err := GetPublicKey(otherKeypair)
+	assert.NoError(t, err)
+
+	var rotationErr *phe.RotationError
+	assert.True(t, errors.As(c.RotateChecked(token, otherPub), &rotationErr))
+	assert.True(t, errors.Is(rotationErr, phe.ErrRotationMismatch))
+	assert.Equal(t, otherPub, rotationErr.NewPublicKey)
+	assert.Equal(t, pub, c.serverPublicKeyBytes)
+	assert.Error(t, c.RotateChecked(token, nil))
+
+	assert.NoError(t, c.RotateChecked(token, newPub))
+	assert.Equal(t, newPub, c.serverPublicKeyBytes)
+}
+
+func Test_PHE_InvalidPassword(t *testing.T) {
+	serverKeypair, err := GenerateServerKeypair()
+	assert.NoError(t, err)
+	pub, err := GetPublicKey(serverKeypair)
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), pub)
+	assert.NoError(t, err)
+
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	req, err := c.CreateVerifyPasswordRequest([]byte("Password1"), rec)
+	assert.NoError(t, err)
+	res, err := VerifyPassword(serverKeypair, req)
+	assert.NoError(t, err)
+	assert.False(t, res.Res)
+	keyDec, err := c.CheckResponseAndDecrypt([]byte("Password1"), rec, res)
+	assert.NoError(t, err)
+	assert.Nil(t, keyDec)
+
+	//tampered proof must be rejected
+	res.ProofFail.BlindA, res.ProofFail.BlindB = res.ProofFail.BlindB, res.ProofFail.BlindA
+	_, err = c.CheckResponseAndDecrypt([]byte("Password1"), rec, res)
+	assert.Error(t, err)
+}
+
+func Test_NISTKeysRejected(t *testing.T) {
+	nistKeypair, err := phe.GenerateServerKeypair()
+	assert.NoError(t, err)
+	_, err = GetEnrollment(nistKeypair)
+	assert.Error(t, err)
+
+	serverKeypair, err := GenerateServerKeypair()
+	assert.NoError(t, err)
+	_, err = phe.GetEnrollment(serverKeypair)
+	assert.Error(t, err)
+}
+
+func TestClient_SharedChecks(t *testing.T) {
+	before := phe.ReadRNGStats()
+	serverKeypair, err := GenerateServerKeypair()
+	assert.NoError(t, err)
+	assert.True(t, phe.ReadRNGStats().Reads > before.Reads)
+	pub, err := GetPublicKey(serverKeypair)
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), pub, WithClientKeyVersion(1), WithRecordMAC([]byte("mac key")))
+	assert.NoError(t, err)
+
+	//key versions
+	enrollment, err := GetEnrollment(serverKeypair, WithKeyVersion(2))
+	assert.NoError(t, err)
+	_, _, err = c.EnrollAccount(pwd, enrollment)
+	var versionErr *phe.KeyVersionError
+	assert.True(t, errors.As(err, &versionErr))
+
+	enrollment, err = GetEnrollment(serverKeypair, WithKeyVersion(1))
+	assert.NoError(t, err)
+	rec, key, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), rec.KeyVersion)
+	assert.Len(t, rec.MAC, phe.RecordMACSize)
+
+	req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), req.KeyVersion)
+	_, err = VerifyPassword(serverKeypair, req, WithKeyVersion(2))
+	assert.True(t, errors.As(err, &versionErr))
+	res, err := VerifyPassword(serverKeypair, req, WithKeyVersion(1))
+	assert.NoError(t, err)
+	keyDec, err := c.CheckResponseAndDecrypt(pwd, rec, res)
+	assert.NoError(t, err)
+	assert.Equal(t, key, keyDec)
+
+	//record MAC
+	tampered := *rec
+	tampered.NC = append([]byte{}, rec.NC...)
+	tampered.NC[0] ^= 1
+	_, err = c.CreateVerifyPasswordRequest(pwd, &tampered)
+	assert.Equal(t, phe.ErrRecordMAC, err)
+	_, err = c.CheckResponseAndDecrypt(pwd, &tampered, res)
+	assert.Equal(t, phe.ErrRecordMAC, err)
+
+	//token metadata
+	token, newKeypair, err := Rotate(serverKeypair)
+	assert.NoError(t, err)
+	newPub, err := GetPublicKey(newKeypair)
+	assert.NoError(t, err)
+	assert.Equal(t, phe.Fingerprint(pub), token.FromKey)
+	assert.Equal(t, phe.Fingerprint(newPub), token.ToKey)
+	assert.NotZero(t, token.IssuedAt)
+
+	stale := *token
+	stale.FromVersion = 5
+	assert.True(t, errors.As(c.Rotate(&stale), &versionErr))
+	_, err = UpdateRecord(rec, &stale)
+	assert.True(t, errors.As(err, &versionErr))
+	stale = *token
+	stale.FromKey = phe.Fingerprint(newPub)
+	assert.Equal(t, phe.ErrTokenKeyMismatch, c.Rotate(&stale))
+
+	updRec, err := UpdateRecord(rec, token)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), updRec.KeyVersion)
+	assert.Nil(t, updRec.MAC)
+	updRec, err = c.UpdateRecord(rec, token)
+	assert.NoError(t, err)
+	assert.NotNil(t, updRec.MAC)
+
+	assert.NoError(t, c.RotateChecked(token, newPub))
+	assert.Equal(t, uint32(2), c.KeyVersion())
+	req, err = c.CreateVerifyPasswordRequest(pwd, updRec)
+	assert.NoError(t, err)
+	res, err = VerifyPassword(newKeypair, req, WithKeyVersion(2))
+	assert.NoError(t, err)
+	keyDec, err = c.CheckResponseAndDecrypt(pwd, updRec, res)
+	assert.NoError(t, err)
+	assert.Equal(t, key, keyDec)
+}
+
+func TestCapabilities(t *testing.T) {
+	caps := Capabilities()
+	assert.Equal(t, phe.SuiteRistretto255, caps.Suite)
+	assert.Contains(t, caps.Suites, caps.Suite)
+	assert.Equal(t, 32, caps.MaxPointLen)
+	assert.Equal(t, 32, caps.MaxScalarLen)
+}
@@ -0,0 +1,232 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package ristretto
+
+import (
+	"time"
+
+	"github.com/gtank/ristretto255"
+	"github.com/passw0rd/phe-go"
+	"github.com/passw0rd/phe-go/internal/rng"
+	"github.com/passw0rd/phe-go/internal/wire"
+	"github.com/pkg/errors"
+)
+
+// ServerOption configures GetEnrollment and VerifyPassword
+type ServerOption func(*serverConfig)
+
+type serverConfig struct {
+	keyVersion uint32
+}
+
+// WithKeyVersion sets the version of the server keypair, see phe.WithKeyVersion
+func WithKeyVersion(v uint32) ServerOption {
+	return func(cfg *serverConfig) {
+		cfg.keyVersion = v
+	}
+}
+
+func newServerConfig(opts []ServerOption) *serverConfig {
+	cfg := &serverConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// GenerateServerKeypair creates a new random ristretto255 keypair
+func GenerateServerKeypair() ([]byte, error) {
+	x := randomZ()
+	return marshalKeypair(baseMul(x).Encode(nil), x.Encode(nil))
+}
+
+// GetPublicKey returns server public key
+func GetPublicKey(serverKeypair []byte) ([]byte, error) {
+	_, pub, err := unmarshalKeypair(serverKeypair)
+	if err != nil {
+		return nil, err
+	}
+	return pub.Encode(nil), nil
+}
+
+// GetEnrollment generates a new random enrollment record and a proof
+func GetEnrollment(serverKeypair []byte, opts ...ServerOption) (*phe.EnrollmentResponse, error) {
+	x, pub, err := unmarshalKeypair(serverKeypair)
+	if err != nil {
+		return nil, err
+	}
+
+	ns := make([]byte, 32)
+	if _, err = rng.Default.Read(ns); err != nil {
+		return nil, err
+	}
+
+	hs0 := hashToPoint(dhs0, ns)
+	hs1 := hashToPoint(dhs1, ns)
+	c0 := mul(hs0, x)
+	c1 := mul(hs1, x)
+
+	return &phe.EnrollmentResponse{
+		NS:         ns,
+		C0:         c0.Encode(nil),
+		C1:         c1.Encode(nil),
+		Proof:      proveSuccess(x, pub, hs0, hs1, c0, c1),
+		KeyVersion: newServerConfig(opts).keyVersion,
+	}, nil
+}
+
+// VerifyPassword compares password attempt to the one server would calculate itself using its private key
+// and returns a zero knowledge proof of ether success or failure
+func VerifyPassword(serverKeypair []byte, req *phe.VerifyPasswordRequest, opts ...ServerOption) (*phe.VerifyPasswordResponse, error) {
+	x, pub, err := unmarshalKeypair(serverKeypair)
+	if err != nil {
+		return nil, err
+	}
+
+	if req == nil || wire.Nonce(req.NS) != nil {
+		return nil, errors.New("Invalid password verify request")
+	}
+
+	if err = phe.CheckKeyVersion(newServerConfig(opts).keyVersion, req.KeyVersion); err != nil {
+		return nil, err
+	}
+
+	c0, err := pointUnmarshal(req.C0)
+	if err != nil {
+		return nil, err
+	}
+
+	hs0 := hashToPoint(dhs0, req.NS)
+	hs1 := hashToPoint(dhs1, req.NS)
+
+	if equal(mul(hs0, x), c0) {
+		//password is ok
+		c1 := mul(hs1, x)
+		return &phe.VerifyPasswordResponse{
+			Res:          true,
+			C1:           c1.Encode(nil),
+			ProofSuccess: proveSuccess(x, pub, hs0, hs1, c0, c1),
+		}, nil
+	}
+
+	//password is invalid
+	c1, proof := proveFailure(x, pub, c0, hs0)
+	return &phe.VerifyPasswordResponse{
+		Res:       false,
+		C1:        c1.Encode(nil),
+		ProofFail: proof,
+	}, nil
+}
+
+func proveSuccess(x *ristretto255.Scalar, pub, hs0, hs1, c0, c1 *ristretto255.Element) *phe.ProofOfSuccess {
+	blindX := randomZ()
+
+	term1 := mul(hs0, blindX).Encode(nil)
+	term2 := mul(hs1, blindX).Encode(nil)
+	term3 := baseMul(blindX).Encode(nil)
+
+	challenge := hashZ(proofOk, pub.Encode(nil), gBytes, c0.Encode(nil), c1.Encode(nil), term1, term2, term3)
+	res := ristretto255.NewScalar().Multiply(x, challenge)
+	res.Add(res, blindX)
+
+	return &phe.ProofOfSuccess{
+		Term1:  term1,
+		Term2:  term2,
+		Term3:  term3,
+		BlindX: res.Encode(nil),
+	}
+}
+
+func proveFailure(x *ristretto255.Scalar, pub, c0, hs0 *ristretto255.Element) (*ristretto255.Element, *phe.ProofOfFail) {
+	// c1 = c0 * r - hs0 * (r * x), so that X * a + G * b is the identity for a = r, b = -r * x
+	a := randomZ()
+	b := ristretto255.NewScalar().Multiply(a, x)
+	b.Negate(b)
+
+	c1 := add(mul(c0, a), mul(hs0, b))
+
+	blindA := randomZ()
+	blindB := randomZ()
+
+	term1 := mul(c0, blindA).Encode(nil)
+	term2 := mul(hs0, blindB).Encode(nil)
+	term3 := mul(pub, blindA).Encode(nil)
+	term4 := baseMul(blindB).Encode(nil)
+
+	challenge := hashZ(proofError, pub.Encode(nil), gBytes, c0.Encode(nil), c1.Encode(nil), term1, term2, term3, term4)
+
+	resA := ristretto255.NewScalar().Multiply(challenge, a)
+	resA.Add(resA, blindA)
+	resB := ristretto255.NewScalar().Multiply(challenge, b)
+	resB.Add(resB, blindB)
+
+	return c1, &phe.ProofOfFail{
+		Term1:  term1,
+		Term2:  term2,
+		Term3:  term3,
+		Term4:  term4,
+		BlindA: resA.Encode(nil),
+		BlindB: resB.Encode(nil),
+	}
+}
+
+// Rotate updates server's private and public keys and issues an update token for use on client's side
+func Rotate(serverKeypair []byte) (token *phe.UpdateToken, newServerKeypair []byte, err error) {
+	x, pub, err := unmarshalKeypair(serverKeypair)
+	if err != nil {
+		return
+	}
+
+	a, b := randomZ(), randomZ()
+	newPrivate := ristretto255.NewScalar().Multiply(x, a)
+	newPrivate.Add(newPrivate, b)
+	newPublic := baseMul(newPrivate).Encode(nil)
+
+	newServerKeypair, err = marshalKeypair(newPublic, newPrivate.Encode(nil))
+	if err != nil {
+		return
+	}
+
+	token = &phe.UpdateToken{
+		A:        a.Encode(nil),
+		B:        b.Encode(nil),
+		FromKey:  phe.Fingerprint(pub.Encode(nil)),
+		ToKey:    phe.Fingerprint(newPublic),
+		IssuedAt: time.Now().Unix(),
+	}
+	return
+}
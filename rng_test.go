@@ -0,0 +1,15 @@
+package phe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadRNGStats(t *testing.T) {
+	before := ReadRNGStats()
+	GenerateClientKey()
+	after := ReadRNGStats()
+	assert.True(t, after.Reads > before.Reads)
+	assert.True(t, after.Bytes > before.Bytes)
+}
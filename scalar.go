@@ -0,0 +1,96 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"math/big"
+
+	"filippo.io/bigmod"
+)
+
+// scalarField does arithmetic modulo curve's group order in constant time.
+// Scalars are big-endian byte slices of fixed length so neither their encoding nor the operations depend on the value.
+// Use it for anything derived from private keys, blinding factors and update tokens
+type scalarField struct {
+	m       *bigmod.Modulus
+	nMinus2 []byte
+}
+
+func newScalarField(n *big.Int) *scalarField {
+	m, err := bigmod.NewModulus(n.Bytes())
+	if err != nil {
+		panic(err)
+	}
+	return &scalarField{
+		m:       m,
+		nMinus2: new(big.Int).Sub(n, big.NewInt(2)).Bytes(),
+	}
+}
+
+// nat converts scalar to bigmod representation. The scalar must be less than the group order
+func (f *scalarField) nat(a []byte) *bigmod.Nat {
+	x, err := bigmod.NewNat().SetBytes(a, f.m)
+	if err != nil {
+		panic(err)
+	}
+	return x
+}
+
+// Add returns a + b
+func (f *scalarField) Add(a, b []byte) []byte {
+	return f.nat(a).Add(f.nat(b), f.m).Bytes(f.m)
+}
+
+// Sub returns a - b
+func (f *scalarField) Sub(a, b []byte) []byte {
+	return f.nat(a).Sub(f.nat(b), f.m).Bytes(f.m)
+}
+
+// Mul returns a * b
+func (f *scalarField) Mul(a, b []byte) []byte {
+	return f.nat(a).Mul(f.nat(b), f.m).Bytes(f.m)
+}
+
+// Neg returns -a
+func (f *scalarField) Neg(a []byte) []byte {
+	return bigmod.NewNat().ExpandFor(f.m).Sub(f.nat(a), f.m).Bytes(f.m)
+}
+
+// Inv returns a^-1 computed as a^(n-2)
+func (f *scalarField) Inv(a []byte) []byte {
+	return bigmod.NewNat().Exp(f.nat(a), f.nMinus2, f.m).Bytes(f.m)
+}
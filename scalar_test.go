@@ -0,0 +1,27 @@
+package phe
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScalarField(t *testing.T) {
+	for _, c := range curves {
+		n := c.ec.Params().N
+		for i := 0; i < 20; i++ {
+			a, b := c.randomZ(), c.randomZ()
+			ab, bb := c.scalarBytes(a), c.scalarBytes(b)
+
+			assert.Equal(t, c.scalarBytes(c.gf.Add(a, b)), c.sf.Add(ab, bb))
+			assert.Equal(t, c.scalarBytes(c.gf.Sub(a, b)), c.sf.Sub(ab, bb))
+			assert.Equal(t, c.scalarBytes(c.gf.Mul(a, b)), c.sf.Mul(ab, bb))
+			assert.Equal(t, c.scalarBytes(c.gf.Neg(a)), c.sf.Neg(ab))
+			assert.Equal(t, c.scalarBytes(new(big.Int).ModInverse(a, n)), c.sf.Inv(ab))
+		}
+
+		zero := make([]byte, c.scalarLen)
+		assert.Equal(t, zero, c.sf.Neg(zero))
+	}
+}
@@ -0,0 +1,274 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"crypto/elliptic"
+	"crypto/subtle"
+	"math/big"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// k256 adapts decred's secp256k1 implementation. Its elliptic.Curve adaptor multiplies in variable time,
+// so multiplications are done here in constant time instead: a fixed 4-bit window ladder over decred's
+// constant-time field and scalar arithmetic with complete addition formulas and table lookups reading every entry
+type k256 struct {
+	*secp256k1.KoblitzCurve
+}
+
+func (c k256) ScalarMult(x, y *big.Int, k []byte) (*big.Int, *big.Int) {
+	var p k256Point
+	p.setAffine(x, y)
+	return c.mult(&p, k).affine()
+}
+
+func (c k256) ScalarBaseMult(k []byte) (*big.Int, *big.Int) {
+	params := c.Params()
+	return c.ScalarMult(params.Gx, params.Gy, k)
+}
+
+// mult computes k*P processing one 4-bit digit of k per step whatever its value
+func (c k256) mult(p *k256Point, k []byte) *k256Point {
+	const window = 4
+
+	var s secp256k1.ModNScalar
+	if len(k) > 32 {
+		// only scalars of a fixed length are secret, see Curve.fixedScalar
+		k = new(big.Int).Mod(new(big.Int).SetBytes(k), c.Params().N).Bytes()
+	}
+	s.SetByteSlice(k)
+	ks := s.Bytes()
+	s.Zero()
+	defer Wipe(ks[:])
+
+	// table[i] = i*P
+	var table [1 << window][k256PointLen]byte
+	var q k256Point
+	q.setIdentity()
+	for i := range table {
+		q.encode(&table[i])
+		q.add(&q, p)
+	}
+
+	var acc, t k256Point
+	var buf [k256PointLen]byte
+	defer Wipe(buf[:])
+	acc.setIdentity()
+	for n := 0; n < len(ks)*8/window; n++ {
+		for d := 0; d < window; d++ {
+			acc.add(&acc, &acc)
+		}
+
+		// digits go from the most significant one, two per byte
+		digit := ks[n/2] >> uint(window*(1-n%2)) & (1<<window - 1)
+		for i := range table {
+			subtle.ConstantTimeCopy(subtle.ConstantTimeByteEq(uint8(i), digit), buf[:], table[i][:])
+		}
+		t.decode(&buf)
+		acc.add(&acc, &t)
+	}
+	return &acc
+}
+
+const k256PointLen = 3 * 32
+
+// k256Point is a point in projective coordinates, the identity is (0:1:0)
+type k256Point struct {
+	x, y, z secp256k1.FieldVal
+}
+
+func (p *k256Point) setIdentity() {
+	p.x.Zero()
+	p.y.SetInt(1)
+	p.z.Zero()
+}
+
+// setAffine sets p to (x, y), elliptic's (0, 0) being the point at infinity
+func (p *k256Point) setAffine(x, y *big.Int) {
+	if x.Sign() == 0 && y.Sign() == 0 {
+		p.setIdentity()
+		return
+	}
+	p.x.SetByteSlice(x.Bytes())
+	p.y.SetByteSlice(y.Bytes())
+	p.z.SetInt(1)
+}
+
+func (p *k256Point) affine() (*big.Int, *big.Int) {
+	p.z.Normalize()
+	if p.z.IsZero() {
+		return new(big.Int), new(big.Int)
+	}
+
+	var zInv, x, y secp256k1.FieldVal
+	zInv.Set(&p.z).Inverse()
+	x.Mul2(&p.x, &zInv).Normalize()
+	y.Mul2(&p.y, &zInv).Normalize()
+	return new(big.Int).SetBytes(x.Bytes()[:]), new(big.Int).SetBytes(y.Bytes()[:])
+}
+
+func (p *k256Point) encode(b *[k256PointLen]byte) {
+	p.x.Normalize().PutBytesUnchecked(b[:32])
+	p.y.Normalize().PutBytesUnchecked(b[32:64])
+	p.z.Normalize().PutBytesUnchecked(b[64:])
+}
+
+func (p *k256Point) decode(b *[k256PointLen]byte) {
+	p.x.SetBytes((*[32]byte)(b[:32]))
+	p.y.SetBytes((*[32]byte)(b[32:64]))
+	p.z.SetBytes((*[32]byte)(b[64:]))
+}
+
+// add sets p = a + b with the complete formulas for a = 0 curves from Renes, Costello and Batina,
+// "Complete addition formulas for prime order elliptic curves", algorithm 7. They work for doubling
+// and the identity too, so there's no branching on the points. Every value is kept normalized
+func (p *k256Point) add(a, b *k256Point) {
+	var t0, t1, t2, t3, t4, x3, y3, z3 secp256k1.FieldVal
+
+	fmul(&t0, &a.x, &b.x)
+	fmul(&t1, &a.y, &b.y)
+	fmul(&t2, &a.z, &b.z)
+	fadd(&t3, &a.x, &a.y)
+	fadd(&t4, &b.x, &b.y)
+	fmul(&t3, &t3, &t4)
+	fadd(&t4, &t0, &t1)
+	fsub(&t3, &t3, &t4)
+	fadd(&t4, &a.y, &a.z)
+	fadd(&x3, &b.y, &b.z)
+	fmul(&t4, &t4, &x3)
+	fadd(&x3, &t1, &t2)
+	fsub(&t4, &t4, &x3)
+	fadd(&x3, &a.x, &a.z)
+	fadd(&y3, &b.x, &b.z)
+	fmul(&x3, &x3, &y3)
+	fadd(&y3, &t0, &t2)
+	fsub(&y3, &x3, &y3)
+	fadd(&x3, &t0, &t0)
+	fadd(&t0, &x3, &t0)
+	fmulB3(&t2, &t2)
+	fadd(&z3, &t1, &t2)
+	fsub(&t1, &t1, &t2)
+	fmulB3(&y3, &y3)
+	fmul(&x3, &t4, &y3)
+	fmul(&t2, &t3, &t1)
+	fsub(&x3, &t2, &x3)
+	fmul(&y3, &y3, &t0)
+	fmul(&t1, &t1, &z3)
+	fadd(&y3, &t1, &y3)
+	fmul(&t0, &t0, &t3)
+	fmul(&z3, &z3, &t4)
+	fadd(&z3, &z3, &t0)
+
+	p.x.Set(&x3)
+	p.y.Set(&y3)
+	p.z.Set(&z3)
+}
+
+func fmul(r, a, b *secp256k1.FieldVal) {
+	r.Mul2(a, b).Normalize()
+}
+
+func fadd(r, a, b *secp256k1.FieldVal) {
+	r.Add2(a, b).Normalize()
+}
+
+func fsub(r, a, b *secp256k1.FieldVal) {
+	var nb secp256k1.FieldVal
+	nb.NegateVal(b, 1)
+	r.Add2(a, &nb).Normalize()
+}
+
+// fmulB3 multiplies by 3*b = 21
+func fmulB3(r, a *secp256k1.FieldVal) {
+	r.Set(a).MulInt(21).Normalize()
+}
+
+var _ elliptic.Curve = k256{}
+
+func init() {
+	secp256K1.msm = k256MultiScalarMult
+}
+
+// k256MultiScalarMult computes the sum of points multiplied to scalars with Strauss' method:
+// 4-bit windows of all scalars are processed together so that doublings are shared.
+// It works in variable time and must only be used for public values
+func k256MultiScalarMult(points []*Point, scalars []*big.Int) (x, y *big.Int) {
+	const window = 4
+
+	tables := make([][1 << window]secp256k1.JacobianPoint, len(points))
+	ks := make([][32]byte, len(points))
+	for i, p := range points {
+		var k secp256k1.ModNScalar
+		k.SetByteSlice(scalars[i].Bytes())
+		ks[i] = k.Bytes()
+
+		var px, py secp256k1.FieldVal
+		px.SetByteSlice(p.X.Bytes())
+		py.SetByteSlice(p.Y.Bytes())
+		tables[i][1].X.Set(&px)
+		tables[i][1].Y.Set(&py)
+		tables[i][1].Z.SetInt(1)
+		secp256k1.DoubleNonConst(&tables[i][1], &tables[i][2])
+		for j := 3; j < 1<<window; j++ {
+			secp256k1.AddNonConst(&tables[i][j-1], &tables[i][1], &tables[i][j])
+		}
+	}
+
+	var acc secp256k1.JacobianPoint
+	for n := 0; n < 32*8/window; n++ {
+		for d := 0; d < window; d++ {
+			secp256k1.DoubleNonConst(&acc, &acc)
+		}
+
+		// digits go from the most significant one, two per byte
+		shift := uint(window * (1 - n%2))
+		for i := range points {
+			if digit := ks[i][n/2] >> shift & (1<<window - 1); digit != 0 {
+				secp256k1.AddNonConst(&acc, &tables[i][digit], &acc)
+			}
+		}
+	}
+
+	if (acc.X.IsZero() && acc.Y.IsZero()) || acc.Z.IsZero() {
+		return new(big.Int), new(big.Int)
+	}
+
+	acc.ToAffine()
+	xb, yb := acc.X.Bytes(), acc.Y.Bytes()
+	return new(big.Int).SetBytes(xb[:]), new(big.Int).SetBytes(yb[:])
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+const redacted = "[REDACTED]"
+
+// secret holds bytes which are never printed, logged or marshaled, even by value
+type secret struct {
+	b []byte
+}
+
+// String implements fmt.Stringer and never returns the secret
+func (s secret) String() string {
+	return redacted
+}
+
+// Format makes every fmt verb including %x and %#v print a placeholder
+func (s secret) Format(f fmt.State, verb rune) {
+	_, _ = f.Write([]byte(redacted))
+}
+
+// LogValue implements slog.LogValuer
+func (s secret) LogValue() slog.Value {
+	return slog.StringValue(redacted)
+}
+
+// MarshalText keeps the secret out of JSON and other text encodings
+func (s secret) MarshalText() ([]byte, error) {
+	return []byte(redacted), nil
+}
+
+// Password is user's password which can't end up in logs by accident. Use Bytes to get it
+type Password struct {
+	secret
+}
+
+// NewPassword copies the password so the caller can wipe its own buffer
+func NewPassword(password []byte) *Password {
+	return &Password{secret{append([]byte{}, password...)}}
+}
+
+// Bytes returns the password itself, it's wiped together with the wrapper
+func (p *Password) Bytes() []byte {
+	if p == nil {
+		return nil
+	}
+	return p.b
+}
+
+// Len returns the length of the password
+func (p *Password) Len() int {
+	return len(p.Bytes())
+}
+
+// Wipe zeroes the password
+func (p *Password) Wipe() {
+	Wipe(p.Bytes())
+}
+
+// Key is an account key which can't end up in logs by accident. Use Bytes to get it, e.g. for NewPheCipher
+type Key struct {
+	secret
+}
+
+// NewKey copies the key so the caller can wipe its own buffer
+func NewKey(key []byte) *Key {
+	return &Key{secret{append([]byte{}, key...)}}
+}
+
+// Bytes returns the key itself, it's wiped together with the wrapper
+func (k *Key) Bytes() []byte {
+	if k == nil {
+		return nil
+	}
+	return k.b
+}
+
+// Len returns the length of the key
+func (k *Key) Len() int {
+	return len(k.Bytes())
+}
+
+// Wipe zeroes the key
+func (k *Key) Wipe() {
+	Wipe(k.Bytes())
+}
+
+// Enroll is EnrollAccountContext taking and returning wrapped secrets
+func (c *Client) Enroll(ctx context.Context, password *Password, resp *EnrollmentResponse) (*EnrollmentRecord, *Key, error) {
+	rec, key, err := c.EnrollAccountContext(ctx, password.Bytes(), resp)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rec, &Key{secret{key}}, nil
+}
+
+// NewVerifyRequest is CreateVerifyPasswordRequest taking a wrapped password
+func (c *Client) NewVerifyRequest(password *Password, rec *EnrollmentRecord) (*VerifyPasswordRequest, error) {
+	return c.CreateVerifyPasswordRequest(password.Bytes(), rec)
+}
+
+// Open is CheckResponseAndDecryptContext taking a wrapped password and returning a wrapped key,
+// which is nil if the password is wrong
+func (c *Client) Open(ctx context.Context, password *Password, rec *EnrollmentRecord, resp *VerifyPasswordResponse) (*Key, error) {
+	key, err := c.CheckResponseAndDecryptContext(ctx, password.Bytes(), rec, resp)
+	if err != nil || key == nil {
+		return nil, err
+	}
+	return &Key{secret{key}}, nil
+}
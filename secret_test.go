@@ -0,0 +1,84 @@
+package phe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecret_Redacted(t *testing.T) {
+	raw := []byte("hunter2")
+	p := NewPassword(raw)
+	k := NewKey([]byte{0xde, 0xad, 0xbe, 0xef})
+
+	Wipe(raw)
+	assert.Equal(t, []byte("hunter2"), p.Bytes())
+	assert.Equal(t, 7, p.Len())
+
+	for _, format := range []string{"%v", "%+v", "%#v", "%s", "%q", "%x", "%X", "%d"} {
+		for _, v := range []interface{}{p, k, *p} {
+			out := fmt.Sprintf(format, v)
+			assert.NotContains(t, out, "hunter2", format)
+			assert.NotContains(t, out, "deadbeef", format)
+			assert.NotContains(t, out, "104", format)
+		}
+	}
+	assert.Equal(t, "[REDACTED]", p.String())
+	assert.Equal(t, "[REDACTED]", fmt.Sprint(k))
+
+	js, err := json.Marshal(struct {
+		P *Password
+		K *Key
+	}{p, k})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"P":"[REDACTED]","K":"[REDACTED]"}`, string(js))
+
+	buf := &bytes.Buffer{}
+	slog.New(slog.NewTextHandler(buf, nil)).Info("login", "password", p, "key", k)
+	assert.Contains(t, buf.String(), "password=[REDACTED] key=[REDACTED]")
+
+	p.Wipe()
+	assert.Equal(t, make([]byte, 7), p.Bytes())
+
+	var nilKey *Key
+	assert.Nil(t, nilKey.Bytes())
+	nilKey.Wipe()
+}
+
+func TestClient_SecretTypes(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	c, err := NewClient(GenerateClientKey(), mustPublicKey(t, serverKeypair))
+	assert.NoError(t, err)
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+
+	password := NewPassword(pwd)
+	rec, key, err := c.Enroll(context.Background(), password, enrollment)
+	assert.NoError(t, err)
+	assert.Equal(t, 32, key.Len())
+
+	req, err := c.NewVerifyRequest(password, rec)
+	assert.NoError(t, err)
+	resp, err := VerifyPassword(serverKeypair, req)
+	assert.NoError(t, err)
+	opened, err := c.Open(context.Background(), password, rec, resp)
+	assert.NoError(t, err)
+	assert.Equal(t, key.Bytes(), opened.Bytes())
+
+	wrong := NewPassword([]byte("wrong"))
+	req, err = c.NewVerifyRequest(wrong, rec)
+	assert.NoError(t, err)
+	resp, err = VerifyPassword(serverKeypair, req)
+	assert.NoError(t, err)
+	opened, err = c.Open(context.Background(), wrong, rec, resp)
+	assert.NoError(t, err)
+	assert.Nil(t, opened)
+
+	_, _, err = c.Enroll(context.Background(), password, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+)
+
+// MinSeedLen is the shortest seed server keypairs are derived from
+const MinSeedLen = 32
+
+var seedInfo = []byte("ServerKeypairSeed")
+
+// GenerateServerKeypairFromSeed derives a Nist p-256 server keypair from a high-entropy seed.
+// The same seed always gives the same keypair, so the seed alone is enough to restore it
+func GenerateServerKeypairFromSeed(seed []byte) ([]byte, error) {
+	return GenerateServerKeypairFromSeedForCurve(p256, seed, nil)
+}
+
+// GenerateServerKeypairFromSeedForCurve derives a server keypair on the given curve from a high-entropy seed.
+// Different labels, e.g. key versions or tenant IDs, give independent keypairs from a single seed
+func GenerateServerKeypairFromSeedForCurve(curve *Curve, seed, label []byte) ([]byte, error) {
+	if len(seed) < MinSeedLen {
+		return nil, errors.Errorf("seed must be at least %d bytes", MinSeedLen)
+	}
+
+	info := TupleHash([][]byte{[]byte(curve.name), label}, seedInfo)
+	kdf := hkdf.New(curve.hash, seed, nil, info)
+
+	x := curve.makeZ(kdf)
+	for x.Sign() == 0 {
+		x = curve.makeZ(kdf)
+	}
+
+	privateKey := curve.scalarBytes(x)
+	publicKey := curve.scalarBaseMultBytes(privateKey).Marshal()
+
+	publishKeyGenerated(publicKey)
+	return marshalKeypair(publicKey, privateKey)
+}
@@ -0,0 +1,41 @@
+package phe
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateServerKeypairFromSeed(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, MinSeedLen)
+
+	kp1, err := GenerateServerKeypairFromSeed(seed)
+	assert.NoError(t, err)
+	kp2, err := GenerateServerKeypairFromSeed(seed)
+	assert.NoError(t, err)
+	assert.Equal(t, kp1, kp2)
+	assert.NoError(t, mustServer(t, kp1).Warmup())
+
+	seen := map[string]bool{string(kp1): true}
+	for _, curve := range curves {
+		for _, label := range [][]byte{nil, []byte("v2")} {
+			kp, err := GenerateServerKeypairFromSeedForCurve(curve, seed, label)
+			assert.NoError(t, err)
+			if curve != p256 || label != nil {
+				assert.False(t, seen[string(kp)])
+			}
+			seen[string(kp)] = true
+			assert.NoError(t, mustServer(t, kp).Warmup())
+		}
+	}
+
+	_, err = GenerateServerKeypairFromSeed(seed[:MinSeedLen-1])
+	assert.Error(t, err)
+}
+
+func mustServer(t *testing.T, serverKeypair []byte) *Server {
+	s, err := NewServer(serverKeypair)
+	assert.NoError(t, err)
+	return s
+}
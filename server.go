@@ -37,16 +37,30 @@
 package phe
 
 import (
-	"crypto/rand"
-
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"log/slog"
+	"math/big"
+	"time"
+
+	"github.com/passw0rd/phe-go/internal/wire"
 	"github.com/pkg/errors"
 )
 
 // GenerateServerKeypair creates a new random Nist p-256 keypair
 func GenerateServerKeypair() ([]byte, error) {
-	privateKey := randomZ().Bytes()
-	publicKey := new(Point).ScalarBaseMult(privateKey)
+	return GenerateServerKeypairForCurve(p256)
+}
+
+// GenerateServerKeypairForCurve creates a new random keypair on the given curve
+// Every other party picks the curve up from server's public key
+func GenerateServerKeypairForCurve(curve *Curve) ([]byte, error) {
+	privateKey := curve.randomScalar()
+	publicKey := curve.scalarBaseMultBytes(privateKey)
 
+	publishKeyGenerated(publicKey.Marshal())
 	return marshalKeypair(publicKey.Marshal(), privateKey)
 
 }
@@ -54,24 +68,23 @@ func GenerateServerKeypair() ([]byte, error) {
 // GetEnrollment generates a new random enrollment record and a proof
 func GetEnrollment(serverKeypair []byte) (*EnrollmentResponse, error) {
 
-	kp, err := unmarshalKeypair(serverKeypair)
+	s, err := NewServer(serverKeypair)
 	if err != nil {
 		return nil, err
 	}
 
-	ns := make([]byte, 32)
-	_, err = rand.Read(ns)
+	return s.GetEnrollment()
+}
+
+// GetEnrollments generates n enrollment responses at once, see Server.GetEnrollments
+func GetEnrollments(serverKeypair []byte, n int, opts ...BulkOption) ([]*EnrollmentResponse, error) {
+
+	s, err := NewServer(serverKeypair)
 	if err != nil {
 		return nil, err
 	}
-	hs0, hs1, c0, c1 := eval(kp, ns)
-	proof := proveSuccess(kp, hs0, hs1, c0, c1)
-	return &EnrollmentResponse{
-		NS:    ns,
-		C0:    c0.Marshal(),
-		C1:    c1.Marshal(),
-		Proof: proof,
-	}, nil
+
+	return s.GetEnrollments(context.Background(), n, opts...)
 }
 
 // GetPublicKey returns server public key
@@ -88,42 +101,280 @@ func GetPublicKey(serverKeypair []byte) ([]byte, error) {
 // and returns a zero knowledge proof of ether success or failure
 func VerifyPassword(serverKeypair []byte, req *VerifyPasswordRequest) (response *VerifyPasswordResponse, err error) {
 
+	s, err := NewServer(serverKeypair)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.VerifyPassword(req)
+}
+
+// VerifyPasswordBatch is like VerifyPassword for many requests at once. The keypair is parsed once and
+// requests are processed in parallel. responses[i] answers reqs[i] and is nil if that request failed,
+// in which case a *BatchError with per-request errors is returned
+func VerifyPasswordBatch(serverKeypair []byte, reqs []*VerifyPasswordRequest, opts ...BulkOption) (responses []*VerifyPasswordResponse, err error) {
+
+	s, err := NewServer(serverKeypair)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.VerifyPasswordBatch(context.Background(), reqs, opts...)
+}
+
+// Server holds a parsed server keypair and performs the server side of the protocol
+type Server struct {
+	kp    *keypair
+	pub   *Point
+	curve *Curve
+	priv  []byte //private key padded to curve's scalar length, nil if it's delegated
+	key   privateKey
+	usage *usageCounter
+	stats *serverStats
+
+	events         *EventBus
+	keyVersion     uint32
+	recordLifetime time.Duration
+	flagExpired    bool
+	noProofs       bool
+	replay         ReplayCache
+	replayWindow   time.Duration
+	tracer         Tracer
+	metrics        Metrics
+	logger         Logger
+}
+
+// ServerOption configures optional Server behavior
+type ServerOption func(*Server)
+
+// NewServer parses server keypair once so it can be reused for many requests
+func NewServer(serverKeypair []byte, opts ...ServerOption) (*Server, error) {
 	kp, err := unmarshalKeypair(serverKeypair)
 	if err != nil {
 		return nil, err
 	}
 
-	if req == nil || len(req.NS) > 32 || len(req.NS) == 0 {
+	pub, err := PointUnmarshal(kp.PublicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid keypair")
+	}
+
+	if _, err = pub.curve.parseScalar(kp.PrivateKey); err != nil {
+		return nil, errors.New("invalid keypair")
+	}
+
+	priv := pub.curve.scalarBytes(new(big.Int).SetBytes(kp.PrivateKey))
+	s := &Server{
+		kp:      kp,
+		pub:     pub,
+		curve:   pub.curve,
+		priv:    priv,
+		key:     &softwareKey{curve: pub.curve, x: priv},
+		usage:   &usageCounter{since: time.Now()},
+		stats:   newServerStats(),
+		events:  DefaultEventBus,
+		metrics: nopMetrics{},
+		logger:  nopLogger{},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s, nil
+}
+
+// Public returns server public key as *ecdsa.PublicKey, e.g. to put it into a certificate or a JWK.
+// The private key can't sign or decrypt, so Server is neither a crypto.Signer nor a crypto.Decrypter
+func (s *Server) Public() crypto.PublicKey {
+	return &ecdsa.PublicKey{
+		Curve: s.curve.ec,
+		X:     new(big.Int).Set(s.pub.X),
+		Y:     new(big.Int).Set(s.pub.Y),
+	}
+}
+
+// PublicKey returns marshaled server public key
+func (s *Server) PublicKey() []byte {
+	return append([]byte{}, s.kp.PublicKey...)
+}
+
+// GetEnrollment generates a new random enrollment record and a proof
+func (s *Server) GetEnrollment() (*EnrollmentResponse, error) {
+	return s.GetEnrollmentContext(context.Background())
+}
+
+// GetEnrollmentContext is like GetEnrollment, ctx carries the trace the request belongs to
+func (s *Server) GetEnrollmentContext(ctx context.Context) (resp *EnrollmentResponse, err error) {
+	ctx, span := startSpan(ctx, s.tracer, "phe.Server.GetEnrollment")
+	defer func() { span.End(err) }()
+	span.SetAttribute("phe.curve", s.curve.name)
+
+	defer s.stats.begin()()
+
+	ns := make([]byte, 32)
+	_, err = random.Read(ns)
+	if err != nil {
+		return nil, err
+	}
+	ns = s.stampNonce(ns)
+	hs0, hs1, c0, c1, err := s.eval(ns)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := s.proveSuccess(ctx, hs0, hs1, c0, c1)
+	if err != nil {
+		return nil, err
+	}
+	s.count(&s.usage.enrollments)
+	s.publishEnrolled(ns)
+	return &EnrollmentResponse{
+		NS:         ns,
+		C0:         c0.Marshal(),
+		C1:         c1.Marshal(),
+		Proof:      proof,
+		KeyVersion: s.keyVersion,
+		NoProof:    s.noProofs,
+	}, nil
+}
+
+// GetEnrollments generates n enrollment responses for registration bursts such as imports.
+// Nonces for all of them are read from the random source at once and proofs are generated in parallel
+func (s *Server) GetEnrollments(ctx context.Context, n int, opts ...BulkOption) ([]*EnrollmentResponse, error) {
+
+	if n < 0 {
+		return nil, errors.New("invalid number of enrollments")
+	}
+
+	nonces := make([]byte, n*32)
+	if _, err := random.Read(nonces); err != nil {
+		return nil, err
+	}
+
+	start, done := s.stats.enqueue(n)
+	defer done()
+
+	responses := make([]*EnrollmentResponse, n)
+	err := runBulk(ctx, n, newBulkConfig(opts), func(ctx context.Context, i int) error {
+		start()
+		defer s.stats.begin()()
+		ns := s.stampNonce(nonces[i*32 : (i+1)*32 : (i+1)*32])
+		hs0, hs1, c0, c1, err := s.eval(ns)
+		if err != nil {
+			return err
+		}
+		proof, err := s.proveSuccess(ctx, hs0, hs1, c0, c1)
+		if err != nil {
+			return err
+		}
+		responses[i] = &EnrollmentResponse{
+			NS:         ns,
+			C0:         c0.Marshal(),
+			C1:         c1.Marshal(),
+			Proof:      proof,
+			KeyVersion: s.keyVersion,
+			NoProof:    s.noProofs,
+		}
+		s.count(&s.usage.enrollments)
+		s.publishEnrolled(ns)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return responses, nil
+}
+
+// VerifyPassword compares password attempt to the one server would calculate itself using its private key
+// and returns a zero knowledge proof of ether success or failure
+func (s *Server) VerifyPassword(req *VerifyPasswordRequest) (response *VerifyPasswordResponse, err error) {
+	return s.VerifyPasswordContext(context.Background(), req)
+}
+
+// VerifyPasswordContext is like VerifyPassword, ctx carries the trace the request belongs to
+func (s *Server) VerifyPasswordContext(ctx context.Context, req *VerifyPasswordRequest) (response *VerifyPasswordResponse, err error) {
+	start := time.Now()
+	ctx, span := startSpan(ctx, s.tracer, "phe.Server.VerifyPassword")
+	defer func() {
+		if response != nil {
+			span.SetAttribute("phe.result", response.Res)
+			s.metrics.Verification(SourceServer, response.Res, time.Since(start))
+		}
+		if err != nil {
+			s.logVerifyError(ctx, req, err)
+		}
+		span.End(err)
+	}()
+	span.SetAttribute("phe.curve", s.curve.name)
+
+	defer s.stats.begin()()
+
+	if req == nil || wire.Nonce(req.NS) != nil {
 		err = errors.New("Invalid password verify request")
 		return
 	}
 
+	if err = CheckKeyVersion(s.keyVersion, req.KeyVersion); err != nil {
+		return
+	}
+
 	ns := req.NS
 
-	c0, err := PointUnmarshal(req.C0)
+	expired, err := s.checkExpiry(ns)
 	if err != nil {
 		return
 	}
 
-	hs0 := hashToPoint(dhs0, ns)
-	hs1 := hashToPoint(dhs1, ns)
+	c0, err := s.curve.pointUnmarshal(req.C0)
+	if err != nil {
+		return
+	}
 
-	if hs0.ScalarMult(kp.PrivateKey).Equal(c0) {
+	if err = s.checkReplay(req); err != nil {
+		return
+	}
+
+	hs0 := s.curve.hashToPoint(s.curve.dhs0, ns)
+	hs1 := s.curve.hashToPoint(s.curve.dhs1, ns)
+
+	defer s.count(&s.usage.verifications)
+	defer s.stats.verified(start)
+
+	xhs0, err := s.key.scalarMult(hs0)
+	if err != nil {
+		return
+	}
+
+	if xhs0.Equal(c0) {
 		//password is ok
 
-		c1 := hs1.ScalarMult(kp.PrivateKey)
+		var c1 *Point
+		if c1, err = s.key.scalarMult(hs1); err != nil {
+			return
+		}
+
+		var proof *ProofOfSuccess
+		if proof, err = s.proveSuccess(ctx, hs0, hs1, c0, c1); err != nil {
+			return
+		}
 
 		response = &VerifyPasswordResponse{
 			Res:          true,
 			C1:           c1.Marshal(),
-			ProofSuccess: proveSuccess(kp, hs0, hs1, c0, c1),
+			ProofSuccess: proof,
+			Expired:      expired,
+			NoProof:      s.noProofs,
 		}
+		s.events.publish(EventVerified, SourceServer, func(h EventHeader) Event {
+			return &VerifiedEvent{EventHeader: h, NS: ns, Expired: expired}
+		})
 		return
 	}
 
 	//password is invalid
 
-	c1, proof, err := proveFailure(kp, c0, hs0)
+	c1, proof, err := s.proveFailure(ctx, c0, hs0, xhs0)
 	if err != nil {
 		return
 	}
@@ -132,58 +383,112 @@ func VerifyPassword(serverKeypair []byte, req *VerifyPasswordRequest) (response
 		Res:       false,
 		C1:        c1.Marshal(),
 		ProofFail: proof,
+		Expired:   expired,
+		NoProof:   s.noProofs,
 	}
+	s.events.publish(EventVerificationFailed, SourceServer, func(h EventHeader) Event {
+		return &VerificationFailedEvent{EventHeader: h, NS: ns}
+	})
 
 	return
 }
 
-func eval(kp *keypair, ns []byte) (hs0, hs1, c0, c1 *Point) {
-	hs0 = hashToPoint(dhs0, ns)
-	hs1 = hashToPoint(dhs1, ns)
+// VerifyPasswordBatch verifies many requests in parallel, see VerifyPasswordBatch.
+// Processing stops early only if ctx is cancelled
+func (s *Server) VerifyPasswordBatch(ctx context.Context, reqs []*VerifyPasswordRequest, opts ...BulkOption) (responses []*VerifyPasswordResponse, err error) {
 
-	c0 = hs0.ScalarMult(kp.PrivateKey)
-	c1 = hs1.ScalarMult(kp.PrivateKey)
+	start, done := s.stats.enqueue(len(reqs))
+	defer done()
+
+	responses = make([]*VerifyPasswordResponse, len(reqs))
+
+	err = runEach(ctx, len(reqs), newBulkConfig(opts), func(i int) (err error) {
+		start()
+		responses[i], err = s.VerifyPasswordContext(ctx, reqs[i])
+		return
+	})
+	return
+}
+
+// logVerifyError logs a request VerifyPasswordContext has rejected
+func (s *Server) logVerifyError(ctx context.Context, req *VerifyPasswordRequest, err error) {
+	attrs := []slog.Attr{errAttr(err)}
+	if req != nil {
+		attrs = append(attrs, slog.String("ns", Fingerprint(req.NS)), slog.Uint64("key_version", uint64(req.KeyVersion)))
+	}
+	s.logger.LogAttrs(ctx, slog.LevelWarn, "phe: verify password request rejected", attrs...)
+}
+
+func (s *Server) publishEnrolled(ns []byte) {
+	s.metrics.Enrollment(SourceServer)
+	s.events.publish(EventEnrolled, SourceServer, func(h EventHeader) Event {
+		return &EnrolledEvent{EventHeader: h, NS: ns}
+	})
+}
+
+func (s *Server) eval(ns []byte) (hs0, hs1, c0, c1 *Point, err error) {
+	hs0 = s.curve.hashToPoint(s.curve.dhs0, ns)
+	hs1 = s.curve.hashToPoint(s.curve.dhs1, ns)
+
+	if c0, err = s.key.scalarMult(hs0); err != nil {
+		return
+	}
+	c1, err = s.key.scalarMult(hs1)
 	return
 }
 
-func proveSuccess(kp *keypair, hs0, hs1, c0, c1 *Point) *ProofOfSuccess {
-	blindX := randomZ()
+func (s *Server) proveSuccess(ctx context.Context, hs0, hs1, c0, c1 *Point) (proof *ProofOfSuccess, err error) {
+	if s.noProofs {
+		return nil, nil
+	}
+	defer s.stats.proof()
+	_, span := startSpan(ctx, s.tracer, "phe.Server.Prove")
+	defer func() { span.End(err) }()
 
-	term1 := hs0.ScalarMult(blindX.Bytes())
-	term2 := hs1.ScalarMult(blindX.Bytes())
-	term3 := new(Point).ScalarBaseMult(blindX.Bytes())
+	// term1 = hs0 ** blind_x, term2 = hs1 ** blind_x, term3 = self.G ** blind_x
+	terms, respond, err := s.key.commit(hs0, hs1, s.curve.g)
+	if err != nil {
+		return nil, err
+	}
+	term1, term2, term3 := terms[0], terms[1], terms[2]
 
 	//challenge = group.hash((self.X, self.G, c0, c1, term1, term2, term3), target_type=ZR)
 
-	challenge := hashZ(proofOk, kp.PublicKey, curveG.Marshal(), c0.Marshal(), c1.Marshal(), term1.Marshal(), term2.Marshal(), term3.Marshal())
-	res := gf.Add(blindX, gf.MulBytes(kp.PrivateKey, challenge))
+	challenge := s.curve.hashZ(s.curve.proofOk, s.kp.PublicKey, s.curve.gBytes, c0.Marshal(), c1.Marshal(), term1.Marshal(), term2.Marshal(), term3.Marshal())
+	res, err := respond(s.curve.scalarBytes(challenge))
+	if err != nil {
+		return nil, err
+	}
 
 	return &ProofOfSuccess{
 		Term1:  term1.Marshal(),
 		Term2:  term2.Marshal(),
 		Term3:  term3.Marshal(),
-		BlindX: res.Bytes(),
-	}
+		BlindX: res,
+	}, nil
 
 }
 
-func proveFailure(kp *keypair, c0, hs0 *Point) (c1 *Point, proof *ProofOfFail, err error) {
-	r := randomZ()
-	minusR := gf.Neg(r)
-	minusRX := gf.MulBytes(kp.PrivateKey, minusR)
+// proveFailure gets xhs0 = hs0 ** x from the caller which has already compared it to c0
+func (s *Server) proveFailure(ctx context.Context, c0, hs0, xhs0 *Point) (c1 *Point, proof *ProofOfFail, err error) {
+	sf := s.curve.sf
+	r := s.curve.randomScalar()
 
-	c1 = c0.ScalarMult(r.Bytes()).Add(hs0.ScalarMult(minusRX.Bytes()))
+	// c1 = c0 ** r * hs0 ** (-r * x) = (c0 / hs0 ** x) ** r
+	c1 = c0.Add(xhs0.Neg()).ScalarMult(r)
+	if s.noProofs {
+		return c1, nil, nil
+	}
+	defer s.stats.proof()
+	_, span := startSpan(ctx, s.tracer, "phe.Server.Prove")
+	defer func() { span.End(err) }()
 
+	// a = r, b = -r * x never leaves the key, see blind_b below
 	a := r
-	b := minusRX
 
-	blindA := randomZ().Bytes()
-	blindB := randomZ().Bytes()
+	blindA := s.curve.randomScalar()
 
-	publicKey, err := PointUnmarshal(kp.PublicKey)
-	if err != nil {
-		return
-	}
+	publicKey := s.pub
 
 	// I = (self.X ** a) * (self.G ** b)
 	// term1 = c0     ** blind_a
@@ -191,33 +496,45 @@ func proveFailure(kp *keypair, c0, hs0 *Point) (c1 *Point, proof *ProofOfFail, e
 	// term3 = self.X ** blind_a
 	// term4 = self.G ** blind_b
 
+	terms, respond, err := s.key.commit(hs0, s.curve.g)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	term1 := c0.ScalarMult(blindA)
-	term2 := hs0.ScalarMult(blindB)
+	term2 := terms[0]
 	term3 := publicKey.ScalarMult(blindA)
-	term4 := new(Point).ScalarBaseMult(blindB)
+	term4 := terms[1]
 
-	challenge := hashZ(proofError, kp.PublicKey, curveG.Marshal(), c0.Marshal(), c1.Marshal(), term1.Marshal(), term2.Marshal(), term3.Marshal(), term4.Marshal())
+	challenge := s.curve.hashZ(s.curve.proofError, s.kp.PublicKey, s.curve.gBytes, c0.Marshal(), c1.Marshal(), term1.Marshal(), term2.Marshal(), term3.Marshal(), term4.Marshal())
+	challengeBytes := s.curve.scalarBytes(challenge)
+
+	// blind_b + challenge * b = blind_b + (-challenge * r) * x
+	blindB, err := respond(sf.Neg(sf.Mul(challengeBytes, r)))
+	if err != nil {
+		return nil, nil, err
+	}
 
 	return c1, &ProofOfFail{
 		Term1:  term1.Marshal(),
 		Term2:  term2.Marshal(),
 		Term3:  term3.Marshal(),
 		Term4:  term4.Marshal(),
-		BlindA: gf.AddBytes(blindA, gf.Mul(challenge, a)).Bytes(),
-		BlindB: gf.AddBytes(blindB, gf.Mul(challenge, b)).Bytes(),
+		BlindA: sf.Add(blindA, sf.Mul(challengeBytes, a)),
+		BlindB: blindB,
 	}, nil
 }
 
 //Rotate updates server's private and public keys and issues an update token for use on client's side
 func Rotate(serverKeypair []byte) (token *UpdateToken, newServerKeypair []byte, err error) {
 
-	kp, err := unmarshalKeypair(serverKeypair)
+	s, err := NewServer(serverKeypair)
 	if err != nil {
 		return
 	}
-	a, b := randomZ(), randomZ()
-	newPrivate := gf.Add(gf.MulBytes(kp.PrivateKey, a), b).Bytes()
-	newPublic := new(Point).ScalarBaseMult(newPrivate)
+	a, b := s.curve.randomScalar(), s.curve.randomScalar()
+	newPrivate := s.curve.sf.Add(s.curve.sf.Mul(s.priv, a), b)
+	newPublic := s.curve.scalarBaseMultBytes(newPrivate)
 
 	newServerKeypair, err = marshalKeypair(newPublic.Marshal(), newPrivate)
 	if err != nil {
@@ -225,9 +542,75 @@ func Rotate(serverKeypair []byte) (token *UpdateToken, newServerKeypair []byte,
 	}
 
 	token = &UpdateToken{
-		A: a.Bytes(),
-		B: b.Bytes(),
+		A:        a,
+		B:        b,
+		FromKey:  Fingerprint(s.PublicKey()),
+		ToKey:    Fingerprint(newPublic.Marshal()),
+		IssuedAt: time.Now().Unix(),
 	}
 
+	DefaultEventBus.publish(EventRotated, SourceServer, func(h EventHeader) Event {
+		return &RotatedEvent{EventHeader: h, PublicKey: newPublic.Marshal(), OldPublicKey: s.PublicKey(), Token: token}
+	})
 	return
 }
+
+func publishKeyGenerated(publicKey []byte) {
+	DefaultEventBus.publish(EventKeyGenerated, SourceServer, func(h EventHeader) Event {
+		return &KeyGeneratedEvent{EventHeader: h, PublicKey: publicKey}
+	})
+}
+
+// Warmup initializes lazily built curve tables and runs a self-test, a full enrollment and verification round trip
+// against server's own key, so the first requests after deploy don't pay for it.
+// It returns an error if the keypair is inconsistent or any step of the protocol fails
+func (s *Server) Warmup() error {
+
+	if x, err := s.key.scalarMult(s.curve.g); err != nil || !x.Equal(s.pub) {
+		return errors.New("self-test failed: public key does not match private key")
+	}
+
+	var opts []ClientOption
+	if s.noProofs {
+		opts = append(opts, WithTrustedServer())
+	}
+
+	c, err := NewClient(GenerateClientKeyForCurve(s.curve), s.kp.PublicKey, opts...)
+	if err != nil {
+		return errors.Wrap(err, "self-test failed")
+	}
+
+	enrollment, err := s.GetEnrollment()
+	if err != nil {
+		return errors.Wrap(err, "self-test failed")
+	}
+
+	password := []byte("self-test")
+	rec, key, err := c.EnrollAccount(password, enrollment)
+	if err != nil {
+		return errors.Wrap(err, "self-test failed")
+	}
+
+	for _, attempt := range [][]byte{password, []byte("wrong password")} {
+		req, err := c.CreateVerifyPasswordRequest(attempt, rec)
+		if err != nil {
+			return errors.Wrap(err, "self-test failed")
+		}
+
+		resp, err := s.VerifyPassword(req)
+		if err != nil {
+			return errors.Wrap(err, "self-test failed")
+		}
+
+		keyDec, err := c.CheckResponseAndDecrypt(attempt, rec, resp)
+		if err != nil {
+			return errors.Wrap(err, "self-test failed")
+		}
+
+		if resp.Res != bytes.Equal(attempt, password) || resp.Res != bytes.Equal(key, keyDec) {
+			return errors.New("self-test failed: unexpected verification result")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math/bits"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	shardDomain      = []byte("MigrationShard")
+	shardTokenDomain = []byte("MigrationToken")
+)
+
+// Shard selects one of Count ranges of server nonce hashes. A table too large for a single updater is
+// migrated by Count migrations, possibly on different machines, each running its own shard Index.
+// Assignment depends on the server nonce only, so it's the same everywhere and survives rotations
+type Shard struct {
+	Index int `json:"index"`
+	Count int `json:"count"`
+}
+
+// ShardOf returns the index of the shard a server nonce belongs to when the table is split into count shards
+func ShardOf(ns []byte, count int) int {
+	if count <= 1 {
+		return 0
+	}
+	h := binary.BigEndian.Uint64(TupleHash([][]byte{ns}, shardDomain))
+	i, _ := bits.Mul64(h, uint64(count))
+	return int(i)
+}
+
+// Contains reports whether the record belongs to the shard. Nil records belong to the first one
+func (s Shard) Contains(rec *EnrollmentRecord) bool {
+	if rec == nil {
+		return s.Index == 0
+	}
+	return ShardOf(rec.NS, s.Count) == s.Index
+}
+
+func (s Shard) validate() error {
+	if s.Count <= 0 || s.Index < 0 || s.Index >= s.Count {
+		return errors.New("invalid shard")
+	}
+	return nil
+}
+
+// shardedSource hides records of other shards. It keeps reading until it finds a record of its shard
+// or the source ends, so an empty batch still means the end was reached
+type shardedSource struct {
+	src   RecordSource
+	shard Shard
+}
+
+func (s *shardedSource) Records(ctx context.Context, after string, limit int) ([]KeyedRecord, error) {
+	for {
+		batch, err := s.src.Records(ctx, after, limit)
+		if err != nil || len(batch) == 0 {
+			return nil, err
+		}
+
+		var res []KeyedRecord
+		for _, kr := range batch {
+			if s.shard.Contains(kr.Record) {
+				res = append(res, kr)
+			}
+		}
+		if len(res) > 0 {
+			return res, nil
+		}
+		after = batch[len(batch)-1].Key
+	}
+}
+
+// NewShardedMigrations splits a migration into count migrations over disjoint shards of the same source.
+// Each shard needs its own checkpoint store, checkpoints returns one for a shard
+func NewShardedMigrations(m Migration, count int, checkpoints func(s Shard) CheckpointStore) ([]*Migration, error) {
+	if count <= 0 || checkpoints == nil {
+		return nil, errors.New("invalid shard count")
+	}
+
+	res := make([]*Migration, count)
+	for i := range res {
+		shard := m
+		shard.Shard = &Shard{Index: i, Count: count}
+		shard.Checkpoints = checkpoints(*shard.Shard)
+		res[i] = &shard
+	}
+	return res, nil
+}
+
+// RunShards runs sharded migrations in parallel and merges their checkpoints with MergeCheckpoints.
+// Migrations which are split between machines are run separately and merged by whoever collects the checkpoints
+func RunShards(ctx context.Context, migrations []*Migration) (*Checkpoint, error) {
+	if len(migrations) == 0 {
+		return nil, errors.New("no migrations")
+	}
+
+	cps := make([]*Checkpoint, len(migrations))
+	g, gctx := errgroup.WithContext(ctx)
+	for i, m := range migrations {
+		i, m := i, m
+		g.Go(func() (err error) {
+			cps[i], err = m.Run(gctx)
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return MergeCheckpoints(migrations[0].Token, cps...)
+}
+
+// MergeCheckpoints checks that every shard of a sharded migration has completed under the given token
+// and sums their progress. Each shard must be present exactly once
+func MergeCheckpoints(token *UpdateToken, cps ...*Checkpoint) (*Checkpoint, error) {
+	if token == nil || len(cps) == 0 {
+		return nil, errors.New("nothing to merge")
+	}
+
+	digest := tokenDigest(token)
+	seen := make([]bool, len(cps))
+	res := &Checkpoint{Token: digest, Done: true}
+
+	for _, cp := range cps {
+		if cp == nil || cp.Shard == nil || cp.Shard.Count != len(cps) || cp.Shard.validate() != nil {
+			return nil, errors.New("checkpoint shards don't cover the table")
+		}
+		if seen[cp.Shard.Index] {
+			return nil, errors.Errorf("shard %d is present twice", cp.Shard.Index)
+		}
+		seen[cp.Shard.Index] = true
+
+		if !bytes.Equal(cp.Token, digest) {
+			return nil, errors.Errorf("shard %d was migrated with another token", cp.Shard.Index)
+		}
+		if !cp.Done {
+			return nil, errors.Errorf("shard %d is not done", cp.Shard.Index)
+		}
+
+		res.Processed += cp.Processed
+		res.Updated += cp.Updated
+		res.Failed += cp.Failed
+		res.Failures = append(res.Failures, cp.Failures...)
+	}
+	return res, nil
+}
+
+// tokenDigest identifies an update token in checkpoints without storing the token itself
+func tokenDigest(token *UpdateToken) []byte {
+	return TupleHash([][]byte{token.A, token.B}, shardTokenDomain)
+}
@@ -0,0 +1,114 @@
+package phe
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lockedRecords lets sharded migrations share a memRecords
+type lockedRecords struct {
+	mu sync.Mutex
+	memRecords
+}
+
+func (l *lockedRecords) Records(ctx context.Context, after string, limit int) ([]KeyedRecord, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.memRecords.Records(ctx, after, limit)
+}
+
+func (l *lockedRecords) PutRecords(ctx context.Context, records []KeyedRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.memRecords.PutRecords(ctx, records)
+}
+
+func TestShardOf(t *testing.T) {
+	counts := make([]int, 4)
+	for i := 0; i < 400; i++ {
+		ns := []byte(fmt.Sprint(i))
+		s := ShardOf(ns, 4)
+		assert.Equal(t, s, ShardOf(ns, 4))
+		assert.True(t, Shard{Index: s, Count: 4}.Contains(&EnrollmentRecord{NS: ns}))
+		counts[s]++
+	}
+	for _, n := range counts {
+		assert.True(t, n > 50)
+	}
+	assert.Equal(t, 0, ShardOf([]byte("ns"), 1))
+}
+
+func TestRunShards(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	pub, err := GetPublicKey(serverKeypair)
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), pub)
+	assert.NoError(t, err)
+
+	store := &lockedRecords{memRecords: memRecords{records: map[string]*EnrollmentRecord{}}}
+	for i := 0; i < 20; i++ {
+		enrollment, err := GetEnrollment(serverKeypair)
+		assert.NoError(t, err)
+		store.records[fmt.Sprintf("%02d", i)], _, err = c.EnrollAccount(pwd, enrollment)
+		assert.NoError(t, err)
+	}
+	store.records["x"] = &EnrollmentRecord{NS: []byte("broken")}
+
+	token, newKeypair, err := Rotate(serverKeypair)
+	assert.NoError(t, err)
+	assert.NoError(t, c.RotateChecked(token, mustPublicKey(t, newKeypair)))
+
+	dir := t.TempDir()
+	checkpoints := func(s Shard) CheckpointStore {
+		return NewFileCheckpoint(filepath.Join(dir, fmt.Sprintf("shard-%d.json", s.Index)))
+	}
+	ms, err := NewShardedMigrations(Migration{Source: store, Sink: store, Token: token, BatchSize: 2}, 3, checkpoints)
+	assert.NoError(t, err)
+
+	cp, err := RunShards(context.Background(), ms)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(21), cp.Processed)
+	assert.Equal(t, int64(20), cp.Updated)
+	assert.Equal(t, int64(1), cp.Failed)
+
+	for k, rec := range store.records {
+		if k == "x" {
+			continue
+		}
+		req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+		assert.NoError(t, err)
+		resp, err := VerifyPassword(newKeypair, req)
+		assert.NoError(t, err)
+		assert.True(t, resp.Res, k)
+	}
+
+	var cps []*Checkpoint
+	for i := 0; i < 3; i++ {
+		shardCp, err := checkpoints(Shard{Index: i, Count: 3}).LoadCheckpoint(context.Background())
+		assert.NoError(t, err)
+		cps = append(cps, shardCp)
+	}
+
+	_, err = MergeCheckpoints(token, cps...)
+	assert.NoError(t, err)
+	_, err = MergeCheckpoints(token, cps[0], cps[1])
+	assert.Error(t, err)
+	_, err = MergeCheckpoints(token, cps[0], cps[1], cps[1])
+	assert.Error(t, err)
+
+	otherToken, _, err := Rotate(newKeypair)
+	assert.NoError(t, err)
+	_, err = MergeCheckpoints(otherToken, cps...)
+	assert.Error(t, err)
+
+	// a shard's checkpoint can't be resumed with another token or shard
+	_, err = (&Migration{Source: store, Sink: store, Checkpoints: checkpoints(Shard{Count: 3}), Token: otherToken, Shard: &Shard{Count: 3}}).Run(context.Background())
+	assert.Error(t, err)
+	_, err = (&Migration{Source: store, Sink: store, Checkpoints: checkpoints(Shard{Count: 3}), Token: token, Shard: &Shard{Index: 1, Count: 3}}).Run(context.Background())
+	assert.Error(t, err)
+}
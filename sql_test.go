@@ -0,0 +1,44 @@
+package phe
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	_ sql.Scanner   = (*EnrollmentRecord)(nil)
+	_ driver.Valuer = (*EnrollmentRecord)(nil)
+)
+
+func TestEnrollmentRecord_ScanValue(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	c, err := NewClient(GenerateClientKey(), mustPublicKey(t, serverKeypair))
+	assert.NoError(t, err)
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	v, err := rec.Value()
+	assert.NoError(t, err)
+	assert.True(t, driver.IsValue(v))
+
+	var scanned EnrollmentRecord
+	assert.NoError(t, scanned.Scan(v))
+	assert.Equal(t, rec, &scanned)
+
+	scanned = EnrollmentRecord{}
+	assert.NoError(t, scanned.Scan(string(v.([]byte))))
+	assert.Equal(t, rec, &scanned)
+
+	assert.Error(t, scanned.Scan(nil))
+	assert.Error(t, scanned.Scan(int64(1)))
+	assert.Error(t, scanned.Scan(v.([]byte)[:10]))
+	assert.Equal(t, rec, &scanned)
+
+	_, err = (&EnrollmentRecord{}).Value()
+	assert.Error(t, err)
+}
@@ -0,0 +1,263 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+// Package sqlstore keeps enrollment records in a database/sql table.
+// Records are stored in the compact encoding, one row per key
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/pkg/errors"
+)
+
+// iteratePage is the number of rows Iterate fetches with a single query
+const iteratePage = 500
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Dialect holds SQL syntax which differs between databases
+type Dialect struct {
+	placeholder func(n int) string
+	blobType    string
+	upsert      string
+	// insertNew makes an INSERT affect no rows if the key exists
+	insertNew string
+}
+
+var (
+	// SQLite dialect
+	SQLite = &Dialect{
+		placeholder: func(int) string { return "?" },
+		blobType:    "BLOB",
+		upsert:      "ON CONFLICT (record_key) DO UPDATE SET record = excluded.record",
+		insertNew:   "ON CONFLICT (record_key) DO NOTHING",
+	}
+	// MySQL dialect
+	MySQL = &Dialect{
+		placeholder: func(int) string { return "?" },
+		blobType:    "VARBINARY(255)",
+		upsert:      "ON DUPLICATE KEY UPDATE record = VALUES(record)",
+		// a no-op update affects no rows unless the connection reports found rows
+		insertNew: "ON DUPLICATE KEY UPDATE record_key = record_key",
+	}
+	// Postgres dialect
+	Postgres = &Dialect{
+		placeholder: func(n int) string { return "$" + strconv.Itoa(n) },
+		blobType:    "BYTEA",
+		upsert:      "ON CONFLICT (record_key) DO UPDATE SET record = excluded.record",
+		insertNew:   "ON CONFLICT (record_key) DO NOTHING",
+	}
+)
+
+// Store is a phe.RecordStore on top of a database/sql table
+type Store struct {
+	db    *sql.DB
+	table string
+	d     *Dialect
+}
+
+var _ phe.RecordStore = (*Store)(nil)
+
+// New returns a store using the given table, which can be created with CreateSchema
+func New(db *sql.DB, table string, d *Dialect) (*Store, error) {
+	if db == nil || d == nil {
+		return nil, errors.New("invalid store parameters")
+	}
+
+	if !tableName.MatchString(table) {
+		return nil, errors.New("invalid table name")
+	}
+
+	return &Store{db: db, table: table, d: d}, nil
+}
+
+// Schema returns the statement creating the records table
+func (s *Store) Schema() string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (record_key VARCHAR(255) NOT NULL PRIMARY KEY, record %s NOT NULL)", s.table, s.d.blobType)
+}
+
+// CreateSchema creates the records table if it doesn't exist
+func (s *Store) CreateSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, s.Schema())
+	return err
+}
+
+// Get returns the record stored under key or phe.ErrRecordNotFound
+func (s *Store) Get(ctx context.Context, key string) (*phe.EnrollmentRecord, error) {
+	var blob []byte
+	err := s.db.QueryRowContext(ctx, s.query("SELECT record FROM %s WHERE record_key = %s", 1), key).Scan(&blob)
+	if err == sql.ErrNoRows {
+		return nil, phe.ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return phe.UnmarshalCompactRecord(blob)
+}
+
+// Put stores the record under key replacing any existing one
+func (s *Store) Put(ctx context.Context, key string, rec *phe.EnrollmentRecord) error {
+	blob, err := phe.MarshalCompactRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, s.query("INSERT INTO %s (record_key, record) VALUES (%s, %s) "+s.d.upsert, 2), key, blob)
+	return err
+}
+
+// Create stores the record under key only if there's none yet, otherwise it returns phe.ErrRecordConflict.
+// MySQL connections must not set clientFoundRows for conflicts to be detected
+func (s *Store) Create(ctx context.Context, key string, rec *phe.EnrollmentRecord) error {
+	blob, err := phe.MarshalCompactRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.db.ExecContext(ctx, s.query("INSERT INTO %s (record_key, record) VALUES (%s, %s) "+s.d.insertNew, 2), key, blob)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return phe.ErrRecordConflict
+	}
+	return nil
+}
+
+// Iterate calls fn for records with keys greater than after in ascending key order, fetching them page by page
+func (s *Store) Iterate(ctx context.Context, after string, fn func(key string, rec *phe.EnrollmentRecord) error) error {
+	q := s.query("SELECT record_key, record FROM %s WHERE record_key > %s ORDER BY record_key LIMIT "+strconv.Itoa(iteratePage), 1)
+
+	for {
+		rows, err := s.db.QueryContext(ctx, q, after)
+		if err != nil {
+			return err
+		}
+
+		keys, blobs, err := scanPage(rows)
+		if err != nil {
+			return err
+		}
+
+		for i, key := range keys {
+			rec, err := phe.UnmarshalCompactRecord(blobs[i])
+			if err != nil {
+				return errors.Wrapf(err, "record %s", key)
+			}
+
+			if err = fn(key, rec); err == phe.ErrStopIteration {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+
+		if len(keys) < iteratePage {
+			return nil
+		}
+		after = keys[len(keys)-1]
+	}
+}
+
+// UpdateConditional replaces the record under key only if the stored one equals old.
+// It returns phe.ErrRecordConflict if it doesn't and phe.ErrRecordNotFound if there's no record.
+// The stored record may be packed with an earlier version, it's written with the current one
+func (s *Store) UpdateConditional(ctx context.Context, key string, old, rec *phe.EnrollmentRecord) error {
+	oldBlobs, err := phe.CompactRecordEncodings(old)
+	if err != nil {
+		return err
+	}
+
+	blob, err := phe.MarshalCompactRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	for _, oldBlob := range oldBlobs {
+		res, err := s.db.ExecContext(ctx, s.query("UPDATE %s SET record = %s WHERE record_key = %s AND record = %s", 3), blob, key, oldBlob)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return nil
+		}
+	}
+
+	if _, err = s.Get(ctx, key); err != nil {
+		return err
+	}
+	return phe.ErrRecordConflict
+}
+
+// query formats a statement with the table name and n placeholders
+func (s *Store) query(format string, n int) string {
+	args := []interface{}{s.table}
+	for i := 1; i <= n; i++ {
+		args = append(args, s.d.placeholder(i))
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+func scanPage(rows *sql.Rows) (keys []string, blobs [][]byte, err error) {
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		var blob []byte
+		if err = rows.Scan(&key, &blob); err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, key)
+		blobs = append(blobs, blob)
+	}
+	return keys, blobs, rows.Err()
+}
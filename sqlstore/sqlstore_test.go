@@ -0,0 +1,105 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/passw0rd/phe-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRecord(t *testing.T, serverKeypair []byte, c *phe.Client) *phe.EnrollmentRecord {
+	enrollment, err := phe.GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccount([]byte("password"), enrollment)
+	assert.NoError(t, err)
+	return rec
+}
+
+func TestStore(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "records.db"))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	s, err := New(db, "phe_records", SQLite)
+	assert.NoError(t, err)
+	assert.NoError(t, s.CreateSchema(ctx))
+
+	_, err = New(db, "records; DROP TABLE users", SQLite)
+	assert.Error(t, err)
+
+	serverKeypair, err := phe.GenerateServerKeypair()
+	assert.NoError(t, err)
+	pub, err := phe.GetPublicKey(serverKeypair)
+	assert.NoError(t, err)
+	c, err := phe.NewClient(phe.GenerateClientKey(), pub)
+	assert.NoError(t, err)
+
+	_, err = s.Get(ctx, "alice")
+	assert.Equal(t, phe.ErrRecordNotFound, err)
+
+	rec := newRecord(t, serverKeypair, c)
+	assert.NoError(t, s.Put(ctx, "alice", rec))
+	got, err := s.Get(ctx, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, rec, got)
+
+	//put replaces
+	rec2 := newRecord(t, serverKeypair, c)
+	assert.NoError(t, s.Put(ctx, "alice", rec2))
+
+	assert.Equal(t, phe.ErrRecordConflict, s.UpdateConditional(ctx, "alice", rec, rec))
+	assert.NoError(t, s.UpdateConditional(ctx, "alice", rec2, rec))
+	assert.Equal(t, phe.ErrRecordNotFound, s.UpdateConditional(ctx, "bob", rec, rec2))
+
+	assert.Equal(t, phe.ErrRecordConflict, s.Create(ctx, "alice", rec))
+	assert.NoError(t, s.Create(ctx, "bob", rec))
+	got, err = s.Get(ctx, "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, rec, got)
+	_, err = db.Exec("DELETE FROM phe_records WHERE record_key = ?", "bob")
+	assert.NoError(t, err)
+
+	//records packed with an earlier version are matched and upgraded
+	encodings, err := phe.CompactRecordEncodings(rec)
+	assert.NoError(t, err)
+	_, err = db.Exec("UPDATE phe_records SET record = ? WHERE record_key = ?", encodings[1], "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, phe.ErrRecordConflict, s.UpdateConditional(ctx, "alice", rec2, rec))
+	assert.NoError(t, s.UpdateConditional(ctx, "alice", rec, rec))
+	var stored []byte
+	assert.NoError(t, db.QueryRow("SELECT record FROM phe_records WHERE record_key = ?", "alice").Scan(&stored))
+	assert.Equal(t, encodings[0], stored)
+
+	for i := 0; i < iteratePage+3; i++ {
+		assert.NoError(t, s.Put(ctx, fmt.Sprintf("user%04d", i), rec))
+	}
+
+	var keys []string
+	assert.NoError(t, s.Iterate(ctx, "alice", func(key string, r *phe.EnrollmentRecord) error {
+		keys = append(keys, key)
+		return nil
+	}))
+	assert.Len(t, keys, iteratePage+3)
+	assert.Equal(t, "user0000", keys[0])
+
+	page, err := phe.StoreRecords{RecordStore: s}.Records(ctx, "", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", page[0].Key)
+	assert.Equal(t, "user0000", page[1].Key)
+}
+
+func TestDialects(t *testing.T) {
+	s := &Store{table: "records", d: Postgres}
+	assert.Equal(t, "UPDATE records SET record = $1 WHERE record_key = $2 AND record = $3",
+		s.query("UPDATE %s SET record = %s WHERE record_key = %s AND record = %s", 3))
+	assert.Contains(t, s.Schema(), "BYTEA")
+
+	s.d = MySQL
+	assert.Contains(t, s.query("INSERT INTO %s (record_key, record) VALUES (%s, %s) "+MySQL.upsert, 2), "VALUES (?, ?) ON DUPLICATE KEY")
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StatsWindow is the period rates and averages of ServerStats are computed over
+const StatsWindow = 10 * time.Second
+
+const statsBuckets = int(StatsWindow / time.Second)
+
+// ServerStats is a snapshot of server load for autoscalers. Proof generation is CPU-bound,
+// so load follows the number of proofs and their cost rather than the request rate
+type ServerStats struct {
+	// InFlight is the number of enrollments and verifications being computed
+	InFlight int64
+	// Queued is the number of bulk items waiting for a worker or a limiter
+	Queued int64
+	// ProofsPerSecond is the rate of generated proofs over the last StatsWindow
+	ProofsPerSecond float64
+	// AvgVerifyCost is the average duration of VerifyPassword over the last StatsWindow, zero if there were none
+	AvgVerifyCost time.Duration
+}
+
+// serverStats is shared by all requests of a Server, counters go first to stay 64-bit aligned
+type serverStats struct {
+	inFlight int64
+	queued   int64
+	created  time.Time
+	now      func() time.Time
+
+	mu      sync.Mutex
+	buckets [statsBuckets]statsBucket
+}
+
+// statsBucket holds one second of the window
+type statsBucket struct {
+	sec         int64
+	proofs      uint64
+	verifies    uint64
+	verifyNanos int64
+}
+
+func newServerStats() *serverStats {
+	return &serverStats{created: time.Now(), now: time.Now}
+}
+
+// Stats returns a snapshot of server load. Only a long-lived Server collects them,
+// package level functions create a new one for every call
+func (s *Server) Stats() ServerStats {
+	st := s.stats
+	now := st.now()
+	stats := ServerStats{
+		InFlight: atomic.LoadInt64(&st.inFlight),
+		Queued:   atomic.LoadInt64(&st.queued),
+	}
+
+	var proofs, verifies uint64
+	var nanos int64
+	sec := now.Unix()
+	st.mu.Lock()
+	for _, b := range st.buckets {
+		if b.sec > sec-int64(statsBuckets) && b.sec <= sec {
+			proofs += b.proofs
+			verifies += b.verifies
+			nanos += b.verifyNanos
+		}
+	}
+	st.mu.Unlock()
+
+	// a server younger than the window has had less time to generate proofs
+	window := now.Sub(st.created)
+	if window > StatsWindow {
+		window = StatsWindow
+	}
+	if window < time.Second {
+		window = time.Second
+	}
+	stats.ProofsPerSecond = float64(proofs) / window.Seconds()
+	if verifies > 0 {
+		stats.AvgVerifyCost = time.Duration(nanos / int64(verifies))
+	}
+	return stats
+}
+
+// begin counts an operation as in flight until the returned func is called
+func (st *serverStats) begin() func() {
+	atomic.AddInt64(&st.inFlight, 1)
+	return func() {
+		atomic.AddInt64(&st.inFlight, -1)
+	}
+}
+
+// enqueue counts n bulk items as queued. Each item calls start when it begins,
+// done removes the ones which never started
+func (st *serverStats) enqueue(n int) (start func(), done func()) {
+	var started int64
+	atomic.AddInt64(&st.queued, int64(n))
+	start = func() {
+		atomic.AddInt64(&started, 1)
+		atomic.AddInt64(&st.queued, -1)
+	}
+	done = func() {
+		atomic.AddInt64(&st.queued, atomic.LoadInt64(&started)-int64(n))
+	}
+	return
+}
+
+// proof records a generated proof
+func (st *serverStats) proof() {
+	st.record(func(b *statsBucket) {
+		b.proofs++
+	})
+}
+
+// verified records the cost of a verification which started at start
+func (st *serverStats) verified(start time.Time) {
+	d := st.now().Sub(start)
+	st.record(func(b *statsBucket) {
+		b.verifies++
+		b.verifyNanos += int64(d)
+	})
+}
+
+func (st *serverStats) record(fn func(b *statsBucket)) {
+	sec := st.now().Unix()
+	st.mu.Lock()
+	b := &st.buckets[sec%int64(statsBuckets)]
+	if b.sec != sec {
+		*b = statsBucket{sec: sec}
+	}
+	fn(b)
+	st.mu.Unlock()
+}
@@ -0,0 +1,55 @@
+package phe
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_Stats(t *testing.T) {
+	s, err := NewServer(mustKeypair(t))
+	assert.NoError(t, err)
+	s.stats.created = time.Now().Add(-time.Minute)
+	c, err := NewClient(GenerateClientKey(), s.PublicKey())
+	assert.NoError(t, err)
+
+	assert.Equal(t, ServerStats{}, s.Stats())
+
+	enrollment, err := s.GetEnrollment()
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+	for _, p := range [][]byte{pwd, []byte("wrong")} {
+		req, err := c.CreateVerifyPasswordRequest(p, rec)
+		assert.NoError(t, err)
+		_, err = s.VerifyPassword(req)
+		assert.NoError(t, err)
+	}
+
+	stats := s.Stats()
+	assert.Equal(t, 0.3, stats.ProofsPerSecond)
+	assert.True(t, stats.AvgVerifyCost > 0)
+	assert.Equal(t, int64(0), stats.InFlight)
+
+	// items waiting for the limiter are queued
+	l := NewLimiter(1)
+	assert.NoError(t, l.Acquire(context.Background(), 1))
+	done := make(chan error)
+	go func() {
+		_, err := s.GetEnrollments(context.Background(), 4, WithLimiter(l), WithWorkers(4))
+		done <- err
+	}()
+	assert.Eventually(t, func() bool { return s.Stats().Queued == 4 }, time.Second, time.Millisecond)
+	l.Release(1)
+	assert.NoError(t, <-done)
+	assert.Equal(t, int64(0), s.Stats().Queued)
+
+	// cancelled items which never started aren't left queued
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.GetEnrollments(ctx, 4)
+	assert.Error(t, err)
+	assert.Equal(t, int64(0), s.Stats().Queued)
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrRecordNotFound is returned by RecordStore when there's no record under the key
+	ErrRecordNotFound = errors.New("record not found")
+	// ErrRecordConflict is returned by RecordStore.UpdateConditional when the stored record has changed
+	// and by RecordStore.Create when there's a record already
+	ErrRecordConflict = errors.New("record was changed concurrently")
+	// ErrStopIteration can be returned from a RecordStore.Iterate callback to stop iteration without an error
+	ErrStopIteration = errors.New("stop iteration")
+)
+
+// RecordStore keeps enrollment records under string keys, usually user IDs
+type RecordStore interface {
+	Get(ctx context.Context, key string) (*EnrollmentRecord, error)
+	Put(ctx context.Context, key string, rec *EnrollmentRecord) error
+	// Create stores the record under key only if there's none yet, otherwise it returns ErrRecordConflict
+	Create(ctx context.Context, key string, rec *EnrollmentRecord) error
+	// Iterate calls fn for records with keys greater than after in ascending key order.
+	// Iteration stops at the first error fn returns, ErrStopIteration isn't reported
+	Iterate(ctx context.Context, after string, fn func(key string, rec *EnrollmentRecord) error) error
+	// UpdateConditional replaces the record under key with rec only if the stored one equals old
+	UpdateConditional(ctx context.Context, key string, old, rec *EnrollmentRecord) error
+}
+
+// StoreRecords adapts a RecordStore to be the source and the sink of a Migration
+type StoreRecords struct {
+	RecordStore
+}
+
+// Records returns up to limit records with keys greater than after
+func (s StoreRecords) Records(ctx context.Context, after string, limit int) ([]KeyedRecord, error) {
+	res := make([]KeyedRecord, 0, limit)
+	err := s.Iterate(ctx, after, func(key string, rec *EnrollmentRecord) error {
+		res = append(res, KeyedRecord{Key: key, Record: rec})
+		if len(res) == limit {
+			return ErrStopIteration
+		}
+		return nil
+	})
+	return res, err
+}
+
+// PutRecords stores records one by one. Records with Previous set replace it only if it's still stored,
+// the ones which changed or were deleted since are skipped and reported in a *BatchError
+func (s StoreRecords) PutRecords(ctx context.Context, records []KeyedRecord) error {
+	var errs []error
+	for i, kr := range records {
+		if kr.Previous == nil {
+			if err := s.Put(ctx, kr.Key, kr.Record); err != nil {
+				return errors.Wrapf(err, "could not put record %s", kr.Key)
+			}
+			continue
+		}
+
+		err := s.UpdateConditional(ctx, kr.Key, kr.Previous, kr.Record)
+		if errors.Is(err, ErrRecordConflict) || errors.Is(err, ErrRecordNotFound) {
+			if errs == nil {
+				errs = make([]error, len(records))
+			}
+			errs[i] = errors.Wrapf(err, "could not update record %s", kr.Key)
+		} else if err != nil {
+			return errors.Wrapf(err, "could not update record %s", kr.Key)
+		}
+	}
+
+	if errs != nil {
+		return &BatchError{Errors: errs}
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// StreamChunkSize is the length of plaintext sealed in each chunk of a stream
+	StreamChunkSize = 64 * 1024
+
+	streamSaltLen   = 32
+	streamHeaderLen = 1 + streamSaltLen
+	maxStreamChunks = 1 << 32
+)
+
+var streamInfo = []byte("PheCipherStream")
+
+// EncryptStream returns a writer which encrypts everything written to it into dst. The stream is split into chunks
+// of StreamChunkSize which are sealed separately under a key derived from a random salt, every chunk's nonce
+// holds its number and the last one is marked, so reordered, dropped or truncated chunks are detected.
+// Close must be called to seal the last chunk, it doesn't close dst
+func (c *PheCipher) EncryptStream(dst io.Writer, additionalData []byte) (io.WriteCloser, error) {
+	header := make([]byte, streamHeaderLen)
+	header[0] = byte(c.suite)
+	random.MustRead(header[1:])
+
+	aead, err := c.streamAEAD(c.suite, header[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = dst.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &streamWriter{
+		dst:  dst,
+		aead: aead,
+		ad:   pheCipherAD(header[0], additionalData),
+		buf:  make([]byte, 0, StreamChunkSize+aead.Overhead()),
+	}, nil
+}
+
+// DecryptStream returns a reader of the plaintext of a stream produced by EncryptStream with the same additional data.
+// Data of a chunk is returned only after the chunk is authenticated, a stream which ends early fails with an error
+// after its last complete chunk
+func (c *PheCipher) DecryptStream(src io.Reader, additionalData []byte) (io.Reader, error) {
+	header := make([]byte, streamHeaderLen)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return nil, errors.New("invalid stream header")
+	}
+
+	if _, ok := c.keys[PheCipherSuite(header[0])]; !ok {
+		return nil, errors.New("unsupported cipher suite")
+	}
+
+	aead, err := c.streamAEAD(PheCipherSuite(header[0]), header[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &streamReader{
+		src:   bufio.NewReaderSize(src, StreamChunkSize+aead.Overhead()),
+		aead:  aead,
+		ad:    pheCipherAD(header[0], additionalData),
+		chunk: make([]byte, StreamChunkSize+aead.Overhead()),
+	}, nil
+}
+
+// streamAEAD derives the key of a single stream from the suite key and the stream's salt
+func (c *PheCipher) streamAEAD(suite PheCipherSuite, salt []byte) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha512.New512_256, c.keys[suite], salt, streamInfo), key); err != nil {
+		return nil, err
+	}
+	return newSuiteAEAD(suite, key)
+}
+
+// streamNonce is zeroes followed by the big-endian chunk number and 1 for the last chunk
+func streamNonce(aead cipher.AEAD, counter uint64, last bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint32(nonce[len(nonce)-5:], uint32(counter))
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+type streamWriter struct {
+	dst     io.Writer
+	aead    cipher.AEAD
+	ad      []byte
+	buf     []byte
+	counter uint64
+	err     error
+}
+
+func (w *streamWriter) Write(p []byte) (n int, err error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	for len(p) > 0 {
+		// a full chunk is sealed only once more data arrives, as the last chunk is sealed differently
+		if len(w.buf) == StreamChunkSize {
+			if w.err = w.seal(false); w.err != nil {
+				return n, w.err
+			}
+		}
+
+		k := StreamChunkSize - len(w.buf)
+		if k > len(p) {
+			k = len(p)
+		}
+		w.buf = append(w.buf, p[:k]...)
+		p = p[k:]
+		n += k
+	}
+	return n, nil
+}
+
+// Close seals the last chunk, which may be empty
+func (w *streamWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.seal(true)
+	if w.err == nil {
+		w.err = errors.New("stream is closed")
+		return nil
+	}
+	return w.err
+}
+
+func (w *streamWriter) seal(last bool) error {
+	if w.counter >= maxStreamChunks {
+		return errors.New("stream too long")
+	}
+
+	out := w.aead.Seal(w.buf[:0], streamNonce(w.aead, w.counter, last), w.buf, w.ad)
+	w.counter++
+	w.buf = w.buf[:0]
+
+	_, err := w.dst.Write(out)
+	return err
+}
+
+type streamReader struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	ad      []byte
+	chunk   []byte
+	plain   []byte
+	counter uint64
+	done    bool
+	err     error
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		r.err = r.open()
+	}
+
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// open reads and authenticates the next chunk. A chunk is the last one if nothing follows it
+func (r *streamReader) open() error {
+	n, err := io.ReadFull(r.src, r.chunk)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+
+	last := n < len(r.chunk)
+	if !last {
+		if _, err = r.src.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if r.counter >= maxStreamChunks {
+		return errors.New("stream too long")
+	}
+
+	plain, err := r.aead.Open(r.chunk[:0], streamNonce(r.aead, r.counter, last), r.chunk[:n], r.ad)
+	if err != nil {
+		return errors.New("stream decryption failed")
+	}
+	r.counter++
+	r.plain = plain
+	r.done = last
+	return nil
+}
@@ -0,0 +1,138 @@
+package phe
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encryptStream(t *testing.T, c *PheCipher, data, ad []byte) []byte {
+	buf := &bytes.Buffer{}
+	w, err := c.EncryptStream(buf, ad)
+	assert.NoError(t, err)
+
+	// odd write sizes so chunks don't line up with writes
+	for len(data) > 0 {
+		n := 1000
+		if n > len(data) {
+			n = len(data)
+		}
+		_, err = w.Write(data[:n])
+		assert.NoError(t, err)
+		data = data[n:]
+	}
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestStream(t *testing.T) {
+	key := make([]byte, 32)
+	random.MustRead(key)
+
+	for _, suite := range []PheCipherSuite{PheCipherAES256GCM, PheCipherXChaCha20Poly1305, PheCipherAES256GCMSIV} {
+		c, err := NewPheCipher(key, WithCipherSuite(suite))
+		assert.NoError(t, err)
+
+		for _, size := range []int{0, 1, StreamChunkSize, 2*StreamChunkSize + 17} {
+			data := make([]byte, size)
+			random.MustRead(data)
+
+			ct := encryptStream(t, c, data, []byte("backup-1"))
+			chunks := size/StreamChunkSize + 1
+			if size > 0 && size%StreamChunkSize == 0 {
+				chunks--
+			}
+			assert.Len(t, ct, streamHeaderLen+size+chunks*16)
+
+			r, err := c.DecryptStream(bytes.NewReader(ct), []byte("backup-1"))
+			assert.NoError(t, err)
+			pt, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.True(t, bytes.Equal(data, pt))
+
+			r, err = c.DecryptStream(bytes.NewReader(ct), []byte("backup-2"))
+			assert.NoError(t, err)
+			_, err = io.ReadAll(r)
+			assert.Error(t, err)
+		}
+	}
+}
+
+func TestStream_Tampered(t *testing.T) {
+	key := make([]byte, 32)
+	random.MustRead(key)
+	c, err := NewPheCipher(key)
+	assert.NoError(t, err)
+
+	data := make([]byte, 3*StreamChunkSize)
+	random.MustRead(data)
+	ct := encryptStream(t, c, data, nil)
+	chunk := StreamChunkSize + 16
+
+	read := func(ct []byte) ([]byte, error) {
+		r, err := c.DecryptStream(bytes.NewReader(ct), nil)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+
+	// truncated at a chunk boundary, the chunk before the cut fails as it's not marked last
+	pt, err := read(ct[:streamHeaderLen+2*chunk])
+	assert.Error(t, err)
+	assert.True(t, bytes.Equal(data[:StreamChunkSize], pt))
+
+	// truncated inside a chunk
+	_, err = read(ct[:len(ct)-1])
+	assert.Error(t, err)
+
+	// header only
+	_, err = read(ct[:streamHeaderLen])
+	assert.Error(t, err)
+	_, err = read(ct[:streamHeaderLen-1])
+	assert.Error(t, err)
+
+	// swapped chunks
+	swapped := append([]byte{}, ct[:streamHeaderLen]...)
+	swapped = append(swapped, ct[streamHeaderLen+chunk:streamHeaderLen+2*chunk]...)
+	swapped = append(swapped, ct[streamHeaderLen:streamHeaderLen+chunk]...)
+	swapped = append(swapped, ct[streamHeaderLen+2*chunk:]...)
+	pt, err = read(swapped)
+	assert.Error(t, err)
+	assert.Empty(t, pt)
+
+	// appended data
+	_, err = read(append(append([]byte{}, ct...), 0))
+	assert.Error(t, err)
+
+	// flipped bit
+	flipped := append([]byte{}, ct...)
+	flipped[streamHeaderLen+chunk+5] ^= 1
+	_, err = read(flipped)
+	assert.Error(t, err)
+
+	// another salt derives another key
+	flipped = append([]byte{}, ct...)
+	flipped[1] ^= 1
+	_, err = read(flipped)
+	assert.Error(t, err)
+
+	flipped[0] = 9
+	_, err = read(flipped)
+	assert.Error(t, err)
+}
+
+func TestStream_Closed(t *testing.T) {
+	key := make([]byte, 32)
+	random.MustRead(key)
+	c, err := NewPheCipher(key)
+	assert.NoError(t, err)
+
+	w, err := c.EncryptStream(io.Discard, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	_, err = w.Write([]byte{1})
+	assert.Error(t, err)
+}
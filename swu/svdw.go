@@ -0,0 +1,186 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package swu
+
+/*
+ Implementation of Shallue-van de Woestijne mapping (RFC 9380, section 6.6.1) for curves SWU can't handle, like a = 0
+*/
+
+import (
+	"crypto/elliptic"
+	"math/big"
+)
+
+// SvdW maps hashes to points of any short Weierstrass curve y^2 = x^3 + a*x + b over a prime field with p = 3 mod 4
+type SvdW struct {
+	gf             *GF
+	a, b, z        *big.Int
+	c1, c2, c3, c4 *big.Int
+	sqrtExp        *big.Int
+	legendreExp    *big.Int
+	size           int
+}
+
+// NewSvdW precomputes mapping constants for the curve with the given a coefficient. Curve's own B is used as b
+func NewSvdW(curve elliptic.Curve, a *big.Int) *SvdW {
+	params := curve.Params()
+	p := params.P
+	if new(big.Int).Mod(p, four).Cmp(three) != 0 {
+		panic("unsupported curve")
+	}
+
+	gf := &GF{p}
+	s := &SvdW{
+		gf:          gf,
+		a:           new(big.Int).Mod(a, p),
+		b:           params.B,
+		sqrtExp:     new(big.Int).Rsh(new(big.Int).Add(p, one), 2),
+		legendreExp: new(big.Int).Rsh(new(big.Int).Sub(p, one), 1),
+		size:        (p.BitLen() + 7) / 8,
+	}
+
+	s.z = s.findZ()
+
+	// c1 = g(Z), c2 = -Z / 2, c3 = sqrt(-g(Z) * (3 * Z^2 + 4 * A)) with sgn0(c3) = 0, c4 = -4 * g(Z) / (3 * Z^2 + 4 * A)
+	gz := s.g(s.z)
+	t := s.tz(s.z)
+	s.c1 = gz
+	s.c2 = gf.Neg(gf.Div(s.z, two))
+	s.c3 = s.sqrt(gf.Neg(gf.Mul(gz, t)))
+	if s.c3.Bit(0) == 1 {
+		s.c3 = gf.Neg(s.c3)
+	}
+	s.c4 = gf.Neg(gf.Div(gf.Mul(four, gz), t))
+	return s
+}
+
+// Size returns the length of hash HashToPoint expects
+func (s *SvdW) Size() int {
+	return s.size
+}
+
+// HashToPoint maps hash of Size() bytes to a point on curve
+func (s *SvdW) HashToPoint(hash []byte) (x, y *big.Int) {
+	if len(hash) != s.size {
+		panic("invalid hash length")
+	}
+
+	gf := s.gf
+	u := new(big.Int).SetBytes(hash)
+	u.Mod(u, gf.P)
+
+	tv1 := gf.Mul(gf.Square(u), s.c1)
+	tv2 := gf.Add(one, tv1)
+	tv1 = gf.Sub(one, tv1)
+	tv3 := s.inv0(gf.Mul(tv1, tv2))
+	tv4 := gf.Mul(gf.Mul(gf.Mul(u, tv1), tv3), s.c3)
+
+	x1 := gf.Sub(s.c2, tv4)
+	x2 := gf.Add(s.c2, tv4)
+	x3 := gf.Mul(gf.Square(gf.Mul(gf.Square(tv2), tv3)), s.c4)
+	x3 = gf.Add(x3, s.z)
+
+	switch {
+	case s.isSquare(s.g(x1)):
+		x = x1
+	case s.isSquare(s.g(x2)):
+		x = x2
+	default:
+		x = x3
+	}
+
+	y = s.sqrt(s.g(x))
+	if u.Bit(0) != y.Bit(0) {
+		y = gf.Neg(y)
+		y.Mod(y, gf.P)
+	}
+	return
+}
+
+// findZ picks the first of 1, -1, 2, -2, ... satisfying RFC 9380 criteria for SvdW
+func (s *SvdW) findZ() *big.Int {
+	gf := s.gf
+	for ctr := int64(1); ; ctr++ {
+		for _, z := range []*big.Int{big.NewInt(ctr), gf.Neg(big.NewInt(ctr))} {
+			gz := s.g(z)
+			t := s.tz(z)
+			if gz.Sign() == 0 || t.Sign() == 0 {
+				continue
+			}
+
+			// -(3 * Z^2 + 4 * A) / (4 * g(Z)) must be a non-zero square
+			h := gf.Neg(gf.Div(t, gf.Mul(four, gz)))
+			if h.Sign() == 0 || !s.isSquare(h) {
+				continue
+			}
+
+			// at least one of g(Z) and g(-Z / 2) must be square
+			if s.isSquare(gz) || s.isSquare(s.g(gf.Neg(gf.Div(z, two)))) {
+				return z
+			}
+		}
+	}
+}
+
+// g computes x^3 + a*x + b
+func (s *SvdW) g(x *big.Int) *big.Int {
+	gf := s.gf
+	return gf.Add(gf.Add(gf.Cube(x), gf.Mul(s.a, x)), s.b)
+}
+
+// tz computes 3 * Z^2 + 4 * A
+func (s *SvdW) tz(z *big.Int) *big.Int {
+	gf := s.gf
+	return gf.Add(gf.Mul(three, gf.Square(z)), gf.Mul(four, s.a))
+}
+
+func (s *SvdW) isSquare(x *big.Int) bool {
+	l := s.gf.Pow(x, s.legendreExp)
+	return l.Sign() == 0 || l.Cmp(one) == 0
+}
+
+func (s *SvdW) sqrt(x *big.Int) *big.Int {
+	return s.gf.Pow(x, s.sqrtExp)
+}
+
+// inv0 is modular inverse which maps 0 to 0
+func (s *SvdW) inv0(x *big.Int) *big.Int {
+	if x.Sign() == 0 {
+		return new(big.Int)
+	}
+	return s.gf.Inv(x)
+}
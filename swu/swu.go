@@ -46,23 +46,45 @@ import (
 	"math/big"
 )
 
-var (
-	p        = elliptic.P256().Params().P
-	a        *big.Int
-	b        = elliptic.P256().Params().B
+// SWU maps hashes to points of a short Weierstrass curve with a = -3 over a prime field with p = 3 mod 4
+type SWU struct {
+	gf       *GF
+	a, b     *big.Int
 	mba      *big.Int
-	gf       = &GF{p}
 	p34, p14 *big.Int
-)
+	size     int
+}
+
+var p256 = New(elliptic.P256())
+
+// New precomputes SWU constants for the given curve
+func New(curve elliptic.Curve) *SWU {
+	params := curve.Params()
+	p := params.P
+	if new(big.Int).Mod(p, four).Cmp(three) != 0 {
+		panic("unsupported curve")
+	}
+
+	gf := &GF{p}
+	s := &SWU{
+		gf:   gf,
+		a:    gf.Neg(three),
+		b:    params.B,
+		size: (p.BitLen() + 7) / 8,
+	}
 
-func init() {
-	a = gf.Neg(three)
-	ba := gf.Div(b, a)
-	mba = gf.Neg(ba)
+	ba := gf.Div(s.b, s.a)
+	s.mba = gf.Neg(ba)
 	p3 := gf.Sub(p, three)
-	p34 = gf.Div(p3, four)
+	s.p34 = gf.Div(p3, four)
 	p1 := gf.Add(p, one)
-	p14 = gf.Div(p1, four)
+	s.p14 = gf.Div(p1, four)
+	return s
+}
+
+// Size returns the length of hash HashToPoint expects
+func (s *SWU) Size() int {
+	return s.size
 }
 
 //DataToPoint hashes data using SHA-256 and maps it to a point on curve
@@ -71,15 +93,22 @@ func DataToPoint(data []byte) (x, y *big.Int) {
 	return HashToPoint(hash[:])
 }
 
-//HashToPoint maps 32 byte hash to a point on curve
+//HashToPoint maps 32 byte hash to a point on P-256 curve
 func HashToPoint(hash []byte) (x, y *big.Int) {
+	return p256.HashToPoint(hash)
+}
+
+//HashToPoint maps hash of Size() bytes to a point on curve
+func (s *SWU) HashToPoint(hash []byte) (x, y *big.Int) {
 
-	if len(hash) != 32 {
+	if len(hash) != s.size {
 		panic("invalid hash length")
 	}
 
+	gf, a, b := s.gf, s.a, s.b
+
 	t := new(big.Int).SetBytes(hash)
-	t.Mod(t, p)
+	t.Mod(t, gf.P)
 
 	//alpha = -t^2
 	tt := gf.Square(t)
@@ -91,7 +120,7 @@ func HashToPoint(hash []byte) (x, y *big.Int) {
 	asqa1 := gf.Add(one, gf.Inv(asqa))
 
 	// x2 = -(b / a) * (1 + 1/(alpha^2+alpha))
-	x2 := gf.Mul(mba, asqa1)
+	x2 := gf.Mul(s.mba, asqa1)
 
 	//x3 = alpha * x2
 	x3 := gf.Mul(alpha, x2)
@@ -111,7 +140,7 @@ func HashToPoint(hash []byte) (x, y *big.Int) {
 	h3 := gf.Add(x33ax3, b)
 
 	// tmp = h2 ^ ((p - 3) // 4)
-	tmp := gf.Pow(h2, p34)
+	tmp := gf.Pow(h2, s.p34)
 
 	tmp2 := gf.Square(tmp)
 	tmp2h2 := gf.Mul(tmp2, h2)
@@ -123,5 +152,5 @@ func HashToPoint(hash []byte) (x, y *big.Int) {
 	}
 
 	//return (x3, h3 ^ ((p+1)//4))
-	return x3, gf.Pow(h3, p14)
+	return x3, gf.Pow(h3, s.p14)
 }
@@ -79,3 +79,45 @@ func increment(counter []byte) {
 		}
 	}
 }
+
+func TestSWU_P384(t *testing.T) {
+	c := elliptic.P384()
+	s := New(c)
+	assert.Equal(t, 48, s.Size())
+	for i := 0; i < 1000; i++ {
+		b := make([]byte, s.Size())
+		rand.Read(b)
+
+		x, y := s.HashToPoint(b)
+
+		assert.True(t, c.IsOnCurve(x, y))
+	}
+}
+
+func TestSWU_P521(t *testing.T) {
+	c := elliptic.P521()
+	s := New(c)
+	assert.Equal(t, 66, s.Size())
+	for i := 0; i < 100; i++ {
+		b := make([]byte, s.Size())
+		rand.Read(b)
+
+		x, y := s.HashToPoint(b)
+
+		assert.True(t, c.IsOnCurve(x, y))
+	}
+}
+
+func TestSvdW(t *testing.T) {
+	for _, c := range []elliptic.Curve{elliptic.P256(), elliptic.P384()} {
+		s := NewSvdW(c, big.NewInt(-3))
+		for i := 0; i < 1000; i++ {
+			b := make([]byte, s.Size())
+			rand.Read(b)
+
+			x, y := s.HashToPoint(b)
+
+			assert.True(t, c.IsOnCurve(x, y))
+		}
+	}
+}
@@ -0,0 +1,188 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUnknownTenant is returned by Tenants for tenant IDs it doesn't hold
+var ErrUnknownTenant = errors.New("unknown tenant")
+
+// Tenants lets a single service serve many applications, each with its own Keyring.
+// Tenants never share keypairs: a keypair already used by one tenant can't be added to another
+type Tenants struct {
+	mu      sync.RWMutex
+	tenants map[string]*Keyring
+	opts    []ServerOption
+}
+
+// NewTenants creates an empty set of tenants. Options apply to servers of every tenant
+func NewTenants(opts ...ServerOption) *Tenants {
+	return &Tenants{
+		tenants: make(map[string]*Keyring),
+		opts:    opts,
+	}
+}
+
+// AddTenant adds a tenant whose current keypair has the given version
+func (t *Tenants) AddTenant(id string, version uint32, serverKeypair []byte) error {
+	if id == "" {
+		return errors.New("invalid tenant id")
+	}
+
+	k, err := NewKeyring(version, serverKeypair, t.opts...)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.tenants[id]; ok {
+		return errors.Errorf("tenant %s already exists", id)
+	}
+	if t.used(k.Current().PublicKey()) {
+		return errors.New("keypair belongs to another tenant")
+	}
+	t.tenants[id] = k
+	return nil
+}
+
+// AddKeypair adds an earlier keypair of a tenant, see Keyring.Add
+func (t *Tenants) AddKeypair(id string, version uint32, serverKeypair []byte) error {
+	pub, err := GetPublicKey(serverKeypair)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	k, ok := t.tenants[id]
+	if !ok {
+		return ErrUnknownTenant
+	}
+	if t.used(pub) {
+		return errors.New("keypair is already in use")
+	}
+	return k.Add(version, serverKeypair)
+}
+
+// used reports whether any tenant holds a keypair with the public key
+func (t *Tenants) used(pub []byte) bool {
+	for _, k := range t.tenants {
+		for _, v := range k.Versions() {
+			if s, err := k.Server(v); err == nil && string(s.PublicKey()) == string(pub) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RemoveTenant removes a tenant and all its keypairs
+func (t *Tenants) RemoveTenant(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.tenants, id)
+}
+
+// Keyring returns the keyring of a tenant
+func (t *Tenants) Keyring(id string) (*Keyring, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	k, ok := t.tenants[id]
+	if !ok {
+		return nil, ErrUnknownTenant
+	}
+	return k, nil
+}
+
+// IDs returns IDs of all tenants in ascending order
+func (t *Tenants) IDs() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	ids := make([]string, 0, len(t.tenants))
+	for id := range t.tenants {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// PublicKey returns the current public key of a tenant and its version
+func (t *Tenants) PublicKey(id string) (pub []byte, version uint32, err error) {
+	k, err := t.Keyring(id)
+	if err != nil {
+		return nil, 0, err
+	}
+	s := k.Current()
+	return s.PublicKey(), s.KeyVersion(), nil
+}
+
+// Rotate rotates the keypair of a tenant, see Keyring.Rotate
+func (t *Tenants) Rotate(id string) (token *UpdateToken, newServerKeypair []byte, err error) {
+	k, err := t.Keyring(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return k.Rotate()
+}
+
+// GetEnrollment enrolls with the current keypair of a tenant
+func (t *Tenants) GetEnrollment(id string) (*EnrollmentResponse, error) {
+	k, err := t.Keyring(id)
+	if err != nil {
+		return nil, err
+	}
+	return k.GetEnrollment()
+}
+
+// VerifyPassword verifies with a keypair of a tenant, see Keyring.VerifyPassword
+func (t *Tenants) VerifyPassword(id string, req *VerifyPasswordRequest) (*VerifyPasswordResponse, error) {
+	k, err := t.Keyring(id)
+	if err != nil {
+		return nil, err
+	}
+	return k.VerifyPassword(req)
+}
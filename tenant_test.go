@@ -0,0 +1,55 @@
+package phe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenants(t *testing.T) {
+	tenants := NewTenants()
+	kpA, kpB := mustKeypair(t), mustKeypair(t)
+	assert.NoError(t, tenants.AddTenant("a", 1, kpA))
+	assert.NoError(t, tenants.AddTenant("b", 7, kpB))
+	assert.Error(t, tenants.AddTenant("a", 1, mustKeypair(t)))
+	assert.Error(t, tenants.AddTenant("c", 1, kpA))
+	assert.Error(t, tenants.AddKeypair("b", 1, kpA))
+	assert.Equal(t, []string{"a", "b"}, tenants.IDs())
+
+	pubA, version, err := tenants.PublicKey("a")
+	assert.NoError(t, err)
+	assert.Equal(t, mustPublicKey(t, kpA), pubA)
+	assert.Equal(t, uint32(1), version)
+
+	c, err := NewClient(GenerateClientKey(), pubA)
+	assert.NoError(t, err)
+	enrollment, err := tenants.GetEnrollment("a")
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+	req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+
+	resp, err := tenants.VerifyPassword("a", req)
+	assert.NoError(t, err)
+	assert.True(t, resp.Res)
+
+	//another tenant's key doesn't know the password
+	req.KeyVersion = 0
+	resp, err = tenants.VerifyPassword("b", req)
+	assert.NoError(t, err)
+	assert.False(t, resp.Res)
+
+	_, _, err = tenants.Rotate("b")
+	assert.NoError(t, err)
+	_, version, err = tenants.PublicKey("b")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(8), version)
+	_, version, err = tenants.PublicKey("a")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), version)
+
+	tenants.RemoveTenant("a")
+	_, err = tenants.GetEnrollment("a")
+	assert.Equal(t, ErrUnknownTenant, err)
+}
@@ -0,0 +1,478 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/passw0rd/phe-go/internal/shamir"
+	"github.com/pkg/errors"
+)
+
+// In threshold mode the server private key x is Shamir-shared between n share holders and any t of them
+// run the server together. Everything the server does with x is linear in it: x·P is the sum of λi·(xi·P)
+// and a proof response k + c·x is the sum of λi·(ki + c·xi), λi being Lagrange coefficients of the participating
+// holders. ThresholdKey does that combination behind PrivateKeyOps, so NewServerWithKey turns it into a server
+// whose responses and proofs are the same as a single server's and clients need no changes.
+//
+// The key never exists in one place, yet the combiner sees every evaluation such as hs0·x passing through it,
+// which is what a server sees. It belongs on the server side and must not be run by clients
+
+// ThresholdShare is the share of a server private key held by one of the n holders
+type ThresholdShare struct {
+	Index     int `json:"index"`
+	Threshold int `json:"threshold"`
+	// PublicKey is the server public key x·G, SharePublicKey is xi·G
+	PublicKey      []byte `json:"public_key"`
+	SharePublicKey []byte `json:"share_public_key"`
+	PrivateKey     []byte `json:"private_key"`
+}
+
+// SplitThreshold splits a server keypair into n shares any t of which can run the server.
+// The keypair has to be destroyed once the shares are handed out
+func SplitThreshold(serverKeypair []byte, n, t int) ([]*ThresholdShare, error) {
+	s, err := NewServer(serverKeypair)
+	if err != nil {
+		return nil, err
+	}
+	if t < 2 {
+		return nil, errors.New("threshold must be at least 2")
+	}
+
+	n256 := s.curve.ec.Params().N
+	split, err := shamir.Split(new(big.Int).SetBytes(s.priv), n, t, n256, random)
+	if err != nil {
+		return nil, err
+	}
+
+	shares := make([]*ThresholdShare, n)
+	for i, sh := range split {
+		priv := s.curve.scalarBytes(sh.Y)
+		shares[i] = &ThresholdShare{
+			Index:          sh.X,
+			Threshold:      t,
+			PublicKey:      s.PublicKey(),
+			SharePublicKey: s.curve.scalarBaseMultBytes(priv).Marshal(),
+			PrivateKey:     priv,
+		}
+	}
+	return shares, nil
+}
+
+// Key returns private key operations of the share to be served to the combiner.
+// Every commitment it makes answers at most one challenge
+func (sh *ThresholdShare) Key() (PrivateKeyOps, error) {
+	return newShareKey(sh.SharePublicKey, sh.PrivateKey)
+}
+
+// shareKey serves a key share held in memory to a combiner
+type shareKey struct {
+	key *softwareKey
+	pub *Point
+}
+
+func newShareKey(publicKey, privateKey []byte) (*shareKey, error) {
+	pub, err := PointUnmarshal(publicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid share public key")
+	}
+	c := pub.curve
+
+	x, err := c.parseScalar(privateKey)
+	if err != nil || x.Sign() == 0 || !c.scalarBaseMult(x).Equal(pub) {
+		return nil, errors.New("invalid share private key")
+	}
+
+	return &shareKey{key: &softwareKey{curve: c, x: c.scalarBytes(x)}, pub: pub}, nil
+}
+
+func (k *shareKey) PublicKey() []byte {
+	return k.pub.Marshal()
+}
+
+func (k *shareKey) ScalarMult(point []byte) ([]byte, error) {
+	p, err := k.key.curve.pointUnmarshal(point)
+	if err != nil {
+		return nil, err
+	}
+	res, err := k.key.scalarMult(p)
+	if err != nil {
+		return nil, err
+	}
+	return res.Marshal(), nil
+}
+
+func (k *shareKey) Commit(bases [][]byte) ([][]byte, func(challenge []byte) ([]byte, error), error) {
+	points := make([]*Point, len(bases))
+	for i, b := range bases {
+		p, err := k.key.curve.pointUnmarshal(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		if p.Equal(k.key.curve.g) {
+			p = k.key.curve.g
+		}
+		points[i] = p
+	}
+
+	terms, respond, err := k.key.commit(points...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	marshaled := make([][]byte, len(terms))
+	for i, t := range terms {
+		marshaled[i] = t.Marshal()
+	}
+
+	var once sync.Once
+	return marshaled, func(challenge []byte) (resp []byte, err error) {
+		err = errors.New("commitment already used")
+		once.Do(func() {
+			if _, err = k.key.curve.parseScalar(challenge); err == nil {
+				resp, err = respond(challenge)
+			}
+		})
+		return
+	}, nil
+}
+
+// thresholdProofDomain separates challenges of share holders' proofs of their ScalarMult results
+var thresholdProofDomain = []byte("ThresholdShareProof")
+
+// ThresholdKey runs server private key operations with t of n share holders. It implements PrivateKeyOps
+type ThresholdKey struct {
+	curve     *Curve
+	pub       []byte
+	threshold int
+	indices   []int
+	holders   map[int]PrivateKeyOps
+	shares    map[int]*Point
+}
+
+// NewThresholdKey creates a combiner for share holders keyed by their share index. Every operation is sent to all
+// of them and completed with the t that answer first by index, so up to n - t holders may be unavailable.
+// Share public keys are checked to be shares of publicKey
+func NewThresholdKey(publicKey []byte, threshold int, holders map[int]PrivateKeyOps) (*ThresholdKey, error) {
+	pub, err := PointUnmarshal(publicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid public key")
+	}
+	c := pub.curve
+
+	if threshold < 2 || len(holders) < threshold {
+		return nil, errors.New("not enough share holders for the threshold")
+	}
+
+	k := &ThresholdKey{
+		curve:     c,
+		pub:       pub.Marshal(),
+		threshold: threshold,
+		holders:   make(map[int]PrivateKeyOps, len(holders)),
+		shares:    make(map[int]*Point, len(holders)),
+	}
+	for idx, h := range holders {
+		if h == nil {
+			return nil, errors.Errorf("share holder %d is nil", idx)
+		}
+		if k.shares[idx], err = c.pointUnmarshal(h.PublicKey()); err != nil {
+			return nil, errors.Wrapf(err, "invalid public key of share holder %d", idx)
+		}
+		k.holders[idx] = h
+		k.indices = append(k.indices, idx)
+	}
+	sort.Ints(k.indices)
+
+	// the first t shares must interpolate the public key at 0 and every other share at its index
+	base := make([]int, threshold)
+	for pos := range base {
+		base[pos] = pos
+	}
+	for _, at := range append([]int{0}, k.indices[threshold:]...) {
+		want := pub
+		if at != 0 {
+			want = k.shares[at]
+		}
+		got, err := k.interpolate(base, at, func(pos int) *Point { return k.shares[k.indices[pos]] })
+		if err != nil {
+			return nil, err
+		}
+		if !got.Equal(want) {
+			return nil, errors.New("share public keys are not shares of the public key")
+		}
+	}
+
+	return k, nil
+}
+
+// PublicKey returns marshaled server public key
+func (k *ThresholdKey) PublicKey() []byte {
+	return append([]byte{}, k.pub...)
+}
+
+// ScalarMult returns x·P combined from the first t holders to answer with a valid proof of their result
+func (k *ThresholdKey) ScalarMult(point []byte) ([]byte, error) {
+	pt, err := k.curve.pointUnmarshal(point)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*Point, len(k.indices))
+	participants, err := k.run(func(pos int, h PrivateKeyOps) error {
+		res, err := h.ScalarMult(point)
+		if err != nil {
+			return err
+		}
+		p, err := k.curve.pointUnmarshal(res)
+		if err != nil {
+			return err
+		}
+		if err = k.proveShare(pos, h, pt, p); err != nil {
+			return err
+		}
+		results[pos] = p
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := k.interpolate(participants, 0, func(pos int) *Point { return results[pos] })
+	if err != nil {
+		return nil, err
+	}
+	if isIdentity(res) {
+		return nil, errors.New("results of share holders cancel out")
+	}
+	return res.Marshal(), nil
+}
+
+// proveShare has the holder at pos prove that r is xi·p for the xi of its share public key Xi, a DLEQ proof
+// run through Commit: for terms t0 = ki·p, t1 = ki·G and challenge c the response s must satisfy
+// s·p = t0 + c·r and s·G = t1 + c·Xi
+func (k *ThresholdKey) proveShare(pos int, h PrivateKeyOps, p, r *Point) error {
+	terms, respond, err := h.Commit([][]byte{p.Marshal(), k.curve.gBytes})
+	if err != nil {
+		return err
+	}
+	if len(terms) != 2 {
+		return errors.New("wrong number of terms")
+	}
+	t0, err := k.curve.pointUnmarshal(terms[0])
+	if err != nil {
+		return err
+	}
+	t1, err := k.curve.pointUnmarshal(terms[1])
+	if err != nil {
+		return err
+	}
+
+	xi := k.shares[k.indices[pos]]
+	c := k.curve.hashZ(thresholdProofDomain, xi.Marshal(), p.Marshal(), r.Marshal(), terms[0], terms[1])
+	resp, err := respond(k.curve.scalarBytes(c))
+	if err != nil {
+		return err
+	}
+	sc, err := k.curve.parseScalar(resp)
+	if err != nil {
+		return err
+	}
+
+	if !p.ScalarMultInt(sc).Equal(t0.Add(r.ScalarMultInt(c))) || !k.curve.scalarBaseMult(sc).Equal(t1.Add(xi.ScalarMultInt(c))) {
+		return errors.New("invalid proof of the result")
+	}
+	return nil
+}
+
+// Commit collects commitments of the first t holders to answer, the same holders have to respond to the challenge
+func (k *ThresholdKey) Commit(bases [][]byte) ([][]byte, func(challenge []byte) ([]byte, error), error) {
+	// responses to a challenge on the generator are checked against share public keys
+	gIndex := -1
+	for i, b := range bases {
+		p, err := k.curve.pointUnmarshal(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		if p.Equal(k.curve.g) {
+			gIndex = i
+		}
+	}
+
+	type commitment struct {
+		terms   []*Point
+		respond func(challenge []byte) ([]byte, error)
+	}
+	commitments := make([]*commitment, len(k.indices))
+	participants, err := k.run(func(pos int, h PrivateKeyOps) error {
+		terms, respond, err := h.Commit(bases)
+		if err != nil {
+			return err
+		}
+		if len(terms) != len(bases) {
+			return errors.New("wrong number of terms")
+		}
+		cm := &commitment{terms: make([]*Point, len(terms)), respond: respond}
+		for i, t := range terms {
+			if cm.terms[i], err = k.curve.pointUnmarshal(t); err != nil {
+				return err
+			}
+		}
+		commitments[pos] = cm
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	terms := make([][]byte, len(bases))
+	for i := range bases {
+		t, err := k.interpolate(participants, 0, func(pos int) *Point { return commitments[pos].terms[i] })
+		if err != nil {
+			return nil, nil, err
+		}
+		if isIdentity(t) {
+			return nil, nil, errors.New("commitments of share holders cancel out")
+		}
+		terms[i] = t.Marshal()
+	}
+
+	coeffs, err := k.coefficients(participants, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return terms, func(challenge []byte) ([]byte, error) {
+		c, err := k.curve.parseScalar(challenge)
+		if err != nil {
+			return nil, err
+		}
+
+		responses := make([]*big.Int, len(participants))
+		errs := make([]error, len(participants))
+		var wg sync.WaitGroup
+		for i, pos := range participants {
+			wg.Add(1)
+			go func(i, pos int) {
+				defer wg.Done()
+				resp, err := commitments[pos].respond(challenge)
+				if err == nil {
+					responses[i], err = k.curve.parseScalar(resp)
+				}
+				if err == nil && gIndex >= 0 {
+					// si·G = ki·G + c·Xi
+					want := commitments[pos].terms[gIndex].Add(k.shares[k.indices[pos]].ScalarMultInt(c))
+					if !k.curve.scalarBaseMult(responses[i]).Equal(want) {
+						err = errors.New("invalid response")
+					}
+				}
+				if err != nil {
+					errs[i] = errors.Wrapf(err, "share holder %d", k.indices[pos])
+				}
+			}(i, pos)
+		}
+		wg.Wait()
+
+		n := k.curve.ec.Params().N
+		sum := new(big.Int)
+		for i := range participants {
+			if errs[i] != nil {
+				return nil, errs[i]
+			}
+			sum.Add(sum, new(big.Int).Mul(coeffs[i], responses[i]))
+		}
+		return k.curve.scalarBytes(sum.Mod(sum, n)), nil
+	}, nil
+}
+
+// run calls every holder in parallel and returns positions in k.indices of the first threshold ones that succeeded
+func (k *ThresholdKey) run(op func(pos int, h PrivateKeyOps) error) ([]int, error) {
+	errs := make([]error, len(k.indices))
+	var wg sync.WaitGroup
+	for pos, idx := range k.indices {
+		wg.Add(1)
+		go func(pos int, h PrivateKeyOps) {
+			defer wg.Done()
+			errs[pos] = op(pos, h)
+		}(pos, k.holders[idx])
+	}
+	wg.Wait()
+
+	var participants []int
+	var firstErr error
+	for pos, err := range errs {
+		if err == nil {
+			participants = append(participants, pos)
+		} else if firstErr == nil {
+			firstErr = errors.Wrapf(err, "share holder %d", k.indices[pos])
+		}
+	}
+
+	if len(participants) < k.threshold {
+		return nil, errors.Wrapf(firstErr, "only %d of %d required share holders answered", len(participants), k.threshold)
+	}
+	return participants[:k.threshold], nil
+}
+
+// coefficients returns Lagrange coefficients of holders at the given positions in k.indices
+func (k *ThresholdKey) coefficients(positions []int, at int) ([]*big.Int, error) {
+	xs := make([]int, len(positions))
+	for i, pos := range positions {
+		xs[i] = k.indices[pos]
+	}
+	return shamir.Coefficients(xs, at, k.curve.ec.Params().N)
+}
+
+// interpolate computes the sum of λi·Pi for holders at the given positions where λi interpolate at the given index
+func (k *ThresholdKey) interpolate(positions []int, at int, point func(pos int) *Point) (*Point, error) {
+	coeffs, err := k.coefficients(positions, at)
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([]*Point, len(positions))
+	for i, pos := range positions {
+		points[i] = point(pos)
+	}
+	return k.curve.multiScalarMult(points, coeffs), nil
+}
+
+// isIdentity reports whether p is the point at infinity, which can't be marshaled
+func isIdentity(p *Point) bool {
+	return p.X.Sign() == 0 && p.Y.Sign() == 0
+}
@@ -0,0 +1,216 @@
+package phe
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// downHolder is an unavailable share holder
+type downHolder struct {
+	PrivateKeyOps
+}
+
+func (downHolder) ScalarMult([]byte) ([]byte, error) {
+	return nil, errors.New("unavailable")
+}
+
+func (downHolder) Commit([][]byte) ([][]byte, func([]byte) ([]byte, error), error) {
+	return nil, nil, errors.New("unavailable")
+}
+
+// cheatingHolder answers challenges with garbage
+type cheatingHolder struct {
+	PrivateKeyOps
+}
+
+func (h cheatingHolder) Commit(bases [][]byte) ([][]byte, func([]byte) ([]byte, error), error) {
+	terms, _, err := h.PrivateKeyOps.Commit(bases)
+	return terms, func(challenge []byte) ([]byte, error) {
+		return p256.randomScalar(), nil
+	}, err
+}
+
+// cancellingHolder makes the sum of its and holder 1's results or commitments the identity, holder 1 reports
+// its commitment terms on terms. With holders 1 and 2 the Lagrange coefficients at 0 are 2 and -1
+type cancellingHolder struct {
+	PrivateKeyOps
+	whole privateKey
+	terms chan []byte
+}
+
+func (h cancellingHolder) ScalarMult(point []byte) ([]byte, error) {
+	res, err := h.PrivateKeyOps.ScalarMult(point)
+	if err != nil {
+		return nil, err
+	}
+	p, _ := p256.pointUnmarshal(point)
+	r, _ := p256.pointUnmarshal(res)
+	xp, err := h.whole.scalarMult(p)
+	if err != nil {
+		return nil, err
+	}
+	return r.Add(xp).Marshal(), nil
+}
+
+func (h cancellingHolder) Commit(bases [][]byte) ([][]byte, func([]byte) ([]byte, error), error) {
+	if h.terms == nil {
+		return h.PrivateKeyOps.Commit(bases)
+	}
+	_, respond, err := h.PrivateKeyOps.Commit(bases)
+	t1, _ := p256.pointUnmarshal(<-h.terms)
+	return [][]byte{t1.Add(t1).Marshal()}, respond, err
+}
+
+// reportingHolder sends its first commitment term to terms
+type reportingHolder struct {
+	PrivateKeyOps
+	terms chan []byte
+}
+
+func (h reportingHolder) Commit(bases [][]byte) ([][]byte, func([]byte) ([]byte, error), error) {
+	terms, respond, err := h.PrivateKeyOps.Commit(bases)
+	h.terms <- terms[0]
+	return terms, respond, err
+}
+
+func mustThresholdHolders(t *testing.T, serverKeypair []byte, n, threshold int) map[int]PrivateKeyOps {
+	shares, err := SplitThreshold(serverKeypair, n, threshold)
+	assert.NoError(t, err)
+
+	holders := make(map[int]PrivateKeyOps)
+	for _, sh := range shares {
+		holders[sh.Index], err = sh.Key()
+		assert.NoError(t, err)
+	}
+	return holders
+}
+
+func TestThreshold(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	pub := mustPublicKey(t, serverKeypair)
+	holders := mustThresholdHolders(t, serverKeypair, 5, 3)
+
+	//two holders may be down
+	holders[1] = downHolder{holders[1]}
+	holders[4] = downHolder{holders[4]}
+
+	key, err := NewThresholdKey(pub, 3, holders)
+	assert.NoError(t, err)
+	server, err := NewServerWithKey(key)
+	assert.NoError(t, err)
+	assert.NoError(t, server.Warmup())
+
+	//records enrolled with the whole key are verified by the threshold server and the other way round
+	c, err := NewClient(GenerateClientKey(), pub)
+	assert.NoError(t, err)
+
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	rec, key1, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	resp, err := server.VerifyPassword(req)
+	assert.NoError(t, err)
+	key2, err := c.CheckResponseAndDecrypt(pwd, rec, resp)
+	assert.NoError(t, err)
+	assert.Equal(t, key1, key2)
+
+	//not enough holders
+	holders[2] = downHolder{holders[2]}
+	key, err = NewThresholdKey(pub, 3, holders)
+	assert.NoError(t, err)
+	_, err = NewServerWithKey(key)
+	assert.Error(t, err)
+}
+
+func TestThreshold_Cheater(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	holders := mustThresholdHolders(t, serverKeypair, 3, 2)
+	holders[2] = cheatingHolder{holders[2]}
+
+	key, err := NewThresholdKey(mustPublicKey(t, serverKeypair), 2, holders)
+	assert.NoError(t, err)
+	server, err := NewServerWithKey(key)
+	assert.NoError(t, err)
+
+	_, err = server.GetEnrollment()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "share holder 2")
+	}
+}
+
+func TestThreshold_Invalid(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+
+	_, err := SplitThreshold(serverKeypair, 3, 1)
+	assert.Error(t, err)
+	_, err = SplitThreshold(serverKeypair, 2, 3)
+	assert.Error(t, err)
+
+	holders := mustThresholdHolders(t, serverKeypair, 3, 2)
+	_, err = NewThresholdKey(mustPublicKey(t, serverKeypair), 3, map[int]PrivateKeyOps{1: holders[1], 2: holders[2]})
+	assert.Error(t, err)
+
+	//shares of another key
+	_, err = NewThresholdKey(mustPublicKey(t, mustKeypair(t)), 2, holders)
+	assert.Error(t, err)
+
+	//a share from another split
+	other := mustThresholdHolders(t, serverKeypair, 3, 2)
+	holders[3] = other[3]
+	_, err = NewThresholdKey(mustPublicKey(t, serverKeypair), 2, holders)
+	assert.Error(t, err)
+
+	_, respond, err := other[1].Commit([][]byte{p256.gBytes})
+	assert.NoError(t, err)
+	_, err = respond(p256.randomScalar())
+	assert.NoError(t, err)
+	_, err = respond(p256.randomScalar())
+	assert.Error(t, err)
+}
+
+func TestThreshold_Cancelling(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	pub := mustPublicKey(t, serverKeypair)
+	s, err := NewServer(serverKeypair)
+	assert.NoError(t, err)
+	all := mustThresholdHolders(t, serverKeypair, 3, 2)
+	p := p256.scalarBaseMult(p256.randomZ())
+
+	//a result cancelling the sum fails its proof and the holder is named
+	holders := map[int]PrivateKeyOps{1: all[1], 2: cancellingHolder{PrivateKeyOps: all[2], whole: s.key}}
+	key, err := NewThresholdKey(pub, 2, holders)
+	assert.NoError(t, err)
+	assert.NotPanics(t, func() {
+		_, err = key.ScalarMult(p.Marshal())
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "share holder 2")
+	}
+
+	//with the third holder the bad one is left out
+	holders[3] = all[3]
+	key, err = NewThresholdKey(pub, 2, holders)
+	assert.NoError(t, err)
+	res, err := key.ScalarMult(p.Marshal())
+	assert.NoError(t, err)
+	want, err := s.key.scalarMult(p)
+	assert.NoError(t, err)
+	assert.Equal(t, want.Marshal(), res)
+
+	//cancelling commitments
+	terms := make(chan []byte, 1)
+	key, err = NewThresholdKey(pub, 2, map[int]PrivateKeyOps{
+		1: reportingHolder{PrivateKeyOps: all[1], terms: terms},
+		2: cancellingHolder{PrivateKeyOps: all[2], terms: terms},
+	})
+	assert.NoError(t, err)
+	assert.NotPanics(t, func() {
+		_, _, err = key.Commit([][]byte{p.Marshal()})
+	})
+	assert.Error(t, err)
+}
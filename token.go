@@ -0,0 +1,106 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Validate checks the token's scalars are in range for the curve of server keys it rotates.
+// Decoding a token only checks their lengths since the encodings don't name the curve
+func (t *UpdateToken) Validate(curve *Curve) error {
+	_, _, err := t.parse(curve)
+	return err
+}
+
+// ParseUpdateToken decodes a token in its binary or JSON encoding, e.g. as received from a queue or a config system,
+// and validates it for the curve of server keys it rotates
+func ParseUpdateToken(data []byte, curve *Curve) (*UpdateToken, error) {
+	token := &UpdateToken{}
+	var err error
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		err = json.Unmarshal(trimmed, token)
+	} else {
+		err = token.UnmarshalBinary(data)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid update token")
+	}
+
+	if err = token.Validate(curve); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// hasMetadata reports whether any of the optional fields is set
+func (t *UpdateToken) hasMetadata() bool {
+	return t.FromVersion != 0 || t.ToVersion != 0 || t.FromKey != "" || t.ToKey != "" || t.IssuedAt != 0 || t.ExpiresAt != 0
+}
+
+// CheckUse refuses a token which has expired or was issued for other keys than the server public key pub
+// of the given version. Unset metadata, nil pub and version 0 aren't checked
+func (t *UpdateToken) CheckUse(pub []byte, version uint32) error {
+	if t == nil {
+		return nil
+	}
+	if t.ExpiresAt != 0 && time.Now().Unix() > t.ExpiresAt {
+		return ErrTokenExpired
+	}
+	if t.FromVersion != 0 && version != 0 && version != t.FromVersion {
+		return &KeyVersionError{Expected: t.FromVersion, Got: version}
+	}
+	if t.FromKey != "" && pub != nil && t.FromKey != Fingerprint(pub) {
+		return ErrTokenKeyMismatch
+	}
+	return nil
+}
+
+// NextVersion returns the key version after applying the token to keys of version v
+func (t *UpdateToken) NextVersion(v uint32) uint32 {
+	if v == 0 {
+		return 0
+	}
+	if t.ToVersion != 0 {
+		return t.ToVersion
+	}
+	return v + 1
+}
@@ -0,0 +1,130 @@
+package phe
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUpdateToken(t *testing.T) {
+	token, _, err := Rotate(mustKeypair(t))
+	assert.NoError(t, err)
+	assert.NoError(t, token.Validate(P256()))
+
+	bin, err := token.MarshalBinary()
+	assert.NoError(t, err)
+	js, err := json.Marshal(token)
+	assert.NoError(t, err)
+
+	for _, data := range [][]byte{bin, js, append([]byte("\n  "), js...)} {
+		parsed, err := ParseUpdateToken(data, P256())
+		assert.NoError(t, err)
+		assert.True(t, token.Equal(parsed))
+	}
+
+	n := P256().ec.Params().N.Bytes()
+	for name, bad := range map[string]*UpdateToken{
+		"a out of range": {A: n, B: token.B},
+		"b out of range": {A: token.A, B: n},
+		"zero a":         {A: []byte{0}, B: token.B},
+	} {
+		assert.Error(t, bad.Validate(P256()), name)
+		data, err := bad.MarshalBinary()
+		assert.NoError(t, err)
+		_, err = ParseUpdateToken(data, P256())
+		assert.Error(t, err, name)
+	}
+
+	// a P-521 token doesn't fit P-256
+	p521Keypair, err := GenerateServerKeypairForCurve(P521())
+	assert.NoError(t, err)
+	p521Token, _, err := Rotate(p521Keypair)
+	assert.NoError(t, err)
+	data, err := p521Token.MarshalBinary()
+	assert.NoError(t, err)
+	_, err = ParseUpdateToken(data, P256())
+	assert.Error(t, err)
+	_, err = ParseUpdateToken(data, P521())
+	assert.NoError(t, err)
+
+	_, err = ParseUpdateToken([]byte(`{"a":"AQ=="}`), P256())
+	assert.Error(t, err)
+	_, err = ParseUpdateToken(nil, P256())
+	assert.Error(t, err)
+}
+
+func TestUpdateToken_Metadata(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	pub := mustPublicKey(t, serverKeypair)
+	k, err := NewKeyring(1, serverKeypair)
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), pub, WithClientKeyVersion(1))
+	assert.NoError(t, err)
+	enrollment, err := k.GetEnrollment()
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	token, newKeypair, err := k.Rotate()
+	assert.NoError(t, err)
+	newPub := mustPublicKey(t, newKeypair)
+	assert.Equal(t, uint32(1), token.FromVersion)
+	assert.Equal(t, uint32(2), token.ToVersion)
+	assert.Equal(t, Fingerprint(pub), token.FromKey)
+	assert.Equal(t, Fingerprint(newPub), token.ToKey)
+	assert.NotZero(t, token.IssuedAt)
+
+	bin, err := token.MarshalBinary()
+	assert.NoError(t, err)
+	parsed, err := ParseUpdateToken(bin, P256())
+	assert.NoError(t, err)
+	assert.Equal(t, token, parsed)
+	js, err := json.Marshal(token)
+	assert.NoError(t, err)
+	parsed, err = ParseUpdateToken(js, P256())
+	assert.NoError(t, err)
+	assert.Equal(t, token, parsed)
+
+	expired := token.Clone()
+	expired.IssuedAt -= 3600
+	expired.ExpiresAt = expired.IssuedAt + 60
+	_, err = UpdateRecord(rec, expired)
+	assert.ErrorIs(t, err, ErrTokenExpired)
+	_, err = c.RotateNew(expired, newPub)
+	assert.ErrorIs(t, err, ErrTokenExpired)
+	_, _, err = RotateClientKeys(GenerateClientKey(), pub, expired)
+	assert.ErrorIs(t, err, ErrTokenExpired)
+
+	otherKey := token.Clone()
+	otherKey.FromKey = Fingerprint(newPub)
+	_, err = c.RotateNew(otherKey, newPub)
+	assert.ErrorIs(t, err, ErrTokenKeyMismatch)
+
+	updRec, err := UpdateRecord(rec, token)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), updRec.KeyVersion)
+	// a token is never applied twice
+	_, err = UpdateRecord(updRec, token)
+	assert.ErrorIs(t, err, ErrKeyVersionMismatch)
+
+	rotated, err := c.RotateNew(token, newPub)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), rotated.KeyVersion())
+	_, err = rotated.RotateNew(token, newPub)
+	assert.Error(t, err)
+
+	// tokens skipping versions move records and clients to the version they name
+	jump := token.Clone()
+	jump.ToVersion = 5
+	updRec, err = UpdateRecord(rec, jump)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(5), updRec.KeyVersion)
+
+	bad := token.Clone()
+	bad.ExpiresAt = bad.IssuedAt - 1
+	_, err = bad.MarshalBinary()
+	assert.Error(t, err)
+	var v UpdateToken
+	assert.Error(t, v.UnmarshalBinary(bin[:len(bin)-1]))
+}
@@ -0,0 +1,114 @@
+package phe
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type spanKey struct{}
+
+// recordingTracer keeps ended spans, each span knows its parent from the context
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	t      *recordingTracer
+	name   string
+	parent string
+	attrs  map[string]interface{}
+	err    error
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	s := &recordedSpan{t: t, name: name, attrs: map[string]interface{}{}}
+	if parent, ok := ctx.Value(spanKey{}).(*recordedSpan); ok {
+		s.parent = parent.name
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (s *recordedSpan) SetAttribute(key string, value interface{}) {
+	s.attrs[key] = value
+}
+
+func (s *recordedSpan) End(err error) {
+	s.err = err
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.t.spans = append(s.t.spans, s)
+}
+
+func TestTracer(t *testing.T) {
+	tracer := &recordingTracer{}
+	serverKeypair := mustKeypair(t)
+	s, err := NewServer(serverKeypair, WithServerTracer(tracer))
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), s.PublicKey(), WithClientTracer(tracer))
+	assert.NoError(t, err)
+
+	enrollment, err := s.GetEnrollmentContext(context.Background())
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccountContext(context.Background(), pwd, enrollment)
+	assert.NoError(t, err)
+	req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	resp, err := s.VerifyPassword(req)
+	assert.NoError(t, err)
+	_, err = c.CheckResponseAndDecrypt(pwd, rec, resp)
+	assert.NoError(t, err)
+
+	var spans []string
+	for _, span := range tracer.spans {
+		spans = append(spans, span.parent+" > "+span.name)
+	}
+	assert.Equal(t, []string{
+		"phe.Server.GetEnrollment > phe.Server.Prove", " > phe.Server.GetEnrollment",
+		"phe.Client.EnrollAccount > phe.Client.VerifyProof", " > phe.Client.EnrollAccount",
+		"phe.Server.VerifyPassword > phe.Server.Prove", " > phe.Server.VerifyPassword",
+		"phe.Client.CheckResponseAndDecrypt > phe.Client.VerifyProof", " > phe.Client.CheckResponseAndDecrypt",
+	}, spans)
+	assert.Equal(t, true, tracer.spans[5].attrs["phe.result"])
+	assert.Equal(t, true, tracer.spans[7].attrs["phe.result"])
+}
+
+func TestTracer_Migration(t *testing.T) {
+	tracer := &recordingTracer{}
+	serverKeypair := mustKeypair(t)
+	pub := mustPublicKey(t, serverKeypair)
+	c, err := NewClient(GenerateClientKey(), pub)
+	assert.NoError(t, err)
+
+	store := &memRecords{records: map[string]*EnrollmentRecord{}}
+	for _, k := range []string{"a", "b", "c"} {
+		enrollment, err := GetEnrollment(serverKeypair)
+		assert.NoError(t, err)
+		store.records[k], _, err = c.EnrollAccount(pwd, enrollment)
+		assert.NoError(t, err)
+	}
+	token, _, err := Rotate(serverKeypair)
+	assert.NoError(t, err)
+
+	m := &Migration{
+		Source:      store,
+		Sink:        store,
+		Checkpoints: NewFileCheckpoint(filepath.Join(t.TempDir(), "checkpoint.json")),
+		Token:       token,
+		BatchSize:   2,
+		Tracer:      tracer,
+	}
+	_, err = m.Run(context.Background())
+	assert.NoError(t, err)
+
+	assert.Len(t, tracer.spans, 3)
+	assert.Equal(t, "phe.Migration.Run", tracer.spans[0].parent)
+	assert.Equal(t, 2, tracer.spans[0].attrs["phe.records"])
+	assert.Equal(t, 1, tracer.spans[1].attrs["phe.records"])
+	assert.Equal(t, "phe.Migration.Run", tracer.spans[2].name)
+	assert.Equal(t, int64(3), tracer.spans[2].attrs["phe.processed"])
+}
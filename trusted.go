@@ -0,0 +1,66 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+// Proofs make up most of the work on both sides: a server makes 3 or 4 extra scalar multiplications per response
+// and a client checks them with 3 or 4 multi-scalar multiplications. A deployment where the client and the server
+// are run by the same operator in the same network may trust the server to use its key honestly and skip them.
+// Without proofs a compromised server can make a client accept a wrong password or lock out any account,
+// so both sides have to opt in explicitly
+
+// WithoutProofs makes the server send responses without proofs, for use with clients made with WithTrustedServer only.
+// Clients which check proofs reject such responses
+func WithoutProofs() ServerOption {
+	return func(s *Server) {
+		s.noProofs = true
+	}
+}
+
+// WithTrustedServer makes the client accept server responses without checking proofs, whether they carry one or not
+func WithTrustedServer() ClientOption {
+	return func(c *Client) {
+		c.trustServer = true
+	}
+}
+
+// checkTrusted only makes sure the server sent points which parse
+func (c *Client) checkTrusted(c0, c1 *Point) error {
+	if c0 == nil || c1 == nil {
+		return &ProofError{Reason: ProofMalformed}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package phe
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrustedServer(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	server, err := NewServer(serverKeypair, WithoutProofs())
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), server.PublicKey(), WithTrustedServer())
+	assert.NoError(t, err)
+
+	enrollment, err := server.GetEnrollment()
+	assert.NoError(t, err)
+	assert.Nil(t, enrollment.Proof)
+
+	rec, key, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	resp, err := server.VerifyPassword(req)
+	assert.NoError(t, err)
+	assert.Nil(t, resp.ProofSuccess)
+
+	//proofless responses survive both encodings
+	data, err := resp.MarshalBinary()
+	assert.NoError(t, err)
+	resp = &VerifyPasswordResponse{}
+	assert.NoError(t, resp.UnmarshalBinary(data))
+	data, err = json.Marshal(resp)
+	assert.NoError(t, err)
+	resp = &VerifyPasswordResponse{}
+	assert.NoError(t, json.Unmarshal(data, resp))
+	assert.True(t, resp.NoProof)
+
+	decrypted, err := c.CheckResponseAndDecrypt(pwd, rec, resp)
+	assert.NoError(t, err)
+	assert.Equal(t, key, decrypted)
+
+	req, err = c.CreateVerifyPasswordRequest([]byte("wrong"), rec)
+	assert.NoError(t, err)
+	resp, err = server.VerifyPassword(req)
+	assert.NoError(t, err)
+	assert.False(t, resp.Res)
+	assert.Nil(t, resp.ProofFail)
+	decrypted, err = c.CheckResponseAndDecrypt([]byte("wrong"), rec, resp)
+	assert.NoError(t, err)
+	assert.Nil(t, decrypted)
+
+	//trusted clients accept proofs too
+	enrollment, err = GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	enrollment.NoProof = true
+	_, err = enrollment.MarshalBinary()
+	assert.Error(t, err)
+	enrollment.NoProof = false
+	_, _, err = c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+}
+
+func TestTrustedServer_OptIn(t *testing.T) {
+	server, err := NewServer(mustKeypair(t), WithoutProofs())
+	assert.NoError(t, err)
+	c, err := NewClient(GenerateClientKey(), server.PublicKey())
+	assert.NoError(t, err)
+
+	enrollment, err := server.GetEnrollment()
+	assert.NoError(t, err)
+	_, _, err = c.EnrollAccount(pwd, enrollment)
+	assert.ErrorIs(t, err, ErrInvalidProof)
+
+	trusted, err := NewClient(GenerateClientKey(), server.PublicKey(), WithTrustedServer())
+	assert.NoError(t, err)
+	rec, _, err := trusted.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	req, err := trusted.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	resp, err := server.VerifyPassword(req)
+	assert.NoError(t, err)
+	_, err = c.CheckResponseAndDecrypt(pwd, rec, resp)
+	assert.ErrorIs(t, err, ErrInvalidProof)
+}
+
+func TestTrustedServer_Warmup(t *testing.T) {
+	server, err := NewServer(mustKeypair(t), WithoutProofs())
+	assert.NoError(t, err)
+	assert.NoError(t, server.Warmup())
+	assert.True(t, server.Capabilities().FastMode)
+
+	c, err := NewClient(GenerateClientKey(), server.PublicKey(), WithTrustedServer())
+	assert.NoError(t, err)
+	assert.True(t, c.Capabilities().FastMode)
+}
+
+func BenchmarkLoginFlow_WithoutProofs(b *testing.B) {
+	serverKeypair, err := GenerateServerKeypair()
+	assert.NoError(b, err)
+	server, err := NewServer(serverKeypair, WithoutProofs())
+	assert.NoError(b, err)
+	c, err := NewClient(GenerateClientKey(), server.PublicKey(), WithTrustedServer())
+	assert.NoError(b, err)
+
+	enrollment, err := server.GetEnrollment()
+	assert.NoError(b, err)
+	rec, key, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(b, err)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+		assert.NoError(b, err)
+		res, err := server.VerifyPassword(req)
+		assert.NoError(b, err)
+		keyDec, err := c.CheckResponseAndDecrypt(pwd, rec, res)
+		assert.NoError(b, err)
+		assert.Equal(b, key, keyDec)
+	}
+}
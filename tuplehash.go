@@ -39,6 +39,7 @@ package phe
 import (
 	"crypto/sha512"
 	"encoding/binary"
+	"hash"
 	"io"
 
 	"golang.org/x/crypto/hkdf"
@@ -46,8 +47,12 @@ import (
 
 //TupleHash hashes a slice of byte arrays, prefixing each one with its length
 func TupleHash(tuple [][]byte, domain []byte) []byte {
+	return tupleHash(sha512.New512_256, tuple, domain)
+}
+
+func tupleHash(h func() hash.Hash, tuple [][]byte, domain []byte) []byte {
 	var sizeBuf [8]byte
-	hash := sha512.New512_256()
+	hash := h()
 
 	for _, t := range tuple {
 		writeArray(hash, &sizeBuf, t)
@@ -68,7 +73,11 @@ func writeArray(w io.Writer, sizeBuf *[8]byte, a []byte) {
 
 // TupleKDF creates HKDF instance initialized with TupleHash
 func TupleKDF(tuple [][]byte, domain []byte) io.Reader {
-	key := TupleHash(tuple, domain)
-	return hkdf.New(sha512.New512_256, key, domain, []byte("TupleKDF"))
+	return tupleKDF(sha512.New512_256, tuple, domain)
+}
+
+func tupleKDF(h func() hash.Hash, tuple [][]byte, domain []byte) io.Reader {
+	key := tupleHash(h, tuple, domain)
+	return hkdf.New(h, key, domain, []byte("TupleKDF"))
 
 }
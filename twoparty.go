@@ -0,0 +1,274 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"crypto/subtle"
+
+	"github.com/pkg/errors"
+)
+
+// In two-party mode the server private key is x = x1·x2 with the shares held by two independent services,
+// both of which take part in every operation. The first service serves its share with ShareKey, the second one
+// layers its own share over it with TwoPartyKey and runs the server with NewServerWithKey:
+//
+//	x·P         = x2·(x1·P)
+//	commitment  = x2·(k1·B) + k2·B for nonces k1 and k2 of the two parties
+//	response    = x2·(k1 + c·x1) + k2 = (x2·k1 + k2) + c·x
+//
+// so clients get ordinary proofs. Neither service learns the other share: the first one only sees points,
+// the second one sees x1·P and Schnorr responses of the first one
+
+// SplitTwoParty splits a server keypair into two share keypairs such that the private key is the product of theirs.
+// The keypair has to be destroyed once the shares are handed out
+func SplitTwoParty(serverKeypair []byte) (firstShare, secondShare []byte, err error) {
+	s, err := NewServer(serverKeypair)
+	if err != nil {
+		return nil, nil, err
+	}
+	c := s.curve
+
+	// x1 is random, x2 = x / x1
+	x1 := randomFactor(c)
+	inv := c.sf.Inv(x1)
+	x2 := c.sf.Mul(inv, s.priv)
+	defer Wipe(x1)
+	defer Wipe(inv)
+	defer Wipe(x2)
+	defer Wipe(s.priv)
+
+	if firstShare, err = marshalShare(c, x1); err != nil {
+		return nil, nil, err
+	}
+	if secondShare, err = marshalShare(c, x2); err != nil {
+		return nil, nil, err
+	}
+	return firstShare, secondShare, nil
+}
+
+// ShareKey serves the share keypair of the first party to the second one.
+// Every commitment it makes answers at most one challenge
+func ShareKey(shareKeypair []byte) (PrivateKeyOps, error) {
+	kp, err := unmarshalKeypair(shareKeypair)
+	if err != nil {
+		return nil, err
+	}
+	return newShareKey(kp.PublicKey, kp.PrivateKey)
+}
+
+// TwoPartyKey is the second party's share layered over the first party's key operations. It implements PrivateKeyOps
+type TwoPartyKey struct {
+	curve    *Curve
+	second   *softwareKey
+	first    PrivateKeyOps
+	firstPub *Point
+	pub      *Point
+}
+
+// NewTwoPartyKey combines the second party's share keypair with the first party's key operations,
+// which are usually a client of a remote service
+func NewTwoPartyKey(secondShare []byte, first PrivateKeyOps) (*TwoPartyKey, error) {
+	if first == nil {
+		return nil, errors.New("invalid first party key")
+	}
+
+	second, err := ShareKey(secondShare)
+	if err != nil {
+		return nil, err
+	}
+	sk := second.(*shareKey)
+
+	firstPub, err := sk.key.curve.pointUnmarshal(first.PublicKey())
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid first party public key")
+	}
+
+	return &TwoPartyKey{
+		curve:    sk.key.curve,
+		second:   sk.key,
+		first:    first,
+		firstPub: firstPub,
+		pub:      firstPub.ScalarMult(sk.key.x),
+	}, nil
+}
+
+// PublicKey returns marshaled server public key x2·x1·G
+func (k *TwoPartyKey) PublicKey() []byte {
+	return k.pub.Marshal()
+}
+
+// ScalarMult returns x2·(x1·P)
+func (k *TwoPartyKey) ScalarMult(point []byte) ([]byte, error) {
+	res, err := k.first.ScalarMult(point)
+	if err != nil {
+		return nil, errors.Wrap(err, "first party")
+	}
+	p, err := k.curve.pointUnmarshal(res)
+	if err != nil {
+		return nil, errors.Wrap(err, "first party")
+	}
+	p, err = k.second.scalarMult(p)
+	if err != nil {
+		return nil, err
+	}
+	return p.Marshal(), nil
+}
+
+// Commit adds the second party's nonce to the first party's commitment
+func (k *TwoPartyKey) Commit(bases [][]byte) ([][]byte, func(challenge []byte) ([]byte, error), error) {
+	points := make([]*Point, len(bases))
+	gIndex := -1
+	for i, b := range bases {
+		p, err := k.curve.pointUnmarshal(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		if p.Equal(k.curve.g) {
+			p, gIndex = k.curve.g, i
+		}
+		points[i] = p
+	}
+
+	firstTerms, firstRespond, err := k.first.Commit(bases)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "first party")
+	}
+	if len(firstTerms) != len(bases) {
+		return nil, nil, errors.New("first party returned wrong number of terms")
+	}
+
+	own, respond, err := k.second.commit(points...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	commitments := make([]*Point, len(bases))
+	terms := make([][]byte, len(bases))
+	for i, t := range firstTerms {
+		if commitments[i], err = k.curve.pointUnmarshal(t); err != nil {
+			return nil, nil, errors.Wrap(err, "first party")
+		}
+		terms[i] = commitments[i].ScalarMult(k.second.x).Add(own[i]).Marshal()
+	}
+
+	return terms, func(challenge []byte) ([]byte, error) {
+		c, err := k.curve.parseScalar(challenge)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := firstRespond(challenge)
+		if err != nil {
+			return nil, errors.Wrap(err, "first party")
+		}
+		s1, err := k.curve.parseScalar(resp)
+		if err != nil {
+			return nil, errors.Wrap(err, "first party")
+		}
+		// s1·G = k1·G + c·X1
+		if gIndex >= 0 && !k.curve.scalarBaseMult(s1).Equal(commitments[gIndex].Add(k.firstPub.ScalarMultInt(c))) {
+			return nil, errors.New("first party returned invalid response")
+		}
+
+		// x2·s1 + k2 is the second party's own response to the challenge s1
+		return respond(k.curve.scalarBytes(s1))
+	}, nil
+}
+
+// RotateShare multiplies a share by a fresh random factor. After both parties have rotated their shares,
+// TwoPartyUpdateToken combines their factors into the update token for clients and records
+func RotateShare(shareKeypair []byte) (newShareKeypair, factor []byte, err error) {
+	kp, err := unmarshalKeypair(shareKeypair)
+	if err != nil {
+		return nil, nil, err
+	}
+	sk, err := newShareKey(kp.PublicKey, kp.PrivateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	c := sk.key.curve
+
+	a := randomFactor(c)
+	x := c.sf.Mul(sk.key.x, a)
+	defer Wipe(x)
+	defer Wipe(sk.key.x)
+
+	if newShareKeypair, err = marshalShare(c, x); err != nil {
+		return nil, nil, err
+	}
+	return newShareKeypair, a, nil
+}
+
+// TwoPartyUpdateToken makes the update token from rotation factors of both parties. The new private key is
+// a·x with a being the product of the factors, two-party rotation can't add a b term without either party knowing x
+func TwoPartyUpdateToken(serverPublicKey, firstFactor, secondFactor []byte) (*UpdateToken, error) {
+	pub, err := PointUnmarshal(serverPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	c := pub.curve
+
+	a1, err := c.parseScalar(firstFactor)
+	if err != nil || a1.Sign() == 0 {
+		return nil, errors.New("invalid rotation factor")
+	}
+	a2, err := c.parseScalar(secondFactor)
+	if err != nil || a2.Sign() == 0 {
+		return nil, errors.New("invalid rotation factor")
+	}
+
+	return &UpdateToken{
+		A: c.sf.Mul(c.scalarBytes(a1), c.scalarBytes(a2)),
+		B: make([]byte, c.scalarLen),
+	}, nil
+}
+
+// marshalShare makes the keypair of a share x of scalarLen bytes
+func marshalShare(c *Curve, x []byte) ([]byte, error) {
+	return marshalKeypair(c.scalarBaseMultBytes(x).Marshal(), x)
+}
+
+// randomFactor returns a random non-zero scalar of scalarLen bytes
+func randomFactor(c *Curve) []byte {
+	zero := make([]byte, c.scalarLen)
+	for {
+		k := c.randomScalar()
+		if subtle.ConstantTimeCompare(k, zero) == 0 {
+			return k
+		}
+	}
+}
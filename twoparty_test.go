@@ -0,0 +1,79 @@
+package phe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mustTwoPartyServer(t *testing.T, first, second []byte) *Server {
+	firstKey, err := ShareKey(first)
+	assert.NoError(t, err)
+	key, err := NewTwoPartyKey(second, firstKey)
+	assert.NoError(t, err)
+	server, err := NewServerWithKey(key)
+	assert.NoError(t, err)
+	return server
+}
+
+func TestTwoParty(t *testing.T) {
+	for _, curve := range curves {
+		serverKeypair, err := GenerateServerKeypairForCurve(curve)
+		assert.NoError(t, err)
+		pub := mustPublicKey(t, serverKeypair)
+
+		first, second, err := SplitTwoParty(serverKeypair)
+		assert.NoError(t, err)
+
+		server := mustTwoPartyServer(t, first, second)
+		assert.Equal(t, pub, server.PublicKey())
+		assert.NoError(t, server.Warmup())
+
+		c, err := NewClient(GenerateClientKeyForCurve(curve), pub)
+		assert.NoError(t, err)
+		enrollment, err := GetEnrollment(serverKeypair)
+		assert.NoError(t, err)
+		rec, key, err := c.EnrollAccount(pwd, enrollment)
+		assert.NoError(t, err)
+
+		//both shares rotate with a single token
+		newFirst, a1, err := RotateShare(first)
+		assert.NoError(t, err)
+		newSecond, a2, err := RotateShare(second)
+		assert.NoError(t, err)
+		token, err := TwoPartyUpdateToken(pub, a1, a2)
+		assert.NoError(t, err)
+
+		rotated := mustTwoPartyServer(t, newFirst, newSecond)
+		assert.NoError(t, c.RotateChecked(token, rotated.PublicKey()))
+		rec, err = UpdateRecord(rec, token)
+		assert.NoError(t, err)
+
+		req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+		assert.NoError(t, err)
+		resp, err := rotated.VerifyPassword(req)
+		assert.NoError(t, err)
+		newKey, err := c.CheckResponseAndDecrypt(pwd, rec, resp)
+		assert.NoError(t, err)
+		assert.Equal(t, key, newKey)
+	}
+}
+
+func TestTwoParty_Invalid(t *testing.T) {
+	first, second, err := SplitTwoParty(mustKeypair(t))
+	assert.NoError(t, err)
+
+	firstKey, err := ShareKey(first)
+	assert.NoError(t, err)
+	key, err := NewTwoPartyKey(second, cheatingHolder{firstKey})
+	assert.NoError(t, err)
+	server, err := NewServerWithKey(key)
+	assert.NoError(t, err)
+	_, err = server.GetEnrollment()
+	assert.Error(t, err)
+
+	_, err = NewTwoPartyKey(second, nil)
+	assert.Error(t, err)
+	_, err = TwoPartyUpdateToken(mustPublicKey(t, mustKeypair(t)), make([]byte, 32), make([]byte, 32))
+	assert.Error(t, err)
+}
@@ -0,0 +1,289 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"io"
+	"math/big"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+)
+
+// UOKMS is an updatable oblivious key management scheme. A data encryption key is derived from a wrap W = r·G
+// as KDF((ks + kc)·W), ks being the server key and kc the client key. Clients make wraps and keys on their own
+// and need the server to get ks·W back for a blinded W, so the server never sees wraps nor keys.
+// Rotation multiplies both keys by a and wraps by 1/a, which keeps every data encryption key.
+//
+// A UOKMS server answers ks·P for any point, so it must never share its keypair with a PHE server:
+// that would hand out hs0·x for any nonce and allow offline password guessing
+
+var (
+	uokmsProof = []byte("UOKMSProof")
+	uokmsKey   = []byte("UOKMSKey")
+)
+
+// UOKMSDecryptRequest carries a blinded wrap
+type UOKMSDecryptRequest struct {
+	Blinded []byte `json:"blinded"`
+}
+
+// UOKMSDecryptResponse carries the blinded wrap multiplied by the server key and a proof it was the server key
+type UOKMSDecryptResponse struct {
+	Result []byte `json:"result"`
+	Term1  []byte `json:"term1"`
+	Term2  []byte `json:"term2"`
+	Blind  []byte `json:"blind"`
+}
+
+// UOKMSServer holds the server side key of UOKMS
+type UOKMSServer struct {
+	s *Server
+}
+
+// NewUOKMSServer parses a server keypair made by GenerateServerKeypair for use with UOKMS only
+func NewUOKMSServer(serverKeypair []byte) (*UOKMSServer, error) {
+	s, err := NewServer(serverKeypair)
+	if err != nil {
+		return nil, err
+	}
+	return &UOKMSServer{s: s}, nil
+}
+
+// PublicKey returns marshaled server public key
+func (u *UOKMSServer) PublicKey() []byte {
+	return u.s.PublicKey()
+}
+
+// ProcessDecryptRequest multiplies the blinded wrap by the server key and proves it
+func (u *UOKMSServer) ProcessDecryptRequest(req *UOKMSDecryptRequest) (*UOKMSDecryptResponse, error) {
+	if req == nil {
+		return nil, errors.New("invalid decrypt request")
+	}
+	c := u.s.curve
+
+	blinded, err := c.pointUnmarshal(req.Blinded)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid decrypt request")
+	}
+
+	res, err := u.s.key.scalarMult(blinded)
+	if err != nil {
+		return nil, err
+	}
+
+	terms, respond, err := u.s.key.commit(blinded, c.g)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge := uokmsChallenge(c, u.s.kp.PublicKey, blinded, res, terms[0], terms[1])
+	blind, err := respond(c.scalarBytes(challenge))
+	if err != nil {
+		return nil, err
+	}
+
+	return &UOKMSDecryptResponse{
+		Result: res.Marshal(),
+		Term1:  terms[0].Marshal(),
+		Term2:  terms[1].Marshal(),
+		Blind:  blind,
+	}, nil
+}
+
+// RotateUOKMS multiplies the server key by a random factor and issues an update token for clients and wraps.
+// UOKMS tokens have no additive part, B is zero
+func RotateUOKMS(serverKeypair []byte) (token *UpdateToken, newServerKeypair []byte, err error) {
+	s, err := NewServer(serverKeypair)
+	if err != nil {
+		return nil, nil, err
+	}
+	c := s.curve
+
+	a := c.scalarBytes(nonZero(c, random))
+	newPrivate := c.sf.Mul(s.priv, a)
+	if newServerKeypair, err = marshalKeypair(c.scalarBaseMultBytes(newPrivate).Marshal(), newPrivate); err != nil {
+		return nil, nil, err
+	}
+	return &UpdateToken{A: a, B: make([]byte, c.scalarLen)}, newServerKeypair, nil
+}
+
+// UOKMSClient holds the client side key of UOKMS and the server public key
+type UOKMSClient struct {
+	curve     *Curve
+	priv      []byte
+	serverPub *Point
+}
+
+// NewUOKMSClient creates a client with a key made by GenerateClientKeyForCurve for the server key's curve
+func NewUOKMSClient(clientPrivateKey, serverPublicKey []byte) (*UOKMSClient, error) {
+	pub, err := PointUnmarshal(serverPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	c := pub.curve
+
+	k, err := c.parseScalar(clientPrivateKey)
+	if err != nil || k.Sign() == 0 {
+		return nil, errors.New("invalid client private key")
+	}
+	return &UOKMSClient{curve: c, priv: c.scalarBytes(k), serverPub: pub}, nil
+}
+
+// GenerateEncryptWrap makes a new data encryption key and the wrap to store next to the data. No server is involved
+func (u *UOKMSClient) GenerateEncryptWrap() (wrap, key []byte, err error) {
+	c := u.curve
+	r := c.scalarBytes(nonZero(c, random))
+
+	// (ks + kc)·W = r·(Ks + kc·G)
+	shared := u.serverPub.Add(c.scalarBaseMultBytes(u.priv)).ScalarMult(r)
+	return c.scalarBaseMultBytes(r).Marshal(), u.deriveKey(shared), nil
+}
+
+// CreateDecryptRequest blinds the wrap for the server. deblind has to be kept until the response arrives
+func (u *UOKMSClient) CreateDecryptRequest(wrap []byte) (req *UOKMSDecryptRequest, deblind []byte, err error) {
+	c := u.curve
+	w, err := c.pointUnmarshal(wrap)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid wrap")
+	}
+
+	b := c.scalarBytes(nonZero(c, random))
+	return &UOKMSDecryptRequest{Blinded: w.ScalarMult(b).Marshal()}, c.sf.Inv(b), nil
+}
+
+// ProcessDecryptResponse checks the server's proof, unblinds its result and returns the data encryption key
+func (u *UOKMSClient) ProcessDecryptResponse(wrap []byte, req *UOKMSDecryptRequest, resp *UOKMSDecryptResponse, deblind []byte) ([]byte, error) {
+	if req == nil || resp == nil {
+		return nil, errors.New("invalid decrypt response")
+	}
+	c := u.curve
+
+	w, err := c.pointUnmarshal(wrap)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid wrap")
+	}
+	blinded, err := c.pointUnmarshal(req.Blinded)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid decrypt request")
+	}
+	d, err := c.parseScalar(deblind)
+	if err != nil || d.Sign() == 0 {
+		return nil, errors.New("invalid deblind factor")
+	}
+
+	var points [3]*Point
+	for i, b := range [][]byte{resp.Result, resp.Term1, resp.Term2} {
+		if points[i], err = c.pointUnmarshal(b); err != nil {
+			return nil, errors.Wrap(err, "invalid decrypt response")
+		}
+	}
+	res, term1, term2 := points[0], points[1], points[2]
+	blind, err := c.parseScalar(resp.Blind)
+	if err != nil {
+		return nil, errors.New("invalid decrypt response")
+	}
+
+	// blind·B = term1 + challenge·result, blind·G = term2 + challenge·Ks
+	challenge := uokmsChallenge(c, u.serverPub.Marshal(), blinded, res, term1, term2)
+	if !blinded.ScalarMultInt(blind).Equal(term1.Add(res.ScalarMultInt(challenge))) ||
+		!c.scalarBaseMult(blind).Equal(term2.Add(u.serverPub.ScalarMultInt(challenge))) {
+		return nil, errors.New("invalid proof")
+	}
+
+	// ks·W = result / b, kc·W is local
+	shared := res.ScalarMultInt(d).Add(w.ScalarMult(u.priv))
+	return u.deriveKey(shared), nil
+}
+
+// Rotate updates the client key and the server public key with a UOKMS update token.
+// newServerPublicKey is the key the server has rotated to, nothing changes and *RotationError is returned
+// if the token doesn't lead to it
+func (u *UOKMSClient) Rotate(token *UpdateToken, newServerPublicKey []byte) error {
+	a, err := parseUOKMSToken(u.curve, token)
+	if err != nil {
+		return err
+	}
+
+	pub := u.serverPub.ScalarMultInt(a)
+	if err = checkRotation(u.serverPub.Marshal(), pub, newServerPublicKey); err != nil {
+		return err
+	}
+
+	u.priv = u.curve.sf.Mul(u.priv, u.curve.scalarBytes(a))
+	u.serverPub = pub
+	return nil
+}
+
+// UpdateWrap needs to be applied to every stored wrap after rotation, the data encryption key stays the same
+func UpdateWrap(wrap []byte, token *UpdateToken) ([]byte, error) {
+	w, err := PointUnmarshal(wrap)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid wrap")
+	}
+	c := w.curve
+
+	a, err := parseUOKMSToken(c, token)
+	if err != nil {
+		return nil, err
+	}
+	return w.ScalarMult(c.sf.Inv(c.scalarBytes(a))).Marshal(), nil
+}
+
+func (u *UOKMSClient) deriveKey(shared *Point) []byte {
+	key := make([]byte, u.curve.keyLen)
+	if _, err := io.ReadFull(hkdf.New(u.curve.hash, shared.Marshal(), nil, uokmsKey), key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// parseUOKMSToken returns the factor of a multiplicative update token
+func parseUOKMSToken(c *Curve, token *UpdateToken) (a *big.Int, err error) {
+	a, b, err := token.parse(c)
+	if err != nil {
+		return nil, err
+	}
+	if a.Sign() == 0 || b.Sign() != 0 {
+		return nil, errors.New("invalid uokms update token")
+	}
+	return a, nil
+}
+
+func uokmsChallenge(c *Curve, serverPublicKey []byte, blinded, res, term1, term2 *Point) *big.Int {
+	return c.hashZ(uokmsProof, serverPublicKey, c.gBytes, blinded.Marshal(), res.Marshal(), term1.Marshal(), term2.Marshal())
+}
@@ -0,0 +1,70 @@
+package phe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUOKMS(t *testing.T) {
+	for _, curve := range curves {
+		serverKeypair, err := GenerateServerKeypairForCurve(curve)
+		assert.NoError(t, err)
+		server, err := NewUOKMSServer(serverKeypair)
+		assert.NoError(t, err)
+		client, err := NewUOKMSClient(GenerateClientKeyForCurve(curve), server.PublicKey())
+		assert.NoError(t, err)
+
+		wrap, key, err := client.GenerateEncryptWrap()
+		assert.NoError(t, err)
+
+		decrypt := func(server *UOKMSServer, wrap []byte) []byte {
+			req, deblind, err := client.CreateDecryptRequest(wrap)
+			assert.NoError(t, err)
+			assert.NotEqual(t, wrap, req.Blinded)
+			resp, err := server.ProcessDecryptRequest(req)
+			assert.NoError(t, err)
+			key, err := client.ProcessDecryptResponse(wrap, req, resp, deblind)
+			assert.NoError(t, err)
+			return key
+		}
+		assert.Equal(t, key, decrypt(server, wrap))
+
+		//rotation keeps data encryption keys
+		token, newKeypair, err := RotateUOKMS(serverKeypair)
+		assert.NoError(t, err)
+		newServer, err := NewUOKMSServer(newKeypair)
+		assert.NoError(t, err)
+		assert.NoError(t, client.Rotate(token, newServer.PublicKey()))
+		newWrap, err := UpdateWrap(wrap, token)
+		assert.NoError(t, err)
+		assert.NotEqual(t, wrap, newWrap)
+		assert.Equal(t, key, decrypt(newServer, newWrap))
+
+		//another server key doesn't pass the proof
+		req, deblind, err := client.CreateDecryptRequest(newWrap)
+		assert.NoError(t, err)
+		resp, err := server.ProcessDecryptRequest(req)
+		assert.NoError(t, err)
+		_, err = client.ProcessDecryptResponse(newWrap, req, resp, deblind)
+		assert.Error(t, err)
+	}
+}
+
+func TestUOKMS_Tokens(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	server, err := NewUOKMSServer(serverKeypair)
+	assert.NoError(t, err)
+	client, err := NewUOKMSClient(GenerateClientKey(), server.PublicKey())
+	assert.NoError(t, err)
+
+	//PHE tokens have an additive part UOKMS can't apply
+	token, newKeypair, err := Rotate(serverKeypair)
+	assert.NoError(t, err)
+	assert.Error(t, client.Rotate(token, mustPublicKey(t, newKeypair)))
+
+	token, _, err = RotateUOKMS(serverKeypair)
+	assert.NoError(t, err)
+	var rotationErr *RotationError
+	assert.ErrorAs(t, client.Rotate(token, mustPublicKey(t, mustKeypair(t))), &rotationErr)
+}
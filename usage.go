@@ -0,0 +1,138 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// KeyUsage is a snapshot of how many operations a server keypair has performed.
+// It's meant to be persisted (e.g. as JSON) and restored with WithKeyUsage so counts survive restarts
+type KeyUsage struct {
+	Enrollments   uint64    `json:"enrollments"`
+	Verifications uint64    `json:"verifications"`
+	Since         time.Time `json:"since"`
+}
+
+// Total returns the number of private key operations
+func (u KeyUsage) Total() uint64 {
+	return u.Enrollments + u.Verifications
+}
+
+// RekeyPolicy defines when a server keypair should be rotated. Zero fields are not checked
+type RekeyPolicy struct {
+	MaxOperations uint64
+	MaxAge        time.Duration
+
+	// Advise, if set, is called once, from the request which first exceeded the policy
+	Advise func(*RekeyAdvisory)
+}
+
+// RekeyAdvisory tells operators the server keypair is due for rotation
+type RekeyAdvisory struct {
+	Reason string
+	Usage  KeyUsage
+}
+
+// usageCounter is shared by all requests of a Server, counters go first to stay 64-bit aligned
+type usageCounter struct {
+	enrollments   uint64
+	verifications uint64
+	advised       int32
+	since         time.Time
+	policy        *RekeyPolicy
+}
+
+// WithKeyUsage restores usage counters persisted from a Server with the same keypair
+func WithKeyUsage(u KeyUsage) ServerOption {
+	return func(s *Server) {
+		s.usage.enrollments = u.Enrollments
+		s.usage.verifications = u.Verifications
+		if !u.Since.IsZero() {
+			s.usage.since = u.Since
+		}
+	}
+}
+
+// WithRekeyPolicy sets thresholds after which the server advises to rotate its keypair
+func WithRekeyPolicy(p RekeyPolicy) ServerOption {
+	return func(s *Server) {
+		s.usage.policy = &p
+	}
+}
+
+// KeyUsage returns operation counters of the server keypair.
+// Only a long-lived Server counts operations, package level functions create a new one for every call
+func (s *Server) KeyUsage() KeyUsage {
+	return KeyUsage{
+		Enrollments:   atomic.LoadUint64(&s.usage.enrollments),
+		Verifications: atomic.LoadUint64(&s.usage.verifications),
+		Since:         s.usage.since,
+	}
+}
+
+// RekeyAdvisory returns an advisory if usage exceeds the rekey policy and nil otherwise
+func (s *Server) RekeyAdvisory() *RekeyAdvisory {
+	p := s.usage.policy
+	if p == nil {
+		return nil
+	}
+
+	u := s.KeyUsage()
+	switch {
+	case p.MaxOperations > 0 && u.Total() > p.MaxOperations:
+		return &RekeyAdvisory{Reason: "operation limit exceeded", Usage: u}
+	case p.MaxAge > 0 && time.Since(u.Since) > p.MaxAge:
+		return &RekeyAdvisory{Reason: "key age limit exceeded", Usage: u}
+	}
+	return nil
+}
+
+// count increments one of the usage counters and fires the advisory callback when policy is first exceeded
+func (s *Server) count(counter *uint64) {
+	atomic.AddUint64(counter, 1)
+
+	p := s.usage.policy
+	if p == nil || p.Advise == nil || atomic.LoadInt32(&s.usage.advised) != 0 {
+		return
+	}
+
+	if a := s.RekeyAdvisory(); a != nil && atomic.CompareAndSwapInt32(&s.usage.advised, 0, 1) {
+		p.Advise(a)
+	}
+}
@@ -0,0 +1,60 @@
+package phe
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_KeyUsage(t *testing.T) {
+	kp := mustKeypair(t)
+
+	var advisories []*RekeyAdvisory
+	s, err := NewServer(kp, WithRekeyPolicy(RekeyPolicy{
+		MaxOperations: 3,
+		Advise: func(a *RekeyAdvisory) {
+			advisories = append(advisories, a)
+		},
+	}))
+	assert.NoError(t, err)
+
+	c, err := NewClient(GenerateClientKey(), s.PublicKey())
+	assert.NoError(t, err)
+	enrollment, err := s.GetEnrollment()
+	assert.NoError(t, err)
+	rec, _, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+		assert.NoError(t, err)
+		_, err = s.VerifyPassword(req)
+		assert.NoError(t, err)
+	}
+	assert.Nil(t, s.RekeyAdvisory())
+	assert.Empty(t, advisories)
+
+	_, err = s.GetEnrollments(context.Background(), 2)
+	assert.NoError(t, err)
+
+	usage := s.KeyUsage()
+	assert.Equal(t, uint64(3), usage.Enrollments)
+	assert.Equal(t, uint64(2), usage.Verifications)
+	assert.NotNil(t, s.RekeyAdvisory())
+	assert.Len(t, advisories, 1)
+	assert.Equal(t, "operation limit exceeded", advisories[0].Reason)
+
+	//counters survive a restart
+	data, err := json.Marshal(usage)
+	assert.NoError(t, err)
+	var restored KeyUsage
+	assert.NoError(t, json.Unmarshal(data, &restored))
+
+	s, err = NewServer(kp, WithKeyUsage(restored), WithRekeyPolicy(RekeyPolicy{MaxAge: time.Nanosecond}))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5), s.KeyUsage().Total())
+	assert.Equal(t, "key age limit exceeded", s.RekeyAdvisory().Reason)
+}
@@ -37,95 +37,29 @@
 package phe
 
 import (
-	"crypto/elliptic"
-	"crypto/rand"
-	"encoding/asn1"
-	"io"
 	"math/big"
 
-	"github.com/passw0rd/phe-go/swu"
-
-	"github.com/pkg/errors"
+	"github.com/passw0rd/phe-go/internal/wire"
 )
 
-var (
-	curve  = elliptic.P256()
-	curveG = new(Point).ScalarBaseMultInt(new(big.Int).SetUint64(1))
-	gf     = swu.GF{P: curve.Params().N}
-
-	//domains
-	dhc0       = []byte("hc0")
-	dhc1       = []byte("hc1")
-	dhs0       = []byte("hs0")
-	dhs1       = []byte("hs1")
-	dm         = []byte("m")
-	proofOk    = []byte("ProofOk")
-	proofError = []byte("ProofError")
-)
-
-// randomZ generates big random 256 bit integer which must be less than curve's N parameter
+// randomZ generates big random integer less than P-256 curve's N parameter
 func randomZ() (z *big.Int) {
-	rz := makeZ(rand.Reader)
-	for z == nil {
-		// If the scalar is out of range, sample another random number.
-		if rz.Cmp(curve.Params().N) >= 0 {
-			rz = makeZ(rand.Reader)
-		} else {
-			z = rz
-		}
-	}
-	return
-}
-
-// hashZ maps arrays of bytes to an integer less than curve's N parameter
-func hashZ(domain []byte, data ...[]byte) (z *big.Int) {
-	xof := TupleKDF(data, domain)
-	rz := makeZ(xof)
-
-	for z == nil {
-		// If the scalar is out of range, extract another number.
-		if rz.Cmp(curve.Params().N) >= 0 {
-			rz = makeZ(xof)
-		} else {
-			z = rz
-		}
-	}
-	return
-}
-
-func makeZ(reader io.Reader) *big.Int {
-	buf := make([]byte, 32)
-	_, err := reader.Read(buf)
-	if err != nil {
-		panic(err)
-	}
-	return new(big.Int).SetBytes(buf)
-}
-
-// hashToPoint maps arrays of bytes to a valid curve point
-func hashToPoint(domain []byte, data ...[]byte) *Point {
-	hash := TupleHash(data, domain)
-	x, y := swu.HashToPoint(hash)
-	return &Point{x, y}
+	return p256.randomZ()
 }
 
 func marshalKeypair(publicKey, privateKey []byte) ([]byte, error) {
-	kp := keypair{
-		PublicKey:  publicKey,
-		PrivateKey: privateKey,
-	}
-
-	return asn1.Marshal(kp)
+	return wire.MarshalKeypair(publicKey, privateKey)
 }
 
 func unmarshalKeypair(serverKeypair []byte) (kp *keypair, err error) {
 
-	kp = &keypair{}
-	rest, err := asn1.Unmarshal(serverKeypair, kp)
-
-	if len(rest) != 0 || err != nil {
-		return nil, errors.New("invalid keypair")
+	w, err := wire.UnmarshalKeypair(serverKeypair)
+	if err != nil {
+		return nil, err
 	}
 
-	return
+	return &keypair{
+		PublicKey:  w.PublicKey,
+		PrivateKey: w.PrivateKey,
+	}, nil
 }
@@ -0,0 +1,100 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"github.com/passw0rd/phe-go/internal/wire"
+	"github.com/pkg/errors"
+)
+
+// Validate checks the record is structurally sound without any keys: nonces have valid lengths, both points
+// decode to points of one supported curve other than the point at infinity and the MAC, if any, has the right length.
+// It doesn't check the MAC itself, see WithRecordMAC
+func (c *EnrollmentRecord) Validate() error {
+	if c == nil {
+		return errors.New("invalid record: missing")
+	}
+	if err := wire.Nonce(c.NS); err != nil {
+		return errors.Wrap(err, "invalid record: ns")
+	}
+	if err := wire.Nonce(c.NC); err != nil {
+		return errors.Wrap(err, "invalid record: nc")
+	}
+
+	curve, err := curveByPoint(c.T0)
+	if err != nil {
+		return errors.Wrap(err, "invalid record: t0")
+	}
+	if _, err = curve.pointUnmarshal(c.T1); err != nil {
+		return errors.Wrapf(err, "invalid record: t1 is not a %s point", curve.name)
+	}
+
+	if c.MAC != nil && len(c.MAC) != RecordMACSize {
+		return errors.New("invalid record: mac")
+	}
+	return nil
+}
+
+// Validate checks the response is structurally sound: the nonce has a valid length, both points and the points
+// and scalar of the proof decode on one supported curve. It doesn't verify the proof, Client does
+func (r *EnrollmentResponse) Validate() error {
+	if r == nil {
+		return errors.New("invalid enrollment response: missing")
+	}
+	if err := wire.Nonce(r.NS); err != nil {
+		return errors.Wrap(err, "invalid enrollment response: ns")
+	}
+
+	curve, err := curveByPoint(r.C0)
+	if err != nil {
+		return errors.Wrap(err, "invalid enrollment response: c0")
+	}
+	if _, err = curve.pointUnmarshal(r.C1); err != nil {
+		return errors.Wrapf(err, "invalid enrollment response: c1 is not a %s point", curve.name)
+	}
+
+	if r.NoProof {
+		if r.Proof != nil {
+			return errors.New("invalid enrollment response: proof is set together with no_proof")
+		}
+		return nil
+	}
+	if _, _, _, _, err = r.Proof.parse(curve); err != nil {
+		return errors.Wrap(err, "invalid enrollment response: proof")
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package phe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnrollmentRecord_Validate(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	c, err := NewClient(GenerateClientKey(), mustPublicKey(t, serverKeypair))
+	assert.NoError(t, err)
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	assert.NoError(t, enrollment.Validate())
+	rec, _, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+	assert.NoError(t, rec.Validate())
+
+	p384Point := P384().g.Marshal()
+	offCurve := append([]byte{}, rec.T1...)
+	offCurve[len(offCurve)-1] ^= 1
+
+	for name, mutate := range map[string]func(r *EnrollmentRecord){
+		"empty ns":     func(r *EnrollmentRecord) { r.NS = nil },
+		"long nc":      func(r *EnrollmentRecord) { r.NC = make([]byte, 33) },
+		"truncated t0": func(r *EnrollmentRecord) { r.T0 = r.T0[:len(r.T0)-1] },
+		"infinity t1":  func(r *EnrollmentRecord) { r.T1 = []byte{0} },
+		"off-curve t1": func(r *EnrollmentRecord) { r.T1 = offCurve },
+		"mixed curves": func(r *EnrollmentRecord) { r.T1 = p384Point },
+		"short mac":    func(r *EnrollmentRecord) { r.MAC = make([]byte, 16) },
+	} {
+		bad := *rec
+		mutate(&bad)
+		assert.Error(t, bad.Validate(), name)
+	}
+	assert.Error(t, (*EnrollmentRecord)(nil).Validate())
+
+	for name, mutate := range map[string]func(r *EnrollmentResponse){
+		"empty ns":      func(r *EnrollmentResponse) { r.NS = nil },
+		"off-curve c1":  func(r *EnrollmentResponse) { r.C1 = offCurve },
+		"missing proof": func(r *EnrollmentResponse) { r.Proof = nil },
+		"bad proof": func(r *EnrollmentResponse) {
+			r.Proof = &ProofOfSuccess{Term1: r.C0, Term2: r.C1, Term3: p384Point, BlindX: []byte{1}}
+		},
+		"both proofs": func(r *EnrollmentResponse) { r.NoProof = true },
+	} {
+		bad := *enrollment
+		mutate(&bad)
+		assert.Error(t, bad.Validate(), name)
+	}
+
+	noProof := *enrollment
+	noProof.Proof, noProof.NoProof = nil, true
+	assert.NoError(t, noProof.Validate())
+}
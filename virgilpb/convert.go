@@ -0,0 +1,221 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+// Package virgilpb contains protocol buffer messages of the Virgil PHE services and SDKs, generated from phe.proto,
+// and conversions to and from the structs of package phe so records, responses and tokens can be exchanged with them
+package virgilpb
+
+//go:generate protoc -I.. --go_out=.. --go_opt=paths=source_relative ../virgilpb/phe.proto
+
+import (
+	"github.com/passw0rd/phe-go"
+	"github.com/passw0rd/phe-go/internal/wire"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+// FromKeypair converts a server keypair to the Virgil keypair message
+func FromKeypair(serverKeypair []byte) (*Keypair, error) {
+	kp, err := wire.UnmarshalKeypair(serverKeypair)
+	if err != nil {
+		return nil, err
+	}
+	return &Keypair{PublicKey: kp.PublicKey, PrivateKey: kp.PrivateKey}, nil
+}
+
+// ToKeypair converts a Virgil keypair message to a server keypair and checks it can be used
+func ToKeypair(m *Keypair) ([]byte, error) {
+	if m == nil {
+		return nil, errors.New("invalid keypair")
+	}
+	kp, err := wire.MarshalKeypair(m.GetPublicKey(), m.GetPrivateKey())
+	if err != nil {
+		return nil, err
+	}
+	if _, err = phe.NewServer(kp); err != nil {
+		return nil, err
+	}
+	return kp, nil
+}
+
+// FromEnrollmentRecord converts a record to its message
+func FromEnrollmentRecord(rec *phe.EnrollmentRecord) *EnrollmentRecord {
+	if rec == nil {
+		return nil
+	}
+	return &EnrollmentRecord{Ns: rec.NS, Nc: rec.NC, T0: rec.T0, T1: rec.T1}
+}
+
+// ToEnrollmentRecord converts a message to a record
+func ToEnrollmentRecord(m *EnrollmentRecord) *phe.EnrollmentRecord {
+	if m == nil {
+		return nil
+	}
+	return &phe.EnrollmentRecord{NS: m.GetNs(), NC: m.GetNc(), T0: m.GetT0(), T1: m.GetT1()}
+}
+
+// MarshalEnrollmentRecord serializes a record the way Virgil SDKs store it
+func MarshalEnrollmentRecord(rec *phe.EnrollmentRecord) ([]byte, error) {
+	if rec == nil {
+		return nil, errors.New("invalid record")
+	}
+	return proto.Marshal(FromEnrollmentRecord(rec))
+}
+
+// UnmarshalEnrollmentRecord parses a record stored by Virgil SDKs
+func UnmarshalEnrollmentRecord(data []byte) (*phe.EnrollmentRecord, error) {
+	m := &EnrollmentRecord{}
+	if err := proto.Unmarshal(data, m); err != nil {
+		return nil, errors.Wrap(err, "invalid record")
+	}
+	return ToEnrollmentRecord(m), nil
+}
+
+// FromProofOfSuccess converts a proof to its message
+func FromProofOfSuccess(p *phe.ProofOfSuccess) *ProofOfSuccess {
+	if p == nil {
+		return nil
+	}
+	return &ProofOfSuccess{Term1: p.Term1, Term2: p.Term2, Term3: p.Term3, BlindX: p.BlindX}
+}
+
+// ToProofOfSuccess converts a message to a proof
+func ToProofOfSuccess(m *ProofOfSuccess) *phe.ProofOfSuccess {
+	if m == nil {
+		return nil
+	}
+	return &phe.ProofOfSuccess{Term1: m.GetTerm1(), Term2: m.GetTerm2(), Term3: m.GetTerm3(), BlindX: m.GetBlindX()}
+}
+
+// FromProofOfFail converts a proof to its message
+func FromProofOfFail(p *phe.ProofOfFail) *ProofOfFail {
+	if p == nil {
+		return nil
+	}
+	return &ProofOfFail{Term1: p.Term1, Term2: p.Term2, Term3: p.Term3, Term4: p.Term4, BlindA: p.BlindA, BlindB: p.BlindB}
+}
+
+// ToProofOfFail converts a message to a proof
+func ToProofOfFail(m *ProofOfFail) *phe.ProofOfFail {
+	if m == nil {
+		return nil
+	}
+	return &phe.ProofOfFail{
+		Term1:  m.GetTerm1(),
+		Term2:  m.GetTerm2(),
+		Term3:  m.GetTerm3(),
+		Term4:  m.GetTerm4(),
+		BlindA: m.GetBlindA(),
+		BlindB: m.GetBlindB(),
+	}
+}
+
+// FromEnrollmentResponse converts an enrollment response to its message
+func FromEnrollmentResponse(resp *phe.EnrollmentResponse) *EnrollmentResponse {
+	if resp == nil {
+		return nil
+	}
+	return &EnrollmentResponse{Ns: resp.NS, C0: resp.C0, C1: resp.C1, Proof: FromProofOfSuccess(resp.Proof)}
+}
+
+// ToEnrollmentResponse converts a message to an enrollment response
+func ToEnrollmentResponse(m *EnrollmentResponse) *phe.EnrollmentResponse {
+	if m == nil {
+		return nil
+	}
+	return &phe.EnrollmentResponse{NS: m.GetNs(), C0: m.GetC0(), C1: m.GetC1(), Proof: ToProofOfSuccess(m.GetProof())}
+}
+
+// FromVerifyPasswordRequest converts a request to its message
+func FromVerifyPasswordRequest(req *phe.VerifyPasswordRequest) *VerifyPasswordRequest {
+	if req == nil {
+		return nil
+	}
+	return &VerifyPasswordRequest{Ns: req.NS, C0: req.C0}
+}
+
+// ToVerifyPasswordRequest converts a message to a request
+func ToVerifyPasswordRequest(m *VerifyPasswordRequest) *phe.VerifyPasswordRequest {
+	if m == nil {
+		return nil
+	}
+	return &phe.VerifyPasswordRequest{NS: m.GetNs(), C0: m.GetC0()}
+}
+
+// FromVerifyPasswordResponse converts a response to its message. The schema has room for one proof only,
+// the one matching Res is kept. Expired isn't part of the schema and is lost
+func FromVerifyPasswordResponse(resp *phe.VerifyPasswordResponse) *VerifyPasswordResponse {
+	if resp == nil {
+		return nil
+	}
+
+	m := &VerifyPasswordResponse{Res: resp.Res, C1: resp.C1}
+	if resp.Res && resp.ProofSuccess != nil {
+		m.Proof = &VerifyPasswordResponse_Success{Success: FromProofOfSuccess(resp.ProofSuccess)}
+	} else if !resp.Res && resp.ProofFail != nil {
+		m.Proof = &VerifyPasswordResponse_Fail{Fail: FromProofOfFail(resp.ProofFail)}
+	}
+	return m
+}
+
+// ToVerifyPasswordResponse converts a message to a response
+func ToVerifyPasswordResponse(m *VerifyPasswordResponse) *phe.VerifyPasswordResponse {
+	if m == nil {
+		return nil
+	}
+	return &phe.VerifyPasswordResponse{
+		Res:          m.GetRes(),
+		C1:           m.GetC1(),
+		ProofSuccess: ToProofOfSuccess(m.GetSuccess()),
+		ProofFail:    ToProofOfFail(m.GetFail()),
+	}
+}
+
+// FromUpdateToken converts an update token to its message. The message has no room for token metadata, it's dropped
+func FromUpdateToken(token *phe.UpdateToken) *UpdateToken {
+	if token == nil {
+		return nil
+	}
+	return &UpdateToken{A: token.A, B: token.B}
+}
+
+// ToUpdateToken converts a message to an update token
+func ToUpdateToken(m *UpdateToken) *phe.UpdateToken {
+	if m == nil {
+		return nil
+	}
+	return &phe.UpdateToken{A: m.GetA(), B: m.GetB()}
+}
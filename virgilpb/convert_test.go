@@ -0,0 +1,80 @@
+package virgilpb
+
+import (
+	"testing"
+
+	"github.com/passw0rd/phe-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestConvert(t *testing.T) {
+	kp, err := phe.GenerateServerKeypair()
+	assert.NoError(t, err)
+
+	m, err := FromKeypair(kp)
+	assert.NoError(t, err)
+	data, err := proto.Marshal(m)
+	assert.NoError(t, err)
+	m2 := &Keypair{}
+	assert.NoError(t, proto.Unmarshal(data, m2))
+	kp2, err := ToKeypair(m2)
+	assert.NoError(t, err)
+	assert.Equal(t, kp, kp2)
+
+	s, err := phe.NewServer(kp)
+	assert.NoError(t, err)
+	c, err := phe.NewClient(phe.GenerateClientKey(), s.PublicKey())
+	assert.NoError(t, err)
+
+	// everything goes through the wire format on its way between client and server
+	enrollment, err := s.GetEnrollment()
+	assert.NoError(t, err)
+	enrollment = roundTrip(t, FromEnrollmentResponse(enrollment), ToEnrollmentResponse)
+
+	rec, key, err := c.EnrollAccount([]byte("password"), enrollment)
+	assert.NoError(t, err)
+	data, err = MarshalEnrollmentRecord(rec)
+	assert.NoError(t, err)
+	rec2, err := UnmarshalEnrollmentRecord(data)
+	assert.NoError(t, err)
+	assert.Equal(t, rec, rec2)
+
+	for _, pwd := range []string{"password", "wrong"} {
+		req, err := c.CreateVerifyPasswordRequest([]byte(pwd), rec2)
+		assert.NoError(t, err)
+		req = roundTrip(t, FromVerifyPasswordRequest(req), ToVerifyPasswordRequest)
+
+		resp, err := s.VerifyPassword(req)
+		assert.NoError(t, err)
+		resp = roundTrip(t, FromVerifyPasswordResponse(resp), ToVerifyPasswordResponse)
+
+		got, err := c.CheckResponseAndDecrypt([]byte(pwd), rec2, resp)
+		assert.NoError(t, err)
+		if pwd == "password" {
+			assert.Equal(t, key, got)
+		} else {
+			assert.Nil(t, got)
+		}
+	}
+
+	token, _, err := phe.Rotate(kp)
+	assert.NoError(t, err)
+	// Virgil's message carries the scalars only
+	assert.Equal(t, &phe.UpdateToken{A: token.A, B: token.B}, roundTrip(t, FromUpdateToken(token), ToUpdateToken))
+
+	assert.Nil(t, FromEnrollmentRecord(nil))
+	assert.Nil(t, ToVerifyPasswordResponse(nil))
+	_, err = UnmarshalEnrollmentRecord([]byte{0xff})
+	assert.Error(t, err)
+	_, err = ToKeypair(&Keypair{PublicKey: m.PublicKey})
+	assert.Error(t, err)
+}
+
+func roundTrip[M proto.Message, T any](t *testing.T, m M, to func(M) T) T {
+	data, err := proto.Marshal(m)
+	assert.NoError(t, err)
+	out := m.ProtoReflect().New().Interface().(M)
+	assert.NoError(t, proto.Unmarshal(data, out))
+	return to(out)
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"math/big"
+)
+
+// Wipe overwrites b with zeroes. Use it on account keys, server keypairs and other secrets once they are no longer needed.
+// Go may have copied the bytes elsewhere, e.g. when a slice grew, so this is best-effort
+func Wipe(b []byte) {
+	clear(b)
+}
+
+// Wipe zeroes client's private key or destroys its enclave. The client must not be used afterwards
+func (c *Client) Wipe() {
+	Wipe(c.clientPrivateKey)
+	Wipe(c.clientPrivateKeyBytes)
+	Wipe(c.macKey)
+	if c.enclave != nil {
+		c.enclave.destroy()
+	}
+}
+
+// Wipe zeroes server's private key if it's held in memory. Delegated keys are left to their PrivateKeyOps.
+// The server must not be used afterwards
+func (s *Server) Wipe() {
+	Wipe(s.priv)
+	if s.kp != nil {
+		Wipe(s.kp.PrivateKey)
+	}
+	if k, ok := s.key.(*softwareKey); ok {
+		Wipe(k.x)
+	}
+}
+
+// Wipe zeroes the subkeys the cipher derived. Expanded key schedules inside crypto/cipher can't be reached,
+// drop the cipher afterwards so they are collected. The cipher must not be used afterwards
+func (c *PheCipher) Wipe() {
+	for _, k := range c.keys {
+		Wipe(k)
+	}
+}
+
+// wipeInt zeroes the limbs of a big integer
+func wipeInt(z *big.Int) {
+	if z != nil {
+		clear(z.Bits())
+		z.SetInt64(0)
+	}
+}
+
+// wipe zeroes coordinates of a secret point such as account's m
+func (p *Point) wipe() {
+	if p != nil {
+		wipeInt(p.X)
+		wipeInt(p.Y)
+	}
+}
+
+// wipeHashed wipes the result of hashPassword if it was produced by a hasher and so is not caller's password
+func (c *Client) wipeHashed(hashed []byte) {
+	if c.hasher != nil {
+		Wipe(hashed)
+	}
+}
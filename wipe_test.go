@@ -0,0 +1,95 @@
+package phe
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWipe(t *testing.T) {
+	b := []byte{1, 2, 3}
+	Wipe(b)
+	assert.Equal(t, []byte{0, 0, 0}, b)
+	Wipe(nil)
+
+	z := new(big.Int).SetBytes([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9})
+	limbs := z.Bits()
+	wipeInt(z)
+	assert.Equal(t, 0, z.Sign())
+	for _, l := range limbs {
+		assert.Zero(t, l)
+	}
+	wipeInt(nil)
+}
+
+func TestClient_Wipe(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	clientKey := GenerateClientKey()
+	c, err := NewClient(clientKey, mustPublicKey(t, serverKeypair))
+	assert.NoError(t, err)
+
+	c.Wipe()
+	assert.Equal(t, make([]byte, len(c.clientPrivateKey)), c.clientPrivateKey)
+	assert.Equal(t, make([]byte, len(c.clientPrivateKeyBytes)), c.clientPrivateKeyBytes)
+	// caller's copy is left alone
+	assert.NotEqual(t, make([]byte, len(clientKey)), clientKey)
+}
+
+func TestServer_Wipe(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	s, err := NewServer(serverKeypair)
+	assert.NoError(t, err)
+
+	s.Wipe()
+	assert.Equal(t, make([]byte, len(s.priv)), s.priv)
+	assert.Equal(t, make([]byte, len(s.kp.PrivateKey)), s.kp.PrivateKey)
+	assert.Equal(t, make([]byte, len(s.priv)), s.key.(*softwareKey).x)
+
+	// the serialized keypair is caller's to wipe
+	_, err = NewServer(serverKeypair)
+	assert.NoError(t, err)
+	Wipe(serverKeypair)
+	_, err = NewServer(serverKeypair)
+	assert.Error(t, err)
+}
+
+func TestPheCipher_Wipe(t *testing.T) {
+	key := make([]byte, 32)
+	random.MustRead(key)
+	c, err := NewPheCipher(key)
+	assert.NoError(t, err)
+
+	c.Wipe()
+	for _, k := range c.keys {
+		assert.Equal(t, make([]byte, 32), k)
+	}
+}
+
+func TestWipe_Intermediates(t *testing.T) {
+	// zeroing temporaries must not change results
+	serverKeypair := mustKeypair(t)
+	c, err := NewClient(GenerateClientKey(), mustPublicKey(t, serverKeypair), WithPasswordHasher(Scrypt{N: 1024}))
+	assert.NoError(t, err)
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	password := []byte("password")
+	rec, key, err := c.EnrollAccount(password, enrollment)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("password"), password)
+
+	for i := 0; i < 2; i++ {
+		req, err := c.CreateVerifyPasswordRequest(password, rec)
+		assert.NoError(t, err)
+		resp, err := VerifyPassword(serverKeypair, req)
+		assert.NoError(t, err)
+		decrypted, err := c.CheckResponseAndDecrypt(password, rec, resp)
+		assert.NoError(t, err)
+		assert.Equal(t, key, decrypted)
+	}
+
+	m := c.randomM()
+	m.wipe()
+	assert.Equal(t, 0, m.X.Sign())
+	assert.Equal(t, 0, m.Y.Sign())
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright (C) 2015-2018 Virgil Security Inc.
+ *
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *     (1) Redistributions of source code must retain the above copyright
+ *     notice, this list of conditions and the following disclaimer.
+ *
+ *     (2) Redistributions in binary form must reproduce the above copyright
+ *     notice, this list of conditions and the following disclaimer in
+ *     the documentation and/or other materials provided with the
+ *     distribution.
+ *
+ *     (3) Neither the name of the copyright holder nor the names of its
+ *     contributors may be used to endorse or promote products derived from
+ *     this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR ''AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ *
+ * Lead Maintainer: Virgil Security Inc. <support@virgilsecurity.com>
+ */
+
+package phe
+
+import (
+	"crypto/cipher"
+
+	"github.com/passw0rd/phe-go/internal/gcmsiv"
+	"github.com/pkg/errors"
+)
+
+const (
+	// WrapAES256GCMSIV identifies data keys wrapped with AES-256-GCM-SIV
+	WrapAES256GCMSIV byte = 1
+
+	// DataKeySize is the length of keys generated by GenerateDataKey
+	DataKeySize = 32
+
+	wrapHeaderLen  = 1 + gcmsiv.NonceSize
+	minDataKeySize = 16
+	maxDataKeySize = 64
+)
+
+var wrapInfo = []byte("WrapKey")
+
+// ErrUnwrapFailed is returned by UnwrapKey if the account key or the key ID is wrong or the wrapped key has been modified
+var ErrUnwrapFailed = errors.New("could not unwrap key")
+
+// GenerateDataKey creates a random key for encrypting a single object, e.g. with NewPheCipher
+func GenerateDataKey() []byte {
+	key := make([]byte, DataKeySize)
+	random.MustRead(key)
+	return key
+}
+
+// WrapKey encrypts a data key of 16 to 64 bytes with the account key returned by EnrollAccount or CheckResponseAndDecrypt.
+// Bulk data is encrypted with data keys and only their small wrapped forms depend on the account key, so
+// a password change or RotateAccountKey requires re-wrapping them only, see RewrapKey.
+// keyID, e.g. the object's ID, is authenticated so a wrapped key can't be moved to another object
+func WrapKey(accountKey, dataKey, keyID []byte) ([]byte, error) {
+	if len(dataKey) < minDataKeySize || len(dataKey) > maxDataKeySize {
+		return nil, errors.New("invalid data key")
+	}
+
+	aead, err := newWrapAEAD(accountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, wrapHeaderLen, wrapHeaderLen+len(dataKey)+aead.Overhead())
+	out[0] = WrapAES256GCMSIV
+	random.MustRead(out[1:wrapHeaderLen])
+
+	return aead.Seal(out, out[1:wrapHeaderLen], dataKey, pheCipherAD(out[0], keyID)), nil
+}
+
+// UnwrapKey decrypts a data key wrapped by WrapKey with the same account key and key ID
+func UnwrapKey(accountKey, wrapped, keyID []byte) ([]byte, error) {
+	if len(wrapped) < wrapHeaderLen+minDataKeySize+gcmsiv.TagSize || len(wrapped) > wrapHeaderLen+maxDataKeySize+gcmsiv.TagSize {
+		return nil, errors.New("invalid wrapped key")
+	}
+
+	if wrapped[0] != WrapAES256GCMSIV {
+		return nil, errors.New("unsupported key wrapping algorithm")
+	}
+
+	aead, err := newWrapAEAD(accountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := aead.Open(nil, wrapped[1:wrapHeaderLen], wrapped[wrapHeaderLen:], pheCipherAD(wrapped[0], keyID))
+	if err != nil {
+		return nil, ErrUnwrapFailed
+	}
+	return dataKey, nil
+}
+
+// RewrapKey moves a wrapped data key from the old account key to the new one, e.g. the keys returned by RotateAccountKey
+func RewrapKey(oldAccountKey, newAccountKey, wrapped, keyID []byte) ([]byte, error) {
+	dataKey, err := UnwrapKey(oldAccountKey, wrapped, keyID)
+	if err != nil {
+		return nil, err
+	}
+	return WrapKey(newAccountKey, dataKey, keyID)
+}
+
+// newWrapAEAD derives the key wrapping subkey, it's never used for anything but data keys
+func newWrapAEAD(accountKey []byte) (cipher.AEAD, error) {
+	subKey, err := deriveSubKey(accountKey, wrapInfo)
+	if err != nil {
+		return nil, err
+	}
+	return gcmsiv.New(subKey)
+}
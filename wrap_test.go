@@ -0,0 +1,97 @@
+package phe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrapKey(t *testing.T) {
+	accountKey := make([]byte, 32)
+	random.MustRead(accountKey)
+	dataKey := GenerateDataKey()
+	assert.Len(t, dataKey, DataKeySize)
+
+	wrapped, err := WrapKey(accountKey, dataKey, []byte("object-1"))
+	assert.NoError(t, err)
+	assert.Equal(t, WrapAES256GCMSIV, wrapped[0])
+	assert.Len(t, wrapped, wrapHeaderLen+DataKeySize+16)
+
+	unwrapped, err := UnwrapKey(accountKey, wrapped, []byte("object-1"))
+	assert.NoError(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+
+	_, err = UnwrapKey(accountKey, wrapped, []byte("object-2"))
+	assert.Equal(t, ErrUnwrapFailed, err)
+
+	otherKey := make([]byte, 32)
+	random.MustRead(otherKey)
+	_, err = UnwrapKey(otherKey, wrapped, []byte("object-1"))
+	assert.Equal(t, ErrUnwrapFailed, err)
+
+	tampered := append([]byte{}, wrapped...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = UnwrapKey(accountKey, tampered, []byte("object-1"))
+	assert.Equal(t, ErrUnwrapFailed, err)
+
+	tampered[0] = 2
+	_, err = UnwrapKey(accountKey, tampered, []byte("object-1"))
+	assert.Error(t, err)
+
+	_, err = UnwrapKey(accountKey, wrapped[:len(wrapped)-17], []byte("object-1"))
+	assert.Error(t, err)
+
+	_, err = WrapKey(accountKey, dataKey[:15], nil)
+	assert.Error(t, err)
+	_, err = WrapKey(accountKey, make([]byte, 65), nil)
+	assert.Error(t, err)
+	_, err = WrapKey(accountKey[:16], dataKey, nil)
+	assert.Error(t, err)
+}
+
+func TestRewrapKey(t *testing.T) {
+	serverKeypair := mustKeypair(t)
+	c, err := NewClient(GenerateClientKey(), mustPublicKey(t, serverKeypair))
+	assert.NoError(t, err)
+	enrollment, err := GetEnrollment(serverKeypair)
+	assert.NoError(t, err)
+	rec, accountKey, err := c.EnrollAccount(pwd, enrollment)
+	assert.NoError(t, err)
+
+	dataKey := GenerateDataKey()
+	enc, err := NewPheCipher(dataKey)
+	assert.NoError(t, err)
+	ct, err := enc.Encrypt([]byte("bulk data"), nil)
+	assert.NoError(t, err)
+	wrapped, err := WrapKey(accountKey, dataKey, []byte("file"))
+	assert.NoError(t, err)
+
+	req, err := c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	resp, err := VerifyPassword(serverKeypair, req)
+	assert.NoError(t, err)
+	rec, oldKey, newKey, err := c.RotateAccountKey(pwd, rec, resp)
+	assert.NoError(t, err)
+
+	// only the wrapped key changes, bulk data stays as it was
+	wrapped, err = RewrapKey(oldKey, newKey, wrapped, []byte("file"))
+	assert.NoError(t, err)
+
+	req, err = c.CreateVerifyPasswordRequest(pwd, rec)
+	assert.NoError(t, err)
+	resp, err = VerifyPassword(serverKeypair, req)
+	assert.NoError(t, err)
+	accountKey, err = c.CheckResponseAndDecrypt(pwd, rec, resp)
+	assert.NoError(t, err)
+
+	dataKey, err = UnwrapKey(accountKey, wrapped, []byte("file"))
+	assert.NoError(t, err)
+	dec, err := NewPheCipher(dataKey)
+	assert.NoError(t, err)
+	pt, err := dec.Decrypt(ct, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("bulk data"), pt)
+
+	_, err = RewrapKey(oldKey, newKey, wrapped, []byte("file"))
+	assert.Equal(t, ErrUnwrapFailed, err)
+}